@@ -15,12 +15,24 @@ var (
 	// AnoPEHeaderOverlapDOSHeader is reported when the PE headers overlaps with the DOS header.
 	AnoPEHeaderOverlapDOSHeader = "PE header overlaps with DOS header"
 
+	// AnoPEHeaderBeyondSizeOfHeaders is reported when e_lfanew points past
+	// SizeOfHeaders, the NT headers are not mapped with the headers.
+	AnoPEHeaderBeyondSizeOfHeaders = "PE header is located beyond SizeOfHeaders"
+
+	// AnoPEHeaderOverlapSection is reported when the NT headers or the
+	// section table share their bytes with the raw data of a section.
+	AnoPEHeaderOverlapSection = "PE header overlaps with section data"
+
+	// AnoPEHeaderInOverlay is reported when the NT headers are appended after
+	// the raw data of the last section.
+	AnoPEHeaderInOverlay = "PE header is located after the last section"
+
 	// AnoPETimeStampNull is reported when the file header timestamp is 0.
 	AnoPETimeStampNull = "file header timestamp set to 0"
 
 	// AnoPETimeStampFuture is reported when the file header timestamp is more
 	// than one day ahead of the current date timestamp.
-	AnoPETimeStampFuture = "file header timestamp set to 0"
+	AnoPETimeStampFuture = "file header timestamp set to the future"
 
 	// NumberOfSections is reported when number of sections is larger or equal than 10.
 	AnoNumberOfSections10Plus = "number of sections is 10+"
@@ -47,6 +59,18 @@ var (
 	AnoAddressOfEPLessSizeOfHeaders = "address of entry point is smaller than size of headers, " +
 		"the file cannot run under Windows 8"
 
+	// AnoAddressOfEPNotInCode is reported when address of entry
+	// point is within a section which is not executable.
+	AnoAddressOfEPNotInCode = "address of entry point is in a non executable section"
+
+	// AnoAddressOfEPOutsideSections is reported when address of
+	// entry point is past the headers but not within a section.
+	AnoAddressOfEPOutsideSections = "address of entry point is outside of the sections"
+
+	// AnoAddressOfEPBeyondImage is reported when address of entry
+	// point is at or past SizeOfImage.
+	AnoAddressOfEPBeyondImage = "address of entry point is beyond SizeOfImage"
+
 	// AnoImageBaseNull is reported when the image base is null.
 	AnoImageBaseNull = "image base is 0"
 
@@ -75,6 +99,15 @@ var (
 	// AnoNumberOfRvaAndSizes is reported when NumberOfRvaAndSizes is different than 16.
 	AnoNumberOfRvaAndSizes = "optional header NumberOfRvaAndSizes != 16"
 
+	// AnoSizeOfOptionalHeaderTruncated is reported when SizeOfOptionalHeader
+	// does not cover all the data directories, the section table overlaps
+	// the optional header.
+	AnoSizeOfOptionalHeaderTruncated = "size of optional header is smaller than the optional header"
+
+	// AnoOptionalHeaderBeyondFile is reported when the optional header runs
+	// past the end of the file.
+	AnoOptionalHeaderBeyondFile = "optional header extends beyond the end of the file"
+
 	// AnoReservedDataDirectoryEntry is reported when the last data directory entry is not zero.
 	AnoReservedDataDirectoryEntry = "last data directory entry is a reserved field, must be set to zero"
 
@@ -83,13 +116,125 @@ var (
 
 	// AnoRelocationEntriesCount is reported when the number of relocation entries is absurdly high.
 	AnoRelocationEntriesCount = "relocation entries count is absurdly high"
+
+	// AnoSectionsNotSortedByVA is reported when the section table entries
+	// are not sorted in ascending order of VirtualAddress.
+	AnoSectionsNotSortedByVA = "section table is not sorted by VirtualAddress"
+
+	// AnoSectionTableBeyondFile is reported when the section table extends
+	// beyond the end of the file, the sections which can be read are kept.
+	AnoSectionTableBeyondFile = "section table extends beyond the end of the file"
+
+	// AnoSectionNullBytes is reported when a section header is made of
+	// null bytes.
+	AnoSectionNullBytes = "Section `%s` Contents are null-bytes"
+
+	// AnoSectionRawDataBeyondFile is reported when the raw data of a section
+	// extends beyond the end of the file.
+	AnoSectionRawDataBeyondFile = "Section `%s` SizeOfRawData is larger than file"
+
+	// AnoSectionPointerToRawDataBeyondFile is reported when the raw data of
+	// a section starts beyond the end of the file.
+	AnoSectionPointerToRawDataBeyondFile = "Section `%s` PointerToRawData points beyond the end of the file"
+
+	// AnoSectionVirtualSizeTooLarge is reported when the VirtualSize of a
+	// section is larger than 256MiB.
+	AnoSectionVirtualSizeTooLarge = "Section `%s` VirtualSize is extremely large > 256MiB"
+
+	// AnoSectionVirtualAddressTooLarge is reported when the VirtualAddress
+	// of a section is beyond 0x10000000.
+	AnoSectionVirtualAddressTooLarge = "Section `%s` VirtualAddress is beyond 0x10000000"
+
+	// AnoSectionPointerToRawDataUnaligned is reported when the
+	// PointerToRawData of a section is not a multiple of FileAlignment.
+	AnoSectionPointerToRawDataUnaligned = "Section `%s` PointerToRawData is not multiple of FileAlignment"
+
+	// AnoSectionRawDataOverlap is reported when the raw data of two sections
+	// overlap.
+	AnoSectionRawDataOverlap = "Section `%s` raw data overlaps with section `%s`"
+
+	// AnoSectionNameDuplicated is reported when two sections share the same
+	// name, tools looking sections up by name only see the first one.
+	AnoSectionNameDuplicated = "Section `%s` at index %d has the same name as the section at index %d"
+
+	// AnoSectionPaddingNotZero is reported when the padding of a section
+	// contains non-zero bytes which are not the ones linkers write.
+	AnoSectionPaddingNotZero = "Section `%s` padding contains non-zero bytes"
+
+	// AnoHeaderOnlyImage is reported when the image has no usable section,
+	// the loader maps the whole image from the headers.
+	AnoHeaderOnlyImage = "image has no sections, data is mapped from the headers"
+
+	// AnoLowAlignment is reported when SectionAlignment is lower than the
+	// page size, the file is mapped as is and RVAs are equal to file offsets.
+	AnoLowAlignment = "image uses low alignment, RVAs are file offsets"
+
+	// AnoDataDirectoryOutsideImage is reported when a data directory RVA is
+	// beyond SizeOfImage, the directory is not parsed.
+	AnoDataDirectoryOutsideImage = "data directory %s RVA is beyond SizeOfImage"
+
+	// AnoDataDirectoryOverflowImage is reported when a data directory
+	// RVA + Size is beyond SizeOfImage.
+	AnoDataDirectoryOverflowImage = "data directory %s extends beyond SizeOfImage"
+
+	// AnoDataDirectoryOutsideFile is reported when the certificate table
+	// offset is beyond the end of the file, the directory is not parsed.
+	AnoDataDirectoryOutsideFile = "data directory %s offset is beyond the end of the file"
+
+	// AnoDataDirectoryOverflowFile is reported when the certificate table
+	// offset + Size is beyond the end of the file.
+	AnoDataDirectoryOverflowFile = "data directory %s extends beyond the end of the file"
+
+	// AnoDataDirectoryOutsideSections is reported when a data directory RVA
+	// does not fall within the headers or any section.
+	AnoDataDirectoryOutsideSections = "data directory %s RVA is not within any section"
+
+	// AnoDataDirectoryInHeaders is reported when a data directory, other than
+	// the bound import directory which linkers place after the section
+	// table, lies within the headers.
+	AnoDataDirectoryInHeaders = "data directory %s lies within the headers"
+
+	// AnoDataDirectoryOverlap is reported when two data directories share
+	// some bytes, such as a debug directory hidden in the certificate table.
+	AnoDataDirectoryOverlap = "data directory %s overlaps with data directory %s"
+)
+
+// Anomalies reported when an image does not opt in to an exploit mitigation.
+// They are only checked when listed in Options.FailOnAnomalies, as most
+// images in the wild lack at least one of them.
+var (
+	// AnoNoDynamicBase is reported when the image cannot be relocated at
+	// load time (ASLR).
+	AnoNoDynamicBase = "Image does not support ASLR"
+
+	// AnoNoHighEntropyVA is reported when a 64-bit image does not support a
+	// high entropy virtual address space.
+	AnoNoHighEntropyVA = "Image does not support high entropy ASLR"
+
+	// AnoNoNXCompat is reported when the image is not compatible with data
+	// execution prevention.
+	AnoNoNXCompat = "Image does not support DEP"
+
+	// AnoNoCFG is reported when the image is not built with Control Flow
+	// Guard.
+	AnoNoCFG = "Image does not support Control Flow Guard"
+
+	// AnoNoCETCompat is reported when the image is not compatible with the
+	// CET shadow stack.
+	AnoNoCETCompat = "Image is not CET shadow stack compatible"
 )
 
 // GetAnomalies reportes anomalies found in a PE binary.
 // These nomalies does prevent the Windows loader from loading the files but
 // is an interesting features for malware analysis.
+// Nothing is reported for files without NT header, as parsed with the
+// AllowInvalidNTHeader option.
 func (pe *File) GetAnomalies() error {
 
+	if !pe.HasNTHdr {
+		return nil
+	}
+
 	// ******************** Anomalies in File header ************************
 	// An application for Windows NT typically has the nine predefined sections
 	// named: .text, .bss, .rdata, .data, .rsrc, .edata, .idata, .pdata, and
@@ -98,25 +243,25 @@ func (pe *File) GetAnomalies() error {
 	// NumberOfSections can be up to 96 under XP.
 	// NumberOfSections can be up to 65535 under Vista and later.
 	if pe.NtHeader.FileHeader.NumberOfSections >= 10 {
-		pe.Anomalies = append(pe.Anomalies, AnoNumberOfSections10Plus)
+		pe.addAnomaly(AnoNumberOfSections10Plus)
 	}
 
 	// File header timestamp set to 0.
 	if pe.NtHeader.FileHeader.TimeDateStamp == 0 {
-		pe.Anomalies = append(pe.Anomalies, AnoPETimeStampNull)
+		pe.addAnomaly(AnoPETimeStampNull)
 	}
 
 	// File header timestamp set to the future.
 	now := time.Now()
 	future := uint32(now.Add(24 * time.Hour).Unix())
 	if pe.NtHeader.FileHeader.TimeDateStamp > future {
-		pe.Anomalies = append(pe.Anomalies, AnoPETimeStampFuture)
+		pe.addAnomaly(AnoPETimeStampFuture)
 	}
 
 	// NumberOfSections can be null with low alignment PEs
 	// and in this case, the values are just checked but not really used (under XP)
 	if pe.NtHeader.FileHeader.NumberOfSections == 0 {
-		pe.Anomalies = append(pe.Anomalies, AnoNumberOfSectionsNull)
+		pe.addAnomaly(AnoNumberOfSectionsNull)
 	}
 
 	// SizeOfOptionalHeader is not the size of the optional header, but the delta
@@ -124,7 +269,7 @@ func (pe *File) GetAnomalies() error {
 	// Thus, it can be null (the section table will overlap the Optional Header,
 	// or can be null when no sections are present)
 	if pe.NtHeader.FileHeader.SizeOfOptionalHeader == 0 {
-		pe.Anomalies = append(pe.Anomalies, AnoSizeOfOptionalHeaderNull)
+		pe.addAnomaly(AnoSizeOfOptionalHeaderNull)
 	}
 
 	// SizeOfOptionalHeader can be bigger than the file
@@ -136,13 +281,13 @@ func (pe *File) GetAnomalies() error {
 	// SizeOfOptionalHeader standard value is 0xE0 for PE32.
 	if pe.Is32 &&
 		pe.NtHeader.FileHeader.SizeOfOptionalHeader > uint16(binary.Size(oh32)) {
-		pe.Anomalies = append(pe.Anomalies, AnoUncommonSizeOfOptionalHeader32)
+		pe.addAnomaly(AnoUncommonSizeOfOptionalHeader32)
 	}
 
 	// SizeOfOptionalHeader standard value is 0xF0 for PE32+.
 	if pe.Is64 &&
 		pe.NtHeader.FileHeader.SizeOfOptionalHeader > uint16(binary.Size(oh64)) {
-		pe.Anomalies = append(pe.Anomalies, AnoUncommonSizeOfOptionalHeader64)
+		pe.addAnomaly(AnoUncommonSizeOfOptionalHeader64)
 	}
 
 	// ***************** Anomalies in Optional header *********************
@@ -150,61 +295,93 @@ func (pe *File) GetAnomalies() error {
 	// SizeOfHeaders, except if it's null.
 	switch pe.Is64 {
 	case true:
-		oh64 = pe.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+		if v, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			oh64 = v
+		}
 	case false:
-		oh32 = pe.NtHeader.OptionalHeader.(ImageOptionalHeader32)
+		if v, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			oh32 = v
+		}
 	}
 
 	// Use oh for fields which are common for both structures.
 	oh := oh32
+	if pe.Is64 {
+		oh.AddressOfEntryPoint = oh64.AddressOfEntryPoint
+		oh.SizeOfHeaders = oh64.SizeOfHeaders
+		oh.SectionAlignment = oh64.SectionAlignment
+		oh.SizeOfImage = oh64.SizeOfImage
+		oh.MajorSubsystemVersion = oh64.MajorSubsystemVersion
+		oh.Win32VersionValue = oh64.Win32VersionValue
+		oh.CheckSum = oh64.CheckSum
+		oh.DllCharacteristics = oh64.DllCharacteristics
+	}
 	if oh.AddressOfEntryPoint != 0 && oh.AddressOfEntryPoint < oh.SizeOfHeaders {
-		pe.Anomalies = append(pe.Anomalies, AnoAddressOfEPLessSizeOfHeaders)
+		pe.addAnomaly(AnoAddressOfEPLessSizeOfHeaders)
 	}
 
 	// AddressOfEntryPoint can be null in DLLs: in this case,
 	// DllMain is just not called. can be null
 	if oh.AddressOfEntryPoint == 0 {
-		pe.Anomalies = append(pe.Anomalies, AnoAddressOfEntryPointNull)
+		pe.addAnomaly(AnoAddressOfEntryPointNull)
+	}
+
+	// The entry point is expected in an executable section. The entry point
+	// in the headers is reported above.
+	switch pe.EntryPointLocation() {
+	case EntryPointInDataSection:
+		pe.addAnomaly(AnoAddressOfEPNotInCode)
+	case EntryPointOutsideSections:
+		pe.addAnomaly(AnoAddressOfEPOutsideSections)
+	case EntryPointBeyondImage:
+		pe.addAnomaly(AnoAddressOfEPBeyondImage)
 	}
 
 	// ImageBase can be null, under XP.
 	// In this case, the binary will be relocated to 10000h
 	if (pe.Is64 && oh64.ImageBase == 0) ||
 		(pe.Is32 && oh32.ImageBase == 0) {
-		pe.Anomalies = append(pe.Anomalies, AnoImageBaseNull)
+		pe.addAnomaly(AnoImageBaseNull)
+	}
+
+	// An image requesting ASLR without relocations can only be loaded at its
+	// preferred base.
+	if pe.NtHeader.FileHeader.Characteristics&ImageFileRelocsStripped != 0 &&
+		oh.DllCharacteristics&ImageDllCharacteristicsDynamicBase != 0 {
+		pe.addAnomaly(AnoRelocsStrippedASLR)
 	}
 
 	// The msdn states that SizeOfImage must be a multiple of the section
 	// alignment. This is not a requirement though. Adding it as anomaly.
 	// Todo: raise an anomaly when SectionAlignment is NULL ?
 	if oh.SectionAlignment != 0 && oh.SizeOfImage%oh.SectionAlignment != 0 {
-		pe.Anomalies = append(pe.Anomalies, AnoInvalidSizeOfImage)
+		pe.addAnomaly(AnoInvalidSizeOfImage)
 	}
 
 	// For DLLs, MajorSubsystemVersion is ignored until Windows 8. It can have
 	// any value. Under Windows 8, it needs a standard value (3.10 < 6.30).
 	if oh.MajorSubsystemVersion < 3 || oh.MajorSubsystemVersion > 6 {
-		pe.Anomalies = append(pe.Anomalies, AnoMajorSubsystemVersion)
+		pe.addAnomaly(AnoMajorSubsystemVersion)
 	}
 
 	// Win32VersionValue officially defined as `reserved` and should be null
 	// if non null, it overrides MajorVersion/MinorVersion/BuildNumber/PlatformId
 	// OperatingSystem Versions values located in the PEB, after loading.
 	if oh.Win32VersionValue != 0 {
-		pe.Anomalies = append(pe.Anomalies, AnonWin32VersionValue)
+		pe.addAnomaly(AnonWin32VersionValue)
 	}
 
 	// Checksums are required for kernel-mode drivers and some system DLLs.
 	// Otherwise, this field can be 0.
 	if pe.Checksum() != oh.CheckSum && oh.CheckSum != 0 {
-		pe.Anomalies = append(pe.Anomalies, AnoInvalidPEChecksum)
+		pe.addAnomaly(AnoInvalidPEChecksum)
 	}
 
 	// This field contains the number of IMAGE_DATA_DIRECTORY entries.
 	//  This field has been 16 since the earliest releases of Windows NT.
-	if (pe.Is64 && oh64.NumberOfRvaAndSizes == 0xA) ||
-		(pe.Is32 && oh32.NumberOfRvaAndSizes == 0xA) {
-		pe.Anomalies = append(pe.Anomalies, AnoNumberOfRvaAndSizes)
+	if (pe.Is64 && oh64.NumberOfRvaAndSizes != 16) ||
+		(pe.Is32 && oh32.NumberOfRvaAndSizes != 16) {
+		pe.addAnomaly(AnoNumberOfRvaAndSizes)
 	}
 
 	return nil
@@ -213,6 +390,65 @@ func (pe *File) GetAnomalies() error {
 // addAnomaly appends the given anomaly to the list of anomalies.
 func (pe *File) addAnomaly(anomaly string) {
 	if !stringInSlice(anomaly, pe.Anomalies) {
-		pe.Anomalies = append(pe.Anomalies, anomaly)
+		pe.appendAnomaly(anomaly)
+	}
+}
+
+// appendAnomaly appends the given anomaly to the list of anomalies, even
+// when already reported, along with its identifier.
+func (pe *File) appendAnomaly(anomaly string) {
+	pe.Anomalies = append(pe.Anomalies, anomaly)
+	pe.AnomalyIDs = append(pe.AnomalyIDs, LookupAnomaly(anomaly))
+}
+
+// failOnAnomaly implements Options.FailOnAnomaly, it returns an
+// *AnomalyError listing the selected anomalies the file has, if any.
+func (pe *File) failOnAnomaly() error {
+	if !pe.opts.FailOnAnomaly {
+		return nil
+	}
+
+	err := pe.GetAnomalies()
+	if err != nil {
+		return err
+	}
+
+	var selected map[string]bool
+	if len(pe.opts.FailOnAnomalies) > 0 {
+		selected = make(map[string]bool, len(pe.opts.FailOnAnomalies))
+		for _, anomaly := range pe.opts.FailOnAnomalies {
+			selected[anomaly] = true
+		}
+	}
+
+	// The missing mitigations are read from the NT header.
+	if selected != nil && pe.HasNTHdr {
+		sf := pe.SecurityFeatures()
+		mitigations := []struct {
+			anomaly string
+			missing bool
+		}{
+			{AnoNoDynamicBase, !sf.DynamicBase},
+			{AnoNoHighEntropyVA, pe.Is64 && !sf.HighEntropyVA},
+			{AnoNoNXCompat, !sf.NXCompat},
+			{AnoNoCFG, !sf.CFG},
+			{AnoNoCETCompat, !sf.CETCompat},
+		}
+		for _, m := range mitigations {
+			if m.missing && selected[m.anomaly] {
+				pe.addAnomaly(m.anomaly)
+			}
+		}
 	}
+
+	var found []string
+	for _, anomaly := range pe.Anomalies {
+		if selected == nil || selected[anomaly] {
+			found = append(found, anomaly)
+		}
+	}
+	if len(found) > 0 {
+		return &AnomalyError{Anomalies: found}
+	}
+	return nil
 }
@@ -5,6 +5,7 @@
 package pe
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -51,3 +52,75 @@ func TestGetAnomalies(t *testing.T) {
 		})
 	}
 }
+
+func TestFailOnAnomaly(t *testing.T) {
+
+	tests := []struct {
+		in        string
+		anomalies []string
+		out       []string
+	}{
+		{
+			getAbsoluteFilePath("test/putty.exe"),
+			nil,
+			nil,
+		},
+		{
+			getAbsoluteFilePath("test/putty.exe"),
+			[]string{AnoNoDynamicBase, AnoNoHighEntropyVA, AnoNoCFG},
+			[]string{AnoNoCFG},
+		},
+		{
+			getAbsoluteFilePath("test/KernelBase.dll"),
+			nil,
+			[]string{AnoPETimeStampFuture, AnoMajorSubsystemVersion},
+		},
+		{
+			getAbsoluteFilePath("test/KernelBase.dll"),
+			[]string{AnoInvalidPEChecksum, AnoNoDynamicBase, AnoNoCFG},
+			nil,
+		},
+		{
+			getAbsoluteFilePath("test/arp.dll"),
+			[]string{AnoNoDynamicBase, AnoNoNXCompat},
+			[]string{AnoNoDynamicBase, AnoNoNXCompat},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			ops := Options{FailOnAnomaly: true, FailOnAnomalies: tt.anomalies}
+			file, err := New(tt.in, &ops)
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+
+			err = file.Parse()
+			if tt.out == nil {
+				if err != nil {
+					t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrAnomaly) {
+				t.Fatalf("Parse(%s) error assertion failed, got %v, want %v",
+					tt.in, err, ErrAnomaly)
+			}
+			var anoErr *AnomalyError
+			if !errors.As(err, &anoErr) {
+				t.Fatalf("Parse(%s) returned %T, want *AnomalyError", tt.in, err)
+			}
+			if len(anoErr.Anomalies) != len(tt.out) {
+				t.Fatalf("anomalies assertion failed, got %v, want %v",
+					anoErr.Anomalies, tt.out)
+			}
+			for i, ano := range tt.out {
+				if anoErr.Anomalies[i] != ano {
+					t.Errorf("anomaly #%d assertion failed, got %s, want %s",
+						i, anoErr.Anomalies[i], ano)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"regexp"
+	"strings"
+)
+
+// AnomalyID identifies an anomaly independently of its text, which lets
+// consumers handle anomalies programmatically. The text of an anomaly is
+// given by String, anomalies taking arguments, such as the name of a data
+// directory, return their format string.
+type AnomalyID uint16
+
+// Anomaly identifiers. Their values are stable, new identifiers are only
+// appended.
+const (
+	// AnomalyUnknown is the identifier of an anomaly which is not known,
+	// such as one appended by the caller.
+	AnomalyUnknown AnomalyID = iota
+	AnomalyPEHeaderOverlapDOSHeader
+	AnomalyPEHeaderBeyondSizeOfHeaders
+	AnomalyPEHeaderOverlapSection
+	AnomalyPEHeaderInOverlay
+	AnomalyPETimeStampNull
+	AnomalyPETimeStampFuture
+	AnomalyNumberOfSections10Plus
+	AnomalyNumberOfSectionsNull
+	AnomalySizeOfOptionalHeaderNull
+	AnomalyUncommonSizeOfOptionalHeader32
+	AnomalyUncommonSizeOfOptionalHeader64
+	AnomalyAddressOfEntryPointNull
+	AnomalyAddressOfEPLessSizeOfHeaders
+	AnomalyImageBaseNull
+	AnomalyDanSMagicOffset
+	AnomalyInvalidFileAlignment
+	AnomalyInvalidSectionAlignment
+	AnomalyMajorSubsystemVersion
+	AnomalyWin32VersionValue
+	AnomalyInvalidPEChecksum
+	AnomalyNumberOfRvaAndSizes
+	AnomalySizeOfOptionalHeaderTruncated
+	AnomalyOptionalHeaderBeyondFile
+	AnomalyReservedDataDirectoryEntry
+	AnomalyCOFFSymbolsCount
+	AnomalyRelocationEntriesCount
+	AnomalySectionsNotSortedByVA
+	AnomalySectionTableBeyondFile
+	AnomalySectionNullBytes
+	AnomalySectionRawDataBeyondFile
+	AnomalySectionPointerToRawDataBeyondFile
+	AnomalySectionVirtualSizeTooLarge
+	AnomalySectionVirtualAddressTooLarge
+	AnomalySectionPointerToRawDataUnaligned
+	AnomalySectionRawDataOverlap
+	AnomalySectionPaddingNotZero
+	AnomalyHeaderOnlyImage
+	AnomalyLowAlignment
+	AnomalyDataDirectoryOutsideImage
+	AnomalyDataDirectoryOverflowImage
+	AnomalyDataDirectoryOutsideFile
+	AnomalyDataDirectoryOverflowFile
+	AnomalyDataDirectoryOutsideSections
+	AnomalyNoDynamicBase
+	AnomalyNoHighEntropyVA
+	AnomalyNoNXCompat
+	AnomalyNoCFG
+	AnomalyNoCETCompat
+	AnomalyImageBaseOverflow
+	AnomalyInvalidSizeOfImage
+	AnomalyVAOutsideImage
+	AnomalyArchitectureDataDirectoryEntry
+	AnomalyBoundImportNameOutsideDirectory
+	AnomalyBoundImportNameInDescriptors
+	AnomalyBoundImportNameInvalid
+	AnomalyBoundImportBeyondDirectory
+	AnomalyDebugDataOutsideSections
+	AnomalyDebugDataInvalidPointer
+	AnomalyMetadataStreamOutsideDirectory
+	AnomalyMetadataStreamTruncated
+	AnomalyExportMaxOrdEntries
+	AnomalyExportManyRepeatedEntries
+	AnomalyNullNumberOfFunctions
+	AnomalyNullAddressOfFunctions
+	AnomalyExportEntriesCount
+	AnomalyExportNamesNotSorted
+	AnomalyExportNameDuplicated
+	AnomalyExportNameOutsideDirectory
+	AnomalyInvalidGlobalPtrReg
+	AnomalyGlobalPtrSizeNotZero
+	AnomalyGlobalPtrUnexpectedMachine
+	AnomalyInvalidThunkAddressOfData
+	AnomalyManyRepeatedEntries
+	AnomalyAddressOfDataBeyondLimits
+	AnomalyImportNoNameNoOrdinal
+	AnomalyImportDescriptorBeyondDirectory
+	AnomalyImportNameInHeaders
+	AnomalyImportNameOutsideSections
+	AnomalyImportBoundWithoutINT
+	AnomalyImportMergedINTAndIAT
+	AnomalyImportThunkOutsideImage
+	AnomalyRelocTypeInvalidForMachine
+	AnomalyResourceDirectoryLoop
+	AnomalyResourceDirectoryTooDeep
+	AnomalyDansSigNotFound
+	AnomalyPaddingDwordNotZero
+	AnomalyInvalidRichHeaderChecksum
+	AnomalyUnsupportedCertificateType
+	AnomalyTLSCallbacksNull
+	AnomalyTLSDirectoryStraddlesSections
+	AnomalyImportINTTruncated
+	AnomalySectionNameDuplicated
+	AnomalyDataDirectoryInHeaders
+	AnomalyDataDirectoryOverlap
+	AnomalyCLRHeaderNotInFile
+	AnomalyResourceDataOutsideSection
+	AnomalyAddressOfEPNotInCode
+	AnomalyAddressOfEPOutsideSections
+	AnomalyAddressOfEPBeyondImage
+	AnomalyRelocsStrippedASLR
+	AnomalyRelocPageOutsideImage
+)
+
+// anomalyText maps the anomaly identifiers to their text.
+var anomalyText = map[AnomalyID]string{
+	AnomalyPEHeaderOverlapDOSHeader:          AnoPEHeaderOverlapDOSHeader,
+	AnomalyPEHeaderBeyondSizeOfHeaders:       AnoPEHeaderBeyondSizeOfHeaders,
+	AnomalyPEHeaderOverlapSection:            AnoPEHeaderOverlapSection,
+	AnomalyPEHeaderInOverlay:                 AnoPEHeaderInOverlay,
+	AnomalyPETimeStampNull:                   AnoPETimeStampNull,
+	AnomalyPETimeStampFuture:                 AnoPETimeStampFuture,
+	AnomalyNumberOfSections10Plus:            AnoNumberOfSections10Plus,
+	AnomalyNumberOfSectionsNull:              AnoNumberOfSectionsNull,
+	AnomalySizeOfOptionalHeaderNull:          AnoSizeOfOptionalHeaderNull,
+	AnomalyUncommonSizeOfOptionalHeader32:    AnoUncommonSizeOfOptionalHeader32,
+	AnomalyUncommonSizeOfOptionalHeader64:    AnoUncommonSizeOfOptionalHeader64,
+	AnomalyAddressOfEntryPointNull:           AnoAddressOfEntryPointNull,
+	AnomalyAddressOfEPLessSizeOfHeaders:      AnoAddressOfEPLessSizeOfHeaders,
+	AnomalyImageBaseNull:                     AnoImageBaseNull,
+	AnomalyDanSMagicOffset:                   AnoDanSMagicOffset,
+	AnomalyInvalidFileAlignment:              ErrInvalidFileAlignment,
+	AnomalyInvalidSectionAlignment:           ErrInvalidSectionAlignment,
+	AnomalyMajorSubsystemVersion:             AnoMajorSubsystemVersion,
+	AnomalyWin32VersionValue:                 AnonWin32VersionValue,
+	AnomalyInvalidPEChecksum:                 AnoInvalidPEChecksum,
+	AnomalyNumberOfRvaAndSizes:               AnoNumberOfRvaAndSizes,
+	AnomalySizeOfOptionalHeaderTruncated:     AnoSizeOfOptionalHeaderTruncated,
+	AnomalyOptionalHeaderBeyondFile:          AnoOptionalHeaderBeyondFile,
+	AnomalyReservedDataDirectoryEntry:        AnoReservedDataDirectoryEntry,
+	AnomalyCOFFSymbolsCount:                  AnoCOFFSymbolsCount,
+	AnomalyRelocationEntriesCount:            AnoRelocationEntriesCount,
+	AnomalySectionsNotSortedByVA:             AnoSectionsNotSortedByVA,
+	AnomalySectionTableBeyondFile:            AnoSectionTableBeyondFile,
+	AnomalySectionNullBytes:                  AnoSectionNullBytes,
+	AnomalySectionRawDataBeyondFile:          AnoSectionRawDataBeyondFile,
+	AnomalySectionPointerToRawDataBeyondFile: AnoSectionPointerToRawDataBeyondFile,
+	AnomalySectionVirtualSizeTooLarge:        AnoSectionVirtualSizeTooLarge,
+	AnomalySectionVirtualAddressTooLarge:     AnoSectionVirtualAddressTooLarge,
+	AnomalySectionPointerToRawDataUnaligned:  AnoSectionPointerToRawDataUnaligned,
+	AnomalySectionRawDataOverlap:             AnoSectionRawDataOverlap,
+	AnomalySectionPaddingNotZero:             AnoSectionPaddingNotZero,
+	AnomalyHeaderOnlyImage:                   AnoHeaderOnlyImage,
+	AnomalyLowAlignment:                      AnoLowAlignment,
+	AnomalyDataDirectoryOutsideImage:         AnoDataDirectoryOutsideImage,
+	AnomalyDataDirectoryOverflowImage:        AnoDataDirectoryOverflowImage,
+	AnomalyDataDirectoryOutsideFile:          AnoDataDirectoryOutsideFile,
+	AnomalyDataDirectoryOverflowFile:         AnoDataDirectoryOverflowFile,
+	AnomalyDataDirectoryOutsideSections:      AnoDataDirectoryOutsideSections,
+	AnomalyNoDynamicBase:                     AnoNoDynamicBase,
+	AnomalyNoHighEntropyVA:                   AnoNoHighEntropyVA,
+	AnomalyNoNXCompat:                        AnoNoNXCompat,
+	AnomalyNoCFG:                             AnoNoCFG,
+	AnomalyNoCETCompat:                       AnoNoCETCompat,
+	AnomalyImageBaseOverflow:                 AnoImageBaseOverflow,
+	AnomalyInvalidSizeOfImage:                AnoInvalidSizeOfImage,
+	AnomalyVAOutsideImage:                    AnoVAOutsideImage,
+	AnomalyArchitectureDataDirectoryEntry:    AnoArchitectureDataDirectoryEntry,
+	AnomalyBoundImportNameOutsideDirectory:   AnoBoundImportNameOutsideDirectory,
+	AnomalyBoundImportNameInDescriptors:      AnoBoundImportNameInDescriptors,
+	AnomalyBoundImportNameInvalid:            AnoBoundImportNameInvalid,
+	AnomalyBoundImportBeyondDirectory:        AnoBoundImportBeyondDirectory,
+	AnomalyDebugDataOutsideSections:          AnoDebugDataOutsideSections,
+	AnomalyDebugDataInvalidPointer:           AnoDebugDataInvalidPointer,
+	AnomalyMetadataStreamOutsideDirectory:    AnoMetadataStreamOutsideDirectory,
+	AnomalyMetadataStreamTruncated:           AnoMetadataStreamTruncated,
+	AnomalyExportMaxOrdEntries:               ErrExportMaxOrdEntries,
+	AnomalyExportManyRepeatedEntries:         ErrExportManyRepeatedEntries,
+	AnomalyNullNumberOfFunctions:             AnoNullNumberOfFunctions,
+	AnomalyNullAddressOfFunctions:            AnoNullAddressOfFunctions,
+	AnomalyExportEntriesCount:                AnoExportEntriesCount,
+	AnomalyExportNamesNotSorted:              AnoExportNamesNotSorted,
+	AnomalyExportNameDuplicated:              AnoExportNameDuplicated,
+	AnomalyExportNameOutsideDirectory:        AnoExportNameOutsideDirectory,
+	AnomalyInvalidGlobalPtrReg:               AnoInvalidGlobalPtrReg,
+	AnomalyGlobalPtrSizeNotZero:              AnoGlobalPtrSizeNotZero,
+	AnomalyGlobalPtrUnexpectedMachine:        AnoGlobalPtrUnexpectedMachine,
+	AnomalyInvalidThunkAddressOfData:         AnoInvalidThunkAddressOfData,
+	AnomalyManyRepeatedEntries:               AnoManyRepeatedEntries,
+	AnomalyAddressOfDataBeyondLimits:         AnoAddressOfDataBeyondLimits,
+	AnomalyImportNoNameNoOrdinal:             AnoImportNoNameNoOrdinal,
+	AnomalyImportDescriptorBeyondDirectory:   AnoImportDescriptorBeyondDirectory,
+	AnomalyImportNameInHeaders:               AnoImportNameInHeaders,
+	AnomalyImportNameOutsideSections:         AnoImportNameOutsideSections,
+	AnomalyImportBoundWithoutINT:             AnoImportBoundWithoutINT,
+	AnomalyImportMergedINTAndIAT:             AnoImportMergedINTAndIAT,
+	AnomalyImportThunkOutsideImage:           AnoImportThunkOutsideImage,
+	AnomalyRelocTypeInvalidForMachine:        AnoRelocTypeInvalidForMachine,
+	AnomalyResourceDirectoryLoop:             AnoResourceDirectoryLoop,
+	AnomalyResourceDirectoryTooDeep:          AnoResourceDirectoryTooDeep,
+	AnomalyDansSigNotFound:                   AnoDansSigNotFound,
+	AnomalyPaddingDwordNotZero:               AnoPaddingDwordNotZero,
+	AnomalyInvalidRichHeaderChecksum:         AnoInvalidRichHeaderChecksum,
+	AnomalyUnsupportedCertificateType:        AnoUnsupportedCertificateType,
+	AnomalyTLSCallbacksNull:                  AnoTLSCallbacksNull,
+	AnomalyTLSDirectoryStraddlesSections:     AnoTLSDirectoryStraddlesSections,
+	AnomalyImportINTTruncated:                AnoImportINTTruncated,
+	AnomalySectionNameDuplicated:             AnoSectionNameDuplicated,
+	AnomalyDataDirectoryInHeaders:            AnoDataDirectoryInHeaders,
+	AnomalyDataDirectoryOverlap:              AnoDataDirectoryOverlap,
+	AnomalyCLRHeaderNotInFile:                AnoCLRHeaderNotInFile,
+	AnomalyResourceDataOutsideSection:        AnoResourceDataOutsideSection,
+	AnomalyAddressOfEPNotInCode:              AnoAddressOfEPNotInCode,
+	AnomalyAddressOfEPOutsideSections:        AnoAddressOfEPOutsideSections,
+	AnomalyAddressOfEPBeyondImage:            AnoAddressOfEPBeyondImage,
+	AnomalyRelocsStrippedASLR:                AnoRelocsStrippedASLR,
+	AnomalyRelocPageOutsideImage:             AnoRelocPageOutsideImage,
+}
+
+// anomalyIDs maps the text of the anomalies to their identifier.
+var anomalyIDs = func() map[string]AnomalyID {
+	ids := make(map[string]AnomalyID, len(anomalyText))
+	for id, text := range anomalyText {
+		ids[text] = id
+	}
+	return ids
+}()
+
+// anomalyPatterns matches the text of the anomalies taking arguments.
+var anomalyPatterns = func() map[AnomalyID]*regexp.Regexp {
+	verb := regexp.MustCompile(`%[-+# 0-9]*[a-zA-Z]`)
+	patterns := make(map[AnomalyID]*regexp.Regexp)
+	for id, text := range anomalyText {
+		if !verb.MatchString(text) {
+			continue
+		}
+		literals := verb.Split(text, -1)
+		for i, literal := range literals {
+			literals[i] = regexp.QuoteMeta(literal)
+		}
+		patterns[id] = regexp.MustCompile("^" + strings.Join(literals, ".*") + "$")
+	}
+	return patterns
+}()
+
+// String returns the text of the anomaly.
+func (id AnomalyID) String() string {
+	if v, ok := anomalyText[id]; ok {
+		return v
+	}
+	return "?"
+}
+
+// LookupAnomaly returns the identifier of an anomaly given its text, as found
+// in File.Anomalies, and AnomalyUnknown when the text is not the one of an
+// anomaly reported by the parser.
+func LookupAnomaly(anomaly string) AnomalyID {
+	if id, ok := anomalyIDs[anomaly]; ok {
+		return id
+	}
+	for id, pattern := range anomalyPatterns {
+		if pattern.MatchString(anomaly) {
+			return id
+		}
+	}
+	return AnomalyUnknown
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestLookupAnomaly(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out AnomalyID
+	}{
+		{AnoPETimeStampNull, AnomalyPETimeStampNull},
+		{AnoPETimeStampFuture, AnomalyPETimeStampFuture},
+		{ErrInvalidFileAlignment, AnomalyInvalidFileAlignment},
+		{AnonWin32VersionValue, AnomalyWin32VersionValue},
+		{AnoInvalidRichHeaderChecksum, AnomalyInvalidRichHeaderChecksum},
+		{fmt.Sprintf(AnoDataDirectoryOutsideFile, "Security"),
+			AnomalyDataDirectoryOutsideFile},
+		{fmt.Sprintf(AnoSectionRawDataOverlap, ".text", ".data"),
+			AnomalySectionRawDataOverlap},
+		{fmt.Sprintf(AnoExportNameOutsideDirectory, "foo", 2, 0x1000),
+			AnomalyExportNameOutsideDirectory},
+		{"not an anomaly", AnomalyUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got := LookupAnomaly(tt.in)
+			if got != tt.out {
+				t.Errorf("anomaly ID assertion failed, got %d (%v), want %d (%v)",
+					got, got, tt.out, tt.out)
+			}
+		})
+	}
+}
+
+func TestAnomalyIDString(t *testing.T) {
+
+	tests := []struct {
+		in  AnomalyID
+		out string
+	}{
+		{AnomalyUnknown, "?"},
+		{AnomalyNumberOfSections10Plus, AnoNumberOfSections10Plus},
+		{AnomalyDataDirectoryOutsideSections, AnoDataDirectoryOutsideSections},
+		{AnomalyTLSDirectoryStraddlesSections, AnoTLSDirectoryStraddlesSections},
+		{AnomalyID(0xffff), "?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			got := tt.in.String()
+			if got != tt.out {
+				t.Errorf("anomaly string assertion failed, got %v, want %v",
+					got, tt.out)
+			}
+		})
+	}
+
+	// Every anomaly has its own text.
+	seen := make(map[string]AnomalyID)
+	for id, text := range anomalyText {
+		if other, ok := seen[text]; ok {
+			t.Errorf("anomalies %d and %d share the text %q", id, other, text)
+		}
+		seen[text] = id
+	}
+}
+
+func TestAnomalyIDs(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out []AnomalyID
+	}{
+		{
+			getAbsoluteFilePath("test/mfc140u.dll"),
+			[]AnomalyID{AnomalyExportManyRepeatedEntries,
+				AnomalyExportMaxOrdEntries},
+		},
+		{
+			getAbsoluteFilePath("test/arp.dll"),
+			[]AnomalyID{AnomalyDebugDataOutsideSections,
+				AnomalyMajorSubsystemVersion},
+		},
+		{
+			getAbsoluteFilePath("test/putty_modified.exe"),
+			[]AnomalyID{AnomalyInvalidPEChecksum},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.GetAnomalies()
+			if err != nil {
+				t.Fatalf("GetAnomalies(%s) failed, reason: %v", tt.in, err)
+			}
+
+			if !reflect.DeepEqual(file.AnomalyIDs, tt.out) {
+				t.Fatalf("anomaly IDs assertion failed, got %v, want %v",
+					file.AnomalyIDs, tt.out)
+			}
+			for i, id := range file.AnomalyIDs {
+				if LookupAnomaly(file.Anomalies[i]) != id {
+					t.Errorf("anomaly #%d %q does not match ID %d", i,
+						file.Anomalies[i], id)
+				}
+			}
+		})
+	}
+}
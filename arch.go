@@ -4,8 +4,75 @@
 
 package pe
 
+const (
+	// AnoArchitectureDataDirectoryEntry is reported when the architecture
+	// data directory entry is not zero.
+	AnoArchitectureDataDirectoryEntry = "architecture data directory entry is a reserved field, must be set to zero"
+)
+
+// ReservedDataDirectory holds the content of a data directory entry the
+// specification reserves, the architecture and the last entry, which must
+// be zero. Malware is known to hide data there, as the loader ignores them.
+type ReservedDataDirectory struct {
+	// The RVA and size found in the data directory entry.
+	VirtualAddress uint32 `json:"virtual_address"`
+	Size           uint32 `json:"size"`
+
+	// The bytes pointed to by the entry, truncated to the end of the file.
+	Raw []byte `json:"raw"`
+}
+
 // Architecture-specific data. This data directory is not used
 // (set to all zeros) for I386, IA64, or AMD64 architecture.
 func (pe *File) parseArchitectureDirectory(rva, size uint32) error {
+	pe.Architecture = pe.parseReservedDataDirectory(rva, size)
+	pe.HasArchitect = true
+	return nil
+}
+
+// The last data directory entry is reserved, it must be zero.
+func (pe *File) parseReservedDirectory(rva, size uint32) error {
+	pe.Reserved = pe.parseReservedDataDirectory(rva, size)
+	return nil
+}
+
+func (pe *File) parseReservedDataDirectory(rva, size uint32) ReservedDataDirectory {
+	return ReservedDataDirectory{
+		VirtualAddress: rva,
+		Size:           size,
+		Raw:            pe.rawDirectoryData(rva, size),
+	}
+}
+
+// rawDirectoryData returns the bytes pointed to by a directory given its RVA
+// and size, truncated to the end of the file.
+func (pe *File) rawDirectoryData(rva, size uint32) []byte {
+	offset := pe.GetOffsetFromRva(rva)
+	if offset == ^uint32(0) || pe.checkRange(offset, 0) != nil {
+		return nil
+	}
+	end := uint64(offset) + uint64(size)
+	if end > pe.size {
+		end = pe.size
+	}
+	pe.markCoverage(offset, uint32(end)-offset)
+	return pe.data[offset:end]
+}
+
+// checkReservedDataDirectory reports the reserved data directory entries
+// which are not zero, either their address or their size.
+func (pe *File) checkReservedDataDirectory(entry ImageDirectoryEntry,
+	va, size uint32) error {
+
+	if va == 0 && size == 0 {
+		return nil
+	}
+
+	switch entry {
+	case ImageDirectoryEntryArchitecture:
+		return pe.violation(entry.String(), AnoArchitectureDataDirectoryEntry, nil)
+	case ImageDirectoryEntryReserved:
+		return pe.violation(entry.String(), AnoReservedDataDirectoryEntry, nil)
+	}
 	return nil
 }
@@ -0,0 +1,92 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReservedDataDirectories(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	tests := []struct {
+		entry   ImageDirectoryEntry
+		rva     uint32
+		size    uint32
+		anomaly string
+		raw     bool
+	}{
+		{ImageDirectoryEntryArchitecture, 0x1000, 0x10,
+			AnoArchitectureDataDirectoryEntry, true},
+		{ImageDirectoryEntryArchitecture, 0, 0x10,
+			AnoArchitectureDataDirectoryEntry, false},
+		{ImageDirectoryEntryReserved, 0x1000, 0x20,
+			AnoReservedDataDirectoryEntry, true},
+		{ImageDirectoryEntryReserved, 0, 0x20,
+			AnoReservedDataDirectoryEntry, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry.String(), func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+
+			// putty.exe is a PE32+, data directories follow the 112 bytes of
+			// the optional header fixed fields.
+			ntHeaderOffset := binary.LittleEndian.Uint32(data[0x3c:])
+			dirOffset := ntHeaderOffset + 4 + 20 + 112 + uint32(tt.entry)*8
+			binary.LittleEndian.PutUint32(data[dirOffset:], tt.rva)
+			binary.LittleEndian.PutUint32(data[dirOffset+4:], tt.size)
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			if !stringInSlice(tt.anomaly, file.Anomalies) {
+				t.Errorf("anomaly %s not found in %v", tt.anomaly, file.Anomalies)
+			}
+
+			dir := file.Architecture
+			if tt.entry == ImageDirectoryEntryReserved {
+				dir = file.Reserved
+			}
+			if tt.raw {
+				offset := file.GetOffsetFromRva(tt.rva)
+				want := data[offset : offset+tt.size]
+				if dir.VirtualAddress != tt.rva || dir.Size != tt.size ||
+					!bytes.Equal(dir.Raw, want) {
+					t.Errorf("%s directory assertion failed, got %v, want raw %x",
+						tt.entry, dir, want)
+				}
+			} else if dir.Raw != nil {
+				t.Errorf("%s directory assertion failed, got raw %x, want none",
+					tt.entry, dir.Raw)
+			}
+
+			// In strict mode, the violation aborts parsing.
+			file, err = NewBytes(data, &Options{Strict: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			var violation *SpecViolationError
+			if !errors.As(err, &violation) || violation.Violation != tt.anomaly {
+				t.Errorf("strict Parse(%s) assertion failed, got %v, want %s",
+					filename, err, tt.anomaly)
+			}
+		})
+	}
+}
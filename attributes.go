@@ -0,0 +1,151 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode"
+)
+
+// AttributesPrefix is the namespace used for the keys returned by Attributes.
+const AttributesPrefix = "pe."
+
+// Attributes returns a flat map of counters and sizes describing the parsed
+// structures. Keys are dot separated and prefixed with `pe.`, values are
+// either int64 or bool, which makes them straightforward to attach to
+// OpenTelemetry spans or to any other key/value based telemetry sink:
+//
+//	for k, v := range file.Attributes() {
+//		switch v := v.(type) {
+//		case int64:
+//			span.SetAttributes(attribute.Int64(k, v))
+//		case bool:
+//			span.SetAttributes(attribute.Bool(k, v))
+//		}
+//	}
+//
+// When the file is parsed with the CollectStats option, the time spent and
+// the bytes read by every parser are included as `pe.stats.<parser>.duration_ns`
+// and `pe.stats.<parser>.bytes`. It is meant to be called after Parse.
+func (pe *File) Attributes() map[string]interface{} {
+	attrs := make(map[string]interface{})
+	set := func(key string, value interface{}) {
+		attrs[AttributesPrefix+key] = value
+	}
+
+	// Under the LazyImports option, the imports are read from the file on
+	// every call, read them once for all the attributes relying on them.
+	imports := pe.imports()
+
+	set("size", int64(pe.size))
+	set("is_32", pe.Is32)
+	set("is_64", pe.Is64)
+	set("is_dll", pe.IsDLL())
+	set("is_driver", pe.isDriver(imports))
+	set("anomalies.count", int64(len(pe.Anomalies)))
+
+	set("rich_header.present", pe.HasRichHdr)
+	set("rich_header.comp_ids.count", int64(len(pe.RichHeader.CompIDs)))
+
+	set("coff.present", pe.HasCOFF)
+	set("coff.symbols.count", int64(len(pe.COFF.SymbolTable)))
+
+	set("sections.present", pe.HasSections)
+	set("sections.count", int64(len(pe.Sections)))
+
+	importFuncsCount := 0
+	for _, imp := range imports {
+		importFuncsCount += len(imp.Functions)
+	}
+	set("imports.present", pe.HasImport)
+	set("imports.dlls.count", int64(len(imports)))
+	set("imports.functions.count", int64(importFuncsCount))
+
+	set("exports.present", pe.HasExport)
+	set("exports.functions.count", int64(len(pe.Export.Functions)))
+
+	relocEntriesCount := 0
+	for _, reloc := range pe.Relocations {
+		relocEntriesCount += len(reloc.Entries)
+	}
+	set("relocations.present", pe.HasReloc)
+	set("relocations.blocks.count", int64(len(pe.Relocations)))
+	set("relocations.entries.count", int64(relocEntriesCount))
+
+	set("resources.present", pe.HasResource)
+	set("resources.types.count", int64(len(pe.Resources.Entries)))
+
+	set("exceptions.present", pe.HasException)
+	set("exceptions.count", int64(len(pe.Exceptions)))
+
+	set("certificates.present", pe.HasCertificate)
+	set("certificates.count", int64(len(pe.Certificates.Certificates)))
+	var certificatesSize int64
+	if pe.HasCertificate {
+		certificatesSize = int64(pe.Certificates.Header.Length) -
+			int64(binary.Size(pe.Certificates.Header))
+	}
+	set("certificates.size", certificatesSize)
+	set("signed", pe.IsSigned)
+
+	set("debug.present", pe.HasDebug)
+	set("debug.entries.count", int64(len(pe.Debugs)))
+
+	set("tls.present", pe.HasTLS)
+	set("load_config.present", pe.HasLoadCFG)
+
+	delayImportFuncsCount := 0
+	for _, imp := range pe.DelayImports {
+		delayImportFuncsCount += len(imp.Functions)
+	}
+	set("delay_imports.present", pe.HasDelayImp)
+	set("delay_imports.dlls.count", int64(len(pe.DelayImports)))
+	set("delay_imports.functions.count", int64(delayImportFuncsCount))
+
+	set("bound_imports.present", pe.HasBoundImp)
+	set("bound_imports.count", int64(len(pe.BoundImports)))
+
+	set("iat.present", pe.HasIAT)
+	set("iat.entries.count", int64(len(pe.IAT)))
+
+	set("clr.present", pe.HasCLR)
+	set("clr.metadata_streams.count", int64(len(pe.CLR.MetadataStreamHeaders)))
+	set("clr.metadata_tables.count", int64(len(pe.CLR.MetadataTables)))
+
+	overlaySize := int64(0)
+	if pe.HasOverlay {
+		overlaySize = pe.OverlayLength()
+	}
+	set("overlay.present", pe.HasOverlay)
+	set("overlay.size", overlaySize)
+
+	for _, stats := range pe.Stats {
+		key := "stats." + attributeName(stats.Parser)
+		set(key+".duration_ns", stats.Duration.Nanoseconds())
+		set(key+".bytes", int64(stats.Bytes))
+	}
+
+	return attrs
+}
+
+// attributeName converts a parser name such as `NTHeader` or `LoadConfig`
+// to the snake case used by the attribute keys, `nt_header` and
+// `load_config` respectively.
+func attributeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := rune(name[i])
+		if i > 0 && unicode.IsUpper(c) {
+			prevLower := unicode.IsLower(rune(name[i-1]))
+			nextLower := i+1 < len(name) && unicode.IsLower(rune(name[i+1]))
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
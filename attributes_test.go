@@ -0,0 +1,89 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"testing"
+)
+
+func TestAttributes(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out map[string]interface{}
+	}{
+		{getAbsoluteFilePath("test/putty.exe"),
+			map[string]interface{}{
+				"pe.size":                     int64(1179024),
+				"pe.is_64":                    true,
+				"pe.sections.count":           int64(8),
+				"pe.imports.dlls.count":       int64(8),
+				"pe.imports.functions.count":  int64(324),
+				"pe.relocations.blocks.count": int64(18),
+				"pe.exceptions.count":         int64(1889),
+				"pe.certificates.present":     true,
+				"pe.overlay.size":             int64(15760),
+			}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			attrs := file.Attributes()
+			for k, want := range tt.out {
+				got, ok := attrs[k]
+				if !ok {
+					t.Errorf("attribute %s not found", k)
+					continue
+				}
+				if got != want {
+					t.Errorf("attribute %s assertion failed, got %v, want %v",
+						k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAttributesStats(t *testing.T) {
+	filename := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(filename, &Options{CollectStats: true, LazyImports: true})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	attrs := file.Attributes()
+	for _, parser := range []string{"dos_header", "nt_header", "section_header",
+		"import", "load_config"} {
+		key := "pe.stats." + parser + ".bytes"
+		bytes, ok := attrs[key].(int64)
+		if !ok || bytes == 0 {
+			t.Errorf("attribute %s assertion failed, got %v", key, attrs[key])
+		}
+		key = "pe.stats." + parser + ".duration_ns"
+		if _, ok := attrs[key].(int64); !ok {
+			t.Errorf("attribute %s not found", key)
+		}
+	}
+
+	if got := attrs["pe.imports.functions.count"]; got != int64(324) {
+		t.Errorf("imports functions count assertion failed, got %v, want 324", got)
+	}
+	if got := attrs["pe.is_driver"]; got != false {
+		t.Errorf("is_driver assertion failed, got %v, want false", got)
+	}
+}
@@ -6,6 +6,8 @@ package pe
 
 import (
 	"encoding/binary"
+	"fmt"
+	"strings"
 )
 
 const (
@@ -15,6 +17,29 @@ const (
 	MaxStringLength = uint32(0x100)
 )
 
+const (
+	// AnoBoundImportNameOutsideDirectory is reported when the offset of a
+	// module name, relative to the start of the directory, points beyond
+	// the directory.
+	AnoBoundImportNameOutsideDirectory = "Bound import name at offset 0x%x lies outside the bound import directory"
+
+	// AnoBoundImportNameInDescriptors is reported when the offset of a
+	// module name points back into the descriptors.
+	AnoBoundImportNameInDescriptors = "Bound import name at offset 0x%x overlaps the bound import descriptors"
+
+	// AnoBoundImportNameInvalid is reported when a module name is beyond the
+	// file, too long or not printable, which aborts the parsing.
+	AnoBoundImportNameInvalid = "Bound import name at offset 0x%x is invalid"
+
+	// AnoBoundImportBeyondDirectory is reported when the descriptors are not
+	// terminated within the directory.
+	AnoBoundImportBeyondDirectory = "Bound import descriptors are not terminated within the directory"
+)
+
+// maxBoundImportNameLength is the maximum length of a module name of the
+// bound import directory, longer names are taken as a corrupt entry.
+const maxBoundImportNameLength = 256
+
 // ImageBoundImportDescriptor represents the IMAGE_BOUND_IMPORT_DESCRIPTOR.
 type ImageBoundImportDescriptor struct {
 	// TimeDateStamp is just the value from the Exports information of the DLL
@@ -73,13 +98,20 @@ func (pe *File) parseBoundImportDirectory(rva, size uint32) (err error) {
 			break
 		}
 
+		// The loader only looks for the terminating descriptor, but linkers
+		// size the directory to include it.
+		if size > 0 && rva+bndDescSize > start+size {
+			pe.addAnomaly(AnoBoundImportBeyondDirectory)
+			break
+		}
+
 		rva += bndDescSize
 		sectionsAfterOffset = nil
 
 		fileOffset := pe.GetOffsetFromRva(rva)
 		section := pe.getSectionByRva(rva)
 		if section == nil {
-			safetyBoundary = pe.size - fileOffset
+			safetyBoundary = pe.remaining(fileOffset)
 			for _, section := range pe.Sections {
 				if section.Header.PointerToRawData > fileOffset {
 					sectionsAfterOffset = append(
@@ -101,7 +133,8 @@ func (pe *File) parseBoundImportDirectory(rva, size uint32) (err error) {
 		}
 
 		if section == nil {
-			pe.logger.Warnf("RVA of IMAGE_BOUND_IMPORT_DESCRIPTOR points to an invalid address: 0x%x", rva)
+			pe.warnf(ImageDirectoryEntryBoundImport.String(), 0,
+				"RVA of IMAGE_BOUND_IMPORT_DESCRIPTOR points to an invalid address: 0x%x", rva)
 			return nil
 		}
 
@@ -118,15 +151,9 @@ func (pe *File) parseBoundImportDirectory(rva, size uint32) (err error) {
 
 			rva += bndFrwdRefSize
 
-			offset := start + uint32(bndFrwdRef.OffsetModuleName)
-			DllNameBuff := string(pe.GetStringFromData(0, pe.data[offset:offset+MaxStringLength]))
-			DllName := string(DllNameBuff)
-
-			// OffsetModuleName points to a DLL name. These shouldn't be too long.
-			// Anything longer than a safety length of 128 will be taken to indicate
-			// a corrupt entry and abort the processing of these entries.
-			// Names shorter than 4 characters will be taken as invalid as well.
-			if DllName != "" && (len(DllName) > 256 || !IsPrintable(DllName)) {
+			DllName, ok := pe.boundImportName(start, size, rva,
+				bndFrwdRef.OffsetModuleName)
+			if !ok {
 				break
 			}
 
@@ -134,10 +161,9 @@ func (pe *File) parseBoundImportDirectory(rva, size uint32) (err error) {
 				Struct: bndFrwdRef, Name: DllName})
 		}
 
-		offset := start + uint32(bndDesc.OffsetModuleName)
-		DllNameBuff := pe.GetStringFromData(0, pe.data[offset:offset+MaxStringLength])
-		DllName := string(DllNameBuff)
-		if DllName != "" && (len(DllName) > 256 || !IsPrintable(DllName)) {
+		DllName, ok := pe.boundImportName(start, size, rva,
+			bndDesc.OffsetModuleName)
+		if !ok {
 			break
 		}
 
@@ -152,3 +178,224 @@ func (pe *File) parseBoundImportDirectory(rva, size uint32) (err error) {
 	}
 	return nil
 }
+
+// boundImportName reads a module name of the bound import directory, given
+// its offset from the start of the directory. The names are expected after
+// the descriptors read so far, which end at descEnd, and within the directory.
+// Malformed files point them anywhere, such as into the headers: the names
+// are bounds checked and false is returned for names beyond the file, too
+// long or not printable, which indicate a corrupt entry.
+func (pe *File) boundImportName(start, size, descEnd uint32,
+	offsetModuleName uint16) (string, bool) {
+
+	offset := uint64(start) + uint64(offsetModuleName)
+	if offset >= pe.size {
+		pe.addAnomaly(fmt.Sprintf(AnoBoundImportNameInvalid, offsetModuleName))
+		return "", false
+	}
+
+	// Read one more byte than the maximum length to detect longer names.
+	end := offset + maxBoundImportNameLength + 1
+	if end > pe.size {
+		end = pe.size
+	}
+	name := string(pe.GetStringFromData(0, pe.data[offset:end]))
+	pe.markCoverage(uint32(offset), uint32(len(name))+1)
+
+	// OffsetModuleName points to a DLL name. These shouldn't be too long.
+	// Anything longer than the safety length or not printable will be taken
+	// to indicate a corrupt entry and abort the processing of these entries.
+	if name != "" && (len(name) > maxBoundImportNameLength || !IsPrintable(name)) {
+		pe.addAnomaly(fmt.Sprintf(AnoBoundImportNameInvalid, offsetModuleName))
+		return "", false
+	}
+
+	if size > 0 && uint32(offsetModuleName) >= size {
+		pe.addAnomaly(fmt.Sprintf(AnoBoundImportNameOutsideDirectory,
+			offsetModuleName))
+	} else if uint32(offsetModuleName) < descEnd-start {
+		pe.addAnomaly(fmt.Sprintf(AnoBoundImportNameInDescriptors,
+			offsetModuleName))
+	}
+	return name, true
+}
+
+// BindingSource identifies where a binding is recorded in the image.
+type BindingSource int
+
+const (
+	// BindingSourceBoundImport is an entry of the bound import directory.
+	BindingSourceBoundImport BindingSource = iota
+
+	// BindingSourceForwarderRef is a forwarder reference of an entry of the
+	// bound import directory.
+	BindingSourceForwarderRef
+
+	// BindingSourceImport is an old style binding, the timestamp is stored in
+	// the import descriptor itself.
+	BindingSourceImport
+
+	// BindingSourceDelayImport is a bound delay import descriptor.
+	BindingSourceDelayImport
+)
+
+// BindingStatus describes whether a binding still holds.
+type BindingStatus int
+
+const (
+	// BindingValid means the module timestamp matches the binding.
+	BindingValid BindingStatus = iota
+
+	// BindingStale means the module timestamp differs from the binding, the
+	// loader ignores the bound addresses and resolves the imports again.
+	BindingStale
+
+	// BindingOutOfRange means the timestamp matches, but the bound addresses
+	// span more than the module SizeOfImage, so they can't point into it.
+	BindingOutOfRange
+
+	// BindingModuleUnknown means the module is not part of the given set.
+	BindingModuleUnknown
+)
+
+// BoundModule describes a module an image may be bound against, as found on
+// the system the bindings are checked for.
+type BoundModule struct {
+	// TimeDateStamp is the file header timestamp of the module.
+	TimeDateStamp uint32 `json:"time_date_stamp"`
+
+	// SizeOfImage is the optional header SizeOfImage of the module, 0 when
+	// unknown.
+	SizeOfImage uint32 `json:"size_of_image"`
+}
+
+// Binding reports the state of a binding recorded in the image.
+type Binding struct {
+	// Module is the name of the module the image is bound against.
+	Module string `json:"module"`
+
+	// Source tells where the binding is recorded.
+	Source BindingSource `json:"source"`
+
+	// TimeDateStamp is the module timestamp recorded at binding time.
+	TimeDateStamp uint32 `json:"time_date_stamp"`
+
+	// Status is the result of the comparison with the module.
+	Status BindingStatus `json:"status"`
+}
+
+// CheckBindings compares the bindings recorded in the image: the bound import
+// directory entries and their forwarder references, the old style bound
+// import descriptors and the bound delay import descriptors, against the
+// given modules. Module names are matched case insensitively. When the
+// SizeOfImage of a module is known, the addresses stored in the IAT of an old
+// style binding are also checked to fit within an image of that size.
+func (pe *File) CheckBindings(modules map[string]BoundModule) []Binding {
+	byName := make(map[string]BoundModule, len(modules))
+	for name, m := range modules {
+		byName[strings.ToLower(name)] = m
+	}
+
+	check := func(name string, source BindingSource, timestamp uint32) Binding {
+		b := Binding{Module: name, Source: source, TimeDateStamp: timestamp}
+		m, ok := byName[strings.ToLower(name)]
+		switch {
+		case !ok:
+			b.Status = BindingModuleUnknown
+		case m.TimeDateStamp != timestamp:
+			b.Status = BindingStale
+		default:
+			b.Status = BindingValid
+		}
+		return b
+	}
+
+	var bindings []Binding
+	for _, bndImp := range pe.BoundImports {
+		bindings = append(bindings, check(bndImp.Name,
+			BindingSourceBoundImport, bndImp.Struct.TimeDateStamp))
+		for _, ref := range bndImp.ForwardedRefs {
+			bindings = append(bindings, check(ref.Name,
+				BindingSourceForwarderRef, ref.Struct.TimeDateStamp))
+		}
+	}
+
+	for _, imp := range pe.imports() {
+		// A timestamp of -1 means the binding is described in the bound
+		// import directory.
+		timestamp := imp.Descriptor.TimeDateStamp
+		if timestamp == 0 || timestamp == ^uint32(0) {
+			continue
+		}
+
+		b := check(imp.Name, BindingSourceImport, timestamp)
+		m := byName[strings.ToLower(imp.Name)]
+		if b.Status == BindingValid && m.SizeOfImage != 0 {
+			// Forwarded functions are not bound, their IAT entries chain
+			// the index of the next forwarded function.
+			forwarded := make(map[uint32]bool)
+			chain := imp.Descriptor.ForwarderChain
+			for chain < uint32(len(imp.Functions)) && !forwarded[chain] {
+				forwarded[chain] = true
+				chain = uint32(imp.Functions[chain].ThunkValue)
+			}
+
+			lowest, highest := ^uint64(0), uint64(0)
+			for i, fn := range imp.Functions {
+				if forwarded[uint32(i)] {
+					continue
+				}
+				if fn.ThunkValue < lowest {
+					lowest = fn.ThunkValue
+				}
+				if fn.ThunkValue > highest {
+					highest = fn.ThunkValue
+				}
+			}
+			if highest >= lowest && highest-lowest >= uint64(m.SizeOfImage) {
+				b.Status = BindingOutOfRange
+			}
+		}
+		bindings = append(bindings, b)
+	}
+
+	for _, imp := range pe.DelayImports {
+		if imp.Descriptor.TimeDateStamp == 0 {
+			continue
+		}
+		bindings = append(bindings, check(imp.Name,
+			BindingSourceDelayImport, imp.Descriptor.TimeDateStamp))
+	}
+
+	return bindings
+}
+
+// String returns the string representation of a binding source.
+func (s BindingSource) String() string {
+	bindingSourceMap := map[BindingSource]string{
+		BindingSourceBoundImport:  "Bound Import",
+		BindingSourceForwarderRef: "Bound Forwarder Reference",
+		BindingSourceImport:       "Import",
+		BindingSourceDelayImport:  "Delay Import",
+	}
+
+	if val, ok := bindingSourceMap[s]; ok {
+		return val
+	}
+	return "?"
+}
+
+// String returns the string representation of a binding status.
+func (s BindingStatus) String() string {
+	bindingStatusMap := map[BindingStatus]string{
+		BindingValid:         "Valid",
+		BindingStale:         "Stale",
+		BindingOutOfRange:    "Out Of Range",
+		BindingModuleUnknown: "Module Unknown",
+	}
+
+	if val, ok := bindingStatusMap[s]; ok {
+		return val
+	}
+	return "?"
+}
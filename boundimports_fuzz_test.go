@@ -0,0 +1,54 @@
+//go:build go1.18
+// +build go1.18
+
+// Copyright 2018 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzBoundImportDirectory mutates the bound import directory of a bound
+// file, run it with: go test -fuzz=FuzzBoundImportDirectory
+func FuzzBoundImportDirectory(f *testing.F) {
+	filename := getAbsoluteFilePath("test/mfc40u.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		f.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+	file, err := NewBytes(data, &Options{Fast: true})
+	if err != nil {
+		f.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	if err := file.Parse(); err != nil {
+		f.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	dirEntry := file.dataDirectory(ImageDirectoryEntryBoundImport)
+	rva, size := dirEntry.VirtualAddress, dirEntry.Size
+	offset := file.GetOffsetFromRva(rva)
+
+	f.Add(data[offset:offset+size], size)
+	f.Add([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0}, size)
+
+	f.Fuzz(func(t *testing.T, dir []byte, dirSize uint32) {
+		if len(dir) > int(size) {
+			dir = dir[:size]
+		}
+		mutated := make([]byte, len(data))
+		copy(mutated, data)
+		copy(mutated[offset:], dir)
+
+		file, err := NewBytes(mutated, &Options{Fast: true})
+		if err != nil {
+			t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+		}
+		if err := file.Parse(); err != nil {
+			t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+		}
+		file.parseBoundImportDirectory(rva, dirSize)
+	})
+}
@@ -5,6 +5,10 @@
 package pe
 
 import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"reflect"
 	"testing"
 )
@@ -102,3 +106,204 @@ func TestBoundImportDirectory(t *testing.T) {
 		})
 	}
 }
+
+// mfc40u.dll has its bound import directory in the headers: 4 descriptors
+// and 2 forwarder references, followed by the terminating descriptor and the
+// module names, 0x38 bytes after the start of the directory.
+const (
+	mfc40uBoundImportOffset = 0x268
+	mfc40uBoundImportSize   = 0x7c
+)
+
+func TestBoundImportNameAnomalies(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		patch   func(data []byte)
+		size    uint32
+		count   int
+		names   []string
+		anomaly string
+	}{
+		{
+			"name outside directory",
+			func(data []byte) {
+				copy(data[mfc40uBoundImportOffset+0x80:], "EVIL.dll\x00")
+				binary.LittleEndian.PutUint16(data[mfc40uBoundImportOffset+4:], 0x80)
+			},
+			mfc40uBoundImportSize, 4,
+			[]string{"EVIL.dll", "KERNEL32.dll", "GDI32.dll", "USER32.dll"},
+			fmt.Sprintf(AnoBoundImportNameOutsideDirectory, 0x80),
+		},
+		{
+			"name in descriptors",
+			func(data []byte) {
+				copy(data[mfc40uBoundImportOffset:], "ABC\x00")
+				binary.LittleEndian.PutUint16(data[mfc40uBoundImportOffset+4:], 0)
+			},
+			mfc40uBoundImportSize, 4,
+			[]string{"ABC", "KERNEL32.dll", "GDI32.dll", "USER32.dll"},
+			fmt.Sprintf(AnoBoundImportNameInDescriptors, 0),
+		},
+		{
+			"name not printable",
+			func(data []byte) {
+				binary.LittleEndian.PutUint16(data[mfc40uBoundImportOffset+4:], 0)
+			},
+			mfc40uBoundImportSize, 0, nil,
+			fmt.Sprintf(AnoBoundImportNameInvalid, 0),
+		},
+		{
+			"descriptors beyond directory",
+			func(data []byte) {},
+			8, 1, []string{"MSVCRT40.dll"},
+			AnoBoundImportBeyondDirectory,
+		},
+	}
+
+	filename := getAbsoluteFilePath("test/mfc40u.dll")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+			tt.patch(data)
+
+			file, err := NewBytes(data, &Options{Fast: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			err = file.parseBoundImportDirectory(mfc40uBoundImportOffset, tt.size)
+			if err != nil {
+				t.Fatalf("parseBoundImportDirectory(%s) failed, reason: %v",
+					filename, err)
+			}
+			if len(file.BoundImports) != tt.count {
+				t.Fatalf("bound imports entry count assertion failed, got %v, "+
+					"want %v", len(file.BoundImports), tt.count)
+			}
+			for i, name := range tt.names {
+				if file.BoundImports[i].Name != name {
+					t.Errorf("bound import name assertion failed, got %v, want %v",
+						file.BoundImports[i].Name, name)
+				}
+			}
+			if !stringInSlice(tt.anomaly, file.Anomalies) {
+				t.Errorf("anomaly %q not found in %v", tt.anomaly, file.Anomalies)
+			}
+		})
+	}
+}
+
+// TestBoundImportDirectoryMutations parses randomly corrupted bound import
+// directories, which must never panic.
+func TestBoundImportDirectoryMutations(t *testing.T) {
+	filename := getAbsoluteFilePath("test/mfc40u.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	// The directory lies after the section headers, the parsed file is
+	// reused for every mutation.
+	mutated := make([]byte, len(data))
+	copy(mutated, data)
+	file, err := NewBytes(mutated, &Options{Fast: true})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		copy(mutated, data)
+		for j := rng.Intn(16) + 1; j > 0; j-- {
+			off := mfc40uBoundImportOffset + rng.Intn(mfc40uBoundImportSize)
+			mutated[off] = byte(rng.Intn(256))
+		}
+		file.BoundImports = nil
+		file.Anomalies = nil
+
+		func() {
+			defer func() {
+				if e := recover(); e != nil {
+					t.Fatalf("parseBoundImportDirectory() of mutation %d "+
+						"panicked: %v", i, e)
+				}
+			}()
+			file.parseBoundImportDirectory(mfc40uBoundImportOffset,
+				uint32(rng.Intn(2*mfc40uBoundImportSize)))
+		}()
+	}
+}
+
+func TestCheckBindings(t *testing.T) {
+
+	tests := []struct {
+		in      string
+		modules map[string]BoundModule
+		out     []Binding
+	}{
+		{
+			getAbsoluteFilePath("test/mfc40u.dll"),
+			map[string]BoundModule{
+				"msvcrt40.dll": {TimeDateStamp: 0x31CB50F3},
+				"MSVCRT.DLL":   {TimeDateStamp: 0x3B7DFE0F},
+				"kernel32.dll": {TimeDateStamp: 0x3B7DFE0E},
+			},
+			[]Binding{
+				{"MSVCRT40.dll", BindingSourceBoundImport, 0x31CB50F3, BindingValid},
+				{"msvcrt.DLL", BindingSourceForwarderRef, 0x3B7DFE0E, BindingStale},
+				{"KERNEL32.dll", BindingSourceBoundImport, 0x3B7DFE0E, BindingValid},
+				{"NTDLL.DLL", BindingSourceForwarderRef, 0x3B7DE01E, BindingModuleUnknown},
+				{"GDI32.dll", BindingSourceBoundImport, 0x3B7DFE0E, BindingModuleUnknown},
+				{"USER32.dll", BindingSourceBoundImport, 0x3B7DFE0E, BindingModuleUnknown},
+			},
+		},
+		{
+			getAbsoluteFilePath("test/WdfCoInstaller01011.dll"),
+			map[string]BoundModule{
+				"msvcrt.dll":   {TimeDateStamp: 0x50109D55, SizeOfImage: 0xa0000},
+				"setupapi.dll": {TimeDateStamp: 0x50109D56},
+				"advapi32.dll": {TimeDateStamp: 0x50109D55, SizeOfImage: 0x1000},
+			},
+			[]Binding{
+				{"msvcrt.dll", BindingSourceImport, 0x50109D55, BindingValid},
+				{"SETUPAPI.dll", BindingSourceImport, 0x50109D55, BindingStale},
+				{"KERNEL32.dll", BindingSourceImport, 0x50109D55, BindingModuleUnknown},
+				{"ADVAPI32.dll", BindingSourceImport, 0x50109D55, BindingOutOfRange},
+				{"SHELL32.dll", BindingSourceImport, 0x50109D55, BindingModuleUnknown},
+				{"USER32.dll", BindingSourceImport, 0x50109D55, BindingModuleUnknown},
+				{"SHLWAPI.dll", BindingSourceImport, 0x50109D55, BindingModuleUnknown},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			got := file.CheckBindings(tt.modules)
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("CheckBindings() assertion failed, got %v, want %v",
+					got, tt.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/secDre4mer/pkcs7"
+)
+
+var (
+	// oidCertificateTrustList is the content type of the signed data of a
+	// security catalog, szOID_CTL.
+	oidCertificateTrustList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 10, 1}
+
+	// oidCatalogList is the usage of the trust list of a security catalog,
+	// szOID_CATALOG_LIST.
+	oidCatalogList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 12, 1, 1}
+
+	// oidSpcIndirectData holds the hash of a catalog member, as found in
+	// Authenticode signatures, SPC_INDIRECT_DATA_OBJID.
+	oidSpcIndirectData = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+
+	// oidCatalogNameValue is a name-value attribute of a catalog or of one of
+	// its members, CAT_NAMEVALUE_OBJID.
+	oidCatalogNameValue = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 12, 2, 1}
+
+	// oidCatalogMemberInfo tells the subject type of a catalog member,
+	// CAT_MEMBERINFO_OBJID.
+	oidCatalogMemberInfo = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 12, 2, 2}
+)
+
+// Catalog represents a security catalog (.cat), a signed list of the hashes
+// of the files of a product, commonly shipped with drivers. The files listed
+// in a catalog are not signed themselves, their hash is looked up in the
+// catalog instead.
+type Catalog struct {
+	// The identifier of the list, usually a GUID.
+	ListIdentifier []byte `json:"list_identifier"`
+
+	// The time the list was issued.
+	ThisUpdate time.Time `json:"this_update"`
+
+	// The time the next list is due, zero when missing.
+	NextUpdate time.Time `json:"next_update,omitempty"`
+
+	// The files listed in the catalog.
+	Members []CatalogMember `json:"members"`
+
+	// The name-value attributes of the catalog, such as the operating
+	// systems it applies to.
+	Attributes []CatalogAttribute `json:"attributes,omitempty"`
+
+	// The signer of the catalog and the result of its verification, see the
+	// certificate validation options.
+	Signer     CertInfo         `json:"signer"`
+	Verified   bool             `json:"verified"`
+	Revocation RevocationStatus `json:"revocation"`
+
+	// The PKCS#7 structure of the catalog.
+	Content pkcs7.PKCS7 `json:"-"`
+}
+
+// CatalogMember is a file listed in a catalog.
+type CatalogMember struct {
+	// The tag of the member, usually the hexadecimal hash of the file.
+	Tag string `json:"tag"`
+
+	// The GUID of the subject interface package which hashes the file, as
+	// the PE image or the flat file one.
+	SubjectGUID string `json:"subject_guid,omitempty"`
+
+	// The hash of the file, the Authentihash for PE images, and the function
+	// used to compute it.
+	HashFunction crypto.Hash `json:"hash_function"`
+	Hash         []byte      `json:"hash"`
+
+	// The name-value attributes of the member, such as its file name.
+	Attributes []CatalogAttribute `json:"attributes,omitempty"`
+}
+
+// CatalogAttribute is a name-value attribute of a catalog or of a member.
+type CatalogAttribute struct {
+	Name  string `json:"name"`
+	Flags uint32 `json:"flags"`
+	Value string `json:"value"`
+}
+
+// catalogNameValue is the CAT_NAMEVALUE structure.
+type catalogNameValue struct {
+	Tag   asn1.RawValue
+	Flags int
+	Value []byte
+}
+
+// catalogMemberInfo is the CAT_MEMBERINFO structure.
+type catalogMemberInfo struct {
+	SubjectGUID asn1.RawValue
+	CertVersion int
+}
+
+// catalogAttribute is an attribute of a member of the trust list.
+type catalogAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// trustedSubject is a member of the trust list.
+type trustedSubject struct {
+	Identifier []byte
+	Attributes []catalogAttribute `asn1:"set,optional"`
+}
+
+// catalogIndirectData is the SpcIndirectDataContent of a catalog member,
+// whose data depends on the subject type.
+type catalogIndirectData struct {
+	Data          asn1.RawValue
+	MessageDigest DigestInfo
+}
+
+// ParseCatalog parses a security catalog and verifies its signature as
+// configured by the certificate validation options. opts can be nil.
+func ParseCatalog(data []byte, opts *Options) (*Catalog, error) {
+
+	// The signature is verified the way the one of a PE is.
+	file, err := NewBytes(data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		SignedData  struct {
+			Version          int
+			DigestAlgorithms asn1.RawValue
+			ContentInfo      struct {
+				ContentType asn1.ObjectIdentifier
+				Content     asn1.RawValue `asn1:"optional"`
+			}
+		} `asn1:"explicit,tag:0"`
+	}
+	_, err = asn1.Unmarshal(data, &contentInfo)
+	if err != nil ||
+		!contentInfo.ContentType.Equal(pkcs7.OIDSignedData) ||
+		!contentInfo.SignedData.ContentInfo.ContentType.Equal(oidCertificateTrustList) {
+		return nil, ErrNotCatalog
+	}
+
+	p7, err := pkcs7.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := Catalog{Content: *p7}
+	err = catalog.parseTrustList(p7.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog.Signer, catalog.Verified, catalog.Revocation, err =
+		file.verifySigner(p7)
+	if err != nil {
+		return nil, err
+	}
+	return &catalog, nil
+}
+
+// parseTrustList parses the certificate trust list of a catalog, the
+// content of its signed data:
+//
+//	CertificateTrustList ::= SEQUENCE {
+//	    subjectUsage     SEQUENCE OF OBJECT IDENTIFIER,
+//	    listIdentifier   OCTET STRING OPTIONAL,
+//	    sequenceNumber   INTEGER OPTIONAL,
+//	    thisUpdate       Time,
+//	    nextUpdate       Time OPTIONAL,
+//	    subjectAlgorithm AlgorithmIdentifier,
+//	    trustedSubjects  SEQUENCE OF TrustedSubject OPTIONAL,
+//	    extensions       [0] EXPLICIT Extensions OPTIONAL }
+func (c *Catalog) parseTrustList(content []byte) error {
+	var fields []asn1.RawValue
+	for rest := content; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &field)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, field)
+	}
+
+	// The subject usage tells the trust list is a catalog.
+	var usages []asn1.ObjectIdentifier
+	if len(fields) == 0 {
+		return ErrNotCatalog
+	}
+	if _, err := asn1.Unmarshal(fields[0].FullBytes, &usages); err != nil {
+		return err
+	}
+	isCatalog := false
+	for _, usage := range usages {
+		isCatalog = isCatalog || usage.Equal(oidCatalogList)
+	}
+	if !isCatalog {
+		return ErrNotCatalog
+	}
+
+	i := 1
+	if i < len(fields) && fields[i].Tag == asn1.TagOctetString {
+		c.ListIdentifier = fields[i].Bytes
+		i++
+	}
+	if i < len(fields) && fields[i].Tag == asn1.TagInteger {
+		i++
+	}
+
+	var updates []time.Time
+	for ; i < len(fields) && (fields[i].Tag == asn1.TagUTCTime ||
+		fields[i].Tag == asn1.TagGeneralizedTime); i++ {
+		var t time.Time
+		if _, err := asn1.Unmarshal(fields[i].FullBytes, &t); err != nil {
+			return err
+		}
+		updates = append(updates, t)
+	}
+	if len(updates) > 0 {
+		c.ThisUpdate = updates[0]
+	}
+	if len(updates) > 1 {
+		c.NextUpdate = updates[1]
+	}
+
+	// Skip the subject algorithm.
+	i++
+
+	if i < len(fields) && fields[i].Class == asn1.ClassUniversal &&
+		fields[i].Tag == asn1.TagSequence {
+		var subjects []trustedSubject
+		if _, err := asn1.Unmarshal(fields[i].FullBytes, &subjects); err != nil {
+			return err
+		}
+		for _, subject := range subjects {
+			c.Members = append(c.Members, parseCatalogMember(subject))
+		}
+		i++
+	}
+
+	if i < len(fields) && fields[i].Class == asn1.ClassContextSpecific &&
+		fields[i].Tag == 0 {
+		var extensions []pkix.Extension
+		if _, err := asn1.Unmarshal(fields[i].Bytes, &extensions); err != nil {
+			return err
+		}
+		for _, ext := range extensions {
+			if !ext.Id.Equal(oidCatalogNameValue) {
+				continue
+			}
+			if attr, ok := parseCatalogNameValue(ext.Value); ok {
+				c.Attributes = append(c.Attributes, attr)
+			}
+		}
+	}
+	return nil
+}
+
+// parseCatalogMember converts a member of the trust list. Attributes which
+// fail to parse are skipped.
+func parseCatalogMember(subject trustedSubject) CatalogMember {
+	member := CatalogMember{Tag: catalogMemberTag(subject.Identifier)}
+
+	for _, attr := range subject.Attributes {
+		for _, value := range attr.Values {
+			switch {
+			case attr.Type.Equal(oidSpcIndirectData):
+				var indirect catalogIndirectData
+				if _, err := asn1.Unmarshal(value.FullBytes, &indirect); err != nil {
+					continue
+				}
+				hashFunction, _, err := parseHashAlgorithm(
+					indirect.MessageDigest.DigestAlgorithm)
+				if err != nil {
+					continue
+				}
+				member.HashFunction = hashFunction
+				member.Hash = indirect.MessageDigest.Digest
+
+			case attr.Type.Equal(oidCatalogMemberInfo):
+				var info catalogMemberInfo
+				if _, err := asn1.Unmarshal(value.FullBytes, &info); err == nil {
+					member.SubjectGUID = decodeBMPString(info.SubjectGUID.Bytes)
+				}
+
+			case attr.Type.Equal(oidCatalogNameValue):
+				if nameValue, ok := parseCatalogNameValue(value.FullBytes); ok {
+					member.Attributes = append(member.Attributes, nameValue)
+				}
+			}
+		}
+	}
+	return member
+}
+
+// parseCatalogNameValue parses a CAT_NAMEVALUE structure, whose value is a
+// null terminated UTF-16 string.
+func parseCatalogNameValue(data []byte) (CatalogAttribute, bool) {
+	var nameValue catalogNameValue
+	if _, err := asn1.Unmarshal(data, &nameValue); err != nil {
+		return CatalogAttribute{}, false
+	}
+
+	return CatalogAttribute{
+		Name:  decodeBMPString(nameValue.Tag.Bytes),
+		Flags: uint32(nameValue.Flags),
+		Value: decodeUTF16String(nameValue.Value),
+	}, true
+}
+
+// catalogMemberTag returns the tag of a member, a null terminated UTF-16
+// string, or the hexadecimal encoding of the identifier when it is not one.
+func catalogMemberTag(identifier []byte) string {
+	if len(identifier) >= 2 && len(identifier)%2 == 0 && identifier[1] == 0 {
+		return decodeUTF16String(identifier)
+	}
+	return strings.ToUpper(hex.EncodeToString(identifier))
+}
+
+// decodeBMPString decodes an ASN.1 BMPString, big endian UTF-16.
+func decodeBMPString(b []byte) string {
+	chars := make([]uint16, len(b)/2)
+	for i := range chars {
+		chars[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return strings.TrimRight(string(utf16.Decode(chars)), "\x00")
+}
+
+// decodeUTF16String decodes a little endian UTF-16 string up to its null
+// terminator.
+func decodeUTF16String(b []byte) string {
+	chars := make([]uint16, len(b)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	s := string(utf16.Decode(chars))
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// Member returns the member of the catalog with the given hash, nil when the
+// hash is not listed.
+func (c *Catalog) Member(hash []byte) *CatalogMember {
+	for i := range c.Members {
+		if len(c.Members[i].Hash) > 0 && bytes.Equal(c.Members[i].Hash, hash) {
+			return &c.Members[i]
+		}
+	}
+	return nil
+}
+
+// FindFile returns the member of the catalog matching the Authentihash of a
+// PE, computed with the hash function of each member, nil when the file is
+// not listed. The file must be parsed before calling FindFile.
+func (c *Catalog) FindFile(pe *File) *CatalogMember {
+	hashes := make(map[crypto.Hash][]byte)
+	for i := range c.Members {
+		member := &c.Members[i]
+		if !member.HashFunction.Available() {
+			continue
+		}
+		hash, ok := hashes[member.HashFunction]
+		if !ok {
+			if results := pe.AuthentihashExt(member.HashFunction.New()); len(results) > 0 {
+				hash = results[0]
+			}
+			hashes[member.HashFunction] = hash
+		}
+		if bytes.Equal(member.Hash, hash) {
+			return member
+		}
+	}
+	return nil
+}
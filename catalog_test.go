@@ -0,0 +1,257 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/secDre4mer/pkcs7"
+)
+
+// bmpString encodes a string as an ASN.1 BMPString.
+func bmpString(s string) asn1.RawValue {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return asn1.RawValue{Tag: 30, Bytes: b}
+}
+
+// utf16String encodes a string as a null terminated little endian UTF-16
+// string.
+func utf16String(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s + "\x00")) {
+		b = append(b, 0, 0)
+		binary.LittleEndian.PutUint16(b[len(b)-2:], c)
+	}
+	return b
+}
+
+// makeCatalog builds a security catalog listing a single PE with the given
+// Authentihash, signed by a self-signed certificate.
+func makeCatalog(t *testing.T, hash []byte) []byte {
+	mustMarshal := func(v interface{}) []byte {
+		b, err := asn1.Marshal(v)
+		if err != nil {
+			t.Fatalf("asn1.Marshal(%T) failed, reason: %v", v, err)
+		}
+		return b
+	}
+	attribute := func(oid asn1.ObjectIdentifier, v interface{}) catalogAttribute {
+		return catalogAttribute{
+			Type:   oid,
+			Values: []asn1.RawValue{{FullBytes: mustMarshal(v)}},
+		}
+	}
+
+	indirectData := struct {
+		Data          SpcAttributeTypeAndOptionalValue
+		MessageDigest DigestInfo
+	}{
+		Data: SpcAttributeTypeAndOptionalValue{
+			Type: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15},
+			Value: SpcPeImageData{
+				File: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0,
+					IsCompound: true},
+			},
+		},
+		MessageDigest: DigestInfo{
+			DigestAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm: pkcs7.OIDDigestAlgorithmSHA256,
+			},
+			Digest: hash,
+		},
+	}
+	subject := trustedSubject{
+		Identifier: utf16String("PUTTY"),
+		Attributes: []catalogAttribute{
+			attribute(oidCatalogNameValue, catalogNameValue{
+				Tag:   bmpString("File"),
+				Flags: 0x10010001,
+				Value: utf16String("putty.exe"),
+			}),
+			attribute(oidCatalogMemberInfo, catalogMemberInfo{
+				SubjectGUID: bmpString("{C689AAB8-8E78-11D0-8C47-00C04FC295EE}"),
+				CertVersion: 512,
+			}),
+			attribute(oidSpcIndirectData, indirectData),
+		},
+	}
+
+	osAttr := catalogNameValue{
+		Tag:   bmpString("OSAttr"),
+		Flags: 0x10010001,
+		Value: utf16String("2:10.0"),
+	}
+	thisUpdate := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var content []byte
+	content = append(content, mustMarshal([]asn1.ObjectIdentifier{oidCatalogList})...)
+	content = append(content, mustMarshal([]byte("catalog-list-id!"))...)
+	content = append(content, mustMarshal(thisUpdate)...)
+	content = append(content, mustMarshal(pkix.AlgorithmIdentifier{
+		Algorithm: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 12, 1, 2},
+	})...)
+	content = append(content, mustMarshal([]trustedSubject{subject})...)
+	content = append(content, mustMarshal(asn1.RawValue{
+		Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+		Bytes: mustMarshal([]pkix.Extension{{
+			Id:    oidCatalogNameValue,
+			Value: mustMarshal(osAttr),
+		}}),
+	})...)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Catalog Signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed, reason: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	// The signed data holds the trust list instead of plain data, the
+	// signature covers its content octets.
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		t.Fatalf("NewSignedData failed, reason: %v", err)
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	sd.GetSignedData().ContentInfo.ContentType = oidCertificateTrustList
+	sd.GetSignedData().ContentInfo.Content = asn1.RawValue{
+		Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+		Bytes: mustMarshal(asn1.RawValue{
+			Tag: asn1.TagSequence, IsCompound: true, Bytes: content,
+		}),
+	}
+	err = sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{})
+	if err != nil {
+		t.Fatalf("AddSigner failed, reason: %v", err)
+	}
+	data, err := sd.Finish()
+	if err != nil {
+		t.Fatalf("Finish failed, reason: %v", err)
+	}
+	return data
+}
+
+func TestParseCatalog(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(filename, &Options{DisableCertValidation: true})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	defer file.Close()
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	hash := file.AuthentihashExt(crypto.SHA256.New())[0]
+
+	data := makeCatalog(t, hash)
+	catalog, err := ParseCatalog(data, &Options{DisableCertChainValidation: true})
+	if err != nil {
+		t.Fatalf("ParseCatalog failed, reason: %v", err)
+	}
+
+	if !catalog.Verified {
+		t.Errorf("catalog signature verification failed")
+	}
+	if catalog.Signer.Subject != ", Catalog Signer" {
+		t.Errorf("catalog signer assertion failed, got %v", catalog.Signer.Subject)
+	}
+	if string(catalog.ListIdentifier) != "catalog-list-id!" {
+		t.Errorf("list identifier assertion failed, got %q",
+			catalog.ListIdentifier)
+	}
+	wantUpdate := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	if !catalog.ThisUpdate.Equal(wantUpdate) || !catalog.NextUpdate.IsZero() {
+		t.Errorf("update times assertion failed, got %v and %v",
+			catalog.ThisUpdate, catalog.NextUpdate)
+	}
+	wantAttr := CatalogAttribute{"OSAttr", 0x10010001, "2:10.0"}
+	if len(catalog.Attributes) != 1 || catalog.Attributes[0] != wantAttr {
+		t.Errorf("catalog attributes assertion failed, got %v, want %v",
+			catalog.Attributes, wantAttr)
+	}
+
+	if len(catalog.Members) != 1 {
+		t.Fatalf("catalog members count assertion failed, got %v, want 1",
+			len(catalog.Members))
+	}
+	member := catalog.Members[0]
+	if member.Tag != "PUTTY" ||
+		member.SubjectGUID != "{C689AAB8-8E78-11D0-8C47-00C04FC295EE}" ||
+		member.HashFunction != crypto.SHA256 || !bytes.Equal(member.Hash, hash) {
+		t.Errorf("catalog member assertion failed, got %+v", member)
+	}
+	wantAttr = CatalogAttribute{"File", 0x10010001, "putty.exe"}
+	if len(member.Attributes) != 1 || member.Attributes[0] != wantAttr {
+		t.Errorf("member attributes assertion failed, got %v, want %v",
+			member.Attributes, wantAttr)
+	}
+
+	if catalog.Member(hash) == nil || catalog.FindFile(file) == nil {
+		t.Errorf("catalog member lookup failed")
+	}
+
+	// Altering the trust list invalidates the signature.
+	tampered := bytes.Replace(data, []byte("catalog-list-id!"),
+		[]byte("catalog-list-id?"), 1)
+	catalog, err = ParseCatalog(tampered, &Options{DisableCertChainValidation: true})
+	if err != nil {
+		t.Fatalf("ParseCatalog failed, reason: %v", err)
+	}
+	if catalog.Verified {
+		t.Errorf("tampered catalog signature verification succeeded")
+	}
+}
+
+func TestParseCatalogNotCatalog(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+	file, err := NewBytes(data, &Options{DisableCertValidation: true})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	// A PE, and an Authenticode signature which signs indirect data.
+	for _, in := range [][]byte{data, file.Certificates.Raw} {
+		_, err = ParseCatalog(in, nil)
+		if !errors.Is(err, ErrNotCatalog) {
+			t.Errorf("ParseCatalog() got %v, want %v", err, ErrNotCatalog)
+		}
+	}
+}
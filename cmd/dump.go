@@ -7,9 +7,10 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -214,9 +215,93 @@ func parse(filePath string, cfg config) {
 	}
 }
 
+// dumpJSON writes the requested parts of a file to w as a single JSON object,
+// keyed by the JSON names of the File fields holding them.
+func dumpJSON(pe *peparser.File, cfg config, w io.Writer) error {
+	out := make(map[string]interface{})
+	if cfg.wantDOSHeader {
+		out["dos_header"] = pe.DOSHeader
+	}
+	if cfg.wantRichHeader && pe.FileInfo.HasRichHdr {
+		out["rich_header"] = pe.RichHeader
+	}
+	if cfg.wantNTHeader {
+		out["nt_header"] = pe.NtHeader
+	}
+	if cfg.wantCOFF && pe.FileInfo.HasCOFF {
+		out["coff"] = pe.COFF
+	}
+	if cfg.wantDataDirs {
+		out["data_directories"] = pe.Directories()
+	}
+	if cfg.wantSections && pe.FileInfo.HasSections {
+		out["sections"] = pe.Sections
+	}
+	if cfg.wantExport && pe.FileInfo.HasExport {
+		out["export"] = pe.Export
+	}
+	if cfg.wantImport && pe.FileInfo.HasImport {
+		out["imports"] = pe.Imports
+	}
+	if cfg.wantResource && pe.FileInfo.HasResource {
+		out["resources"] = pe.Resources
+	}
+	if cfg.wantException && pe.FileInfo.HasException {
+		out["exceptions"] = pe.Exceptions
+	}
+	if cfg.wantCertificate && pe.FileInfo.HasCertificate {
+		out["certificates"] = pe.Certificates
+	}
+	if cfg.wantReloc && pe.FileInfo.HasReloc {
+		out["relocations"] = pe.Relocations
+	}
+	if cfg.wantDebug && pe.FileInfo.HasDebug {
+		out["debugs"] = pe.Debugs
+	}
+	if cfg.wantTLS && pe.FileInfo.HasTLS {
+		out["tls"] = pe.TLS
+	}
+	if cfg.wantLoadCfg && pe.FileInfo.HasLoadCFG {
+		out["load_config"] = pe.LoadConfig
+	}
+	if cfg.wantBoundImp && pe.FileInfo.HasBoundImp {
+		out["bound_imports"] = pe.BoundImports
+	}
+	if cfg.wantIAT && pe.FileInfo.HasIAT {
+		out["iat"] = pe.IAT
+	}
+	if cfg.wantDelayImp && pe.FileInfo.HasDelayImp {
+		out["delay_imports"] = pe.DelayImports
+	}
+	if cfg.wantCLR && pe.FileInfo.HasCLR {
+		out["clr"] = pe.CLR
+	}
+	if cfg.wantGoBuildInfo {
+		if info, err := pe.ReadGoBuildInfo(); err == nil {
+			out["go_build_info"] = info
+		}
+	}
+
+	// Write the object at once, files of a directory are dumped concurrently.
+	b, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
 func parsePE(filename string, cfg config) {
 
-	logger := log.NewStdLogger(os.Stdout)
+	// Keep stdout parsable when dumping JSON.
+	var logOutput io.Writer = os.Stdout
+	switch {
+	case cfg.quiet:
+		logOutput = ioutil.Discard
+	case cfg.format == "json":
+		logOutput = os.Stderr
+	}
+	logger := log.NewStdLogger(logOutput)
 	logger = log.NewFilter(logger, log.FilterLevel(log.LevelInfo))
 	log := log.NewHelper(logger)
 
@@ -227,6 +312,7 @@ func parsePE(filename string, cfg config) {
 		Logger:                logger,
 		DisableCertValidation: false,
 		Fast:                  false,
+		Strict:                cfg.strict,
 	})
 
 	if err != nil {
@@ -243,13 +329,13 @@ func parsePE(filename string, cfg config) {
 		return
 	}
 
-	// Dump all results to disk in JSON format.
-	// f, err := os.Create("out.json")
-	// if err != nil {
-	// 	return
-	// }
-	// defer f.Close()
-	// f.WriteString(prettyPrint(pe))
+	if cfg.format == "json" {
+		err = dumpJSON(pe, cfg, os.Stdout)
+		if err != nil {
+			log.Errorf("Error while dumping file: %s, reason: %v", filename, err)
+		}
+		return
+	}
 
 	if cfg.wantDOSHeader {
 		DOSHeader := pe.DOSHeader
@@ -570,8 +656,8 @@ func parsePE(filename string, cfg config) {
 		cert := pe.Certificates
 		w := tabwriter.NewWriter(os.Stdout, 1, 1, 3, ' ', tabwriter.AlignRight)
 		fmt.Fprintln(w, "Length\tRevision\tCertificateType\t")
-		fmt.Fprintf(w, "0x%x\t0x%x\t0x%x\t\n", cert.Header.Length, cert.Header.Revision,
-			cert.Header.CertificateType)
+		fmt.Fprintf(w, "0x%x\t0x%x\t0x%x (%s)\t\n", cert.Header.Length, cert.Header.Revision,
+			uint16(cert.Header.CertificateType), cert.Header.CertificateType)
 		w.Flush()
 		fmt.Print("\n   ---Raw Certificate dump---\n")
 		hexDump(cert.Raw)
@@ -649,8 +735,9 @@ func parsePE(filename string, cfg config) {
 			case peparser.ImageDebugTypePOGO:
 				pogo := debug.Info.(peparser.POGO)
 				if len(pogo.Entries) > 0 {
-					fmt.Fprintf(w, "Signature:\t 0x%x (%s)\n\n", pogo.Signature,
+					fmt.Fprintf(w, "Signature:\t 0x%x (%s)\n", pogo.Signature,
 						pogo.Signature.String())
+					fmt.Fprintf(w, "Build:\t %s\n\n", pogo.Signature.Description())
 					fmt.Fprintln(w, "RVA\tSize\tName\tDescription\t")
 					fmt.Fprintln(w, "---\t----\t----\t-----------\t")
 					for _, pogoEntry := range pogo.Entries {
@@ -663,6 +750,7 @@ func parsePE(filename string, cfg config) {
 				repro := debug.Info.(peparser.REPRO)
 				fmt.Fprintf(w, "Hash:\t %x\n", repro.Hash)
 				fmt.Fprintf(w, "Size:\t 0x%x (%s)\n", repro.Size, BytesSize(float64(repro.Size)))
+				fmt.Fprintf(w, "Reproducible Build:\t %v\n", pe.IsReproducibleBuild())
 			case peparser.ImageDebugTypeExDllCharacteristics:
 				exDllCharacteristics := debug.Info.(peparser.DllCharacteristicsExType)
 				fmt.Fprintf(w, "Value:\t %d (%s)\n", exDllCharacteristics,
@@ -808,6 +896,17 @@ func parsePE(filename string, cfg config) {
 		fmt.Fprintf(w, "Managed Native Header Size:\t 0x%x (%s)\n", clrHdr.ManagedNativeHeader.Size, BytesSize(float64(clrHdr.ManagedNativeHeader.Size)))
 		w.Flush()
 
+		if len(clr.ExportAddressTableJumps) > 0 {
+			fmt.Print("\n   ---Export Address Table Jumps dump---\n")
+			hexDump(clr.ExportAddressTableJumps)
+		}
+		if clr.ReadyToRun {
+			fmt.Print("\n   Managed Native Header is a ReadyToRun header\n")
+		} else if len(clr.ManagedNativeHeader) > 0 {
+			fmt.Print("\n   ---Managed Native Header dump---\n")
+			hexDump(clr.ManagedNativeHeader)
+		}
+
 		fmt.Print("\n\t------[ MetaData Header ]------\n\n")
 		mdHdr := clr.MetadataHeader
 		fmt.Fprintf(w, "Signature:\t 0x%x (%s)\n", mdHdr.Signature,
@@ -857,8 +956,10 @@ func parsePE(filename string, cfg config) {
 				modTableRows := modTable.Content.([]peparser.ModuleTableRow)
 				for _, modTableRow := range modTableRows {
 					modName := pe.GetStringFromData(modTableRow.Name, pe.CLR.MetadataStreams["#Strings"])
-					Mvid := pe.GetStringFromData(modTableRow.Mvid, pe.CLR.MetadataStreams["#GUID"])
-					MvidStr := hex.EncodeToString(Mvid)
+					var MvidStr string
+					if Mvid, err := pe.CLR.GUIDAt(modTableRow.Mvid); err == nil {
+						MvidStr = Mvid.String()
+					}
 					fmt.Fprintf(w, "Generation:\t 0x%x\n", modTableRow.Generation)
 					fmt.Fprintf(w, "Name:\t 0x%x (%s)\n", modTableRow.Name, string(modName))
 					fmt.Fprintf(w, "Mvid:\t 0x%x (%s)\n", modTableRow.Mvid, MvidStr)
@@ -871,6 +972,25 @@ func parsePE(filename string, cfg config) {
 		}
 	}
 
+	if cfg.wantGoBuildInfo {
+		info, err := pe.ReadGoBuildInfo()
+		if err == nil {
+			fmt.Printf("\nGO BUILD INFO\n**************\n\n")
+			w := tabwriter.NewWriter(os.Stdout, 1, 1, 3, ' ', tabwriter.AlignRight)
+			fmt.Fprintf(w, "Build ID:\t %s\n", info.BuildID)
+			fmt.Fprintf(w, "Go Version:\t %s\n", info.GoVersion)
+			fmt.Fprintf(w, "Path:\t %s\n", info.Path)
+			fmt.Fprintf(w, "Main Module:\t %s %s\n", info.Main.Path, info.Main.Version)
+			for _, dep := range info.Deps {
+				fmt.Fprintf(w, "Dependency:\t %s %s\n", dep.Path, dep.Version)
+			}
+			for _, setting := range info.Settings {
+				fmt.Fprintf(w, "Setting:\t %s=%s\n", setting.Key, setting.Value)
+			}
+			w.Flush()
+		}
+	}
+
 	// Get file type.
 	if pe.IsEXE() {
 		log.Debug("File is Exe")
@@ -0,0 +1,85 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	peparser "github.com/saferwall/pe"
+)
+
+func TestDumpJSON(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		cfg  config
+		keys []string
+	}{
+		{
+			"../test/putty.exe",
+			config{wantDOSHeader: true, wantImport: true, format: "json"},
+			[]string{"dos_header", "imports"},
+		},
+		{
+			"../test/mscorlib.dll",
+			config{wantCLR: true, format: "json"},
+			[]string{"clr"},
+		},
+		{
+			// putty.exe is not a .NET assembly.
+			"../test/putty.exe",
+			config{wantCLR: true, format: "json"},
+			[]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			pe, err := peparser.New(tt.in, &peparser.Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			defer pe.Close()
+			err = pe.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			var buf bytes.Buffer
+			err = dumpJSON(pe, tt.cfg, &buf)
+			if err != nil {
+				t.Fatalf("dumpJSON(%s) failed, reason: %v", tt.in, err)
+			}
+
+			var out map[string]json.RawMessage
+			err = json.Unmarshal(buf.Bytes(), &out)
+			if err != nil {
+				t.Fatalf("json.Unmarshal() failed, reason: %v", err)
+			}
+			keys := []string{}
+			for key := range out {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("JSON keys assertion failed, got %v, want %v", keys, tt.keys)
+			}
+
+			if tt.cfg.wantImport {
+				var imports []struct {
+					Name string `json:"name"`
+				}
+				err = json.Unmarshal(out["imports"], &imports)
+				if err != nil || len(imports) == 0 || imports[0].Name != "GDI32.dll" {
+					t.Errorf("imports assertion failed, got %v, err %v", imports, err)
+				}
+			}
+		})
+	}
+}
@@ -31,6 +31,14 @@ type config struct {
 	wantIAT         bool
 	wantDelayImp    bool
 	wantCLR         bool
+	wantGoBuildInfo bool
+	strict          bool
+
+	// format is the output format, text or json.
+	format string
+
+	// quiet drops the log lines, for scripting.
+	quiet bool
 }
 
 func main() {
@@ -55,6 +63,21 @@ func main() {
 	dumpIAT := dumpCmd.Bool("iat", false, "Dump IAT")
 	dumpDelayedImport := dumpCmd.Bool("delay", false, "Dump delay import descriptor")
 	dumpCLR := dumpCmd.Bool("clr", false, "Dump CLR")
+	dumpGoBuildInfo := dumpCmd.Bool("gobuildinfo", false, "Dump Go build info")
+	strict := dumpCmd.Bool("strict", false, "Abort on PE specification violations")
+	dumpFormat := dumpCmd.String("format", "text", "Output format, text or json")
+	dumpQuiet := dumpCmd.Bool("quiet", false, "Do not print the log lines")
+
+	verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
+	verifyNoChain := verifyCmd.Bool("nochain", false,
+		"Do not build the certificate chain of trust")
+	verifyRevocation := verifyCmd.Bool("revocation", false,
+		"Check the revocation status of the certificates (network access)")
+
+	stringsCmd := flag.NewFlagSet("strings", flag.ExitOnError)
+	stringsMinLen := stringsCmd.Int("n", 4, "Minimum length of the strings")
+	stringsASCII := stringsCmd.Bool("ascii", false, "Extract ASCII strings only")
+	stringsUTF16 := stringsCmd.Bool("utf16", false, "Extract UTF-16 strings only")
 
 	verCmd := flag.NewFlagSet("version", flag.ExitOnError)
 
@@ -87,6 +110,15 @@ func main() {
 			wantIAT:         *dumpIAT,
 			wantDelayImp:    *dumpDelayedImport,
 			wantCLR:         *dumpCLR,
+			wantGoBuildInfo: *dumpGoBuildInfo,
+			strict:          *strict,
+			format:          *dumpFormat,
+			quiet:           *dumpQuiet,
+		}
+		if cfg.format != "text" && cfg.format != "json" {
+			fmt.Printf("Unknown output format: %s, expected text or json\n",
+				cfg.format)
+			os.Exit(1)
 		}
 
 		// Start as many workers you want, default to cpu count -1.
@@ -104,6 +136,29 @@ func main() {
 			wg.Wait()
 		}
 
+	case "verify":
+		if len(os.Args) < 3 {
+			showHelp()
+		}
+		verifyCmd.Parse(os.Args[3:])
+
+		verify(os.Args[2], verifyConfig{
+			noChain:    *verifyNoChain,
+			revocation: *verifyRevocation,
+		})
+
+	case "strings":
+		if len(os.Args) < 3 {
+			showHelp()
+		}
+		stringsCmd.Parse(os.Args[3:])
+
+		extractStrings(os.Args[2], stringsConfig{
+			minLen: *stringsMinLen,
+			ascii:  *stringsASCII,
+			utf16:  *stringsUTF16,
+		})
+
 	case "version":
 		verCmd.Parse(os.Args[2:])
 		fmt.Println("You are using version 1.3.0")
@@ -122,7 +177,7 @@ func showHelp() {
 	A PE-Parser built for speed and malware-analysis in mind.
 	Brought to you by Saferwall (c) 2018 MIT
 `)
-	fmt.Println("\nAvailable sub-commands 'dump' or 'version' subcommands")
+	fmt.Println("\nAvailable sub-commands 'dump', 'verify', 'strings' or 'version' subcommands")
 
 	os.Exit(1)
 }
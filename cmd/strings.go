@@ -0,0 +1,70 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	peparser "github.com/saferwall/pe"
+)
+
+type stringsConfig struct {
+	minLen int
+	ascii  bool
+	utf16  bool
+}
+
+// dumpStrings writes the strings of a file to w, one per line with the file
+// offset, the RVA and the section they lie in.
+func dumpStrings(filename string, cfg stringsConfig, w io.Writer) error {
+
+	pe, err := peparser.New(filename, &peparser.Options{Fast: true})
+	if err != nil {
+		return err
+	}
+	defer pe.Close()
+
+	err = pe.Parse()
+	if err != nil {
+		return err
+	}
+
+	var kinds peparser.StringKind
+	if cfg.ascii {
+		kinds |= peparser.StringASCII
+	}
+	if cfg.utf16 {
+		kinds |= peparser.StringUTF16
+	}
+
+	tw := tabwriter.NewWriter(w, 1, 1, 2, ' ', 0)
+	fmt.Fprintln(tw, "Offset\tRVA\tSection\tKind\tValue")
+	fmt.Fprintln(tw, "------\t---\t-------\t----\t-----")
+	for _, s := range pe.Strings(cfg.minLen, kinds) {
+		rva := "-"
+		if s.RVA != 0 || s.Offset == 0 {
+			rva = fmt.Sprintf("0x%x", s.RVA)
+		}
+		section := s.Section
+		if section == "" {
+			section = "-"
+		}
+		fmt.Fprintf(tw, "0x%x\t%s\t%s\t%s\t%q\n", s.Offset, rva, section,
+			s.Kind, s.Value)
+	}
+	return tw.Flush()
+}
+
+func extractStrings(filename string, cfg stringsConfig) {
+	err := dumpStrings(filename, cfg, os.Stdout)
+	if err != nil {
+		fmt.Printf("Error while extracting strings from file: %s, reason: %v\n",
+			filename, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"time"
+
+	peparser "github.com/saferwall/pe"
+	"github.com/secDre4mer/pkcs7"
+)
+
+type verifyConfig struct {
+	noChain    bool
+	revocation bool
+}
+
+var (
+	// oidCounterSignature is the unsigned attribute holding a legacy
+	// Authenticode timestamp.
+	oidCounterSignature = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+
+	// oidRFC3161Timestamp is the unsigned attribute holding an RFC 3161
+	// timestamp token.
+	oidRFC3161Timestamp = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}
+)
+
+// attribute is an authenticated or unauthenticated attribute of a SignerInfo.
+type attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+// counterSignerInfo is the part of the SignerInfo of a legacy countersignature
+// needed to read its signing time.
+type counterSignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     asn1.RawValue
+	DigestAlgorithm           asn1.RawValue
+	AuthenticatedAttributes   []attribute `asn1:"optional,omitempty,tag:0"`
+	DigestEncryptionAlgorithm asn1.RawValue
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes []attribute `asn1:"optional,omitempty,tag:1"`
+}
+
+// timestampToken is the ContentInfo of an RFC 3161 timestamp token, decoded
+// down to the TSTInfo it signs. The certificates it embeds are not parsed, as
+// some of them are rejected by crypto/x509.
+type timestampToken struct {
+	ContentType asn1.ObjectIdentifier
+	SignedData  struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		EncapContentInfo struct {
+			EContentType asn1.ObjectIdentifier
+			EContent     []byte `asn1:"explicit,tag:0"`
+		}
+		Certificates asn1.RawValue `asn1:"optional,tag:0"`
+		CRLs         asn1.RawValue `asn1:"optional,tag:1"`
+		SignerInfos  asn1.RawValue
+	} `asn1:"explicit,tag:0"`
+}
+
+// tstInfo is the beginning of the TSTInfo structure of an RFC 3161 timestamp
+// token.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint asn1.RawValue
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+}
+
+// verifyPE verifies the Authenticode signatures and the checksum of a file,
+// writes a report to w and tells whether all the checks passed.
+func verifyPE(filename string, cfg verifyConfig, w io.Writer) bool {
+
+	pe, err := peparser.New(filename, &peparser.Options{
+		DisableCertChainValidation: cfg.noChain,
+		CertRevocationCheck:        cfg.revocation,
+	})
+	if err != nil {
+		fmt.Fprintf(w, "Error while opening file: %s, reason: %v\n", filename, err)
+		return false
+	}
+	defer pe.Close()
+
+	err = pe.Parse()
+	if err != nil {
+		fmt.Fprintf(w, "Error while parsing file: %s, reason: %v\n", filename, err)
+		return false
+	}
+
+	ok := true
+	fmt.Fprintf(w, "File: %s\n", filename)
+
+	if !pe.IsSigned {
+		fmt.Fprintln(w, "Signature: not signed")
+		ok = false
+	}
+
+	for i, cert := range pe.Certificates.Certificates {
+		fmt.Fprintf(w, "\nSignature #%d:\n", i+1)
+		fmt.Fprintf(w, "\tSigner: %s\n", cert.Info.Subject)
+		fmt.Fprintf(w, "\tIssuer: %s\n", cert.Info.Issuer)
+		fmt.Fprintf(w, "\tSerial Number: %s\n", cert.Info.SerialNumber)
+		fmt.Fprintf(w, "\tValidity: %s - %s\n",
+			cert.Info.NotBefore.Format(time.RFC3339),
+			cert.Info.NotAfter.Format(time.RFC3339))
+
+		fmt.Fprintln(w, "\tChain:")
+		for depth, c := range signerChain(&cert.Content) {
+			fmt.Fprintf(w, "\t\t%d: %s\n", depth, c.Subject.String())
+		}
+
+		var signingTime time.Time
+		if cert.Content.UnmarshalSignedAttribute(pkcs7.OIDAttributeSigningTime,
+			&signingTime) == nil {
+			fmt.Fprintf(w, "\tSigning Time: %s\n", signingTime.Format(time.RFC3339))
+		}
+		if ts, kind, found := timestamp(&cert.Content); found {
+			fmt.Fprintf(w, "\tTimestamp (%s): %s\n", kind, ts.Format(time.RFC3339))
+		} else {
+			fmt.Fprintln(w, "\tTimestamp: none")
+		}
+
+		content := cert.SignatureContent
+		if content.HashFunction.Available() {
+			computed := pe.AuthentihashExt(content.HashFunction.New())[0]
+			fmt.Fprintf(w, "\tAuthentihash (%s):\n", content.HashFunction)
+			fmt.Fprintf(w, "\t\tSigned:   %x\n", content.HashResult)
+			fmt.Fprintf(w, "\t\tComputed: %x\n", computed)
+		}
+
+		fmt.Fprintf(w, "\tSignature Valid: %v\n", cert.SignatureValid)
+		fmt.Fprintf(w, "\tCertificate Verified: %v\n", cert.Verified)
+		if cfg.revocation {
+			fmt.Fprintf(w, "\tRevocation: %s\n", cert.Revocation)
+		}
+		if !cert.SignatureValid || !cert.Verified {
+			ok = false
+		}
+	}
+
+	var checksum uint32
+	switch pe.Is64 {
+	case true:
+		checksum = pe.NtHeader.OptionalHeader.(peparser.ImageOptionalHeader64).CheckSum
+	case false:
+		checksum = pe.NtHeader.OptionalHeader.(peparser.ImageOptionalHeader32).CheckSum
+	}
+
+	// A null checksum is not set, which is allowed for user-mode images.
+	computed := pe.Checksum()
+	switch {
+	case checksum == 0:
+		fmt.Fprintf(w, "\nChecksum: not set, computed 0x%x\n", computed)
+	case checksum == computed:
+		fmt.Fprintf(w, "\nChecksum: 0x%x valid\n", checksum)
+	default:
+		fmt.Fprintf(w, "\nChecksum: 0x%x invalid, computed 0x%x\n", checksum,
+			computed)
+		ok = false
+	}
+
+	if ok {
+		fmt.Fprintln(w, "\nResult: OK")
+	} else {
+		fmt.Fprintln(w, "\nResult: FAILED")
+	}
+	return ok
+}
+
+// signerChain returns the certificates of the signature from the signer to
+// the last issuer found among the embedded certificates.
+func signerChain(p7 *pkcs7.PKCS7) []*x509.Certificate {
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return nil
+	}
+
+	chain := []*x509.Certificate{signer}
+	for cert := signer; !bytes.Equal(cert.RawIssuer, cert.RawSubject); {
+		var issuer *x509.Certificate
+		for _, c := range p7.Certificates {
+			if bytes.Equal(c.RawSubject, cert.RawIssuer) &&
+				cert.CheckSignatureFrom(c) == nil {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil || len(chain) > len(p7.Certificates) {
+			break
+		}
+		chain = append(chain, issuer)
+		cert = issuer
+	}
+	return chain
+}
+
+// timestamp returns the time of the RFC 3161 timestamp or of the legacy
+// countersignature of a signature.
+func timestamp(p7 *pkcs7.PKCS7) (time.Time, string, bool) {
+
+	var token asn1.RawValue
+	if p7.UnmarshalUnsignedAttribute(oidRFC3161Timestamp, &token) == nil {
+		var tst timestampToken
+		_, err := asn1.Unmarshal(token.FullBytes, &tst)
+		if err == nil {
+			var info tstInfo
+			eContent := tst.SignedData.EncapContentInfo.EContent
+			if _, err = asn1.Unmarshal(eContent, &info); err == nil {
+				return info.GenTime, "RFC 3161", true
+			}
+		}
+	}
+
+	var counterSigner counterSignerInfo
+	if p7.UnmarshalUnsignedAttribute(oidCounterSignature, &counterSigner) == nil {
+		for _, attr := range counterSigner.AuthenticatedAttributes {
+			if !attr.Type.Equal(pkcs7.OIDAttributeSigningTime) {
+				continue
+			}
+			var t time.Time
+			if _, err := asn1.Unmarshal(attr.Value.Bytes, &t); err == nil {
+				return t, "Countersignature", true
+			}
+		}
+	}
+
+	return time.Time{}, "", false
+}
+
+func verify(filename string, cfg verifyConfig) {
+	if !verifyPE(filename, cfg, os.Stdout) {
+		os.Exit(1)
+	}
+}
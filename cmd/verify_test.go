@@ -0,0 +1,75 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVerifyPE(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		ok   bool
+		want []string
+	}{
+		{
+			"../test/putty.exe",
+			true,
+			[]string{
+				"Timestamp (Countersignature): 2019-09-22T09:32:50Z",
+				"Computed: 8be7d65593b0fff2e8b29004640261b8a0d4fcc651a14cd0b8b702b7928f8ee0",
+				"1: CN=COMODO RSA Code Signing CA",
+				"Checksum: 0x122c22 valid",
+				"Result: OK",
+			},
+		},
+		{
+			"../test/putty_modified.exe",
+			false,
+			[]string{
+				"Signature Valid: false",
+				"Checksum: 0x122c22 invalid, computed 0x121ffc",
+				"Result: FAILED",
+			},
+		},
+		{
+			"../test/kernel32.dll",
+			true,
+			[]string{
+				"Timestamp (RFC 3161): 2021-11-02T06:43:45Z",
+				"Result: OK",
+			},
+		},
+		{
+			"../test/arp.dll",
+			false,
+			[]string{
+				"Signature: not signed",
+				"Checksum: not set",
+				"Result: FAILED",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var out bytes.Buffer
+			ok := verifyPE(tt.in, verifyConfig{noChain: true}, &out)
+			if ok != tt.ok {
+				t.Errorf("verify(%s) assertion failed, got %v, want %v, output:\n%s",
+					tt.in, ok, tt.ok, out.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("verify(%s) output does not contain %q, output:\n%s",
+						tt.in, want, out.String())
+				}
+			}
+		})
+	}
+}
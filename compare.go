@@ -0,0 +1,393 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// compareCodeSize is the number of bytes compared at the entry point.
+const compareCodeSize = 32
+
+// IATChangeKind tells how an IAT slot of a dumped image differs from the
+// original file.
+type IATChangeKind int
+
+const (
+	// IATSlotRedirected is a slot pointing inside the dumped image, to a
+	// hook or a trampoline, instead of the imported function.
+	IATSlotRedirected IATChangeKind = iota + 1
+
+	// IATSlotImportChanged is a slot importing another function than the
+	// one of the original file.
+	IATSlotImportChanged
+
+	// IATSlotAdded is a non null slot absent from the IAT of the original
+	// file.
+	IATSlotAdded
+
+	// IATSlotRemoved is a slot of the original file absent from the IAT of
+	// the dumped image.
+	IATSlotRemoved
+)
+
+// String stringifies the IAT change kind.
+func (k IATChangeKind) String() string {
+	iatChangeKindMap := map[IATChangeKind]string{
+		IATSlotRedirected:    "Redirected",
+		IATSlotImportChanged: "Import Changed",
+		IATSlotAdded:         "Added",
+		IATSlotRemoved:       "Removed",
+	}
+
+	if v, ok := iatChangeKindMap[k]; ok {
+		return v
+	}
+	return "?"
+}
+
+// FieldChange is a header field whose value differs between the original
+// file and the dumped image.
+type FieldChange struct {
+	// The name of the field, such as `OptionalHeader.AddressOfEntryPoint`.
+	Field string `json:"field"`
+
+	Original uint64 `json:"original"`
+	Dumped   uint64 `json:"dumped"`
+}
+
+// SectionChange is a section of the original file whose header or code was
+// modified in the dumped image. Sections are matched by virtual address.
+type SectionChange struct {
+	Name           string `json:"name"`
+	VirtualAddress uint32 `json:"virtual_address"`
+
+	// The name of the section in the dumped image, when it was renamed.
+	DumpedName string `json:"dumped_name,omitempty"`
+
+	// The header fields which differ, the raw data pointer and size are not
+	// compared as they follow the layout of each representation.
+	Fields []FieldChange `json:"fields,omitempty"`
+
+	// CodeModified is true when the mapped content of an executable section
+	// differs. Relocations applied by the loader, when the image is mapped
+	// at another base address, also modify the code.
+	CodeModified bool `json:"code_modified"`
+}
+
+// EntryPointChange describes how the entry point of the dumped image
+// differs from the original file.
+type EntryPointChange struct {
+	// AddressModified is true when the AddressOfEntryPoint differs, as when
+	// the original entry point of a packed file is restored.
+	AddressModified bool `json:"address_modified"`
+
+	// CodeModified is true when the first bytes of code at the entry point
+	// of the original file are patched in the dumped image.
+	CodeModified bool `json:"code_modified"`
+
+	// The code at the entry point of each image.
+	Original CodeBytes `json:"original"`
+	Dumped   CodeBytes `json:"dumped"`
+}
+
+// IATChange is an IAT slot of the dumped image which differs from the
+// original file.
+type IATChange struct {
+	Kind IATChangeKind `json:"kind"`
+	RVA  uint32        `json:"rva"`
+
+	// The module and the function imported by the slot in the original file,
+	// or in the dumped image for added slots.
+	Module   string `json:"module,omitempty"`
+	Function string `json:"function,omitempty"`
+
+	// The module and the function imported by the slot in the dumped image,
+	// when they changed.
+	DumpedModule   string `json:"dumped_module,omitempty"`
+	DumpedFunction string `json:"dumped_function,omitempty"`
+
+	// The value of the slot in the dumped image.
+	Value uint64 `json:"value"`
+}
+
+// Comparison is the result of comparing an image dumped from memory with
+// the file it was loaded from.
+type Comparison struct {
+	// The file and optional header fields which differ.
+	Headers []FieldChange `json:"headers,omitempty"`
+
+	// The sections of the dumped image which do not exist in the original
+	// file, typically injected code or an unpacking stub.
+	InjectedSections []Section `json:"injected_sections,omitempty"`
+
+	// The sections of the original file which are missing from the dumped
+	// image.
+	RemovedSections []Section `json:"removed_sections,omitempty"`
+
+	// The sections whose header or code differ.
+	ModifiedSections []SectionChange `json:"modified_sections,omitempty"`
+
+	// The entry point change, nil when the entry point is untouched.
+	EntryPoint *EntryPointChange `json:"entry_point,omitempty"`
+
+	// The IAT slots which differ.
+	IAT []IATChange `json:"iat,omitempty"`
+}
+
+// Modified tells whether the dumped image differs from the original file.
+func (c *Comparison) Modified() bool {
+	return len(c.Headers) > 0 || len(c.InjectedSections) > 0 ||
+		len(c.RemovedSections) > 0 || len(c.ModifiedSections) > 0 ||
+		c.EntryPoint != nil || len(c.IAT) > 0
+}
+
+// Compare compares an image dumped from memory with the file it was loaded
+// from, highlighting the header changes, the injected sections, the
+// modified entry point and the IAT modifications. The dumped image is
+// usually parsed with the MappedImage option. Both files must be parsed
+// before calling Compare.
+//
+// The IAT slots of a dumped image hold the addresses of the imported
+// functions resolved by the loader, they are only reported when they point
+// inside the image itself or import another function.
+func Compare(original, dumped *File) *Comparison {
+	c := Comparison{}
+	c.Headers = compareHeaders(original, dumped)
+	original.compareSections(dumped, &c)
+	c.EntryPoint = original.compareEntryPoint(dumped)
+	c.IAT = original.compareIAT(dumped)
+	return &c
+}
+
+// compareHeaders compares the file header and the fields of the optional
+// header found in both images, which can be of different bitness.
+func compareHeaders(original, dumped *File) []FieldChange {
+	var changes []FieldChange
+	changes = compareFields("FileHeader",
+		reflect.ValueOf(original.NtHeader.FileHeader),
+		reflect.ValueOf(dumped.NtHeader.FileHeader), changes)
+
+	if original.NtHeader.OptionalHeader != nil &&
+		dumped.NtHeader.OptionalHeader != nil {
+		changes = compareFields("OptionalHeader",
+			reflect.ValueOf(original.NtHeader.OptionalHeader),
+			reflect.ValueOf(dumped.NtHeader.OptionalHeader), changes)
+	}
+	return changes
+}
+
+// compareFields appends the integer fields of two structures which differ.
+// Fields are matched by name, the data directories by entry.
+func compareFields(prefix string, a, b reflect.Value,
+	changes []FieldChange) []FieldChange {
+
+	for i := 0; i < a.NumField(); i++ {
+		name := a.Type().Field(i).Name
+		fa := a.Field(i)
+		fb := b.FieldByName(name)
+		if !fb.IsValid() {
+			continue
+		}
+
+		if dirs, ok := fa.Interface().([16]DataDirectory); ok {
+			dumpedDirs := fb.Interface().([16]DataDirectory)
+			for entry := range dirs {
+				dirName := fmt.Sprintf("%s.%s[%s]", prefix, name,
+					ImageDirectoryEntry(entry))
+				changes = compareFields(dirName, reflect.ValueOf(dirs[entry]),
+					reflect.ValueOf(dumpedDirs[entry]), changes)
+			}
+			continue
+		}
+
+		va, okA := fieldUint(fa)
+		vb, okB := fieldUint(fb)
+		if okA && okB && va != vb {
+			changes = append(changes, FieldChange{
+				Field:    prefix + "." + name,
+				Original: va,
+				Dumped:   vb,
+			})
+		}
+	}
+	return changes
+}
+
+// fieldUint returns the value of an unsigned integer field.
+func fieldUint(v reflect.Value) (uint64, bool) {
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), true
+	}
+	return 0, false
+}
+
+// compareSections matches the sections of both images by virtual address.
+func (pe *File) compareSections(dumped *File, c *Comparison) {
+	originals := make(map[uint32]*Section)
+	for i := range pe.Sections {
+		originals[pe.Sections[i].Header.VirtualAddress] = &pe.Sections[i]
+	}
+
+	matched := make(map[uint32]bool)
+	for i := range dumped.Sections {
+		section := &dumped.Sections[i]
+		va := section.Header.VirtualAddress
+		original, ok := originals[va]
+		if !ok {
+			c.InjectedSections = append(c.InjectedSections, *section)
+			continue
+		}
+		matched[va] = true
+
+		change := SectionChange{Name: original.String(), VirtualAddress: va}
+		if original.String() != section.String() {
+			change.DumpedName = section.String()
+		}
+		if original.Header.VirtualSize != section.Header.VirtualSize {
+			change.Fields = append(change.Fields, FieldChange{
+				Field:    "VirtualSize",
+				Original: uint64(original.Header.VirtualSize),
+				Dumped:   uint64(section.Header.VirtualSize),
+			})
+		}
+		if original.Header.Characteristics != section.Header.Characteristics {
+			change.Fields = append(change.Fields, FieldChange{
+				Field:    "Characteristics",
+				Original: uint64(original.Header.Characteristics),
+				Dumped:   uint64(section.Header.Characteristics),
+			})
+		}
+
+		if original.Header.Characteristics&ImageSectionMemExecute != 0 {
+			size := original.Header.VirtualSize
+			if size == 0 {
+				size = original.Header.SizeOfRawData
+			}
+			a, errA := pe.GetMappedData(va, size)
+			b, errB := dumped.GetMappedData(va, size)
+			change.CodeModified = errA == nil && errB == nil &&
+				!bytes.Equal(a, b)
+		}
+
+		if change.DumpedName != "" || len(change.Fields) > 0 ||
+			change.CodeModified {
+			c.ModifiedSections = append(c.ModifiedSections, change)
+		}
+	}
+
+	for i := range pe.Sections {
+		if !matched[pe.Sections[i].Header.VirtualAddress] {
+			c.RemovedSections = append(c.RemovedSections, pe.Sections[i])
+		}
+	}
+}
+
+// compareEntryPoint compares the address and the first bytes of code of the
+// entry point of both images.
+func (pe *File) compareEntryPoint(dumped *File) *EntryPointChange {
+	original, err := pe.EntryPointBytes(compareCodeSize)
+	if err != nil {
+		return nil
+	}
+	dumpedCode, err := dumped.EntryPointBytes(compareCodeSize)
+	if err != nil {
+		return nil
+	}
+
+	change := EntryPointChange{Original: original, Dumped: dumpedCode}
+	change.AddressModified = original.RVA != dumpedCode.RVA
+
+	// The code at the original entry point, as found in the dumped image.
+	code := dumpedCode
+	if change.AddressModified {
+		code, err = dumped.codeBytes(original.RVA, compareCodeSize)
+	}
+	change.CodeModified = err == nil && !bytes.Equal(original.Mapped, code.Mapped)
+
+	if !change.AddressModified && !change.CodeModified {
+		return nil
+	}
+	return &change
+}
+
+// compareIAT compares the IAT slots of both images by address.
+func (pe *File) compareIAT(dumped *File) []IATChange {
+	sizeOfImage := dumped.sizeOfImage()
+	inImage := func(va uint64) bool {
+		rva, err := dumped.VAToRVA(va)
+		return err == nil && rva < sizeOfImage
+	}
+
+	originals := make(map[uint32]*IATEntry)
+	for i := range pe.IAT {
+		originals[pe.IAT[i].Rva] = &pe.IAT[i]
+	}
+
+	var changes []IATChange
+	seen := make(map[uint32]bool)
+	for _, entry := range dumped.IAT {
+		value := iatValue(entry.Value)
+		seen[entry.Rva] = true
+		original, ok := originals[entry.Rva]
+		if !ok {
+			if value != 0 {
+				changes = append(changes, IATChange{
+					Kind:     IATSlotAdded,
+					RVA:      entry.Rva,
+					Module:   entry.Module,
+					Function: entry.Function,
+					Value:    value,
+				})
+			}
+			continue
+		}
+
+		change := IATChange{
+			RVA:      entry.Rva,
+			Module:   original.Module,
+			Function: original.Function,
+			Value:    value,
+		}
+		switch {
+		case entry.Function != "" &&
+			(entry.Module != original.Module || entry.Function != original.Function):
+			change.Kind = IATSlotImportChanged
+			change.DumpedModule = entry.Module
+			change.DumpedFunction = entry.Function
+		case value != iatValue(original.Value) && inImage(value):
+			change.Kind = IATSlotRedirected
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	for _, entry := range pe.IAT {
+		if !seen[entry.Rva] && entry.Function != "" {
+			changes = append(changes, IATChange{
+				Kind:     IATSlotRemoved,
+				RVA:      entry.Rva,
+				Module:   entry.Module,
+				Function: entry.Function,
+			})
+		}
+	}
+	return changes
+}
+
+// iatValue returns the value of an IAT slot, whatever its size.
+func iatValue(v interface{}) uint64 {
+	switch v := v.(type) {
+	case uint64:
+		return v
+	case uint32:
+		return uint64(v)
+	}
+	return 0
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+
+	filePath := getAbsoluteFilePath("test/putty.exe")
+	original, err := New(filePath, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filePath, err)
+	}
+	defer original.Close()
+	err = original.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filePath, err)
+	}
+
+	var buf bytes.Buffer
+	err = original.WriteMappedImage(&buf)
+	if err != nil {
+		t.Fatalf("WriteMappedImage(%s) failed, reason: %v", filePath, err)
+	}
+	image := buf.Bytes()
+
+	// The image mapped as is does not differ from the file.
+	dumped, err := NewBytes(image, &Options{MappedImage: true})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filePath, err)
+	}
+	err = dumped.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filePath, err)
+	}
+	if c := Compare(original, dumped); c.Modified() {
+		t.Fatalf("Compare(%s) of the mapped image got %+v", filePath, c)
+	}
+
+	// Inject a section after the last one, patch the entry point and hook
+	// the first IAT slot.
+	oh64 := original.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+	ntHeader := original.DOSHeader.AddressOfNewEXEHeader
+	sizeOfImageOffset := ntHeader + 4 + 20 + 56
+	lastHeader := original.Sections[len(original.Sections)-1].headerOffset
+	injected := ImageSectionHeader{
+		Name:            [8]uint8{'.', 'i', 'n', 'j'},
+		VirtualSize:     0x1000,
+		VirtualAddress:  oh64.SizeOfImage,
+		SizeOfRawData:   0x1000,
+		Characteristics: ImageSectionMemExecute | ImageSectionMemRead,
+	}
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, injected)
+	copy(image[lastHeader+40:], header.Bytes())
+	binary.LittleEndian.PutUint16(image[ntHeader+6:],
+		uint16(len(original.Sections)+1))
+	binary.LittleEndian.PutUint32(image[sizeOfImageOffset:],
+		oh64.SizeOfImage+0x1000)
+	image = append(image, make([]byte, 0x1000)...)
+	image[oh64.AddressOfEntryPoint] = 0xe9
+	hook := oh64.ImageBase + uint64(oh64.SizeOfImage)
+	binary.LittleEndian.PutUint64(image[original.IAT[0].Rva:], hook)
+
+	dumped, err = NewBytes(image, &Options{MappedImage: true})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filePath, err)
+	}
+	err = dumped.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filePath, err)
+	}
+	c := Compare(original, dumped)
+
+	wantHeaders := []FieldChange{
+		{"FileHeader.NumberOfSections", 8, 9},
+		{"OptionalHeader.SizeOfImage", uint64(oh64.SizeOfImage),
+			uint64(oh64.SizeOfImage) + 0x1000},
+	}
+	if len(c.Headers) != len(wantHeaders) {
+		t.Fatalf("headers changes assertion failed, got %v, want %v",
+			c.Headers, wantHeaders)
+	}
+	for i, want := range wantHeaders {
+		if c.Headers[i] != want {
+			t.Fatalf("header change assertion failed, got %v, want %v",
+				c.Headers[i], want)
+		}
+	}
+
+	if len(c.InjectedSections) != 1 ||
+		c.InjectedSections[0].String() != ".inj" {
+		t.Fatalf("injected sections assertion failed, got %v",
+			c.InjectedSections)
+	}
+	if len(c.RemovedSections) != 0 {
+		t.Fatalf("removed sections assertion failed, got %v",
+			c.RemovedSections)
+	}
+	if len(c.ModifiedSections) != 1 || c.ModifiedSections[0].Name != ".text" ||
+		!c.ModifiedSections[0].CodeModified {
+		t.Fatalf("modified sections assertion failed, got %v",
+			c.ModifiedSections)
+	}
+
+	if c.EntryPoint == nil || c.EntryPoint.AddressModified ||
+		!c.EntryPoint.CodeModified || c.EntryPoint.Dumped.Mapped[0] != 0xe9 {
+		t.Fatalf("entry point assertion failed, got %+v", c.EntryPoint)
+	}
+
+	wantIAT := IATChange{
+		Kind:     IATSlotRedirected,
+		RVA:      original.IAT[0].Rva,
+		Module:   "GDI32.dll",
+		Function: "CreateBitmap",
+		Value:    hook,
+	}
+	if len(c.IAT) != 1 || c.IAT[0] != wantIAT {
+		t.Fatalf("IAT changes assertion failed, got %v, want %v", c.IAT,
+			wantIAT)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+// ConformanceLevel describes how much of a PE feature the parser supports.
+type ConformanceLevel int
+
+// Conformance levels.
+const (
+	// ConformanceNone means the feature is recognized but its content is
+	// not parsed.
+	ConformanceNone ConformanceLevel = iota
+
+	// ConformancePartial means only a subset of the feature is parsed.
+	ConformancePartial
+
+	// ConformanceFull means the feature is fully parsed.
+	ConformanceFull
+)
+
+// Features which support level is declared by Conformance.
+const (
+	FeatureDOSHeader          = "dos_header"
+	FeatureRichHeader         = "rich_header"
+	FeatureNTHeader           = "nt_header"
+	FeatureCOFFSymbols        = "coff_symbols"
+	FeatureSectionHeaders     = "section_headers"
+	FeatureExportDirectory    = "export_directory"
+	FeatureImportDirectory    = "import_directory"
+	FeatureResourceDirectory  = "resource_directory"
+	FeatureVersionResources   = "version_resources"
+	FeatureExceptionAMD64     = "exception_amd64_unwind"
+	FeatureExceptionARM64     = "exception_arm64_unwind"
+	FeatureExceptionARM       = "exception_arm_unwind"
+	FeatureExceptionX86       = "exception_x86_safeseh_fpo"
+	FeatureSecurityDirectory  = "security_directory"
+	FeatureAuthenticode       = "authenticode_verification"
+	FeatureRelocDirectory     = "reloc_directory"
+	FeatureDebugDirectory     = "debug_directory"
+	FeatureArchitecture       = "architecture_directory"
+	FeatureGlobalPtrDirectory = "global_ptr_directory"
+	FeatureTLSDirectory       = "tls_directory"
+	FeatureLoadConfig         = "load_config_directory"
+	FeatureDVRTv1             = "dvrt_v1"
+	FeatureDVRTv2             = "dvrt_v2"
+	FeatureBoundImport        = "bound_import_directory"
+	FeatureIATDirectory       = "iat_directory"
+	FeatureDelayImport        = "delay_import_directory"
+	FeatureCLRHeader          = "clr_header"
+	FeatureCLRMetadataTables  = "clr_metadata_tables"
+	FeatureOverlay            = "overlay"
+)
+
+// FeatureConformance declares the support level of a PE feature.
+type FeatureConformance struct {
+	// Name of the feature, one of the Feature* constants.
+	Name string `json:"name"`
+
+	// Level of support of the feature.
+	Level ConformanceLevel `json:"level"`
+
+	// Notes gives details about what is not supported.
+	Notes string `json:"notes,omitempty"`
+}
+
+// features lists the support level of every feature, it must be kept in sync
+// with the parsers.
+var features = []FeatureConformance{
+	{FeatureDOSHeader, ConformanceFull, ""},
+	{FeatureRichHeader, ConformanceFull, ""},
+	{FeatureNTHeader, ConformanceFull, ""},
+	{FeatureCOFFSymbols, ConformanceFull, ""},
+	{FeatureSectionHeaders, ConformanceFull, ""},
+	{FeatureExportDirectory, ConformanceFull, ""},
+	{FeatureImportDirectory, ConformanceFull, ""},
+	{FeatureResourceDirectory, ConformanceFull, ""},
+	{FeatureVersionResources, ConformanceFull, ""},
+	{FeatureExceptionAMD64, ConformanceFull, ""},
+	{FeatureExceptionARM64, ConformanceNone,
+		"runtime function entries are read, unwind data is not decoded"},
+	{FeatureExceptionARM, ConformanceNone,
+		"runtime function entries are read, unwind data is not decoded"},
+	{FeatureExceptionX86, ConformancePartial,
+		"SAFESEH handlers are correlated with FPO debug records, scope tables " +
+			"are not parsed, see X86ExceptionInventory"},
+	{FeatureSecurityDirectory, ConformanceFull, ""},
+	{FeatureAuthenticode, ConformancePartial,
+		"signature and chain of trust are verified, revocation is only checked " +
+			"through OCSP or CRL with the CertRevocationCheck option"},
+	{FeatureRelocDirectory, ConformanceFull, ""},
+	{FeatureDebugDirectory, ConformancePartial,
+		"CodeView, POGO, VC Feature, REPRO, FPO, ExDllCharacteristics, PDB " +
+			"checksum, embedded portable PDB and PerfMap entries are decoded"},
+	{FeatureArchitecture, ConformanceFull,
+		"reserved, must be zero, its content is exposed raw"},
+	{FeatureGlobalPtrDirectory, ConformanceFull, ""},
+	{FeatureTLSDirectory, ConformanceFull, ""},
+	{FeatureLoadConfig, ConformanceFull, ""},
+	{FeatureDVRTv1, ConformanceFull, ""},
+	{FeatureDVRTv2, ConformanceNone, ""},
+	{FeatureBoundImport, ConformanceFull, ""},
+	{FeatureIATDirectory, ConformanceFull, ""},
+	{FeatureDelayImport, ConformanceFull, ""},
+	{FeatureCLRHeader, ConformanceFull, ""},
+	{FeatureCLRMetadataTables, ConformancePartial,
+		"AssemblyProcessor, AssemblyOS, AssemblyRefProcessor, AssemblyRefOS " +
+			"and File tables are not parsed"},
+	{FeatureOverlay, ConformanceFull, ""},
+}
+
+// ConformanceCorpus holds the SHA256 hashes of the canonical samples from the
+// test corpus the parser is validated against.
+var ConformanceCorpus = []string{
+	"000049925c578e5a0883e7d1a8257c1a44feab8f7d9972ace8d0e3fb96612a4c",
+	"000057fd78f66e64e15f5070364c824a8923b6216bd8bcf6368857fb9674c483",
+	"0000e876c5b712b6b7b3ce97f757ddd918fb3dbdc5a3938e850716fbd841309f",
+	"00121dae38f26a33da2990987db58738c5a5966930126a42f606a3b40e014624",
+	"0044e1870806c048a7558082d4482d1650dcd3ea73152ed2218a554983130721",
+	"00da1a2a9d9ebf447508bf6550f05f466f8eabb4ed6c4f2a524c0769b2d75bc1",
+	"010001e68577ef704792448ff474d22c6545167231982447c568e55041169ef0",
+	"01008963d32f5cc17b64c31446386ee5b36a7eab6761df87a2989ba9394d8f3d",
+	"0103daa751660333b7ae5f098795df58f07e3031563e042d2eb415bffa71fe7a",
+	"050708404553416d103652a7ca1f887ab81f533a019a0eeff0e6bb460a202cde",
+	"0585495341e0ffaae1734acb78708ff55cd3612d844672d37226ef63d12652d0",
+	"0b1d3d3664915577ab9a32188d29bbf3542b86c7b9ce333e245496c3018819f1",
+	"3a081c7fe475ec68ed155c76d30cfddc4d41f7a09169810682d1c75421e98eaa",
+	"579fd8a0385482fb4c789561a30b09f25671e86422f40ef5cca2036b28f99648",
+}
+
+// Conformance returns the support level of every PE feature known to this
+// version of the library. Downstream products can use it to gate features
+// or to communicate the limitations of the parser.
+func Conformance() []FeatureConformance {
+	ret := make([]FeatureConformance, len(features))
+	copy(ret, features)
+	return ret
+}
+
+// ConformanceOf returns the support level of a given feature. Unknown
+// features are reported as ConformanceNone.
+func ConformanceOf(name string) ConformanceLevel {
+	for _, feature := range features {
+		if feature.Name == name {
+			return feature.Level
+		}
+	}
+	return ConformanceNone
+}
+
+// String returns the string representation of a conformance level.
+func (level ConformanceLevel) String() string {
+	conformanceLevelMap := map[ConformanceLevel]string{
+		ConformanceNone:    "none",
+		ConformancePartial: "partial",
+		ConformanceFull:    "full",
+	}
+
+	if value, ok := conformanceLevelMap[level]; ok {
+		return value
+	}
+	return "?"
+}
+
+// MarshalText implements the encoding.TextMarshaler interface so conformance
+// levels are encoded as strings in JSON.
+func (level ConformanceLevel) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
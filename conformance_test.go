@@ -0,0 +1,49 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out ConformanceLevel
+	}{
+		{FeatureImportDirectory, ConformanceFull},
+		{FeatureCLRMetadataTables, ConformancePartial},
+		{FeatureExceptionARM64, ConformanceNone},
+		{FeatureExceptionX86, ConformancePartial},
+		{"unknown_feature", ConformanceNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got := ConformanceOf(tt.in)
+			if got != tt.out {
+				t.Errorf("ConformanceOf(%s) got %v, want %v", tt.in, got, tt.out)
+			}
+		})
+	}
+
+	// Callers must not be able to alter the declared features.
+	features := Conformance()
+	features[0].Level = ConformanceNone
+	if ConformanceOf(features[0].Name) != ConformanceFull {
+		t.Errorf("Conformance() returned a reference to the internal table")
+	}
+
+	b, err := json.Marshal(Conformance())
+	if err != nil {
+		t.Fatalf("json.Marshal(Conformance()) failed, reason: %v", err)
+	}
+	if !strings.Contains(string(b), `"level":"partial"`) {
+		t.Errorf("conformance level is not encoded as a string: %s", b)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"math"
+	"sort"
+)
+
+// CoverageRange is a byte range of the file consumed by a parser.
+type CoverageRange struct {
+	ByteRange
+
+	// Parser is the name of the parser which read the range, for instance
+	// `NTHeader`, `SectionHeader` or the name of a data directory.
+	Parser string `json:"parser"`
+}
+
+// markCoverage records that the current parser consumed size bytes at the
+// given file offset. Nothing is recorded unless the Coverage or the
+// CollectStats option is set, or when the read does not happen during Parse.
+func (pe *File) markCoverage(offset, size uint32) {
+	if pe.coverageParser == "" || size == 0 {
+		return
+	}
+	if uint64(offset) >= pe.size {
+		return
+	}
+	if uint64(size) > pe.size-uint64(offset) {
+		size = uint32(pe.size - uint64(offset))
+	}
+
+	pe.addStatsBytes(size)
+	if !pe.opts.Coverage {
+		return
+	}
+
+	// Most parsers read consecutive fields, extend the last range instead of
+	// recording a new one to keep the list short.
+	if n := len(pe.coverage); n > 0 {
+		last := &pe.coverage[n-1]
+		if last.Parser == pe.coverageParser &&
+			uint64(offset) >= uint64(last.Offset) &&
+			uint64(offset) <= last.End() {
+			if end := uint64(offset) + uint64(size); end > last.End() {
+				last.Length = uint32(end - uint64(last.Offset))
+			}
+			return
+		}
+	}
+
+	pe.coverage = append(pe.coverage, CoverageRange{
+		ByteRange: ByteRange{Offset: offset, Length: size},
+		Parser:    pe.coverageParser,
+	})
+}
+
+// startCoverage sets the name of the parser to which subsequent reads and
+// the time spent are attributed.
+func (pe *File) startCoverage(parser string) {
+	pe.startStats(parser)
+	if pe.opts.Coverage || pe.opts.CollectStats {
+		pe.coverageParser = parser
+	}
+}
+
+// Coverage returns the byte ranges of the file consumed by each parser,
+// sorted by offset. Overlapping or adjacent ranges read by the same parser
+// are merged, while ranges read by different parsers may overlap. It is only
+// populated when the file is parsed with the Coverage option.
+func (pe *File) Coverage() []CoverageRange {
+	ranges := make([]CoverageRange, len(pe.coverage))
+	copy(ranges, pe.coverage)
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].Parser != ranges[j].Parser {
+			return ranges[i].Parser < ranges[j].Parser
+		}
+		return ranges[i].Offset < ranges[j].Offset
+	})
+
+	var merged []CoverageRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.Parser == r.Parser && uint64(r.Offset) <= last.End() {
+				if r.End() > last.End() {
+					last.Length = uint32(r.End() - uint64(last.Offset))
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Offset < merged[j].Offset
+	})
+	return merged
+}
+
+// UncoveredRanges returns the byte ranges of the file which were not consumed
+// by any parser, sorted by offset. Large uncovered ranges outside of the
+// sections content and the overlay are good candidates for hidden payloads.
+// The bytes past the first 4GB of the file, which only an overlay can hold,
+// are not reported.
+func (pe *File) UncoveredRanges() []ByteRange {
+	gaps, _ := pe.uncoveredRanges()
+	return gaps
+}
+
+// uncoveredRanges returns the uncovered byte ranges of the file and the total
+// number of uncovered bytes, including those past the first 4GB.
+func (pe *File) uncoveredRanges() ([]ByteRange, uint64) {
+	var gaps []ByteRange
+	uncovered := uint64(0)
+	cursor := uint64(0)
+	for _, r := range pe.Coverage() {
+		if uint64(r.Offset) > cursor {
+			gaps = append(gaps, ByteRange{
+				Offset: uint32(cursor),
+				Length: uint32(uint64(r.Offset) - cursor),
+			})
+			uncovered += uint64(r.Offset) - cursor
+		}
+		if r.End() > cursor {
+			cursor = r.End()
+		}
+	}
+	if cursor < pe.size {
+		uncovered += pe.size - cursor
+		if cursor < math.MaxUint32 {
+			end := pe.size
+			if end > math.MaxUint32 {
+				end = math.MaxUint32
+			}
+			gaps = append(gaps, ByteRange{
+				Offset: uint32(cursor),
+				Length: uint32(end - cursor),
+			})
+		}
+	}
+	return gaps, uncovered
+}
+
+// UncoveredPercentage returns the percentage of the file which was not
+// consumed by any parser.
+func (pe *File) UncoveredPercentage() float64 {
+	if pe.size == 0 {
+		return 0
+	}
+
+	_, uncovered := pe.uncoveredRanges()
+	return float64(uncovered) * 100 / float64(pe.size)
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out []CoverageRange
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), []CoverageRange{
+			{ByteRange{Offset: 0x0, Length: 0x40}, "DOSHeader"},
+			{ByteRange{Offset: 0x40, Length: 0x38}, "DOSStub"},
+			{ByteRange{Offset: 0x78, Length: 0x108}, "NTHeader"},
+			{ByteRange{Offset: 0x180, Length: 0x140}, "SectionHeader"},
+			{ByteRange{Offset: 0xc3fe8, Length: 0xb4}, "Import"},
+		}},
+		{getAbsoluteFilePath("test/kernel32.dll"), []CoverageRange{
+			{ByteRange{Offset: 0x0, Length: 0x40}, "DOSHeader"},
+			{ByteRange{Offset: 0x80, Length: 0x60}, "RichHeader"},
+			{ByteRange{Offset: 0xe8, Length: 0x108}, "NTHeader"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{Coverage: true})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			coverage := file.Coverage()
+			for _, want := range tt.out {
+				found := false
+				for _, got := range coverage {
+					if got == want {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("coverage range %v not found", want)
+				}
+			}
+
+			// Every byte is either covered or uncovered.
+			covered := make([]bool, file.size)
+			for i, r := range coverage {
+				if i > 0 && r.Offset < coverage[i-1].Offset {
+					t.Fatalf("coverage ranges are not sorted by offset")
+				}
+				for j := r.Offset; uint64(j) < r.End(); j++ {
+					covered[j] = true
+				}
+			}
+			uncovered := uint32(0)
+			for _, r := range file.UncoveredRanges() {
+				for j := r.Offset; uint64(j) < r.End(); j++ {
+					if covered[j] {
+						t.Fatalf("byte at 0x%x is both covered and uncovered", j)
+					}
+					covered[j] = true
+				}
+				uncovered += r.Length
+			}
+			for j, c := range covered {
+				if !c {
+					t.Fatalf("byte at 0x%x is neither covered nor uncovered", j)
+				}
+			}
+
+			want := float64(uncovered) * 100 / float64(file.size)
+			if got := file.UncoveredPercentage(); got != want {
+				t.Errorf("UncoveredPercentage() got %v, want %v", got, want)
+			}
+
+			// Reads happening after parsing are not accounted.
+			n := len(file.coverage)
+			file.Checksum()
+			file.ReadBytesAtOffset(uint32(file.size)-0x10, 0x10)
+			if len(file.coverage) != n {
+				t.Errorf("reads after Parse should not be recorded")
+			}
+		})
+	}
+}
+
+func TestCoverageDisabled(t *testing.T) {
+	filename := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(filename, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	if len(file.Coverage()) != 0 {
+		t.Errorf("Coverage() should be empty when the option is not set")
+	}
+	if got := file.UncoveredPercentage(); got != 100 {
+		t.Errorf("UncoveredPercentage() got %v, want 100", got)
+	}
+}
+
+func TestCoverageGetDataHeaders(t *testing.T) {
+	filename := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(filename, &Options{Coverage: true})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	// Only the bytes returned by GetData are covered, not everything up to
+	// the end of the file.
+	file.startCoverage("Test")
+	defer file.startCoverage("")
+	data, err := file.GetData(0x10, 4)
+	if err != nil || len(data) != 4 {
+		t.Fatalf("GetData(0x10, 4) got (%v, %v), want 4 bytes", data, err)
+	}
+	want := CoverageRange{ByteRange{Offset: 0x10, Length: 4}, "Test"}
+	if got := file.coverage[len(file.coverage)-1]; got != want {
+		t.Errorf("coverage range assertion failed, got %v, want %v", got, want)
+	}
+}
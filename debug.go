@@ -5,9 +5,12 @@
 package pe
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 // The following values are defined for the Type field of the debug directory entry:
@@ -66,8 +69,20 @@ const (
 	// PE determinism or reproducibility.
 	ImageDebugTypeRepro = 16
 
+	// Portable PDB embedded in the image, compressed with deflate.
+	ImageDebugTypeEmbeddedPortablePDB = 17
+
+	// Sample profile guided optimization.
+	ImageDebugTypeSPGO = 18
+
+	// Checksum of the PDB file, used to validate it.
+	ImageDebugTypePDBChecksum = 19
+
 	// Extended DLL characteristics bits.
 	ImageDebugTypeExDllCharacteristics = 20
+
+	// Perf map of a ReadyToRun (ngen) image.
+	ImageDebugTypePerfMap = 21
 )
 
 const (
@@ -76,6 +91,25 @@ const (
 
 	// CVSignatureNB10 represents the CodeView signature 'NB10'.
 	CVSignatureNB10 = 0x3031424e
+
+	// EmbeddedPortablePDBSignature represents the signature 'MPDB' of an
+	// embedded portable PDB debug entry.
+	EmbeddedPortablePDBSignature = 0x4244504d
+
+	// PerfMapMagic represents the magic 'R2RM' of a perf map debug entry.
+	PerfMapMagic = 0x4d523252
+)
+
+const (
+	// AnoDebugDataOutsideSections is reported when the data of a debug entry
+	// is not within any section, such as stripped debug data appended to the
+	// image or stored in the headers.
+	AnoDebugDataOutsideSections = "Debug data is not within any section"
+
+	// AnoDebugDataInvalidPointer is reported when the PointerToRawData of a
+	// debug entry is outside the file and the data is found through its
+	// AddressOfRawData instead.
+	AnoDebugDataInvalidPointer = "Debug data PointerToRawData is outside the file"
 )
 
 const (
@@ -95,10 +129,39 @@ const (
 // DllCharacteristicsExType represents a DLL Characteristics type.
 type DllCharacteristicsExType uint32
 
+// The Ex.DLL Characteristics debug entry contains a combination of one or
+// more of the following flags:
 const (
 	// ImageDllCharacteristicsExCETCompat indicates that the image is CET
 	// compatible.
 	ImageDllCharacteristicsExCETCompat = 0x0001
+
+	// ImageDllCharacteristicsExCETCompatStrictMode indicates that the image
+	// is CET compatible only in strict mode.
+	ImageDllCharacteristicsExCETCompatStrictMode = 0x0002
+
+	// ImageDllCharacteristicsExCETSetContextIPValidationRelaxedMode
+	// indicates that the relaxed mode of the instruction pointer validation
+	// applies to the context set by SetThreadContext and RtlRestoreContext.
+	ImageDllCharacteristicsExCETSetContextIPValidationRelaxedMode = 0x0004
+
+	// ImageDllCharacteristicsExCETDynamicAPIsAllowInProc indicates that the
+	// use of the CET dynamic APIs is restricted to out of process callers.
+	ImageDllCharacteristicsExCETDynamicAPIsAllowInProc = 0x0008
+
+	// ImageDllCharacteristicsExCETReserved1 is reserved for future use.
+	ImageDllCharacteristicsExCETReserved1 = 0x0010
+
+	// ImageDllCharacteristicsExCETReserved2 is reserved for future use.
+	ImageDllCharacteristicsExCETReserved2 = 0x0020
+
+	// ImageDllCharacteristicsExForwardCFICompat indicates that the image is
+	// compatible with the forward control flow integrity checks.
+	ImageDllCharacteristicsExForwardCFICompat = 0x0040
+
+	// ImageDllCharacteristicsExHotPatchCompatible indicates that the image
+	// can be hot patched.
+	ImageDllCharacteristicsExHotPatchCompatible = 0x0080
 )
 
 const (
@@ -272,6 +335,28 @@ type POGO struct {
 	Entries   []ImagePGOItem `json:"entries"`
 }
 
+// POGOContribution is the amount of bytes a grouped section, as named in the
+// POGO entries, contributes to a section of the image.
+type POGOContribution struct {
+	Name    string `json:"name"`
+	Size    uint32 `json:"size"`
+	Entries int    `json:"entries"`
+}
+
+// POGOSectionSummary tells which grouped sections a section of the image is
+// made of, for instance how much of .text comes from .text$mn or .text$x.
+type POGOSectionSummary struct {
+	// Section is the name of the section of the image, empty for entries
+	// which do not fall within a section.
+	Section string `json:"section"`
+
+	// Size is the total size of the entries within the section.
+	Size uint32 `json:"size"`
+
+	// Contributions are sorted by decreasing size.
+	Contributions []POGOContribution `json:"contributions"`
+}
+
 type VCFeature struct {
 	PreVC11 uint32 `json:"pre_vc11"`
 	CCpp    uint32 `json:"C/C++"`
@@ -280,11 +365,76 @@ type VCFeature struct {
 	GuardN  uint32 `json:"guardN"`
 }
 
+// REPRO represents the data of the REPRO debug entry, written by linkers
+// producing deterministic builds (/Brepro). LLD writes an entry without data.
 type REPRO struct {
 	Size uint32 `json:"size"`
 	Hash []byte `json:"hash"`
 }
 
+// EmbeddedPortablePDB represents the header of the data of the embedded
+// portable PDB debug entry. It is followed by the portable PDB compressed
+// with deflate.
+type EmbeddedPortablePDB struct {
+	// Signature, equal to `MPDB`.
+	Signature uint32 `json:"signature"`
+
+	// Size of the portable PDB once decompressed.
+	UncompressedSize uint32 `json:"uncompressed_size"`
+}
+
+// PDBChecksum represents the data of the PDB checksum debug entry.
+type PDBChecksum struct {
+	// Name of the hash algorithm, such as `SHA256`.
+	Algorithm string `json:"algorithm"`
+
+	// Checksum of the PDB file.
+	Checksum []byte `json:"checksum"`
+}
+
+// PerfMap represents the data of the perf map debug entry of a ReadyToRun
+// image, which identifies the perf map generated for it.
+type PerfMap struct {
+	// Magic, equal to `R2RM`.
+	Magic uint32 `json:"magic"`
+
+	// Signature of the perf map.
+	Signature GUID `json:"signature"`
+
+	// Version of the perf map format.
+	Version uint32 `json:"version"`
+
+	// Null-terminated path of the perf map file.
+	Path string `json:"path"`
+}
+
+// portablePDBMinorVersion is the MinorVersion of a CodeView debug entry
+// describing a portable PDB, `PM` in little endian.
+const portablePDBMinorVersion = 0x504d
+
+// ReproCheck is the result of the cross-checks of a deterministic build.
+// The MSVC linker derives the values which would otherwise change on every
+// build from the hash of the REPRO entry: the file header TimeDateStamp is
+// its last 4 bytes and the GUID of the PDB its first 16 bytes. How the hash
+// itself is computed is not documented, so it is not recomputed.
+type ReproCheck struct {
+	// Hash is the hash of the REPRO entry, empty when the entry has no data.
+	Hash []byte `json:"hash"`
+
+	// TimeDateStampFromHash tells that the file header TimeDateStamp is
+	// derived from the hash. It is false when there is no hash.
+	TimeDateStampFromHash bool `json:"time_date_stamp_from_hash"`
+
+	// DebugTimeDateStampsMatch tells that the TimeDateStamp of every debug
+	// directory entry is the file header one.
+	DebugTimeDateStampsMatch bool `json:"debug_time_date_stamps_match"`
+
+	// PDBGUIDFromHash tells that the GUID of the CodeView RSDS entry is
+	// derived from the hash. It is false when there is no hash or no such
+	// entry.
+	PDBGUIDFromHash bool `json:"pdb_guid_from_hash"`
+}
+
 // ImageDebugMisc represents the IMAGE_DEBUG_MISC structure.
 type ImageDebugMisc struct {
 	// The type of data carried in the `Data` field.
@@ -312,7 +462,6 @@ type ImageDebugMisc struct {
 // image file, or not be in a section at all.
 func (pe *File) parseDebugDirectory(rva, size uint32) error {
 
-	debugEntry := DebugEntry{}
 	debugDir := ImageDebugDirectory{}
 	errorMsg := fmt.Sprintf("Invalid debug information. Can't read data at RVA: 0x%x", rva)
 	debugDirSize := uint32(binary.Size(debugDir))
@@ -325,11 +474,20 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 			return errors.New(errorMsg)
 		}
 
+		// Entries whose data can't be read are kept without their info.
+		debugEntry := DebugEntry{}
+		dataOffset, ok := pe.debugDataOffset(debugDir)
+		if !ok {
+			pe.warnf(ImageDirectoryEntryDebug.String(), 0,
+				"%s debug data at offset 0x%x is outside the file",
+				debugDir.Type.String(), debugDir.PointerToRawData)
+		}
+
 		switch debugDir.Type {
 		case ImageDebugTypeCodeView:
-			debugSignature, err := pe.ReadUint32(debugDir.PointerToRawData)
+			debugSignature, err := pe.ReadUint32(dataOffset)
 			if err != nil {
-				continue
+				break
 			}
 
 			if debugSignature == CVSignatureRSDS {
@@ -337,18 +495,18 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 				pdb := CVInfoPDB70{CVSignature: CVSignatureRSDS}
 
 				// Extract the GUID.
-				offset := debugDir.PointerToRawData + 4
+				offset := dataOffset + 4
 				guidSize := uint32(binary.Size(pdb.Signature))
 				err = pe.structUnpack(&pdb.Signature, offset, guidSize)
 				if err != nil {
-					continue
+					break
 				}
 
 				// Extract the age.
 				offset += guidSize
 				pdb.Age, err = pe.ReadUint32(offset)
 				if err != nil {
-					continue
+					break
 				}
 				offset += 4
 
@@ -362,7 +520,7 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 					pdbFilename := make([]byte, pdbFilenameSize)
 					err = pe.structUnpack(&pdbFilename, offset, pdbFilenameSize)
 					if err != nil {
-						continue
+						break
 					}
 					pdb.PDBFileName = string(pdbFilename)
 				}
@@ -373,10 +531,10 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 			} else if debugSignature == CVSignatureNB10 {
 				// PDB 2.0.
 				cvHeader := CVHeader{}
-				offset := debugDir.PointerToRawData
+				offset := dataOffset
 				err = pe.structUnpack(&cvHeader, offset, size)
 				if err != nil {
-					continue
+					break
 				}
 
 				pdb := CVInfoPDB20{CVHeader: cvHeader}
@@ -384,13 +542,13 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 				// Extract the signature.
 				pdb.Signature, err = pe.ReadUint32(offset + 8)
 				if err != nil {
-					continue
+					break
 				}
 
 				// Extract the age.
 				pdb.Age, err = pe.ReadUint32(offset + 12)
 				if err != nil {
-					continue
+					break
 				}
 				offset += 16
 
@@ -399,7 +557,7 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 					pdbFilename := make([]byte, pdbFilenameSize)
 					err = pe.structUnpack(&pdbFilename, offset, pdbFilenameSize)
 					if err != nil {
-						continue
+						break
 					}
 					pdb.PDBFileName = string(pdbFilename)
 				}
@@ -408,9 +566,9 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 				debugEntry.Info = pdb
 			}
 		case ImageDebugTypePOGO:
-			pogoSignature, err := pe.ReadUint32(debugDir.PointerToRawData)
+			pogoSignature, err := pe.ReadUint32(dataOffset)
 			if err != nil {
-				continue
+				break
 			}
 
 			pogo := POGO{}
@@ -420,7 +578,7 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 				// TODO: Some files like 00da1a2a9d9ebf447508bf6550f05f466f8eabb4ed6c4f2a524c0769b2d75bc1
 				// have a POGO signature of 0x0. To be reverse engineered.
 				pogo.Signature = POGOType(pogoSignature)
-				offset = debugDir.PointerToRawData + 4
+				offset = dataOffset + 4
 				c := uint32(0)
 				for c < debugDir.SizeOfData-4 {
 
@@ -437,7 +595,15 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 					}
 					offset += 4
 
-					pogoEntry.Name = string(pe.GetStringFromData(0, pe.data[offset:offset+64]))
+					end := offset + 64
+					if uint64(end) > pe.size {
+						end = uint32(pe.size)
+					}
+					if offset >= end {
+						break
+					}
+					pogoEntry.Name = string(pe.GetStringFromData(0, pe.data[offset:end]))
+					pe.markCoverage(offset, uint32(len(pogoEntry.Name))+1)
 
 					pogo.Entries = append(pogo.Entries, pogoEntry)
 					offset += uint32(len(pogoEntry.Name))
@@ -453,29 +619,33 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 		case ImageDebugTypeVCFeature:
 			vcf := VCFeature{}
 			size := uint32(binary.Size(vcf))
-			err := pe.structUnpack(&vcf, debugDir.PointerToRawData, size)
+			err := pe.structUnpack(&vcf, dataOffset, size)
 			if err != nil {
-				continue
+				break
 			}
 			debugEntry.Info = vcf
 		case ImageDebugTypeRepro:
 			repro := REPRO{}
-			offset := debugDir.PointerToRawData
+			offset := dataOffset
+			if debugDir.SizeOfData == 0 {
+				debugEntry.Info = repro
+				break
+			}
 
 			// Extract the size.
 			repro.Size, err = pe.ReadUint32(offset)
 			if err != nil {
-				continue
+				break
 			}
 
 			// Extract the hash.
 			repro.Hash, err = pe.ReadBytesAtOffset(offset+4, repro.Size)
 			if err != nil {
-				continue
+				break
 			}
 			debugEntry.Info = repro
 		case ImageDebugTypeFPO:
-			offset := debugDir.PointerToRawData
+			offset := dataOffset
 			size := uint32(16)
 			fpoEntries := []FPOData{}
 			c := uint32(0)
@@ -540,12 +710,59 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 			}
 			debugEntry.Info = fpoEntries
 		case ImageDebugTypeExDllCharacteristics:
-			exDllChar, err := pe.ReadUint32(debugDir.PointerToRawData)
+			exDllChar, err := pe.ReadUint32(dataOffset)
 			if err != nil {
-				continue
+				break
 			}
 
 			debugEntry.Info = DllCharacteristicsExType(exDllChar)
+		case ImageDebugTypeEmbeddedPortablePDB:
+			pdb := EmbeddedPortablePDB{}
+			size := uint32(binary.Size(pdb))
+			err := pe.structUnpack(&pdb, dataOffset, size)
+			if err != nil {
+				break
+			}
+			debugEntry.Info = pdb
+		case ImageDebugTypePDBChecksum:
+			// The name of the algorithm is null-terminated, the checksum
+			// takes the rest of the data.
+			n, algorithm := pe.readASCIIStringAtOffset(dataOffset,
+				debugDir.SizeOfData)
+			if n >= debugDir.SizeOfData {
+				break
+			}
+			checksum, err := pe.ReadBytesAtOffset(dataOffset+n+1,
+				debugDir.SizeOfData-n-1)
+			if err != nil {
+				break
+			}
+			debugEntry.Info = PDBChecksum{
+				Algorithm: algorithm,
+				Checksum:  checksum,
+			}
+		case ImageDebugTypePerfMap:
+			perfMap := PerfMap{}
+			size := uint32(binary.Size(perfMap.Magic) +
+				binary.Size(perfMap.Signature) + binary.Size(perfMap.Version))
+			if debugDir.SizeOfData < size {
+				break
+			}
+			perfMap.Magic, err = pe.ReadUint32(dataOffset)
+			if err != nil {
+				break
+			}
+			err = pe.structUnpack(&perfMap.Signature, dataOffset+4, 16)
+			if err != nil {
+				break
+			}
+			perfMap.Version, err = pe.ReadUint32(dataOffset + 20)
+			if err != nil {
+				break
+			}
+			_, perfMap.Path = pe.readASCIIStringAtOffset(dataOffset+size,
+				debugDir.SizeOfData-size)
+			debugEntry.Info = perfMap
 		}
 
 		debugEntry.Struct = debugDir
@@ -560,6 +777,37 @@ func (pe *File) parseDebugDirectory(rva, size uint32) error {
 	return nil
 }
 
+// debugDataOffset returns the file offset of the data of a debug entry and
+// whether it lies within the file. The data is read wherever it is in the
+// file, not only in sections, and through AddressOfRawData when the
+// PointerToRawData is invalid.
+func (pe *File) debugDataOffset(debugDir ImageDebugDirectory) (uint32, bool) {
+	inFile := func(offset uint32) bool {
+		return offset != 0 && pe.checkRange(offset, debugDir.SizeOfData) == nil
+	}
+
+	if debugDir.SizeOfData == 0 {
+		return debugDir.PointerToRawData, true
+	}
+
+	if inFile(debugDir.PointerToRawData) {
+		if pe.getSectionByOffset(debugDir.PointerToRawData) == nil {
+			pe.addAnomaly(AnoDebugDataOutsideSections)
+		}
+		return debugDir.PointerToRawData, true
+	}
+
+	if debugDir.AddressOfRawData != 0 {
+		offset := pe.GetOffsetFromRva(debugDir.AddressOfRawData)
+		if offset != ^uint32(0) && inFile(offset) {
+			pe.addAnomaly(AnoDebugDataInvalidPointer)
+			return offset, true
+		}
+	}
+
+	return debugDir.PointerToRawData, false
+}
+
 // SectionAttributeDescription maps a section attribute to a friendly name.
 func SectionAttributeDescription(section string) string {
 	sectionNameMap := map[string]string{
@@ -709,12 +957,17 @@ func (t ImageDebugDirectoryType) String() string {
 		ImageDebugTypeOMAPFromSrc:          "OMAP From Src",
 		ImageDebugTypeBorland:              "Borland",
 		ImageDebugTypeReserved:             "Reserved",
+		ImageDebugTypeCLSID:                "CLSID",
 		ImageDebugTypeVCFeature:            "VC Feature",
 		ImageDebugTypePOGO:                 "POGO",
 		ImageDebugTypeILTCG:                "iLTCG",
 		ImageDebugTypeMPX:                  "MPX",
 		ImageDebugTypeRepro:                "REPRO",
+		ImageDebugTypeEmbeddedPortablePDB:  "Embedded Portable PDB",
+		ImageDebugTypeSPGO:                 "SPGO",
+		ImageDebugTypePDBChecksum:          "PDB Checksum",
 		ImageDebugTypeExDllCharacteristics: "Ex.DLL Characteristics",
+		ImageDebugTypePerfMap:              "PerfMap",
 	}
 
 	v, ok := debugTypeMap[t]
@@ -742,6 +995,159 @@ func (p POGOType) String() string {
 	return "?"
 }
 
+// Description returns the build the POGO signature indicates: which of the
+// link time code generation modes produced the image.
+func (p POGOType) Description() string {
+	pogoDescriptionMap := map[POGOType]string{
+		POGOTypePGU:  "Profile guided optimization, updated profile (/LTCG:PGUPDATE)",
+		POGOTypePGI:  "Profile guided instrumentation (/LTCG:PGINSTRUMENT)",
+		POGOTypePGO:  "Profile guided optimization (/LTCG:PGOPTIMIZE)",
+		POGOTypeLTCG: "Link time code generation (/LTCG)",
+	}
+
+	v, ok := pogoDescriptionMap[p]
+	if ok {
+		return v
+	}
+
+	return "?"
+}
+
+// POGOSummary aggregates the entries of the POGO debug entry per section of
+// the image, in the order of the sections. Build provenance tools use it to
+// fingerprint toolchains. Nil is returned when the image has no POGO entry.
+// The file must be parsed before calling POGOSummary.
+func (pe *File) POGOSummary() []POGOSectionSummary {
+	var pogo *POGO
+	for i := range pe.Debugs {
+		if p, ok := pe.Debugs[i].Info.(POGO); ok {
+			pogo = &p
+			break
+		}
+	}
+	if pogo == nil {
+		return nil
+	}
+
+	// Entries outside any section are gathered last.
+	index := make(map[string]int)
+	var summaries []POGOSectionSummary
+	for _, section := range pe.Sections {
+		name := section.String()
+		if _, ok := index[name]; !ok {
+			index[name] = len(summaries)
+			summaries = append(summaries, POGOSectionSummary{Section: name})
+		}
+	}
+
+	contributions := make([]map[string]int, len(summaries))
+	for _, entry := range pogo.Entries {
+		name := pe.getSectionNameByRva(entry.RVA)
+		i, ok := index[name]
+		if !ok {
+			i = len(summaries)
+			index[name] = i
+			summaries = append(summaries, POGOSectionSummary{Section: name})
+			contributions = append(contributions, nil)
+		}
+		if contributions[i] == nil {
+			contributions[i] = make(map[string]int)
+		}
+
+		summary := &summaries[i]
+		summary.Size += entry.Size
+		j, ok := contributions[i][entry.Name]
+		if !ok {
+			j = len(summary.Contributions)
+			contributions[i][entry.Name] = j
+			summary.Contributions = append(summary.Contributions,
+				POGOContribution{Name: entry.Name})
+		}
+		summary.Contributions[j].Size += entry.Size
+		summary.Contributions[j].Entries++
+	}
+
+	// Drop the sections without entries.
+	n := 0
+	for _, summary := range summaries {
+		if len(summary.Contributions) == 0 {
+			continue
+		}
+		sort.SliceStable(summary.Contributions, func(a, b int) bool {
+			return summary.Contributions[a].Size > summary.Contributions[b].Size
+		})
+		summaries[n] = summary
+		n++
+	}
+	return summaries[:n]
+}
+
+// CheckRepro cross-checks the values a linker derives from the hash of the
+// REPRO debug entry. Nil is returned when the image has no REPRO entry. The
+// file must be parsed before calling CheckRepro.
+func (pe *File) CheckRepro() *ReproCheck {
+	var repro *REPRO
+	for i := range pe.Debugs {
+		if r, ok := pe.Debugs[i].Info.(REPRO); ok {
+			repro = &r
+			break
+		}
+	}
+	if repro == nil {
+		return nil
+	}
+
+	timestamp := pe.NtHeader.FileHeader.TimeDateStamp
+	check := &ReproCheck{Hash: repro.Hash, DebugTimeDateStampsMatch: true}
+	for _, debug := range pe.Debugs {
+		// The .NET compilers leave the timestamp of some entries to zero,
+		// and the CodeView entry of a portable PDB holds a stamp of the
+		// PDB identifier instead.
+		if debug.Struct.TimeDateStamp == 0 ||
+			(debug.Struct.Type == ImageDebugTypeCodeView &&
+				debug.Struct.MinorVersion == portablePDBMinorVersion) {
+			continue
+		}
+		if debug.Struct.TimeDateStamp != timestamp {
+			check.DebugTimeDateStampsMatch = false
+		}
+	}
+
+	hash := repro.Hash
+	if len(hash) < 20 {
+		return check
+	}
+	check.TimeDateStampFromHash =
+		binary.LittleEndian.Uint32(hash[len(hash)-4:]) == timestamp
+
+	for _, debug := range pe.Debugs {
+		pdb, ok := debug.Info.(CVInfoPDB70)
+		if !ok {
+			continue
+		}
+		var guid [16]byte
+		binary.LittleEndian.PutUint32(guid[0:], pdb.Signature.Data1)
+		binary.LittleEndian.PutUint16(guid[4:], pdb.Signature.Data2)
+		binary.LittleEndian.PutUint16(guid[6:], pdb.Signature.Data3)
+		copy(guid[8:], pdb.Signature.Data4[:])
+		check.PDBGUIDFromHash = bytes.Equal(guid[:], hash[:16])
+		break
+	}
+	return check
+}
+
+// IsReproducibleBuild returns true if the image comes from a deterministic
+// build: it has a REPRO debug entry, the TimeDateStamp of the debug entries
+// is the file header one and, when the entry holds a hash, the TimeDateStamp
+// is derived from it.
+func (pe *File) IsReproducibleBuild() bool {
+	check := pe.CheckRepro()
+	if check == nil || !check.DebugTimeDateStampsMatch {
+		return false
+	}
+	return len(check.Hash) == 0 || check.TimeDateStampFromHash
+}
+
 // String returns a string interpretation of a CodeView signature.
 func (s CVSignature) String() string {
 	cvSignatureMap := map[CVSignature]string{
@@ -757,16 +1163,42 @@ func (s CVSignature) String() string {
 	return "?"
 }
 
-// String returns a string interpretation of Dll Characteristics Ex.
-func (flag DllCharacteristicsExType) String() string {
-	dllCharacteristicsExTypeMap := map[DllCharacteristicsExType]string{
-		ImageDllCharacteristicsExCETCompat: "CET Compatible",
-	}
+// dllCharacteristicsExTypeMap names the Dll Characteristics Ex flags.
+var dllCharacteristicsExTypeMap = map[DllCharacteristicsExType]string{
+	ImageDllCharacteristicsExCETCompat:                            "CET Compatible",
+	ImageDllCharacteristicsExCETCompatStrictMode:                  "CET Compatible Strict Mode",
+	ImageDllCharacteristicsExCETSetContextIPValidationRelaxedMode: "CET Set Context IP Validation Relaxed Mode",
+	ImageDllCharacteristicsExCETDynamicAPIsAllowInProc:            "CET Dynamic APIs Allow In Proc",
+	ImageDllCharacteristicsExCETReserved1:                         "CET Reserved 1",
+	ImageDllCharacteristicsExCETReserved2:                         "CET Reserved 2",
+	ImageDllCharacteristicsExForwardCFICompat:                     "Forward CFI Compatible",
+	ImageDllCharacteristicsExHotPatchCompatible:                   "Hot Patch Compatible",
+}
 
-	v, ok := dllCharacteristicsExTypeMap[flag]
-	if ok {
-		return v
+// Flags returns the list of strings which describes the Dll Characteristics
+// Ex flags set.
+func (flag DllCharacteristicsExType) Flags() []string {
+	return flagNames(dllCharacteristicsExTypeMap, func(k uint64) bool {
+		return DllCharacteristicsExType(k)&flag != 0
+	})
+}
+
+// FlagsMap returns every Dll Characteristics Ex flag mapped to whether it is
+// set.
+func (flag DllCharacteristicsExType) FlagsMap() map[string]bool {
+	values := make(map[string]bool, len(dllCharacteristicsExTypeMap))
+	for k, v := range dllCharacteristicsExTypeMap {
+		values[v] = k&flag != 0
 	}
+	return values
+}
 
-	return "?"
+// String returns a string interpretation of Dll Characteristics Ex, the
+// flags set are separated by a comma.
+func (flag DllCharacteristicsExType) String() string {
+	values := flag.Flags()
+	if len(values) == 0 {
+		return "?"
+	}
+	return strings.Join(values, ", ")
 }
@@ -5,6 +5,8 @@
 package pe
 
 import (
+	"encoding/binary"
+	"io/ioutil"
 	"reflect"
 	"testing"
 )
@@ -423,6 +425,146 @@ func TestDebugDirectoryExDLLCharacteristics(t *testing.T) {
 	}
 }
 
+func TestDebugDirectoryPDBChecksum(t *testing.T) {
+
+	tests := []struct {
+		in  TestDebugIn
+		out DebugEntry
+	}{
+		{
+			TestDebugIn{
+				index:    1,
+				filepath: getAbsoluteFilePath("test/mscorlib.dll"),
+			},
+			DebugEntry{
+				Struct: ImageDebugDirectory{
+					Characteristics:  0x0,
+					TimeDateStamp:    0x0,
+					MajorVersion:     0x1,
+					MinorVersion:     0x0,
+					Type:             0x13,
+					SizeOfData:       0x27,
+					AddressOfRawData: 0xcfad,
+					PointerToRawData: 0xb1ad,
+				},
+				Info: PDBChecksum{
+					Algorithm: "SHA256",
+					Checksum: []byte{0x94, 0xe3, 0xa, 0x27, 0x27, 0xe6, 0x7, 0x21, 0x42,
+						0xd6, 0x7c, 0xe, 0x5, 0x23, 0x2, 0x7e, 0x31, 0x69, 0x34, 0x21,
+						0x62, 0xa, 0x5f, 0x9b, 0x5c, 0x58, 0xbd, 0xe7, 0xbe, 0x82, 0x6e,
+						0xed},
+				},
+				Type: "PDB Checksum",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in.filepath, func(t *testing.T) {
+			file, err := New(tt.in.filepath, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in.filepath, err)
+			}
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in.filepath, err)
+			}
+
+			debugEntry := file.Debugs[tt.in.index]
+			if !reflect.DeepEqual(debugEntry, tt.out) {
+				t.Fatalf("debug entry assertion failed, got %v, want %v",
+					debugEntry, tt.out)
+			}
+		})
+	}
+}
+
+func TestDebugDirectoryPerfMap(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/mscorlib.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	// Turn the PDB checksum entry, the second one, into a perf map entry.
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	debugDir := file.Debugs[1].Struct
+	oh32 := file.NtHeader.OptionalHeader.(ImageOptionalHeader32)
+	dirOffset := file.GetOffsetFromRva(
+		oh32.DataDirectory[ImageDirectoryEntryDebug].VirtualAddress)
+	binary.LittleEndian.PutUint32(data[dirOffset+28+12:],
+		ImageDebugTypePerfMap)
+
+	offset := debugDir.PointerToRawData
+	binary.LittleEndian.PutUint32(data[offset:], PerfMapMagic)
+	copy(data[offset+4:], []byte{0x94, 0xe3, 0xa, 0x27, 0x27, 0xe6, 0x7, 0x21,
+		0x42, 0xd6, 0x7c, 0xe, 0x5, 0x23, 0x2, 0x7e})
+	binary.LittleEndian.PutUint32(data[offset+20:], 1)
+	copy(data[offset+24:], "mscorlib.map\x00")
+
+	file, err = NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	want := PerfMap{
+		Magic: PerfMapMagic,
+		Signature: GUID{Data1: 0x270ae394, Data2: 0xe627, Data3: 0x2107,
+			Data4: [8]byte{0x42, 0xd6, 0x7c, 0xe, 0x5, 0x23, 0x2, 0x7e}},
+		Version: 1,
+		Path:    "mscorlib.map",
+	}
+	debugEntry := file.Debugs[1]
+	if debugEntry.Type != "PerfMap" {
+		t.Errorf("debug entry type assertion failed, got %v, want PerfMap",
+			debugEntry.Type)
+	}
+	if !reflect.DeepEqual(debugEntry.Info, want) {
+		t.Errorf("perf map assertion failed, got %+v, want %+v",
+			debugEntry.Info, want)
+	}
+}
+
+func TestDebugTypeString(t *testing.T) {
+
+	tests := []struct {
+		in  ImageDebugDirectoryType
+		out string
+	}{
+		{ImageDebugTypeCLSID, "CLSID"},
+		{ImageDebugTypeILTCG, "iLTCG"},
+		{ImageDebugTypeMPX, "MPX"},
+		{ImageDebugTypeEmbeddedPortablePDB, "Embedded Portable PDB"},
+		{ImageDebugTypeSPGO, "SPGO"},
+		{ImageDebugTypePDBChecksum, "PDB Checksum"},
+		{ImageDebugTypePerfMap, "PerfMap"},
+		{0x42, "?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			got := tt.in.String()
+			if got != tt.out {
+				t.Errorf("debug type string assertion failed, got %v, want %v",
+					got, tt.out)
+			}
+		})
+	}
+}
+
 func TestDebugDirectoryVCFeature(t *testing.T) {
 
 	type TestVCFeature struct {
@@ -677,3 +819,214 @@ func TestDebugSectionAttributes(t *testing.T) {
 		})
 	}
 }
+
+func TestPOGOSummary(t *testing.T) {
+
+	tests := []struct {
+		in          string
+		signature   POGOType
+		description string
+		out         []POGOSectionSummary
+	}{
+		{
+			getAbsoluteFilePath("test/WdBoot.sys"),
+			POGOTypeLTCG,
+			"Link time code generation (/LTCG)",
+			[]POGOSectionSummary{
+				{".text", 7225, []POGOContribution{
+					{".text$mn", 6064, 1}, {".text$mn$21", 1081, 1},
+					{".text$mn$00", 80, 1}}},
+				{".rdata", 3952, []POGOContribution{
+					{".rdata", 1860, 1}, {".xdata", 1272, 1},
+					{".rdata$zzzdbg", 500, 1}, {".rdata$brc", 320, 1}}},
+				{".data", 544, []POGOContribution{
+					{".bss", 480, 1}, {".data", 48, 1}, {".data$brc", 16, 1}}},
+				{".pdata", 912, []POGOContribution{{".pdata", 912, 1}}},
+				{".idata", 1736, []POGOContribution{
+					{".idata$6", 952, 1}, {".idata$5", 352, 1},
+					{".idata$4", 352, 1}, {".idata$2", 40, 1},
+					{".idata$3", 24, 1}, {".00cfg", 16, 1}}},
+				{"PAGE", 12554, []POGOContribution{
+					{"PAGE", 12516, 1}, {"PAGE$x", 38, 1}}},
+				{"INIT", 5201, []POGOContribution{{"INIT", 5201, 1}}},
+				{"GFIDS", 32, []POGOContribution{{".gfids", 32, 1}}},
+				{".rsrc", 2560, []POGOContribution{
+					{".rsrc$02", 2304, 1}, {".rsrc$01", 256, 1}}},
+			},
+		},
+		{
+			getAbsoluteFilePath("test/putty.exe"),
+			0,
+			"?",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			got := file.POGOSummary()
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("POGO summary assertion failed, got %+v, want %+v",
+					got, tt.out)
+			}
+
+			if description := tt.signature.Description(); description != tt.description {
+				t.Errorf("POGO signature description assertion failed, got %v, "+
+					"want %v", description, tt.description)
+			}
+		})
+	}
+}
+
+func TestCheckRepro(t *testing.T) {
+
+	tests := []struct {
+		in           string
+		tamper       bool
+		out          *ReproCheck
+		reproducible bool
+	}{
+		{getAbsoluteFilePath("test/kernel32.dll"), false, &ReproCheck{
+			TimeDateStampFromHash:    true,
+			DebugTimeDateStampsMatch: true,
+			PDBGUIDFromHash:          true,
+		}, true},
+		// The TimeDateStamp was changed after the link.
+		{getAbsoluteFilePath("test/kernel32.dll"), true, &ReproCheck{
+			PDBGUIDFromHash: true,
+		}, false},
+		// .NET deterministic builds have a REPRO entry without data.
+		{getAbsoluteFilePath("test/mscorlib.dll"), false, &ReproCheck{
+			DebugTimeDateStampsMatch: true,
+		}, true},
+		{getAbsoluteFilePath("test/putty.exe"), false, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+			if tt.tamper {
+				file.NtHeader.FileHeader.TimeDateStamp++
+			}
+
+			got := file.CheckRepro()
+			if got != nil {
+				if len(got.Hash) != 0 && len(got.Hash) != 32 {
+					t.Errorf("REPRO hash length assertion failed, got %d",
+						len(got.Hash))
+				}
+				got.Hash = nil
+			}
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("CheckRepro() assertion failed, got %+v, want %+v",
+					got, tt.out)
+			}
+			if reproducible := file.IsReproducibleBuild(); reproducible != tt.reproducible {
+				t.Errorf("IsReproducibleBuild() assertion failed, got %v, want %v",
+					reproducible, tt.reproducible)
+			}
+		})
+	}
+}
+
+func TestDebugDataOutsideSections(t *testing.T) {
+
+	// The CodeView entry of kernel32.dll is the first debug entry, its data is
+	// 0x25 bytes at offset 0x91cf0.
+	const (
+		cvOffset = 0x91cf0
+		cvSize   = 0x25
+	)
+
+	tests := []struct {
+		name    string
+		move    bool
+		pointer uint32
+		rva     uint32
+		anomaly string
+		info    bool
+	}{
+		{"overlay", true, 0, 0x932f0, AnoDebugDataOutsideSections, true},
+		{"headers", false, 0x380, 0x932f0, AnoDebugDataOutsideSections, true},
+		{"address", false, 0xffffff00, 0x932f0, AnoDebugDataInvalidPointer, true},
+		{"invalid", false, 0xffffff00, 0, "", false},
+	}
+
+	filename := getAbsoluteFilePath("test/kernel32.dll")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+			want := file.Debugs[0].Info
+			debugDirOffset := file.GetOffsetFromRva(
+				file.dataDirectory(ImageDirectoryEntryDebug).VirtualAddress)
+
+			// Relocate the CodeView data, either to the end of the file or
+			// into the padding of the headers.
+			pointer := tt.pointer
+			if tt.move {
+				pointer = uint32(len(data))
+				data = append(data, data[cvOffset:cvOffset+cvSize]...)
+			} else if tt.pointer < uint32(len(data)) {
+				copy(data[pointer:], data[cvOffset:cvOffset+cvSize])
+			}
+			binary.LittleEndian.PutUint32(data[debugDirOffset+20:], tt.rva)
+			binary.LittleEndian.PutUint32(data[debugDirOffset+24:], pointer)
+
+			file, err = NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			if len(file.Debugs) == 0 || file.Debugs[0].Type != "CodeView" {
+				t.Fatalf("CodeView debug entry not found in %v", file.Debugs)
+			}
+			got := file.Debugs[0].Info
+			if tt.info && !reflect.DeepEqual(got, want) {
+				t.Errorf("CodeView info assertion failed, got %v, want %v",
+					got, want)
+			}
+			if !tt.info && got != nil {
+				t.Errorf("CodeView info assertion failed, got %v, want none", got)
+			}
+
+			if tt.anomaly != "" && !stringInSlice(tt.anomaly, file.Anomalies) {
+				t.Errorf("anomaly %s not found in %v", tt.anomaly, file.Anomalies)
+			}
+			if !tt.info && len(file.Warnings) == 0 {
+				t.Errorf("Warnings assertion failed, got none")
+			}
+		})
+	}
+}
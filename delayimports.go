@@ -60,6 +60,10 @@ type DelayImport struct {
 	Name       string                     `json:"name"`
 	Functions  []ImportFunction           `json:"functions"`
 	Descriptor ImageDelayImportDescriptor `json:"descriptor"`
+
+	// The name as found in the file when Options.NameSanitization changed
+	// it, empty otherwise.
+	RawName string `json:"raw_name,omitempty"`
 }
 
 // Delay-Load Import Tables tables were added to the image to support a uniform
@@ -89,7 +93,7 @@ func (pe *File) parseDelayImportDirectory(rva, size uint32) error {
 		// If the array of thunks is somewhere earlier than the import
 		// descriptor we can set a maximum length for the array. Otherwise
 		// just set a maximum length of the size of the file
-		maxLen := uint32(len(pe.data)) - fileOffset
+		maxLen := pe.remaining(fileOffset)
 		if rva > importDelayDesc.ImportNameTableRVA ||
 			rva > importDelayDesc.ImportAddressTableRVA {
 			if rva < importDelayDesc.ImportNameTableRVA {
@@ -114,8 +118,11 @@ func (pe *File) parseDelayImportDirectory(rva, size uint32) error {
 
 		nameRVA := uint32(0)
 		if importDelayDesc.Attributes == 0 {
-			nameRVA = importDelayDesc.Name -
-				pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase
+			nameRVA, err = pe.rvaFromVA(uint64(importDelayDesc.Name),
+				"delay import Name")
+			if err != nil {
+				continue
+			}
 		} else {
 			nameRVA = importDelayDesc.Name
 		}
@@ -125,12 +132,13 @@ func (pe *File) parseDelayImportDirectory(rva, size uint32) error {
 			continue
 		}
 
-		pe.DelayImports = append(pe.DelayImports, DelayImport{
+		delayImp := DelayImport{
 			Offset:     fileOffset,
-			Name:       string(dllName),
 			Functions:  importedFunctions,
 			Descriptor: importDelayDesc,
-		})
+		}
+		delayImp.Name, delayImp.RawName = pe.sanitizeName(dllName)
+		pe.DelayImports = append(pe.DelayImports, delayImp)
 	}
 
 	if len(pe.DelayImports) > 0 {
@@ -0,0 +1,510 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"math"
+	"strings"
+)
+
+const (
+	// DelphiFormSignature starts the binary form (DFM) resources streamed by
+	// the Delphi and C++Builder VCL.
+	DelphiFormSignature = "TPF0"
+
+	// DelphiPackageInfoName is the name of the RCDATA resource describing the
+	// packages required and the units contained by a Delphi module.
+	DelphiPackageInfoName = "PACKAGEINFO"
+
+	// DelphiDVCLALName is the name of the RCDATA resource holding the
+	// Delphi license information.
+	DelphiDVCLALName = "DVCLAL"
+)
+
+// Delphi package info flags.
+const (
+	DelphiPackageNeverBuild        = 0x00000001
+	DelphiPackageDesignOnly        = 0x00000002
+	DelphiPackageRunOnly           = 0x00000004
+	DelphiPackageIgnoreDupUnits    = 0x00000008
+	DelphiPackageProducerMask      = 0x0C000000
+	DelphiPackageProducerV3        = 0x00000000
+	DelphiPackageProducerUndefined = 0x04000000
+	DelphiPackageProducerBCB       = 0x08000000
+	DelphiPackageProducerDelphi    = 0x0C000000
+	DelphiPackageModuleTypeMask    = 0xC0000000
+	DelphiPackageExeModule         = 0x00000000
+	DelphiPackagePackageModule     = 0x40000000
+	DelphiPackageLibraryModule     = 0x80000000
+)
+
+// Types of the values found in binary forms, TValueType in Classes.pas.
+const (
+	delphiValueNull = iota
+	delphiValueList
+	delphiValueInt8
+	delphiValueInt16
+	delphiValueInt32
+	delphiValueExtended
+	delphiValueString
+	delphiValueIdent
+	delphiValueFalse
+	delphiValueTrue
+	delphiValueBinary
+	delphiValueSet
+	delphiValueLString
+	delphiValueNil
+	delphiValueCollection
+	delphiValueSingle
+	delphiValueCurrency
+	delphiValueDate
+	delphiValueWString
+	delphiValueInt64
+	delphiValueUTF8String
+	delphiValueDouble
+)
+
+// Filer flags prefixing the objects of binary forms.
+const (
+	DelphiFilerInherited = 0x1
+	DelphiFilerChildPos  = 0x2
+	DelphiFilerInline    = 0x4
+)
+
+// maxDelphiFormDepth limits the nesting of objects and lists in forms.
+const maxDelphiFormDepth = 64
+
+// DelphiUnit represents a unit contained in a Delphi module.
+type DelphiUnit struct {
+	// Name of the unit, for instance `SysUtils`.
+	Name string `json:"name"`
+
+	// Unit flags, such as main unit, package unit or weak packaged unit.
+	Flags uint8 `json:"flags"`
+}
+
+// DelphiPackageInfo represents the PACKAGEINFO resource of a Delphi module.
+type DelphiPackageInfo struct {
+	// Package flags, the producer and the module type can be extracted with
+	// DelphiPackageProducerMask and DelphiPackageModuleTypeMask.
+	Flags uint32 `json:"flags"`
+
+	// Names of the packages required by the module.
+	Requires []string `json:"requires"`
+
+	// Units linked into the module.
+	Contains []DelphiUnit `json:"contains"`
+}
+
+// DelphiProperty represents a published property streamed in a form.
+type DelphiProperty struct {
+	// Name of the property, possibly dotted, for instance `Font.Name`.
+	Name string `json:"name"`
+
+	// Value of the property. Integers are decoded to int64, floating point
+	// numbers, currencies and dates to float64, strings and identifiers to
+	// string, booleans to bool, nil to nil, binary data to []byte, sets to
+	// []string, lists to []interface{} and collections to
+	// []DelphiCollectionItem.
+	Value interface{} `json:"value"`
+}
+
+// DelphiCollectionItem represents an item of a collection property.
+type DelphiCollectionItem struct {
+	// Index of the item, when streamed.
+	Index *int64 `json:"index,omitempty"`
+
+	// Properties of the item.
+	Properties []DelphiProperty `json:"properties"`
+}
+
+// DelphiObject represents a component streamed in a form.
+type DelphiObject struct {
+	// Filer flags, a combination of DelphiFilerInherited, DelphiFilerChildPos
+	// and DelphiFilerInline.
+	Flags uint8 `json:"flags"`
+
+	// Position of the component among its siblings, when
+	// DelphiFilerChildPos is set.
+	Position int64 `json:"position"`
+
+	// Class of the component, for instance `TForm1`.
+	ClassName string `json:"class_name"`
+
+	// Name of the component, for instance `Form1`.
+	Name string `json:"name"`
+
+	// Published properties of the component.
+	Properties []DelphiProperty `json:"properties"`
+
+	// Owned components.
+	Children []DelphiObject `json:"children"`
+}
+
+// DelphiForm represents a form found in the RCDATA resources.
+type DelphiForm struct {
+	// Name of the resource, which is the class name of the form.
+	ResourceName string `json:"resource_name"`
+
+	// The root component of the form.
+	Root DelphiObject `json:"root"`
+}
+
+// IsDelphi returns true if the file was built by the Delphi or C++Builder
+// compilers. They embed the DVCLAL and PACKAGEINFO resources and the
+// Borland linker names its sections CODE, DATA and BSS, or adds an .itext
+// section in recent versions.
+func (pe *File) IsDelphi() bool {
+	if pe.rcDataResource(DelphiDVCLALName) != nil ||
+		pe.rcDataResource(DelphiPackageInfoName) != nil {
+		return true
+	}
+
+	names := make(map[string]bool)
+	for _, section := range pe.Sections {
+		names[section.String()] = true
+	}
+	return names[".itext"] || (names["CODE"] && names["DATA"])
+}
+
+// ReadDelphiPackageInfo parses the PACKAGEINFO resource, nil is returned
+// when the resource is not present.
+func (pe *File) ReadDelphiPackageInfo() (*DelphiPackageInfo, error) {
+	data := pe.rcDataResource(DelphiPackageInfoName)
+	if data == nil {
+		return nil, nil
+	}
+	return ParseDelphiPackageInfo(data)
+}
+
+// ReadDelphiForms decodes the binary forms found in the RCDATA resources.
+// Forms which can't be decoded are skipped, the first error encountered is
+// returned along with the forms decoded successfully.
+func (pe *File) ReadDelphiForms() ([]DelphiForm, error) {
+	var forms []DelphiForm
+	var firstErr error
+	for _, entry := range pe.rcDataEntries() {
+		data := pe.resourceData(entry)
+		if !bytes.HasPrefix(data, []byte(DelphiFormSignature)) {
+			continue
+		}
+		root, err := ParseDelphiForm(data)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		forms = append(forms, DelphiForm{ResourceName: entry.Name, Root: *root})
+	}
+	return forms, firstErr
+}
+
+// rcDataEntries returns the named entries of the RCDATA resource directory.
+func (pe *File) rcDataEntries() []ResourceDirectoryEntry {
+	for _, e := range pe.Resources.Entries {
+		if e.ID == RTRCdata {
+			return e.Directory.Entries
+		}
+	}
+	return nil
+}
+
+// rcDataResource returns the data of the RCDATA resource with the given
+// name, in the first language available.
+func (pe *File) rcDataResource(name string) []byte {
+	for _, entry := range pe.rcDataEntries() {
+		if entry.Name == name {
+			return pe.resourceData(entry)
+		}
+	}
+	return nil
+}
+
+// resourceData returns the data of the first language of a named resource.
+func (pe *File) resourceData(entry ResourceDirectoryEntry) []byte {
+	if !entry.IsResourceDir || len(entry.Directory.Entries) == 0 {
+		return nil
+	}
+	dataEntry := entry.Directory.Entries[0].Data.Struct
+	if dataEntry.Size == 0 {
+		return nil
+	}
+	data, err := pe.GetData(dataEntry.OffsetToData, dataEntry.Size)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// ParseDelphiPackageInfo decodes the content of a PACKAGEINFO resource,
+// TPackageInfoHeader in SysUtils.pas: flags and the number of required
+// packages, each one being a hash byte followed by a null terminated name,
+// then the number of contained units, each one being a flags byte, a hash
+// byte and a null terminated name.
+func ParseDelphiPackageInfo(data []byte) (*DelphiPackageInfo, error) {
+	r := &blobReader{data: data}
+	info := &DelphiPackageInfo{Flags: r.readUint32()}
+
+	requiresCount := r.readUint32()
+	if r.err == nil && int(requiresCount) > len(data) {
+		return nil, ErrInvalidDelphiPackageInfo
+	}
+	for i := uint32(0); i < requiresCount && r.err == nil; i++ {
+		r.readUint8() // hash code
+		info.Requires = append(info.Requires, r.readCString())
+	}
+
+	containsCount := r.readUint32()
+	if r.err == nil && int(containsCount) > len(data) {
+		return nil, ErrInvalidDelphiPackageInfo
+	}
+	for i := uint32(0); i < containsCount && r.err == nil; i++ {
+		unit := DelphiUnit{Flags: r.readUint8()}
+		r.readUint8() // hash code
+		unit.Name = r.readCString()
+		info.Contains = append(info.Contains, unit)
+	}
+
+	if r.err != nil {
+		return nil, ErrInvalidDelphiPackageInfo
+	}
+	return info, nil
+}
+
+// readCString reads a null terminated string.
+func (r *blobReader) readCString() string {
+	if r.err != nil {
+		return ""
+	}
+	n := bytes.IndexByte(r.data[r.off:], 0)
+	if n < 0 {
+		r.err = ErrOutsideBoundary
+		return ""
+	}
+	str := string(r.data[r.off : r.off+n])
+	r.off += n + 1
+	return str
+}
+
+// ParseDelphiForm decodes a binary form (DFM), as streamed by
+// TWriter.WriteComponent in Classes.pas, and returns its root component.
+func ParseDelphiForm(data []byte) (*DelphiObject, error) {
+	if !bytes.HasPrefix(data, []byte(DelphiFormSignature)) {
+		return nil, ErrInvalidDelphiForm
+	}
+	r := &delphiFormReader{blobReader{data: data, off: len(DelphiFormSignature)}}
+	root, err := r.readObject(0)
+	if err != nil {
+		return nil, ErrInvalidDelphiForm
+	}
+	return &root, nil
+}
+
+// delphiFormReader reads the objects and values of a binary form.
+type delphiFormReader struct {
+	blobReader
+}
+
+// readShortString reads a string prefixed by its length on one byte.
+func (r *delphiFormReader) readShortString() string {
+	length := r.readUint8()
+	return string(r.read(int(length)))
+}
+
+// endOfList consumes the null value ending a list and returns true if the
+// next value is one.
+func (r *delphiFormReader) endOfList() bool {
+	if r.err != nil {
+		return true
+	}
+	if r.off < len(r.data) && r.data[r.off] == delphiValueNull {
+		r.off++
+		return true
+	}
+	return false
+}
+
+func (r *delphiFormReader) readObject(depth int) (DelphiObject, error) {
+	obj := DelphiObject{}
+	if depth > maxDelphiFormDepth {
+		return obj, ErrInvalidDelphiForm
+	}
+
+	// An optional prefix byte holds the filer flags in its low nibble.
+	if r.off < len(r.data) && r.data[r.off]&0xf0 == 0xf0 {
+		obj.Flags = r.readUint8() & 0x0f
+		if obj.Flags&DelphiFilerChildPos != 0 {
+			v, err := r.readValue(depth)
+			if err != nil {
+				return obj, err
+			}
+			position, ok := v.(int64)
+			if !ok {
+				return obj, ErrInvalidDelphiForm
+			}
+			obj.Position = position
+		}
+	}
+	obj.ClassName = r.readShortString()
+	obj.Name = r.readShortString()
+
+	properties, err := r.readProperties(depth)
+	if err != nil {
+		return obj, err
+	}
+	obj.Properties = properties
+
+	for !r.endOfList() {
+		child, err := r.readObject(depth + 1)
+		if err != nil {
+			return obj, err
+		}
+		obj.Children = append(obj.Children, child)
+	}
+	return obj, r.err
+}
+
+// readProperties reads name and value pairs up to an empty name.
+func (r *delphiFormReader) readProperties(depth int) ([]DelphiProperty, error) {
+	var properties []DelphiProperty
+	for !r.endOfList() {
+		name := r.readShortString()
+		value, err := r.readValue(depth)
+		if err != nil {
+			return properties, err
+		}
+		properties = append(properties, DelphiProperty{Name: name, Value: value})
+	}
+	return properties, r.err
+}
+
+func (r *delphiFormReader) readValue(depth int) (interface{}, error) {
+	if depth > maxDelphiFormDepth {
+		return nil, ErrInvalidDelphiForm
+	}
+
+	var v interface{}
+	switch valueType := r.readUint8(); valueType {
+	case delphiValueNull, delphiValueNil:
+	case delphiValueList:
+		list := []interface{}{}
+		for !r.endOfList() {
+			elem, err := r.readValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+		}
+		v = list
+	case delphiValueInt8:
+		v = int64(int8(r.readUint8()))
+	case delphiValueInt16:
+		v = int64(int16(r.readUint16()))
+	case delphiValueInt32:
+		v = int64(int32(r.readUint32()))
+	case delphiValueInt64:
+		v = int64(r.readUint64())
+	case delphiValueExtended:
+		mantissa := r.readUint64()
+		v = extendedToFloat64(mantissa, r.readUint16())
+	case delphiValueSingle:
+		v = float64(math.Float32frombits(r.readUint32()))
+	case delphiValueDouble, delphiValueDate:
+		v = math.Float64frombits(r.readUint64())
+	case delphiValueCurrency:
+		// Currencies are fixed point numbers with four decimal digits.
+		v = float64(int64(r.readUint64())) / 10000
+	case delphiValueString, delphiValueIdent:
+		v = r.readShortString()
+	case delphiValueFalse:
+		v = false
+	case delphiValueTrue:
+		v = true
+	case delphiValueLString, delphiValueUTF8String:
+		length := r.readUint32()
+		v = string(r.read(int(length)))
+	case delphiValueWString:
+		length := r.readUint32()
+		if int(length) > len(r.data) {
+			return nil, ErrInvalidDelphiForm
+		}
+		str, err := DecodeUTF16String(r.read(int(length) * 2))
+		if err != nil {
+			return nil, err
+		}
+		v = strings.TrimRight(str, "\x00")
+	case delphiValueBinary:
+		length := r.readUint32()
+		v = append([]byte{}, r.read(int(length))...)
+	case delphiValueSet:
+		set := []string{}
+		for {
+			elem := r.readShortString()
+			if elem == "" || r.err != nil {
+				break
+			}
+			set = append(set, elem)
+		}
+		v = set
+	case delphiValueCollection:
+		items := []DelphiCollectionItem{}
+		for !r.endOfList() {
+			item := DelphiCollectionItem{}
+			if r.off < len(r.data) {
+				switch r.data[r.off] {
+				case delphiValueInt8, delphiValueInt16, delphiValueInt32:
+					index, err := r.readValue(depth + 1)
+					if err != nil {
+						return nil, err
+					}
+					i := index.(int64)
+					item.Index = &i
+				}
+			}
+			if r.readUint8() != delphiValueList {
+				return nil, ErrInvalidDelphiForm
+			}
+			properties, err := r.readProperties(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			item.Properties = properties
+			items = append(items, item)
+		}
+		v = items
+	default:
+		return nil, ErrInvalidDelphiForm
+	}
+
+	if r.err != nil {
+		return nil, ErrInvalidDelphiForm
+	}
+	return v, nil
+}
+
+// extendedToFloat64 converts an x87 80-bit extended precision number, made
+// of an explicit 64-bit mantissa and a 16-bit sign and exponent.
+func extendedToFloat64(mantissa uint64, signExp uint16) float64 {
+	exp := int(signExp & 0x7fff)
+	var f float64
+	switch {
+	case exp == 0 && mantissa == 0:
+		f = 0
+	case exp == 0x7fff:
+		if mantissa<<1 == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(float64(mantissa), exp-16383-63)
+	}
+	if signExp&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
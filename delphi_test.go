@@ -0,0 +1,216 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// dfmWriter builds binary forms the way TWriter streams them.
+type dfmWriter struct {
+	bytes.Buffer
+}
+
+func (w *dfmWriter) shortString(s string) {
+	w.WriteByte(byte(len(s)))
+	w.WriteString(s)
+}
+
+func (w *dfmWriter) value(valueType byte, data ...interface{}) {
+	w.WriteByte(valueType)
+	for _, d := range data {
+		switch v := d.(type) {
+		case string:
+			w.shortString(v)
+		default:
+			binary.Write(w, binary.LittleEndian, v)
+		}
+	}
+}
+
+func TestParseDelphiForm(t *testing.T) {
+	w := &dfmWriter{}
+	w.WriteString(DelphiFormSignature)
+	w.shortString("TForm1")
+	w.shortString("Form1")
+	w.shortString("Left")
+	w.value(delphiValueInt8, int8(-10))
+	w.shortString("Width")
+	w.value(delphiValueInt16, int16(300))
+	w.shortString("Tag")
+	w.value(delphiValueInt32, int32(70000))
+	w.shortString("Caption")
+	w.value(delphiValueString, "Hello")
+	w.shortString("Color")
+	w.value(delphiValueIdent, "clBtnFace")
+	w.shortString("Visible")
+	w.value(delphiValueTrue)
+	w.shortString("BorderIcons")
+	w.value(delphiValueSet, "biSystemMenu", "biMinimize", "")
+	w.shortString("Ratio")
+	// 1.5 in extended precision.
+	w.value(delphiValueExtended, uint64(0xc000000000000000), uint16(0x3fff))
+	w.shortString("Hint")
+	w.value(delphiValueWString, uint32(2), []uint16{'h', 'i'})
+	w.shortString("Items.Strings")
+	w.value(delphiValueList)
+	w.value(delphiValueString, "a")
+	w.value(delphiValueUTF8String, uint32(1), []byte("b"))
+	w.value(delphiValueNull)
+	w.shortString("Icon.Data")
+	w.value(delphiValueBinary, uint32(2), []byte{0xde, 0xad})
+	w.shortString("Columns")
+	w.value(delphiValueCollection)
+	w.value(delphiValueInt8, int8(1))
+	w.value(delphiValueList)
+	w.shortString("Width")
+	w.value(delphiValueInt8, int8(64))
+	w.value(delphiValueNull)
+	w.value(delphiValueNull)
+	w.value(delphiValueNull) // end of properties
+	// A child with its position among its siblings.
+	w.WriteByte(0xf0 | DelphiFilerChildPos)
+	w.value(delphiValueInt8, int8(3))
+	w.shortString("TButton")
+	w.shortString("Button1")
+	w.shortString("Default")
+	w.value(delphiValueFalse)
+	w.value(delphiValueNull) // end of properties
+	w.value(delphiValueNull) // end of children
+	w.value(delphiValueNull) // end of children
+
+	index := int64(1)
+	want := &DelphiObject{
+		ClassName: "TForm1",
+		Name:      "Form1",
+		Properties: []DelphiProperty{
+			{"Left", int64(-10)},
+			{"Width", int64(300)},
+			{"Tag", int64(70000)},
+			{"Caption", "Hello"},
+			{"Color", "clBtnFace"},
+			{"Visible", true},
+			{"BorderIcons", []string{"biSystemMenu", "biMinimize"}},
+			{"Ratio", 1.5},
+			{"Hint", "hi"},
+			{"Items.Strings", []interface{}{"a", "b"}},
+			{"Icon.Data", []byte{0xde, 0xad}},
+			{"Columns", []DelphiCollectionItem{{
+				Index:      &index,
+				Properties: []DelphiProperty{{"Width", int64(64)}},
+			}}},
+		},
+		Children: []DelphiObject{{
+			Flags:      DelphiFilerChildPos,
+			Position:   3,
+			ClassName:  "TButton",
+			Name:       "Button1",
+			Properties: []DelphiProperty{{"Default", false}},
+		}},
+	}
+
+	got, err := ParseDelphiForm(w.Bytes())
+	if err != nil {
+		t.Fatalf("ParseDelphiForm() failed, reason: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Delphi form assertion failed, got %+v, want %+v", got, want)
+	}
+
+	// Truncated forms are reported.
+	for _, size := range []int{2, 10, w.Len() - 1} {
+		_, err = ParseDelphiForm(w.Bytes()[:size])
+		if err != ErrInvalidDelphiForm {
+			t.Errorf("ParseDelphiForm() of %d bytes error assertion failed, "+
+				"got %v, want %v", size, err, ErrInvalidDelphiForm)
+		}
+	}
+}
+
+func TestParseDelphiPackageInfo(t *testing.T) {
+	w := &dfmWriter{}
+	binary.Write(w, binary.LittleEndian, uint32(DelphiPackageProducerDelphi|
+		DelphiPackageRunOnly))
+	binary.Write(w, binary.LittleEndian, uint32(2))
+	w.WriteString("\x12rtl\x00")
+	w.WriteString("\x34vcl\x00")
+	binary.Write(w, binary.LittleEndian, uint32(2))
+	w.WriteString("\x01\x56Unit1\x00")
+	w.WriteString("\x10\x78SysInit\x00")
+
+	want := &DelphiPackageInfo{
+		Flags:    DelphiPackageProducerDelphi | DelphiPackageRunOnly,
+		Requires: []string{"rtl", "vcl"},
+		Contains: []DelphiUnit{{"Unit1", 0x01}, {"SysInit", 0x10}},
+	}
+
+	got, err := ParseDelphiPackageInfo(w.Bytes())
+	if err != nil {
+		t.Fatalf("ParseDelphiPackageInfo() failed, reason: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Delphi package info assertion failed, got %+v, want %+v",
+			got, want)
+	}
+
+	_, err = ParseDelphiPackageInfo(w.Bytes()[:w.Len()-1])
+	if err != ErrInvalidDelphiPackageInfo {
+		t.Errorf("ParseDelphiPackageInfo() error assertion failed, got %v, "+
+			"want %v", err, ErrInvalidDelphiPackageInfo)
+	}
+}
+
+func TestIsDelphi(t *testing.T) {
+
+	tests := []struct {
+		sectionName string
+		out         bool
+	}{
+		{"", false},
+		{".itext", true},
+	}
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	for _, tt := range tests {
+		t.Run(tt.sectionName, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+
+			// Rename the second section of putty.exe, a PE32+ whose section
+			// table follows the 0xf0 bytes of the optional header.
+			if tt.sectionName != "" {
+				ntHeaderOffset := binary.LittleEndian.Uint32(data[0x3c:])
+				var name [8]byte
+				copy(name[:], tt.sectionName)
+				copy(data[ntHeaderOffset+4+20+0xf0+40:], name[:])
+			}
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			if got := file.IsDelphi(); got != tt.out {
+				t.Errorf("IsDelphi() assertion failed, got %v, want %v",
+					got, tt.out)
+			}
+			info, err := file.ReadDelphiPackageInfo()
+			if info != nil || err != nil {
+				t.Errorf("ReadDelphiPackageInfo() assertion failed, got %v, %v",
+					info, err)
+			}
+		})
+	}
+}
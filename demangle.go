@@ -0,0 +1,1574 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// errDemangle is used internally to abort demangling of names using
+// constructs which are not supported.
+var errDemangle = errors.New("unsupported decorated name")
+
+const (
+	// maxDemangleDepth limits the recursion while demangling nested types.
+	maxDemangleDepth = 64
+
+	// maxDemangledLength limits the length of the names and types which
+	// can be back referenced, as each reference could double the length
+	// of the result.
+	maxDemangledLength = 4096
+)
+
+// Demangle returns the undecorated form of a C++ name decorated by MSVC, or
+// by the Itanium ABI used by GCC and MinGW, for instance
+// `public: int __thiscall Foo::bar(char const *)const` for
+// `?bar@Foo@@QBEHPBD@Z` or `Foo::bar(char const*)` for `_ZN3Foo3barEPKc`.
+// An empty string is returned when the name is not decorated or uses
+// constructs the demangler does not support.
+func Demangle(name string) string {
+	var demangled string
+	var err error
+	switch {
+	case strings.HasPrefix(name, "?"):
+		d := &msvcDemangler{s: name}
+		demangled, err = d.demangle()
+	case strings.HasPrefix(name, "_Z"):
+		d := &itaniumDemangler{s: name, pos: 2}
+		demangled, err = d.demangle()
+	default:
+		return ""
+	}
+	if err != nil {
+		return ""
+	}
+	return demangled
+}
+
+// demangledType is a type split around the position of the declarator, so
+// that pointers to functions can be written `void (__cdecl *)(int)`.
+type demangledType struct {
+	left, right string
+}
+
+func (t demangledType) String() string {
+	return t.left + t.right
+}
+
+// pointerTo returns the type of a pointer or reference to t, op being the
+// pointer operator optionally followed by its qualifiers, and sep the
+// separator written before it.
+func (t demangledType) pointerTo(op string, sep string) demangledType {
+	if t.right != "" && !strings.HasPrefix(t.right, ")") {
+		// Pointer to a function or an array, the declarator is
+		// parenthesized.
+		return demangledType{t.left + " (" + op, ")" + t.right}
+	}
+	if strings.HasSuffix(t.left, "*") || strings.HasSuffix(t.left, "&") {
+		return demangledType{t.left + op, t.right}
+	}
+	return demangledType{t.left + sep + op, t.right}
+}
+
+// msvcDemangler undecorates names mangled by the Microsoft C++ compiler.
+type msvcDemangler struct {
+	s     string
+	pos   int
+	depth int
+
+	// Back references to names and function argument types.
+	names []string
+	types []demangledType
+}
+
+var msvcOperators = map[byte]string{
+	'2': "operator new",
+	'3': "operator delete",
+	'4': "operator=",
+	'5': "operator>>",
+	'6': "operator<<",
+	'7': "operator!",
+	'8': "operator==",
+	'9': "operator!=",
+	'A': "operator[]",
+	'C': "operator->",
+	'D': "operator*",
+	'E': "operator++",
+	'F': "operator--",
+	'G': "operator-",
+	'H': "operator+",
+	'I': "operator&",
+	'J': "operator->*",
+	'K': "operator/",
+	'L': "operator%",
+	'M': "operator<",
+	'N': "operator<=",
+	'O': "operator>",
+	'P': "operator>=",
+	'Q': "operator,",
+	'R': "operator()",
+	'S': "operator~",
+	'T': "operator^",
+	'U': "operator|",
+	'V': "operator&&",
+	'W': "operator||",
+	'X': "operator*=",
+	'Y': "operator+=",
+	'Z': "operator-=",
+}
+
+var msvcSpecialOperators = map[byte]string{
+	'0': "operator/=",
+	'1': "operator%=",
+	'2': "operator>>=",
+	'3': "operator<<=",
+	'4': "operator&=",
+	'5': "operator|=",
+	'6': "operator^=",
+	'7': "`vftable'",
+	'8': "`vbtable'",
+	'9': "`vcall'",
+	'A': "`typeof'",
+	'B': "`local static guard'",
+	'D': "`vbase destructor'",
+	'E': "`vector deleting destructor'",
+	'F': "`default constructor closure'",
+	'G': "`scalar deleting destructor'",
+	'H': "`vector constructor iterator'",
+	'I': "`vector destructor iterator'",
+	'J': "`vector vbase constructor iterator'",
+	'K': "`virtual displacement map'",
+	'L': "`eh vector constructor iterator'",
+	'M': "`eh vector destructor iterator'",
+	'N': "`eh vector vbase constructor iterator'",
+	'O': "`copy constructor closure'",
+	'S': "`local vftable'",
+	'T': "`local vftable constructor closure'",
+	'U': "operator new[]",
+	'V': "operator delete[]",
+	'X': "`placement delete closure'",
+	'Y': "`placement delete[] closure'",
+}
+
+var msvcBasicTypes = map[byte]string{
+	'C': "signed char",
+	'D': "char",
+	'E': "unsigned char",
+	'F': "short",
+	'G': "unsigned short",
+	'H': "int",
+	'I': "unsigned int",
+	'J': "long",
+	'K': "unsigned long",
+	'M': "float",
+	'N': "double",
+	'O': "long double",
+	'X': "void",
+	'Z': "...",
+}
+
+var msvcExtendedTypes = map[byte]string{
+	'J': "__int64",
+	'K': "unsigned __int64",
+	'N': "bool",
+	'Q': "char8_t",
+	'S': "char16_t",
+	'U': "char32_t",
+	'W': "wchar_t",
+}
+
+var msvcCallingConventions = map[byte]string{
+	'A': "__cdecl",
+	'B': "__cdecl",
+	'C': "__pascal",
+	'D': "__pascal",
+	'E': "__thiscall",
+	'F': "__thiscall",
+	'G': "__stdcall",
+	'H': "__stdcall",
+	'I': "__fastcall",
+	'J': "__fastcall",
+	'M': "__clrcall",
+	'N': "__clrcall",
+	'Q': "__vectorcall",
+}
+
+// Kind of the special names which depend on the enclosing class.
+const (
+	msvcNameRegular = iota
+	msvcNameConstructor
+	msvcNameDestructor
+	msvcNameConversion
+)
+
+func (d *msvcDemangler) peek() byte {
+	if d.pos < len(d.s) {
+		return d.s[d.pos]
+	}
+	return 0
+}
+
+func (d *msvcDemangler) next() (byte, error) {
+	if d.pos >= len(d.s) {
+		return 0, errDemangle
+	}
+	c := d.s[d.pos]
+	d.pos++
+	return c, nil
+}
+
+func (d *msvcDemangler) consume(prefix string) bool {
+	if strings.HasPrefix(d.s[d.pos:], prefix) {
+		d.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+func (d *msvcDemangler) demangle() (string, error) {
+	d.pos = 1
+	name, kind, err := d.parseSymbolName()
+	if err != nil {
+		return "", err
+	}
+
+	c, err := d.next()
+	if err != nil {
+		return "", err
+	}
+	var result string
+	switch {
+	case c >= '0' && c <= '4':
+		result, err = d.parseVariable(c, name)
+	case c == '6' || c == '7':
+		result, err = d.parseVirtualTable(name)
+	case c >= 'A' && c <= 'Z':
+		result, err = d.parseFunction(c, name, kind)
+	default:
+		err = errDemangle
+	}
+	if err != nil {
+		return "", err
+	}
+	if d.pos != len(d.s) {
+		return "", errDemangle
+	}
+	return result, nil
+}
+
+// parseSymbolName parses the qualified name of the symbol, made of the
+// unqualified name followed by the enclosing scopes from the innermost.
+func (d *msvcDemangler) parseSymbolName() (string, int, error) {
+	kind := msvcNameRegular
+	var name string
+	var err error
+
+	if d.consume("?") {
+		switch {
+		case d.consume("$"):
+			name, err = d.parseTemplateName()
+		case d.consume("0"):
+			kind = msvcNameConstructor
+		case d.consume("1"):
+			kind = msvcNameDestructor
+		case d.consume("B"):
+			kind = msvcNameConversion
+			name = "operator"
+		case d.consume("_"):
+			c, err := d.next()
+			if err != nil {
+				return "", 0, err
+			}
+			op, ok := msvcSpecialOperators[c]
+			if !ok {
+				return "", 0, errDemangle
+			}
+			name = op
+		default:
+			c, err := d.next()
+			if err != nil {
+				return "", 0, err
+			}
+			op, ok := msvcOperators[c]
+			if !ok {
+				return "", 0, errDemangle
+			}
+			name = op
+		}
+	} else {
+		name, err = d.parseSimpleName()
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	scopes, err := d.parseScopes()
+	if err != nil {
+		return "", 0, err
+	}
+	switch kind {
+	case msvcNameConstructor, msvcNameDestructor:
+		if len(scopes) == 0 {
+			return "", 0, errDemangle
+		}
+		name = scopes[0]
+		if i := strings.IndexByte(name, '<'); i > 0 {
+			name = name[:i]
+		}
+		if kind == msvcNameDestructor {
+			name = "~" + name
+		}
+	}
+	return joinScopes(name, scopes), kind, nil
+}
+
+func joinScopes(name string, scopes []string) string {
+	var b strings.Builder
+	for i := len(scopes) - 1; i >= 0; i-- {
+		b.WriteString(scopes[i])
+		b.WriteString("::")
+	}
+	b.WriteString(name)
+	return b.String()
+}
+
+// parseScopes parses the enclosing scopes up to the terminating `@`.
+func (d *msvcDemangler) parseScopes() ([]string, error) {
+	var scopes []string
+	for !d.consume("@") {
+		var scope string
+		var err error
+		switch c := d.peek(); {
+		case c >= '0' && c <= '9':
+			scope, err = d.nameBackReference()
+		case d.consume("?$"):
+			scope, err = d.parseTemplateName()
+		case d.consume("?A"):
+			// Anonymous namespace, followed by a unique identifier.
+			end := strings.IndexByte(d.s[d.pos:], '@')
+			if end < 0 {
+				return nil, errDemangle
+			}
+			d.pos += end + 1
+			scope = "`anonymous namespace'"
+		case c == '?':
+			return nil, errDemangle
+		default:
+			scope, err = d.parseSimpleName()
+		}
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+func (d *msvcDemangler) nameBackReference() (string, error) {
+	c, err := d.next()
+	if err != nil {
+		return "", err
+	}
+	i := int(c - '0')
+	if i >= len(d.names) {
+		return "", errDemangle
+	}
+	return d.names[i], nil
+}
+
+func (d *msvcDemangler) memorizeName(name string) {
+	if len(d.names) >= 10 {
+		return
+	}
+	for _, n := range d.names {
+		if n == name {
+			return
+		}
+	}
+	d.names = append(d.names, name)
+}
+
+// parseSimpleName parses a name terminated by `@`, or a back reference.
+func (d *msvcDemangler) parseSimpleName() (string, error) {
+	if c := d.peek(); c >= '0' && c <= '9' {
+		return d.nameBackReference()
+	}
+	end := strings.IndexByte(d.s[d.pos:], '@')
+	if end <= 0 {
+		return "", errDemangle
+	}
+	name := d.s[d.pos : d.pos+end]
+	d.pos += end + 1
+	d.memorizeName(name)
+	return name, nil
+}
+
+// parseTemplateName parses a template instantiation name following `?$`.
+// Template arguments have their own back references.
+func (d *msvcDemangler) parseTemplateName() (string, error) {
+	if d.depth++; d.depth > maxDemangleDepth {
+		return "", errDemangle
+	}
+	defer func() { d.depth-- }()
+
+	names, types := d.names, d.types
+	d.names, d.types = nil, nil
+
+	var name string
+	var err error
+	if d.consume("?") {
+		c, err := d.next()
+		if err != nil {
+			return "", err
+		}
+		op, ok := msvcOperators[c]
+		if !ok {
+			return "", errDemangle
+		}
+		name = op
+	} else {
+		name, err = d.parseSimpleName()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var args []string
+	for !d.consume("@") {
+		arg, err := d.parseTemplateArgument()
+		if err != nil {
+			return "", err
+		}
+		if arg != "" {
+			args = append(args, arg)
+		}
+	}
+
+	d.names, d.types = names, types
+	name += "<" + strings.Join(args, ",")
+	if len(name) > maxDemangledLength {
+		return "", errDemangle
+	}
+	if strings.HasSuffix(name, ">") {
+		name += " "
+	}
+	name += ">"
+	d.memorizeName(name)
+	return name, nil
+}
+
+func (d *msvcDemangler) parseTemplateArgument() (string, error) {
+	switch {
+	case d.consume("$0"):
+		n, err := d.parseNumber()
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(n, 10), nil
+	case d.consume("$$V"), d.consume("$$Z"), d.consume("$S"):
+		// Empty parameter pack.
+		return "", nil
+	case d.peek() == '$' && !strings.HasPrefix(d.s[d.pos:], "$$"):
+		// Pointers to members and other non-type arguments.
+		return "", errDemangle
+	}
+	t, err := d.parseArgumentType()
+	if err != nil {
+		return "", err
+	}
+	return t.String(), nil
+}
+
+// parseNumber parses an encoded number: digits for 1 to 10, otherwise hex
+// digits from A to P terminated by `@`, optionally preceded by `?` for
+// negative numbers.
+func (d *msvcDemangler) parseNumber() (int64, error) {
+	negative := d.consume("?")
+	c, err := d.next()
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if c >= '0' && c <= '9' {
+		n = int64(c-'0') + 1
+	} else {
+		for c != '@' {
+			if c < 'A' || c > 'P' || n > 1<<58 {
+				return 0, errDemangle
+			}
+			n = n<<4 + int64(c-'A')
+			if c, err = d.next(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if negative {
+		n = -n
+	}
+	return n, nil
+}
+
+// parseArgumentType parses the type of a function or template argument,
+// which can be a back reference to a previous argument type.
+func (d *msvcDemangler) parseArgumentType() (demangledType, error) {
+	if c := d.peek(); c >= '0' && c <= '9' {
+		d.pos++
+		i := int(c - '0')
+		if i >= len(d.types) {
+			return demangledType{}, errDemangle
+		}
+		return d.types[i], nil
+	}
+	start := d.pos
+	t, err := d.parseType()
+	if err != nil {
+		return t, err
+	}
+	if len(t.left)+len(t.right) > maxDemangledLength {
+		return t, errDemangle
+	}
+	// Only types encoded with more than one character are memorized.
+	if d.pos-start > 1 && len(d.types) < 10 {
+		d.types = append(d.types, t)
+	}
+	return t, nil
+}
+
+func (d *msvcDemangler) parseType() (demangledType, error) {
+	if d.depth++; d.depth > maxDemangleDepth {
+		return demangledType{}, errDemangle
+	}
+	defer func() { d.depth-- }()
+
+	c, err := d.next()
+	if err != nil {
+		return demangledType{}, err
+	}
+	if basic, ok := msvcBasicTypes[c]; ok {
+		return demangledType{left: basic}, nil
+	}
+
+	switch c {
+	case '_':
+		c, err := d.next()
+		if err != nil {
+			return demangledType{}, err
+		}
+		if ext, ok := msvcExtendedTypes[c]; ok {
+			return demangledType{left: ext}, nil
+		}
+	case 'T', 'U', 'V':
+		keyword := map[byte]string{'T': "union ", 'U': "struct ", 'V': "class "}[c]
+		name, err := d.parseTypeName()
+		if err != nil {
+			return demangledType{}, err
+		}
+		return demangledType{left: keyword + name}, nil
+	case 'W':
+		if !d.consume("4") {
+			return demangledType{}, errDemangle
+		}
+		name, err := d.parseTypeName()
+		if err != nil {
+			return demangledType{}, err
+		}
+		return demangledType{left: "enum " + name}, nil
+	case 'P':
+		return d.parsePointer("*", "")
+	case 'Q':
+		return d.parsePointer("*", " const")
+	case 'R':
+		return d.parsePointer("*", " volatile")
+	case 'S':
+		return d.parsePointer("*", " const volatile")
+	case 'A':
+		return d.parsePointer("&", "")
+	case 'B':
+		return d.parsePointer("&", " volatile")
+	case '?':
+		// Storage class of a type passed by value.
+		if _, err := d.parseQualifiers(); err != nil {
+			return demangledType{}, err
+		}
+		return d.parseType()
+	case '$':
+		switch {
+		case d.consume("$Q"):
+			return d.parsePointer("&&", "")
+		case d.consume("$R"):
+			return d.parsePointer("&&", " volatile")
+		case d.consume("$T"):
+			return demangledType{left: "std::nullptr_t"}, nil
+		case d.consume("$C"):
+			cv, err := d.parseQualifiers()
+			if err != nil {
+				return demangledType{}, err
+			}
+			t, err := d.parseType()
+			if err != nil {
+				return demangledType{}, err
+			}
+			t.left += cv
+			return t, nil
+		case d.consume("$A6"):
+			f, err := d.parseFunctionType()
+			if err != nil {
+				return demangledType{}, err
+			}
+			return demangledType{left: f.ret + f.cc, right: "(" + f.args + ")"}, nil
+		}
+	}
+	return demangledType{}, errDemangle
+}
+
+// parseTypeName parses the qualified name of a class, struct, union or enum.
+func (d *msvcDemangler) parseTypeName() (string, error) {
+	var name string
+	var err error
+	if d.consume("?$") {
+		name, err = d.parseTemplateName()
+	} else {
+		name, err = d.parseSimpleName()
+	}
+	if err != nil {
+		return "", err
+	}
+	scopes, err := d.parseScopes()
+	if err != nil {
+		return "", err
+	}
+	return joinScopes(name, scopes), nil
+}
+
+// parseQualifiers parses the optional pointer modifiers and the cv
+// qualifiers of a pointed type or of a member function.
+func (d *msvcDemangler) parseQualifiers() (string, error) {
+	// __ptr64, __restrict and __unaligned modifiers are omitted.
+	for d.consume("E") || d.consume("I") || d.consume("F") {
+	}
+	c, err := d.next()
+	if err != nil {
+		return "", err
+	}
+	switch c {
+	case 'A':
+		return "", nil
+	case 'B':
+		return " const", nil
+	case 'C':
+		return " volatile", nil
+	case 'D':
+		return " const volatile", nil
+	}
+	return "", errDemangle
+}
+
+func (d *msvcDemangler) parsePointer(op, cv string) (demangledType, error) {
+	for d.consume("E") || d.consume("I") || d.consume("F") {
+	}
+	if d.consume("6") {
+		f, err := d.parseFunctionType()
+		if err != nil {
+			return demangledType{}, err
+		}
+		return demangledType{
+			left:  f.ret + "(" + f.cc + " " + op + cv,
+			right: ")(" + f.args + ")",
+		}, nil
+	}
+	pointeeCV, err := d.parseQualifiers()
+	if err != nil {
+		return demangledType{}, err
+	}
+	t, err := d.parseType()
+	if err != nil {
+		return t, err
+	}
+	if t.right == "" {
+		t.left += pointeeCV
+	}
+	return t.pointerTo(op+cv, " "), nil
+}
+
+// msvcFunctionType holds the parts of a function type, which are laid out
+// differently for functions and pointers to functions.
+type msvcFunctionType struct {
+	// The return type followed by a space, empty for constructors and
+	// destructors.
+	ret string
+
+	cc   string
+	args string
+}
+
+// parseFunctionType parses the calling convention, the return type and the
+// arguments of a function type.
+func (d *msvcDemangler) parseFunctionType() (msvcFunctionType, error) {
+	var f msvcFunctionType
+	c, err := d.next()
+	if err != nil {
+		return f, err
+	}
+	cc, ok := msvcCallingConventions[c]
+	if !ok {
+		return f, errDemangle
+	}
+	f.cc = cc
+	if !d.consume("@") {
+		ret, err := d.parseType()
+		if err != nil {
+			return f, err
+		}
+		f.ret = ret.String() + " "
+	}
+	if f.args, err = d.parseArguments(); err != nil {
+		return f, err
+	}
+	// Throw specification.
+	if !d.consume("Z") && !d.consume("_E") {
+		return f, errDemangle
+	}
+	return f, nil
+}
+
+func (d *msvcDemangler) parseArguments() (string, error) {
+	if d.consume("X") {
+		return "void", nil
+	}
+	var args []string
+	for {
+		if d.consume("@") {
+			break
+		}
+		if d.consume("Z") {
+			args = append(args, "...")
+			break
+		}
+		t, err := d.parseArgumentType()
+		if err != nil {
+			return "", err
+		}
+		args = append(args, t.String())
+	}
+	return strings.Join(args, ","), nil
+}
+
+// parseFunction parses the type of a function, c being the code of its
+// access and storage.
+func (d *msvcDemangler) parseFunction(c byte, name string, kind int) (string, error) {
+	var access, storage string
+	member := true
+	switch (c - 'A') / 8 {
+	case 0:
+		access = "private: "
+	case 1:
+		access = "protected: "
+	case 2:
+		access = "public: "
+	}
+	switch (c - 'A') % 8 / 2 {
+	case 1:
+		storage = "static "
+		member = false
+	case 2:
+		storage = "virtual "
+	case 3:
+		// Thunks adjusting this.
+		return "", errDemangle
+	}
+	if c == 'Y' || c == 'Z' {
+		access, storage = "", ""
+		member = false
+	}
+
+	var thisCV string
+	if member {
+		cv, err := d.parseQualifiers()
+		if err != nil {
+			return "", err
+		}
+		thisCV = strings.TrimPrefix(cv, " ")
+	}
+
+	f, err := d.parseFunctionType()
+	if err != nil {
+		return "", err
+	}
+	if kind == msvcNameConversion {
+		// The return type is the type converted to.
+		name += " " + strings.TrimSuffix(f.ret, " ")
+		f.ret = ""
+	}
+	return access + storage + f.ret + f.cc + " " + name + "(" + f.args + ")" +
+		thisCV, nil
+}
+
+// parseVariable parses the type of a variable, c being the code of its
+// access and storage.
+func (d *msvcDemangler) parseVariable(c byte, name string) (string, error) {
+	prefix := map[byte]string{
+		'0': "private: static ",
+		'1': "protected: static ",
+		'2': "public: static ",
+	}[c]
+	t, err := d.parseType()
+	if err != nil {
+		return "", err
+	}
+	cv, err := d.parseQualifiers()
+	if err != nil {
+		return "", err
+	}
+	t.left += cv
+	return prefix + t.left + " " + name + t.right, nil
+}
+
+// parseVirtualTable parses the qualifiers and the optional class of virtual
+// function and base tables.
+func (d *msvcDemangler) parseVirtualTable(name string) (string, error) {
+	cv, err := d.parseQualifiers()
+	if err != nil {
+		return "", err
+	}
+	result := strings.TrimPrefix(cv, " ") + " " + name
+	if !d.consume("@") {
+		scope, err := d.parseTypeName()
+		if err != nil {
+			return "", err
+		}
+		result += "{for `" + scope + "'}"
+	}
+	return strings.TrimPrefix(result, " "), nil
+}
+
+// itaniumDemangler demangles names mangled according to the Itanium C++ ABI,
+// as done by GCC and Clang, and thus found in MinGW binaries.
+type itaniumDemangler struct {
+	s     string
+	pos   int
+	depth int
+
+	// Substitution candidates, referenced by S_ and S<seq-id>_.
+	subs []demangledType
+
+	// Template arguments of the function, referenced by T_ and T<n>_.
+	templateArgs []demangledType
+
+	// Set while parsing the name of the function, whose template arguments
+	// are referenced by template parameters.
+	inFunctionName bool
+}
+
+// itaniumName gives information about a parsed name used to demangle the
+// function type which follows it.
+type itaniumName struct {
+	name string
+
+	// Last unqualified name, used by constructors and destructors.
+	last string
+
+	// The name ends with template arguments.
+	template bool
+
+	// The name is a constructor, destructor or conversion operator, which
+	// have no return type.
+	noReturnType bool
+
+	// cv and ref qualifiers of member functions.
+	qualifiers string
+}
+
+var itaniumBuiltinTypes = map[byte]string{
+	'v': "void",
+	'w': "wchar_t",
+	'b': "bool",
+	'c': "char",
+	'a': "signed char",
+	'h': "unsigned char",
+	's': "short",
+	't': "unsigned short",
+	'i': "int",
+	'j': "unsigned int",
+	'l': "long",
+	'm': "unsigned long",
+	'x': "long long",
+	'y': "unsigned long long",
+	'n': "__int128",
+	'o': "unsigned __int128",
+	'f': "float",
+	'd': "double",
+	'e': "long double",
+	'g': "__float128",
+	'z': "...",
+}
+
+var itaniumExtendedTypes = map[byte]string{
+	'a': "auto",
+	'c': "decltype(auto)",
+	'i': "char32_t",
+	'n': "decltype(nullptr)",
+	's': "char16_t",
+	'u': "char8_t",
+}
+
+var itaniumOperators = map[string]string{
+	"nw": "new", "na": "new[]", "dl": "delete", "da": "delete[]",
+	"ps": "+", "ng": "-", "ad": "&", "de": "*", "co": "~",
+	"pl": "+", "mi": "-", "ml": "*", "dv": "/", "rm": "%",
+	"an": "&", "or": "|", "eo": "^", "aS": "=",
+	"pL": "+=", "mI": "-=", "mL": "*=", "dV": "/=", "rM": "%=",
+	"aN": "&=", "oR": "|=", "eO": "^=",
+	"ls": "<<", "rs": ">>", "lS": "<<=", "rS": ">>=",
+	"eq": "==", "ne": "!=", "lt": "<", "gt": ">", "le": "<=", "ge": ">=",
+	"ss": "<=>", "nt": "!", "aa": "&&", "oo": "||", "pp": "++", "mm": "--",
+	"cm": ",", "pm": "->*", "pt": "->", "cl": "()", "ix": "[]", "qu": "?",
+}
+
+var itaniumStdSubstitutions = map[byte]demangledType{
+	'a': {left: "std::allocator"},
+	'b': {left: "std::basic_string"},
+	's': {left: "std::string"},
+	'i': {left: "std::istream"},
+	'o': {left: "std::ostream"},
+	'd': {left: "std::iostream"},
+}
+
+// The suffixes added to integer literals by their type.
+var itaniumLiteralSuffixes = map[byte]string{
+	'i': "", 'j': "u", 'l': "l", 'm': "ul", 'x': "ll", 'y': "ull",
+}
+
+func (d *itaniumDemangler) peek() byte {
+	if d.pos < len(d.s) {
+		return d.s[d.pos]
+	}
+	return 0
+}
+
+func (d *itaniumDemangler) next() (byte, error) {
+	if d.pos >= len(d.s) {
+		return 0, errDemangle
+	}
+	c := d.s[d.pos]
+	d.pos++
+	return c, nil
+}
+
+func (d *itaniumDemangler) consume(prefix string) bool {
+	if strings.HasPrefix(d.s[d.pos:], prefix) {
+		d.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+func (d *itaniumDemangler) demangle() (string, error) {
+	// Drop the suffixes of cloned functions such as `.cold` or `.isra.0`.
+	if i := strings.IndexByte(d.s, '.'); i > 0 {
+		d.s = d.s[:i]
+	}
+
+	var result string
+	var err error
+	switch {
+	case d.consume("TV"):
+		result, err = d.parseSpecialName("vtable for ")
+	case d.consume("TT"):
+		result, err = d.parseSpecialName("VTT for ")
+	case d.consume("TI"):
+		result, err = d.parseSpecialName("typeinfo for ")
+	case d.consume("TS"):
+		result, err = d.parseSpecialName("typeinfo name for ")
+	case d.consume("GV"):
+		var name itaniumName
+		name, err = d.parseName()
+		result = "guard variable for " + name.name
+	default:
+		result, err = d.parseEncoding()
+	}
+	if err != nil {
+		return "", err
+	}
+	if d.pos != len(d.s) {
+		return "", errDemangle
+	}
+	return result, nil
+}
+
+func (d *itaniumDemangler) parseSpecialName(prefix string) (string, error) {
+	t, err := d.parseType()
+	if err != nil {
+		return "", err
+	}
+	return prefix + t.String(), nil
+}
+
+// parseEncoding parses the name of a function followed by its parameters,
+// or the name of a variable.
+func (d *itaniumDemangler) parseEncoding() (string, error) {
+	d.inFunctionName = true
+	name, err := d.parseName()
+	d.inFunctionName = false
+	if err != nil {
+		return "", err
+	}
+	if d.pos == len(d.s) {
+		return name.name, nil
+	}
+
+	var ret string
+	if name.template && !name.noReturnType {
+		t, err := d.parseType()
+		if err != nil {
+			return "", err
+		}
+		ret = t.String() + " "
+	}
+
+	var params []string
+	for d.pos < len(d.s) {
+		t, err := d.parseType()
+		if err != nil {
+			return "", err
+		}
+		params = append(params, t.String())
+	}
+	if len(params) == 1 && params[0] == "void" {
+		params = nil
+	}
+	return ret + name.name + "(" + strings.Join(params, ", ") + ")" +
+		name.qualifiers, nil
+}
+
+func (d *itaniumDemangler) parseName() (itaniumName, error) {
+	if d.depth++; d.depth > maxDemangleDepth {
+		return itaniumName{}, errDemangle
+	}
+	defer func() { d.depth-- }()
+
+	switch c := d.peek(); {
+	case c == 'N':
+		d.pos++
+		return d.parseNestedName()
+	case c == 'Z':
+		// Local names are not supported.
+		return itaniumName{}, errDemangle
+	case c == 'S' && !strings.HasPrefix(d.s[d.pos:], "St"):
+		// A substituted template name must be followed by its arguments.
+		sub, err := d.parseSubstitution()
+		if err != nil {
+			return itaniumName{}, err
+		}
+		if d.peek() != 'I' {
+			return itaniumName{}, errDemangle
+		}
+		return d.parseTemplateArgsOf(itaniumName{name: sub.String()})
+	}
+
+	prefix := ""
+	if d.consume("St") {
+		prefix = "std::"
+	}
+	name, err := d.parseUnqualifiedName("")
+	if err != nil {
+		return itaniumName{}, err
+	}
+	name.name = prefix + name.name
+	if d.peek() == 'I' {
+		if err := d.addSubstitution(demangledType{left: name.name}); err != nil {
+			return itaniumName{}, err
+		}
+		return d.parseTemplateArgsOf(name)
+	}
+	return name, nil
+}
+
+// parseTemplateArgsOf appends the template arguments to name.
+func (d *itaniumDemangler) parseTemplateArgsOf(name itaniumName) (itaniumName, error) {
+	inFunctionName := d.inFunctionName
+	d.inFunctionName = false
+	args, err := d.parseTemplateArgs()
+	d.inFunctionName = inFunctionName
+	if err != nil {
+		return itaniumName{}, err
+	}
+	if d.inFunctionName {
+		d.templateArgs = args
+	}
+	name.name += formatTemplateArgs(args)
+	name.template = true
+	return name, nil
+}
+
+func formatTemplateArgs(args []demangledType) string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.String()
+	}
+	s := "<" + strings.Join(strs, ", ")
+	if strings.HasSuffix(s, ">") {
+		s += " "
+	}
+	return s + ">"
+}
+
+func (d *itaniumDemangler) parseNestedName() (itaniumName, error) {
+	var qualifiers string
+	for {
+		switch {
+		case d.consume("r"):
+			qualifiers += " restrict"
+			continue
+		case d.consume("V"):
+			qualifiers += " volatile"
+			continue
+		case d.consume("K"):
+			qualifiers += " const"
+			continue
+		}
+		break
+	}
+	if d.consume("R") {
+		qualifiers += " &"
+	} else if d.consume("O") {
+		qualifiers += " &&"
+	}
+
+	var name itaniumName
+	for !d.consume("E") {
+		if d.pos >= len(d.s) {
+			return itaniumName{}, errDemangle
+		}
+
+		substituted := false
+		switch c := d.peek(); {
+		case c == 'S' && name.name == "":
+			if d.consume("St") {
+				name.name = "std"
+				name.last = "std"
+				continue
+			}
+			sub, err := d.parseSubstitution()
+			if err != nil {
+				return itaniumName{}, err
+			}
+			name.name = sub.String()
+			name.last = sub.String()
+			substituted = true
+		case c == 'I':
+			if name.name == "" {
+				return itaniumName{}, errDemangle
+			}
+			var err error
+			name, err = d.parseTemplateArgsOf(name)
+			if err != nil {
+				return itaniumName{}, err
+			}
+		case c == 'T':
+			t, err := d.parseTemplateParam()
+			if err != nil {
+				return itaniumName{}, err
+			}
+			name.name = t.String()
+			name.last = t.String()
+		default:
+			component, err := d.parseUnqualifiedName(name.last)
+			if err != nil {
+				return itaniumName{}, err
+			}
+			if name.name != "" {
+				component.name = name.name + "::" + component.name
+			}
+			name = component
+		}
+
+		// Every prefix of the name is a substitution candidate.
+		if !substituted && d.peek() != 'E' {
+			if err := d.addSubstitution(demangledType{left: name.name}); err != nil {
+				return itaniumName{}, err
+			}
+		}
+	}
+	name.qualifiers = qualifiers
+	return name, nil
+}
+
+// parseUnqualifiedName parses a source name, an operator name, or the name
+// of a constructor or destructor of the class named last.
+func (d *itaniumDemangler) parseUnqualifiedName(last string) (itaniumName, error) {
+	d.consume("L")
+	c := d.peek()
+	switch {
+	case c >= '0' && c <= '9':
+		name, err := d.parseSourceName()
+		if err != nil {
+			return itaniumName{}, err
+		}
+		return itaniumName{name: name, last: name}, nil
+	case c == 'C' || c == 'D':
+		d.pos++
+		k, err := d.next()
+		if err != nil || last == "" {
+			return itaniumName{}, errDemangle
+		}
+		if c == 'C' && (k < '1' || k > '5') || c == 'D' && (k < '0' || k > '5') {
+			return itaniumName{}, errDemangle
+		}
+		if i := strings.IndexByte(last, '<'); i > 0 {
+			last = last[:i]
+		}
+		if i := strings.LastIndex(last, "::"); i >= 0 {
+			last = last[i+2:]
+		}
+		if c == 'D' {
+			last = "~" + last
+		}
+		return itaniumName{name: last, last: last, noReturnType: true}, nil
+	case d.consume("cv"):
+		t, err := d.parseType()
+		if err != nil {
+			return itaniumName{}, err
+		}
+		name := "operator " + t.String()
+		return itaniumName{name: name, last: name, noReturnType: true}, nil
+	case d.consume("li"):
+		name, err := d.parseSourceName()
+		if err != nil {
+			return itaniumName{}, err
+		}
+		name = "operator\"\" " + name
+		return itaniumName{name: name, last: name}, nil
+	case d.pos+2 <= len(d.s):
+		op, ok := itaniumOperators[d.s[d.pos:d.pos+2]]
+		if !ok {
+			break
+		}
+		d.pos += 2
+		name := "operator" + op
+		if op[0] >= 'a' && op[0] <= 'z' {
+			name = "operator " + op
+		}
+		return itaniumName{name: name, last: name}, nil
+	}
+	return itaniumName{}, errDemangle
+}
+
+func (d *itaniumDemangler) parseNumber() (int, error) {
+	start := d.pos
+	for d.pos < len(d.s) && d.s[d.pos] >= '0' && d.s[d.pos] <= '9' {
+		d.pos++
+	}
+	n, err := strconv.Atoi(d.s[start:d.pos])
+	if err != nil {
+		return 0, errDemangle
+	}
+	return n, nil
+}
+
+func (d *itaniumDemangler) parseSourceName() (string, error) {
+	n, err := d.parseNumber()
+	if err != nil {
+		return "", err
+	}
+	if n <= 0 || n > len(d.s)-d.pos {
+		return "", errDemangle
+	}
+	name := d.s[d.pos : d.pos+n]
+	d.pos += n
+	if strings.HasPrefix(name, "_GLOBAL__N") {
+		name = "(anonymous namespace)"
+	}
+	return name, nil
+}
+
+// parseSeqID parses the base 36 index of substitutions and template
+// parameters up to the terminating `_`, the first one having no index.
+func (d *itaniumDemangler) parseSeqID() (int, error) {
+	if d.consume("_") {
+		return 0, nil
+	}
+	n := 0
+	for {
+		c, err := d.next()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case c == '_':
+			return n + 1, nil
+		case c >= '0' && c <= '9':
+			n = n*36 + int(c-'0')
+		case c >= 'A' && c <= 'Z':
+			n = n*36 + int(c-'A') + 10
+		default:
+			return 0, errDemangle
+		}
+		if n > len(d.s) {
+			return 0, errDemangle
+		}
+	}
+}
+
+// addSubstitution records a substitution candidate.
+func (d *itaniumDemangler) addSubstitution(t demangledType) error {
+	if len(t.left)+len(t.right) > maxDemangledLength {
+		return errDemangle
+	}
+	d.subs = append(d.subs, t)
+	return nil
+}
+
+func (d *itaniumDemangler) parseSubstitution() (demangledType, error) {
+	if !d.consume("S") {
+		return demangledType{}, errDemangle
+	}
+	if t, ok := itaniumStdSubstitutions[d.peek()]; ok {
+		d.pos++
+		return t, nil
+	}
+	i, err := d.parseSeqID()
+	if err != nil {
+		return demangledType{}, err
+	}
+	if i >= len(d.subs) {
+		return demangledType{}, errDemangle
+	}
+	return d.subs[i], nil
+}
+
+func (d *itaniumDemangler) parseTemplateParam() (demangledType, error) {
+	if !d.consume("T") {
+		return demangledType{}, errDemangle
+	}
+	i, err := d.parseSeqID()
+	if err != nil {
+		return demangledType{}, err
+	}
+	if i >= len(d.templateArgs) {
+		return demangledType{}, errDemangle
+	}
+	return d.templateArgs[i], nil
+}
+
+func (d *itaniumDemangler) parseTemplateArgs() ([]demangledType, error) {
+	if !d.consume("I") {
+		return nil, errDemangle
+	}
+	var args []demangledType
+	for !d.consume("E") {
+		arg, err := d.parseTemplateArg()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (d *itaniumDemangler) parseTemplateArg() (demangledType, error) {
+	switch {
+	case d.consume("L"):
+		return d.parseLiteral()
+	case d.consume("J"):
+		// Argument pack.
+		var args []string
+		for !d.consume("E") {
+			arg, err := d.parseTemplateArg()
+			if err != nil {
+				return demangledType{}, err
+			}
+			args = append(args, arg.String())
+		}
+		return demangledType{left: strings.Join(args, ", ")}, nil
+	case d.peek() == 'X':
+		// Expressions are not supported.
+		return demangledType{}, errDemangle
+	}
+	return d.parseType()
+}
+
+// parseLiteral parses an integer or boolean literal following `L`.
+func (d *itaniumDemangler) parseLiteral() (demangledType, error) {
+	c, err := d.next()
+	if err != nil {
+		return demangledType{}, err
+	}
+	typ, ok := itaniumBuiltinTypes[c]
+	if !ok {
+		return demangledType{}, errDemangle
+	}
+	negative := d.consume("n")
+	n, err := d.parseNumber()
+	if err != nil || !d.consume("E") {
+		return demangledType{}, errDemangle
+	}
+	value := strconv.Itoa(n)
+	if negative {
+		value = "-" + value
+	}
+	if c == 'b' {
+		switch n {
+		case 0:
+			return demangledType{left: "false"}, nil
+		case 1:
+			return demangledType{left: "true"}, nil
+		}
+	}
+	if suffix, ok := itaniumLiteralSuffixes[c]; ok {
+		return demangledType{left: value + suffix}, nil
+	}
+	return demangledType{left: "(" + typ + ")" + value}, nil
+}
+
+func (d *itaniumDemangler) parseType() (demangledType, error) {
+	if d.depth++; d.depth > maxDemangleDepth {
+		return demangledType{}, errDemangle
+	}
+	defer func() { d.depth-- }()
+
+	c := d.peek()
+	if builtin, ok := itaniumBuiltinTypes[c]; ok {
+		d.pos++
+		return demangledType{left: builtin}, nil
+	}
+
+	var t demangledType
+	var err error
+	switch c {
+	case 'D':
+		d.pos++
+		c, err := d.next()
+		if err != nil {
+			return t, err
+		}
+		ext, ok := itaniumExtendedTypes[c]
+		if !ok {
+			return t, errDemangle
+		}
+		return demangledType{left: ext}, nil
+	case 'u':
+		d.pos++
+		name, err := d.parseSourceName()
+		return demangledType{left: name}, err
+	case 'P', 'R', 'O':
+		d.pos++
+		t, err = d.parseType()
+		if err != nil {
+			return t, err
+		}
+		op := map[byte]string{'P': "*", 'R': "&", 'O': "&&"}[c]
+		t = t.pointerTo(op, "")
+	case 'K', 'V', 'r':
+		var qualifiers string
+		for {
+			if d.consume("r") {
+				qualifiers = " restrict" + qualifiers
+			} else if d.consume("V") {
+				qualifiers = " volatile" + qualifiers
+			} else if d.consume("K") {
+				qualifiers = " const" + qualifiers
+			} else {
+				break
+			}
+		}
+		t, err = d.parseType()
+		if err != nil {
+			return t, err
+		}
+		if t.right != "" {
+			t.right += qualifiers
+		} else {
+			t.left += qualifiers
+		}
+	case 'F':
+		d.pos++
+		t, err = d.parseFunctionType()
+	case 'A':
+		d.pos++
+		n, err := d.parseNumber()
+		if err != nil || !d.consume("_") {
+			return t, errDemangle
+		}
+		t, err = d.parseType()
+		if err != nil {
+			return t, err
+		}
+		if t.right != "" {
+			return t, errDemangle
+		}
+		t.right = " [" + strconv.Itoa(n) + "]"
+	case 'T':
+		t, err = d.parseTemplateParam()
+		if err != nil {
+			return t, err
+		}
+		if d.peek() == 'I' {
+			if err := d.addSubstitution(t); err != nil {
+				return t, err
+			}
+			args, err := d.parseTemplateArgs()
+			if err != nil {
+				return t, err
+			}
+			t.left += formatTemplateArgs(args)
+		}
+	case 'S':
+		if strings.HasPrefix(d.s[d.pos:], "St") {
+			var name itaniumName
+			name, err = d.parseName()
+			t = demangledType{left: name.name}
+			break
+		}
+		t, err = d.parseSubstitution()
+		if err != nil || d.peek() != 'I' {
+			// Substitutions are not added again.
+			return t, err
+		}
+		args, err := d.parseTemplateArgs()
+		if err != nil {
+			return t, err
+		}
+		t.left += formatTemplateArgs(args)
+	default:
+		if c != 'N' && (c < '0' || c > '9') {
+			return t, errDemangle
+		}
+		var name itaniumName
+		name, err = d.parseName()
+		t = demangledType{left: name.name}
+	}
+	if err != nil {
+		return t, err
+	}
+	return t, d.addSubstitution(t)
+}
+
+// parseFunctionType parses the return and parameter types of a function
+// type following `F`.
+func (d *itaniumDemangler) parseFunctionType() (demangledType, error) {
+	d.consume("Y")
+	ret, err := d.parseType()
+	if err != nil {
+		return ret, err
+	}
+	var params []string
+	for !d.consume("E") {
+		if d.consume("R") || d.consume("O") {
+			continue
+		}
+		t, err := d.parseType()
+		if err != nil {
+			return t, err
+		}
+		params = append(params, t.String())
+	}
+	if len(params) == 1 && params[0] == "void" {
+		params = nil
+	}
+	return demangledType{left: ret.String(),
+		right: "(" + strings.Join(params, ", ") + ")"}, nil
+}
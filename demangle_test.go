@@ -0,0 +1,83 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"testing"
+)
+
+func TestDemangle(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out string
+	}{
+		// Not decorated.
+		{"GetProcAddress", ""},
+		{"?", ""},
+		{"_Z", ""},
+
+		// MSVC.
+		{"?bar@Foo@@QBEHPBD@Z", "public: int __thiscall Foo::bar(char const *)const"},
+		{"?f@std@@YAXV?$vector@HV?$allocator@H@std@@@1@@Z",
+			"void __cdecl std::f(class std::vector<int,class std::allocator<int> >)"},
+		{"?f@@YAXP6AXH@Z@Z", "void __cdecl f(void (__cdecl *)(int))"},
+
+		// Itanium.
+		{"_ZN3Foo3barEPKc", "Foo::bar(char const*)"},
+		{"_ZNSt6vectorIiSaIiEE9push_backERKi",
+			"std::vector<int, std::allocator<int> >::push_back(int const&)"},
+		{"_Z5firstIiET_S0_", "int first<int>(int)"},
+		{"_ZplRK3FooS1_", "operator+(Foo const&, Foo const&)"},
+		{"_Z1fPA4_i", "f(int (*) [4])"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := Demangle(tt.in); got != tt.out {
+				t.Errorf("Demangle(%s) assertion failed, got %q, want %q",
+					tt.in, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestDemangleNamesOption(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		opts Options
+		out  int
+	}{
+		{getAbsoluteFilePath("test/D2D1Debug2.dll"), Options{}, 0},
+		{getAbsoluteFilePath("test/D2D1Debug2.dll"), Options{DemangleNames: true}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &tt.opts)
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			demangled := 0
+			for _, imp := range file.Imports {
+				for _, function := range imp.Functions {
+					if function.Demangled != "" {
+						demangled++
+					}
+				}
+			}
+			if demangled != tt.out {
+				t.Errorf("demangled imports count assertion failed, got %d, want %d",
+					demangled, tt.out)
+			}
+		})
+	}
+}
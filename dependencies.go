@@ -0,0 +1,310 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DependencyKind tells how a module depends on another one.
+type DependencyKind int
+
+const (
+	// DependencyImport is a dependency of the import directory.
+	DependencyImport DependencyKind = iota
+
+	// DependencyDelayImport is a dependency of the delay import directory.
+	DependencyDelayImport
+
+	// DependencyForwarder is an export forwarded to another module.
+	DependencyForwarder
+)
+
+// String stringifies the dependency kind.
+func (k DependencyKind) String() string {
+	dependencyKindMap := map[DependencyKind]string{
+		DependencyImport:      "Import",
+		DependencyDelayImport: "Delay Import",
+		DependencyForwarder:   "Forwarder",
+	}
+
+	if v, ok := dependencyKindMap[k]; ok {
+		return v
+	}
+	return "?"
+}
+
+// DependencyEdge links a module to a module it depends on.
+type DependencyEdge struct {
+	// From is the name of the dependent module.
+	From string `json:"from"`
+
+	// To is the name of the module depended on.
+	To string `json:"to"`
+
+	// Kind tells whether the dependency is an import or a forwarder.
+	Kind DependencyKind `json:"kind"`
+
+	// Missing is set when the module depended on is not part of the graph.
+	Missing bool `json:"missing"`
+
+	// APISet is set when the module depended on is an API set contract, such
+	// as `api-ms-win-core-heap-l1-1-0.dll`, which the loader resolves to a
+	// host module rather than loading a file of that name.
+	APISet bool `json:"api_set"`
+}
+
+// DependencyForward describes an export forwarded to another module.
+type DependencyForward struct {
+	// Export is the name of the exported function, or `#` followed by its
+	// ordinal when exported by ordinal only.
+	Export string `json:"export"`
+
+	// Module is the name of the module the export is forwarded to.
+	Module string `json:"module"`
+
+	// Function is the name of the function the export is forwarded to, or `#`
+	// followed by its ordinal.
+	Function string `json:"function"`
+
+	// Missing is set when the target module is not part of the graph, or
+	// when it does not export the function.
+	Missing bool `json:"missing"`
+}
+
+// DependencyModule is a node of the dependency graph.
+type DependencyModule struct {
+	// Path is the path of the file the module was parsed from.
+	Path string `json:"path"`
+
+	// Imports lists the modules of the import directory.
+	Imports []string `json:"imports"`
+
+	// DelayImports lists the modules of the delay import directory.
+	DelayImports []string `json:"delay_imports"`
+
+	// Forwards lists the exports forwarded to another module.
+	Forwards []DependencyForward `json:"forwards"`
+
+	// exports holds the names and ordinals of the exported functions.
+	exports map[string]bool
+}
+
+// DependencyGraph is the graph of the imports and the export forwarders of a
+// set of modules. Module names are lower cased.
+type DependencyGraph struct {
+	// Modules maps the file name of every module parsed to its node.
+	Modules map[string]*DependencyModule `json:"modules"`
+
+	// Edges lists the dependencies between the modules, sorted.
+	Edges []DependencyEdge `json:"edges"`
+
+	// Errors maps the file name of the files which are not valid PE files to
+	// the reason why parsing failed.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// BuildDependencyGraph parses the DLLs and executables found in a directory,
+// not recursively, and builds the graph of their imports and of their export
+// forwarders. Edges to modules which are not in the directory are reported as
+// missing, which is what DLL hijacking audits look for. When opts is nil, the
+// directories which are not needed to build the graph are not parsed.
+func BuildDependencyGraph(dir string, opts *Options) (*DependencyGraph, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = &Options{
+			OmitExceptionDirectory:   true,
+			OmitResourceDirectory:    true,
+			OmitSecurityDirectory:    true,
+			OmitRelocDirectory:       true,
+			OmitDebugDirectory:       true,
+			OmitTLSDirectory:         true,
+			OmitLoadConfigDirectory:  true,
+			OmitBoundImportDirectory: true,
+			OmitIATDirectory:         true,
+			OmitCLRHeaderDirectory:   true,
+		}
+	}
+
+	g := &DependencyGraph{
+		Modules: make(map[string]*DependencyModule),
+		Errors:  make(map[string]string),
+	}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".dll" && ext != ".exe") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		module, err := parseDependencyModule(path, opts)
+		if err != nil {
+			g.Errors[entry.Name()] = err.Error()
+			continue
+		}
+		g.Modules[strings.ToLower(entry.Name())] = module
+	}
+
+	g.link()
+	return g, nil
+}
+
+// parseDependencyModule reads the imports and the export forwarders of a
+// file.
+func parseDependencyModule(path string, opts *Options) (*DependencyModule, error) {
+	file, err := New(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	err = file.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	module := &DependencyModule{Path: path, exports: make(map[string]bool)}
+	for _, imp := range file.imports() {
+		module.Imports = append(module.Imports, dependencyModuleName(imp.Name))
+	}
+	for _, imp := range file.DelayImports {
+		module.DelayImports = append(module.DelayImports,
+			dependencyModuleName(imp.Name))
+	}
+
+	for _, fn := range file.Export.Functions {
+		export := "#" + strconv.Itoa(int(fn.Ordinal))
+		module.exports[export] = true
+		if fn.Name != "" {
+			export = fn.Name
+			module.exports[export] = true
+		}
+
+		if fn.Forwarder == "" {
+			continue
+		}
+
+		// The loader splits forwarders such as `NTDLL.RtlAllocateHeap` or
+		// `NTDLL.#12` at the last dot.
+		i := strings.LastIndex(fn.Forwarder, ".")
+		if i <= 0 {
+			continue
+		}
+		module.Forwards = append(module.Forwards, DependencyForward{
+			Export:   export,
+			Module:   dependencyModuleName(fn.Forwarder[:i]),
+			Function: fn.Forwarder[i+1:],
+		})
+	}
+	return module, nil
+}
+
+// link resolves the forwarders and builds the edges of the graph.
+func (g *DependencyGraph) link() {
+	type key struct {
+		from, to string
+		kind     DependencyKind
+	}
+	seen := make(map[key]bool)
+	addEdge := func(from, to string, kind DependencyKind) {
+		k := key{from, to, kind}
+		if seen[k] {
+			return
+		}
+		seen[k] = true
+		_, ok := g.Modules[to]
+		g.Edges = append(g.Edges, DependencyEdge{
+			From:    from,
+			To:      to,
+			Kind:    kind,
+			Missing: !ok,
+			APISet:  isAPISetName(to),
+		})
+	}
+
+	for name, module := range g.Modules {
+		for _, imp := range module.Imports {
+			addEdge(name, imp, DependencyImport)
+		}
+		for _, imp := range module.DelayImports {
+			addEdge(name, imp, DependencyDelayImport)
+		}
+
+		for i := range module.Forwards {
+			fwd := &module.Forwards[i]
+			target, ok := g.Modules[fwd.Module]
+			fwd.Missing = !ok || !target.exports[fwd.Function]
+			addEdge(name, fwd.Module, DependencyForwarder)
+		}
+	}
+
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+}
+
+// Adjacency returns, for every module of the graph, the sorted list of the
+// modules it depends on, whatever the kind of the dependency.
+func (g *DependencyGraph) Adjacency() map[string][]string {
+	adjacency := make(map[string][]string, len(g.Modules))
+	for name := range g.Modules {
+		adjacency[name] = nil
+	}
+	for _, edge := range g.Edges {
+		deps := adjacency[edge.From]
+		if n := len(deps); n > 0 && deps[n-1] == edge.To {
+			continue
+		}
+		adjacency[edge.From] = append(deps, edge.To)
+	}
+	return adjacency
+}
+
+// MissingModules returns the sorted list of the modules depended on which
+// are not part of the graph, API sets excluded.
+func (g *DependencyGraph) MissingModules() []string {
+	var missing []string
+	seen := make(map[string]bool)
+	for _, edge := range g.Edges {
+		if !edge.Missing || edge.APISet || seen[edge.To] {
+			continue
+		}
+		seen[edge.To] = true
+		missing = append(missing, edge.To)
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// dependencyModuleName normalizes the name of a module the way the loader
+// looks it up: case insensitively and with the .dll extension when it has
+// none.
+func dependencyModuleName(name string) string {
+	name = strings.ToLower(name)
+	if filepath.Ext(name) == "" {
+		name += ".dll"
+	}
+	return name
+}
+
+// isAPISetName tells whether a module name is an API set contract.
+func isAPISetName(name string) bool {
+	return strings.HasPrefix(name, "api-") || strings.HasPrefix(name, "ext-")
+}
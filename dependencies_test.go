@@ -0,0 +1,111 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildDependencyGraph(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "pe-deps")
+	if err != nil {
+		t.Fatalf("TempDir() failed, reason: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// kernel32.dll forwards many of its exports to ntdll.dll, a copy of
+	// kernel32.dll stands for it so that only the functions both export are
+	// resolved.
+	filename := getAbsoluteFilePath("test/kernel32.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+	files := map[string][]byte{
+		"KERNEL32.dll": data,
+		"ntdll.dll":    data,
+		"notes.dll":    []byte("not a PE"),
+		"readme.txt":   []byte("ignored"),
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), content, 0644)
+		if err != nil {
+			t.Fatalf("WriteFile(%s) failed, reason: %v", name, err)
+		}
+	}
+
+	g, err := BuildDependencyGraph(dir, nil)
+	if err != nil {
+		t.Fatalf("BuildDependencyGraph(%s) failed, reason: %v", dir, err)
+	}
+
+	if len(g.Modules) != 2 || g.Modules["kernel32.dll"] == nil ||
+		g.Modules["ntdll.dll"] == nil {
+		t.Errorf("modules assertion failed, got %v", g.Modules)
+	}
+	if _, ok := g.Errors["notes.dll"]; !ok || len(g.Errors) != 1 {
+		t.Errorf("errors assertion failed, got %v", g.Errors)
+	}
+
+	wantMissing := []string{"kernelbase.dll", "rpcrt4.dll"}
+	if got := g.MissingModules(); !reflect.DeepEqual(got, wantMissing) {
+		t.Errorf("MissingModules() assertion failed, got %v, want %v",
+			got, wantMissing)
+	}
+
+	wantEdges := []DependencyEdge{
+		{From: "kernel32.dll", To: "ntdll.dll", Kind: DependencyImport},
+		{From: "kernel32.dll", To: "ntdll.dll", Kind: DependencyForwarder},
+		{From: "kernel32.dll", To: "rpcrt4.dll", Kind: DependencyDelayImport,
+			Missing: true},
+		{From: "kernel32.dll", To: "api-ms-win-core-heap-l1-1-0.dll",
+			Kind: DependencyImport, Missing: true, APISet: true},
+	}
+	for _, want := range wantEdges {
+		found := false
+		for _, edge := range g.Edges {
+			if edge == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("edge %+v not found in %+v", want, g.Edges)
+		}
+	}
+
+	wantForwards := []DependencyForward{
+		{Export: "AcquireSRWLockExclusive", Module: "ntdll.dll",
+			Function: "RtlAcquireSRWLockExclusive", Missing: true},
+		{Export: "RtlZeroMemory", Module: "ntdll.dll",
+			Function: "RtlZeroMemory", Missing: false},
+	}
+	for _, want := range wantForwards {
+		found := false
+		for _, fwd := range g.Modules["kernel32.dll"].Forwards {
+			if fwd == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("forward %+v not found", want)
+		}
+	}
+
+	adjacency := g.Adjacency()
+	count := 0
+	for _, dep := range adjacency["kernel32.dll"] {
+		if dep == "ntdll.dll" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Adjacency() assertion failed, got %v", adjacency["kernel32.dll"])
+	}
+}
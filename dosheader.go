@@ -5,9 +5,26 @@
 package pe
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"strings"
 )
 
+// DOSStubStandardMessage is the message printed by the stub program that
+// Microsoft linkers emit.
+const DOSStubStandardMessage = "This program cannot be run in DOS mode."
+
+// dosStubPrologue is the beginning of the stub program emitted by most
+// linkers, the two bytes that follow are the offset of the message:
+//
+//	push cs
+//	pop ds
+//	mov dx, offset message
+var dosStubPrologue = []byte{0x0e, 0x1f, 0xba}
+
 // ImageDOSHeader represents the DOS stub of a PE.
 type ImageDOSHeader struct {
 	// Magic number.
@@ -68,6 +85,34 @@ type ImageDOSHeader struct {
 	AddressOfNewEXEHeader uint32 `json:"address_of_new_exe_header"`
 }
 
+// DOSStub represents the real mode program which lies between the DOS header
+// and the Rich header, or the NT headers when there is no Rich header. Linkers
+// and packers leave fingerprints there.
+type DOSStub struct {
+	// Offset is the file offset where the stub starts.
+	Offset uint32 `json:"offset"`
+
+	// Size is the number of bytes in the stub, including padding.
+	Size uint32 `json:"size"`
+
+	// Raw holds the bytes of the stub.
+	Raw []byte `json:"raw"`
+
+	// Message is the string printed by the stub program, when it could be
+	// located.
+	Message string `json:"message"`
+
+	// IsStandard is true when the stub prints the message of the stub
+	// emitted by Microsoft linkers.
+	IsStandard bool `json:"is_standard"`
+
+	// MD5 is the MD5 hash of the stub bytes.
+	MD5 string `json:"md5"`
+
+	// SHA256 is the SHA256 hash of the stub bytes.
+	SHA256 string `json:"sha256"`
+}
+
 // ParseDOSHeader parses the DOS header stub. Every PE file begins with a small
 // MS-DOS stub. The need for this arose in the early days of Windows, before a
 // significant number of consumers were running it. When executed on a machine
@@ -76,6 +121,12 @@ type ImageDOSHeader struct {
 func (pe *File) ParseDOSHeader() (err error) {
 	offset := uint32(0)
 	size := uint32(binary.Size(pe.DOSHeader))
+	if pe.hasDOSMagic() {
+		err = pe.checkTruncated("DOS header", offset, size, ErrOutsideBoundary)
+		if err != nil {
+			return err
+		}
+	}
 	err = pe.structUnpack(&pe.DOSHeader, offset, size)
 	if err != nil {
 		return err
@@ -92,17 +143,83 @@ func (pe *File) ParseDOSHeader() (err error) {
 	// DOS header to turn the EXE into a PE. It is is a relative offset to the
 	// NT Headers. It can't be null (signatures would overlap).
 	// Can be 4 at minimum.
-	if pe.DOSHeader.AddressOfNewEXEHeader < 4 ||
-		pe.DOSHeader.AddressOfNewEXEHeader > pe.size {
+	if pe.DOSHeader.AddressOfNewEXEHeader < 4 {
 		return ErrInvalidElfanewValue
 	}
+	// NT Headers pointed beyond the end of the file are most likely the sign
+	// of a truncated file.
+	if uint64(pe.DOSHeader.AddressOfNewEXEHeader) > pe.size {
+		return pe.checkTruncated("NT header signature",
+			pe.DOSHeader.AddressOfNewEXEHeader, 4, ErrInvalidElfanewValue)
+	}
 
 	// tiny pe has a e_lfanew of 4, which means the NT Headers is overlapping
 	// the DOS Header.
 	if pe.DOSHeader.AddressOfNewEXEHeader <= 0x3c {
-		pe.Anomalies = append(pe.Anomalies, AnoPEHeaderOverlapDOSHeader)
+		pe.appendAnomaly(AnoPEHeaderOverlapDOSHeader)
 	}
 
 	pe.HasDOSHdr = true
 	return nil
 }
+
+// hasDOSMagic returns true if the file starts with the MZ or ZM signature,
+// even when it is too small to hold a complete DOS header.
+func (pe *File) hasDOSMagic() bool {
+	if len(pe.data) < 2 {
+		return false
+	}
+	magic := binary.LittleEndian.Uint16(pe.data)
+	return magic == ImageDOSSignature || magic == ImageDOSZMSignature
+}
+
+// ParseDOSStub parses the DOS stub program. It must be called after the Rich
+// header is parsed, as the Rich header marks the end of the stub.
+func (pe *File) ParseDOSStub() error {
+	start := uint32(binary.Size(pe.DOSHeader))
+	end := pe.DOSHeader.AddressOfNewEXEHeader
+	if pe.HasRichHdr && uint32(pe.RichHeader.DansOffset) < end {
+		end = uint32(pe.RichHeader.DansOffset)
+	}
+	if uint64(end) > pe.size {
+		end = uint32(pe.size)
+	}
+	if end <= start {
+		return nil
+	}
+
+	stub := DOSStub{
+		Offset: start,
+		Size:   end - start,
+		Raw:    pe.data[start:end],
+	}
+	pe.markCoverage(start, end-start)
+
+	// The stub program usually prints a `$` terminated message with the
+	// DOS print string function, the message offset is relative to the
+	// start of the program image which follows the header paragraphs.
+	if bytes.HasPrefix(stub.Raw, dosStubPrologue) && len(stub.Raw) > 4 {
+		headerSize := uint32(pe.DOSHeader.SizeOfHeader) * 16
+		if headerSize == 0 || headerSize > end {
+			headerSize = start
+		}
+		msgOffset := headerSize +
+			uint32(binary.LittleEndian.Uint16(stub.Raw[len(dosStubPrologue):]))
+		if msgOffset >= start && msgOffset < end {
+			msg := pe.data[msgOffset:end]
+			if n := bytes.IndexByte(msg, '$'); n >= 0 {
+				stub.Message = string(msg[:n])
+			}
+		}
+	}
+	stub.IsStandard = strings.TrimRight(stub.Message, "\r\n") == DOSStubStandardMessage
+
+	md5Sum := md5.Sum(stub.Raw)
+	stub.MD5 = hex.EncodeToString(md5Sum[:])
+	sha256Sum := sha256.Sum256(stub.Raw)
+	stub.SHA256 = hex.EncodeToString(sha256Sum[:])
+
+	pe.DOSStub = stub
+	pe.HasDOSStub = true
+	return nil
+}
@@ -5,6 +5,7 @@
 package pe
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -97,3 +98,82 @@ func TestParseDOSHeaderNonMZ(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDOSStub(t *testing.T) {
+
+	tests := []struct {
+		in      string
+		present bool
+		stub    DOSStub
+	}{
+		{
+			getAbsoluteFilePath("test/putty.exe"),
+			true,
+			DOSStub{
+				Offset:     0x40,
+				Size:       0x38,
+				Message:    "This program cannot be run in DOS mode.",
+				IsStandard: true,
+				MD5:        "30a2dafa39e0711f3fa71ce9099969c6",
+				SHA256:     "6591a544dfff02dee1e814fa1bb72a220869d24ad6bb6d1e578e029c4e112b12",
+			},
+		},
+		{
+			getAbsoluteFilePath("test/putty_modified.exe"),
+			true,
+			DOSStub{
+				Offset:     0x40,
+				Size:       0x38,
+				Message:    "This program has be modified  DOS mode.",
+				IsStandard: false,
+				MD5:        "466731bf3f5e7c475d27ecfbb8267877",
+				SHA256:     "058708ec1281c90c3342b831dca506b6d0c39f861e372de39d4e072d1b49fb49",
+			},
+		},
+		{
+			getAbsoluteFilePath("test/kernel32.dll"),
+			true,
+			DOSStub{
+				Offset:     0x40,
+				Size:       0x40,
+				Message:    "This program cannot be run in DOS mode.\r\r\n",
+				IsStandard: true,
+				MD5:        "adea9a7c75488de31136524773dee37f",
+				SHA256:     "7764e7022dcac1b5779d1f96fc05af5c1fee394aaff8a3a7e9a881e1a1b163a3",
+			},
+		},
+		{
+			getAbsoluteFilePath("test/impbyord.exe"),
+			false,
+			DOSStub{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			if file.HasDOSStub != tt.present {
+				t.Fatalf("DOS stub presence assertion failed, got %v, want %v",
+					file.HasDOSStub, tt.present)
+			}
+
+			got := file.DOSStub
+			if uint32(len(got.Raw)) != got.Size {
+				t.Errorf("DOS stub raw length assertion failed, got %v, want %v",
+					len(got.Raw), got.Size)
+			}
+			got.Raw = nil
+			if !reflect.DeepEqual(got, tt.stub) {
+				t.Errorf("DOS stub assertion failed, got %+v, want %+v", got, tt.stub)
+			}
+		})
+	}
+}
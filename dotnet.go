@@ -5,12 +5,28 @@
 package pe
 
 import (
+	"bytes"
 	"encoding/binary"
 )
 
 // References
 // https://www.ntcore.com/files/dotnetformat.htm
 
+const (
+	// AnoMetadataStreamOutsideDirectory is reported when a metadata stream
+	// header points beyond the end of the metadata directory.
+	AnoMetadataStreamOutsideDirectory = "Metadata stream is outside the metadata directory"
+
+	// AnoMetadataStreamTruncated is reported when a metadata stream header
+	// declares a size which goes beyond the end of the metadata directory.
+	AnoMetadataStreamTruncated = "Metadata stream is truncated to the metadata directory"
+
+	// AnoCLRHeaderNotInFile is reported when the CLR header is, at least
+	// partly, in the virtual-only part of a section, as seen with packed .NET
+	// images whose stub rebuilds the header at run time.
+	AnoCLRHeaderNotInFile = "CLR header is not backed by the file, it is read as zero-filled memory"
+)
+
 // COMImageFlagsType represents a COM+ header entry point flag type.
 type COMImageFlagsType uint32
 
@@ -197,6 +213,17 @@ const (
 	BlobStream   = 2
 )
 
+// Heaps flags specific to the uncompressed (#-) metadata tables stream.
+const (
+	// The stream only contains the changes made during an edit-and-continue
+	// session.
+	HeapsDeltaOnly = 0x20
+	// A 4-byte value follows the table row counts.
+	HeapsExtraData = 0x40
+	// The metadata might contain items marked as deleted.
+	HeapsHasDelete = 0x80
+)
+
 // MetadataTableIndexToString returns the string representation of the metadata
 // table index.
 func MetadataTableIndexToString(k int) string {
@@ -263,7 +290,7 @@ func (pe *File) GetMetadataStreamIndexSize(BitPosition int) int {
 	// - if bit 1 is set, indexes into the "#GUID" heap are 4 bytes wide;
 	// - if bit 2 is set, indexes into the "#Blob" heap are 4 bytes wide.
 	heaps := pe.CLR.MetadataTablesStreamHeader.Heaps
-	if IsBitSet(uint64(heaps), BitPosition) {
+	if pe.CLR.MinimalDelta || IsBitSet(uint64(heaps), BitPosition) {
 		return 4
 	}
 	// Conversely, if the HeapSizes bit for a particular heap is not set,
@@ -416,6 +443,11 @@ type MetadataStreamHeader struct {
 	// case the size of the stream header is correspondingly reduced, padded to
 	// the 4-byte boundary.
 	Name string `json:"name"`
+
+	// The error encountered while validating the stream against the metadata
+	// directory, if any. A truncated stream is clamped to the directory, a
+	// stream outside of it is not read. The other streams are still parsed.
+	Error string `json:"error,omitempty"`
 }
 
 // MetadataTableStreamHeader represents the Metadata Table Stream Header Structure.
@@ -435,7 +467,9 @@ type MetadataTableStreamHeader struct {
 	// If a flag is not set, the respective heap offset is a 2-byte unsigned integer.
 	// A #- stream can also have special flags set:
 	// - flag 0x20, indicating that the stream contains only changes made
-	// during an edit-and-continue session, and;
+	// during an edit-and-continue session;
+	// - flag 0x40, indicating that an extra 4-byte value follows the table
+	// row counts, and;
 	// - flag 0x80, indicating that the  metadata might contain items marked as
 	// deleted.
 	Heaps uint8 `json:"heaps"`
@@ -465,6 +499,15 @@ type MetadataTable struct {
 	// Every table has a different layout, defined in the ECMA-335 spec.
 	// Content abstract the type each table is pointing to.
 	Content interface{} `json:"content"`
+
+	// File offset and size of the table, computed from the row counts and
+	// the width of the columns.
+	Offset uint32 `json:"offset"`
+	Size   uint32 `json:"size"`
+
+	// The error encountered while parsing the table, if any. The remaining
+	// tables are still parsed as their offsets don't depend on it.
+	Error string `json:"error,omitempty"`
 }
 
 // CLRData embeds the Common Language Runtime Header structure as well as the
@@ -479,8 +522,29 @@ type CLRData struct {
 	StringStreamIndexSize      int                       `json:"-"`
 	GUIDStreamIndexSize        int                       `json:"-"`
 	BlobStreamIndexSize        int                       `json:"-"`
+
+	// MinimalDelta is set when the metadata holds a #JTD stream, as emitted
+	// for edit-and-continue and hot reload deltas. All heap and table indexes
+	// are then 4 bytes wide.
+	MinimalDelta bool `json:"minimal_delta"`
+
+	// ExportAddressTableJumps holds the bytes pointed to by the CLR header
+	// ExportAddressTableJumps, truncated to the end of the file.
+	ExportAddressTableJumps []byte `json:"export_address_table_jumps,omitempty"`
+
+	// ManagedNativeHeader holds the bytes pointed to by the CLR header
+	// ManagedNativeHeader, such as the CORCOMPILE_HEADER of an NGEN image.
+	// It is left empty for ReadyToRun images.
+	ManagedNativeHeader []byte `json:"managed_native_header,omitempty"`
+
+	// ReadyToRun is set when the managed native header is a READYTORUN_HEADER,
+	// as found in images precompiled with crossgen.
+	ReadyToRun bool `json:"ready_to_run"`
 }
 
+// ReadyToRunSignature is the signature `RTR` of a READYTORUN_HEADER.
+const ReadyToRunSignature = 0x00525452
+
 func (pe *File) parseMetadataStream(off, size uint32) (MetadataTableStreamHeader, error) {
 
 	mdTableStreamHdr := MetadataTableStreamHeader{}
@@ -533,21 +597,92 @@ func (pe *File) parseMetadataHeader(offset, size uint32) (MetadataHeader, error)
 	return mh, err
 }
 
+// metadataStreamBounds returns the file offset and the size of a metadata
+// stream, clamped to the metadata directory and to the end of the file.
+func (pe *File) metadataStreamBounds(metadata ImageDataDirectory,
+	sh MetadataStreamHeader) (uint32, uint32, error) {
+
+	start := pe.GetOffsetFromRva(metadata.VirtualAddress + sh.Offset)
+	if sh.Offset >= metadata.Size || start == ^uint32(0) ||
+		pe.checkRange(start, 0) != nil {
+		pe.addAnomaly(AnoMetadataStreamOutsideDirectory)
+		pe.warnf(ImageDirectoryEntryCLR.String(), 0,
+			"metadata stream %s at offset 0x%x is outside the metadata directory",
+			sh.Name, sh.Offset)
+		return 0, 0, ErrMetadataStreamOutsideDirectory
+	}
+
+	size := sh.Size
+	if size > metadata.Size-sh.Offset {
+		size = metadata.Size - sh.Offset
+	}
+	if size > pe.remaining(start) {
+		size = pe.remaining(start)
+	}
+	if size != sh.Size {
+		pe.addAnomaly(AnoMetadataStreamTruncated)
+		pe.warnf(ImageDirectoryEntryCLR.String(), start,
+			"metadata stream %s of size 0x%x is truncated to 0x%x",
+			sh.Name, sh.Size, size)
+		return start, size, ErrMetadataStreamTruncated
+	}
+	return start, size, nil
+}
+
 // The 15th directory entry of the PE header contains the RVA and size of the
 // runtime header in the image file. The runtime header, which contains all of
 // the runtime-specific data entries and other information, should reside in a
 // read-only section of the image file. The IL assembler puts the common
 // language runtime header in the .text section.
+// parseCLRHeaderRaw keeps the bytes of the CLR header directories the library
+// does not parse, the export address table jumps and the managed native
+// header when it is not a ReadyToRun header.
+func (pe *File) parseCLRHeaderRaw() {
+	clrHeader := pe.CLR.CLRHeader
+	if dir := clrHeader.ExportAddressTableJumps; dir.VirtualAddress != 0 {
+		pe.CLR.ExportAddressTableJumps = pe.rawDirectoryData(dir.VirtualAddress,
+			dir.Size)
+	}
+
+	dir := clrHeader.ManagedNativeHeader
+	if dir.VirtualAddress == 0 {
+		return
+	}
+	signature, err := pe.ReadUint32(pe.GetOffsetFromRva(dir.VirtualAddress))
+	if err == nil && signature == ReadyToRunSignature {
+		pe.CLR.ReadyToRun = true
+		return
+	}
+	pe.CLR.ManagedNativeHeader = pe.rawDirectoryData(dir.VirtualAddress, dir.Size)
+}
+
 func (pe *File) parseCLRHeaderDirectory(rva, size uint32) error {
 
 	clrHeader := ImageCOR20Header{}
-	offset := pe.GetOffsetFromRva(rva)
-	err := pe.structUnpack(&clrHeader, offset, size)
-	if err != nil {
-		return err
+	headerSize := uint32(binary.Size(clrHeader))
+	if pe.rvaNotInFile(rva, headerSize) {
+		// Packed images point the COM descriptor into the virtual part of a
+		// section, read it as the loader maps it rather than failing.
+		pe.addAnomaly(AnoCLRHeaderNotInFile)
+		pe.HasCLR = true
+		buf, err := pe.GetMappedData(rva, headerSize)
+		if err != nil {
+			return err
+		}
+		err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &clrHeader)
+		if err != nil {
+			return err
+		}
+	} else {
+		offset := pe.GetOffsetFromRva(rva)
+		err := pe.structUnpack(&clrHeader, offset, size)
+		if err != nil {
+			return err
+		}
 	}
 
 	pe.CLR.CLRHeader = clrHeader
+	pe.parseCLRHeaderRaw()
 	if clrHeader.MetaData.VirtualAddress == 0 || clrHeader.MetaData.Size == 0 {
 		return nil
 	}
@@ -561,7 +696,12 @@ func (pe *File) parseCLRHeaderDirectory(rva, size uint32) error {
 		return nil
 	}
 
-	offset = pe.GetOffsetFromRva(clrHeader.MetaData.VirtualAddress)
+	// The metadata of packed images only exists once unpacked.
+	if pe.rvaNotInFile(clrHeader.MetaData.VirtualAddress, clrHeader.MetaData.Size) {
+		return nil
+	}
+
+	offset := pe.GetOffsetFromRva(clrHeader.MetaData.VirtualAddress)
 	mh, err := pe.parseMetadataHeader(offset, clrHeader.MetaData.Size)
 	if err != nil {
 		return err
@@ -601,19 +741,33 @@ func (pe *File) parseCLRHeaderDirectory(rva, size uint32) error {
 			}
 		}
 
+		if sh.Name == "#JTD" {
+			pe.CLR.MinimalDelta = true
+		}
+
+		// Streams must fit within the metadata directory, a stream which
+		// does not is flagged rather than failing the whole directory.
+		start, streamSize, streamErr := pe.metadataStreamBounds(
+			clrHeader.MetaData, sh)
+		if streamErr != nil {
+			sh.Error = streamErr.Error()
+		}
+		pe.CLR.MetadataStreamHeaders = append(pe.CLR.MetadataStreamHeaders, sh)
+		if streamErr == ErrMetadataStreamOutsideDirectory {
+			continue
+		}
+
 		// The streams #~ and #- are mutually exclusive; that is, the metadata
 		// structure of the module is either optimized or un-optimized; it
 		// cannot be both at the same time or be something in between.
 		if sh.Name == "#~" || sh.Name == "#-" {
 			mdStreamHdrOff = sh.Offset
-			mdStreamHdrSize = sh.Size
+			mdStreamHdrSize = streamSize
 		}
 
 		// Save the stream into a map <string> []byte.
-		rva = clrHeader.MetaData.VirtualAddress + sh.Offset
-		start := pe.GetOffsetFromRva(rva)
-		pe.CLR.MetadataStreams[sh.Name] = pe.data[start : start+sh.Size]
-		pe.CLR.MetadataStreamHeaders = append(pe.CLR.MetadataStreamHeaders, sh)
+		pe.CLR.MetadataStreams[sh.Name] = pe.data[start : start+streamSize]
+		pe.markCoverage(start, streamSize)
 	}
 
 	// Get the Metadata Table Stream.
@@ -624,6 +778,7 @@ func (pe *File) parseCLRHeaderDirectory(rva, size uint32) error {
 	// metadataDirectoryAddress in the CLRHeader.
 	rva = clrHeader.MetaData.VirtualAddress + mdStreamHdrOff
 	offset = pe.GetOffsetFromRva(rva)
+	streamEnd := uint64(offset) + uint64(mdStreamHdrSize)
 	mdTableStreamHdr, err := pe.parseMetadataStream(offset, mdStreamHdrSize)
 	if err != nil {
 		return nil
@@ -653,114 +808,165 @@ func (pe *File) parseCLRHeaderDirectory(rva, size uint32) error {
 		}
 	}
 
-	// Parse the metadata tables.
+	// Uncompressed streams might have an extra 4-byte value right after the
+	// row counts, it has to be skipped before reading the tables.
+	if mdTableStreamHdr.Heaps&HeapsExtraData != 0 {
+		offset += 4
+	}
+
+	// Parse the metadata tables. The offset of each table is computed from
+	// the row counts and the width of the columns rather than from what the
+	// previous table parser consumed, so a malformed table, as found in
+	// obfuscated assemblies, does not prevent the next ones from parsing.
+	tableOffset := uint64(offset)
 	for tableIndex := 0; tableIndex <= GenericParamConstraint; tableIndex++ {
 		table, ok := pe.CLR.MetadataTables[tableIndex]
 		if !ok {
 			continue
 		}
 
-		n := uint32(0)
+		var err error
+		size := uint64(pe.getMetadataTableRowSize(tableIndex)) *
+			uint64(table.CountCols)
+		offset = uint32(tableOffset)
+		table.Offset = offset
+		tableOffset += size
+		if tableOffset > streamEnd {
+			table.Error = ErrMetadataTableOutsideStream.Error()
+			pe.warnf(ImageDirectoryEntryCLR.String(), offset,
+				"metadata table %s at offset 0x%x is outside the tables stream",
+				table.Name, offset)
+			continue
+		}
+		table.Size = uint32(size)
+
 		switch tableIndex {
 		case Module: // 0x00
-			table.Content, n, err = pe.parseMetadataModuleTable(offset)
+			table.Content, _, err = pe.parseMetadataModuleTable(offset)
 		case TypeRef: // 0x01
-			table.Content, n, err = pe.parseMetadataTypeRefTable(offset)
+			table.Content, _, err = pe.parseMetadataTypeRefTable(offset)
 		case TypeDef: // 0x02
-			table.Content, n, err = pe.parseMetadataTypeDefTable(offset)
+			table.Content, _, err = pe.parseMetadataTypeDefTable(offset)
+		case FieldPtr: // 0x03
+			table.Content, _, err = pe.parseMetadataFieldPtrTable(offset)
 		case Field: // 0x04
-			table.Content, n, err = pe.parseMetadataFieldTable(offset)
+			table.Content, _, err = pe.parseMetadataFieldTable(offset)
+		case MethodPtr: // 0x05
+			table.Content, _, err = pe.parseMetadataMethodPtrTable(offset)
 		case MethodDef: // 0x06
-			table.Content, n, err = pe.parseMetadataMethodDefTable(offset)
+			table.Content, _, err = pe.parseMetadataMethodDefTable(offset)
+		case ParamPtr: // 0x07
+			table.Content, _, err = pe.parseMetadataParamPtrTable(offset)
 		case Param: // 0x08
-			table.Content, n, err = pe.parseMetadataParamTable(offset)
+			table.Content, _, err = pe.parseMetadataParamTable(offset)
 		case InterfaceImpl: // 0x09
-			table.Content, n, err = pe.parseMetadataInterfaceImplTable(offset)
+			table.Content, _, err = pe.parseMetadataInterfaceImplTable(offset)
 		case MemberRef: // 0x0a
-			table.Content, n, err = pe.parseMetadataMemberRefTable(offset)
+			table.Content, _, err = pe.parseMetadataMemberRefTable(offset)
 		case Constant: // 0x0b
-			table.Content, n, err = pe.parseMetadataConstantTable(offset)
+			table.Content, _, err = pe.parseMetadataConstantTable(offset)
 		case CustomAttribute: // 0x0c
-			table.Content, n, err = pe.parseMetadataCustomAttributeTable(offset)
+			table.Content, _, err = pe.parseMetadataCustomAttributeTable(offset)
 		case FieldMarshal: // 0x0d
-			table.Content, n, err = pe.parseMetadataFieldMarshalTable(offset)
+			table.Content, _, err = pe.parseMetadataFieldMarshalTable(offset)
 		case DeclSecurity: // 0x0e
-			table.Content, n, err = pe.parseMetadataDeclSecurityTable(offset)
+			table.Content, _, err = pe.parseMetadataDeclSecurityTable(offset)
 		case ClassLayout: // 0x0f
-			table.Content, n, err = pe.parseMetadataClassLayoutTable(offset)
+			table.Content, _, err = pe.parseMetadataClassLayoutTable(offset)
 		case FieldLayout: // 0x10
-			table.Content, n, err = pe.parseMetadataFieldLayoutTable(offset)
+			table.Content, _, err = pe.parseMetadataFieldLayoutTable(offset)
 		case StandAloneSig: // 0x11
-			table.Content, n, err = pe.parseMetadataStandAloneSignTable(offset)
+			table.Content, _, err = pe.parseMetadataStandAloneSignTable(offset)
 		case EventMap: // 0x12
-			table.Content, n, err = pe.parseMetadataEventMapTable(offset)
+			table.Content, _, err = pe.parseMetadataEventMapTable(offset)
+		case EventPtr: // 0x13
+			table.Content, _, err = pe.parseMetadataEventPtrTable(offset)
 		case Event: // 0x14
-			table.Content, n, err = pe.parseMetadataEventTable(offset)
+			table.Content, _, err = pe.parseMetadataEventTable(offset)
 		case PropertyMap: // 0x15
-			table.Content, n, err = pe.parseMetadataPropertyMapTable(offset)
+			table.Content, _, err = pe.parseMetadataPropertyMapTable(offset)
+		case PropertyPtr: // 0x16
+			table.Content, _, err = pe.parseMetadataPropertyPtrTable(offset)
 		case Property: // 0x17
-			table.Content, n, err = pe.parseMetadataPropertyTable(offset)
+			table.Content, _, err = pe.parseMetadataPropertyTable(offset)
 		case MethodSemantics: // 0x18
-			table.Content, n, err = pe.parseMetadataMethodSemanticsTable(offset)
+			table.Content, _, err = pe.parseMetadataMethodSemanticsTable(offset)
 		case MethodImpl: // 0x19
-			table.Content, n, err = pe.parseMetadataMethodImplTable(offset)
+			table.Content, _, err = pe.parseMetadataMethodImplTable(offset)
 		case ModuleRef: // 0x1a
-			table.Content, n, err = pe.parseMetadataModuleRefTable(offset)
+			table.Content, _, err = pe.parseMetadataModuleRefTable(offset)
 		case TypeSpec: // 0x1b
-			table.Content, n, err = pe.parseMetadataTypeSpecTable(offset)
+			table.Content, _, err = pe.parseMetadataTypeSpecTable(offset)
 		case ImplMap: // 0x1c
-			table.Content, n, err = pe.parseMetadataImplMapTable(offset)
+			table.Content, _, err = pe.parseMetadataImplMapTable(offset)
 		case FieldRVA: // 0x1d
-			table.Content, n, err = pe.parseMetadataFieldRVATable(offset)
+			table.Content, _, err = pe.parseMetadataFieldRVATable(offset)
+		case ENCLog: // 0x1e
+			table.Content, _, err = pe.parseMetadataENCLogTable(offset)
+		case ENCMap: // 0x1f
+			table.Content, _, err = pe.parseMetadataENCMapTable(offset)
 		case Assembly: // 0x20
-			table.Content, n, err = pe.parseMetadataAssemblyTable(offset)
+			table.Content, _, err = pe.parseMetadataAssemblyTable(offset)
 		case AssemblyRef: // 0x23
-			table.Content, n, err = pe.parseMetadataAssemblyRefTable(offset)
+			table.Content, _, err = pe.parseMetadataAssemblyRefTable(offset)
 		case ExportedType: // 0x27
-			table.Content, n, err = pe.parseMetadataExportedTypeTable(offset)
+			table.Content, _, err = pe.parseMetadataExportedTypeTable(offset)
 		case ManifestResource: // 0x28
-			table.Content, n, err = pe.parseMetadataManifestResourceTable(offset)
+			table.Content, _, err = pe.parseMetadataManifestResourceTable(offset)
 		case NestedClass: // 0x29
-			table.Content, n, err = pe.parseMetadataNestedClassTable(offset)
+			table.Content, _, err = pe.parseMetadataNestedClassTable(offset)
 		case GenericParam: // 0x2a
-			table.Content, n, err = pe.parseMetadataGenericParamTable(offset)
+			table.Content, _, err = pe.parseMetadataGenericParamTable(offset)
 		case MethodSpec: // 0x2b
-			table.Content, n, err = pe.parseMetadataMethodSpecTable(offset)
+			table.Content, _, err = pe.parseMetadataMethodSpecTable(offset)
 		case GenericParamConstraint: // 0x2c
-			table.Content, n, err = pe.parseMetadataGenericParamConstraintTable(offset)
+			table.Content, _, err = pe.parseMetadataGenericParamConstraintTable(offset)
 		default:
-			pe.logger.Warnf("unhandled metadata table %d %s offset 0x%x cols %d",
+			pe.warnf(ImageDirectoryEntryCLR.String(), offset,
+				"unhandled metadata table %d %s offset 0x%x cols %d",
 				tableIndex, MetadataTableIndexToString(tableIndex), offset, table.CountCols)
 		}
 		if err != nil {
-			pe.logger.Warnf("parsing metadata table %s failed with %v",
+			table.Error = err.Error()
+			pe.warnf(ImageDirectoryEntryCLR.String(), offset,
+				"parsing metadata table %s failed with %v",
 				MetadataTableIndexToString(tableIndex), err)
 		}
-		offset += n
-
 	}
 
 	return nil
 }
 
+// comImageFlags names the flags of a COMImageFlags type.
+var comImageFlags = map[COMImageFlagsType]string{
+	COMImageFlagsILOnly:           "IL Only",
+	COMImageFlags32BitRequired:    "32-Bit Required",
+	COMImageFlagILLibrary:         "IL Library",
+	COMImageFlagsStrongNameSigned: "Strong Name Signed",
+	COMImageFlagsNativeEntrypoint: "Native Entrypoint",
+	COMImageFlagsTrackDebugData:   "Track Debug Data",
+	COMImageFlags32BitPreferred:   "32-Bit Preferred",
+}
+
 // String returns a string interpretation of a COMImageFlags type.
 func (flags COMImageFlagsType) String() []string {
-	COMImageFlags := map[COMImageFlagsType]string{
-		COMImageFlagsILOnly:           "IL Only",
-		COMImageFlags32BitRequired:    "32-Bit Required",
-		COMImageFlagILLibrary:         "IL Library",
-		COMImageFlagsStrongNameSigned: "Strong Name Signed",
-		COMImageFlagsNativeEntrypoint: "Native Entrypoint",
-		COMImageFlagsTrackDebugData:   "Track Debug Data",
-		COMImageFlags32BitPreferred:   "32-Bit Preferred",
-	}
-
-	var values []string
-	for k, v := range COMImageFlags {
-		if (k & flags) == k {
-			values = append(values, v)
-		}
-	}
+	return flags.Flags()
+}
+
+// Flags returns the names of the flags set in a COMImageFlags type, ordered
+// by flag value.
+func (flags COMImageFlagsType) Flags() []string {
+	return flagNames(comImageFlags, func(k uint64) bool {
+		return COMImageFlagsType(k)&flags == COMImageFlagsType(k)
+	})
+}
 
+// FlagsMap returns every flag of a COMImageFlags type mapped to whether it is
+// set.
+func (flags COMImageFlagsType) FlagsMap() map[string]bool {
+	values := make(map[string]bool, len(comImageFlags))
+	for k, v := range comImageFlags {
+		values[v] = (k & flags) == k
+	}
 	return values
 }
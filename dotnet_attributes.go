@@ -0,0 +1,396 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Well-known custom attributes carrying version and provenance information.
+const (
+	AssemblyInformationalVersionAttribute = "System.Reflection.AssemblyInformationalVersionAttribute"
+	TargetFrameworkAttribute              = "System.Runtime.Versioning.TargetFrameworkAttribute"
+	InternalsVisibleToAttribute           = "System.Runtime.CompilerServices.InternalsVisibleToAttribute"
+	DebuggableAttribute                   = "System.Diagnostics.DebuggableAttribute"
+)
+
+// Element types used in signatures and custom attribute blobs, §II.23.1.16.
+const (
+	elementTypeVoid        = 0x01
+	elementTypeBoolean     = 0x02
+	elementTypeChar        = 0x03
+	elementTypeI1          = 0x04
+	elementTypeU1          = 0x05
+	elementTypeI2          = 0x06
+	elementTypeU2          = 0x07
+	elementTypeI4          = 0x08
+	elementTypeU4          = 0x09
+	elementTypeI8          = 0x0a
+	elementTypeU8          = 0x0b
+	elementTypeR4          = 0x0c
+	elementTypeR8          = 0x0d
+	elementTypeString      = 0x0e
+	elementTypePtr         = 0x0f
+	elementTypeByRef       = 0x10
+	elementTypeValueType   = 0x11
+	elementTypeClass       = 0x12
+	elementTypeVar         = 0x13
+	elementTypeArray       = 0x14
+	elementTypeGenericInst = 0x15
+	elementTypeTypedByRef  = 0x16
+	elementTypeI           = 0x18
+	elementTypeU           = 0x19
+	elementTypeFnPtr       = 0x1b
+	elementTypeObject      = 0x1c
+	elementTypeSZArray     = 0x1d
+	elementTypeMVar        = 0x1e
+	elementTypeCModReqd    = 0x1f
+	elementTypeCModOpt     = 0x20
+	elementTypeSentinel    = 0x41
+	elementTypePinned      = 0x45
+	elementTypeSystemType  = 0x50
+	elementTypeBoxed       = 0x51
+	elementTypeEnum        = 0x55
+)
+
+// CLRCustomAttribute represents a custom attribute applied to a metadata
+// item, with its arguments decoded from the #Blob heap.
+type CLRCustomAttribute struct {
+	// Token of the metadata item the attribute is applied to.
+	Parent uint32 `json:"parent"`
+
+	// Full name of the attribute type, for instance
+	// `System.Runtime.Versioning.TargetFrameworkAttribute`.
+	Type string `json:"type"`
+
+	// Constructor arguments, in order. Strings are decoded to string, or
+	// nil when null, enums to their int32 value and arrays to []interface{}.
+	FixedArgs []interface{} `json:"fixed_args"`
+
+	// Values of the fields and properties set by the attribute, by name.
+	NamedArgs map[string]interface{} `json:"named_args"`
+
+	// The error encountered while decoding the arguments, if any. Enums whose
+	// underlying type is not int32 or types from other assemblies used as
+	// arguments can't always be decoded.
+	Error string `json:"error,omitempty"`
+}
+
+// CustomAttributes returns the custom attributes of the metadata along with
+// their decoded arguments. This gives access to the version and provenance
+// information stored in attributes such as AssemblyInformationalVersion,
+// TargetFramework, InternalsVisibleTo or Debuggable.
+func (clr *CLRData) CustomAttributes() []CLRCustomAttribute {
+	rows, _ := clr.metadataTableContent(CustomAttribute).([]CustomAttributeTableRow)
+	if len(rows) == 0 {
+		return nil
+	}
+	owners := clr.methodOwners()
+
+	attributes := make([]CLRCustomAttribute, 0, len(rows))
+	for _, row := range rows {
+		attr := CLRCustomAttribute{}
+		attr.Parent, _ = DecodeCodedIndex(CodedIndexHasCustomAttribute, row.Parent)
+
+		// The attribute type is the type declaring its constructor.
+		var signature uint32
+		ctor, err := DecodeCodedIndex(CodedIndexCustomAttributeType, row.Type)
+		if err == nil {
+			resolved, err := clr.ResolveToken(ctor)
+			if err == nil {
+				switch method := resolved.Content.(type) {
+				case MethodDefTableRow:
+					attr.Type = clr.typeName(TypeDef<<24 | owners[resolved.Row])
+					signature = method.Signature
+				case MemberRefTableRow:
+					parent, err := DecodeCodedIndex(CodedIndexMemberRefParent, method.Class)
+					if err == nil {
+						attr.Type = clr.typeName(parent)
+					}
+					signature = method.Signature
+				}
+			}
+		}
+
+		if err := clr.decodeCustomAttributeValue(&attr, signature, row.Value); err != nil {
+			attr.Error = err.Error()
+		}
+		attributes = append(attributes, attr)
+	}
+
+	return attributes
+}
+
+// CustomAttributeValues returns the first constructor argument of each
+// custom attribute of the given type, which are strings for the well-known
+// attributes AssemblyInformationalVersion, TargetFramework and
+// InternalsVisibleTo.
+func (clr *CLRData) CustomAttributeValues(attributeType string) []interface{} {
+	var values []interface{}
+	for _, attr := range clr.CustomAttributes() {
+		if attr.Type == attributeType && len(attr.FixedArgs) > 0 {
+			values = append(values, attr.FixedArgs[0])
+		}
+	}
+	return values
+}
+
+// decodeCustomAttributeValue decodes the arguments stored in a custom
+// attribute value blob, §II.23.3, using the constructor signature to learn
+// the types of the fixed arguments.
+func (clr *CLRData) decodeCustomAttributeValue(attr *CLRCustomAttribute,
+	signature, value uint32) error {
+
+	sig, err := clr.getBlob("#Blob", signature)
+	if err != nil {
+		return err
+	}
+	params, err := clr.parseMethodSigParams(sig)
+	if err != nil {
+		return err
+	}
+
+	blob, err := clr.getBlob("#Blob", value)
+	if err != nil {
+		return err
+	}
+	r := &blobReader{data: blob}
+	if prolog := r.readUint16(); r.err == nil && prolog != 0x0001 {
+		return ErrInvalidCustomAttributeBlob
+	}
+
+	for _, param := range params {
+		arg, err := r.readElem(param.elementType, param.arrayOf, param.typeName)
+		if err != nil {
+			return err
+		}
+		attr.FixedArgs = append(attr.FixedArgs, arg)
+	}
+
+	numNamed := r.readUint16()
+	if r.err != nil {
+		return r.err
+	}
+	for i := uint16(0); i < numNamed; i++ {
+		// A FIELD (0x53) or a PROPERTY (0x54) followed by its type.
+		r.readUint8()
+		elementType, arrayOf, err := r.readFieldOrPropType()
+		if err != nil {
+			return err
+		}
+		name, _ := r.readSerString()
+		arg, err := r.readElem(elementType, arrayOf, "")
+		if err != nil {
+			return err
+		}
+		if attr.NamedArgs == nil {
+			attr.NamedArgs = make(map[string]interface{})
+		}
+		attr.NamedArgs[name] = arg
+	}
+	return nil
+}
+
+// sigParam is a parameter of a constructor signature.
+type sigParam struct {
+	elementType uint8
+	arrayOf     uint8
+	typeName    string
+}
+
+// parseMethodSigParams returns the parameters types of a MethodDefSig or
+// MethodRefSig, §II.23.2.1.
+func (clr *CLRData) parseMethodSigParams(sig []byte) ([]sigParam, error) {
+	r := &blobReader{data: sig}
+	callingConvention := r.readUint8()
+	if callingConvention&0x10 != 0 {
+		r.readCompressedUint() // generic parameters count
+	}
+	count := r.readCompressedUint()
+	if ret := r.readUint8(); r.err == nil && ret != elementTypeVoid {
+		return nil, ErrInvalidCustomAttributeBlob
+	}
+
+	var params []sigParam
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		param := sigParam{elementType: r.readUint8()}
+		if param.elementType == elementTypeSZArray {
+			param.arrayOf = r.readUint8()
+		}
+		switch param.elementType {
+		case elementTypeValueType, elementTypeClass:
+			param.typeName = clr.typeDefOrRefName(r.readCompressedUint())
+		case elementTypeSZArray:
+			switch param.arrayOf {
+			case elementTypeValueType, elementTypeClass:
+				param.typeName = clr.typeDefOrRefName(r.readCompressedUint())
+			}
+		}
+		params = append(params, param)
+	}
+	return params, r.err
+}
+
+// blobReader reads consecutive values from a blob, the first error is kept
+// and subsequent reads return zero values.
+type blobReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *blobReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.off+n > len(r.data) {
+		r.err = ErrOutsideBoundary
+		return nil
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *blobReader) readUint8() uint8 {
+	if b := r.read(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *blobReader) readUint16() uint16 {
+	if b := r.read(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *blobReader) readUint32() uint32 {
+	if b := r.read(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *blobReader) readUint64() uint64 {
+	if b := r.read(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *blobReader) readCompressedUint() uint32 {
+	if r.err != nil || r.off >= len(r.data) {
+		r.err = ErrOutsideBoundary
+		return 0
+	}
+	value, n, err := decodeCompressedUint(r.data[r.off:])
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	r.off += int(n)
+	return value
+}
+
+// readSerString reads a SerString, a compressed length followed by UTF-8
+// characters. A length of 0xff stands for the null string.
+func (r *blobReader) readSerString() (string, bool) {
+	if r.err == nil && r.off < len(r.data) && r.data[r.off] == 0xff {
+		r.off++
+		return "", false
+	}
+	length := r.readCompressedUint()
+	return string(r.read(int(length))), r.err == nil
+}
+
+// readFieldOrPropType reads the type of a named argument or a boxed value.
+func (r *blobReader) readFieldOrPropType() (uint8, uint8, error) {
+	elementType := r.readUint8()
+	var arrayOf uint8
+	switch elementType {
+	case elementTypeSZArray:
+		arrayOf = r.readUint8()
+		if arrayOf == elementTypeEnum {
+			r.readSerString()
+		}
+	case elementTypeEnum:
+		// The enum type name, its underlying type is assumed to be int32.
+		r.readSerString()
+	}
+	return elementType, arrayOf, r.err
+}
+
+// readElem reads a value of the given type. Enums, whether coming from a
+// value type in the constructor signature or from a named argument, are
+// assumed to have int32 as underlying type.
+func (r *blobReader) readElem(elementType, arrayOf uint8, typeName string) (interface{}, error) {
+	var v interface{}
+	switch elementType {
+	case elementTypeBoolean:
+		v = r.readUint8() != 0
+	case elementTypeChar:
+		v = rune(r.readUint16())
+	case elementTypeI1:
+		v = int8(r.readUint8())
+	case elementTypeU1:
+		v = r.readUint8()
+	case elementTypeI2:
+		v = int16(r.readUint16())
+	case elementTypeU2:
+		v = r.readUint16()
+	case elementTypeI4, elementTypeValueType, elementTypeEnum:
+		v = int32(r.readUint32())
+	case elementTypeU4:
+		v = r.readUint32()
+	case elementTypeI8:
+		v = int64(r.readUint64())
+	case elementTypeU8:
+		v = r.readUint64()
+	case elementTypeR4:
+		v = math.Float32frombits(r.readUint32())
+	case elementTypeR8:
+		v = math.Float64frombits(r.readUint64())
+	case elementTypeString, elementTypeSystemType:
+		if str, ok := r.readSerString(); ok {
+			v = str
+		}
+	case elementTypeClass:
+		if typeName != "System.Type" {
+			return nil, ErrUnsupportedCustomAttributeArg
+		}
+		if str, ok := r.readSerString(); ok {
+			v = str
+		}
+	case elementTypeObject, elementTypeBoxed:
+		boxedType, boxedArrayOf, err := r.readFieldOrPropType()
+		if err != nil {
+			return nil, err
+		}
+		return r.readElem(boxedType, boxedArrayOf, "")
+	case elementTypeSZArray:
+		count := r.readUint32()
+		if r.err != nil || count == 0xffffffff {
+			break
+		}
+		if int(count) > len(r.data)-r.off {
+			return nil, ErrOutsideBoundary
+		}
+		elems := make([]interface{}, 0, count)
+		for i := uint32(0); i < count; i++ {
+			elem, err := r.readElem(arrayOf, 0, "")
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		v = elems
+	default:
+		return nil, ErrUnsupportedCustomAttributeArg
+	}
+	return v, r.err
+}
@@ -0,0 +1,157 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClrCustomAttributes(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out []CLRCustomAttribute
+	}{
+		{
+			getAbsoluteFilePath("test/mscorlib.dll"),
+			[]CLRCustomAttribute{
+				{
+					Parent:    0x20000001,
+					Type:      AssemblyInformationalVersionAttribute,
+					FixedArgs: []interface{}{"5.0.0+cf258a14b70ad9069470a108f13765e0e5988f51"},
+				},
+				{
+					Parent:    0x20000001,
+					Type:      TargetFrameworkAttribute,
+					FixedArgs: []interface{}{".NETCoreApp,Version=v5.0"},
+					NamedArgs: map[string]interface{}{"FrameworkDisplayName": ""},
+				},
+				{
+					Parent:    0x20000001,
+					Type:      DebuggableAttribute,
+					FixedArgs: []interface{}{int32(2)},
+				},
+			},
+		},
+		{
+			getAbsoluteFilePath("test/pspluginwkr.dll"),
+			[]CLRCustomAttribute{
+				{
+					Parent:    0x01000023,
+					Type:      "System.Security.Permissions.SecurityPermissionAttribute",
+					FixedArgs: []interface{}{int32(8)},
+					NamedArgs: map[string]interface{}{"SkipVerification": true},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			attributes := file.CLR.CustomAttributes()
+			for _, want := range tt.out {
+				found := false
+				for _, got := range attributes {
+					if got.Type == want.Type {
+						found = true
+						if !reflect.DeepEqual(got, want) {
+							t.Errorf("custom attribute assertion failed, got %v, want %v",
+								got, want)
+						}
+						break
+					}
+				}
+				if !found {
+					t.Errorf("custom attribute %s not found", want.Type)
+				}
+			}
+		})
+	}
+}
+
+func TestClrCustomAttributeValue(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		signature []byte
+		value     []byte
+		out       CLRCustomAttribute
+		err       error
+	}{
+		{
+			// InternalsVisibleToAttribute(string)
+			"InternalsVisibleTo",
+			[]byte{0x20, 0x01, 0x01, 0x0e},
+			[]byte{0x01, 0x00, 0x05, 'T', 'e', 's', 't', 's', 0x00, 0x00},
+			CLRCustomAttribute{FixedArgs: []interface{}{"Tests"}},
+			nil,
+		},
+		{
+			// DebuggableAttribute(bool, bool)
+			"Debuggable",
+			[]byte{0x20, 0x02, 0x01, 0x02, 0x02},
+			[]byte{0x01, 0x00, 0x01, 0x00, 0x00, 0x00},
+			CLRCustomAttribute{FixedArgs: []interface{}{true, false}},
+			nil,
+		},
+		{
+			// A null string and a string array.
+			"NullStringAndArray",
+			[]byte{0x20, 0x02, 0x01, 0x0e, 0x1d, 0x0e},
+			[]byte{0x01, 0x00, 0xff, 0x02, 0x00, 0x00, 0x00, 0x01, 'a', 0x01,
+				'b', 0x00, 0x00},
+			CLRCustomAttribute{FixedArgs: []interface{}{nil, []interface{}{"a", "b"}}},
+			nil,
+		},
+		{
+			"InvalidProlog",
+			[]byte{0x20, 0x00, 0x01},
+			[]byte{0x02, 0x00, 0x00, 0x00},
+			CLRCustomAttribute{},
+			ErrInvalidCustomAttributeBlob,
+		},
+		{
+			"Truncated",
+			[]byte{0x20, 0x01, 0x01, 0x08},
+			[]byte{0x01, 0x00, 0x02},
+			CLRCustomAttribute{},
+			ErrOutsideBoundary,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The #Blob heap starts with an empty blob.
+			heap := []byte{0x00, byte(len(tt.signature))}
+			heap = append(heap, tt.signature...)
+			heap = append(heap, byte(len(tt.value)))
+			heap = append(heap, tt.value...)
+			clr := CLRData{MetadataStreams: map[string][]byte{"#Blob": heap}}
+
+			got := CLRCustomAttribute{}
+			err := clr.decodeCustomAttributeValue(&got, 1,
+				uint32(2+len(tt.signature)))
+			if err != tt.err {
+				t.Fatalf("decoding custom attribute failed, got %v, want %v",
+					err, tt.err)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("custom attribute assertion failed, got %v, want %v",
+					got, tt.out)
+			}
+		})
+	}
+}
@@ -10,6 +10,10 @@ const (
 	idxStringStream = iota + 100
 	idxGUIDStream
 	idxBlobStream
+
+	// placeholder for the tags which are not used by a coded index, their
+	// position matters when decoding the index
+	idxUnused = -1
 )
 
 type codedidx struct {
@@ -22,14 +26,14 @@ var (
 	idxResolutionScope     = codedidx{tagbits: 2, idx: []int{Module, ModuleRef, AssemblyRef, TypeRef}}
 	idxMemberRefParent     = codedidx{tagbits: 3, idx: []int{TypeDef, TypeRef, ModuleRef, MethodDef, TypeSpec}}
 	idxHasConstant         = codedidx{tagbits: 2, idx: []int{Field, Param, Property}}
-	idxHasCustomAttributes = codedidx{tagbits: 5, idx: []int{Field, TypeRef, TypeDef, Param, InterfaceImpl, MemberRef, Module, Property, Event, StandAloneSig, ModuleRef, TypeSpec, Assembly, AssemblyRef, FileMD, ExportedType, ManifestResource}}
-	idxCustomAttributeType = codedidx{tagbits: 3, idx: []int{MethodDef, MemberRef}}
+	idxHasCustomAttributes = codedidx{tagbits: 5, idx: []int{MethodDef, Field, TypeRef, TypeDef, Param, InterfaceImpl, MemberRef, Module, DeclSecurity, Property, Event, StandAloneSig, ModuleRef, TypeSpec, Assembly, AssemblyRef, FileMD, ExportedType, ManifestResource, GenericParam, GenericParamConstraint, MethodSpec}}
+	idxCustomAttributeType = codedidx{tagbits: 3, idx: []int{idxUnused, idxUnused, MethodDef, MemberRef, idxUnused}}
 	idxHasFieldMarshall    = codedidx{tagbits: 1, idx: []int{Field, Param}}
 	idxHasDeclSecurity     = codedidx{tagbits: 2, idx: []int{TypeDef, MethodDef, Assembly}}
 	idxHasSemantics        = codedidx{tagbits: 1, idx: []int{Event, Property}}
 	idxMethodDefOrRef      = codedidx{tagbits: 1, idx: []int{MethodDef, MemberRef}}
 	idxMemberForwarded     = codedidx{tagbits: 1, idx: []int{Field, MethodDef}}
-	idxImplementation      = codedidx{tagbits: 2, idx: []int{AssemblyRef, ExportedType}}
+	idxImplementation      = codedidx{tagbits: 2, idx: []int{FileMD, AssemblyRef, ExportedType}}
 	idxTypeOrMethodDef     = codedidx{tagbits: 1, idx: []int{TypeDef, MethodDef}}
 
 	idxField        = codedidx{tagbits: 0, idx: []int{Field}}
@@ -40,12 +44,79 @@ var (
 	idxProperty     = codedidx{tagbits: 0, idx: []int{Property}}
 	idxModuleRef    = codedidx{tagbits: 0, idx: []int{ModuleRef}}
 	idxGenericParam = codedidx{tagbits: 0, idx: []int{GenericParam}}
+	idxAssemblyRef  = codedidx{tagbits: 0, idx: []int{AssemblyRef}}
 
 	idxString = codedidx{tagbits: 0, idx: []int{idxStringStream}}
 	idxBlob   = codedidx{tagbits: 0, idx: []int{idxBlobStream}}
 	idxGUID   = codedidx{tagbits: 0, idx: []int{idxGUIDStream}}
 )
 
+// metadataTableColumns describes the columns of each metadata table as per
+// ECMA-335 §II.22. Constant columns are given by their size in bytes, the
+// others by the index they hold, whose size depends on the metadata.
+var metadataTableColumns = map[int][]interface{}{
+	Module:                 {2, idxString, idxGUID, idxGUID, idxGUID},
+	TypeRef:                {idxResolutionScope, idxString, idxString},
+	TypeDef:                {4, idxString, idxString, idxTypeDefOrRef, idxField, idxMethodDef},
+	FieldPtr:               {idxField},
+	Field:                  {2, idxString, idxBlob},
+	MethodPtr:              {idxMethodDef},
+	MethodDef:              {4, 2, 2, idxString, idxBlob, idxParam},
+	ParamPtr:               {idxParam},
+	Param:                  {2, 2, idxString},
+	InterfaceImpl:          {idxTypeDef, idxTypeDefOrRef},
+	MemberRef:              {idxMemberRefParent, idxString, idxBlob},
+	Constant:               {1, 1, idxHasConstant, idxBlob},
+	CustomAttribute:        {idxHasCustomAttributes, idxCustomAttributeType, idxBlob},
+	FieldMarshal:           {idxHasFieldMarshall, idxBlob},
+	DeclSecurity:           {2, idxHasDeclSecurity, idxBlob},
+	ClassLayout:            {2, 4, idxTypeDef},
+	FieldLayout:            {4, idxField},
+	StandAloneSig:          {idxBlob},
+	EventMap:               {idxTypeDef, idxEvent},
+	EventPtr:               {idxEvent},
+	Event:                  {2, idxString, idxTypeDefOrRef},
+	PropertyMap:            {idxTypeDef, idxProperty},
+	PropertyPtr:            {idxProperty},
+	Property:               {2, idxString, idxBlob},
+	MethodSemantics:        {2, idxMethodDef, idxHasSemantics},
+	MethodImpl:             {idxTypeDef, idxMethodDefOrRef, idxMethodDefOrRef},
+	ModuleRef:              {idxString},
+	TypeSpec:               {idxBlob},
+	ImplMap:                {2, idxMemberForwarded, idxString, idxModuleRef},
+	FieldRVA:               {4, idxField},
+	ENCLog:                 {4, 4},
+	ENCMap:                 {4},
+	Assembly:               {4, 2, 2, 2, 2, 4, idxBlob, idxString, idxString},
+	AssemblyProcessor:      {4},
+	AssemblyOS:             {4, 4, 4},
+	AssemblyRef:            {2, 2, 2, 2, 4, idxBlob, idxString, idxString, idxBlob},
+	AssemblyRefProcessor:   {4, idxAssemblyRef},
+	AssemblyRefOS:          {4, 4, 4, idxAssemblyRef},
+	FileMD:                 {4, idxString, idxBlob},
+	ExportedType:           {4, 4, idxString, idxString, idxImplementation},
+	ManifestResource:       {4, 4, idxString, idxImplementation},
+	NestedClass:            {idxTypeDef, idxTypeDef},
+	GenericParam:           {2, 2, idxTypeOrMethodDef, idxString},
+	MethodSpec:             {idxMethodDefOrRef, idxBlob},
+	GenericParamConstraint: {idxGenericParam, idxTypeDefOrRef},
+}
+
+// getMetadataTableRowSize returns the size in bytes of a row of the given
+// metadata table.
+func (pe *File) getMetadataTableRowSize(table int) uint32 {
+	var size uint32
+	for _, col := range metadataTableColumns[table] {
+		switch c := col.(type) {
+		case int:
+			size += uint32(c)
+		case codedidx:
+			size += pe.getCodedIndexSize(uint32(c.tagbits), c.idx...)
+		}
+	}
+	return size
+}
+
 func (pe *File) getCodedIndexSize(tagbits uint32, idx ...int) uint32 {
 	// special case String/GUID/Blob streams
 	switch idx[0] {
@@ -57,6 +128,11 @@ func (pe *File) getCodedIndexSize(tagbits uint32, idx ...int) uint32 {
 		return uint32(pe.GetMetadataStreamIndexSize(BlobStream))
 	}
 
+	// all indexes are 4 bytes wide in minimal delta metadata
+	if pe.CLR.MinimalDelta {
+		return 4
+	}
+
 	// now deal with coded indices or single table
 	var maxIndex16 uint32 = 1 << (16 - tagbits)
 	var maxColumnCount uint32
@@ -95,3 +171,77 @@ func (pe *File) readFromMetadataStream(cidx codedidx, off uint32, out *uint32) (
 	*out = data
 	return uint32(indexSize), nil
 }
+
+// Coded index kinds, §II.24.2.6.
+const (
+	CodedIndexTypeDefOrRef = iota
+	CodedIndexHasConstant
+	CodedIndexHasCustomAttribute
+	CodedIndexHasFieldMarshal
+	CodedIndexHasDeclSecurity
+	CodedIndexMemberRefParent
+	CodedIndexHasSemantics
+	CodedIndexMethodDefOrRef
+	CodedIndexMemberForwarded
+	CodedIndexImplementation
+	CodedIndexCustomAttributeType
+	CodedIndexResolutionScope
+	CodedIndexTypeOrMethodDef
+)
+
+var codedIndexes = map[int]codedidx{
+	CodedIndexTypeDefOrRef:        idxTypeDefOrRef,
+	CodedIndexHasConstant:         idxHasConstant,
+	CodedIndexHasCustomAttribute:  idxHasCustomAttributes,
+	CodedIndexHasFieldMarshal:     idxHasFieldMarshall,
+	CodedIndexHasDeclSecurity:     idxHasDeclSecurity,
+	CodedIndexMemberRefParent:     idxMemberRefParent,
+	CodedIndexHasSemantics:        idxHasSemantics,
+	CodedIndexMethodDefOrRef:      idxMethodDefOrRef,
+	CodedIndexMemberForwarded:     idxMemberForwarded,
+	CodedIndexImplementation:      idxImplementation,
+	CodedIndexCustomAttributeType: idxCustomAttributeType,
+	CodedIndexResolutionScope:     idxResolutionScope,
+	CodedIndexTypeOrMethodDef:     idxTypeOrMethodDef,
+}
+
+// DecodeCodedIndex returns the metadata token referenced by a coded index of
+// the given kind. The low bits of a coded index tag the table, the remaining
+// ones hold the row.
+func DecodeCodedIndex(kind int, value uint32) (uint32, error) {
+	cidx, ok := codedIndexes[kind]
+	if !ok {
+		return 0, ErrInvalidCodedIndex
+	}
+
+	tag := value & (1<<cidx.tagbits - 1)
+	if tag >= uint32(len(cidx.idx)) || cidx.idx[tag] == idxUnused {
+		return 0, ErrInvalidCodedIndex
+	}
+	return uint32(cidx.idx[tag])<<24 | value>>cidx.tagbits, nil
+}
+
+// decodeCompressedUint decodes an unsigned integer compressed as per §II.23.2
+// and returns it along with the number of bytes it occupies.
+func decodeCompressedUint(data []byte) (uint32, uint32, error) {
+	if len(data) == 0 {
+		return 0, 0, ErrOutsideBoundary
+	}
+
+	switch {
+	case data[0]&0x80 == 0:
+		return uint32(data[0]), 1, nil
+	case data[0]&0xc0 == 0x80:
+		if len(data) < 2 {
+			return 0, 0, ErrOutsideBoundary
+		}
+		return uint32(data[0]&0x3f)<<8 | uint32(data[1]), 2, nil
+	case data[0]&0xe0 == 0xc0:
+		if len(data) < 4 {
+			return 0, 0, ErrOutsideBoundary
+		}
+		return uint32(data[0]&0x1f)<<24 | uint32(data[1])<<16 |
+			uint32(data[2])<<8 | uint32(data[3]), 4, nil
+	}
+	return 0, 0, ErrInvalidCompressedUint
+}
@@ -172,6 +172,29 @@ func (pe *File) parseMetadataTypeDefTable(off uint32) ([]TypeDefTableRow, uint32
 	return rows, n, nil
 }
 
+// FieldPtr 0x03
+type FieldPtrTableRow struct {
+	Field uint32 `json:"field"` // an index into the Field table
+}
+
+// FieldPtr 0x03
+func (pe *File) parseMetadataFieldPtrTable(off uint32) ([]FieldPtrTableRow, uint32, error) {
+	var err error
+	var indexSize uint32
+	var n uint32
+
+	rowCount := int(pe.CLR.MetadataTables[FieldPtr].CountCols)
+	rows := make([]FieldPtrTableRow, rowCount)
+	for i := 0; i < rowCount; i++ {
+		if indexSize, err = pe.readFromMetadataStream(idxField, off, &rows[i].Field); err != nil {
+			return rows, n, err
+		}
+		off += indexSize
+		n += indexSize
+	}
+	return rows, n, nil
+}
+
 // Field 0x04
 type FieldTableRow struct {
 	// a 2-byte bitmask of type FieldAttributes, §II.23.1.5
@@ -212,6 +235,29 @@ func (pe *File) parseMetadataFieldTable(off uint32) ([]FieldTableRow, uint32, er
 	return rows, n, nil
 }
 
+// MethodPtr 0x05
+type MethodPtrTableRow struct {
+	Method uint32 `json:"method"` // an index into the MethodDef table
+}
+
+// MethodPtr 0x05
+func (pe *File) parseMetadataMethodPtrTable(off uint32) ([]MethodPtrTableRow, uint32, error) {
+	var err error
+	var indexSize uint32
+	var n uint32
+
+	rowCount := int(pe.CLR.MetadataTables[MethodPtr].CountCols)
+	rows := make([]MethodPtrTableRow, rowCount)
+	for i := 0; i < rowCount; i++ {
+		if indexSize, err = pe.readFromMetadataStream(idxMethodDef, off, &rows[i].Method); err != nil {
+			return rows, n, err
+		}
+		off += indexSize
+		n += indexSize
+	}
+	return rows, n, nil
+}
+
 // MethodDef 0x06
 type MethodDefTableRow struct {
 	// a 4-byte constant
@@ -276,6 +322,29 @@ func (pe *File) parseMetadataMethodDefTable(off uint32) ([]MethodDefTableRow, ui
 	return rows, n, nil
 }
 
+// ParamPtr 0x07
+type ParamPtrTableRow struct {
+	Param uint32 `json:"param"` // an index into the Param table
+}
+
+// ParamPtr 0x07
+func (pe *File) parseMetadataParamPtrTable(off uint32) ([]ParamPtrTableRow, uint32, error) {
+	var err error
+	var indexSize uint32
+	var n uint32
+
+	rowCount := int(pe.CLR.MetadataTables[ParamPtr].CountCols)
+	rows := make([]ParamPtrTableRow, rowCount)
+	for i := 0; i < rowCount; i++ {
+		if indexSize, err = pe.readFromMetadataStream(idxParam, off, &rows[i].Param); err != nil {
+			return rows, n, err
+		}
+		off += indexSize
+		n += indexSize
+	}
+	return rows, n, nil
+}
+
 // Param 0x08
 type ParamTableRow struct {
 	// a 2-byte bitmask of type ParamAttributes, §II.23.1.13
@@ -683,6 +752,29 @@ func (pe *File) parseMetadataEventMapTable(off uint32) ([]EventMapTableRow, uint
 	return rows, n, nil
 }
 
+// EventPtr 0x13
+type EventPtrTableRow struct {
+	Event uint32 `json:"event"` // an index into the Event table
+}
+
+// EventPtr 0x13
+func (pe *File) parseMetadataEventPtrTable(off uint32) ([]EventPtrTableRow, uint32, error) {
+	var err error
+	var indexSize uint32
+	var n uint32
+
+	rowCount := int(pe.CLR.MetadataTables[EventPtr].CountCols)
+	rows := make([]EventPtrTableRow, rowCount)
+	for i := 0; i < rowCount; i++ {
+		if indexSize, err = pe.readFromMetadataStream(idxEvent, off, &rows[i].Event); err != nil {
+			return rows, n, err
+		}
+		off += indexSize
+		n += indexSize
+	}
+	return rows, n, nil
+}
+
 // Event 0x14
 type EventTableRow struct {
 	// a 2-byte bitmask of type EventAttributes, §II.23.1.4
@@ -756,6 +848,29 @@ func (pe *File) parseMetadataPropertyMapTable(off uint32) ([]PropertyMapTableRow
 	return rows, n, nil
 }
 
+// PropertyPtr 0x16
+type PropertyPtrTableRow struct {
+	Property uint32 `json:"property"` // an index into the Property table
+}
+
+// PropertyPtr 0x16
+func (pe *File) parseMetadataPropertyPtrTable(off uint32) ([]PropertyPtrTableRow, uint32, error) {
+	var err error
+	var indexSize uint32
+	var n uint32
+
+	rowCount := int(pe.CLR.MetadataTables[PropertyPtr].CountCols)
+	rows := make([]PropertyPtrTableRow, rowCount)
+	for i := 0; i < rowCount; i++ {
+		if indexSize, err = pe.readFromMetadataStream(idxProperty, off, &rows[i].Property); err != nil {
+			return rows, n, err
+		}
+		off += indexSize
+		n += indexSize
+	}
+	return rows, n, nil
+}
+
 // Property 0x17
 type PropertyTableRow struct {
 	// a 2-byte bitmask of type PropertyAttributes, §II.23.1.14
@@ -1008,6 +1123,57 @@ func (pe *File) parseMetadataFieldRVATable(off uint32) ([]FieldRVATableRow, uint
 	return rows, n, nil
 }
 
+// ENCLog 0x1e
+type ENCLogTableRow struct {
+	Token    uint32 `json:"token"`     // a 4-byte metadata token of the edited item
+	FuncCode uint32 `json:"func_code"` // a 4-byte code of the edit operation
+}
+
+// ENCLog 0x1e
+func (pe *File) parseMetadataENCLogTable(off uint32) ([]ENCLogTableRow, uint32, error) {
+	var err error
+	var n uint32
+
+	rowCount := int(pe.CLR.MetadataTables[ENCLog].CountCols)
+	rows := make([]ENCLogTableRow, rowCount)
+	for i := 0; i < rowCount; i++ {
+		if rows[i].Token, err = pe.ReadUint32(off); err != nil {
+			return rows, n, err
+		}
+		off += 4
+		n += 4
+
+		if rows[i].FuncCode, err = pe.ReadUint32(off); err != nil {
+			return rows, n, err
+		}
+		off += 4
+		n += 4
+	}
+	return rows, n, nil
+}
+
+// ENCMap 0x1f
+type ENCMapTableRow struct {
+	Token uint32 `json:"token"` // a 4-byte metadata token of the edited item
+}
+
+// ENCMap 0x1f
+func (pe *File) parseMetadataENCMapTable(off uint32) ([]ENCMapTableRow, uint32, error) {
+	var err error
+	var n uint32
+
+	rowCount := int(pe.CLR.MetadataTables[ENCMap].CountCols)
+	rows := make([]ENCMapTableRow, rowCount)
+	for i := 0; i < rowCount; i++ {
+		if rows[i].Token, err = pe.ReadUint32(off); err != nil {
+			return rows, n, err
+		}
+		off += 4
+		n += 4
+	}
+	return rows, n, nil
+}
+
 // Assembly 0x20
 type AssemblyTableRow struct {
 	// a 4-byte constant of type AssemblyHashAlgorithm, §II.23.1.1
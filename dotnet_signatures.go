@@ -0,0 +1,451 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Calling conventions found in the first byte of a method signature,
+// §II.23.2.1. The low nibble holds the convention, the high one the flags.
+const (
+	CallConvDefault     = 0x00
+	CallConvC           = 0x01
+	CallConvStdCall     = 0x02
+	CallConvThisCall    = 0x03
+	CallConvFastCall    = 0x04
+	CallConvVarArg      = 0x05
+	CallConvField       = 0x06
+	CallConvLocalSig    = 0x07
+	CallConvProperty    = 0x08
+	CallConvUnmanaged   = 0x09
+	CallConvGenericInst = 0x0a
+
+	// CallConvGeneric indicates that the method has generic parameters.
+	CallConvGeneric = 0x10
+
+	// CallConvHasThis indicates an instance method.
+	CallConvHasThis = 0x20
+
+	// CallConvExplicitThis indicates that the this pointer is the first
+	// parameter of the signature.
+	CallConvExplicitThis = 0x40
+)
+
+// maxSignatureDepth bounds the nesting of types in a signature, such as
+// arrays of generic instances, to stop on crafted recursive TypeSpecs.
+const maxSignatureDepth = 32
+
+// ILAsm names of the primitive element types.
+var elementTypeNames = map[uint8]string{
+	elementTypeVoid:       "void",
+	elementTypeBoolean:    "bool",
+	elementTypeChar:       "char",
+	elementTypeI1:         "int8",
+	elementTypeU1:         "uint8",
+	elementTypeI2:         "int16",
+	elementTypeU2:         "uint16",
+	elementTypeI4:         "int32",
+	elementTypeU4:         "uint32",
+	elementTypeI8:         "int64",
+	elementTypeU8:         "uint64",
+	elementTypeR4:         "float32",
+	elementTypeR8:         "float64",
+	elementTypeString:     "string",
+	elementTypeTypedByRef: "typedref",
+	elementTypeI:          "native int",
+	elementTypeU:          "native uint",
+	elementTypeObject:     "object",
+}
+
+// CLRMethodSignature represents a decoded MethodDefSig, MethodRefSig or
+// StandAloneMethodSig, §II.23.2.1 to §II.23.2.3. Types are rendered with
+// their ILAsm names, such as `int32`, `string[]` or
+// `System.Collections.Generic.List`1<!0>`.
+type CLRMethodSignature struct {
+	// Calling convention and flags, see the CallConv constants.
+	CallingConvention uint8 `json:"calling_convention"`
+
+	// Number of generic parameters of a generic method.
+	GenericParamCount uint32 `json:"generic_param_count"`
+
+	// Type returned by the method.
+	ReturnType string `json:"return_type"`
+
+	// Types of the parameters, in order. The extra arguments of a vararg
+	// call site follow a `...` entry.
+	Params []string `json:"params"`
+}
+
+// HasThis tells whether the signature is the one of an instance method.
+func (sig CLRMethodSignature) HasThis() bool {
+	return sig.CallingConvention&CallConvHasThis != 0
+}
+
+// Prototype renders the signature as a readable prototype of the method
+// with the given name, for instance `instance void Write(string, object[])`.
+func (sig CLRMethodSignature) Prototype(name string) string {
+	var b strings.Builder
+	if sig.HasThis() {
+		b.WriteString("instance ")
+	}
+	if sig.CallingConvention&0x0f == CallConvVarArg {
+		b.WriteString("vararg ")
+	}
+	b.WriteString(sig.ReturnType)
+	b.WriteString(" ")
+	b.WriteString(name)
+	if sig.GenericParamCount > 0 {
+		params := make([]string, sig.GenericParamCount)
+		for i := range params {
+			params[i] = "!!" + strconv.Itoa(i)
+		}
+		b.WriteString("<" + strings.Join(params, ", ") + ">")
+	}
+	b.WriteString("(" + strings.Join(sig.Params, ", ") + ")")
+	return b.String()
+}
+
+// ReadCompressedUInt decodes an unsigned integer compressed as per §II.23.2,
+// as found in signature blobs and in the length prefix of the #Blob and #US
+// heaps entries. It returns the value along with the number of bytes it
+// occupies.
+func ReadCompressedUInt(data []byte) (uint32, uint32, error) {
+	return decodeCompressedUint(data)
+}
+
+// ReadCompressedInt decodes a signed integer compressed as per §II.23.2,
+// such as the lower bounds of an array shape. The value is rotated left by
+// one bit, its sign being stored in the least significant bit.
+func ReadCompressedInt(data []byte) (int32, uint32, error) {
+	value, n, err := decodeCompressedUint(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	if value&1 == 0 {
+		return int32(value >> 1), n, nil
+	}
+
+	// Negative values are sign extended from the 6, 13 or 28 bits available.
+	var bits uint32
+	switch n {
+	case 1:
+		bits = 6
+	case 2:
+		bits = 13
+	default:
+		bits = 28
+	}
+	return int32(value>>1) - int32(1)<<bits, n, nil
+}
+
+// DecodeMethodSignature decodes a MethodDefSig, MethodRefSig or
+// StandAloneMethodSig blob, resolving the types it references from the
+// metadata tables.
+func (clr *CLRData) DecodeMethodSignature(blob []byte) (CLRMethodSignature, error) {
+	if len(blob) == 0 {
+		return CLRMethodSignature{}, ErrOutsideBoundary
+	}
+	switch blob[0] & 0x0f {
+	case CallConvField, CallConvLocalSig, CallConvProperty, CallConvGenericInst:
+		return CLRMethodSignature{}, ErrInvalidSignatureBlob
+	}
+	return clr.readMethodSig(&blobReader{data: blob}, 0)
+}
+
+// DecodeFieldSignature decodes a FieldSig blob, §II.23.2.4, and returns the
+// type of the field.
+func (clr *CLRData) DecodeFieldSignature(blob []byte) (string, error) {
+	r := &blobReader{data: blob}
+	if callingConvention := r.readUint8(); r.err != nil {
+		return "", r.err
+	} else if callingConvention&0x0f != CallConvField {
+		return "", ErrInvalidSignatureBlob
+	}
+	return clr.readSigType(r, 0)
+}
+
+// MemberPrototype returns a readable prototype of the method or field
+// referenced by a MethodDef, MemberRef or Field token, such as
+// `instance void System.IO.TextWriter::Write(string, object[])`. The name is
+// prefixed with the full name of the declaring type when it is known.
+func (clr *CLRData) MemberPrototype(token uint32) (string, error) {
+	resolved, err := clr.ResolveToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	var name, owner string
+	var signature uint32
+	switch row := resolved.Content.(type) {
+	case MethodDefTableRow:
+		name, signature = clr.getString(row.Name), row.Signature
+		if typeRid, ok := clr.methodOwners()[resolved.Row]; ok {
+			owner = clr.typeName(TypeDef<<24 | typeRid)
+		}
+	case MemberRefTableRow:
+		name, signature = clr.getString(row.Name), row.Signature
+		if parent, err := DecodeCodedIndex(CodedIndexMemberRefParent, row.Class); err == nil {
+			owner = clr.memberRefParentName(parent)
+		}
+	case FieldTableRow:
+		name, signature = clr.getString(row.Name), row.Signature
+	default:
+		return "", ErrInvalidMetadataToken
+	}
+	if owner != "" {
+		name = owner + "::" + name
+	}
+
+	blob, err := clr.getBlob("#Blob", signature)
+	if err != nil {
+		return "", err
+	}
+	if len(blob) > 0 && blob[0]&0x0f == CallConvField {
+		fieldType, err := clr.DecodeFieldSignature(blob)
+		if err != nil {
+			return "", err
+		}
+		return fieldType + " " + name, nil
+	}
+	sig, err := clr.DecodeMethodSignature(blob)
+	if err != nil {
+		return "", err
+	}
+	return sig.Prototype(name), nil
+}
+
+// memberRefParentName returns the name of the type declaring a member
+// reference, which is a TypeDef, a TypeRef or a TypeSpec for members of
+// generic instances.
+func (clr *CLRData) memberRefParentName(token uint32) string {
+	if token>>24 != TypeSpec {
+		return clr.typeName(token)
+	}
+	name, _ := clr.typeSpecName(token, 0)
+	return name
+}
+
+// typeSpecName decodes the signature of a TypeSpec token.
+func (clr *CLRData) typeSpecName(token uint32, depth int) (string, error) {
+	resolved, err := clr.ResolveToken(token)
+	if err != nil {
+		return "", err
+	}
+	row, ok := resolved.Content.(TypeSpecTableRow)
+	if !ok {
+		return "", ErrInvalidMetadataToken
+	}
+	blob, err := clr.getBlob("#Blob", row.Signature)
+	if err != nil {
+		return "", err
+	}
+	return clr.readSigType(&blobReader{data: blob}, depth+1)
+}
+
+// sigTypeDefOrRefName returns the full name of the type referenced by a
+// TypeDefOrRefOrSpecEncoded value found in a signature.
+func (clr *CLRData) sigTypeDefOrRefName(r *blobReader, depth int) (string, error) {
+	token, err := DecodeCodedIndex(CodedIndexTypeDefOrRef, r.readCompressedUint())
+	if r.err != nil {
+		return "", r.err
+	}
+	if err != nil {
+		return "", ErrInvalidSignatureBlob
+	}
+	if token>>24 == TypeSpec {
+		return clr.typeSpecName(token, depth)
+	}
+	if name := clr.typeName(token); name != "" {
+		return name, nil
+	}
+	return "", ErrInvalidMetadataToken
+}
+
+// readSigType reads a Type, §II.23.2.12, preceded by its custom modifiers,
+// and renders it with its ILAsm name.
+func (clr *CLRData) readSigType(r *blobReader, depth int) (string, error) {
+	if depth > maxSignatureDepth {
+		return "", ErrInvalidSignatureBlob
+	}
+
+	elementType := r.readUint8()
+	if r.err != nil {
+		return "", r.err
+	}
+	if name, ok := elementTypeNames[elementType]; ok {
+		return name, nil
+	}
+
+	switch elementType {
+	case elementTypeCModReqd, elementTypeCModOpt:
+		modifier, err := clr.sigTypeDefOrRefName(r, depth)
+		if err != nil {
+			return "", err
+		}
+		t, err := clr.readSigType(r, depth+1)
+		if err != nil {
+			return "", err
+		}
+		if elementType == elementTypeCModReqd {
+			return t + " modreq(" + modifier + ")", nil
+		}
+		return t + " modopt(" + modifier + ")", nil
+
+	case elementTypePinned, elementTypePtr, elementTypeByRef, elementTypeSZArray:
+		t, err := clr.readSigType(r, depth+1)
+		if err != nil {
+			return "", err
+		}
+		switch elementType {
+		case elementTypePinned:
+			return t + " pinned", nil
+		case elementTypePtr:
+			return t + "*", nil
+		case elementTypeByRef:
+			return t + "&", nil
+		}
+		return t + "[]", nil
+
+	case elementTypeValueType, elementTypeClass:
+		return clr.sigTypeDefOrRefName(r, depth)
+
+	case elementTypeVar:
+		return "!" + strconv.FormatUint(uint64(r.readCompressedUint()), 10), r.err
+
+	case elementTypeMVar:
+		return "!!" + strconv.FormatUint(uint64(r.readCompressedUint()), 10), r.err
+
+	case elementTypeArray:
+		t, err := clr.readSigType(r, depth+1)
+		if err != nil {
+			return "", err
+		}
+		return t + clr.readArrayShape(r), r.err
+
+	case elementTypeGenericInst:
+		// The generic type is prefixed with CLASS or VALUETYPE.
+		r.readUint8()
+		generic, err := clr.sigTypeDefOrRefName(r, depth)
+		if err != nil {
+			return "", err
+		}
+		count := r.readCompressedUint()
+		if r.err != nil {
+			return "", r.err
+		}
+		if uint64(count) > uint64(len(r.data)) {
+			return "", ErrInvalidSignatureBlob
+		}
+		args := make([]string, 0, count)
+		for i := uint32(0); i < count; i++ {
+			arg, err := clr.readSigType(r, depth+1)
+			if err != nil {
+				return "", err
+			}
+			args = append(args, arg)
+		}
+		return generic + "<" + strings.Join(args, ", ") + ">", nil
+
+	case elementTypeFnPtr:
+		sig, err := clr.readMethodSig(r, depth+1)
+		if err != nil {
+			return "", err
+		}
+		return "method " + sig.Prototype("*"), nil
+	}
+
+	return "", ErrInvalidSignatureBlob
+}
+
+// readMethodSig reads a method signature, either a whole blob or the one of
+// a function pointer.
+func (clr *CLRData) readMethodSig(r *blobReader, depth int) (CLRMethodSignature, error) {
+	sig := CLRMethodSignature{CallingConvention: r.readUint8()}
+	if sig.CallingConvention&CallConvGeneric != 0 {
+		sig.GenericParamCount = r.readCompressedUint()
+	}
+	count := r.readCompressedUint()
+	if r.err != nil {
+		return sig, r.err
+	}
+	if uint64(sig.GenericParamCount) > uint64(len(r.data)) ||
+		uint64(count) > uint64(len(r.data)) {
+		return sig, ErrInvalidSignatureBlob
+	}
+
+	ret, err := clr.readSigType(r, depth)
+	if err != nil {
+		return sig, err
+	}
+	sig.ReturnType = ret
+
+	sig.Params = make([]string, 0, count)
+	for i := uint32(0); i < count; i++ {
+		if r.off < len(r.data) && r.data[r.off] == elementTypeSentinel {
+			r.off++
+			sig.Params = append(sig.Params, "...")
+		}
+		param, err := clr.readSigType(r, depth)
+		if err != nil {
+			return sig, err
+		}
+		sig.Params = append(sig.Params, param)
+	}
+	return sig, nil
+}
+
+// readArrayShape reads the ArrayShape of a general array, §II.23.2.13, and
+// renders it with its rank and its bounds when they are known, for instance
+// `[0...3,]`.
+func (clr *CLRData) readArrayShape(r *blobReader) string {
+	rank := r.readCompressedUint()
+	numSizes := r.readCompressedUint()
+	if r.err != nil || uint64(rank) > uint64(len(r.data)) ||
+		uint64(numSizes) > uint64(len(r.data)) {
+		r.err = ErrInvalidSignatureBlob
+		return ""
+	}
+	sizes := make([]uint32, numSizes)
+	for i := range sizes {
+		sizes[i] = r.readCompressedUint()
+	}
+	numLoBounds := r.readCompressedUint()
+	if r.err != nil || uint64(numLoBounds) > uint64(len(r.data)) {
+		r.err = ErrInvalidSignatureBlob
+		return ""
+	}
+	loBounds := make([]int32, numLoBounds)
+	for i := range loBounds {
+		if r.off >= len(r.data) {
+			r.err = ErrOutsideBoundary
+			return ""
+		}
+		value, n, err := ReadCompressedInt(r.data[r.off:])
+		if err != nil {
+			r.err = err
+			return ""
+		}
+		loBounds[i] = value
+		r.off += int(n)
+	}
+
+	dims := make([]string, rank)
+	for i := range dims {
+		var lo int32
+		if i < len(loBounds) {
+			lo = loBounds[i]
+		}
+		switch {
+		case i < len(sizes):
+			dims[i] = strconv.Itoa(int(lo)) + "..." +
+				strconv.Itoa(int(lo)+int(sizes[i])-1)
+		case i < len(loBounds):
+			dims[i] = strconv.Itoa(int(lo)) + "..."
+		}
+	}
+	return "[" + strings.Join(dims, ",") + "]"
+}
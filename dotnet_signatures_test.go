@@ -0,0 +1,175 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReadCompressedInt(t *testing.T) {
+
+	// Examples from ECMA-335 §II.23.2.
+	tests := []struct {
+		in       []byte
+		unsigned uint32
+		signed   int32
+		size     uint32
+	}{
+		{[]byte{0x06}, 0x06, 3, 1},
+		{[]byte{0x7b}, 0x7b, -3, 1},
+		{[]byte{0x01}, 0x01, -64, 1},
+		{[]byte{0x80, 0x80}, 0x80, 64, 2},
+		{[]byte{0x80, 0x01}, 0x01, -8192, 2},
+		{[]byte{0xc0, 0x00, 0x40, 0x00}, 0x4000, 8192, 4},
+		{[]byte{0xdf, 0xff, 0xff, 0xfe}, 0x1ffffffe, 268435455, 4},
+		{[]byte{0xc0, 0x00, 0x00, 0x01}, 0x01, -268435456, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%x", tt.in), func(t *testing.T) {
+			unsigned, n, err := ReadCompressedUInt(tt.in)
+			if err != nil || unsigned != tt.unsigned || n != tt.size {
+				t.Errorf("ReadCompressedUInt() got (0x%x, %d, %v), want (0x%x, %d)",
+					unsigned, n, err, tt.unsigned, tt.size)
+			}
+			signed, n, err := ReadCompressedInt(tt.in)
+			if err != nil || signed != tt.signed || n != tt.size {
+				t.Errorf("ReadCompressedInt() got (%d, %d, %v), want (%d, %d)",
+					signed, n, err, tt.signed, tt.size)
+			}
+		})
+	}
+
+	if _, _, err := ReadCompressedInt([]byte{0xe0}); err != ErrInvalidCompressedUint {
+		t.Errorf("ReadCompressedInt() got %v, want %v", err, ErrInvalidCompressedUint)
+	}
+}
+
+func TestDecodeMethodSignature(t *testing.T) {
+
+	// A return type made of nested SZARRAY beyond the maximum depth.
+	nested := []byte{0x00, 0x00}
+	for i := 0; i <= maxSignatureDepth+1; i++ {
+		nested = append(nested, elementTypeSZArray)
+	}
+	nested = append(nested, elementTypeI4)
+
+	clr := &CLRData{}
+	tests := []struct {
+		in  []byte
+		out string
+		err error
+	}{
+		{
+			// HASTHIS GENERIC, 1 generic parameter, 2 parameters, returns
+			// !!0, takes an int32[0...3,] and a !!0*.
+			[]byte{0x30, 0x01, 0x02, 0x1e, 0x00,
+				0x14, 0x08, 0x02, 0x01, 0x04, 0x01, 0x00,
+				0x0f, 0x1e, 0x00},
+			"instance !!0 M<!!0>(int32[0...3,], !!0*)", nil,
+		},
+		{
+			// A static method taking a function pointer and a typedref.
+			[]byte{0x00, 0x02, 0x01,
+				0x1b, 0x00, 0x01, 0x08, 0x0e,
+				0x16},
+			"void M(method int32 *(string), typedref)", nil,
+		},
+		{
+			// A field signature is not a method signature.
+			[]byte{0x06, 0x08}, "", ErrInvalidSignatureBlob,
+		},
+		{
+			// Truncated parameters.
+			[]byte{0x00, 0x02, 0x01, 0x08}, "", ErrOutsideBoundary,
+		},
+		{
+			// Arrays nested beyond the maximum depth.
+			nested, "", ErrInvalidSignatureBlob,
+		},
+		{
+			// A generic parameters count larger than the blob.
+			[]byte{0x10, 0xdf, 0xff, 0xff, 0xff, 0x00, 0x01},
+			"", ErrInvalidSignatureBlob,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			sig, err := clr.DecodeMethodSignature(tt.in)
+			if err != tt.err {
+				t.Fatalf("DecodeMethodSignature() failed, got %v, want %v",
+					err, tt.err)
+			}
+			if err == nil && sig.Prototype("M") != tt.out {
+				t.Errorf("prototype assertion failed, got %q, want %q",
+					sig.Prototype("M"), tt.out)
+			}
+		})
+	}
+}
+
+func TestMemberPrototype(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		token uint32
+		out   string
+	}{
+		{
+			getAbsoluteFilePath("test/pspluginwkr.dll"),
+			0x06000140,
+			"instance void System.Management.Automation.Internal.PSETWTracer::DoDispose(bool)",
+		},
+		{
+			getAbsoluteFilePath("test/pspluginwkr.dll"),
+			0x0a0000c8,
+			"instance bool System.Collections.Generic.Dictionary`2<native int, Managed_CommandSession>::ContainsKey(!0)",
+		},
+		{
+			getAbsoluteFilePath("test/pspluginwkr.dll"),
+			0x0a000028,
+			"vararg int32 modopt(System.Runtime.CompilerServices.CallConvCdecl) " +
+				"sprintf_s(int8 modopt(System.Runtime.CompilerServices.IsSignUnspecifiedByte)*, " +
+				"uint32, int8 modopt(System.Runtime.CompilerServices.IsSignUnspecifiedByte) " +
+				"modopt(System.Runtime.CompilerServices.IsConst)*, ..., " +
+				"void modopt(System.Runtime.CompilerServices.IsConst)*)",
+		},
+		{
+			getAbsoluteFilePath("test/pspluginwkr.dll"),
+			0x04000140,
+			"object shellSyncObject",
+		},
+		{
+			getAbsoluteFilePath("test/mscorlib.dll"),
+			0x0a000001,
+			"instance void System.Runtime.CompilerServices.CompilationRelaxationsAttribute::.ctor(int32)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			defer file.Close()
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			got, err := file.CLR.MemberPrototype(tt.token)
+			if err != nil {
+				t.Fatalf("MemberPrototype(0x%x) failed, reason: %v", tt.token, err)
+			}
+			if got != tt.out {
+				t.Errorf("prototype assertion failed, got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
@@ -5,6 +5,9 @@
 package pe
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
 	"reflect"
 	"sort"
 	"strconv"
@@ -864,3 +867,459 @@ func TestClrDirectorCOMImageFlagsType(t *testing.T) {
 		})
 	}
 }
+
+func TestClrDirectoryUncompressedMetadataStream(t *testing.T) {
+	filename := getAbsoluteFilePath("test/mscorlib.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	// Turn the optimized #~ stream into an uncompressed #- one, the tables
+	// layout is the same as long as no pointer or ENC tables are present.
+	i := bytes.Index(data, []byte("#~\x00\x00"))
+	if i < 0 {
+		t.Fatalf("#~ stream header not found in %s", filename)
+	}
+	data[i+1] = '-'
+
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	if _, ok := file.CLR.MetadataStreams["#-"]; !ok {
+		t.Fatalf("#- stream not found in metadata streams")
+	}
+	mdTable, ok := file.CLR.MetadataTables[AssemblyRef]
+	if !ok {
+		t.Fatalf("AssemblyRef metadata table not found")
+	}
+	rows := mdTable.Content.([]AssemblyRefTableRow)
+	if len(rows) != int(mdTable.CountCols) {
+		t.Errorf("AssemblyRef rows count assertion failed, got %v, want %v",
+			len(rows), mdTable.CountCols)
+	}
+}
+
+func TestClrDirectoryENCTables(t *testing.T) {
+
+	tests := []struct {
+		in          []byte
+		rowCounts   map[int]uint32
+		jtd         bool
+		tableKind   int
+		out         interface{}
+		outConsumed uint32
+	}{
+		{
+			[]byte{0x01, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x00,
+				0x02, 0x00, 0x00, 0x06, 0x01, 0x00, 0x00, 0x00},
+			map[int]uint32{ENCLog: 2},
+			false,
+			ENCLog,
+			[]ENCLogTableRow{
+				{Token: 0x06000001, FuncCode: 0},
+				{Token: 0x06000002, FuncCode: 1},
+			},
+			16,
+		},
+		{
+			[]byte{0x01, 0x00, 0x00, 0x02, 0x03, 0x00, 0x00, 0x06},
+			map[int]uint32{ENCMap: 2},
+			false,
+			ENCMap,
+			[]ENCMapTableRow{
+				{Token: 0x02000001},
+				{Token: 0x06000003},
+			},
+			8,
+		},
+		{
+			[]byte{0x02, 0x00, 0x01, 0x00},
+			map[int]uint32{FieldPtr: 2, Field: 2},
+			false,
+			FieldPtr,
+			[]FieldPtrTableRow{{Field: 2}, {Field: 1}},
+			4,
+		},
+		{
+			[]byte{0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00},
+			map[int]uint32{MethodPtr: 2, MethodDef: 2},
+			true,
+			MethodPtr,
+			[]MethodPtrTableRow{{Method: 2}, {Method: 1}},
+			8,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(MetadataTableIndexToString(tt.tableKind), func(t *testing.T) {
+			file, err := NewBytes(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes() failed, reason: %v", err)
+			}
+			file.CLR.MinimalDelta = tt.jtd
+			file.CLR.MetadataTables = make(map[int]*MetadataTable)
+			for k, v := range tt.rowCounts {
+				file.CLR.MetadataTables[k] = &MetadataTable{CountCols: v}
+			}
+
+			var got interface{}
+			var n uint32
+			switch tt.tableKind {
+			case ENCLog:
+				got, n, err = file.parseMetadataENCLogTable(0)
+			case ENCMap:
+				got, n, err = file.parseMetadataENCMapTable(0)
+			case FieldPtr:
+				got, n, err = file.parseMetadataFieldPtrTable(0)
+			case MethodPtr:
+				got, n, err = file.parseMetadataMethodPtrTable(0)
+			}
+			if err != nil {
+				t.Fatalf("parsing %s table failed, reason: %v",
+					MetadataTableIndexToString(tt.tableKind), err)
+			}
+			if n != tt.outConsumed {
+				t.Errorf("%s table size assertion failed, got %v, want %v",
+					MetadataTableIndexToString(tt.tableKind), n, tt.outConsumed)
+			}
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("%s table assertion failed, got %v, want %v",
+					MetadataTableIndexToString(tt.tableKind), got, tt.out)
+			}
+		})
+	}
+}
+
+func TestClrDirectoryMalformedMetadataTable(t *testing.T) {
+	filename := getAbsoluteFilePath("test/pspluginwkr.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	want := file.CLR.MetadataTables
+
+	// Tables are laid out back to back.
+	var prev *MetadataTable
+	for i := 0; i <= GenericParamConstraint; i++ {
+		table, ok := want[i]
+		if !ok {
+			continue
+		}
+		if prev != nil && table.Offset != prev.Offset+prev.Size {
+			t.Errorf("%s table offset assertion failed, got 0x%x, want 0x%x",
+				table.Name, table.Offset, prev.Offset+prev.Size)
+		}
+		prev = table
+	}
+
+	// Inflate the row count of the NestedClass table, the last one, so that
+	// it goes beyond the end of the tables stream.
+	rowCountOff := want[Module].Offset - uint32(4*len(want)) +
+		uint32(4*(len(want)-1))
+	patched := make([]byte, len(data))
+	copy(patched, data)
+	binary.LittleEndian.PutUint32(patched[rowCountOff:], 0x1000)
+
+	file, err = NewBytes(patched, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	got := file.CLR.MetadataTables
+
+	if got[NestedClass].Error != ErrMetadataTableOutsideStream.Error() {
+		t.Errorf("NestedClass table error assertion failed, got %v, want %v",
+			got[NestedClass].Error, ErrMetadataTableOutsideStream)
+	}
+	for i, table := range got {
+		if i == NestedClass {
+			continue
+		}
+		if table.Error != "" {
+			t.Errorf("%s table error assertion failed, got %v, want none",
+				table.Name, table.Error)
+		}
+		if !reflect.DeepEqual(table.Content, want[i].Content) {
+			t.Errorf("%s table content changed after NestedClass table corruption",
+				table.Name)
+		}
+	}
+}
+
+func TestClrDirectoryMalformedMetadataStream(t *testing.T) {
+
+	// Offsets of the stream headers of mscorlib.dll, relative to the first
+	// one: #~, #Strings, #US, #GUID and #Blob.
+	tests := []struct {
+		name      string
+		hdrOffset uint32
+		field     uint32
+		value     uint32
+		err       error
+		anomaly   string
+		size      int
+	}{
+		{"#Blob", 60, 4, 0x10000, ErrMetadataStreamTruncated,
+			AnoMetadataStreamTruncated, 0x2a8},
+		{"#~", 0, 4, 0xffff0000, ErrMetadataStreamTruncated,
+			AnoMetadataStreamTruncated, 0xae34 - 0x6c},
+		{"#US", 32, 0, 0xb000, ErrMetadataStreamOutsideDirectory,
+			AnoMetadataStreamOutsideDirectory, -1},
+	}
+
+	filename := getAbsoluteFilePath("test/mscorlib.dll")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+			want := file.CLR
+
+			// Stream headers follow the metadata header and its version
+			// string.
+			offset := file.GetOffsetFromRva(want.CLRHeader.MetaData.VirtualAddress) +
+				16 + want.MetadataHeader.VersionString + 4
+			binary.LittleEndian.PutUint32(
+				data[offset+tt.hdrOffset+tt.field:], tt.value)
+
+			file, err = NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+			got := file.CLR
+
+			if !stringInSlice(tt.anomaly, file.Anomalies) {
+				t.Errorf("anomaly %s not found in %v", tt.anomaly, file.Anomalies)
+			}
+			if len(got.MetadataStreamHeaders) != len(want.MetadataStreamHeaders) {
+				t.Fatalf("metadata stream headers count assertion failed, got %d, want %d",
+					len(got.MetadataStreamHeaders), len(want.MetadataStreamHeaders))
+			}
+			for _, sh := range got.MetadataStreamHeaders {
+				wantErr := ""
+				if sh.Name == tt.name {
+					wantErr = tt.err.Error()
+				}
+				if sh.Error != wantErr {
+					t.Errorf("%s stream error assertion failed, got %q, want %q",
+						sh.Name, sh.Error, wantErr)
+				}
+
+				stream, ok := got.MetadataStreams[sh.Name]
+				switch {
+				case sh.Name != tt.name:
+					if !bytes.Equal(stream, want.MetadataStreams[sh.Name]) {
+						t.Errorf("%s stream assertion failed", sh.Name)
+					}
+				case tt.size < 0:
+					if ok {
+						t.Errorf("%s stream assertion failed, got %d bytes, want none",
+							sh.Name, len(stream))
+					}
+				case len(stream) != tt.size:
+					t.Errorf("%s stream size assertion failed, got 0x%x, want 0x%x",
+						sh.Name, len(stream), tt.size)
+				}
+			}
+
+			// The metadata tables are still parsed.
+			if len(got.MetadataTables) != len(want.MetadataTables) {
+				t.Errorf("metadata tables count assertion failed, got %d, want %d",
+					len(got.MetadataTables), len(want.MetadataTables))
+			}
+		})
+	}
+}
+
+func TestClrDirectoryHeaderRaw(t *testing.T) {
+
+	// The export address table jumps and the managed native header are
+	// pointed at the DOS stub, whose RVA is its file offset, at 56 and 64
+	// into the CLR header.
+	tests := []struct {
+		name       string
+		field      uint32
+		signature  []byte
+		readyToRun bool
+	}{
+		{"ExportAddressTableJumps", 56, nil, false},
+		{"ManagedNativeHeader", 64, nil, false},
+		{"ReadyToRun", 64, []byte("RTR\x00"), true},
+	}
+
+	filename := getAbsoluteFilePath("test/mscorlib.dll")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+			if file.CLR.ExportAddressTableJumps != nil ||
+				file.CLR.ManagedNativeHeader != nil || file.CLR.ReadyToRun {
+				t.Fatalf("CLR header raw data found in %s", filename)
+			}
+
+			var clrRVA uint32
+			for _, dir := range file.Directories() {
+				if dir.Entry == ImageDirectoryEntryCLR {
+					clrRVA = dir.VirtualAddress
+				}
+			}
+			offset := file.GetOffsetFromRva(clrRVA)
+			binary.LittleEndian.PutUint32(data[offset+tt.field:], 0x40)
+			binary.LittleEndian.PutUint32(data[offset+tt.field+4:], 0x10)
+			copy(data[0x40:], tt.signature)
+			want := data[0x40:0x50]
+
+			file, err = NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			got := file.CLR.ExportAddressTableJumps
+			if tt.field == 64 {
+				got = file.CLR.ManagedNativeHeader
+			}
+			if tt.readyToRun {
+				want = nil
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s raw data assertion failed, got %x, want %x",
+					tt.name, got, want)
+			}
+			if file.CLR.ReadyToRun != tt.readyToRun {
+				t.Errorf("ReadyToRun assertion failed, got %v, want %v",
+					file.CLR.ReadyToRun, tt.readyToRun)
+			}
+		})
+	}
+}
+
+func TestClrHeaderNotInFile(t *testing.T) {
+
+	// The .data section of pspluginwkr.dll is mapped at 0x24000 and has 0x400
+	// bytes of raw data at 0x23200, followed by zero-filled memory.
+	const dataRVA, dataRaw, dataRawSize = 0x24000, 0x23200, 0x400
+
+	filename := getAbsoluteFilePath("test/pspluginwkr.dll")
+	tests := []struct {
+		name string
+		rva  uint32
+
+		// Number of bytes of the original CLR header copied right before the
+		// end of the raw data.
+		copied   uint32
+		metadata bool
+	}{
+		{"virtual", dataRVA + 0x800, 0, false},
+		{"straddling", dataRVA + dataRawSize - 16, 16, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+			want := file.CLR.CLRHeader
+
+			// pspluginwkr.dll is a PE32, data directories follow the 96
+			// bytes of the optional header fixed fields.
+			oh32 := file.NtHeader.OptionalHeader.(ImageOptionalHeader32)
+			clrDir := oh32.DataDirectory[ImageDirectoryEntryCLR]
+			clrOffset := file.GetOffsetFromRva(clrDir.VirtualAddress)
+			copy(data[dataRaw+dataRawSize-tt.copied:dataRaw+dataRawSize],
+				data[clrOffset:clrOffset+tt.copied])
+			ntHeaderOffset := binary.LittleEndian.Uint32(data[0x3c:])
+			dirOffset := ntHeaderOffset + 4 + 20 + 96 + uint32(ImageDirectoryEntryCLR)*8
+			binary.LittleEndian.PutUint32(data[dirOffset:], tt.rva)
+
+			file, err = NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			if !file.HasCLR {
+				t.Errorf("HasCLR assertion failed, got false, want true")
+			}
+			if !stringInSlice(AnoCLRHeaderNotInFile, file.Anomalies) {
+				t.Errorf("anomaly %q not found in %v", AnoCLRHeaderNotInFile,
+					file.Anomalies)
+			}
+
+			got := file.CLR.CLRHeader
+			if tt.metadata {
+				if got.Cb != want.Cb || got.MetaData != want.MetaData {
+					t.Errorf("CLR header assertion failed, got %+v, want %+v",
+						got, want)
+				}
+				if got.Flags != 0 || got.EntryPointRVAorToken != 0 {
+					t.Errorf("CLR header zero-filled part assertion failed, got %+v", got)
+				}
+				if len(file.CLR.MetadataStreams) == 0 {
+					t.Errorf("metadata streams assertion failed, got none")
+				}
+			} else if got != (ImageCOR20Header{}) {
+				t.Errorf("CLR header assertion failed, got %+v, want zero", got)
+			}
+		})
+	}
+}
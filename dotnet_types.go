@@ -0,0 +1,379 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// UserStringToken is the table byte of the metadata tokens referencing a
+// string literal in the #US heap rather than a row of a metadata table.
+const UserStringToken = 0x70
+
+// CLRMethod represents a method defined by a .NET type.
+type CLRMethod struct {
+	// Name of the method.
+	Name string `json:"name"`
+
+	// RVA of the method body, zero for abstract, runtime or P/Invoke methods.
+	RVA uint32 `json:"rva"`
+
+	// A bitmask of type MethodAttributes, §II.23.1.10.
+	Flags uint16 `json:"flags"`
+
+	// A bitmask of type MethodImplAttributes, §II.23.1.10.
+	ImplFlags uint16 `json:"impl_flags"`
+}
+
+// CLRType represents a .NET type definition with its names resolved from the
+// #Strings heap.
+type CLRType struct {
+	// Namespace of the type, empty for nested types.
+	Namespace string `json:"namespace"`
+
+	// Name of the type.
+	Name string `json:"name"`
+
+	// A bitmask of type TypeAttributes, §II.23.1.15.
+	Flags uint32 `json:"flags"`
+
+	// Full name of the type this type extends, empty for interfaces,
+	// System.Object or when the base type is a generic instantiation.
+	BaseType string `json:"base_type"`
+
+	// Methods defined by the type.
+	Methods []CLRMethod `json:"methods"`
+}
+
+// CLRPInvokeImport represents a method implemented in a native library and
+// called via the platform invocation (P/Invoke) mechanism.
+type CLRPInvokeImport struct {
+	// Full name of the type declaring the method.
+	Type string `json:"type"`
+
+	// Name of the managed method.
+	Method string `json:"method"`
+
+	// Name of the native library, as found in the ModuleRef table.
+	Module string `json:"module"`
+
+	// Name of the native function, which defaults to the name of the
+	// managed method.
+	EntryPoint string `json:"entry_point"`
+
+	// A bitmask of type PInvokeAttributes, §II.23.1.8.
+	MappingFlags uint16 `json:"mapping_flags"`
+}
+
+// FullName returns the name of the type prefixed with its namespace.
+func (t CLRType) FullName() string {
+	return joinTypeName(t.Namespace, t.Name)
+}
+
+// Types returns the types defined in the metadata, joining the TypeDef and
+// MethodDef tables with the strings they reference.
+func (clr *CLRData) Types() []CLRType {
+	typeDefs, _ := clr.metadataTableContent(TypeDef).([]TypeDefTableRow)
+	methodDefs, _ := clr.metadataTableContent(MethodDef).([]MethodDefTableRow)
+
+	types := make([]CLRType, 0, len(typeDefs))
+	for i, typeDef := range typeDefs {
+		t := CLRType{
+			Namespace: clr.getString(typeDef.TypeNamespace),
+			Name:      clr.getString(typeDef.TypeName),
+			Flags:     typeDef.Flags,
+			BaseType:  clr.typeDefOrRefName(typeDef.Extends),
+		}
+
+		for _, rid := range clr.typeMethods(typeDefs, i) {
+			methodDef := methodDefs[rid-1]
+			t.Methods = append(t.Methods, CLRMethod{
+				Name:      clr.getString(methodDef.Name),
+				RVA:       methodDef.RVA,
+				Flags:     methodDef.Flags,
+				ImplFlags: methodDef.ImplFlags,
+			})
+		}
+
+		types = append(types, t)
+	}
+
+	return types
+}
+
+// PInvokeImports returns the methods imported from native libraries, joining
+// the ImplMap, MethodDef and ModuleRef tables with the strings they
+// reference.
+func (clr *CLRData) PInvokeImports() []CLRPInvokeImport {
+	implMaps, _ := clr.metadataTableContent(ImplMap).([]ImplMapTableRow)
+	if len(implMaps) == 0 {
+		return nil
+	}
+	methodDefs, _ := clr.metadataTableContent(MethodDef).([]MethodDefTableRow)
+	moduleRefs, _ := clr.metadataTableContent(ModuleRef).([]ModuleRefTableRow)
+	owners := clr.methodOwners()
+
+	var imports []CLRPInvokeImport
+	for _, implMap := range implMaps {
+		// Only methods can be forwarded, the MemberForwarded coded index
+		// can't reference a Field in practice.
+		rid := implMap.MemberForwarded >> idxMemberForwarded.tagbits
+		if implMap.MemberForwarded&1 != 1 || rid == 0 ||
+			rid > uint32(len(methodDefs)) {
+			continue
+		}
+
+		imp := CLRPInvokeImport{
+			Method:       clr.getString(methodDefs[rid-1].Name),
+			EntryPoint:   clr.getString(implMap.ImportName),
+			MappingFlags: implMap.MappingFlags,
+		}
+		if imp.EntryPoint == "" {
+			imp.EntryPoint = imp.Method
+		}
+		if owner, ok := owners[rid]; ok {
+			imp.Type = clr.typeName(TypeDef<<24 | owner)
+		}
+		if scope := implMap.ImportScope; scope > 0 &&
+			scope <= uint32(len(moduleRefs)) {
+			imp.Module = clr.getString(moduleRefs[scope-1].Name)
+		}
+		imports = append(imports, imp)
+	}
+
+	return imports
+}
+
+// ResolvedToken represents the metadata item referenced by a token.
+type ResolvedToken struct {
+	// Index of the metadata table, or UserStringToken for string literals.
+	Table int `json:"table"`
+
+	// Row index into the table, starting from 1, or offset into the #US heap.
+	Row uint32 `json:"row"`
+
+	// The decoded row, for instance a TypeDefTableRow for a TypeDef token, or
+	// the string literal for a user string token.
+	Content interface{} `json:"content"`
+}
+
+// ResolveToken returns the table, row index and decoded row referenced by a
+// metadata token, such as the managed entry point token of the CLR header.
+// Coded indexes found in the rows can be turned into tokens with
+// DecodeCodedIndex.
+func (clr *CLRData) ResolveToken(token uint32) (ResolvedToken, error) {
+	resolved := ResolvedToken{
+		Table: int(token >> 24),
+		Row:   token & 0x00ffffff,
+	}
+
+	if resolved.Table == UserStringToken {
+		str, err := clr.getUserString(resolved.Row)
+		if err != nil {
+			return resolved, err
+		}
+		resolved.Content = str
+		return resolved, nil
+	}
+
+	table, ok := clr.MetadataTables[resolved.Table]
+	if !ok || resolved.Row == 0 || table.Content == nil {
+		return resolved, ErrInvalidMetadataToken
+	}
+	rows := reflect.ValueOf(table.Content)
+	if rows.Kind() != reflect.Slice || int(resolved.Row) > rows.Len() {
+		return resolved, ErrInvalidMetadataToken
+	}
+	resolved.Content = rows.Index(int(resolved.Row) - 1).Interface()
+	return resolved, nil
+}
+
+// typeMethods returns the MethodDef row indexes of the methods owned by the
+// i-th type. They run from its MethodList up to the MethodList of the next
+// type, or to the end of the table for the last one. Uncompressed metadata
+// adds a level of indirection via the MethodPtr table.
+func (clr *CLRData) typeMethods(typeDefs []TypeDefTableRow, i int) []uint32 {
+	methodDefs, _ := clr.metadataTableContent(MethodDef).([]MethodDefTableRow)
+	methodPtrs, _ := clr.metadataTableContent(MethodPtr).([]MethodPtrTableRow)
+
+	count := uint32(len(methodDefs))
+	if methodPtrs != nil {
+		count = uint32(len(methodPtrs))
+	}
+	end := count + 1
+	if i+1 < len(typeDefs) {
+		end = typeDefs[i+1].MethodList
+	}
+
+	var rids []uint32
+	for rid := typeDefs[i].MethodList; rid > 0 && rid < end && rid <= count; rid++ {
+		methodRid := rid
+		if methodPtrs != nil {
+			methodRid = methodPtrs[rid-1].Method
+		}
+		if methodRid == 0 || methodRid > uint32(len(methodDefs)) {
+			continue
+		}
+		rids = append(rids, methodRid)
+	}
+	return rids
+}
+
+// typeDefOrRefName returns the full name of the type referenced by a
+// TypeDefOrRef coded index, TypeSpec are not resolved.
+func (clr *CLRData) typeDefOrRefName(codedIndex uint32) string {
+	token, err := DecodeCodedIndex(CodedIndexTypeDefOrRef, codedIndex)
+	if err != nil {
+		return ""
+	}
+	return clr.typeName(token)
+}
+
+// typeName returns the full name of the type referenced by a TypeDef or
+// TypeRef token.
+func (clr *CLRData) typeName(token uint32) string {
+	resolved, err := clr.ResolveToken(token)
+	if err != nil {
+		return ""
+	}
+
+	switch row := resolved.Content.(type) {
+	case TypeDefTableRow:
+		return joinTypeName(clr.getString(row.TypeNamespace),
+			clr.getString(row.TypeName))
+	case TypeRefTableRow:
+		return joinTypeName(clr.getString(row.TypeNamespace),
+			clr.getString(row.TypeName))
+	}
+	return ""
+}
+
+// methodOwners maps the MethodDef row indexes to the TypeDef row indexes of
+// the types declaring them.
+func (clr *CLRData) methodOwners() map[uint32]uint32 {
+	typeDefs, _ := clr.metadataTableContent(TypeDef).([]TypeDefTableRow)
+	owners := make(map[uint32]uint32)
+	for i := range typeDefs {
+		for _, rid := range clr.typeMethods(typeDefs, i) {
+			owners[rid] = uint32(i + 1)
+		}
+	}
+	return owners
+}
+
+// metadataTableContent returns the parsed rows of a metadata table, or nil
+// when the table is not present.
+func (clr *CLRData) metadataTableContent(table int) interface{} {
+	if t, ok := clr.MetadataTables[table]; ok {
+		return t.Content
+	}
+	return nil
+}
+
+// getString returns the string found at the given index into the #Strings
+// heap.
+func (clr *CLRData) getString(index uint32) string {
+	heap := clr.MetadataStreams["#Strings"]
+	if index >= uint32(len(heap)) {
+		return ""
+	}
+
+	end := index
+	for end < uint32(len(heap)) && heap[end] != 0 {
+		end++
+	}
+	return string(heap[index:end])
+}
+
+// getBlob returns the content of the blob found at the given index into a
+// heap whose entries are prefixed with their compressed length, such as
+// #Blob and #US.
+func (clr *CLRData) getBlob(heapName string, index uint32) ([]byte, error) {
+	heap := clr.MetadataStreams[heapName]
+	if index >= uint32(len(heap)) {
+		return nil, ErrOutsideBoundary
+	}
+
+	length, n, err := decodeCompressedUint(heap[index:])
+	if err != nil {
+		return nil, err
+	}
+	start := uint64(index) + uint64(n)
+	if start+uint64(length) > uint64(len(heap)) {
+		return nil, ErrOutsideBoundary
+	}
+	return heap[start : start+uint64(length)], nil
+}
+
+// getUserString returns the string literal found at the given offset into
+// the #US heap. They are encoded in UTF-16 and followed by an extra byte
+// telling whether any character needs special handling.
+func (clr *CLRData) getUserString(index uint32) (string, error) {
+	blob, err := clr.getBlob("#US", index)
+	if err != nil {
+		return "", err
+	}
+	if len(blob)%2 == 1 {
+		blob = blob[:len(blob)-1]
+	}
+	return DecodeUTF16String(blob)
+}
+
+// GUIDAt returns the GUID found at the given index into the #GUID heap. The
+// heap is an array of 16-byte GUIDs indexed from 1, index 0 meaning no GUID.
+func (clr *CLRData) GUIDAt(index uint32) (GUID, error) {
+	heap := clr.MetadataStreams["#GUID"]
+	if index == 0 || uint64(index)*16 > uint64(len(heap)) {
+		return GUID{}, ErrOutsideBoundary
+	}
+
+	b := heap[(index-1)*16 : index*16]
+	g := GUID{
+		Data1: binary.LittleEndian.Uint32(b),
+		Data2: binary.LittleEndian.Uint16(b[4:]),
+		Data3: binary.LittleEndian.Uint16(b[6:]),
+	}
+	copy(g.Data4[:], b[8:])
+	return g, nil
+}
+
+// GUIDs returns every GUID of the #GUID heap, the GUID at index i of the heap
+// being the element i-1 of the slice.
+func (clr *CLRData) GUIDs() []GUID {
+	count := uint32(len(clr.MetadataStreams["#GUID"]) / 16)
+	guids := make([]GUID, 0, count)
+	for i := uint32(1); i <= count; i++ {
+		g, _ := clr.GUIDAt(i)
+		guids = append(guids, g)
+	}
+	return guids
+}
+
+// MVID returns the module version identifier, the GUID generated by the
+// compiler for every build of the module, in the usual UUID format such as
+// 6f2e3b0a-4c1d-4f8e-9a7b-1c2d3e4f5a6b. It is empty when the Module table or
+// the GUID it references is missing.
+func (clr *CLRData) MVID() string {
+	modules, _ := clr.metadataTableContent(Module).([]ModuleTableRow)
+	if len(modules) == 0 {
+		return ""
+	}
+
+	g, err := clr.GUIDAt(modules[0].Mvid)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		g.Data1, g.Data2, g.Data3, g.Data4[:2], g.Data4[2:])
+}
+
+func joinTypeName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
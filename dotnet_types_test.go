@@ -0,0 +1,328 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestClrTypes(t *testing.T) {
+
+	type TestType struct {
+		index       int
+		fullName    string
+		baseType    string
+		methodCount int
+		firstMethod CLRMethod
+	}
+
+	tests := []struct {
+		in         string
+		typesCount int
+		out        []TestType
+	}{
+		{
+			getAbsoluteFilePath("test/pspluginwkr.dll"),
+			169,
+			[]TestType{
+				{
+					index:       0,
+					fullName:    "<Module>",
+					baseType:    "",
+					methodCount: 286,
+					firstMethod: CLRMethod{
+						Name:  "<CrtImplementationDetails>.NativeDll.IsSafeForManagedCode",
+						RVA:   0x1d414,
+						Flags: 0x13,
+					},
+				},
+				{
+					index:       22,
+					fullName:    "std.basic_string<char,std::char_traits<char>,std::allocator<char>,_STL70>",
+					baseType:    "System.ValueType",
+					methodCount: 2,
+					firstMethod: CLRMethod{
+						Name:  "<MarshalCopy>",
+						RVA:   0x1ff18,
+						Flags: 0x816,
+					},
+				},
+			},
+		},
+		{
+			getAbsoluteFilePath("test/mscorlib.dll"),
+			1,
+			[]TestType{
+				{
+					index:    0,
+					fullName: "<Module>",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			types := file.CLR.Types()
+			if len(types) != tt.typesCount {
+				t.Fatalf("types count assertion failed, got %v, want %v",
+					len(types), tt.typesCount)
+			}
+
+			for _, want := range tt.out {
+				got := types[want.index]
+				if got.FullName() != want.fullName {
+					t.Errorf("type name assertion failed, got %v, want %v",
+						got.FullName(), want.fullName)
+				}
+				if got.BaseType != want.baseType {
+					t.Errorf("base type assertion failed, got %v, want %v",
+						got.BaseType, want.baseType)
+				}
+				if len(got.Methods) != want.methodCount {
+					t.Fatalf("methods count assertion failed, got %v, want %v",
+						len(got.Methods), want.methodCount)
+				}
+				if want.methodCount > 0 &&
+					!reflect.DeepEqual(got.Methods[0], want.firstMethod) {
+					t.Errorf("method assertion failed, got %v, want %v",
+						got.Methods[0], want.firstMethod)
+				}
+			}
+		})
+	}
+}
+
+func TestClrPInvokeImports(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		count int
+		out   []CLRPInvokeImport
+	}{
+		{
+			// C++/CLI assemblies reference the native functions they link to
+			// via an unnamed module.
+			getAbsoluteFilePath("test/pspluginwkr.dll"),
+			51,
+			[]CLRPInvokeImport{
+				{
+					Type:         "<Module>",
+					Method:       "_amsg_exit",
+					EntryPoint:   "_amsg_exit",
+					MappingFlags: 0x240,
+				},
+				{
+					Type:         "<Module>",
+					Method:       "Sleep",
+					EntryPoint:   "Sleep",
+					MappingFlags: 0x340,
+				},
+			},
+		},
+		{
+			getAbsoluteFilePath("test/mscorlib.dll"),
+			0,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			got := file.CLR.PInvokeImports()
+			if len(got) != tt.count {
+				t.Fatalf("P/Invoke imports count assertion failed, got %v, want %v",
+					len(got), tt.count)
+			}
+			for i, want := range tt.out {
+				if !reflect.DeepEqual(got[i], want) {
+					t.Errorf("P/Invoke import assertion failed, got %v, want %v",
+						got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestClrResolveToken(t *testing.T) {
+
+	tests := []struct {
+		in  uint32
+		out ResolvedToken
+		err error
+	}{
+		{
+			0x06000001,
+			ResolvedToken{
+				Table: MethodDef,
+				Row:   1,
+				Content: MethodDefTableRow{
+					RVA:       0x1d414,
+					Flags:     0x13,
+					Name:      0x1b7f,
+					Signature: 0x125,
+					ParamList: 0x1,
+				},
+			},
+			nil,
+		},
+		{
+			0x70000001,
+			ResolvedToken{
+				Table:   UserStringToken,
+				Row:     1,
+				Content: "The C++ module failed to load during vtable initialization.\n",
+			},
+			nil,
+		},
+		{
+			0x06ffffff,
+			ResolvedToken{Table: MethodDef, Row: 0xffffff},
+			ErrInvalidMetadataToken,
+		},
+		{
+			0x2b000001,
+			ResolvedToken{Table: MethodSpec, Row: 1},
+			ErrInvalidMetadataToken,
+		},
+	}
+
+	filename := getAbsoluteFilePath("test/pspluginwkr.dll")
+	file, err := New(filename, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("0x%x", tt.in), func(t *testing.T) {
+			got, err := file.CLR.ResolveToken(tt.in)
+			if err != tt.err {
+				t.Fatalf("ResolveToken(0x%x) failed, got %v, want %v",
+					tt.in, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("ResolveToken(0x%x) assertion failed, got %v, want %v",
+					tt.in, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestDecodeCodedIndex(t *testing.T) {
+
+	tests := []struct {
+		kind  int
+		value uint32
+		out   uint32
+		err   error
+	}{
+		{CodedIndexHasCustomAttribute, 0x2e, 0x20000001, nil},
+		{CodedIndexHasCustomAttribute, 0x27, 0x00000001, nil},
+		{CodedIndexCustomAttributeType, 0x7db, 0x0a0000fb, nil},
+		{CodedIndexCustomAttributeType, 0x7d9, 0, ErrInvalidCodedIndex},
+		{CodedIndexTypeDefOrRef, 0x41, 0x01000010, nil},
+		{CodedIndexTypeDefOrRef, 0x43, 0, ErrInvalidCodedIndex},
+		{CodedIndexImplementation, 0x5, 0x23000001, nil},
+		{0xff, 0x1, 0, ErrInvalidCodedIndex},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d_0x%x", tt.kind, tt.value), func(t *testing.T) {
+			got, err := DecodeCodedIndex(tt.kind, tt.value)
+			if err != tt.err {
+				t.Fatalf("DecodeCodedIndex(%d, 0x%x) failed, got %v, want %v",
+					tt.kind, tt.value, err, tt.err)
+			}
+			if got != tt.out {
+				t.Errorf("DecodeCodedIndex(%d, 0x%x) assertion failed, got 0x%x, want 0x%x",
+					tt.kind, tt.value, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestClrMVID(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		mvid  string
+		guids int
+	}{
+		{getAbsoluteFilePath("test/pspluginwkr.dll"), "12e5442f-9971-4b17-87d4-10f857b1b1cb", 1},
+		{getAbsoluteFilePath("test/mscorlib.dll"), "5130d84c-48e8-4580-9a3b-20e8aff8bedf", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			if got := file.CLR.MVID(); got != tt.mvid {
+				t.Errorf("MVID assertion failed, got %v, want %v", got, tt.mvid)
+			}
+			if got := len(file.CLR.GUIDs()); got != tt.guids {
+				t.Errorf("GUIDs count assertion failed, got %v, want %v", got, tt.guids)
+			}
+		})
+	}
+}
+
+func TestClrGUIDAt(t *testing.T) {
+
+	clr := CLRData{MetadataStreams: map[string][]byte{"#GUID": {
+		0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66,
+		0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+	}}}
+
+	want := GUID{Data1: 0x00112233, Data2: 0x4455, Data3: 0x6677,
+		Data4: [8]byte{0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}}
+	got, err := clr.GUIDAt(1)
+	if err != nil || got != want {
+		t.Errorf("GUIDAt(1) assertion failed, got %v (%v), want %v", got, err, want)
+	}
+
+	for _, index := range []uint32{0, 2} {
+		if _, err := clr.GUIDAt(index); err != ErrOutsideBoundary {
+			t.Errorf("GUIDAt(%d) error assertion failed, got %v, want %v",
+				index, err, ErrOutsideBoundary)
+		}
+	}
+
+	if got := clr.MVID(); got != "" {
+		t.Errorf("MVID without Module table should be empty, got %v", got)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+// CodeBytes holds the first bytes of code found at an address of the image,
+// such as the entry point or a TLS callback.
+type CodeBytes struct {
+	// The virtual address of the code, the base address to disassemble at.
+	VA uint64 `json:"va"`
+
+	// The address of the code relative to the image base.
+	RVA uint32 `json:"rva"`
+
+	// The file offset of the code, only meaningful when Raw is not empty.
+	Offset uint32 `json:"offset"`
+
+	// The name of the section containing the code, empty when the code lies
+	// in the headers.
+	Section string `json:"section"`
+
+	// The bytes as stored in the file. They can be fewer than requested when
+	// the raw data of the section, or the file, ends before.
+	Raw []byte `json:"raw"`
+
+	// The bytes as mapped by the loader, ready to be disassembled: the raw
+	// bytes followed by zeros up to the virtual size of the section.
+	Mapped []byte `json:"mapped"`
+}
+
+// EntryPointLocation tells where the AddressOfEntryPoint of an image points.
+type EntryPointLocation int
+
+const (
+	// EntryPointNull is an AddressOfEntryPoint of 0, DllMain is then not
+	// called for DLLs.
+	EntryPointNull EntryPointLocation = iota
+
+	// EntryPointInCode is an entry point within an executable section.
+	EntryPointInCode
+
+	// EntryPointInDataSection is an entry point within a section which is
+	// not executable.
+	EntryPointInDataSection
+
+	// EntryPointInHeaders is an entry point within the headers.
+	EntryPointInHeaders
+
+	// EntryPointOutsideSections is an entry point within the image, past
+	// the headers but not within a section.
+	EntryPointOutsideSections
+
+	// EntryPointBeyondImage is an entry point at or past SizeOfImage.
+	EntryPointBeyondImage
+)
+
+// String stringifies the entry point location.
+func (l EntryPointLocation) String() string {
+	entryPointLocationMap := map[EntryPointLocation]string{
+		EntryPointNull:            "Null",
+		EntryPointInCode:          "Code Section",
+		EntryPointInDataSection:   "Non Executable Section",
+		EntryPointInHeaders:       "Headers",
+		EntryPointOutsideSections: "Outside Sections",
+		EntryPointBeyondImage:     "Beyond Image",
+	}
+
+	if v, ok := entryPointLocationMap[l]; ok {
+		return v
+	}
+	return "?"
+}
+
+// EntryPointLocation tells where the AddressOfEntryPoint points, the file
+// must be parsed before calling EntryPointLocation.
+func (pe *File) EntryPointLocation() EntryPointLocation {
+	var entryPoint uint32
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			entryPoint = oh64.AddressOfEntryPoint
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			entryPoint = oh32.AddressOfEntryPoint
+		}
+	}
+	sizeOfImage, sizeOfHeaders := pe.sizeOfImage(), pe.sizeOfHeaders()
+
+	switch {
+	case entryPoint == 0:
+		return EntryPointNull
+	case entryPoint >= sizeOfImage:
+		return EntryPointBeyondImage
+	}
+
+	section := pe.getSectionByRva(entryPoint)
+	switch {
+	case section != nil && section.Permissions().Execute:
+		return EntryPointInCode
+	case section != nil:
+		return EntryPointInDataSection
+	case entryPoint < sizeOfHeaders:
+		return EntryPointInHeaders
+	}
+	return EntryPointOutsideSections
+}
+
+// EntryPointInCode tells whether the AddressOfEntryPoint lies within an
+// executable section, as expected from files produced by a linker. Packers
+// and crafted files often start in a writable data section, in the headers
+// or past the image.
+func (pe *File) EntryPointInCode() bool {
+	return pe.EntryPointLocation() == EntryPointInCode
+}
+
+// EntryPointBytes returns the first n bytes of code at the AddressOfEntryPoint.
+// The file must be parsed before calling EntryPointBytes.
+func (pe *File) EntryPointBytes(n uint32) (CodeBytes, error) {
+	var entryPoint uint32
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			entryPoint = oh64.AddressOfEntryPoint
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			entryPoint = oh32.AddressOfEntryPoint
+		}
+	}
+
+	if entryPoint == 0 {
+		return CodeBytes{}, ErrNoEntryPoint
+	}
+	return pe.codeBytes(entryPoint, n)
+}
+
+// TLSCallbackBytes returns the first n bytes of code of each TLS callback, in
+// the order of TLS.Callbacks. Callbacks outside the image have no bytes. The
+// file must be parsed before calling TLSCallbackBytes.
+func (pe *File) TLSCallbackBytes(n uint32) []CodeBytes {
+	var callbacks []uint64
+	switch c := pe.TLS.Callbacks.(type) {
+	case []uint64:
+		callbacks = c
+	case []uint32:
+		for _, va := range c {
+			callbacks = append(callbacks, uint64(va))
+		}
+	}
+
+	var code []CodeBytes
+	for _, va := range callbacks {
+		cb := CodeBytes{VA: va}
+		if rva, err := pe.VAToRVA(va); err == nil {
+			if b, err := pe.codeBytes(rva, n); err == nil {
+				cb = b
+			}
+		}
+		code = append(code, cb)
+	}
+	return code
+}
+
+// codeBytes reads the first n bytes at the given address the way the loader
+// maps them: within a section, the raw data is read at its aligned pointer
+// and zero filled up to the virtual size of the section.
+func (pe *File) codeBytes(rva, n uint32) (CodeBytes, error) {
+	sizeOfImage := pe.sizeOfImage()
+	code := CodeBytes{RVA: rva}
+	code.VA, _ = pe.RVAToVA(rva)
+
+	// The start and end file offsets of the raw data, and the end of the
+	// mapped data relative to the address.
+	var rawStart, rawEnd, mappedSize uint64
+	section := pe.getSectionByRva(rva)
+	if section == nil {
+		// The code lies in the headers, which are mapped as is.
+		if err := pe.checkRange(rva, 0); err != nil {
+			return code, err
+		}
+		rawStart = uint64(rva)
+		rawEnd = pe.size
+		if sizeOfImage > rva {
+			mappedSize = uint64(sizeOfImage - rva)
+		}
+	} else {
+		code.Section = section.String()
+		virtualAddress := pe.adjustSectionAlignment(section.Header.VirtualAddress)
+		pointerToRawData := pe.adjustFileAlignment(section.Header.PointerToRawData)
+		delta := uint64(rva - virtualAddress)
+		rawStart = uint64(pointerToRawData) + delta
+		rawEnd = uint64(pointerToRawData) + uint64(section.Header.SizeOfRawData)
+		virtualSize := section.Header.VirtualSize
+		if virtualSize == 0 {
+			virtualSize = section.Header.SizeOfRawData
+		}
+		if uint64(virtualSize) > delta {
+			mappedSize = uint64(virtualSize) - delta
+		}
+	}
+
+	code.Offset = uint32(rawStart)
+	if rawEnd > pe.size {
+		rawEnd = pe.size
+	}
+	if rawEnd > rawStart+uint64(n) {
+		rawEnd = rawStart + uint64(n)
+	}
+	if rawStart < rawEnd {
+		code.Raw = pe.data[rawStart:rawEnd]
+	}
+
+	if mappedSize < uint64(len(code.Raw)) {
+		mappedSize = uint64(len(code.Raw))
+	}
+	if mappedSize > uint64(n) {
+		mappedSize = uint64(n)
+	}
+	code.Mapped = make([]byte, mappedSize)
+	copy(code.Mapped, code.Raw)
+	return code, nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEntryPointBytes(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(filename, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	// sub rsp, 0x28; call ...
+	want := CodeBytes{
+		VA:      0x14007e384,
+		RVA:     0x7e384,
+		Offset:  0x7d784,
+		Section: ".text",
+		Raw:     []byte{0x48, 0x83, 0xec, 0x28, 0xe8, 0xb3, 0x02, 0x00},
+		Mapped:  []byte{0x48, 0x83, 0xec, 0x28, 0xe8, 0xb3, 0x02, 0x00},
+	}
+	got, err := file.EntryPointBytes(8)
+	if err != nil {
+		t.Fatalf("EntryPointBytes(%s) failed, reason: %v", filename, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entry point bytes assertion failed, got %+v, want %+v",
+			got, want)
+	}
+
+	// Past the raw data of a section, the mapped bytes are zero filled.
+	var section *Section
+	for i := range file.Sections {
+		if file.Sections[i].Header.VirtualSize > file.Sections[i].Header.SizeOfRawData+8 {
+			section = &file.Sections[i]
+			break
+		}
+	}
+	if section == nil {
+		t.Fatalf("no section of %s has uninitialized data", filename)
+	}
+	oh64 := file.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+	oh64.AddressOfEntryPoint = section.Header.VirtualAddress +
+		section.Header.SizeOfRawData - 2
+	file.NtHeader.OptionalHeader = oh64
+	got, err = file.EntryPointBytes(8)
+	if err != nil {
+		t.Fatalf("EntryPointBytes(%s) failed, reason: %v", filename, err)
+	}
+	if len(got.Raw) != 2 || len(got.Mapped) != 8 ||
+		!bytes.Equal(got.Mapped[:2], got.Raw) ||
+		!bytes.Equal(got.Mapped[2:], make([]byte, 6)) {
+		t.Errorf("uninitialized entry point bytes assertion failed, got %+v", got)
+	}
+
+	oh64.AddressOfEntryPoint = 0
+	file.NtHeader.OptionalHeader = oh64
+	_, err = file.EntryPointBytes(8)
+	if err != ErrNoEntryPoint {
+		t.Errorf("EntryPointBytes(%s) error assertion failed, got %v, want %v",
+			filename, err, ErrNoEntryPoint)
+	}
+}
+
+func TestTLSCallbackBytes(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/liblzo2-2.dll")
+	file, err := New(filename, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	want := []CodeBytes{
+		{
+			VA:      0x6cbae7e0,
+			RVA:     0x2e7e0,
+			Offset:  0x2dbe0,
+			Section: ".text",
+			Raw:     []byte{0x56, 0x53, 0x48, 0x83},
+			Mapped:  []byte{0x56, 0x53, 0x48, 0x83},
+		},
+		{
+			VA:      0x6cbae7b0,
+			RVA:     0x2e7b0,
+			Offset:  0x2dbb0,
+			Section: ".text",
+			Raw:     []byte{0x48, 0x83, 0xec, 0x28},
+			Mapped:  []byte{0x48, 0x83, 0xec, 0x28},
+		},
+	}
+	got := file.TLSCallbackBytes(4)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TLS callback bytes assertion failed, got %+v, want %+v",
+			got, want)
+	}
+}
+
+func TestEntryPointLocation(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(filename, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	var rdata uint32
+	for _, section := range file.Sections {
+		if section.String() == ".rdata" {
+			rdata = section.Header.VirtualAddress
+		}
+	}
+	oh64 := file.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+
+	tests := []struct {
+		entryPoint uint32
+		out        EntryPointLocation
+		anomaly    string
+	}{
+		{oh64.AddressOfEntryPoint, EntryPointInCode, ""},
+		{0, EntryPointNull, AnoAddressOfEntryPointNull},
+		{rdata + 0x10, EntryPointInDataSection, AnoAddressOfEPNotInCode},
+		{0x100, EntryPointInHeaders, AnoAddressOfEPLessSizeOfHeaders},
+		{oh64.SizeOfHeaders + 0x10, EntryPointOutsideSections,
+			AnoAddressOfEPOutsideSections},
+		{oh64.SizeOfImage, EntryPointBeyondImage, AnoAddressOfEPBeyondImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out.String(), func(t *testing.T) {
+			oh := oh64
+			oh.AddressOfEntryPoint = tt.entryPoint
+			file.NtHeader.OptionalHeader = oh
+			file.Anomalies = nil
+			file.AnomalyIDs = nil
+
+			got := file.EntryPointLocation()
+			if got != tt.out {
+				t.Errorf("entry point location assertion failed, got %v, want %v",
+					got, tt.out)
+			}
+			if file.EntryPointInCode() != (tt.out == EntryPointInCode) {
+				t.Errorf("entry point in code assertion failed, got %v",
+					file.EntryPointInCode())
+			}
+
+			err := file.GetAnomalies()
+			if err != nil {
+				t.Fatalf("GetAnomalies(%s) failed, reason: %v", filename, err)
+			}
+			for _, anomaly := range []string{AnoAddressOfEPNotInCode,
+				AnoAddressOfEPOutsideSections, AnoAddressOfEPBeyondImage} {
+				if got := stringInSlice(anomaly, file.Anomalies); got !=
+					(anomaly == tt.anomaly) {
+					t.Errorf("anomaly %s assertion failed, got %v", anomaly, got)
+				}
+			}
+			if tt.anomaly != "" && !stringInSlice(tt.anomaly, file.Anomalies) {
+				t.Errorf("anomaly %s not found in %v", tt.anomaly, file.Anomalies)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ package pe
 
 import (
 	"encoding/binary"
+	"sort"
 	"strconv"
 )
 
@@ -247,9 +248,41 @@ type UnwindCode struct {
 	// Operation info.
 	OpInfo uint8 `json:"op_info"`
 
-	// Allocation size.
-	Operand     string `json:"operand"`
+	// Human readable representation of the operands.
+	Operand string `json:"operand"`
+
+	// Stack offset truncated to 16 bits, kept for backward compatibility.
+	// Use StackOffset instead.
 	FrameOffset uint16 `json:"frame_offset"`
+
+	// Register operated on. This is an integer register (see
+	// OpInfoRegisters) unless IsXmmRegister is set. For UWOP_SET_FPREG and
+	// UWOP_SET_FPREG_LARGE it is the frame register from the unwind info.
+	Register uint8 `json:"register"`
+
+	// Set when Register designates an XMM register.
+	IsXmmRegister bool `json:"is_xmm_register"`
+
+	// Unscaled offset in bytes of the saved register from RSP, or for the
+	// set frame pointer operations, the offset of the frame pointer from RSP.
+	StackOffset uint32 `json:"stack_offset"`
+
+	// Unscaled size in bytes of the stack allocation for UWOP_ALLOC_SMALL
+	// and UWOP_ALLOC_LARGE.
+	AllocationSize uint32 `json:"allocation_size"`
+
+	// Set for UWOP_PUSH_MACHFRAME when an error code was pushed as well.
+	HasErrorCode bool `json:"has_error_code"`
+
+	// For version 2 UWOP_EPILOG codes: the first one describes the size of
+	// the epilogs and whether one sits at the very end of the function, the
+	// following ones give the distance of each epilog from the function end.
+	EpilogSize   uint8  `json:"epilog_size"`
+	EpilogAtEnd  bool   `json:"epilog_at_end"`
+	EpilogOffset uint16 `json:"epilog_offset"`
+
+	// Number of slots of the unwind codes array this code occupies.
+	Slots uint8 `json:"slots"`
 }
 
 // UnwindInfo represents the _UNWIND_INFO structure. It is used to record the
@@ -298,6 +331,10 @@ type UnwindInfo struct {
 	// with three UWORDs. These UWORDs represent the RUNTIME_FUNCTION
 	// information for the function of the chained unwind.
 	FunctionEntry ImageRuntimeFunctionEntry `json:"function_entry"`
+
+	// The C_SCOPE_TABLE found in the language specific handler data, when
+	// the handler data looks like one (typically __C_specific_handler).
+	ScopeTable *ScopeTable `json:"scope_table,omitempty"`
 }
 
 //
@@ -314,6 +351,8 @@ type UnwindInfo struct {
 //  ULONG ExceptionData[];
 //
 
+// ScopeRecord represents one __try block of a function, as found in the
+// C_SCOPE_TABLE used by __C_specific_handler.
 type ScopeRecord struct {
 	// This value indicates the offset of the first instruction within a __try
 	// block located in the function.
@@ -362,7 +401,7 @@ type Exception struct {
 	UnwindInfo      UnwindInfo                `json:"unwind_info"`
 }
 
-func (pe *File) parseUnwindCode(offset uint32, version uint8) (UnwindCode, int) {
+func (pe *File) parseUnwindCode(offset uint32, ui *UnwindInfo) (UnwindCode, int) {
 
 	unwindCode := UnwindCode{}
 	advanceBy := 0
@@ -377,58 +416,90 @@ func (pe *File) parseUnwindCode(offset uint32, version uint8) (UnwindCode, int)
 	unwindCode.UnwindOp = UnwindOpType(uc & 0xf00 >> 8)
 	unwindCode.OpInfo = uint8(uc & 0xf000 >> 12)
 
+	// Operands that do not fit in the operation info field spill over into
+	// the following one or two slots.
+	nextSlot := func() uint32 {
+		v, _ := pe.ReadUint16(offset + 2)
+		return uint32(v)
+	}
+	nextTwoSlots := func() uint32 {
+		v, _ := pe.ReadUint32(offset + 2)
+		return v
+	}
+
 	switch unwindCode.UnwindOp {
 	case UwOpAllocSmall:
-		size := int(unwindCode.OpInfo*8 + 8)
-		unwindCode.Operand = "Size=" + strconv.Itoa(size)
+		unwindCode.AllocationSize = uint32(unwindCode.OpInfo)*8 + 8
+		unwindCode.Operand = "Size=" + strconv.Itoa(int(unwindCode.AllocationSize))
 		advanceBy++
 	case UwOpAllocLarge:
 		if unwindCode.OpInfo == 0 {
-			size := int(binary.LittleEndian.Uint16(pe.data[offset+2:]) * 8)
-			unwindCode.Operand = "Size=" + strconv.Itoa(size)
+			unwindCode.AllocationSize = nextSlot() * 8
 			advanceBy += 2
 		} else {
-			size := int(binary.LittleEndian.Uint32(pe.data[offset+2:]) << 16)
-			unwindCode.Operand = "Size=" + strconv.Itoa(size)
+			unwindCode.AllocationSize = nextTwoSlots()
 			advanceBy += 3
 		}
+		unwindCode.Operand = "Size=" + strconv.Itoa(int(unwindCode.AllocationSize))
 	case UwOpSetFpReg:
-		unwindCode.Operand = "Register=" + OpInfoRegisters[unwindCode.OpInfo]
+		// The operation info is reserved, the frame register and its offset
+		// come from the unwind info header.
+		unwindCode.Register = ui.FrameRegister
+		unwindCode.StackOffset = uint32(ui.FrameOffset) * 16
+		unwindCode.Operand = "Register=" + OpInfoRegisters[unwindCode.Register] +
+			", Offset=" + strconv.Itoa(int(unwindCode.StackOffset))
 		advanceBy++
 	case UwOpPushNonVol:
-		unwindCode.Operand = "Register=" + OpInfoRegisters[unwindCode.OpInfo]
+		unwindCode.Register = unwindCode.OpInfo
+		unwindCode.Operand = "Register=" + OpInfoRegisters[unwindCode.Register]
 		advanceBy++
 	case UwOpSaveNonVol:
-		fo := binary.LittleEndian.Uint16(pe.data[offset+2:])
-		unwindCode.FrameOffset = fo * 8
-		unwindCode.Operand = "Register=" + OpInfoRegisters[unwindCode.OpInfo] +
-			", Offset=" + strconv.Itoa(int(unwindCode.FrameOffset))
+		unwindCode.Register = unwindCode.OpInfo
+		unwindCode.StackOffset = nextSlot() * 8
+		unwindCode.FrameOffset = uint16(unwindCode.StackOffset)
+		unwindCode.Operand = "Register=" + OpInfoRegisters[unwindCode.Register] +
+			", Offset=" + strconv.Itoa(int(unwindCode.StackOffset))
 		advanceBy += 2
 	case UwOpSaveNonVolFar:
-		fo := binary.LittleEndian.Uint32(pe.data[offset+2:])
-		unwindCode.FrameOffset = uint16(fo * 8)
-		unwindCode.Operand = "Register=" + OpInfoRegisters[unwindCode.OpInfo] +
-			", Offset=" + strconv.Itoa(int(unwindCode.FrameOffset))
+		unwindCode.Register = unwindCode.OpInfo
+		unwindCode.StackOffset = nextTwoSlots()
+		unwindCode.FrameOffset = uint16(unwindCode.StackOffset)
+		unwindCode.Operand = "Register=" + OpInfoRegisters[unwindCode.Register] +
+			", Offset=" + strconv.Itoa(int(unwindCode.StackOffset))
 		advanceBy += 3
 	case UwOpSaveXmm128:
-		fo := binary.LittleEndian.Uint16(pe.data[offset+2:])
-		unwindCode.FrameOffset = fo * 16
-		unwindCode.Operand = "Register=XMM" + strconv.Itoa(int(unwindCode.OpInfo)) +
-			", Offset=" + strconv.Itoa(int(unwindCode.FrameOffset))
+		unwindCode.Register = unwindCode.OpInfo
+		unwindCode.IsXmmRegister = true
+		unwindCode.StackOffset = nextSlot() * 16
+		unwindCode.FrameOffset = uint16(unwindCode.StackOffset)
+		unwindCode.Operand = "Register=XMM" + strconv.Itoa(int(unwindCode.Register)) +
+			", Offset=" + strconv.Itoa(int(unwindCode.StackOffset))
 		advanceBy += 2
 	case UwOpSaveXmm128Far:
-		fo := binary.LittleEndian.Uint32(pe.data[offset+2:])
-		unwindCode.FrameOffset = uint16(fo)
-		unwindCode.Operand = "Register=XMM" + strconv.Itoa(int(unwindCode.OpInfo)) +
-			", Offset=" + strconv.Itoa(int(unwindCode.FrameOffset))
+		unwindCode.Register = unwindCode.OpInfo
+		unwindCode.IsXmmRegister = true
+		unwindCode.StackOffset = nextTwoSlots()
+		unwindCode.FrameOffset = uint16(unwindCode.StackOffset)
+		unwindCode.Operand = "Register=XMM" + strconv.Itoa(int(unwindCode.Register)) +
+			", Offset=" + strconv.Itoa(int(unwindCode.StackOffset))
 		advanceBy += 3
 	case UwOpSetFpRegLarge:
-		unwindCode.Operand = "Register=" + OpInfoRegisters[unwindCode.OpInfo]
-		advanceBy += 2
+		unwindCode.Register = ui.FrameRegister
+		unwindCode.StackOffset = nextTwoSlots() * 16
+		unwindCode.Operand = "Register=" + OpInfoRegisters[unwindCode.Register] +
+			", Offset=" + strconv.Itoa(int(unwindCode.StackOffset))
+		advanceBy += 3
 	case UwOpPushMachFrame:
+		// An operation info of 1 means the processor pushed an error code
+		// along with the machine frame.
+		unwindCode.HasErrorCode = unwindCode.OpInfo == 1
 		advanceBy++
 	case UwOpEpilog:
-		if version == 2 {
+		if ui.Version == 2 {
+			unwindCode.EpilogSize = unwindCode.CodeOffset
+			unwindCode.EpilogAtEnd = unwindCode.OpInfo&1 == 1
+			unwindCode.EpilogOffset = uint16(unwindCode.OpInfo)<<8 |
+				uint16(unwindCode.CodeOffset)
 			unwindCode.Operand = "Flags=" + strconv.Itoa(int(unwindCode.OpInfo)) + ", Size=" + strconv.Itoa(int(unwindCode.CodeOffset))
 		}
 		advanceBy += 2
@@ -436,9 +507,11 @@ func (pe *File) parseUnwindCode(offset uint32, version uint8) (UnwindCode, int)
 		advanceBy += 3
 	default:
 		advanceBy++ // so we can get out of the loop
-		pe.logger.Warnf("Wrong unwind opcode %d", unwindCode.UnwindOp)
+		pe.warnf(ImageDirectoryEntryException.String(), offset,
+			"Wrong unwind opcode %d", unwindCode.UnwindOp)
 	}
 
+	unwindCode.Slots = uint8(advanceBy)
 	return unwindCode, advanceBy
 }
 
@@ -465,17 +538,17 @@ func (pe *File) parseUnwindInfo(unwindInfo uint32) UnwindInfo {
 	ui.CountOfCodes = uint8(v & 0xff0000 >> 16)
 
 	// The next 4 bits
-	ui.FrameRegister = uint8(v & 0xf00000 >> 24)
+	ui.FrameRegister = uint8(v & 0xf000000 >> 24)
 
 	// The next 4 bits.
-	ui.FrameOffset = uint8(v&0xf0000000>>28) * 6
+	ui.FrameOffset = uint8(v & 0xf0000000 >> 28)
 
 	// Each unwind code struct is 2 bytes wide.
 	offset += 4
 	i := 0
 	for i < int(ui.CountOfCodes) {
 		ucOffset := offset + 2*uint32(i)
-		unwindCode, advanceBy := pe.parseUnwindCode(ucOffset, ui.Version)
+		unwindCode, advanceBy := pe.parseUnwindCode(ucOffset, &ui)
 		if advanceBy == 0 {
 			return ui
 		}
@@ -493,7 +566,17 @@ func (pe *File) parseUnwindInfo(unwindInfo uint32) UnwindInfo {
 	if ui.Flags&UnwFlagEHandler != 0 || ui.Flags&UnwFlagUHandler != 0 {
 		if ui.Flags&UnwFlagChainInfo == 0 {
 			handlerOffset := offset + 2*uint32(i)
-			ui.ExceptionHandler = binary.LittleEndian.Uint32(pe.data[handlerOffset:])
+			handler, err := pe.ReadUint32(handlerOffset)
+			if err != nil {
+				return ui
+			}
+			ui.ExceptionHandler = handler
+
+			// The language specific handler data follows the handler RVA.
+			// For __C_specific_handler it is a scope table, other handlers
+			// such as __CxxFrameHandler3 store an unrelated structure, in
+			// which case the validation in parseScopeTable rejects it.
+			ui.ScopeTable = pe.parseScopeTable(handlerOffset + 4)
 		}
 	}
 
@@ -522,7 +605,7 @@ func (pe *File) parseExceptionDirectory(rva, size uint32) error {
 
 	// The target platform determines which format of the function table entry
 	// to use.
-	var exceptions []Exception
+	exceptions := pe.Exceptions[:0]
 	fileOffset := pe.GetOffsetFromRva(rva)
 
 	entrySize := uint32(binary.Size(ImageRuntimeFunctionEntry{}))
@@ -552,11 +635,206 @@ func (pe *File) parseExceptionDirectory(rva, size uint32) error {
 	return nil
 }
 
+// maxScopeRecords bounds the number of scope records accepted in a scope
+// table, a function with more __try blocks than that is not realistic.
+const maxScopeRecords = 1024
+
+// parseScopeTable reads the C_SCOPE_TABLE at the given file offset. As the
+// layout of the language specific handler data depends on the handler, the
+// table is only returned when every record falls within the image.
+func (pe *File) parseScopeTable(offset uint32) *ScopeTable {
+	oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+	if !ok {
+		return nil
+	}
+	sizeOfImage := oh64.SizeOfImage
+
+	count, err := pe.ReadUint32(offset)
+	if err != nil || count == 0 || count > maxScopeRecords {
+		return nil
+	}
+
+	st := ScopeTable{Count: count}
+	recordSize := uint32(binary.Size(ScopeRecord{}))
+	for i := uint32(0); i < count; i++ {
+		record := ScopeRecord{}
+		err := pe.structUnpack(&record, offset+4+i*recordSize, recordSize)
+		if err != nil {
+			return nil
+		}
+
+		// The handler address may also be a filter constant such as
+		// EXCEPTION_EXECUTE_HANDLER, and the jump target is zero for a
+		// __finally block.
+		if record.BeginAddress == 0 || record.BeginAddress >= record.EndAddress ||
+			record.EndAddress > sizeOfImage || record.HandlerAddress > sizeOfImage ||
+			record.JumpTarget > sizeOfImage {
+			return nil
+		}
+		st.ScopeRecords = append(st.ScopeRecords, record)
+	}
+
+	return &st
+}
+
+// X86ExceptionFunction is an entry of the best-effort exception handling
+// inventory of a 32-bit image, which has no function table.
+type X86ExceptionFunction struct {
+	// RVA of the start of the function.
+	BeginAddress uint32 `json:"begin_address"`
+
+	// RVA of the end of the function, zero when the function bounds are
+	// unknown, that is when no FPO record covers a registered handler.
+	EndAddress uint32 `json:"end_address"`
+
+	// Set when the FPO record flags the function as using SEH.
+	HasSEH bool `json:"has_seh"`
+
+	// SAFESEH registered handlers located within the function.
+	Handlers []uint32 `json:"handlers,omitempty"`
+
+	// The FPO record describing the function frame, if any.
+	FPO *FPOData `json:"fpo,omitempty"`
+}
+
+// X86ExceptionInventory correlates the SAFESEH handler table of the load
+// configuration with the FPO debug records to list the functions of a 32-bit
+// image taking part in exception handling. Functions come from the FPO
+// records that either use SEH or contain a registered handler, handlers not
+// covered by any FPO record are reported on their own. The result is sorted
+// by address.
+func (pe *File) X86ExceptionInventory() []X86ExceptionFunction {
+	if pe.Is64 {
+		return nil
+	}
+
+	var fpos []FPOData
+	for _, debug := range pe.Debugs {
+		if entries, ok := debug.Info.([]FPOData); ok {
+			fpos = append(fpos, entries...)
+		}
+	}
+	sort.Slice(fpos, func(i, j int) bool {
+		return fpos[i].OffsetStart < fpos[j].OffsetStart
+	})
+
+	functions := make([]X86ExceptionFunction, len(fpos))
+	for i := range fpos {
+		functions[i] = X86ExceptionFunction{
+			BeginAddress: fpos[i].OffsetStart,
+			EndAddress:   fpos[i].OffsetStart + fpos[i].ProcSize,
+			HasSEH:       fpos[i].HasSEH != 0,
+			FPO:          &fpos[i],
+		}
+	}
+
+	var orphans []X86ExceptionFunction
+	for _, handler := range pe.LoadConfig.SEH {
+		// Find the last function starting at or before the handler.
+		idx := sort.Search(len(functions), func(i int) bool {
+			return functions[i].BeginAddress > handler
+		}) - 1
+		if idx >= 0 && handler < functions[idx].EndAddress {
+			functions[idx].Handlers = append(functions[idx].Handlers, handler)
+			continue
+		}
+		orphans = append(orphans, X86ExceptionFunction{
+			BeginAddress: handler,
+			Handlers:     []uint32{handler},
+		})
+	}
+
+	var inventory []X86ExceptionFunction
+	for _, f := range functions {
+		if f.HasSEH || len(f.Handlers) > 0 {
+			inventory = append(inventory, f)
+		}
+	}
+	inventory = append(inventory, orphans...)
+	sort.SliceStable(inventory, func(i, j int) bool {
+		return inventory[i].BeginAddress < inventory[j].BeginAddress
+	})
+
+	return inventory
+}
+
+// maxUnwindChainDepth bounds how many chained unwind info structures are
+// followed, malformed files can otherwise make the chain loop forever.
+const maxUnwindChainDepth = 32
+
+// UnwindEpilog describes the location of a function epilog as recorded by
+// version 2 UWOP_EPILOG unwind codes.
+type UnwindEpilog struct {
+	// RVA of the first instruction of the epilog.
+	BeginAddress uint32 `json:"begin_address"`
+
+	// Size of the epilog in bytes.
+	Size uint8 `json:"size"`
+}
+
+// Epilogs resolves the version 2 epilog unwind codes into the actual
+// epilog locations of the function described by fn. Unwind info of version
+// 1 does not describe epilogs and yields nil.
+func (ui UnwindInfo) Epilogs(fn ImageRuntimeFunctionEntry) []UnwindEpilog {
+	var epilogs []UnwindEpilog
+	var size uint8
+	first := true
+
+	for _, uc := range ui.UnwindCodes {
+		if uc.UnwindOp != UwOpEpilog || ui.Version != 2 {
+			continue
+		}
+		if first {
+			first = false
+			size = uc.EpilogSize
+			if uc.EpilogAtEnd && fn.EndAddress >= uint32(size) {
+				epilogs = append(epilogs, UnwindEpilog{
+					BeginAddress: fn.EndAddress - uint32(size),
+					Size:         size,
+				})
+			}
+			continue
+		}
+
+		// A zero offset is padding.
+		if uc.EpilogOffset == 0 || fn.EndAddress < uint32(uc.EpilogOffset) {
+			continue
+		}
+		epilogs = append(epilogs, UnwindEpilog{
+			BeginAddress: fn.EndAddress - uint32(uc.EpilogOffset),
+			Size:         size,
+		})
+	}
+
+	return epilogs
+}
+
+// UnwindChain returns the unwind info of the exception entry followed by
+// every unwind info reached through UNW_FLAG_CHAININFO, the primary unwind
+// info of the function being the last element. Replaying the unwind codes of
+// the chain in order reconstructs the full prolog of the function.
+func (pe *File) UnwindChain(e Exception) []UnwindInfo {
+	chain := []UnwindInfo{e.UnwindInfo}
+	visited := map[uint32]bool{e.RuntimeFunction.UnwindInfoAddress: true}
+
+	ui := e.UnwindInfo
+	for ui.Flags&UnwFlagChainInfo != 0 {
+		next := ui.FunctionEntry.UnwindInfoAddress
+		if visited[next] || len(chain) >= maxUnwindChainDepth {
+			pe.logger.Debugf("unwind info chain loop or too deep at 0x%x", next)
+			break
+		}
+		visited[next] = true
+		ui = pe.parseUnwindInfo(next)
+		chain = append(chain, ui)
+	}
+
+	return chain
+}
+
 // PrettyUnwindInfoHandlerFlags returns the string representation of the
 // `flags` field of the unwind info structure.
 func PrettyUnwindInfoHandlerFlags(flags uint8) []string {
-	var values []string
-
 	unwFlagHandlerMap := map[uint8]string{
 		UnwFlagNHandler:  "No Handler",
 		UnwFlagEHandler:  "Exception",
@@ -564,12 +842,9 @@ func PrettyUnwindInfoHandlerFlags(flags uint8) []string {
 		UnwFlagChainInfo: "Chain",
 	}
 
-	for k, s := range unwFlagHandlerMap {
-		if k&flags != 0 {
-			values = append(values, s)
-		}
-	}
-	return values
+	return flagNames(unwFlagHandlerMap, func(k uint64) bool {
+		return uint8(k)&flags != 0
+	})
 }
 
 // String returns the string representation of the an unwind opcode.
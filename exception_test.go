@@ -5,6 +5,7 @@
 package pe
 
 import (
+	"encoding/binary"
 	"reflect"
 	"strconv"
 	"testing"
@@ -42,11 +43,13 @@ func TestParseExceptionDirectory(t *testing.T) {
 					FrameOffset:   0x0,
 					UnwindCodes: []UnwindCode{
 						{
-							CodeOffset:  0x07,
-							UnwindOp:    0x2,
-							OpInfo:      0x8,
-							Operand:     "Size=72",
-							FrameOffset: 0x0,
+							CodeOffset:     0x07,
+							UnwindOp:       0x2,
+							OpInfo:         0x8,
+							Operand:        "Size=72",
+							FrameOffset:    0x0,
+							AllocationSize: 72,
+							Slots:          1,
 						},
 					},
 				},
@@ -145,3 +148,188 @@ func TestExceptionDirectoryUnwindOpcode(t *testing.T) {
 		})
 	}
 }
+
+func TestParseUnwindCodeOperands(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		in    []byte
+		ui    UnwindInfo
+		out   UnwindCode
+		slots int
+	}{
+		{
+			name: "alloc large unscaled",
+			in:   []byte{0x0b, 0x11, 0x00, 0x00, 0x01, 0x00},
+			out: UnwindCode{CodeOffset: 0x0b, UnwindOp: UwOpAllocLarge,
+				OpInfo: 1, Operand: "Size=65536", AllocationSize: 0x10000,
+				Slots: 3},
+			slots: 3,
+		},
+		{
+			name: "save nonvol",
+			in:   []byte{0x0a, 0x34, 0x06, 0x00},
+			out: UnwindCode{CodeOffset: 0x0a, UnwindOp: UwOpSaveNonVol,
+				OpInfo: rbx, Operand: "Register=RBX, Offset=48",
+				FrameOffset: 48, Register: rbx, StackOffset: 48, Slots: 2},
+			slots: 2,
+		},
+		{
+			name: "save xmm128",
+			in:   []byte{0x10, 0x68, 0x02, 0x00},
+			out: UnwindCode{CodeOffset: 0x10, UnwindOp: UwOpSaveXmm128,
+				OpInfo: 6, Operand: "Register=XMM6, Offset=32",
+				FrameOffset: 32, Register: 6, IsXmmRegister: true,
+				StackOffset: 32, Slots: 2},
+			slots: 2,
+		},
+		{
+			name: "set frame pointer",
+			in:   []byte{0x04, 0x03},
+			ui:   UnwindInfo{FrameRegister: rbp, FrameOffset: 2},
+			out: UnwindCode{CodeOffset: 0x04, UnwindOp: UwOpSetFpReg,
+				Operand: "Register=RBP, Offset=32", Register: rbp,
+				StackOffset: 32, Slots: 1},
+			slots: 1,
+		},
+		{
+			name: "epilog v2",
+			in:   []byte{0x05, 0x16, 0x00, 0x00},
+			ui:   UnwindInfo{Version: 2},
+			out: UnwindCode{CodeOffset: 0x05, UnwindOp: UwOpEpilog,
+				OpInfo: 1, Operand: "Flags=1, Size=5", EpilogSize: 5,
+				EpilogAtEnd: true, EpilogOffset: 0x105, Slots: 2},
+			slots: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := NewBytes(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes failed, reason: %v", err)
+			}
+
+			got, slots := file.parseUnwindCode(0, &tt.ui)
+			if slots != tt.slots {
+				t.Errorf("slots assertion failed, got %d, want %d", slots, tt.slots)
+			}
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("unwind code assertion failed, got %+v, want %+v",
+					got, tt.out)
+			}
+		})
+	}
+}
+
+func TestUnwindInfoEpilogs(t *testing.T) {
+
+	fn := ImageRuntimeFunctionEntry{BeginAddress: 0x1000, EndAddress: 0x1100}
+	ui := UnwindInfo{
+		Version: 2,
+		UnwindCodes: []UnwindCode{
+			{UnwindOp: UwOpEpilog, EpilogSize: 6, EpilogAtEnd: true},
+			{UnwindOp: UwOpEpilog, EpilogOffset: 0x40},
+			{UnwindOp: UwOpEpilog},
+			{UnwindOp: UwOpPushNonVol, Register: rbx},
+		},
+	}
+
+	want := []UnwindEpilog{
+		{BeginAddress: 0x10fa, Size: 6},
+		{BeginAddress: 0x10c0, Size: 6},
+	}
+	got := ui.Epilogs(fn)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Epilogs assertion failed, got %v, want %v", got, want)
+	}
+
+	ui.Version = 1
+	if got := ui.Epilogs(fn); got != nil {
+		t.Errorf("Epilogs for version 1 should be nil, got %v", got)
+	}
+}
+
+func TestParseScopeTable(t *testing.T) {
+
+	record := func(begin, end, handler, target uint32) []byte {
+		b := make([]byte, 16)
+		binary.LittleEndian.PutUint32(b, begin)
+		binary.LittleEndian.PutUint32(b[4:], end)
+		binary.LittleEndian.PutUint32(b[8:], handler)
+		binary.LittleEndian.PutUint32(b[12:], target)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		in   []byte
+		out  *ScopeTable
+	}{
+		{
+			name: "valid",
+			in: append(append([]byte{2, 0, 0, 0},
+				record(0x1010, 0x1020, 0x1, 0x1030)...),
+				record(0x1040, 0x1050, 0x1060, 0)...),
+			out: &ScopeTable{Count: 2, ScopeRecords: []ScopeRecord{
+				{0x1010, 0x1020, 0x1, 0x1030},
+				{0x1040, 0x1050, 0x1060, 0},
+			}},
+		},
+		{
+			name: "record outside image",
+			in:   append([]byte{1, 0, 0, 0}, record(0x1010, 0x90000, 0x1, 0)...),
+		},
+		{
+			name: "not a scope table",
+			in:   []byte{0x00, 0x20, 0x01, 0x00},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := NewBytes(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes failed, reason: %v", err)
+			}
+			file.NtHeader.OptionalHeader = ImageOptionalHeader64{
+				SizeOfImage: 0x10000}
+
+			got := file.parseScopeTable(0)
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("parseScopeTable assertion failed, got %v, want %v",
+					got, tt.out)
+			}
+		})
+	}
+}
+
+func TestX86ExceptionInventory(t *testing.T) {
+
+	file := &File{}
+	file.Is32 = true
+	file.Debugs = []DebugEntry{{Info: []FPOData{
+		{OffsetStart: 0x2000, ProcSize: 0x40},
+		{OffsetStart: 0x1000, ProcSize: 0x80, HasSEH: 1},
+		{OffsetStart: 0x3000, ProcSize: 0x10},
+	}}}
+	file.LoadConfig.SEH = []uint32{0x2010, 0x5000}
+
+	got := file.X86ExceptionInventory()
+	want := []X86ExceptionFunction{
+		{BeginAddress: 0x1000, EndAddress: 0x1080, HasSEH: true},
+		{BeginAddress: 0x2000, EndAddress: 0x2040, Handlers: []uint32{0x2010}},
+		{BeginAddress: 0x5000, Handlers: []uint32{0x5000}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("inventory length assertion failed, got %d, want %d",
+			len(got), len(want))
+	}
+	for i := range want {
+		got[i].FPO = nil
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("inventory entry %d assertion failed, got %+v, want %+v",
+				i, got[i], want[i])
+		}
+	}
+}
@@ -12,6 +12,12 @@ import (
 
 const (
 	maxExportedSymbols = 0x2000
+
+	// MaxDefaultExportEntriesCount represents the default maximum number of
+	// export entries to parse. Some malware declares millions of exports
+	// which causes huge allocations.
+	// Example: 0b1d3d3664915577ab9a32188d29bbf3542b86c7b9ce333e245496c3018819f1
+	MaxDefaultExportEntriesCount = 0x10000
 )
 
 var (
@@ -19,8 +25,25 @@ var (
 	ErrExportManyRepeatedEntries = "Export directory contains many repeated entries"
 	AnoNullNumberOfFunctions     = "Export directory contains zero number of functions"
 	AnoNullAddressOfFunctions    = "Export directory contains zero address of functions"
+	AnoExportEntriesCount        = "Export directory entries count is absurdly high"
+
+	// AnoExportNamesNotSorted is reported when AddressOfNames is not sorted
+	// lexicographically, the loader binary search would fail to find some
+	// of the names.
+	AnoExportNamesNotSorted = "Export names are not sorted, `%s` at index %d follows `%s`"
+
+	// AnoExportNameDuplicated is reported when a name is exported twice.
+	AnoExportNameDuplicated = "Export name `%s` is duplicated at index %d"
+
+	// AnoExportNameOutsideDirectory is reported when a name RVA does not
+	// point within the export directory, where linkers store the names.
+	AnoExportNameOutsideDirectory = "Export name `%s` at index %d has its RVA 0x%x outside the export directory"
 )
 
+// maxExportNameAnomalies limits the number of anomalies reported for each
+// kind of export name inconsistency.
+const maxExportNameAnomalies = 10
+
 // ImageExportDirectory represents the IMAGE_EXPORT_DIRECTORY structure.
 // The export directory table contains address information that is used
 // to resolve imports to the entry points within this image.
@@ -74,6 +97,15 @@ type ExportFunction struct {
 	Name         string `json:"name"`
 	Forwarder    string `json:"forwarder"`
 	ForwarderRVA uint32 `json:"forwarder_rva"`
+
+	// The demangled form of a C++ decorated name, only populated with the
+	// DemangleNames option. It is empty when the name is not decorated or
+	// when the names are read lazily with LazyExportNames. See Demangle.
+	Demangled string `json:"demangled,omitempty"`
+
+	// The name as found in the file when Options.NameSanitization changed
+	// it, empty otherwise.
+	RawName string `json:"raw_name,omitempty"`
 }
 
 // Export represent the export table.
@@ -81,6 +113,10 @@ type Export struct {
 	Functions []ExportFunction     `json:"functions"`
 	Struct    ImageExportDirectory `json:"struct"`
 	Name      string               `json:"name"`
+
+	// The name as found in the file when Options.NameSanitization changed
+	// it, empty otherwise.
+	RawName string `json:"raw_name,omitempty"`
 }
 
 /*
@@ -101,7 +137,7 @@ is exporting some functions by ordinal.
 func (pe *File) parseExportDirectory(rva, size uint32) error {
 
 	// Define some vars.
-	exp := Export{}
+	exp := Export{Functions: pe.Export.Functions[:0]}
 	exportDir := ImageExportDirectory{}
 	errorMsg := fmt.Sprintf("Error parsing export directory at RVA: 0x%x", rva)
 
@@ -116,19 +152,19 @@ func (pe *File) parseExportDirectory(rva, size uint32) error {
 	// We keep track of the bytes left in the file and use it to set a upper
 	// bound in the number of items that can be read from the different arrays.
 	lengthUntilEOF := func(rva uint32) uint32 {
-		return pe.size - pe.GetOffsetFromRva(rva)
+		return pe.remaining(pe.GetOffsetFromRva(rva))
 	}
 	var length uint32
 	var addressOfNames []byte
 
 	// Some DLLs have null number of functions.
 	if exportDir.NumberOfFunctions == 0 {
-		pe.Anomalies = append(pe.Anomalies, AnoNullNumberOfFunctions)
+		pe.appendAnomaly(AnoNullNumberOfFunctions)
 	}
 
 	// Some DLLs have null address of functions.
 	if exportDir.AddressOfFunctions == 0 {
-		pe.Anomalies = append(pe.Anomalies, AnoNullAddressOfFunctions)
+		pe.appendAnomaly(AnoNullAddressOfFunctions)
 	}
 
 	length = min(lengthUntilEOF(exportDir.AddressOfNames),
@@ -152,12 +188,13 @@ func (pe *File) parseExportDirectory(rva, size uint32) error {
 		return errors.New(errorMsg)
 	}
 
-	exp.Name = pe.getStringAtRVA(exportDir.Name, 0x100000)
+	exp.Name, exp.RawName = pe.sanitizeName(
+		pe.getStringAtRVA(exportDir.Name, 0x100000))
 
 	maxFailedEntries := 10
 	var forwarderStr string
 	var forwarderOffset uint32
-	safetyBoundary := pe.size // overly generous upper bound
+	safetyBoundary := pe.remaining(0) // overly generous upper bound
 	symbolCounts := make(map[uint32]int)
 	parsingFailed := false
 
@@ -170,15 +207,14 @@ func (pe *File) parseExportDirectory(rva, size uint32) error {
 
 	numNames := min(exportDir.NumberOfNames, safetyBoundary/4)
 	var symbolAddress uint32
+	defer func() {
+		// recover from panic if one occured. Set err to nil otherwise.
+		if recover() != nil {
+			err = errors.New("array index out of bounds")
+		}
+	}()
 	for i := uint32(0); i < numNames; i++ {
 
-		defer func() {
-			// recover from panic if one occured. Set err to nil otherwise.
-			if recover() != nil {
-				err = errors.New("array index out of bounds")
-			}
-		}()
-
 		symbolOrdinal := binary.LittleEndian.Uint16(addressOfNameOrdinals[i*2:])
 		symbolAddress = binary.LittleEndian.Uint32(addressOfFunctions[symbolOrdinal*4:])
 		if symbolAddress == 0 {
@@ -204,10 +240,13 @@ func (pe *File) parseExportDirectory(rva, size uint32) error {
 				break
 			}
 		}
-		symbolName := pe.getStringAtRVA(symbolNameAddress, 0x100000)
-		if !IsValidFunctionName(symbolName) {
-			parsingFailed = true
-			break
+		var symbolName string
+		if !pe.opts.LazyExportNames {
+			symbolName = pe.getStringAtRVA(symbolNameAddress, 0x100000)
+			if !IsValidFunctionName(symbolName) {
+				parsingFailed = true
+				break
+			}
 		}
 
 		symbolNameOffset := pe.GetOffsetFromRva(symbolNameAddress)
@@ -225,14 +264,18 @@ func (pe *File) parseExportDirectory(rva, size uint32) error {
 		symbolCounts[symbolAddress]++
 		if symbolCounts[symbolAddress] > 10 {
 			if !stringInSlice(ErrExportManyRepeatedEntries, pe.Anomalies) {
-				pe.Anomalies = append(pe.Anomalies, ErrExportManyRepeatedEntries)
+				pe.appendAnomaly(ErrExportManyRepeatedEntries)
 			}
 		}
 		if len(symbolCounts) > maxExportedSymbols {
 			if !stringInSlice(ErrExportMaxOrdEntries, pe.Anomalies) {
-				pe.Anomalies = append(pe.Anomalies, ErrExportMaxOrdEntries)
+				pe.appendAnomaly(ErrExportMaxOrdEntries)
 			}
 		}
+		if uint32(len(exp.Functions)) >= pe.opts.MaxExportEntries {
+			pe.addAnomaly(AnoExportEntriesCount)
+			break
+		}
 		newExport := ExportFunction{
 			Name:         symbolName,
 			NameRVA:      symbolNameAddress,
@@ -242,6 +285,10 @@ func (pe *File) parseExportDirectory(rva, size uint32) error {
 			Forwarder:    forwarderStr,
 			ForwarderRVA: forwarderOffset,
 		}
+		if pe.opts.DemangleNames && !pe.opts.LazyExportNames {
+			newExport.Demangled = Demangle(symbolName)
+		}
+		newExport.Name, newExport.RawName = pe.sanitizeName(symbolName)
 
 		exp.Functions = append(exp.Functions, newExport)
 	}
@@ -251,11 +298,15 @@ func (pe *File) parseExportDirectory(rva, size uint32) error {
 			"invalid address: 0x%x\n", exportDir.AddressOfNames)
 	}
 
+	// The functions parsed so far are the named ones, in the order of
+	// AddressOfNames.
+	pe.checkExportNames(exp.Functions, rva, size)
+
 	maxFailedEntries = 10
 	section = pe.getSectionByRva(exportDir.AddressOfFunctions)
 
 	// Overly generous upper bound
-	safetyBoundary = pe.size
+	safetyBoundary = pe.remaining(0)
 	if section != nil {
 		safetyBoundary = section.Header.VirtualAddress +
 			uint32(len(section.Data(0, 0, pe))) - exportDir.AddressOfNames
@@ -294,15 +345,19 @@ func (pe *File) parseExportDirectory(rva, size uint32) error {
 		symbolCounts[symbolAddress]++
 		if symbolCounts[symbolAddress] > 10 {
 			if !stringInSlice(ErrExportManyRepeatedEntries, pe.Anomalies) {
-				pe.Anomalies = append(pe.Anomalies, ErrExportManyRepeatedEntries)
+				pe.appendAnomaly(ErrExportManyRepeatedEntries)
 			}
 		}
 		if len(symbolCounts) > maxExportedSymbols {
 			if !stringInSlice(ErrExportMaxOrdEntries, pe.Anomalies) {
 
-				pe.Anomalies = append(pe.Anomalies, ErrExportMaxOrdEntries)
+				pe.appendAnomaly(ErrExportMaxOrdEntries)
 			}
 		}
+		if uint32(len(exp.Functions)) >= pe.opts.MaxExportEntries {
+			pe.addAnomaly(AnoExportEntriesCount)
+			break
+		}
 		newExport := ExportFunction{
 			Ordinal:      exportDir.Base + i,
 			FunctionRVA:  symbolAddress,
@@ -318,6 +373,68 @@ func (pe *File) parseExportDirectory(rva, size uint32) error {
 	return nil
 }
 
+// checkExportNames reports the inconsistencies of the export names which
+// are signs of tampered exports: names not sorted lexicographically, which
+// breaks the binary search done by the loader, duplicated names and names
+// stored outside of the export directory. Under the LazyExportNames option,
+// names are not read, so only the names stored outside of the export
+// directory are reported.
+func (pe *File) checkExportNames(functions []ExportFunction, rva, size uint32) {
+	if pe.opts.LazyExportNames {
+		outside := 0
+		for i, function := range functions {
+			if outside >= maxExportNameAnomalies {
+				break
+			}
+			if function.NameRVA < rva || function.NameRVA >= rva+size {
+				pe.addAnomaly(fmt.Sprintf(AnoExportNameOutsideDirectory,
+					pe.ExportFunctionName(function), i, function.NameRVA))
+				outside++
+			}
+		}
+		return
+	}
+
+	var unsorted bool
+	var duplicated, outside int
+	seen := make(map[string]bool)
+	var prev string
+	for i, function := range functions {
+		name := function.Name
+
+		// Only the first unsorted name is reported.
+		if i > 0 && name < prev && !unsorted {
+			pe.addAnomaly(fmt.Sprintf(AnoExportNamesNotSorted, name, i, prev))
+			unsorted = true
+		}
+		prev = name
+
+		if seen[name] && duplicated < maxExportNameAnomalies {
+			pe.addAnomaly(fmt.Sprintf(AnoExportNameDuplicated, name, i))
+			duplicated++
+		}
+		seen[name] = true
+
+		if (function.NameRVA < rva || function.NameRVA >= rva+size) &&
+			outside < maxExportNameAnomalies {
+			pe.addAnomaly(fmt.Sprintf(AnoExportNameOutsideDirectory, name, i,
+				function.NameRVA))
+			outside++
+		}
+	}
+}
+
+// ExportFunctionName returns the name of an exported function. When the
+// export directory was parsed with the LazyExportNames option, the name is
+// read from the file on each call and Options.NameSanitization applied.
+func (pe *File) ExportFunctionName(function ExportFunction) string {
+	if function.Name != "" || function.NameRVA == 0 {
+		return function.Name
+	}
+	return pe.opts.NameSanitization.Sanitize(
+		pe.getStringAtRVA(function.NameRVA, 0x100000))
+}
+
 // GetExportFunctionByRVA return an export function given an RVA.
 func (pe *File) GetExportFunctionByRVA(rva uint32) ExportFunction {
 	for _, exp := range pe.Export.Functions {
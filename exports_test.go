@@ -5,6 +5,10 @@
 package pe
 
 import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -148,3 +152,229 @@ func TestExportDirectory(t *testing.T) {
 		})
 	}
 }
+
+// hugeExportDirectory returns the content of kernel32.dll with an export
+// directory declaring millions of functions and names.
+func hugeExportDirectory(tb testing.TB) []byte {
+	filename := getAbsoluteFilePath("test/kernel32.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		tb.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	file, err := NewBytes(data, &Options{Fast: true})
+	if err != nil {
+		tb.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		tb.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	r, err := file.DataDirectoryRange(ImageDirectoryEntryExport)
+	if err != nil {
+		tb.Fatalf("DataDirectoryRange(%s) failed, reason: %v", filename, err)
+	}
+
+	// Patch NumberOfFunctions and NumberOfNames.
+	binary.LittleEndian.PutUint32(data[r.Offset+20:], 0xac0000)
+	binary.LittleEndian.PutUint32(data[r.Offset+24:], 0xac0000)
+	return data
+}
+
+func TestExportDirectoryMaxEntries(t *testing.T) {
+	tests := []struct {
+		maxEntries uint32
+		entryCount int
+		anomaly    bool
+	}{
+		{0x100, 0x100, true},
+		{0x1000, 0x1000, true},
+		{0x10000, 32753, false},
+	}
+
+	data := hugeExportDirectory(t)
+	for _, tt := range tests {
+		file, err := NewBytes(data, &Options{MaxExportEntries: tt.maxEntries})
+		if err != nil {
+			t.Fatalf("NewBytes() failed, reason: %v", err)
+		}
+		err = file.Parse()
+		if err != nil {
+			t.Fatalf("Parse() failed, reason: %v", err)
+		}
+
+		if len(file.Export.Functions) != tt.entryCount {
+			t.Errorf("export functions count assertion failed, got %v, want %v",
+				len(file.Export.Functions), tt.entryCount)
+		}
+		got := stringInSlice(AnoExportEntriesCount, file.Anomalies)
+		if got != tt.anomaly {
+			t.Errorf("export entries count anomaly assertion failed, got %v, want %v",
+				got, tt.anomaly)
+		}
+	}
+}
+
+func TestExportDirectoryLazyNames(t *testing.T) {
+	filename := getAbsoluteFilePath("test/kernel32.dll")
+	file, err := New(filename, &Options{LazyExportNames: true})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	if len(file.Export.Functions) != 1633 {
+		t.Fatalf("export functions count assertion failed, got %v, want %v",
+			len(file.Export.Functions), 1633)
+	}
+	function := file.Export.Functions[0]
+	if function.Name != "" {
+		t.Errorf("export name should not be resolved, got %s", function.Name)
+	}
+	name := file.ExportFunctionName(function)
+	if name != "AcquireSRWLockExclusive" {
+		t.Errorf("ExportFunctionName() got %s, want %s", name, "AcquireSRWLockExclusive")
+	}
+}
+
+func TestExportNameAnomalies(t *testing.T) {
+	filename := getAbsoluteFilePath("test/kernel32.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	for _, anomaly := range file.Anomalies {
+		if strings.HasPrefix(anomaly, "Export name") {
+			t.Errorf("unexpected export name anomaly: %s", anomaly)
+		}
+	}
+
+	names := file.GetOffsetFromRva(file.Export.Struct.AddressOfNames)
+	first := file.Export.Functions[0]
+	second := file.Export.Functions[1]
+
+	tests := []struct {
+		name    string
+		patch   func(data []byte)
+		anomaly string
+		lazy    bool
+	}{
+		{
+			"unsorted",
+			func(data []byte) {
+				binary.LittleEndian.PutUint32(data[names:], second.NameRVA)
+				binary.LittleEndian.PutUint32(data[names+4:], first.NameRVA)
+			},
+			fmt.Sprintf(AnoExportNamesNotSorted, first.Name, 1, second.Name),
+			false,
+		},
+		{
+			"duplicated",
+			func(data []byte) {
+				binary.LittleEndian.PutUint32(data[names+4:], first.NameRVA)
+			},
+			fmt.Sprintf(AnoExportNameDuplicated, first.Name, 1),
+			false,
+		},
+		{
+			"outside",
+			func(data []byte) {
+				// Point to the DOS stub message.
+				copy(data[0x4e:], "AAA\x00")
+				binary.LittleEndian.PutUint32(data[names:], 0x4e)
+			},
+			fmt.Sprintf(AnoExportNameOutsideDirectory, "AAA", 0, 0x4e),
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched := make([]byte, len(data))
+			copy(patched, data)
+			tt.patch(patched)
+
+			file, err := NewBytes(patched, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+			if !stringInSlice(tt.anomaly, file.Anomalies) {
+				t.Errorf("export name anomaly assertion failed, want %s, got %v",
+					tt.anomaly, file.Anomalies)
+			}
+
+			// Names are not read under LazyExportNames, only the names
+			// outside of the export directory are reported.
+			file, err = NewBytes(patched, &Options{LazyExportNames: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+			got := stringInSlice(tt.anomaly, file.Anomalies)
+			if got != tt.lazy {
+				t.Errorf("lazy export name anomaly assertion failed, got %v, want %v",
+					got, tt.lazy)
+			}
+		})
+	}
+}
+
+func benchmarkExportDirectory(b *testing.B, data []byte, opts Options) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		opts := opts
+		file, err := NewBytes(data, &opts)
+		if err != nil {
+			b.Fatalf("NewBytes() failed, reason: %v", err)
+		}
+		err = file.Parse()
+		if err != nil {
+			b.Fatalf("Parse() failed, reason: %v", err)
+		}
+	}
+}
+
+func BenchmarkExportDirectory(b *testing.B) {
+	filename := getAbsoluteFilePath("test/kernel32.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		b.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+	benchmarkExportDirectory(b, data, Options{})
+}
+
+func BenchmarkExportDirectoryLazyNames(b *testing.B) {
+	filename := getAbsoluteFilePath("test/kernel32.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		b.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+	benchmarkExportDirectory(b, data, Options{LazyExportNames: true})
+}
+
+func BenchmarkExportDirectoryHuge(b *testing.B) {
+	benchmarkExportDirectory(b, hugeExportDirectory(b), Options{})
+}
+
+func BenchmarkExportDirectoryHugeCapped(b *testing.B) {
+	benchmarkExportDirectory(b, hugeExportDirectory(b),
+		Options{MaxExportEntries: 0x1000})
+}
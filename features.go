@@ -0,0 +1,402 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+const (
+	// byteEntropyWindow is the size of the sliding window used to compute
+	// the byte entropy histogram.
+	byteEntropyWindow = 2048
+
+	// byteEntropyStep is the step of the sliding window used to compute the
+	// byte entropy histogram.
+	byteEntropyStep = 1024
+)
+
+// FeatureVector returns a flattened numeric feature set describing the file,
+// similar to the one used by EMBER and other PE-based machine learning
+// models. It groups general information, header fields, section statistics,
+// import and export counts, string statistics and byte/entropy histograms.
+// The name of every value is given at the same index by FeatureNames. The
+// file must be parsed before calling FeatureVector.
+func (pe *File) FeatureVector() []float64 {
+	var values []float64
+	pe.features(func(name string, v float64) {
+		values = append(values, v)
+	})
+	return values
+}
+
+// FeatureNames returns the names of the values returned by FeatureVector,
+// in the same order.
+func FeatureNames() []string {
+	var names []string
+	pe := &File{}
+	pe.features(func(name string, v float64) {
+		names = append(names, name)
+	})
+	return names
+}
+
+// features calls add for every feature of the file, in a fixed order.
+func (pe *File) features(add func(name string, v float64)) {
+	pe.generalFeatures(add)
+	pe.headerFeatures(add)
+	pe.sectionFeatures(add)
+	pe.importExportFeatures(add)
+	stringFeatures(pe.data, add)
+	byteHistogramFeatures(pe.data, add)
+	byteEntropyHistogramFeatures(pe.data, add)
+}
+
+func boolFeature(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (pe *File) generalFeatures(add func(name string, v float64)) {
+	overlaySize := int64(0)
+	if pe.HasOverlay {
+		overlaySize = pe.OverlayLength()
+	}
+
+	add("general.size", float64(len(pe.data)))
+	add("general.is_64", boolFeature(pe.Is64))
+	add("general.is_dll", boolFeature(pe.HasNTHdr && pe.IsDLL()))
+	add("general.has_rich_header", boolFeature(pe.HasRichHdr))
+	add("general.has_debug", boolFeature(pe.HasDebug))
+	add("general.has_relocations", boolFeature(pe.HasReloc))
+	add("general.has_resources", boolFeature(pe.HasResource))
+	add("general.has_signature", boolFeature(pe.HasCertificate))
+	add("general.has_tls", boolFeature(pe.HasTLS))
+	add("general.has_load_config", boolFeature(pe.HasLoadCFG))
+	add("general.has_clr", boolFeature(pe.HasCLR))
+	add("general.has_overlay", boolFeature(pe.HasOverlay))
+	add("general.overlay_size", float64(overlaySize))
+	add("general.symbols", float64(len(pe.COFF.SymbolTable)))
+}
+
+func (pe *File) headerFeatures(add func(name string, v float64)) {
+	fh := pe.NtHeader.FileHeader
+	add("header.machine", float64(fh.Machine))
+	add("header.timestamp", float64(fh.TimeDateStamp))
+	add("header.number_of_sections", float64(fh.NumberOfSections))
+	add("header.characteristics", float64(fh.Characteristics))
+
+	// Only keep the fields common to both optional header flavors.
+	var oh ImageOptionalHeader64
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			oh = oh64
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			oh = ImageOptionalHeader64{
+				Magic:                       oh32.Magic,
+				MajorLinkerVersion:          oh32.MajorLinkerVersion,
+				MinorLinkerVersion:          oh32.MinorLinkerVersion,
+				SizeOfCode:                  oh32.SizeOfCode,
+				SizeOfInitializedData:       oh32.SizeOfInitializedData,
+				SizeOfUninitializedData:     oh32.SizeOfUninitializedData,
+				AddressOfEntryPoint:         oh32.AddressOfEntryPoint,
+				MajorOperatingSystemVersion: oh32.MajorOperatingSystemVersion,
+				MinorOperatingSystemVersion: oh32.MinorOperatingSystemVersion,
+				MajorImageVersion:           oh32.MajorImageVersion,
+				MinorImageVersion:           oh32.MinorImageVersion,
+				MajorSubsystemVersion:       oh32.MajorSubsystemVersion,
+				MinorSubsystemVersion:       oh32.MinorSubsystemVersion,
+				SizeOfImage:                 oh32.SizeOfImage,
+				SizeOfHeaders:               oh32.SizeOfHeaders,
+				CheckSum:                    oh32.CheckSum,
+				Subsystem:                   oh32.Subsystem,
+				DllCharacteristics:          oh32.DllCharacteristics,
+				SizeOfStackReserve:          uint64(oh32.SizeOfStackReserve),
+				SizeOfStackCommit:           uint64(oh32.SizeOfStackCommit),
+				SizeOfHeapReserve:           uint64(oh32.SizeOfHeapReserve),
+				SizeOfHeapCommit:            uint64(oh32.SizeOfHeapCommit),
+			}
+		}
+	}
+
+	add("header.magic", float64(oh.Magic))
+	add("header.major_linker_version", float64(oh.MajorLinkerVersion))
+	add("header.minor_linker_version", float64(oh.MinorLinkerVersion))
+	add("header.size_of_code", float64(oh.SizeOfCode))
+	add("header.size_of_initialized_data", float64(oh.SizeOfInitializedData))
+	add("header.size_of_uninitialized_data", float64(oh.SizeOfUninitializedData))
+	add("header.address_of_entry_point", float64(oh.AddressOfEntryPoint))
+	add("header.major_os_version", float64(oh.MajorOperatingSystemVersion))
+	add("header.minor_os_version", float64(oh.MinorOperatingSystemVersion))
+	add("header.major_image_version", float64(oh.MajorImageVersion))
+	add("header.minor_image_version", float64(oh.MinorImageVersion))
+	add("header.major_subsystem_version", float64(oh.MajorSubsystemVersion))
+	add("header.minor_subsystem_version", float64(oh.MinorSubsystemVersion))
+	add("header.size_of_image", float64(oh.SizeOfImage))
+	add("header.size_of_headers", float64(oh.SizeOfHeaders))
+	add("header.checksum", float64(oh.CheckSum))
+	add("header.subsystem", float64(oh.Subsystem))
+	add("header.dll_characteristics", float64(oh.DllCharacteristics))
+	add("header.size_of_stack_reserve", float64(oh.SizeOfStackReserve))
+	add("header.size_of_stack_commit", float64(oh.SizeOfStackCommit))
+	add("header.size_of_heap_reserve", float64(oh.SizeOfHeapReserve))
+	add("header.size_of_heap_commit", float64(oh.SizeOfHeapCommit))
+}
+
+func (pe *File) sectionFeatures(add func(name string, v float64)) {
+	var zeroSize, emptyName, executable, writable, rwx float64
+	var rawSize, virtualSize, sumEntropy float64
+	minEntropy, maxEntropy := 0.0, 0.0
+	entryEntropy, entryExecutable := 0.0, 0.0
+
+	entryPoint := uint32(0)
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			entryPoint = oh64.AddressOfEntryPoint
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			entryPoint = oh32.AddressOfEntryPoint
+		}
+	}
+
+	for i, section := range pe.Sections {
+		hdr := section.Header
+		var e float64
+		if section.Entropy != nil {
+			e = *section.Entropy
+		} else {
+			e = section.CalculateEntropy(pe)
+		}
+
+		if hdr.SizeOfRawData == 0 {
+			zeroSize++
+		}
+		if section.String() == "" {
+			emptyName++
+		}
+		perms := section.Permissions()
+		isExec, isWrite, isRead := perms.Execute, perms.Write, perms.Read
+		if isExec {
+			executable++
+		}
+		if isWrite {
+			writable++
+		}
+		if isExec && isWrite && isRead {
+			rwx++
+		}
+
+		rawSize += float64(hdr.SizeOfRawData)
+		virtualSize += float64(hdr.VirtualSize)
+		sumEntropy += e
+		if i == 0 || e < minEntropy {
+			minEntropy = e
+		}
+		if i == 0 || e > maxEntropy {
+			maxEntropy = e
+		}
+
+		if section.Contains(entryPoint, pe) {
+			entryEntropy = e
+			entryExecutable = boolFeature(isExec)
+		}
+	}
+
+	count := float64(len(pe.Sections))
+	mean := func(sum float64) float64 {
+		if count == 0 {
+			return 0
+		}
+		return sum / count
+	}
+
+	add("section.count", count)
+	add("section.zero_size", zeroSize)
+	add("section.empty_name", emptyName)
+	add("section.executable", executable)
+	add("section.writable", writable)
+	add("section.rwx", rwx)
+	add("section.mean_raw_size", mean(rawSize))
+	add("section.mean_virtual_size", mean(virtualSize))
+	add("section.min_entropy", minEntropy)
+	add("section.mean_entropy", mean(sumEntropy))
+	add("section.max_entropy", maxEntropy)
+	add("section.entry_entropy", entryEntropy)
+	add("section.entry_executable", entryExecutable)
+}
+
+func (pe *File) importExportFeatures(add func(name string, v float64)) {
+	var functions, byOrdinal, delayFunctions, namedExports float64
+	imports := pe.imports()
+	for _, imp := range imports {
+		for _, fn := range imp.Functions {
+			functions++
+			if fn.ByOrdinal {
+				byOrdinal++
+			}
+		}
+	}
+	for _, imp := range pe.DelayImports {
+		delayFunctions += float64(len(imp.Functions))
+	}
+	for _, fn := range pe.Export.Functions {
+		if pe.ExportFunctionName(fn) != "" {
+			namedExports++
+		}
+	}
+
+	add("imports.libraries", float64(len(imports)))
+	add("imports.functions", functions)
+	add("imports.by_ordinal", byOrdinal)
+	add("imports.delay_libraries", float64(len(pe.DelayImports)))
+	add("imports.delay_functions", delayFunctions)
+	add("exports.functions", float64(len(pe.Export.Functions)))
+	add("exports.named", namedExports)
+}
+
+// stringFeatures computes statistics over the printable ASCII strings of at
+// least DefaultStringMinLength characters found in data.
+func stringFeatures(data []byte, add func(name string, v float64)) {
+	var count, printables, paths, urls, registry float64
+	var dist [0x60]float64
+
+	hasPrefixFold := func(s []byte, prefix string) bool {
+		return len(s) >= len(prefix) &&
+			bytes.EqualFold(s[:len(prefix)], []byte(prefix))
+	}
+
+	start := 0
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && data[i] >= 0x20 && data[i] < 0x80 {
+			continue
+		}
+		if i-start >= DefaultStringMinLength {
+			s := data[start:i]
+			count++
+			printables += float64(len(s))
+			for _, c := range s {
+				dist[c-0x20]++
+			}
+			for j := range s {
+				switch {
+				case hasPrefixFold(s[j:], `c:\`):
+					paths++
+				case hasPrefixFold(s[j:], "http://"),
+					hasPrefixFold(s[j:], "https://"):
+					urls++
+				case bytes.HasPrefix(s[j:], []byte("HKEY_")):
+					registry++
+				}
+			}
+		}
+		start = i + 1
+	}
+
+	avgLength := 0.0
+	stringEntropy := 0.0
+	if count > 0 {
+		avgLength = printables / count
+		for i := range dist {
+			if dist[i] > 0 {
+				p := dist[i] / printables
+				stringEntropy -= p * math.Log2(p)
+			}
+			dist[i] /= printables
+		}
+	}
+
+	add("strings.count", count)
+	add("strings.avg_length", avgLength)
+	add("strings.printables", printables)
+	add("strings.entropy", stringEntropy)
+	add("strings.paths", paths)
+	add("strings.urls", urls)
+	add("strings.registry", registry)
+	add("strings.mz", float64(bytes.Count(data, []byte("MZ"))))
+	for i, v := range dist {
+		add(fmt.Sprintf("strings.printable_dist.%d", i), v)
+	}
+}
+
+// byteHistogramFeatures computes the normalized distribution of the byte
+// values in data.
+func byteHistogramFeatures(data []byte, add func(name string, v float64)) {
+	var histogram [256]float64
+	for _, b := range data {
+		histogram[b]++
+	}
+	for i, v := range histogram {
+		if len(data) > 0 {
+			v /= float64(len(data))
+		}
+		add(fmt.Sprintf("byte_histogram.%d", i), v)
+	}
+}
+
+// byteEntropyHistogramFeatures computes the normalized joint distribution of
+// the entropy of a sliding window over data and the byte values (reduced to
+// their high nibble) found in that window, as described in "Deep Neural
+// Network Based Malware Detection Using Two Dimensional Binary Program
+// Features" by Saxe and Berlin.
+func byteEntropyHistogramFeatures(data []byte, add func(name string, v float64)) {
+	var histogram [16][16]float64
+
+	addBlock := func(block []byte) {
+		var counts [16]float64
+		for _, b := range block {
+			counts[b>>4]++
+		}
+
+		h := 0.0
+		for _, c := range counts {
+			if c > 0 {
+				p := c / byteEntropyWindow
+				h -= p * math.Log2(p)
+			}
+		}
+
+		// The entropy is doubled as the byte values were reduced from 8 to
+		// 4 bits, and then quantized into 16 bins.
+		bin := int(h * 2 * 2)
+		if bin > 15 {
+			bin = 15
+		}
+		for i, c := range counts {
+			histogram[bin][i] += c
+		}
+	}
+
+	if len(data) < byteEntropyWindow {
+		addBlock(data)
+	} else {
+		for offset := 0; offset+byteEntropyWindow <= len(data); offset += byteEntropyStep {
+			addBlock(data[offset : offset+byteEntropyWindow])
+		}
+	}
+
+	total := 0.0
+	for i := range histogram {
+		for _, c := range histogram[i] {
+			total += c
+		}
+	}
+	for i := range histogram {
+		for j, c := range histogram[i] {
+			if total > 0 {
+				c /= total
+			}
+			add(fmt.Sprintf("byte_entropy_histogram.%d", i*16+j), c)
+		}
+	}
+}
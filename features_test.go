@@ -0,0 +1,107 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFeatureVector(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out map[string]float64
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), map[string]float64{
+			"general.size":             1179024,
+			"general.is_64":            1,
+			"general.is_dll":           0,
+			"general.has_signature":    1,
+			"general.overlay_size":     15760,
+			"header.machine":           float64(ImageFileMachineAMD64),
+			"header.magic":             ImageNtOptionalHeader64Magic,
+			"header.size_of_image":     0x128000,
+			"section.count":            8,
+			"section.executable":       1,
+			"section.entry_executable": 1,
+			"imports.libraries":        8,
+			"imports.functions":        324,
+			"exports.functions":        0,
+		}},
+		{getAbsoluteFilePath("test/impbyord.exe"), map[string]float64{
+			"general.size":       1024,
+			"general.is_64":      0,
+			"header.machine":     float64(ImageFileMachineI386),
+			"header.magic":       ImageNtOptionalHeader32Magic,
+			"section.count":      1,
+			"section.empty_name": 1,
+			"imports.libraries":  2,
+			"imports.by_ordinal": 1,
+			"exports.functions":  1,
+		}},
+		{getAbsoluteFilePath("test/kernel32.dll"), map[string]float64{
+			"general.is_dll":          1,
+			"general.has_rich_header": 1,
+			"imports.delay_libraries": 2,
+			"imports.delay_functions": 11,
+			"exports.functions":       1633,
+			"exports.named":           1633,
+		}},
+	}
+
+	names := FeatureNames()
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			t.Fatalf("FeatureNames() has duplicate name %s", name)
+		}
+		seen[name] = true
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			got := file.FeatureVector()
+			if len(got) != len(names) {
+				t.Fatalf("FeatureVector() length assertion failed, got %v, want %v",
+					len(got), len(names))
+			}
+
+			features := make(map[string]float64)
+			histograms := make(map[string]float64)
+			for i, name := range names {
+				features[name] = got[i]
+				if strings.HasPrefix(name, "byte_") {
+					histograms[name[:strings.LastIndex(name, ".")]] += got[i]
+				}
+			}
+
+			for name, want := range tt.out {
+				if features[name] != want {
+					t.Errorf("feature %s assertion failed, got %v, want %v",
+						name, features[name], want)
+				}
+			}
+
+			// Histograms are normalized.
+			for name, sum := range histograms {
+				if math.Abs(sum-1) > 1e-9 {
+					t.Errorf("%s sum assertion failed, got %v, want 1",
+						name, sum)
+				}
+			}
+		})
+	}
+}
@@ -6,15 +6,29 @@ package pe
 
 import (
 	"errors"
+	"fmt"
 	"github.com/edsrzf/mmap-go"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/saferwall/pe/log"
 )
 
 // A File represents an open PE file.
+//
+// A File is not safe for concurrent use while it is being parsed. Once Parse
+// returns, all exported fields and read-only methods (GetData, GetOffsetFromRva,
+// Checksum, ImpHash, Authentihash, Overlay, ...) can be used from multiple
+// goroutines at the same time, as none of them lazily populate or mutate the
+// File. Methods that record additional findings such as GetAnomalies write to
+// the File and must not be called concurrently with other methods.
 type File struct {
 	DOSHeader    ImageDOSHeader              `json:"dos_header,omitempty"`
+	DOSStub      DOSStub                     `json:"dos_stub,omitempty"`
 	RichHeader   RichHeader                  `json:"rich_header,omitempty"`
 	NtHeader     ImageNtHeader               `json:"nt_header,omitempty"`
 	COFF         COFF                        `json:"coff,omitempty"`
@@ -30,18 +44,32 @@ type File struct {
 	Certificates CertificateSection          `json:"certificates,omitempty"`
 	DelayImports []DelayImport               `json:"delay_imports,omitempty"`
 	BoundImports []BoundImportDescriptorData `json:"bound_imports,omitempty"`
+	Architecture ReservedDataDirectory       `json:"architecture,omitempty"`
 	GlobalPtr    uint32                      `json:"global_ptr,omitempty"`
 	CLR          CLRData                     `json:"clr,omitempty"`
 	IAT          []IATEntry                  `json:"iat,omitempty"`
+	Reserved     ReservedDataDirectory       `json:"reserved,omitempty"`
 	Anomalies    []string                    `json:"anomalies,omitempty"`
+	AnomalyIDs   []AnomalyID                 `json:"anomaly_ids,omitempty"`
+	Warnings     []Warning                   `json:"warnings,omitempty"`
+	Stats        []ParseStats                `json:"stats,omitempty"`
 	Header       []byte
 	data         mmap.MMap
+	mapped       bool
 	FileInfo
-	size          uint32
+	size          uint64
 	OverlayOffset int64
-	f             *os.File
-	opts          *Options
-	logger        *log.Helper
+	sectionMap    []SectionMapping
+	hooks
+	coverage       []CoverageRange
+	coverageParser string
+	statsStart     time.Time
+	parsing        bool
+	dirStatus      [ImageNumberOfDirectoryEntries]DataDirectoryStatus
+	dirErr         [ImageNumberOfDirectoryEntries]error
+	f              *os.File
+	opts           *Options
+	logger         *log.Helper
 }
 
 // Options that influence the PE parsing behaviour.
@@ -50,21 +78,124 @@ type Options struct {
 	// Parse only the PE header and do not parse data directories, by default (false).
 	Fast bool
 
+	// Do not fail when the NT header signature is invalid, by default
+	// (false). Parse then returns a partial File holding the DOS header, the
+	// Rich header and the DOS stub, HasNTHdr is false and everything from
+	// the NT header offset on is reported as overlay. This lets triage
+	// tooling still bucket corrupt samples.
+	AllowInvalidNTHeader bool
+
+	// Sanitization applied to the names of the imported and exported
+	// functions and of their DLLs, by default (zero value) names are kept as
+	// found in the file. The original name is kept in the RawName field
+	// whenever it was changed.
+	NameSanitization NameSanitization
+
+	// Decoder of the resource strings formatted according to a Windows code
+	// page, by default (nil) the code pages supported by golang.org/x/text.
+	// Unsupported code pages fall back to Windows-1252.
+	CodePageDecoder CodePageDecoder
+
+	// Abort parsing with a *SpecViolationError on the first violation of the
+	// PE specification, such as an invalid alignment, a truncated header or
+	// a data directory which is outside the image or fails to parse. By
+	// default (false), parsing goes on as far as possible and violations
+	// are reported as anomalies, as malware often relies on them.
+	Strict bool
+
+	// Make Parse return an *AnomalyError when the file has anomalies once
+	// parsed, GetAnomalies included, by default (false). This lets build
+	// pipelines use the library as a post-link validator.
+	FailOnAnomaly bool
+
+	// Anomalies which make Parse fail when FailOnAnomaly is set, by default
+	// all of them. Listing the mitigation anomalies, such as AnoNoDynamicBase
+	// or AnoNoCFG, also checks the image opts in to these mitigations. In
+	// Fast mode, the load configuration and the debug directory are not
+	// parsed, so AnoNoCFG and AnoNoCETCompat are always reported.
+	FailOnAnomalies []string
+
+	// The file is an image dumped from memory, laid out as mapped by the
+	// loader: the data of the sections lies at their virtual address instead
+	// of the raw data pointer found in the section headers, by default
+	// (false). See Compare.
+	MappedImage bool
+
 	// Includes section entropy, by default (false).
 	SectionEntropy bool
 
+	// Includes the ssdeep and TLSH fuzzy hashes of the sections, by default
+	// (false). See FuzzySimilarity.
+	SectionFuzzyHashes bool
+
 	// Maximum COFF symbols to parse, by default (MaxDefaultCOFFSymbolsCount).
 	MaxCOFFSymbolsCount uint32
 
 	// Maximum relocations to parse, by default (MaxDefaultRelocEntriesCount).
 	MaxRelocEntriesCount uint32
 
+	// Maximum export entries to parse, by default (MaxDefaultExportEntriesCount).
+	MaxExportEntries uint32
+
+	// Maximum depth of the resource directory tree to parse, by default
+	// (MaxDefaultResourceDepth).
+	MaxResourceDepth uint32
+
+	// Maximum size of the certificate in the security directory, by default
+	// (MaxDefaultCertificateSize).
+	MaxCertificateSize uint32
+
+	// Maximum nesting depth of the ASN.1 encoding of a certificate, by
+	// default (MaxDefaultASN1Depth).
+	MaxASN1Depth uint32
+
+	// Do not read export names while parsing the export directory, names are
+	// resolved on access with ExportFunctionName. The unsorted and duplicated
+	// export names anomalies are not reported, by default (false).
+	LazyExportNames bool
+
+	// Includes the demangled form of C++ decorated import and export names,
+	// by default (false). See Demangle.
+	DemangleNames bool
+
+	// Do not build the Imports slice while parsing, the import descriptors
+	// are only checked for anomalies and the imported functions are read on
+	// demand with IterImports, by default (false). Methods such as ImpHash
+	// read the imports on each call in this mode, and IAT slots are not
+	// checked for orphans.
+	LazyImports bool
+
+	// Record the byte ranges read by each parser, see File.Coverage, by
+	// default (false).
+	Coverage bool
+
+	// Record the time spent and the bytes read by each parser, see
+	// File.Stats, by default (false).
+	CollectStats bool
+
 	// Disable certificate validation, by default (false).
 	DisableCertValidation bool
 
 	// Disable signature validation, by default (false).
 	DisableSignatureValidation bool
 
+	// Do not build the certificate chain of trust, the signer info is still
+	// parsed and its signature verified, by default (false).
+	DisableCertChainValidation bool
+
+	// Check the revocation status of the certificates of the chain via OCSP,
+	// falling back to CRL, by default (false). This requires network access.
+	CertRevocationCheck bool
+
+	// HTTP client used to query OCSP responders and download CRLs, by
+	// default (http.DefaultClient).
+	HTTPClient *http.Client
+
+	// Time at which the validity of the certificates is evaluated, by default
+	// the signing time when present, or the current time otherwise. This is
+	// useful to analyze old samples whose certificates have since expired.
+	CertValidationTime time.Time
+
 	// A custom logger.
 	Logger log.Logger
 
@@ -115,8 +246,47 @@ type Options struct {
 
 	// OmitCLRMetadata determines if CLR metadata parsing is skipped, by default (false).
 	OmitCLRMetadata bool
+
+	// RetainRaw selects the raw blobs kept once parsing is done, by default
+	// (RetainAllRaw). The parsed fields are kept regardless.
+	RetainRaw RawRetention
 }
 
+// RawRetention is a set of flags selecting the raw blobs retained by a File
+// once parsing is done. Services keeping many parsed files in memory can drop
+// them, these blobs reference the content of the file and keep it alive.
+type RawRetention uint32
+
+const (
+	// RetainDOSStubRaw keeps DOSStub.Raw.
+	RetainDOSStubRaw RawRetention = 1 << iota
+
+	// RetainRichHeaderRaw keeps RichHeader.Raw, which RichHeaderHash needs.
+	RetainRichHeaderRaw
+
+	// RetainCertificatesRaw keeps Certificates.Raw, the PKCS#7 signed data.
+	RetainCertificatesRaw
+
+	// RetainCLRMetadataStreams keeps CLR.MetadataStreams, which the methods
+	// resolving .NET names such as Types and PInvokeImports need.
+	RetainCLRMetadataStreams
+
+	// RetainReservedDirectoriesRaw keeps Architecture.Raw and Reserved.Raw.
+	RetainReservedDirectoriesRaw
+
+	// RetainCLRHeaderRaw keeps CLR.ExportAddressTableJumps and
+	// CLR.ManagedNativeHeader.
+	RetainCLRHeaderRaw
+
+	// RetainAllRaw keeps every raw blob.
+	RetainAllRaw = RetainDOSStubRaw | RetainRichHeaderRaw |
+		RetainCertificatesRaw | RetainCLRMetadataStreams |
+		RetainReservedDirectoriesRaw | RetainCLRHeaderRaw
+
+	// RetainNoRaw drops every raw blob.
+	RetainNoRaw RawRetention = 1 << 31
+)
+
 // New instantiates a file instance with options given a file name.
 func New(name string, opts *Options) (*File, error) {
 	f, err := os.Open(name)
@@ -128,12 +298,22 @@ func New(name string, opts *Options) (*File, error) {
 }
 
 // NewFile instantiates a file instance with options given a file handle.
+// The file is memory mapped when the platform and the file type allow it,
+// otherwise its content is read into memory. The File takes ownership of f,
+// which is closed by Close, or right away if an error is returned.
 func NewFile(f *os.File, opts *Options) (*File, error) {
 	// Memory map the file instead of using read/write.
+	mapped := true
 	data, err := mmap.Map(f, mmap.RDONLY, 0)
 	if err != nil {
-		f.Close()
-		return nil, err
+		// Empty files, pipes and some special files can't be mapped,
+		// fall back to reading the whole content.
+		mapped = false
+		data, err = ioutil.ReadAll(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
 	}
 
 	file := File{}
@@ -149,6 +329,22 @@ func NewFile(f *os.File, opts *Options) (*File, error) {
 	if file.opts.MaxRelocEntriesCount == 0 {
 		file.opts.MaxRelocEntriesCount = MaxDefaultRelocEntriesCount
 	}
+	if file.opts.MaxExportEntries == 0 {
+		file.opts.MaxExportEntries = MaxDefaultExportEntriesCount
+	}
+	if file.opts.MaxResourceDepth == 0 {
+		file.opts.MaxResourceDepth = MaxDefaultResourceDepth
+	}
+	if file.opts.MaxCertificateSize == 0 {
+		file.opts.MaxCertificateSize = MaxDefaultCertificateSize
+	}
+	if file.opts.MaxASN1Depth == 0 {
+		file.opts.MaxASN1Depth = MaxDefaultASN1Depth
+	}
+
+	if file.opts.RetainRaw == 0 {
+		file.opts.RetainRaw = RetainAllRaw
+	}
 
 	var logger log.Logger
 	if file.opts.Logger == nil {
@@ -160,12 +356,14 @@ func NewFile(f *os.File, opts *Options) (*File, error) {
 	}
 
 	file.data = data
-	file.size = uint32(len(file.data))
+	file.size = uint64(len(file.data))
+	file.mapped = mapped
 	file.f = f
 	return &file, nil
 }
 
 // NewBytes instantiates a file instance with options given a memory buffer.
+// The buffer is not copied and must not be modified while the File is used.
 func NewBytes(data []byte, opts *Options) (*File, error) {
 
 	file := File{}
@@ -181,6 +379,22 @@ func NewBytes(data []byte, opts *Options) (*File, error) {
 	if file.opts.MaxRelocEntriesCount == 0 {
 		file.opts.MaxRelocEntriesCount = MaxDefaultRelocEntriesCount
 	}
+	if file.opts.MaxExportEntries == 0 {
+		file.opts.MaxExportEntries = MaxDefaultExportEntriesCount
+	}
+	if file.opts.MaxResourceDepth == 0 {
+		file.opts.MaxResourceDepth = MaxDefaultResourceDepth
+	}
+	if file.opts.MaxCertificateSize == 0 {
+		file.opts.MaxCertificateSize = MaxDefaultCertificateSize
+	}
+	if file.opts.MaxASN1Depth == 0 {
+		file.opts.MaxASN1Depth = MaxDefaultASN1Depth
+	}
+
+	if file.opts.RetainRaw == 0 {
+		file.opts.RetainRaw = RetainAllRaw
+	}
 
 	var logger log.Logger
 	if file.opts.Logger == nil {
@@ -192,14 +406,57 @@ func NewBytes(data []byte, opts *Options) (*File, error) {
 	}
 
 	file.data = data
-	file.size = uint32(len(file.data))
+	file.size = uint64(len(file.data))
 	return &file, nil
 }
 
-// Close closes the File.
+// NewReader instantiates a file instance with options given a reader, such as
+// an entry streamed from a zip or cab archive, without extracting it to disk.
+// size is the size of the content when known, for instance the uncompressed
+// size of an archive entry, or -1. The whole content is read into memory
+// before NewReader returns: when size is known, a single buffer of that size
+// is allocated and exactly size bytes must be read, otherwise the buffer grows
+// while reading. Content larger than 4GB is rejected with ErrFileTooLarge.
+// The reader is not closed.
+func NewReader(r io.Reader, size int64, opts *Options) (*File, error) {
+	if size > math.MaxUint32 {
+		return nil, ErrFileTooLarge
+	}
+
+	var data []byte
+	var err error
+	if size >= 0 {
+		data = make([]byte, size)
+		_, err = io.ReadFull(r, data)
+	} else {
+		data, err = ioutil.ReadAll(io.LimitReader(r, math.MaxUint32+1))
+		if err == nil && int64(len(data)) > math.MaxUint32 {
+			err = ErrFileTooLarge
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBytes(data, opts)
+}
+
+// NewReaderAt instantiates a file instance with options given the first size
+// bytes of a reader at, see NewReader for the memory behavior. To parse a
+// buffer already in memory without copying it, use NewBytes.
+func NewReaderAt(r io.ReaderAt, size int64, opts *Options) (*File, error) {
+	if size < 0 {
+		return nil, ErrOutsideBoundary
+	}
+	return NewReader(io.NewSectionReader(r, 0, size), size, opts)
+}
+
+// Close closes the File. When the File was memory mapped, the mapping is
+// released and the data returned by previous calls must not be used anymore.
 func (pe *File) Close() error {
-	if pe.data != nil {
+	if pe.mapped {
 		_ = pe.data.Unmap()
+		pe.mapped = false
 	}
 
 	if pe.f != nil {
@@ -211,48 +468,138 @@ func (pe *File) Close() error {
 // Parse performs the file parsing for a PE binary.
 func (pe *File) Parse() error {
 
-	// check for the smallest PE size.
+	// check for the smallest PE size. Files starting with the DOS magic are
+	// reported as truncated.
 	if len(pe.data) < TinyPESize {
+		if pe.hasDOSMagic() {
+			return &TruncatedFileError{
+				Structure: "PE file",
+				Expected:  TinyPESize,
+				Available: pe.size,
+				Err:       ErrInvalidPESize,
+			}
+		}
 		return ErrInvalidPESize
 	}
 
+	// Reads are only attributed to parsers while parsing.
+	defer pe.startCoverage("")
+
+	// Warnings are only recorded while parsing.
+	pe.parsing = true
+	defer func() { pe.parsing = false }()
+
+	// Drop the raw blobs the options do not retain.
+	defer pe.releaseRaw()
+
 	// Parse the DOS header.
+	pe.startCoverage("DOSHeader")
 	err := pe.ParseDOSHeader()
 	if err != nil {
 		return err
 	}
 
 	// Parse the Rich header.
+	pe.startCoverage("RichHeader")
 	err = pe.ParseRichHeader()
 	if err != nil {
-		pe.logger.Errorf("rich header parsing failed: %v", err)
+		pe.errorf("RichHeader", 0, "rich header parsing failed: %v", err)
+	}
+
+	// Parse the DOS stub.
+	pe.startCoverage("DOSStub")
+	err = pe.ParseDOSStub()
+	if err != nil {
+		pe.errorf("DOSStub", 0, "dos stub parsing failed: %v", err)
 	}
 
 	// Parse the NT header.
+	pe.startCoverage("NTHeader")
 	err = pe.ParseNTHeader()
 	if err != nil {
+		if pe.opts.AllowInvalidNTHeader && isNTSignatureError(err) {
+			pe.parseWithoutNTHeader(err)
+			return pe.failOnAnomaly()
+		}
 		return err
 	}
 
 	// Parse COFF symbol table.
+	pe.startCoverage("COFF")
 	err = pe.ParseCOFFSymbolTable()
 	if err != nil {
 		pe.logger.Debugf("coff symbols parsing failed: %v", err)
 	}
 
 	// Parse the Section Header.
+	pe.startCoverage("SectionHeader")
 	err = pe.ParseSectionHeader()
 	if err != nil {
 		return err
 	}
 
+	// Invoke the custom section handlers.
+	pe.runSectionHandlers()
+
 	// In fast mode, do not parse data directories.
-	if pe.opts.Fast {
-		return nil
+	if !pe.opts.Fast {
+		// Parse the Data Directory entries.
+		err = pe.ParseDataDirectories()
+		if err != nil {
+			return err
+		}
+	}
+
+	return pe.failOnAnomaly()
+}
+
+// isNTSignatureError tells whether err is returned by ParseNTHeader because
+// the NT header signature is not the one of a PE.
+func isNTSignatureError(err error) bool {
+	switch err {
+	case ErrImageNtSignatureNotFound, ErrImageOS2SignatureFound,
+		ErrImageOS2LESignatureFound, ErrImageVXDSignatureFound,
+		ErrImageTESignatureFound:
+		return true
 	}
+	return false
+}
 
-	// Parse the Data Directory entries.
-	return pe.ParseDataDirectories()
+// parseWithoutNTHeader implements Options.AllowInvalidNTHeader, the bytes
+// from the NT header offset on, which could not be parsed, make the overlay.
+func (pe *File) parseWithoutNTHeader(err error) {
+	offset := pe.DOSHeader.AddressOfNewEXEHeader
+	pe.warnf("NTHeader", offset, "nt header parsing failed: %v", err)
+
+	pe.OverlayOffset = int64(offset)
+	if pe.OverlayOffset < int64(pe.size) {
+		pe.HasOverlay = true
+	}
+}
+
+// releaseRaw drops the raw blobs not selected by the RetainRaw option.
+func (pe *File) releaseRaw() {
+	retain := pe.opts.RetainRaw
+	if retain&RetainDOSStubRaw == 0 {
+		pe.DOSStub.Raw = nil
+	}
+	if retain&RetainRichHeaderRaw == 0 {
+		pe.RichHeader.Raw = nil
+	}
+	if retain&RetainCertificatesRaw == 0 {
+		pe.Certificates.Raw = nil
+	}
+	if retain&RetainCLRMetadataStreams == 0 {
+		pe.CLR.MetadataStreams = nil
+	}
+	if retain&RetainReservedDirectoriesRaw == 0 {
+		pe.Architecture.Raw = nil
+		pe.Reserved.Raw = nil
+	}
+	if retain&RetainCLRHeaderRaw == 0 {
+		pe.CLR.ExportAddressTableJumps = nil
+		pe.CLR.ManagedNativeHeader = nil
+	}
 }
 
 // String stringify the data directory entry.
@@ -279,6 +626,110 @@ func (entry ImageDirectoryEntry) String() string {
 	return dataDirMap[entry]
 }
 
+// DataDirectoryStatus tells what became of a data directory during parsing.
+type DataDirectoryStatus int
+
+const (
+	// DataDirectoryAbsent indicates that the directory address is zero.
+	DataDirectoryAbsent DataDirectoryStatus = iota
+
+	// DataDirectoryParsed indicates that the directory was parsed.
+	DataDirectoryParsed
+
+	// DataDirectorySkipped indicates that the directory was not parsed, either
+	// because of the Fast or Omit*Directory options, or because the library
+	// does not parse it.
+	DataDirectorySkipped
+
+	// DataDirectoryFailed indicates that the directory lies outside the image
+	// or that parsing it failed.
+	DataDirectoryFailed
+)
+
+// String stringify the data directory status.
+func (status DataDirectoryStatus) String() string {
+	dataDirStatusMap := map[DataDirectoryStatus]string{
+		DataDirectoryAbsent:  "Absent",
+		DataDirectoryParsed:  "Parsed",
+		DataDirectorySkipped: "Skipped",
+		DataDirectoryFailed:  "Failed",
+	}
+
+	return dataDirStatusMap[status]
+}
+
+// DataDirectoryInfo describes a data directory entry and how its parsing went.
+type DataDirectoryInfo struct {
+	Entry          ImageDirectoryEntry `json:"entry"`
+	Name           string              `json:"name"`
+	VirtualAddress uint32              `json:"virtual_address"`
+	Size           uint32              `json:"size"`
+	Status         DataDirectoryStatus `json:"status"`
+
+	// The reason the parsing failed, when the status is DataDirectoryFailed.
+	Err error `json:"-"`
+}
+
+// Directories returns, for each of the 16 data directories, its address, its
+// size and whether it was parsed, skipped, failed or is absent. It tells for
+// instance why HasCLR is false without having to go through the logs.
+func (pe *File) Directories() []DataDirectoryInfo {
+	var dataDirs [16]DataDirectory
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			dataDirs = oh64.DataDirectory
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			dataDirs = oh32.DataDirectory
+		}
+	}
+
+	dirs := make([]DataDirectoryInfo, 0, ImageNumberOfDirectoryEntries)
+	for entry := ImageDirectoryEntry(0); entry < ImageNumberOfDirectoryEntries; entry++ {
+		dir := DataDirectoryInfo{
+			Entry:          entry,
+			Name:           entry.String(),
+			VirtualAddress: dataDirs[entry].VirtualAddress,
+			Size:           dataDirs[entry].Size,
+			Status:         pe.dirStatus[entry],
+			Err:            pe.dirErr[entry],
+		}
+
+		// Directories not visited by ParseDataDirectories were skipped.
+		if dir.VirtualAddress == 0 {
+			dir.Status = DataDirectoryAbsent
+		} else if dir.Status == DataDirectoryAbsent {
+			dir.Status = DataDirectorySkipped
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// dataDirectory returns a data directory entry of the optional header.
+func (pe *File) dataDirectory(entry ImageDirectoryEntry) DataDirectory {
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			return oh64.DataDirectory[entry]
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			return oh32.DataDirectory[entry]
+		}
+	}
+	return DataDirectory{}
+}
+
+// setDirectoryStatus records the outcome of the parsing of a data directory.
+func (pe *File) setDirectoryStatus(entry ImageDirectoryEntry,
+	status DataDirectoryStatus, err error) {
+	pe.dirStatus[entry] = status
+	pe.dirErr[entry] = err
+}
+
 // ParseDataDirectories parses the data directories. The DataDirectory is an
 // array of 16 structures. Each array entry has a predefined meaning for what
 // it refers to.
@@ -290,9 +741,13 @@ func (pe *File) ParseDataDirectories() error {
 
 	switch pe.Is64 {
 	case true:
-		oh64 = pe.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+		if v, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			oh64 = v
+		}
 	case false:
-		oh32 = pe.NtHeader.OptionalHeader.(ImageOptionalHeader32)
+		if v, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			oh32 = v
+		}
 	}
 
 	// Maps data directory index to function which parses that directory.
@@ -342,6 +797,7 @@ func (pe *File) ParseDataDirectories() error {
 	if !pe.opts.OmitCLRHeaderDirectory {
 		funcMaps[ImageDirectoryEntryCLR] = pe.parseCLRHeaderDirectory
 	}
+	funcMaps[ImageDirectoryEntryReserved] = pe.parseReservedDirectory
 
 	// Iterate over data directories and call the appropriate function.
 	for entryIndex := ImageDirectoryEntry(0); entryIndex < ImageNumberOfDirectoryEntries; entryIndex++ {
@@ -358,38 +814,209 @@ func (pe *File) ParseDataDirectories() error {
 			size = dirEntry.Size
 		}
 
+		// the architecture and the last entries in the data directories are
+		// reserved and must be zero.
+		err := pe.checkReservedDataDirectory(entryIndex, va, size)
+		if err != nil {
+			return err
+		}
+
 		if va != 0 {
-			func() {
+			err := func() (err error) {
 				// keep parsing data directories even though some entries fails.
 				defer func() {
 					if e := recover(); e != nil {
-						pe.logger.Errorf("unhandled exception when parsing data directory %s, reason: %v",
+						pe.errorf(entryIndex.String(), pe.GetOffsetFromRva(va),
+							"unhandled exception when parsing data directory %s, reason: %v",
 							entryIndex.String(), e)
 						foundErr = true
+						pe.setDirectoryStatus(entryIndex, DataDirectoryFailed,
+							fmt.Errorf("%v", e))
+						if pe.opts.Strict {
+							err = &SpecViolationError{
+								Structure: entryIndex.String(),
+								Violation: "data directory parsing failed",
+								Err:       fmt.Errorf("%v", e),
+							}
+						}
 					}
 				}()
 
-				// the last entry in the data directories is reserved and must be zero.
-				if entryIndex == ImageDirectoryEntryReserved {
-					pe.Anomalies = append(pe.Anomalies, AnoReservedDataDirectoryEntry)
-					return
-				}
-
 				parseDirectory, ok := funcMaps[entryIndex]
-				if !ok {
-					return
+				if !ok && len(pe.directoryHandlers[entryIndex]) == 0 {
+					return nil
 				}
-				err := parseDirectory(va, size)
+
+				// skip directories which lie outside the image.
+				valid, err := pe.ValidateDataDirectory(entryIndex, va, size)
 				if err != nil {
-					pe.logger.Warnf("failed to parse data directory %s, reason: %v",
-						entryIndex.String(), err)
+					pe.setDirectoryStatus(entryIndex, DataDirectoryFailed, err)
+					return err
+				}
+				if !valid {
+					pe.setDirectoryStatus(entryIndex, DataDirectoryFailed,
+						ErrOutsideBoundary)
+					pe.warnf(entryIndex.String(), 0,
+						"skipping data directory %s, it lies outside the image boundary",
+						entryIndex.String())
+					return nil
+				}
+
+				pe.startCoverage(entryIndex.String())
+				if ok {
+					err := parseDirectory(va, size)
+					if err == nil {
+						pe.setDirectoryStatus(entryIndex, DataDirectoryParsed, nil)
+					} else {
+						pe.setDirectoryStatus(entryIndex, DataDirectoryFailed, err)
+						pe.warnf(entryIndex.String(), pe.GetOffsetFromRva(va),
+							"failed to parse data directory %s, reason: %v",
+							entryIndex.String(), err)
+						if pe.opts.Strict {
+							return &SpecViolationError{
+								Structure: entryIndex.String(),
+								Violation: "data directory parsing failed",
+								Err:       err,
+							}
+						}
+					}
 				}
+
+				// invoke the custom handlers registered for this directory.
+				pe.runDirectoryHandlers(entryIndex, va, size)
+				return nil
 			}()
+			if err != nil {
+				return err
+			}
 		}
 	}
 
+	pe.checkDataDirectoryOverlaps()
+
+	// Correlate the IAT slots with the imported functions.
+	if pe.HasIAT {
+		pe.resolveIATEntries()
+	}
+
 	if foundErr {
 		return errors.New("Data directory parsing failed")
 	}
 	return nil
 }
+
+// checkDataDirectoryOverlaps reports the pairs of data directories sharing
+// some bytes. The certificate table is located by a file offset, the other
+// directories are compared against it by their file offset. The import
+// directory is not checked against the IAT as GNU ld sizes it to cover the
+// whole .idata section.
+func (pe *File) checkDataDirectoryOverlaps() {
+
+	type dirRange struct {
+		entry      ImageDirectoryEntry
+		start, end uint64
+	}
+
+	var mapped []dirRange
+	var cert *dirRange
+	for _, dir := range pe.Directories() {
+		if dir.VirtualAddress == 0 || dir.Size == 0 {
+			continue
+		}
+		r := dirRange{
+			entry: dir.Entry,
+			start: uint64(dir.VirtualAddress),
+			end:   uint64(dir.VirtualAddress) + uint64(dir.Size),
+		}
+		if dir.Entry == ImageDirectoryEntryCertificate {
+			cert = &r
+			continue
+		}
+		mapped = append(mapped, r)
+	}
+
+	for i, a := range mapped {
+		for _, b := range mapped[i+1:] {
+			if a.entry == ImageDirectoryEntryImport &&
+				b.entry == ImageDirectoryEntryIAT {
+				continue
+			}
+			if a.start < b.end && b.start < a.end {
+				pe.addAnomaly(fmt.Sprintf(AnoDataDirectoryOverlap,
+					a.entry.String(), b.entry.String()))
+			}
+		}
+		if cert == nil {
+			continue
+		}
+		offset := pe.GetOffsetFromRva(uint32(a.start))
+		if offset == ^uint32(0) {
+			continue
+		}
+		start := uint64(offset)
+		end := start + a.end - a.start
+		if start < cert.end && cert.start < end {
+			pe.addAnomaly(fmt.Sprintf(AnoDataDirectoryOverlap,
+				a.entry.String(), cert.entry.String()))
+		}
+	}
+}
+
+// ValidateDataDirectory cross-checks a data directory entry against the image
+// layout. Parse runs it before each directory is parsed. Directories starting
+// beyond SizeOfImage (or beyond the end of the file for the certificate table,
+// whose address is a file offset) are reported as anomalies and should be
+// skipped. Directories which only overflow the image or do not fall within any
+// section are still parsed, as the loader tolerates them, but are reported as
+// anomalies. In strict mode, directories outside or overflowing the image
+// abort parsing.
+func (pe *File) ValidateDataDirectory(entry ImageDirectoryEntry, va, size uint32) (bool, error) {
+
+	sizeOfImage, sizeOfHeaders := pe.sizeOfImage(), pe.sizeOfHeaders()
+	end := uint64(va) + uint64(size)
+
+	// The certificate table is not mapped into memory.
+	if entry == ImageDirectoryEntryCertificate {
+		if uint64(va) >= pe.size {
+			err := pe.violation(entry.String(),
+				fmt.Sprintf(AnoDataDirectoryOutsideFile, entry.String()),
+				pe.checkTruncated(entry.String(), va, size, ErrOutsideBoundary))
+			return false, err
+		}
+		if end > pe.size {
+			err := pe.violation(entry.String(),
+				fmt.Sprintf(AnoDataDirectoryOverflowFile, entry.String()),
+				pe.checkTruncated(entry.String(), va, size, ErrOutsideBoundary))
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	if sizeOfImage != 0 {
+		if va >= sizeOfImage {
+			err := pe.violation(entry.String(),
+				fmt.Sprintf(AnoDataDirectoryOutsideImage, entry.String()), nil)
+			return false, err
+		}
+		if end > uint64(sizeOfImage) {
+			err := pe.violation(entry.String(),
+				fmt.Sprintf(AnoDataDirectoryOverflowImage, entry.String()), nil)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if va >= sizeOfHeaders && len(pe.Sections) > 0 && pe.getSectionByRva(va) == nil {
+		pe.addAnomaly(fmt.Sprintf(AnoDataDirectoryOutsideSections, entry.String()))
+	}
+
+	if va < sizeOfHeaders && len(pe.Sections) > 0 &&
+		entry != ImageDirectoryEntryBoundImport {
+		pe.addAnomaly(fmt.Sprintf(AnoDataDirectoryInHeaders, entry.String()))
+	}
+
+	return true, nil
+}
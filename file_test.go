@@ -5,7 +5,20 @@
 package pe
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
 	"testing"
 )
 
@@ -69,6 +82,208 @@ func TestNewBytes(t *testing.T) {
 	}
 }
 
+func TestNewFile(t *testing.T) {
+	for _, tt := range peTests {
+		t.Run(tt.in, func(t *testing.T) {
+			f, err := os.Open(tt.in)
+			if err != nil {
+				t.Fatalf("Open(%s) failed, reason: %v", tt.in, err)
+			}
+			file, err := NewFile(f, &Options{})
+			if err != nil {
+				t.Fatalf("NewFile(%s) failed, reason: %v", tt.in, err)
+			}
+			if !file.mapped {
+				t.Errorf("NewFile(%s) expected the file to be memory mapped", tt.in)
+			}
+
+			got := file.Parse()
+			if got != tt.out {
+				t.Errorf("Parse(%s) got %v, want %v", tt.in, got, tt.out)
+			}
+
+			err = file.Close()
+			if err != nil {
+				t.Errorf("Close(%s) failed, reason: %v", tt.in, err)
+			}
+			if file.mapped {
+				t.Errorf("Close(%s) expected the file to be unmapped", tt.in)
+			}
+		})
+	}
+}
+
+func TestNewFileFallback(t *testing.T) {
+	for _, tt := range peTests {
+		t.Run(tt.in, func(t *testing.T) {
+			data, err := ioutil.ReadFile(tt.in)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", tt.in, err)
+			}
+
+			// Pipes can't be memory mapped.
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatalf("Pipe() failed, reason: %v", err)
+			}
+			go func() {
+				w.Write(data)
+				w.Close()
+			}()
+
+			file, err := NewFile(r, &Options{})
+			if err != nil {
+				t.Fatalf("NewFile(%s) failed, reason: %v", tt.in, err)
+			}
+			defer file.Close()
+			if file.mapped {
+				t.Errorf("NewFile(%s) expected the file to be read", tt.in)
+			}
+			if !bytes.Equal(file.data, data) {
+				t.Fatalf("NewFile(%s) data mismatch", tt.in)
+			}
+
+			got := file.Parse()
+			if got != tt.out {
+				t.Errorf("Parse(%s) got %v, want %v", tt.in, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	for _, tt := range peTests {
+		t.Run(tt.in, func(t *testing.T) {
+			data, err := ioutil.ReadFile(tt.in)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", tt.in, err)
+			}
+
+			// Stream the file from a zip archive, as mail scanners do.
+			buf := &bytes.Buffer{}
+			zw := zip.NewWriter(buf)
+			fw, _ := zw.Create("sample.exe")
+			fw.Write(data)
+			zw.Close()
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("zip.NewReader(%s) failed, reason: %v", tt.in, err)
+			}
+			entry := zr.File[0]
+
+			for _, size := range []int64{int64(entry.UncompressedSize64), -1} {
+				rc, err := entry.Open()
+				if err != nil {
+					t.Fatalf("Open(%s) failed, reason: %v", tt.in, err)
+				}
+				file, err := NewReader(rc, size, &Options{})
+				rc.Close()
+				if err != nil {
+					t.Fatalf("NewReader(%s, %d) failed, reason: %v", tt.in, size, err)
+				}
+				if !bytes.Equal(file.data, data) {
+					t.Fatalf("NewReader(%s, %d) data mismatch", tt.in, size)
+				}
+				got := file.Parse()
+				if got != tt.out {
+					t.Errorf("Parse(%s) got %v, want %v", tt.in, got, tt.out)
+				}
+			}
+
+			file, err := NewReaderAt(bytes.NewReader(data), int64(len(data)), &Options{})
+			if err != nil {
+				t.Fatalf("NewReaderAt(%s) failed, reason: %v", tt.in, err)
+			}
+			got := file.Parse()
+			if got != tt.out {
+				t.Errorf("Parse(%s) got %v, want %v", tt.in, got, tt.out)
+			}
+
+			// The declared size must be available.
+			_, err = NewReader(bytes.NewReader(data), int64(len(data))+1, &Options{})
+			if err != io.ErrUnexpectedEOF {
+				t.Errorf("NewReader(%s) of a short reader got %v, want %v",
+					tt.in, err, io.ErrUnexpectedEOF)
+			}
+			_, err = NewReaderAt(bytes.NewReader(data), math.MaxUint32+1, &Options{})
+			if err != ErrFileTooLarge {
+				t.Errorf("NewReaderAt(%s) of a large size got %v, want %v",
+					tt.in, err, ErrFileTooLarge)
+			}
+		})
+	}
+}
+
+func TestLargeFile(t *testing.T) {
+	if testing.Short() || math.MaxInt32 == int(^uint(0)>>1) {
+		t.Skip("skipping the 4GB sparse file test")
+	}
+
+	in := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", in, err)
+	}
+
+	// Padded installers append gigabytes to the image, the file is sparse so
+	// that the test does not use any disk space.
+	f, err := ioutil.TempFile("", "pe-large-*.exe")
+	if err != nil {
+		t.Fatalf("TempFile() failed, reason: %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Truncate(math.MaxUint32 + 0x1001)
+	}
+	f.Close()
+	if err != nil {
+		t.Skipf("creating a sparse file failed, reason: %v", err)
+	}
+
+	// The Authentihash covers the whole file, the security directory is
+	// omitted to keep the test fast.
+	file, err := New(f.Name(), &Options{OmitSecurityDirectory: true})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", f.Name(), err)
+	}
+	defer file.Close()
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", f.Name(), err)
+	}
+
+	if file.size != math.MaxUint32+0x1001 {
+		t.Errorf("size assertion failed, got 0x%x, want 0x%x", file.size,
+			uint64(math.MaxUint32+0x1001))
+	}
+	if len(file.Sections) == 0 || len(file.Imports) == 0 {
+		t.Errorf("sections and imports of a large file not parsed")
+	}
+
+	wantOverlay := int64(math.MaxUint32+0x1001) - file.OverlayOffset
+	if !file.HasOverlay || file.OverlayLength() != wantOverlay {
+		t.Errorf("overlay length assertion failed, got 0x%x, want 0x%x",
+			file.OverlayLength(), wantOverlay)
+	}
+
+	// Reads near the end of the first 4GB do not wrap around.
+	_, err = file.ReadUint32(math.MaxUint32 - 2)
+	if err != nil {
+		t.Errorf("ReadUint32() at the end of the first 4GB failed, reason: %v", err)
+	}
+	b, err := file.ReadBytesAtOffset(math.MaxUint32-0x10, 0x20)
+	if err != nil || len(b) != 0x20 {
+		t.Errorf("ReadBytesAtOffset() across the first 4GB got (%d bytes, %v), want 0x20 bytes",
+			len(b), err)
+	}
+	_, err = file.ReadBytesAtOffset(math.MaxUint32-0x10, 0x2000)
+	if err != ErrOutsideBoundary {
+		t.Errorf("ReadBytesAtOffset() past the end got %v, want %v",
+			err, ErrOutsideBoundary)
+	}
+}
+
 func TestChecksum(t *testing.T) {
 
 	tests := []struct {
@@ -102,3 +317,680 @@ func TestChecksum(t *testing.T) {
 		})
 	}
 }
+
+func TestConcurrentReadAccess(t *testing.T) {
+	for _, tt := range peTests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			defer file.Close()
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			anomaliesCount := len(file.Anomalies)
+			wantChecksum := file.Checksum()
+			wantImpHash, _ := file.ImpHash()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for _, section := range file.Sections {
+						rva := section.Header.VirtualAddress
+						_ = file.GetOffsetFromRva(rva)
+						_, _ = file.GetData(rva, 0x10)
+						_ = file.GetRVAFromOffset(section.Header.PointerToRawData)
+					}
+					if got := file.Checksum(); got != wantChecksum {
+						t.Errorf("Checksum(%s) got %v, want %v", tt.in, got, wantChecksum)
+					}
+					if got, _ := file.ImpHash(); got != wantImpHash {
+						t.Errorf("ImpHash(%s) got %v, want %v", tt.in, got, wantImpHash)
+					}
+					_ = file.Authentihash()
+					_ = file.RichHeaderHash()
+					_, _ = file.Overlay()
+					_, _ = file.ParseVersionResources()
+				}()
+			}
+			wg.Wait()
+
+			if len(file.Anomalies) != anomaliesCount {
+				t.Errorf("concurrent read access mutated anomalies, got %d, want %d",
+					len(file.Anomalies), anomaliesCount)
+			}
+		})
+	}
+}
+
+func TestDataDirectoryBoundaryValidation(t *testing.T) {
+
+	tests := []struct {
+		in      string
+		entry   ImageDirectoryEntry
+		rva     uint32
+		size    uint32
+		anomaly string
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), ImageDirectoryEntryDebug,
+			0x7ffff000, 0x1c, fmt.Sprintf(AnoDataDirectoryOutsideImage, "Debug")},
+		{getAbsoluteFilePath("test/putty.exe"), ImageDirectoryEntryCertificate,
+			0x7ffff000, 0x100, fmt.Sprintf(AnoDataDirectoryOutsideFile, "Security")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			data, err := ioutil.ReadFile(tt.in)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", tt.in, err)
+			}
+
+			// putty.exe is a PE32+, data directories follow the 112 bytes of
+			// the optional header fixed fields.
+			ntHeaderOffset := binary.LittleEndian.Uint32(data[0x3c:])
+			dirOffset := ntHeaderOffset + 4 + 20 + 112 + uint32(tt.entry)*8
+			binary.LittleEndian.PutUint32(data[dirOffset:], tt.rva)
+			binary.LittleEndian.PutUint32(data[dirOffset+4:], tt.size)
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			if !stringInSlice(tt.anomaly, file.Anomalies) {
+				t.Errorf("anomaly %q not found in %v", tt.anomaly, file.Anomalies)
+			}
+
+			valid, err := file.ValidateDataDirectory(tt.entry, tt.rva, tt.size)
+			if valid || err != nil {
+				t.Errorf("ValidateDataDirectory(%s) got (%v, %v), want (false, nil)",
+					tt.entry.String(), valid, err)
+			}
+		})
+	}
+}
+
+func TestDataDirectoryHashTricks(t *testing.T) {
+
+	// putty.exe is a PE32+, data directories follow the 112 bytes of the
+	// optional header fixed fields.
+	setDirectory := func(entry ImageDirectoryEntry, rva, size uint32) func([]byte) {
+		return func(data []byte) {
+			ntHeaderOffset := binary.LittleEndian.Uint32(data[0x3c:])
+			dirOffset := ntHeaderOffset + 4 + 20 + 112 + uint32(entry)*8
+			binary.LittleEndian.PutUint32(data[dirOffset:], rva)
+			binary.LittleEndian.PutUint32(data[dirOffset+4:], size)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		patch   func([]byte)
+		anomaly string
+	}{
+		{
+			name:  "debug inside load config",
+			patch: setDirectory(ImageDirectoryEntryDebug, 0xa9e40, 0x1c),
+			anomaly: fmt.Sprintf(AnoDataDirectoryOverlap, "Debug",
+				"LoadConfig"),
+		},
+		{
+			// The certificate table is located by its file offset.
+			name:  "load config inside certificate table",
+			patch: setDirectory(ImageDirectoryEntryCertificate, 0xa8c40, 0x200),
+			anomaly: fmt.Sprintf(AnoDataDirectoryOverlap, "LoadConfig",
+				"Security"),
+		},
+		{
+			name:    "export inside headers",
+			patch:   setDirectory(ImageDirectoryEntryExport, 0x100, 0x28),
+			anomaly: fmt.Sprintf(AnoDataDirectoryInHeaders, "Export"),
+		},
+		{
+			// Rename the third section header, .data, to .rdata.
+			name:  "duplicated section name",
+			patch: func(data []byte) { copy(data[0x1d0:], ".rdata\x00\x00") },
+			anomaly: fmt.Sprintf(AnoSectionNameDuplicated, ".rdata",
+				2, 1),
+		},
+	}
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+			tt.patch(data)
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			if !stringInSlice(tt.anomaly, file.Anomalies) {
+				t.Errorf("anomaly %q not found in %v", tt.anomaly, file.Anomalies)
+			}
+			if id := LookupAnomaly(tt.anomaly); id == AnomalyUnknown {
+				t.Errorf("anomaly %q has no identifier", tt.anomaly)
+			}
+		})
+	}
+}
+
+func TestDirectories(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	tests := []struct {
+		opts   Options
+		entry  ImageDirectoryEntry
+		rva    uint32
+		status DataDirectoryStatus
+		err    error
+	}{
+		{Options{}, ImageDirectoryEntryImport, 0, DataDirectoryParsed, nil},
+		{Options{}, ImageDirectoryEntryCLR, 0, DataDirectoryAbsent, nil},
+		{Options{OmitResourceDirectory: true}, ImageDirectoryEntryResource, 0,
+			DataDirectorySkipped, nil},
+		{Options{Fast: true}, ImageDirectoryEntryImport, 0, DataDirectorySkipped, nil},
+		{Options{}, ImageDirectoryEntryDebug, 0x7ffff000, DataDirectoryFailed,
+			ErrOutsideBoundary},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry.String()+"/"+tt.status.String(), func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+
+			// putty.exe is a PE32+, data directories follow the 112 bytes of
+			// the optional header fixed fields.
+			if tt.rva != 0 {
+				ntHeaderOffset := binary.LittleEndian.Uint32(data[0x3c:])
+				dirOffset := ntHeaderOffset + 4 + 20 + 112 + uint32(tt.entry)*8
+				binary.LittleEndian.PutUint32(data[dirOffset:], tt.rva)
+				binary.LittleEndian.PutUint32(data[dirOffset+4:], 0x1c)
+			}
+
+			file, err := NewBytes(data, &tt.opts)
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			dirs := file.Directories()
+			if len(dirs) != int(ImageNumberOfDirectoryEntries) {
+				t.Fatalf("directories count assertion failed, got %d, want %d",
+					len(dirs), ImageNumberOfDirectoryEntries)
+			}
+			dir := dirs[tt.entry]
+			if dir.Entry != tt.entry || dir.Status != tt.status || dir.Err != tt.err {
+				t.Errorf("directory %s assertion failed, got %v (%v), want %v (%v)",
+					tt.entry, dir.Status, dir.Err, tt.status, tt.err)
+			}
+			if tt.rva != 0 && dir.VirtualAddress != tt.rva {
+				t.Errorf("directory %s address assertion failed, got 0x%x, want 0x%x",
+					tt.entry, dir.VirtualAddress, tt.rva)
+			}
+		})
+	}
+}
+
+func TestRetainRaw(t *testing.T) {
+
+	tests := []struct {
+		in     string
+		retain RawRetention
+	}{
+		{getAbsoluteFilePath("test/kernel32.dll"), 0},
+		{getAbsoluteFilePath("test/kernel32.dll"), RetainNoRaw},
+		{getAbsoluteFilePath("test/kernel32.dll"), RetainRichHeaderRaw},
+		{getAbsoluteFilePath("test/mscorlib.dll"), RetainNoRaw},
+		{getAbsoluteFilePath("test/mscorlib.dll"), RetainCLRMetadataStreams},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", filepath.Base(tt.in), tt.retain), func(t *testing.T) {
+			full, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			defer full.Close()
+			err = full.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			file, err := New(tt.in, &Options{RetainRaw: tt.retain})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			defer file.Close()
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			retain := file.opts.RetainRaw
+			if tt.retain == 0 && retain != RetainAllRaw {
+				t.Errorf("default retention assertion failed, got %d, want %d",
+					retain, RetainAllRaw)
+			}
+			blobs := []struct {
+				name   string
+				flag   RawRetention
+				got    bool
+				wanted bool
+			}{
+				{"DOSStub.Raw", RetainDOSStubRaw,
+					file.DOSStub.Raw != nil, full.DOSStub.Raw != nil},
+				{"RichHeader.Raw", RetainRichHeaderRaw,
+					file.RichHeader.Raw != nil, full.RichHeader.Raw != nil},
+				{"Certificates.Raw", RetainCertificatesRaw,
+					file.Certificates.Raw != nil, full.Certificates.Raw != nil},
+				{"CLR.MetadataStreams", RetainCLRMetadataStreams,
+					file.CLR.MetadataStreams != nil, full.CLR.MetadataStreams != nil},
+			}
+			for _, blob := range blobs {
+				want := blob.wanted && retain&blob.flag != 0
+				if blob.got != want {
+					t.Errorf("%s retention assertion failed, got %v, want %v",
+						blob.name, blob.got, want)
+				}
+			}
+
+			// The parsed fields and the byte ranges do not depend on the raw
+			// blobs.
+			if !reflect.DeepEqual(file.RichHeader.CompIDs, full.RichHeader.CompIDs) {
+				t.Errorf("rich header @comp.id assertion failed")
+			}
+			if file.RichHeaderRange() != full.RichHeaderRange() {
+				t.Errorf("rich header range assertion failed, got %v, want %v",
+					file.RichHeaderRange(), full.RichHeaderRange())
+			}
+			if !reflect.DeepEqual(file.Attributes(), full.Attributes()) {
+				t.Errorf("attributes assertion failed, got %v, want %v",
+					file.Attributes(), full.Attributes())
+			}
+		})
+	}
+}
+
+func TestTruncatedFile(t *testing.T) {
+
+	tests := []struct {
+		size      int
+		structure string
+		expected  uint64
+		err       error
+	}{
+		{50, "PE file", TinyPESize, ErrInvalidPESize},
+		{100, "NT header signature", 0x7c, ErrInvalidElfanewValue},
+		{0x7a, "NT header signature", 0x7c, ErrInvalidNtHeaderOffset},
+		{0x80, "file header", 0x90, ErrOutsideBoundary},
+		{0x91, "optional header magic", 0x92, ErrOutsideBoundary},
+	}
+
+	in := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", in, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d", tt.size), func(t *testing.T) {
+			file, err := NewBytes(data[:tt.size], &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", in, err)
+			}
+
+			err = file.Parse()
+			if !errors.Is(err, ErrTruncatedFile) || !errors.Is(err, tt.err) {
+				t.Fatalf("Parse(%s) error assertion failed, got %v, want %v",
+					in, err, tt.err)
+			}
+			var truncErr *TruncatedFileError
+			if !errors.As(err, &truncErr) {
+				t.Fatalf("Parse(%s) error is not a TruncatedFileError", in)
+			}
+			if truncErr.Structure != tt.structure ||
+				truncErr.Expected != tt.expected ||
+				truncErr.Available != uint64(tt.size) {
+				t.Errorf("truncated file error assertion failed, got %+v, "+
+					"want structure %s expected %d available %d", truncErr,
+					tt.structure, tt.expected, tt.size)
+			}
+		})
+	}
+
+	// Files not starting with a DOS header are not considered as truncated.
+	file, err := NewBytes(make([]byte, 50), &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes failed, reason: %v", err)
+	}
+	err = file.Parse()
+	if err != ErrInvalidPESize {
+		t.Errorf("Parse error assertion failed, got %v, want %v", err,
+			ErrInvalidPESize)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+
+	// putty.exe is a PE32+ whose optional header starts at 0x90.
+	tests := []struct {
+		name      string
+		patch     func(data []byte) []byte
+		structure string
+		violation string
+	}{
+		{"valid", func(data []byte) []byte { return data }, "", ""},
+		{"file alignment", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[0x90+36:], 0x201)
+			return data
+		}, "optional header", ErrInvalidFileAlignment},
+		{"truncated optional header", func(data []byte) []byte {
+			return data[:0x100]
+		}, "optional header", AnoOptionalHeaderBeyondFile},
+		{"export directory", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[0x90+112:], 0x7fff0000)
+			binary.LittleEndian.PutUint32(data[0x90+116:], 0x100)
+			return data
+		}, "Export", fmt.Sprintf(AnoDataDirectoryOutsideImage, "Export")},
+	}
+
+	in := getAbsoluteFilePath("test/putty.exe")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(in)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", in, err)
+			}
+			data = tt.patch(data)
+
+			// The permissive mode reports the violation as an anomaly.
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", in, err)
+			}
+			_ = file.Parse()
+			if tt.violation != "" && !stringInSlice(tt.violation, file.Anomalies) {
+				t.Errorf("anomaly %s not found in %v", tt.violation, file.Anomalies)
+			}
+
+			file, err = NewBytes(data, &Options{Strict: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", in, err)
+			}
+			err = file.Parse()
+			if tt.violation == "" {
+				if err != nil {
+					t.Fatalf("Parse(%s) failed, reason: %v", in, err)
+				}
+				return
+			}
+			var violationErr *SpecViolationError
+			if !errors.Is(err, ErrSpecViolation) || !errors.As(err, &violationErr) {
+				t.Fatalf("Parse(%s) error assertion failed, got %v, want %v",
+					in, err, ErrSpecViolation)
+			}
+			if violationErr.Structure != tt.structure ||
+				violationErr.Violation != tt.violation {
+				t.Errorf("spec violation error assertion failed, got %+v, "+
+					"want structure %s violation %s", violationErr,
+					tt.structure, tt.violation)
+			}
+		})
+	}
+}
+
+func TestAllowInvalidNTHeader(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		signature uint32
+		err       error
+	}{
+		{"not PE", 0x00005858, ErrImageNtSignatureNotFound},
+		{"NE", ImageOS2Signature, ErrImageOS2SignatureFound},
+	}
+
+	in := getAbsoluteFilePath("test/kernel32.dll")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(in)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", in, err)
+			}
+			want, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", in, err)
+			}
+			err = want.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", in, err)
+			}
+
+			ntHeaderOffset := binary.LittleEndian.Uint32(data[0x3c:])
+			binary.LittleEndian.PutUint32(data[ntHeaderOffset:], tt.signature)
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", in, err)
+			}
+			err = file.Parse()
+			if err != tt.err {
+				t.Fatalf("Parse(%s) error assertion failed, got %v, want %v",
+					in, err, tt.err)
+			}
+
+			file, err = NewBytes(data, &Options{AllowInvalidNTHeader: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", in, err)
+			}
+
+			if !file.HasDOSHdr || file.HasNTHdr || file.HasSections {
+				t.Errorf("header flags assertion failed, got %+v", file.FileInfo)
+			}
+			if !file.HasRichHdr || !reflect.DeepEqual(file.RichHeader, want.RichHeader) {
+				t.Errorf("rich header assertion failed, got %+v, want %+v",
+					file.RichHeader, want.RichHeader)
+			}
+			if !reflect.DeepEqual(file.DOSStub, want.DOSStub) {
+				t.Errorf("DOS stub assertion failed, got %+v, want %+v",
+					file.DOSStub, want.DOSStub)
+			}
+			if !file.HasOverlay || file.OverlayOffset != int64(ntHeaderOffset) ||
+				file.OverlayLength() != int64(len(data))-int64(ntHeaderOffset) {
+				t.Errorf("overlay assertion failed, got offset 0x%x length 0x%x",
+					file.OverlayOffset, file.OverlayLength())
+			}
+			if len(file.Warnings) == 0 || file.Warnings[len(file.Warnings)-1].Directory != "NTHeader" {
+				t.Errorf("NT header warning not found in %v", file.Warnings)
+			}
+
+			// The NT header dependent checks are skipped, and the accessors
+			// of the partial file do not panic.
+			file, err = NewBytes(data, &Options{AllowInvalidNTHeader: true,
+				FailOnAnomaly: true, FailOnAnomalies: []string{AnoNoNXCompat}})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) with FailOnAnomaly failed, reason: %v", in, err)
+			}
+			file.GetOffsetFromRva(0x1000)
+			if _, err := file.DataDirectoryRange(ImageDirectoryEntryImport); err == nil {
+				t.Errorf("DataDirectoryRange() should fail without NT header")
+			}
+			if file.Authentihash() != nil {
+				t.Errorf("Authentihash() should be nil without NT header")
+			}
+		})
+	}
+}
+
+func TestDeterministicJSON(t *testing.T) {
+	tests := []string{
+		getAbsoluteFilePath("test/putty.exe"),
+		getAbsoluteFilePath("test/mscorlib.dll"),
+		getAbsoluteFilePath("test/PSCRIPT5.DLL"),
+	}
+
+	marshal := func(filename string) []byte {
+		file, err := New(filename, &Options{})
+		if err != nil {
+			t.Fatalf("New(%s) failed, reason: %v", filename, err)
+		}
+		defer file.Close()
+		err = file.Parse()
+		if err != nil {
+			t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+		}
+		b, err := json.Marshal(file)
+		if err != nil {
+			t.Fatalf("json.Marshal(%s) failed, reason: %v", filename, err)
+		}
+		return b
+	}
+
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			want := marshal(tt)
+			for i := 0; i < 3; i++ {
+				if got := marshal(tt); !bytes.Equal(got, want) {
+					t.Fatalf("JSON output of %s differs between runs", tt)
+				}
+			}
+		})
+	}
+}
+
+func TestDeterministicFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		got  func() []string
+		want []string
+	}{
+		{
+			"file header characteristics",
+			ImageFileHeaderCharacteristicsType(0x2102).String,
+			[]string{"ExecutableImage", "32BitMachine", "DLL"},
+		},
+		{
+			"dll characteristics",
+			ImageOptionalHeaderDllCharacteristicsType(0x8160).String,
+			[]string{"HighEntropyVA", "DynamicBase", "NXCompact", "TerminalServiceAware"},
+		},
+		{
+			"guard flags",
+			func() []string { return StringifyGuardFlags(0x10500) },
+			[]string{"Instrumented", "TargetMetadata", "LongJumpTablePresent"},
+		},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ {
+			got := tt.got()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%s assertion failed, got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFlagsMap(t *testing.T) {
+	type flagSet interface {
+		Flags() []string
+		FlagsMap() map[string]bool
+	}
+
+	tests := []struct {
+		name  string
+		flags flagSet
+		count int
+		want  []string
+	}{
+		{
+			"file header characteristics",
+			ImageFileHeaderCharacteristicsType(0x2102), 14,
+			[]string{"ExecutableImage", "32BitMachine", "DLL"},
+		},
+		{
+			"dll characteristics",
+			ImageOptionalHeaderDllCharacteristicsType(0x8160), 11,
+			[]string{"HighEntropyVA", "DynamicBase", "NXCompact", "TerminalServiceAware"},
+		},
+		{
+			"section characteristics",
+			SectionCharacteristicsType(0x60000020), 39,
+			[]string{"Contains Code", "Executable", "Readable"},
+		},
+		{
+			"clr header flags",
+			COMImageFlagsType(0x9), 7,
+			[]string{"IL Only", "Strong Name Signed"},
+		},
+		{
+			"guard flags",
+			GuardFlagsType(0x10500), 17,
+			[]string{"Instrumented", "TargetMetadata", "LongJumpTablePresent"},
+		},
+		{
+			"dll characteristics ex",
+			DllCharacteristicsExType(0x1), 8,
+			[]string{"CET Compatible"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.flags.Flags()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("flags assertion failed, got %v, want %v", got, tt.want)
+			}
+
+			flagsMap := tt.flags.FlagsMap()
+			if len(flagsMap) != tt.count {
+				t.Errorf("flags map count assertion failed, got %d, want %d",
+					len(flagsMap), tt.count)
+			}
+			var set []string
+			for name, ok := range flagsMap {
+				if ok {
+					set = append(set, name)
+				}
+			}
+			sort.Strings(set)
+			want := append([]string(nil), tt.want...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(set, want) {
+				t.Errorf("flags map assertion failed, got %v, want %v", set, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,502 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FuzzyHashes holds the fuzzy hashes of a chunk of data, similar chunks get
+// similar hashes. See SsdeepCompare and TLSHDiff.
+type FuzzyHashes struct {
+	Ssdeep string `json:"ssdeep"`
+
+	// Empty when the data is too short or does not have enough variation.
+	TLSH string `json:"tlsh,omitempty"`
+}
+
+func fuzzyHashes(data []byte) FuzzyHashes {
+	return FuzzyHashes{Ssdeep: ssdeepHash(data), TLSH: tlshHash(data)}
+}
+
+// FuzzyHashes calculates the fuzzy hashes of the whole file.
+func (pe *File) FuzzyHashes() FuzzyHashes {
+	return fuzzyHashes(pe.data)
+}
+
+// The constants of the ssdeep context triggered piecewise hashing.
+const (
+	ssdeepRollingWindow  = 7
+	ssdeepMinBlockSize   = 3
+	ssdeepHashPrime      = 0x01000193
+	ssdeepHashInit       = 0x28021967
+	ssdeepSpamSumLength  = 64
+	ssdeepNumBlockHashes = 31
+	ssdeepBase64         = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+)
+
+// ssdeepRollingHash is the rolling hash over the last bytes of the input
+// which decides where the input is split into pieces.
+type ssdeepRollingHash struct {
+	window     [ssdeepRollingWindow]byte
+	h1, h2, h3 uint32
+	n          uint32
+}
+
+func (r *ssdeepRollingHash) update(c byte) {
+	r.h2 -= r.h1
+	r.h2 += ssdeepRollingWindow * uint32(c)
+	r.h1 += uint32(c)
+	r.h1 -= uint32(r.window[r.n%ssdeepRollingWindow])
+	r.window[r.n%ssdeepRollingWindow] = c
+	r.n++
+	r.h3 <<= 5
+	r.h3 ^= uint32(c)
+}
+
+func (r *ssdeepRollingHash) sum() uint32 {
+	return r.h1 + r.h2 + r.h3
+}
+
+// ssdeepBlockHash holds the piecewise hash for one block size.
+type ssdeepBlockHash struct {
+	h, halfh   uint32
+	digest     []byte
+	halfdigest byte
+}
+
+// ssdeepHash computes the ssdeep fuzzy hash of data, as formatted by the
+// ssdeep tool: `blocksize:hash:hash`. The digests of every block size are
+// computed in a single pass, the block size is then chosen from the input
+// size, and halved while the digest is too short.
+func ssdeepHash(data []byte) string {
+	var roll ssdeepRollingHash
+	var bh [ssdeepNumBlockHashes]ssdeepBlockHash
+	for i := range bh {
+		bh[i].h = ssdeepHashInit
+		bh[i].halfh = ssdeepHashInit
+		bh[i].digest = make([]byte, 1, ssdeepSpamSumLength)
+	}
+	blockSize := func(i int) uint64 {
+		return uint64(ssdeepMinBlockSize) << uint(i)
+	}
+
+	for _, c := range data {
+		roll.update(c)
+		h := uint64(roll.sum())
+		for i := range bh {
+			bh[i].h = bh[i].h*ssdeepHashPrime ^ uint32(c)
+			bh[i].halfh = bh[i].halfh*ssdeepHashPrime ^ uint32(c)
+		}
+		for i := range bh {
+			if h%blockSize(i) != blockSize(i)-1 {
+				break
+			}
+
+			// Once the digest is full, its last character is overwritten
+			// by each new piece.
+			b := &bh[i]
+			b.digest[len(b.digest)-1] = ssdeepBase64[b.h%64]
+			b.halfdigest = ssdeepBase64[b.halfh%64]
+			if len(b.digest) < ssdeepSpamSumLength {
+				b.digest = append(b.digest, 0)
+				b.h = ssdeepHashInit
+				if len(b.digest) <= ssdeepSpamSumLength/2 {
+					b.halfh = ssdeepHashInit
+					b.halfdigest = 0
+				}
+			}
+		}
+	}
+
+	// The last character of a digest is pending, it holds the last written
+	// character once the digest is full.
+	digest := func(b *ssdeepBlockHash, max int) []byte {
+		d := b.digest[:len(b.digest)-1]
+		if len(d) > max {
+			d = d[:max]
+		}
+		return d
+	}
+
+	bi := 0
+	for bi < ssdeepNumBlockHashes-1 && blockSize(bi)*ssdeepSpamSumLength < uint64(len(data)) {
+		bi++
+	}
+	for bi > 0 && len(bh[bi].digest)-1 < ssdeepSpamSumLength/2 {
+		bi--
+	}
+
+	h := roll.sum()
+	result := strconv.FormatUint(blockSize(bi), 10) + ":"
+	first := digest(&bh[bi], ssdeepSpamSumLength-1)
+	result += string(first)
+	if h != 0 {
+		result += string(ssdeepBase64[bh[bi].h%64])
+	} else if pending := bh[bi].digest[len(first)]; pending != 0 {
+		result += string(pending)
+	}
+	result += ":"
+
+	if bi < ssdeepNumBlockHashes-1 {
+		next := &bh[bi+1]
+		second := digest(next, ssdeepSpamSumLength/2-1)
+		result += string(second)
+		if h != 0 {
+			result += string(ssdeepBase64[next.halfh%64])
+		} else if next.halfdigest != 0 {
+			result += string(next.halfdigest)
+		}
+	} else if h != 0 {
+		result += string(ssdeepBase64[bh[bi].h%64])
+	}
+	return result
+}
+
+// ssdeepParse splits an ssdeep hash into its block size and its two
+// digests, the runs of more than 3 identical characters being shortened as
+// they carry little information.
+func ssdeepParse(s string) (uint64, string, string, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return 0, "", "", fmt.Errorf("invalid ssdeep hash %q", s)
+	}
+	blockSize, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("invalid ssdeep hash %q", s)
+	}
+	eliminate := func(d string) string {
+		b := []byte(d)
+		n := 0
+		for i, c := range b {
+			if i >= 3 && c == b[i-1] && c == b[i-2] && c == b[i-3] {
+				continue
+			}
+			b[n] = c
+			n++
+		}
+		return string(b[:n])
+	}
+	return blockSize, eliminate(parts[1]), eliminate(parts[2]), nil
+}
+
+// ssdeepEditDistance returns the edit distance of two digests, a change
+// counting as an insertion plus a removal.
+func ssdeepEditDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 2
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// ssdeepScoreDigests scores two digests computed with the same block size.
+func ssdeepScoreDigests(a, b string, blockSize uint64) int {
+	if len(a) > ssdeepSpamSumLength || len(b) > ssdeepSpamSumLength {
+		return 0
+	}
+
+	// Digests without a common substring of the rolling window size are
+	// not related.
+	common := false
+	for i := 0; i+ssdeepRollingWindow <= len(a) && !common; i++ {
+		common = strings.Contains(b, a[i:i+ssdeepRollingWindow])
+	}
+	if !common {
+		return 0
+	}
+
+	score := ssdeepEditDistance(a, b) * ssdeepSpamSumLength / (len(a) + len(b))
+	score = 100 * score / ssdeepSpamSumLength
+	if score >= 100 {
+		return 0
+	}
+	score = 100 - score
+
+	// Short digests of small block sizes are not allowed to exaggerate
+	// their match.
+	if blockSize >= (99+ssdeepRollingWindow)/ssdeepRollingWindow*ssdeepMinBlockSize {
+		return score
+	}
+	shortest := len(a)
+	if len(b) < shortest {
+		shortest = len(b)
+	}
+	limit := int(blockSize/ssdeepMinBlockSize) * shortest
+	if score > limit {
+		score = limit
+	}
+	return score
+}
+
+// SsdeepCompare returns the similarity of two ssdeep hashes, as computed by
+// the ssdeep tool, from 0 for unrelated inputs to 100 for identical ones.
+// Only hashes whose block sizes are equal or differ by a factor of two
+// can be compared, the score of other hashes is 0.
+func SsdeepCompare(a, b string) (int, error) {
+	bsA, a1, a2, err := ssdeepParse(a)
+	if err != nil {
+		return 0, err
+	}
+	bsB, b1, b2, err := ssdeepParse(b)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case bsA == bsB && a1 == b1 && a2 == b2:
+		return 100, nil
+	case bsA == bsB:
+		score := ssdeepScoreDigests(a1, b1, bsA)
+		if s := ssdeepScoreDigests(a2, b2, bsA*2); s > score {
+			score = s
+		}
+		return score, nil
+	case bsA == bsB*2:
+		return ssdeepScoreDigests(a1, b2, bsA), nil
+	case bsB == bsA*2:
+		return ssdeepScoreDigests(a2, b1, bsB), nil
+	}
+	return 0, nil
+}
+
+// The constants of the TLSH locality sensitive hash, with 128 buckets and a
+// one byte checksum.
+const (
+	tlshWindowSize    = 5
+	tlshBuckets       = 128
+	tlshCodeSize      = tlshBuckets / 4
+	tlshMinDataLength = 50
+	tlshVersion       = "T1"
+)
+
+// tlshPearson is the permutation table of the Pearson hash mapping the
+// triplets of bytes to the buckets.
+var tlshPearson = [256]byte{
+	1, 87, 49, 12, 176, 178, 102, 166, 121, 193, 6, 84, 249, 230, 44, 163,
+	14, 197, 213, 181, 161, 85, 218, 80, 64, 239, 24, 226, 236, 142, 38, 200,
+	110, 177, 104, 103, 141, 253, 255, 50, 77, 101, 81, 18, 45, 96, 31, 222,
+	25, 107, 190, 70, 86, 237, 240, 34, 72, 242, 20, 214, 244, 227, 149, 235,
+	97, 234, 57, 22, 60, 250, 82, 175, 208, 5, 127, 199, 111, 62, 135, 248,
+	174, 169, 211, 58, 66, 154, 106, 195, 245, 171, 17, 187, 182, 179, 0, 243,
+	132, 56, 148, 75, 128, 133, 158, 100, 130, 126, 91, 13, 153, 246, 216, 219,
+	119, 68, 223, 78, 83, 88, 201, 99, 122, 11, 92, 32, 136, 114, 52, 10,
+	138, 30, 48, 183, 156, 35, 61, 26, 143, 74, 251, 94, 129, 162, 63, 152,
+	170, 7, 115, 167, 241, 206, 3, 150, 55, 59, 151, 220, 90, 53, 23, 131,
+	125, 173, 15, 238, 79, 95, 89, 16, 105, 137, 225, 224, 217, 160, 37, 123,
+	118, 73, 2, 157, 46, 116, 9, 145, 134, 228, 207, 212, 202, 215, 69, 229,
+	27, 188, 67, 124, 168, 252, 42, 4, 29, 108, 21, 247, 19, 205, 39, 203,
+	233, 40, 186, 147, 198, 192, 155, 33, 164, 191, 98, 204, 165, 180, 117, 76,
+	140, 36, 210, 172, 41, 54, 159, 8, 185, 232, 113, 196, 231, 47, 146, 120,
+	51, 65, 28, 144, 254, 221, 93, 189, 194, 139, 112, 43, 71, 109, 184, 209,
+}
+
+// tlshMapping returns the Pearson hash of a salted triplet.
+func tlshMapping(salt, i, j, k byte) byte {
+	h := tlshPearson[salt]
+	h = tlshPearson[h^i]
+	h = tlshPearson[h^j]
+	return tlshPearson[h^k]
+}
+
+// tlshCaptureLength returns the logarithmic encoding of the input length.
+func tlshCaptureLength(n int) byte {
+	length := float64(n)
+	var l float64
+	switch {
+	case n <= 656:
+		l = math.Floor(math.Log(length) / math.Log(1.5))
+	case n <= 3199:
+		l = math.Floor(math.Log(length)/math.Log(1.3) - 8.72777)
+	default:
+		l = math.Floor(math.Log(length)/math.Log(1.1) - 62.5472)
+	}
+	return byte(int(l) & 0xff)
+}
+
+// swapNibbles swaps the high and low nibbles of a byte as TLSH does when
+// formatting the header.
+func swapNibbles(b byte) byte {
+	return b<<4 | b>>4
+}
+
+// tlshHash computes the TLSH hash of data, as formatted by the TLSH tool:
+// `T1` followed by 70 hexadecimal digits. It returns an empty string when
+// the input is shorter than 50 bytes or does not have enough variation.
+func tlshHash(data []byte) string {
+	if len(data) < tlshMinDataLength {
+		return ""
+	}
+
+	// Every window of 5 bytes increments the bucket of 6 of its triplets,
+	// all including the last byte.
+	var buckets [256]uint32
+	var checksum byte
+	var w [tlshWindowSize]byte
+	for n, c := range data {
+		j := n % tlshWindowSize
+		w[j] = c
+		if n < tlshWindowSize-1 {
+			continue
+		}
+		j1 := (j + 4) % tlshWindowSize
+		j2 := (j + 3) % tlshWindowSize
+		j3 := (j + 2) % tlshWindowSize
+		j4 := (j + 1) % tlshWindowSize
+		checksum = tlshMapping(0, w[j], w[j1], checksum)
+		buckets[tlshMapping(2, w[j], w[j1], w[j2])]++
+		buckets[tlshMapping(3, w[j], w[j1], w[j3])]++
+		buckets[tlshMapping(5, w[j], w[j2], w[j3])]++
+		buckets[tlshMapping(7, w[j], w[j2], w[j4])]++
+		buckets[tlshMapping(11, w[j], w[j1], w[j4])]++
+		buckets[tlshMapping(13, w[j], w[j3], w[j4])]++
+	}
+
+	nonZero := 0
+	for _, b := range buckets[:tlshBuckets] {
+		if b > 0 {
+			nonZero++
+		}
+	}
+	if nonZero <= tlshBuckets/2 {
+		return ""
+	}
+
+	sorted := make([]uint32, tlshBuckets)
+	copy(sorted, buckets[:tlshBuckets])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	q1 := sorted[tlshBuckets/4-1]
+	q2 := sorted[tlshBuckets/2-1]
+	q3 := sorted[tlshBuckets*3/4-1]
+	if q3 == 0 {
+		return ""
+	}
+
+	// The body encodes the quartile of every bucket on 2 bits, the last
+	// buckets first.
+	var code [tlshCodeSize]byte
+	for i := 0; i < tlshCodeSize; i++ {
+		var h byte
+		for j := 0; j < 4; j++ {
+			k := buckets[4*i+j]
+			switch {
+			case q3 < k:
+				h += 3 << (uint(j) * 2)
+			case q2 < k:
+				h += 2 << (uint(j) * 2)
+			case q1 < k:
+				h += 1 << (uint(j) * 2)
+			}
+		}
+		code[tlshCodeSize-1-i] = h
+	}
+
+	q1Ratio := byte(uint64(q1)*100/uint64(q3)) % 16
+	q2Ratio := byte(uint64(q2)*100/uint64(q3)) % 16
+	header := []byte{
+		swapNibbles(checksum),
+		swapNibbles(tlshCaptureLength(len(data))),
+		q1Ratio<<4 | q2Ratio,
+	}
+	return tlshVersion + strings.ToUpper(hex.EncodeToString(header)+
+		hex.EncodeToString(code[:]))
+}
+
+// parseTLSH decodes a TLSH hash into its header, with the nibbles swapped
+// back, and its body.
+func parseTLSH(s string) (header [3]byte, code []byte, err error) {
+	s = strings.TrimPrefix(s, tlshVersion)
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(header)+tlshCodeSize {
+		return header, nil, fmt.Errorf("invalid TLSH hash %q", s)
+	}
+	header[0] = swapNibbles(b[0])
+	header[1] = swapNibbles(b[1])
+	header[2] = b[2]
+	return header, b[len(header):], nil
+}
+
+// modDiff returns the distance between x and y on a circle of size r.
+func modDiff(x, y, r int) int {
+	d := x - y
+	if d < 0 {
+		d = -d
+	}
+	if r-d < d {
+		return r - d
+	}
+	return d
+}
+
+// TLSHDiff returns the distance between two TLSH hashes, including the
+// length of the inputs. Identical inputs have a distance of 0, the distance
+// of unrelated inputs is usually above 200.
+func TLSHDiff(a, b string) (int, error) {
+	ha, ca, err := parseTLSH(a)
+	if err != nil {
+		return 0, err
+	}
+	hb, cb, err := parseTLSH(b)
+	if err != nil {
+		return 0, err
+	}
+
+	diff := 0
+	switch d := modDiff(int(ha[1]), int(hb[1]), 256); d {
+	case 0, 1:
+		diff += d
+	default:
+		diff += d * 12
+	}
+	for _, shift := range []uint{4, 0} {
+		d := modDiff(int(ha[2]>>shift&0xf), int(hb[2]>>shift&0xf), 16)
+		if d <= 1 {
+			diff += d
+		} else {
+			diff += (d - 1) * 12
+		}
+	}
+	if ha[0] != hb[0] {
+		diff++
+	}
+
+	for i := range ca {
+		x, y := ca[i], cb[i]
+		for j := uint(0); j < 8; j += 2 {
+			d := int(x>>j&3) - int(y>>j&3)
+			if d < 0 {
+				d = -d
+			}
+			if d == 3 {
+				d = 6
+			}
+			diff += d
+		}
+	}
+	return diff, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"testing"
+)
+
+func TestSsdeepHash(t *testing.T) {
+
+	// A pseudo random input large enough to use a block size above the
+	// minimum.
+	random := make([]byte, 10000)
+	x := uint32(1)
+	for i := range random {
+		x = x*1103515245 + 12345
+		random[i] = byte(x >> 16)
+	}
+
+	tests := []struct {
+		name string
+		in   []byte
+		out  string
+	}{
+		{"empty", nil, "3::"},
+		{"random", random,
+			"192:xD/uceMkIkJ/jb4ACeXCQ7diBlG6apx/CMu4tx73U1L/VujBh+wH+ADnRnBO83kQ:xD/5kIQXbCQ7d2AxNL73U3WhNnB6/+mK"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ssdeepHash(tt.in)
+			if got != tt.out {
+				t.Errorf("ssdeepHash() got %v, want %v", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestSsdeepCompare(t *testing.T) {
+
+	tests := []struct {
+		name string
+		a, b string
+		out  int
+	}{
+		{"identical", "3:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C",
+			"3:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C", 100},
+		{"sequences", "3:AAAAAAAXGBicFlgVNh:AXGHsNhxLsr2C",
+			"3:AAAXGBicFlgVNh:AXGHsNhxLsr2C", 100},
+		{"similar", "96:MAn8nn304U0tPXjMc9unwf0GHu+lkbEX8UncylInUkvPiPEvvc:h8n2kPs40GHsbEX8UnVIUsCEX",
+			"96:MAn8nn304U0tPXjMc9unwf0GHu+lkbEX8UncylInUkvPiPEvvZ:h8n2kPs40GHsbEX8UnVIUsCEZ", 99},
+		{"double", "192:h8n2kPs40GHsbEX8UnVIUsCEX:xxxx",
+			"96:MAn8nn304U0tPXjMc9unwf0GHu+lkbEX8UncylInUkvPiPEvvc:h8n2kPs40GHsbEX8UnVIUsCEX", 100},
+		{"unrelated", "96:MAn8nn304U0tPXjMc9unwf0GHu+lkbEX8UncylInUkvPiPEvvc:h8n2kPs40GHsbEX8UnVIUsCEX",
+			"96:yATkRkDTkTDSMT/yATkRkDTkTDSMT:yATkRkDTkTDSMT", 0},
+		{"incompatible", "3:EGk3ekll/:EGTk", "24:EGk3ekll/:EGTk", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SsdeepCompare(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("SsdeepCompare() failed, reason: %v", err)
+			}
+			if got != tt.out {
+				t.Errorf("SsdeepCompare() got %v, want %v", got, tt.out)
+			}
+		})
+	}
+
+	if _, err := SsdeepCompare("3:abc", "3:abc:def"); err == nil {
+		t.Errorf("SsdeepCompare() of an invalid hash succeeded")
+	}
+}
+
+func TestTLSHHash(t *testing.T) {
+
+	random := make([]byte, 10000)
+	x := uint32(1)
+	for i := range random {
+		x = x*1103515245 + 12345
+		random[i] = byte(x >> 16)
+	}
+
+	// Some bytes changed.
+	patched := make([]byte, len(random))
+	copy(patched, random)
+	for i := 0; i < len(patched); i += 500 {
+		patched[i] ^= 0xff
+	}
+
+	tests := []struct {
+		name string
+		in   []byte
+		out  string
+	}{
+		{"short", random[:49], ""},
+		{"uniform", make([]byte, 1000), ""},
+		{"random", random,
+			"T1E022BEFF2B0CE3D1184CF91153694BA8AF42AB67CACA382AFC14446185647C361CFC8A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tlshHash(tt.in)
+			if got != tt.out {
+				t.Errorf("tlshHash() got %v, want %v", got, tt.out)
+			}
+		})
+	}
+
+	hash := tlshHash(random)
+	diff, err := TLSHDiff(hash, hash)
+	if err != nil || diff != 0 {
+		t.Errorf("TLSHDiff() of identical hashes got %v, %v, want 0", diff, err)
+	}
+	diff, err = TLSHDiff(hash, tlshHash(patched))
+	if err != nil || diff == 0 || diff > 100 {
+		t.Errorf("TLSHDiff() of similar hashes got %v, %v", diff, err)
+	}
+	if _, err := TLSHDiff(hash, "T1C721"); err == nil {
+		t.Errorf("TLSHDiff() of an invalid hash succeeded")
+	}
+}
@@ -7,8 +7,28 @@ package pe
 const (
 	// AnoInvalidGlobalPtrReg is reported when the global pointer register offset is outide the image.
 	AnoInvalidGlobalPtrReg = "Global pointer register offset outside of PE image"
+
+	// AnoGlobalPtrSizeNotZero is reported when the size of the global pointer
+	// data directory is not zero.
+	AnoGlobalPtrSizeNotZero = "Global pointer data directory size must be set to zero"
+
+	// AnoGlobalPtrUnexpectedMachine is reported when the global pointer data
+	// directory is set for a machine which has no global pointer register.
+	AnoGlobalPtrUnexpectedMachine = "Global pointer data directory set for a machine without global pointer register"
 )
 
+// globalPtrMachines lists the machines which use a global pointer register.
+var globalPtrMachines = map[ImageFileHeaderMachineType]bool{
+	ImageFileMachineAlpha:     true,
+	ImageFileMachineAlpha64:   true,
+	ImageFileMachineIA64:      true,
+	ImageFileMachineR4000:     true,
+	ImageFileMachineWCEMIPSv2: true,
+	ImageFileMachineMIPS16:    true,
+	ImageFileMachineMIPSFPU:   true,
+	ImageFileMachineMIPSFPU16: true,
+}
+
 // RVA of the value to be stored in the global pointer register. The size must
 // be set to 0. This data directory is set to all zeros if the target
 // architecture (for example, I386 or AMD64) does not use the concept of a
@@ -17,12 +37,19 @@ func (pe *File) parseGlobalPtrDirectory(rva, size uint32) error {
 
 	var err error
 
+	if size != 0 {
+		pe.addAnomaly(AnoGlobalPtrSizeNotZero)
+	}
+	if !globalPtrMachines[pe.NtHeader.FileHeader.Machine] {
+		pe.addAnomaly(AnoGlobalPtrUnexpectedMachine)
+	}
+
 	// RVA of the value to be stored in the global pointer register.
 	offset := pe.GetOffsetFromRva(rva)
 	if offset == ^uint32(0) {
 		// Fake global pointer data directory
 		// sample: 0101f36de484fbc7bfbe6cb942a1ecf6fac0c3acd9f65b88b19400582d7e7007
-		pe.Anomalies = append(pe.Anomalies, AnoInvalidGlobalPtrReg)
+		pe.appendAnomaly(AnoInvalidGlobalPtrReg)
 		return nil
 	}
 
@@ -34,3 +61,19 @@ func (pe *File) parseGlobalPtrDirectory(rva, size uint32) error {
 	pe.HasGlobalPtr = true
 	return nil
 }
+
+// GlobalPointer returns the virtual address the loader stores in the global
+// pointer register, that is the image base plus the RVA found in the global
+// pointer data directory, and false when the directory is not set.
+func (pe *File) GlobalPointer() (uint64, bool) {
+	dirEntry := pe.dataDirectory(ImageDirectoryEntryGlobalPtr)
+	if dirEntry.VirtualAddress == 0 {
+		return 0, false
+	}
+
+	va, err := pe.RVAToVA(dirEntry.VirtualAddress)
+	if err != nil {
+		return 0, false
+	}
+	return va, true
+}
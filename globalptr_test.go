@@ -0,0 +1,81 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestGlobalPtrDirectory(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	tests := []struct {
+		machine   ImageFileHeaderMachineType
+		rva       uint32
+		size      uint32
+		anomalies []string
+	}{
+		{ImageFileMachineIA64, 0x1000, 0, nil},
+		{ImageFileMachineIA64, 0x1000, 8, []string{AnoGlobalPtrSizeNotZero}},
+		{ImageFileMachineAMD64, 0x1000, 0, []string{AnoGlobalPtrUnexpectedMachine}},
+		{ImageFileMachineIA64, 0, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.machine.String(), func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+
+			// putty.exe is a PE32+, data directories follow the 112 bytes of
+			// the optional header fixed fields.
+			ntHeaderOffset := binary.LittleEndian.Uint32(data[0x3c:])
+			binary.LittleEndian.PutUint16(data[ntHeaderOffset+4:], uint16(tt.machine))
+			dirOffset := ntHeaderOffset + 4 + 20 + 112 +
+				uint32(ImageDirectoryEntryGlobalPtr)*8
+			binary.LittleEndian.PutUint32(data[dirOffset:], tt.rva)
+			binary.LittleEndian.PutUint32(data[dirOffset+4:], tt.size)
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			for _, anomaly := range []string{AnoGlobalPtrSizeNotZero,
+				AnoGlobalPtrUnexpectedMachine} {
+				want := stringInSlice(anomaly, tt.anomalies)
+				if got := stringInSlice(anomaly, file.Anomalies); got != want {
+					t.Errorf("anomaly %s assertion failed, got %v, want %v",
+						anomaly, got, want)
+				}
+			}
+
+			gp, ok := file.GlobalPointer()
+			if ok != (tt.rva != 0) || file.HasGlobalPtr != ok {
+				t.Fatalf("GlobalPointer() assertion failed, got %v, want %v",
+					ok, tt.rva != 0)
+			}
+			if !ok {
+				return
+			}
+			if gp != 0x140000000+uint64(tt.rva) {
+				t.Errorf("GlobalPointer() assertion failed, got 0x%x, want 0x%x",
+					gp, 0x140000000+uint64(tt.rva))
+			}
+			want := binary.LittleEndian.Uint32(data[file.GetOffsetFromRva(tt.rva):])
+			if file.GlobalPtr != want {
+				t.Errorf("GlobalPtr assertion failed, got 0x%x, want 0x%x",
+					file.GlobalPtr, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+const (
+	// goBuildIDPrefix and goBuildIDSuffix surround the build ID written by
+	// the Go linker at the start of the text section.
+	goBuildIDPrefix = "\xff Go build ID: \""
+	goBuildIDSuffix = "\"\n \xff"
+
+	// goBuildIDSearchSize is the number of bytes searched for the build ID
+	// from the start of the file, as done by the go tool.
+	goBuildIDSearchSize = 32 * 1024
+
+	// goBuildInfoMagic starts the runtime.buildinfo structure, which is
+	// aligned to 16 bytes and has a 32 bytes header.
+	goBuildInfoMagic      = "\xff Go buildinf:"
+	goBuildInfoAlign      = 16
+	goBuildInfoHeaderSize = 32
+
+	// goBuildInfoFlagsInline is set since Go 1.18 when the version and
+	// module information strings follow the header, rather than being
+	// referenced by pointers.
+	goBuildInfoFlagsInline = 0x2
+)
+
+// GoModule describes a Go module the binary was built from.
+type GoModule struct {
+	// Module path, for instance `golang.org/x/text`.
+	Path string `json:"path"`
+
+	// Module version, `(devel)` for the main module when built from a
+	// local checkout.
+	Version string `json:"version"`
+
+	// Checksum of the module as found in go.sum.
+	Sum string `json:"sum"`
+
+	// The module replacing this one, if any.
+	Replace *GoModule `json:"replace,omitempty"`
+}
+
+// GoBuildSetting is a key/value pair describing how the binary was built,
+// for instance `GOOS=windows`, `-ldflags=-s -w` or `vcs.revision=...`.
+type GoBuildSetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// GoBuildInfo represents the build information embedded by the Go toolchain.
+type GoBuildInfo struct {
+	// The build ID written by the linker at the start of the text section.
+	BuildID string `json:"build_id"`
+
+	// Version of the Go toolchain, for instance `go1.20.4`.
+	GoVersion string `json:"go_version"`
+
+	// Package path of the main package.
+	Path string `json:"path"`
+
+	// The main module.
+	Main GoModule `json:"main"`
+
+	// Dependencies linked into the binary.
+	Deps []GoModule `json:"deps"`
+
+	// Build settings, available since Go 1.18.
+	Settings []GoBuildSetting `json:"settings"`
+}
+
+// ReadGoBuildInfo extracts the build ID and the runtime.buildinfo structure
+// embedded in Go binaries: the toolchain version, the module path, the
+// dependencies and the build settings. Stripped binaries keep these, which
+// makes them a good source of provenance information for Go malware.
+func (pe *File) ReadGoBuildInfo() (*GoBuildInfo, error) {
+	info := &GoBuildInfo{BuildID: pe.goBuildID()}
+
+	found, err := pe.readGoBuildInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	if !found && info.BuildID == "" {
+		return nil, ErrGoBuildInfoNotFound
+	}
+	return info, nil
+}
+
+// goBuildID returns the Go build ID, or an empty string if not found.
+func (pe *File) goBuildID() string {
+	data := pe.data[:min(pe.remaining(0), goBuildIDSearchSize)]
+	start := bytes.Index(data, []byte(goBuildIDPrefix))
+	if start < 0 {
+		return ""
+	}
+	start += len(goBuildIDPrefix)
+	end := bytes.Index(data[start:], []byte(goBuildIDSuffix))
+	if end < 0 {
+		return ""
+	}
+	id := string(data[start : start+end])
+	if unquoted, err := strconv.Unquote(`"` + id + `"`); err == nil {
+		id = unquoted
+	}
+	return id
+}
+
+// readGoBuildInfo looks for the runtime.buildinfo structure in the raw data
+// of the sections and decodes it into info.
+func (pe *File) readGoBuildInfo(info *GoBuildInfo) (bool, error) {
+	for _, section := range pe.Sections {
+		data := section.Data(0, 0, pe)
+		for off := 0; off+goBuildInfoHeaderSize <= len(data); off += goBuildInfoAlign {
+			if !bytes.HasPrefix(data[off:], []byte(goBuildInfoMagic)) {
+				continue
+			}
+
+			var version, modInfo string
+			var err error
+			header := data[off : off+goBuildInfoHeaderSize]
+			ptrSize := int(header[14])
+			flags := header[15]
+			if flags&goBuildInfoFlagsInline != 0 {
+				r := &blobReader{data: data[off+goBuildInfoHeaderSize:]}
+				version = r.readUvarintString()
+				modInfo = r.readUvarintString()
+				err = r.err
+			} else {
+				version, err = pe.readGoString(header[16:], ptrSize, flags)
+				if err == nil {
+					modInfo, err = pe.readGoString(header[16+ptrSize:], ptrSize, flags)
+				}
+			}
+			if err != nil {
+				return false, err
+			}
+
+			info.GoVersion = version
+			parseGoModInfo(info, modInfo)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readGoString reads a Go string whose header is referenced by the pointer
+// found at the start of ptr, as stored by Go versions before 1.18.
+func (pe *File) readGoString(ptr []byte, ptrSize int, flags byte) (string, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if flags&0x1 != 0 {
+		order = binary.BigEndian
+	}
+	readPtr := func(b []byte) (uint64, bool) {
+		switch ptrSize {
+		case 4:
+			if len(b) >= 4 {
+				return uint64(order.Uint32(b)), true
+			}
+		case 8:
+			if len(b) >= 8 {
+				return order.Uint64(b), true
+			}
+		}
+		return 0, false
+	}
+
+	va, ok := readPtr(ptr)
+	if !ok {
+		return "", ErrInvalidGoBuildInfo
+	}
+	rva, err := pe.VAToRVA(va)
+	if err != nil {
+		return "", err
+	}
+	header, err := pe.GetData(rva, uint32(2*ptrSize))
+	if err != nil {
+		return "", err
+	}
+	dataVA, ok := readPtr(header)
+	if !ok {
+		return "", ErrInvalidGoBuildInfo
+	}
+	length, ok := readPtr(header[ptrSize:])
+	if !ok || length > pe.size {
+		return "", ErrInvalidGoBuildInfo
+	}
+	if length == 0 {
+		return "", nil
+	}
+	rva, err = pe.VAToRVA(dataVA)
+	if err != nil {
+		return "", err
+	}
+	data, err := pe.GetData(rva, uint32(length))
+	if err != nil {
+		return "", err
+	}
+	if uint64(len(data)) < length {
+		return "", ErrInvalidGoBuildInfo
+	}
+	return string(data), nil
+}
+
+// readUvarintString reads a string prefixed by its uvarint encoded length.
+func (r *blobReader) readUvarintString() string {
+	if r.err != nil {
+		return ""
+	}
+	length, n := binary.Uvarint(r.data[r.off:])
+	if n <= 0 || length > uint64(len(r.data)) {
+		r.err = ErrInvalidGoBuildInfo
+		return ""
+	}
+	r.off += n
+	return string(r.read(int(length)))
+}
+
+// parseGoModInfo decodes the module information as written by the go
+// command. It is made of tab separated lines such as `path`, `mod`, `dep`,
+// `=>` for a replaced module and `build` for settings.
+func parseGoModInfo(info *GoBuildInfo, modInfo string) {
+	// The module information is surrounded by 16 bytes sentinels.
+	if len(modInfo) >= 33 && modInfo[len(modInfo)-17] == '\n' {
+		modInfo = modInfo[16 : len(modInfo)-16]
+	}
+
+	var last *GoModule
+	for _, line := range strings.Split(modInfo, "\n") {
+		fields := strings.Split(line, "\t")
+		switch fields[0] {
+		case "path":
+			if len(fields) > 1 {
+				info.Path = fields[1]
+			}
+		case "mod":
+			info.Main = parseGoModule(fields[1:])
+			last = &info.Main
+		case "dep":
+			info.Deps = append(info.Deps, parseGoModule(fields[1:]))
+			last = &info.Deps[len(info.Deps)-1]
+		case "=>":
+			if last != nil {
+				replace := parseGoModule(fields[1:])
+				last.Replace = &replace
+				last = nil
+			}
+		case "build":
+			if len(fields) > 1 {
+				info.Settings = append(info.Settings,
+					parseGoBuildSetting(strings.Join(fields[1:], "\t")))
+			}
+		}
+	}
+}
+
+func parseGoModule(fields []string) GoModule {
+	mod := GoModule{}
+	if len(fields) > 0 {
+		mod.Path = fields[0]
+	}
+	if len(fields) > 1 {
+		mod.Version = fields[1]
+	}
+	if len(fields) > 2 {
+		mod.Sum = fields[2]
+	}
+	return mod
+}
+
+// parseGoBuildSetting decodes a `key=value` setting, keys and values
+// holding special characters are quoted.
+func parseGoBuildSetting(setting string) GoBuildSetting {
+	var key, value string
+	if strings.HasPrefix(setting, `"`) {
+		// Look for the closing quote, skipping escaped characters.
+		for i := 1; i < len(setting); i++ {
+			if setting[i] == '\\' {
+				i++
+			} else if setting[i] == '"' {
+				key, _ = strconv.Unquote(setting[:i+1])
+				setting = setting[i+1:]
+				break
+			}
+		}
+	}
+	if key == "" {
+		i := strings.IndexByte(setting, '=')
+		if i < 0 {
+			return GoBuildSetting{Key: setting}
+		}
+		key, setting = setting[:i], setting[i:]
+	}
+	value = strings.TrimPrefix(setting, "=")
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	return GoBuildSetting{Key: key, Value: value}
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// goBinary embeds a build ID and a runtime.buildinfo structure into the only
+// section of impbyord.exe, which is mapped at 0x401000 from file offset 0x200.
+func goBinary(t *testing.T, inline bool, version, modInfo string) []byte {
+	filename := getAbsoluteFilePath("test/impbyord.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	copy(data[0x200:], goBuildIDPrefix+"abc/def"+goBuildIDSuffix)
+
+	header := data[0x280:]
+	copy(header, goBuildInfoMagic)
+	header[14] = 4
+	if inline {
+		header[15] = goBuildInfoFlagsInline
+		buf := data[0x280+goBuildInfoHeaderSize:]
+		for _, str := range []string{version, modInfo} {
+			n := binary.PutUvarint(buf, uint64(len(str)))
+			n += copy(buf[n:], str)
+			buf = buf[n:]
+		}
+		return data
+	}
+
+	// Before Go 1.18, the header points to the string headers, themselves
+	// pointing to the string data.
+	binary.LittleEndian.PutUint32(header[16:], 0x401100)
+	binary.LittleEndian.PutUint32(header[20:], 0x401108)
+	binary.LittleEndian.PutUint32(data[0x300:], 0x401110)
+	binary.LittleEndian.PutUint32(data[0x304:], uint32(len(version)))
+	binary.LittleEndian.PutUint32(data[0x308:], 0x401120)
+	binary.LittleEndian.PutUint32(data[0x30c:], uint32(len(modInfo)))
+	copy(data[0x310:], version)
+	copy(data[0x320:], modInfo)
+	return data
+}
+
+func TestReadGoBuildInfo(t *testing.T) {
+
+	sentinel := "0123456789abcdef"
+	modInfo := sentinel + "path\texample.com/hello\n" +
+		"mod\texample.com/hello\t(devel)\t\n" +
+		"dep\tgolang.org/x/text\tv0.3.7\th1:abc=\n" +
+		"=>\t../text\t(devel)\t\n" +
+		"build\t-ldflags=\"-s -w\"\n" +
+		"build\tGOOS=windows\n" + sentinel
+
+	want := &GoBuildInfo{
+		BuildID:   "abc/def",
+		GoVersion: "go1.20.4",
+		Path:      "example.com/hello",
+		Main:      GoModule{Path: "example.com/hello", Version: "(devel)"},
+		Deps: []GoModule{{
+			Path:    "golang.org/x/text",
+			Version: "v0.3.7",
+			Sum:     "h1:abc=",
+			Replace: &GoModule{Path: "../text", Version: "(devel)"},
+		}},
+		Settings: []GoBuildSetting{
+			{Key: "-ldflags", Value: "-s -w"},
+			{Key: "GOOS", Value: "windows"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		inline bool
+	}{
+		{"inline", true},
+		{"pointers", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := goBinary(t, tt.inline, "go1.20.4", modInfo)
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes() failed, reason: %v", err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse() failed, reason: %v", err)
+			}
+
+			got, err := file.ReadGoBuildInfo()
+			if err != nil {
+				t.Fatalf("ReadGoBuildInfo() failed, reason: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Go build info assertion failed, got %+v, want %+v",
+					got, want)
+			}
+		})
+	}
+}
+
+func TestReadGoBuildInfoNotFound(t *testing.T) {
+	filename := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(filename, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	_, err = file.ReadGoBuildInfo()
+	if err != ErrGoBuildInfoNotFound {
+		t.Errorf("ReadGoBuildInfo() error assertion failed, got %v, want %v",
+			err, ErrGoBuildInfoNotFound)
+	}
+}
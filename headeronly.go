@@ -0,0 +1,154 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// headerOnlyReadSize is the size of the first read of NewHeaderOnly, which
+// holds the headers of almost every file.
+const headerOnlyReadSize = 0x1000
+
+// HeaderInfo holds the headers of a PE file read by NewHeaderOnly.
+type HeaderInfo struct {
+	DOSHeader ImageDOSHeader `json:"dos_header"`
+	NtHeader  ImageNtHeader  `json:"nt_header"`
+
+	// Sections lists the section headers in the order of the section table.
+	// The table is cut when it goes past the end of the file.
+	Sections []ImageSectionHeader `json:"sections"`
+
+	// Size is the size of the file.
+	Size int64 `json:"size"`
+
+	Is32 bool `json:"is_32"`
+	Is64 bool `json:"is_64"`
+}
+
+// IsDLL returns true if the PE file is a standard DLL.
+func (h *HeaderInfo) IsDLL() bool {
+	return h.NtHeader.FileHeader.Characteristics&ImageFileDLL != 0
+}
+
+// IsEXE returns true if the PE file has the IMAGE_FILE_EXECUTABLE_IMAGE flag
+// set and is not a DLL. Unlike File.IsEXE, drivers are not told apart as it
+// requires the import directory.
+func (h *HeaderInfo) IsEXE() bool {
+	return !h.IsDLL() &&
+		h.NtHeader.FileHeader.Characteristics&ImageFileExecutableImage != 0
+}
+
+// NewHeaderOnly reads the DOS, NT and section headers of a file without
+// reading the rest of it, which usually takes a single small read. It is
+// meant for pre-filters deciding whether a file is worth a complete parsing,
+// none of the anomalies checks of Parse are performed.
+func NewHeaderOnly(name string) (*HeaderInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadHeaderInfo(f, fi.Size())
+}
+
+// ReadHeaderInfo reads the headers of the PE file made of the first size
+// bytes of a reader at, see NewHeaderOnly.
+func ReadHeaderInfo(r io.ReaderAt, size int64) (*HeaderInfo, error) {
+	if size < 0 {
+		return nil, ErrOutsideBoundary
+	}
+
+	var buf []byte
+	read := func(n int64) error {
+		if n > size {
+			n = size
+		}
+		if n <= int64(len(buf)) {
+			return nil
+		}
+		grown := make([]byte, n)
+		copy(grown, buf)
+		_, err := r.ReadAt(grown[len(buf):], int64(len(buf)))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		buf = grown
+		return nil
+	}
+
+	// The headers are usually within the first page, read more only when
+	// e_lfanew or the section table point further.
+	err := read(headerOnlyReadSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) >= binary.Size(ImageDOSHeader{}) {
+		ntHeaderOffset := int64(binary.LittleEndian.Uint32(buf[0x3c:]))
+		fileHeaderSize := int64(binary.Size(ImageFileHeader{}))
+		err = read(ntHeaderOffset + 4 + fileHeaderSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if ntHeaderOffset+4+fileHeaderSize <= int64(len(buf)) {
+			fileHeader := buf[ntHeaderOffset+4:]
+			numberOfSections := int64(binary.LittleEndian.Uint16(fileHeader[2:]))
+			sizeOfOptionalHeader := int64(binary.LittleEndian.Uint16(fileHeader[16:]))
+			err = read(ntHeaderOffset + 4 + fileHeaderSize + sizeOfOptionalHeader +
+				numberOfSections*int64(binary.Size(ImageSectionHeader{})))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	pe, err := NewBytes(buf, &Options{Fast: true})
+	if err != nil {
+		return nil, err
+	}
+
+	err = pe.ParseDOSHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	err = pe.ParseNTHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &HeaderInfo{
+		DOSHeader: pe.DOSHeader,
+		NtHeader:  pe.NtHeader,
+		Size:      size,
+		Is32:      pe.Is32,
+		Is64:      pe.Is64,
+	}
+
+	offset := pe.DOSHeader.AddressOfNewEXEHeader + 4 +
+		uint32(binary.Size(pe.NtHeader.FileHeader)) +
+		uint32(pe.NtHeader.FileHeader.SizeOfOptionalHeader)
+	secHeaderSize := uint32(binary.Size(ImageSectionHeader{}))
+	for i := uint16(0); i < pe.NtHeader.FileHeader.NumberOfSections; i++ {
+		secHeader := ImageSectionHeader{}
+		err := pe.structUnpack(&secHeader, offset, secHeaderSize)
+		if err != nil {
+			break
+		}
+		h.Sections = append(h.Sections, secHeader)
+		offset += secHeaderSize
+	}
+
+	return h, nil
+}
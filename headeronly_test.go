@@ -0,0 +1,120 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// countingReaderAt counts the bytes read from a reader at.
+type countingReaderAt struct {
+	r     io.ReaderAt
+	count int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.count += n
+	return n, err
+}
+
+func TestNewHeaderOnly(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		isDLL bool
+		isEXE bool
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), false, true},
+		{getAbsoluteFilePath("test/kernel32.dll"), true, false},
+		{getAbsoluteFilePath("test/mfc40u.dll"), true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			h, err := NewHeaderOnly(tt.in)
+			if err != nil {
+				t.Fatalf("NewHeaderOnly(%s) failed, reason: %v", tt.in, err)
+			}
+
+			file, err := New(tt.in, &Options{Fast: true})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			if h.DOSHeader != file.DOSHeader {
+				t.Errorf("DOS header assertion failed, got %v, want %v",
+					h.DOSHeader, file.DOSHeader)
+			}
+			if !reflect.DeepEqual(h.NtHeader, file.NtHeader) {
+				t.Errorf("NT header assertion failed, got %v, want %v",
+					h.NtHeader, file.NtHeader)
+			}
+			if len(h.Sections) != len(file.Sections) {
+				t.Fatalf("sections count assertion failed, got %v, want %v",
+					len(h.Sections), len(file.Sections))
+			}
+			for i := range h.Sections {
+				if h.Sections[i] != file.Sections[i].Header {
+					t.Errorf("section header %d assertion failed, got %v, want %v",
+						i, h.Sections[i], file.Sections[i].Header)
+				}
+			}
+			if h.Size != int64(file.size) || h.Is64 != file.Is64 || h.Is32 != file.Is32 {
+				t.Errorf("header info assertion failed, got %v", h)
+			}
+			if h.IsDLL() != tt.isDLL || h.IsEXE() != tt.isEXE {
+				t.Errorf("IsDLL, IsEXE assertion failed, got %v, %v, want %v, %v",
+					h.IsDLL(), h.IsEXE(), tt.isDLL, tt.isEXE)
+			}
+		})
+	}
+}
+
+func TestReadHeaderInfo(t *testing.T) {
+	data, err := ioutil.ReadFile(getAbsoluteFilePath("test/kernel32.dll"))
+	if err != nil {
+		t.Fatalf("ReadFile() failed, reason: %v", err)
+	}
+
+	r := &countingReaderAt{r: bytes.NewReader(data)}
+	_, err = ReadHeaderInfo(r, int64(len(data)))
+	if err != nil {
+		t.Fatalf("ReadHeaderInfo() failed, reason: %v", err)
+	}
+	if r.count != headerOnlyReadSize {
+		t.Errorf("bytes read assertion failed, got %d, want %d",
+			r.count, headerOnlyReadSize)
+	}
+
+	// NT headers found past the first read.
+	moved := make([]byte, 0x3000)
+	copy(moved, data[:0x40])
+	ntHeaderOffset := uint32(0x2000)
+	binary.LittleEndian.PutUint32(moved[0x3c:], ntHeaderOffset)
+	copy(moved[ntHeaderOffset:], data[0xe8:0x400])
+	h, err := ReadHeaderInfo(bytes.NewReader(moved), int64(len(moved)))
+	if err != nil {
+		t.Fatalf("ReadHeaderInfo() failed, reason: %v", err)
+	}
+	if h.NtHeader.FileHeader.NumberOfSections == 0 ||
+		len(h.Sections) != int(h.NtHeader.FileHeader.NumberOfSections) {
+		t.Errorf("relocated NT header assertion failed, got %v", h)
+	}
+
+	_, err = ReadHeaderInfo(bytes.NewReader([]byte("not a PE file")), 13)
+	if err == nil {
+		t.Errorf("ReadHeaderInfo() of a non-PE file succeeded")
+	}
+}
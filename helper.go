@@ -8,11 +8,16 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"golang.org/x/text/encoding/unicode"
+	"math"
 	"path"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"unicode/utf16"
 )
 
 const (
@@ -90,8 +95,254 @@ var (
 	// ErrOutsideBoundary is reported when attempting to read an address beyond
 	// file image limits.
 	ErrOutsideBoundary = errors.New("reading data outside boundary")
+
+	// ErrTruncatedFile is reported when the file ends before a structure
+	// required to parse the headers. The error returned is a
+	// *TruncatedFileError telling how many bytes were expected.
+	ErrTruncatedFile = errors.New("truncated file")
+
+	// ErrSpecViolation is reported in strict mode when the file violates
+	// the PE specification. The error returned is a *SpecViolationError.
+	ErrSpecViolation = errors.New("PE specification violation")
+
+	// ErrAnomaly is reported by Parse when Options.FailOnAnomaly is set and
+	// the file has one of the selected anomalies. The error returned is an
+	// *AnomalyError.
+	ErrAnomaly = errors.New("PE anomaly found")
+
+	// ErrNoEntryPoint is reported when the AddressOfEntryPoint of the image
+	// is zero, which is allowed for DLLs.
+	ErrNoEntryPoint = errors.New("image has no entry point")
+
+	// ErrFileTooLarge is reported when the content given to NewReader or
+	// NewReaderAt is larger than the 4GB a PE file can span.
+	ErrFileTooLarge = errors.New("file is too large to be a PE file")
+
+	// ErrVAOutsideImage is reported when a virtual address is below the
+	// image base or too far above it to be expressed as an RVA.
+	ErrVAOutsideImage = errors.New("virtual address is outside the image")
+
+	// ErrRVANotInSection is reported when an RVA lies in the headers, in a
+	// gap between sections or beyond the last one.
+	ErrRVANotInSection = errors.New("RVA does not fall within any section")
+
+	// ErrRVANotInFile is reported when an RVA lies in the zero filled part
+	// of a section, past its raw data.
+	ErrRVANotInFile = errors.New("RVA is not backed by the raw data of its section")
+
+	// ErrOffsetNotInSection is reported when a file offset lies in the
+	// headers, between the raw data of sections or in the overlay.
+	ErrOffsetNotInSection = errors.New(
+		"file offset does not fall within the raw data of any section")
+
+	// ErrOffsetNotMapped is reported when a file offset lies in the raw data
+	// of a section past the part the loader maps.
+	ErrOffsetNotMapped = errors.New("file offset is not mapped by its section")
+
+	// ErrRVAOutsideAddressSpace is reported when the image base plus an RVA
+	// does not fit in the address space of the image.
+	ErrRVAOutsideAddressSpace = errors.New(
+		"relative virtual address is outside the address space of the image")
+
+	// ErrMetadataTableOutsideStream is reported when the rows of a metadata
+	// table go beyond the end of the metadata tables stream.
+	ErrMetadataTableOutsideStream = errors.New(
+		"metadata table is outside the tables stream")
+
+	// ErrMetadataStreamOutsideDirectory is reported when a metadata stream
+	// starts beyond the end of the metadata directory or of the file.
+	ErrMetadataStreamOutsideDirectory = errors.New(
+		"metadata stream is outside the metadata directory")
+
+	// ErrMetadataStreamTruncated is reported when a metadata stream goes
+	// beyond the end of the metadata directory or of the file.
+	ErrMetadataStreamTruncated = errors.New(
+		"metadata stream is truncated to the metadata directory")
+
+	// ErrInvalidCodedIndex is reported when a metadata coded index has an
+	// unknown kind or tag.
+	ErrInvalidCodedIndex = errors.New("invalid metadata coded index")
+
+	// ErrInvalidMetadataToken is reported when a metadata token references
+	// a table or a row which does not exist.
+	ErrInvalidMetadataToken = errors.New(
+		"metadata token references a missing table or row")
+
+	// ErrInvalidCompressedUint is reported when a compressed integer found in
+	// a metadata blob or heap does not follow ECMA-335 §II.23.2.
+	ErrInvalidCompressedUint = errors.New("invalid compressed integer")
+
+	// ErrInvalidCustomAttributeBlob is reported when a custom attribute value
+	// or its constructor signature is malformed.
+	ErrInvalidCustomAttributeBlob = errors.New("invalid custom attribute blob")
+
+	// ErrInvalidSignatureBlob is reported when a method, field or type
+	// signature blob is malformed.
+	ErrInvalidSignatureBlob = errors.New("invalid signature blob")
+
+	// ErrUnsupportedCustomAttributeArg is reported when a custom attribute
+	// argument can't be decoded without resolving types from other
+	// assemblies.
+	ErrUnsupportedCustomAttributeArg = errors.New(
+		"unsupported custom attribute argument")
+
+	// ErrGoBuildInfoNotFound is reported when neither a Go build ID nor
+	// the runtime.buildinfo structure are found, the file was probably not
+	// built with the Go toolchain.
+	ErrGoBuildInfoNotFound = errors.New("Go build information not found")
+
+	// ErrInvalidGoBuildInfo is reported when the runtime.buildinfo structure
+	// is malformed.
+	ErrInvalidGoBuildInfo = errors.New("invalid Go build information")
+
+	// ErrInvalidDelphiForm is reported when a Delphi binary form resource
+	// is malformed.
+	ErrInvalidDelphiForm = errors.New("invalid Delphi form")
+
+	// ErrInvalidDelphiPackageInfo is reported when the PACKAGEINFO resource
+	// is malformed.
+	ErrInvalidDelphiPackageInfo = errors.New("invalid Delphi package info")
+
+	// ErrRichSignatureNotFound is reported when decoding a rich header which
+	// does not contain the `Rich` signature followed by the XOR key.
+	ErrRichSignatureNotFound = errors.New("rich header signature not found")
+
+	// ErrDansSignatureNotFound is reported when decoding a rich header which
+	// does not start with the masked `DanS` signature.
+	ErrDansSignatureNotFound = errors.New("rich header DanS signature not found")
+
+	// ErrNotCatalog is reported when the data given to ParseCatalog is not a
+	// security catalog, a PKCS#7 signed certificate trust list.
+	ErrNotCatalog = errors.New("not a security catalog")
+
+	// AnoVAOutsideImage is reported when a virtual address found in a
+	// structure is below the image base or too far above it to be expressed
+	// as an RVA, the structure it points to is not parsed.
+	AnoVAOutsideImage = "%s virtual address is outside the image"
 )
 
+// TruncatedFileError is returned when the file is too small to hold a
+// structure required to parse the headers, which typically happens with
+// partial downloads. It matches ErrTruncatedFile with errors.Is, as well as
+// the error reported before truncation was detected, for instance
+// ErrInvalidElfanewValue.
+type TruncatedFileError struct {
+	// Name of the structure which does not fit in the file.
+	Structure string
+
+	// File offset of the structure.
+	Offset uint32
+
+	// Number of bytes needed from the start of the file to read the
+	// structure.
+	Expected uint64
+
+	// Size of the file.
+	Available uint64
+
+	// The error describing the structure which could not be read.
+	Err error
+}
+
+func (e *TruncatedFileError) Error() string {
+	return fmt.Sprintf("truncated file: %s at offset 0x%x needs %d bytes, "+
+		"only %d available", e.Structure, e.Offset, e.Expected, e.Available)
+}
+
+// Is reports whether the target is ErrTruncatedFile.
+func (e *TruncatedFileError) Is(target error) bool {
+	return target == ErrTruncatedFile
+}
+
+// Unwrap returns the error describing the structure which could not be read.
+func (e *TruncatedFileError) Unwrap() error {
+	return e.Err
+}
+
+// checkTruncated returns a *TruncatedFileError wrapping err when the
+// structure found at offset with the given size extends past the end of the
+// file, and nil otherwise.
+func (pe *File) checkTruncated(structure string, offset, size uint32,
+	err error) error {
+	expected := uint64(offset) + uint64(size)
+	if expected <= pe.size {
+		return nil
+	}
+	return &TruncatedFileError{
+		Structure: structure,
+		Offset:    offset,
+		Expected:  expected,
+		Available: pe.size,
+		Err:       err,
+	}
+}
+
+// SpecViolationError is returned by Parse in strict mode when the file does
+// not follow the PE specification. It matches ErrSpecViolation with
+// errors.Is, as well as the error describing the violation, if any.
+type SpecViolationError struct {
+	// Name of the structure violating the specification.
+	Structure string
+
+	// The anomaly reported for this violation in permissive mode.
+	Violation string
+
+	// The error which caused the violation, if any.
+	Err error
+}
+
+func (e *SpecViolationError) Error() string {
+	msg := fmt.Sprintf("PE specification violation: %s: %s", e.Structure,
+		e.Violation)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is reports whether the target is ErrSpecViolation.
+func (e *SpecViolationError) Is(target error) bool {
+	return target == ErrSpecViolation
+}
+
+// Unwrap returns the error which caused the violation.
+func (e *SpecViolationError) Unwrap() error {
+	return e.Err
+}
+
+// AnomalyError is returned by Parse when Options.FailOnAnomaly is set and
+// the file has one of the selected anomalies. It matches ErrAnomaly with
+// errors.Is.
+type AnomalyError struct {
+	// The selected anomalies found in the file.
+	Anomalies []string
+}
+
+func (e *AnomalyError) Error() string {
+	return "PE anomaly found: " + strings.Join(e.Anomalies, ", ")
+}
+
+// Is reports whether the target is ErrAnomaly.
+func (e *AnomalyError) Is(target error) bool {
+	return target == ErrAnomaly
+}
+
+// violation records a violation of the PE specification found in the given
+// structure. In the default permissive mode, it is reported as an anomaly
+// and parsing goes on, nil is returned. In strict mode, the error aborting
+// the parsing is returned.
+func (pe *File) violation(structure, anomaly string, err error) error {
+	pe.addAnomaly(anomaly)
+	if !pe.opts.Strict {
+		return nil
+	}
+	return &SpecViolationError{
+		Structure: structure,
+		Violation: anomaly,
+		Err:       err,
+	}
+}
+
 // Max returns the larger of x or y.
 func Max(x, y uint32) uint32 {
 	if x < y {
@@ -100,6 +351,19 @@ func Max(x, y uint32) uint32 {
 	return x
 }
 
+// remaining returns the number of bytes from offset to the end of the file,
+// zero when offset is past the end. It is capped to what a uint32 holds, the
+// sizes of the PE structures being 32-bit.
+func (pe *File) remaining(offset uint32) uint32 {
+	if pe.checkRange(offset, 0) != nil {
+		return 0
+	}
+	if n := pe.size - uint64(offset); n < math.MaxUint32 {
+		return uint32(n)
+	}
+	return math.MaxUint32
+}
+
 func min(a, b uint32) uint32 {
 	if a < b {
 		return a
@@ -159,8 +423,8 @@ func IsPrintable(s string) bool {
 	whitespace := " \t\n\r\v\f"
 	special := "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
 	charset := alphabet + numerals + special + whitespace
-	for _, c := range charset {
-		if !strings.Contains(charset, string(c)) {
+	for _, c := range s {
+		if !strings.ContainsRune(charset, c) {
 			return false
 		}
 	}
@@ -169,9 +433,18 @@ func IsPrintable(s string) bool {
 
 // getSectionByRva returns the section containing the given address.
 func (pe *File) getSectionByRva(rva uint32) *Section {
-	for _, section := range pe.Sections {
-		if section.Contains(rva, pe) {
-			return &section
+	if len(pe.sectionMap) != len(pe.Sections) {
+		for _, section := range pe.Sections {
+			if section.Contains(rva, pe) {
+				return &section
+			}
+		}
+		return nil
+	}
+
+	for _, m := range pe.sectionMap {
+		if m.VirtualAddress <= rva && rva < m.VirtualAddress+m.VirtualSize {
+			return &pe.Sections[m.Index]
 		}
 	}
 	return nil
@@ -179,12 +452,11 @@ func (pe *File) getSectionByRva(rva uint32) *Section {
 
 // getSectionByRva returns the section name containing the given address.
 func (pe *File) getSectionNameByRva(rva uint32) string {
-	for _, section := range pe.Sections {
-		if section.Contains(rva, pe) {
-			return section.String()
-		}
+	section := pe.getSectionByRva(rva)
+	if section == nil {
+		return ""
 	}
-	return ""
+	return section.String()
 }
 
 func (pe *File) getSectionByOffset(offset uint32) *Section {
@@ -203,14 +475,66 @@ func (pe *File) getSectionByOffset(offset uint32) *Section {
 	return nil
 }
 
+// GetSectionByRVA returns the section containing the given RVA along with the
+// file offset the address translates to, following the layout the loader
+// maps. Addresses outside of any section give ErrRVANotInSection, addresses
+// in the zero filled tail of a section return the section and
+// ErrRVANotInFile.
+func (pe *File) GetSectionByRVA(rva uint32) (*Section, uint32, error) {
+	for _, m := range pe.sectionMap {
+		if rva < m.VirtualAddress || rva-m.VirtualAddress >= m.VirtualSize ||
+			m.Index >= len(pe.Sections) {
+			continue
+		}
+		section := &pe.Sections[m.Index]
+		delta := rva - m.VirtualAddress
+		if delta >= m.SizeOfRawData {
+			return section, 0, ErrRVANotInFile
+		}
+		return section, m.PointerToRawData + delta, nil
+	}
+	return nil, 0, ErrRVANotInSection
+}
+
+// GetSectionByOffset returns the section whose raw data holds the given file
+// offset along with the RVA it is mapped at. Offsets in the headers, between
+// sections or in the overlay give ErrOffsetNotInSection, raw data past the
+// part of the section the loader maps returns the section and
+// ErrOffsetNotMapped. When the raw data of several sections overlap, the one
+// with the lowest address is returned.
+func (pe *File) GetSectionByOffset(offset uint32) (*Section, uint32, error) {
+	for _, m := range pe.sectionMap {
+		if offset < m.PointerToRawData ||
+			offset-m.PointerToRawData >= m.SizeOfRawData ||
+			m.Index >= len(pe.Sections) {
+			continue
+		}
+		section := &pe.Sections[m.Index]
+		delta := offset - m.PointerToRawData
+		if delta >= m.VirtualSize {
+			return section, 0, ErrOffsetNotMapped
+		}
+		return section, m.VirtualAddress + delta, nil
+	}
+	return nil, 0, ErrOffsetNotInSection
+}
+
 // GetOffsetFromRva returns the file offset corresponding to this RVA.
 func (pe *File) GetOffsetFromRva(rva uint32) uint32 {
 
+	// In low alignment mode, the loader maps the file as is.
+	if pe.isLowAlignment() {
+		if uint64(rva) < pe.size {
+			return rva
+		}
+		return ^uint32(0)
+	}
+
 	// Given a RVA, this method will find the section where the
 	// data lies and return the offset within the file.
 	section := pe.getSectionByRva(rva)
 	if section == nil {
-		if rva < uint32(len(pe.data)) {
+		if uint64(rva) < pe.size {
 			return rva
 		}
 		return ^uint32(0)
@@ -220,8 +544,94 @@ func (pe *File) GetOffsetFromRva(rva uint32) uint32 {
 	return rva - sectionAlignment + fileAlignment
 }
 
+// imageBase returns the preferred base address of the image, as found in the
+// optional header.
+func (pe *File) imageBase() uint64 {
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			return oh64.ImageBase
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			return uint64(oh32.ImageBase)
+		}
+	}
+	return 0
+}
+
+// sizeOfImage returns the size of the image once loaded in memory, as found
+// in the optional header.
+func (pe *File) sizeOfImage() uint32 {
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			return oh64.SizeOfImage
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			return oh32.SizeOfImage
+		}
+	}
+	return 0
+}
+
+// sizeOfHeaders returns the combined size of the headers, as found in the
+// optional header.
+func (pe *File) sizeOfHeaders() uint32 {
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			return oh64.SizeOfHeaders
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			return oh32.SizeOfHeaders
+		}
+	}
+	return 0
+}
+
+// VAToRVA returns the RVA of a virtual address of the image loaded at its
+// preferred base. The computation is done on 64 bits so addresses below the
+// image base, or more than 4GB above it, are reported with ErrVAOutsideImage
+// instead of silently wrapping around.
+func (pe *File) VAToRVA(va uint64) (uint32, error) {
+	imageBase := pe.imageBase()
+	if va < imageBase || va-imageBase > uint64(^uint32(0)) {
+		return 0, ErrVAOutsideImage
+	}
+	return uint32(va - imageBase), nil
+}
+
+// RVAToVA returns the virtual address of an RVA once the image is loaded at
+// its preferred base. Addresses which do not fit in the address space of the
+// image, 4GB for a PE32, are reported with ErrRVAOutsideAddressSpace.
+func (pe *File) RVAToVA(rva uint32) (uint64, error) {
+	imageBase := pe.imageBase()
+	va := imageBase + uint64(rva)
+	if va < imageBase || (!pe.Is64 && va > uint64(^uint32(0))) {
+		return 0, ErrRVAOutsideAddressSpace
+	}
+	return va, nil
+}
+
+// rvaFromVA is like VAToRVA but reports an anomaly naming the field
+// which holds the virtual address when the conversion fails.
+func (pe *File) rvaFromVA(va uint64, field string) (uint32, error) {
+	rva, err := pe.VAToRVA(va)
+	if err != nil {
+		pe.addAnomaly(fmt.Sprintf(AnoVAOutsideImage, field))
+	}
+	return rva, err
+}
+
 // GetRVAFromOffset returns an RVA given an offset.
 func (pe *File) GetRVAFromOffset(offset uint32) uint32 {
+	if pe.isLowAlignment() {
+		return offset
+	}
+
 	section := pe.getSectionByOffset(offset)
 	minAddr := ^uint32(0)
 	if section == nil {
@@ -271,33 +681,41 @@ func (pe *File) getStringAtRVA(rva, maxLen uint32) string {
 
 	section := pe.getSectionByRva(rva)
 	if section == nil {
-		if rva > pe.size {
+		if uint64(rva) > pe.size {
 			return ""
 		}
 
-		end := rva + maxLen
+		end := uint64(rva) + uint64(maxLen)
 		if end > pe.size {
 			end = pe.size
 		}
 		s := pe.GetStringFromData(0, pe.data[rva:end])
+		pe.markCoverage(rva, uint32(len(s))+1)
 		return string(s)
 	}
 	s := pe.GetStringFromData(0, section.Data(rva, maxLen, pe))
+	pe.markCoverage(pe.GetOffsetFromRva(rva), uint32(len(s))+1)
 	return string(s)
 }
 
+// readUnicodeStringAtRVA reads an UTF-16 string of at most maxLength bytes,
+// stopping at the first null code unit.
 func (pe *File) readUnicodeStringAtRVA(rva uint32, maxLength uint32) string {
-	str := ""
+	var chars []uint16
 	offset := pe.GetOffsetFromRva(rva)
 	i := uint32(0)
-	for i = 0; i < maxLength; i += 2 {
-		if offset+i >= pe.size || pe.data[offset+i] == 0 {
+	for i = 0; i+1 < maxLength; i += 2 {
+		if uint64(offset)+uint64(i)+1 >= pe.size {
 			break
 		}
-
-		str += string(pe.data[offset+i])
+		c := binary.LittleEndian.Uint16(pe.data[offset+i:])
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
 	}
-	return str
+	pe.markCoverage(offset, i+2)
+	return string(utf16.Decode(chars))
 }
 
 func (pe *File) readASCIIStringAtOffset(offset, maxLength uint32) (uint32, string) {
@@ -305,12 +723,13 @@ func (pe *File) readASCIIStringAtOffset(offset, maxLength uint32) (uint32, strin
 	i := uint32(0)
 
 	for i = 0; i < maxLength; i++ {
-		if offset+i >= pe.size || pe.data[offset+i] == 0 {
+		if uint64(offset)+uint64(i) >= pe.size || pe.data[offset+i] == 0 {
 			break
 		}
 
 		str += string(pe.data[offset+i])
 	}
+	pe.markCoverage(offset, i+1)
 	return i, str
 }
 
@@ -338,11 +757,12 @@ func (pe *File) GetStringFromData(offset uint32, data []byte) []byte {
 
 // getStringAtOffset returns a string given an offset.
 func (pe *File) getStringAtOffset(offset, size uint32) (string, error) {
-	if offset+size > pe.size {
-		return "", ErrOutsideBoundary
+	if err := pe.checkRange(offset, size); err != nil {
+		return "", err
 	}
 
-	str := string(pe.data[offset : offset+size])
+	pe.markCoverage(offset, size)
+	str := string(pe.data[offset : uint64(offset)+uint64(size)])
 	return strings.Replace(str, "\x00", "", -1), nil
 }
 
@@ -354,32 +774,119 @@ func (pe *File) GetData(rva, length uint32) ([]byte, error) {
 	// will find the section where the data lies and return the data.
 	section := pe.getSectionByRva(rva)
 
-	var end uint32
-	if length > 0 {
-		end = rva + length
-	} else {
-		end = 0
-	}
-
 	if section == nil {
-		if rva < uint32(len(pe.Header)) {
-			return pe.Header[rva:end], nil
-		}
-
-		// Before we give up we check whether the file might contain the data
+		// The data lies in the headers, or the file might contain the data
 		// anyway. There are cases of PE files without sections that rely on
 		// windows loading the first 8291 bytes into memory and assume the data
 		// will be there. A functional file with these characteristics is:
 		// MD5: 0008892cdfbc3bda5ce047c565e52295
 		// SHA-1: c7116b9ff950f86af256defb95b5d4859d4752a9
-
-		if rva < uint32(len(pe.data)) {
+		// In both cases, the RVA is the file offset. A null length, or a length
+		// running past the end of the file, reads up to the end of the file.
+		if uint64(rva) < pe.size {
+			end := pe.size
+			if length > 0 && length < pe.remaining(rva) {
+				end = uint64(rva) + uint64(length)
+			}
+			pe.markCoverage(rva, uint32(end-uint64(rva)))
 			return pe.data[rva:end], nil
 		}
 
 		return nil, errors.New("data at RVA can't be fetched. Corrupt header?")
 	}
-	return section.Data(rva, length, pe), nil
+	data := section.Data(rva, length, pe)
+	pe.markCoverage(pe.GetOffsetFromRva(rva), uint32(len(data)))
+	return data, nil
+}
+
+// rvaNotInFile tells whether some of the size bytes found at the given RVA
+// lie in the virtual-only part of a section, which the loader zero-fills.
+func (pe *File) rvaNotInFile(rva, size uint32) bool {
+	if _, _, err := pe.GetSectionByRVA(rva); err == ErrRVANotInFile {
+		return true
+	}
+	if size == 0 || rva+size-1 < rva {
+		return false
+	}
+	_, _, err := pe.GetSectionByRVA(rva + size - 1)
+	return err == ErrRVANotInFile
+}
+
+// GetMappedData returns length bytes found at the given RVA as the loader
+// maps them in memory. Unlike GetData, which returns the raw data of the file
+// and may return less than requested, the part of a section beyond its raw
+// data (VirtualSize > SizeOfRawData) and the gaps between sections read as
+// zeros, and the returned slice is a copy of exactly length bytes. It fails
+// with ErrOutsideBoundary when the range goes past the end of the last
+// section.
+func (pe *File) GetMappedData(rva, length uint32) ([]byte, error) {
+	// Check the range before allocating, the size is usually read from the
+	// file.
+	imageEnd := pe.size
+	if n := len(pe.sectionMap); n > 0 {
+		imageEnd = uint64(pe.sectionMap[n-1].VirtualAddress) +
+			uint64(pe.sectionMap[n-1].VirtualSize)
+	}
+	if uint64(rva)+uint64(length) > imageEnd {
+		return nil, ErrOutsideBoundary
+	}
+
+	buf := make([]byte, length)
+	for done := uint32(0); done < length; {
+		n, err := pe.copyMapped(buf[done:], rva+done)
+		if err != nil {
+			return nil, err
+		}
+		done += n
+	}
+	return buf, nil
+}
+
+// copyMapped fills dst with the mapped bytes found at rva up to the end of
+// the region (headers, section or gap) rva lies in, and returns the number
+// of bytes consumed. dst is expected to be zeroed.
+func (pe *File) copyMapped(dst []byte, rva uint32) (uint32, error) {
+	want := uint32(len(dst))
+
+	// Data before the first section comes from the headers, images without
+	// sections are mapped as is.
+	if len(pe.sectionMap) == 0 || rva < pe.sectionMap[0].VirtualAddress {
+		n := want
+		if len(pe.sectionMap) > 0 {
+			n = min(n, pe.sectionMap[0].VirtualAddress-rva)
+		}
+		if raw := min(n, pe.remaining(rva)); raw > 0 {
+			copy(dst[:raw], pe.data[rva:])
+			pe.markCoverage(rva, raw)
+		} else if len(pe.sectionMap) == 0 {
+			return 0, ErrRVANotInSection
+		}
+		return n, nil
+	}
+
+	for i, m := range pe.sectionMap {
+		if rva < m.VirtualAddress {
+			continue
+		}
+		delta := rva - m.VirtualAddress
+		if delta >= m.VirtualSize {
+			// The gap up to the next section is zero-filled.
+			if i+1 < len(pe.sectionMap) && rva < pe.sectionMap[i+1].VirtualAddress {
+				return min(want, pe.sectionMap[i+1].VirtualAddress-rva), nil
+			}
+			continue
+		}
+		n := min(want, m.VirtualSize-delta)
+		if delta < m.SizeOfRawData {
+			raw := min(n, m.SizeOfRawData-delta)
+			offset := m.PointerToRawData + delta
+			copy(dst[:raw], pe.data[offset:offset+raw])
+			pe.markCoverage(offset, raw)
+		}
+		return n, nil
+	}
+
+	return 0, ErrRVANotInSection
 }
 
 // The alignment factor (in bytes) that is used to align the raw data of sections
@@ -391,13 +898,13 @@ func (pe *File) adjustFileAlignment(va uint32) uint32 {
 	var fileAlignment uint32
 	switch pe.Is64 {
 	case true:
-		fileAlignment = pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).FileAlignment
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			fileAlignment = oh64.FileAlignment
+		}
 	case false:
-		fileAlignment = pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).FileAlignment
-	}
-
-	if fileAlignment > FileAlignmentHardcodedValue && fileAlignment%2 != 0 {
-		pe.Anomalies = append(pe.Anomalies, ErrInvalidFileAlignment)
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			fileAlignment = oh32.FileAlignment
+		}
 	}
 
 	if fileAlignment < FileAlignmentHardcodedValue {
@@ -421,19 +928,25 @@ func (pe *File) adjustSectionAlignment(va uint32) uint32 {
 
 	switch pe.Is64 {
 	case true:
-		fileAlignment = pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).FileAlignment
-		sectionAlignment = pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).SectionAlignment
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			fileAlignment = oh64.FileAlignment
+			sectionAlignment = oh64.SectionAlignment
+		}
 	case false:
-		fileAlignment = pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).FileAlignment
-		sectionAlignment = pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).SectionAlignment
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			fileAlignment = oh32.FileAlignment
+			sectionAlignment = oh32.SectionAlignment
+		}
 	}
 
-	if fileAlignment < FileAlignmentHardcodedValue &&
-		fileAlignment != sectionAlignment {
-		pe.Anomalies = append(pe.Anomalies, ErrInvalidSectionAlignment)
+	// Below the page size, the loader maps the file as is and requires the
+	// sections to be at the same address in memory and in the file. Aligning
+	// the address to FileAlignment, which can be larger than
+	// SectionAlignment in crafted files, would move the section.
+	if pe.isLowAlignment() {
+		return va
 	}
-
-	if sectionAlignment < 0x1000 { // page size
+	if sectionAlignment == 0 {
 		sectionAlignment = fileAlignment
 	}
 
@@ -445,23 +958,47 @@ func (pe *File) adjustSectionAlignment(va uint32) uint32 {
 	return va
 }
 
+// isLowAlignment returns true when SectionAlignment is lower than the page
+// size. The loader then maps the file as is, RVAs being equal to offsets.
+func (pe *File) isLowAlignment() bool {
+	var sectionAlignment uint32
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			sectionAlignment = oh64.SectionAlignment
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			sectionAlignment = oh32.SectionAlignment
+		}
+	}
+	return sectionAlignment != 0 && sectionAlignment < 0x1000
+}
+
 // alignDword aligns the offset on a 32-bit boundary.
 func alignDword(offset, base uint32) uint32 {
 	return ((offset + base + 3) & 0xfffffffc) - (base & 0xfffffffc)
 }
 
-// stringInSlice checks weather a string exists in a slice of strings.
-func stringInSlice(a string, list []string) bool {
-	for _, b := range list {
-		if b == a {
-			return true
+// flagNames returns the names of the flags for which isSet returns true,
+// ordered by flag value. names maps the flags, of an unsigned integer type,
+// to their names.
+func flagNames(names interface{}, isSet func(flag uint64) bool) []string {
+	m := reflect.ValueOf(names)
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Uint() < keys[j].Uint() })
+
+	var values []string
+	for _, k := range keys {
+		if isSet(k.Uint()) {
+			values = append(values, m.MapIndex(k).String())
 		}
 	}
-	return false
+	return values
 }
 
-// intInSlice checks weather a uint32 exists in a slice of uint32.
-func intInSlice(a uint32, list []uint32) bool {
+// stringInSlice checks weather a string exists in a slice of strings.
+func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
 			return true
@@ -483,9 +1020,16 @@ func (pe *File) IsDriver() bool {
 	// Checking if any section characteristics have the IMAGE_SCN_MEM_NOT_PAGED
 	// flag set is not reliable either.
 
+	return pe.isDriver(pe.imports())
+}
+
+// isDriver is IsDriver over imports already read, which avoids reading them
+// again from the file under the LazyImports option.
+func (pe *File) isDriver(imports []Import) bool {
+
 	// If there's still no import directory (the PE doesn't have one or it's
 	// malformed), give up.
-	if len(pe.Imports) == 0 {
+	if len(imports) == 0 {
 		return false
 	}
 
@@ -494,7 +1038,7 @@ func (pe *File) IsDriver() bool {
 	// be a driver.
 	systemDLLs := []string{"ntoskrnl.exe", "hal.dll", "ndis.sys",
 		"bootvid.dll", "kdcom.dll"}
-	for _, dll := range pe.Imports {
+	for _, dll := range imports {
 		if stringInSlice(strings.ToLower(dll.Name), systemDLLs) {
 			return true
 		}
@@ -503,15 +1047,15 @@ func (pe *File) IsDriver() bool {
 	// If still we couldn't tell, check common driver section with combination
 	// of IMAGE_SUBSYSTEM_NATIVE or IMAGE_SUBSYSTEM_NATIVE_WINDOWS.
 	subsystem := ImageOptionalHeaderSubsystemType(0)
-	oh32 := ImageOptionalHeader32{}
-	oh64 := ImageOptionalHeader64{}
 	switch pe.Is64 {
 	case true:
-		oh64 = pe.NtHeader.OptionalHeader.(ImageOptionalHeader64)
-		subsystem = oh64.Subsystem
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			subsystem = oh64.Subsystem
+		}
 	case false:
-		oh32 = pe.NtHeader.OptionalHeader.(ImageOptionalHeader32)
-		subsystem = oh32.Subsystem
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			subsystem = oh32.Subsystem
+		}
 	}
 	commonDriverSectionNames := []string{"page", "paged", "nonpage", "init"}
 	for _, section := range pe.Sections {
@@ -561,23 +1105,29 @@ func (pe *File) Checksum() uint32 {
 	// `CheckSum` field position in optional PE headers is always 64 for PE and PE+.
 	checksumOffset := optionalHeaderOffset + 64
 
-	// Verify the data is DWORD-aligned and add padding if needed
+	// Verify the data is DWORD-aligned and pad the last DWORD if needed.
+	// The padding is done on a local copy so the mapped data is never
+	// modified, which keeps this method safe for concurrent use.
 	remainder := pe.size % 4
 	dataLen := pe.size
 	if remainder > 0 {
 		dataLen = pe.size + (4 - remainder)
-		paddedBytes := make([]byte, 4-remainder)
-		pe.data = append(pe.data, paddedBytes...)
 	}
 
-	for i := uint32(0); i < dataLen; i += 4 {
+	for i := uint64(0); i < dataLen; i += 4 {
 		// Skip the checksum field.
-		if i == checksumOffset {
+		if i == uint64(checksumOffset) {
 			continue
 		}
 
 		// Read DWORD from file.
-		currentDword = binary.LittleEndian.Uint32(pe.data[i:])
+		if i+4 > pe.size {
+			var lastDword [4]byte
+			copy(lastDword[:], pe.data[i:pe.size])
+			currentDword = binary.LittleEndian.Uint32(lastDword[:])
+		} else {
+			currentDword = binary.LittleEndian.Uint32(pe.data[i:])
+		}
 
 		// Calculate checksum.
 		checksum = (checksum & 0xffffffff) + uint64(currentDword) + (checksum >> 32)
@@ -596,89 +1146,178 @@ func (pe *File) Checksum() uint32 {
 	return uint32(checksum)
 }
 
+// checkRange makes sure the size bytes found at the given file offset lie
+// within the file. The end of the range is computed on 64 bits so it never
+// wraps around, callers must slice the range the same way. As expected by
+// the readers built on it, even an empty range must start within the file.
+// It fails with ErrOutsideBoundary otherwise.
+func (pe *File) checkRange(offset, size uint32) error {
+	end := uint64(offset) + uint64(size)
+	if uint64(offset) >= pe.size || end > pe.size {
+		return ErrOutsideBoundary
+	}
+	return nil
+}
+
 // ReadUint64 read a uint64 from a buffer.
 func (pe *File) ReadUint64(offset uint32) (uint64, error) {
-	if offset+8 > pe.size {
-		return 0, ErrOutsideBoundary
+	if err := pe.checkRange(offset, 8); err != nil {
+		return 0, err
 	}
 
+	pe.markCoverage(offset, 8)
 	return binary.LittleEndian.Uint64(pe.data[offset:]), nil
 }
 
 // ReadUint32 read a uint32 from a buffer.
 func (pe *File) ReadUint32(offset uint32) (uint32, error) {
-	if offset > pe.size-4 {
-		return 0, ErrOutsideBoundary
+	if err := pe.checkRange(offset, 4); err != nil {
+		return 0, err
 	}
 
+	pe.markCoverage(offset, 4)
 	return binary.LittleEndian.Uint32(pe.data[offset:]), nil
 }
 
 // ReadUint16 read a uint16 from a buffer.
 func (pe *File) ReadUint16(offset uint32) (uint16, error) {
-	if offset > pe.size-2 {
-		return 0, ErrOutsideBoundary
+	if err := pe.checkRange(offset, 2); err != nil {
+		return 0, err
 	}
 
+	pe.markCoverage(offset, 2)
 	return binary.LittleEndian.Uint16(pe.data[offset:]), nil
 }
 
 // ReadUint8 read a uint8 from a buffer.
 func (pe *File) ReadUint8(offset uint32) (uint8, error) {
-	if offset+1 > pe.size {
-		return 0, ErrOutsideBoundary
+	if err := pe.checkRange(offset, 1); err != nil {
+		return 0, err
 	}
 
+	pe.markCoverage(offset, 1)
 	b := pe.data[offset : offset+1][0]
 	return uint8(b), nil
 }
 
 func (pe *File) structUnpack(iface interface{}, offset, size uint32) (err error) {
-	// Boundary check
-	totalSize := offset + size
-
-	// Integer overflow
-	if (totalSize > offset) != (size > 0) {
-		return ErrOutsideBoundary
-	}
-
-	if offset >= pe.size || totalSize > pe.size {
-		return ErrOutsideBoundary
+	if err := pe.checkRange(offset, size); err != nil {
+		return err
 	}
 
-	buf := bytes.NewReader(pe.data[offset : offset+size])
+	buf := bytes.NewReader(pe.data[offset : uint64(offset)+uint64(size)])
 	err = binary.Read(buf, binary.LittleEndian, iface)
 	if err != nil {
 		return err
 	}
+	pe.markCoverage(offset, size)
 	return nil
 }
 
-// ReadBytesAtOffset returns a byte array from offset.
-func (pe *File) ReadBytesAtOffset(offset, size uint32) ([]byte, error) {
-	// Boundary check
-	totalSize := offset + size
+// StructUnpack decodes the little-endian binary representation found at the
+// given file offset into iface, which must be a pointer to a fixed-size value
+// (see encoding/binary). It fails with ErrOutsideBoundary when the structure
+// does not fit in the file. It is the building block of every parser in this
+// package and is exported to allow implementing custom parsers on top of a
+// File.
+func (pe *File) StructUnpack(iface interface{}, offset, size uint32) error {
+	return pe.structUnpack(iface, offset, size)
+}
 
-	// Integer overflow
-	if (totalSize > offset) != (size > 0) {
-		return nil, ErrOutsideBoundary
+// OffsetFromRVA returns the file offset corresponding to the given RVA. Unlike
+// GetOffsetFromRva, it reports an error with ErrOutsideBoundary when the RVA
+// can not be mapped to a location within the file.
+func (pe *File) OffsetFromRVA(rva uint32) (uint32, error) {
+	offset := pe.GetOffsetFromRva(rva)
+	if offset == ^uint32(0) {
+		return 0, ErrOutsideBoundary
 	}
+	if err := pe.checkRange(offset, 0); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
 
-	if offset >= pe.size || totalSize > pe.size {
-		return nil, ErrOutsideBoundary
+// ReadBytesAtRVA returns size bytes read from the given RVA.
+func (pe *File) ReadBytesAtRVA(rva, size uint32) ([]byte, error) {
+	offset, err := pe.OffsetFromRVA(rva)
+	if err != nil {
+		return nil, err
 	}
+	return pe.ReadBytesAtOffset(offset, size)
+}
 
-	return pe.data[offset : offset+size], nil
+// ReadCStringAtRVA returns the null-terminated ASCII string located at the
+// given RVA, reading at most maxLength bytes.
+func (pe *File) ReadCStringAtRVA(rva, maxLength uint32) (string, error) {
+	offset, err := pe.OffsetFromRVA(rva)
+	if err != nil {
+		return "", err
+	}
+	_, str := pe.readASCIIStringAtOffset(offset, maxLength)
+	return str, nil
+}
+
+// ReadUTF16AtRVA returns the null-terminated UTF-16LE string located at the
+// given RVA, reading at most maxLength bytes.
+func (pe *File) ReadUTF16AtRVA(rva, maxLength uint32) (string, error) {
+	offset, err := pe.OffsetFromRVA(rva)
+	if err != nil {
+		return "", err
+	}
+
+	end := uint64(offset) + uint64(maxLength)
+	if end > pe.size {
+		end = pe.size
+	}
+
+	// Look for the null terminator on a 2-bytes boundary.
+	b := pe.data[offset:end]
+	n := len(b) &^ 1
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i] == 0 && b[i+1] == 0 {
+			n = i
+			break
+		}
+	}
+
+	pe.markCoverage(offset, uint32(n)+2)
+
+	decoder := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
+	s, err := decoder.Bytes(b[:n])
+	if err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// ReadBytesAtOffset returns a byte array from offset.
+func (pe *File) ReadBytesAtOffset(offset, size uint32) ([]byte, error) {
+	if err := pe.checkRange(offset, size); err != nil {
+		return nil, err
+	}
+
+	pe.markCoverage(offset, size)
+	return pe.data[offset : uint64(offset)+uint64(size)], nil
 }
 
 // DecodeUTF16String decodes the UTF16 string from the byte slice.
+// The string ends at the first null code unit, or at the end of the slice.
+// Code units are 2 bytes aligned so a null high byte followed by a null low
+// byte is not mistaken for the terminator.
 func DecodeUTF16String(b []byte) (string, error) {
-	n := bytes.Index(b, []byte{0, 0})
+	n := len(b) &^ 1
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i] == 0 && b[i+1] == 0 {
+			n = i
+			break
+		}
+	}
 	if n == 0 {
 		return "", nil
 	}
 	decoder := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()
-	s, err := decoder.Bytes(b[0 : n+1])
+	s, err := decoder.Bytes(b[:n])
 	if err != nil {
 		return "", err
 	}
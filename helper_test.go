@@ -5,6 +5,8 @@
 package pe
 
 import (
+	"bytes"
+	"io/ioutil"
 	"testing"
 )
 
@@ -97,3 +99,290 @@ func TestIsDriver(t *testing.T) {
 		})
 	}
 }
+
+func TestLowLevelReaders(t *testing.T) {
+
+	tests := []struct {
+		in        string
+		cStrRVA   uint32
+		cStr      string
+		utf16RVA  uint32
+		utf16Str  string
+		structRVA uint32
+	}{
+		// The version resource starts with a VS_VERSIONINFO structure, the
+		// key string VS_VERSION_INFO starts at offset 6.
+		{getAbsoluteFilePath("test/putty.exe"), 0xc7dbc, "GDI32.dll",
+			0x124838 + 6, "VS_VERSION_INFO", 0x124838},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			cStr, err := file.ReadCStringAtRVA(tt.cStrRVA, 0x100)
+			if err != nil || cStr != tt.cStr {
+				t.Errorf("ReadCStringAtRVA(0x%x) got %v (%v), want %v",
+					tt.cStrRVA, cStr, err, tt.cStr)
+			}
+
+			utf16Str, err := file.ReadUTF16AtRVA(tt.utf16RVA, 0x100)
+			if err != nil || utf16Str != tt.utf16Str {
+				t.Errorf("ReadUTF16AtRVA(0x%x) got %v (%v), want %v",
+					tt.utf16RVA, utf16Str, err, tt.utf16Str)
+			}
+
+			offset, err := file.OffsetFromRVA(tt.structRVA)
+			if err != nil {
+				t.Fatalf("OffsetFromRVA(0x%x) failed, reason: %v", tt.structRVA, err)
+			}
+			var length uint16
+			err = file.StructUnpack(&length, offset, 2)
+			if err != nil {
+				t.Fatalf("StructUnpack(0x%x) failed, reason: %v", offset, err)
+			}
+			b, err := file.ReadBytesAtRVA(tt.structRVA, uint32(length))
+			if err != nil || len(b) != int(length) {
+				t.Errorf("ReadBytesAtRVA(0x%x) got %d bytes (%v), want %d",
+					tt.structRVA, len(b), err, length)
+			}
+
+			_, err = file.OffsetFromRVA(0xfffffff0)
+			if err != ErrOutsideBoundary {
+				t.Errorf("OffsetFromRVA(0xfffffff0) got %v, want %v",
+					err, ErrOutsideBoundary)
+			}
+		})
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		offset uint32
+		size   uint32
+		err    error
+	}{
+		{"whole file", 0, 0x100, nil},
+		{"last byte", 0xff, 1, nil},
+		{"empty range in file", 0x80, 0, nil},
+		{"empty range at end of file", 0x100, 0, ErrOutsideBoundary},
+		{"one byte past the end", 0xff, 2, ErrOutsideBoundary},
+		{"offset past the end", 0x200, 1, ErrOutsideBoundary},
+		{"size wraps around", 0x20, 0xfffffff0, ErrOutsideBoundary},
+		{"end wraps to zero", 0x10, 0xfffffff0, ErrOutsideBoundary},
+		{"max size", 0, 0xffffffff, ErrOutsideBoundary},
+		{"max offset", 0xffffffff, 1, ErrOutsideBoundary},
+		{"last uint64", 0xf8, 8, nil},
+		{"uint64 past the end", 0xf9, 8, ErrOutsideBoundary},
+		{"uint32 past the end", 0xfd, 4, ErrOutsideBoundary},
+		{"uint16 past the end", 0xff, 2, ErrOutsideBoundary},
+		{"uint8 at the end", 0x100, 1, ErrOutsideBoundary},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := NewBytes(make([]byte, 0x100), &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes failed, reason: %v", err)
+			}
+
+			err = file.checkRange(tt.offset, tt.size)
+			if err != tt.err {
+				t.Errorf("checkRange(0x%x, 0x%x) got %v, want %v",
+					tt.offset, tt.size, err, tt.err)
+			}
+
+			// The readers built on checkRange must agree with it.
+			_, err = file.ReadBytesAtOffset(tt.offset, tt.size)
+			if err != tt.err {
+				t.Errorf("ReadBytesAtOffset(0x%x, 0x%x) got %v, want %v",
+					tt.offset, tt.size, err, tt.err)
+			}
+			switch tt.size {
+			case 1:
+				_, err = file.ReadUint8(tt.offset)
+			case 2:
+				_, err = file.ReadUint16(tt.offset)
+			case 4:
+				_, err = file.ReadUint32(tt.offset)
+			case 8:
+				_, err = file.ReadUint64(tt.offset)
+			}
+			if err != tt.err {
+				t.Errorf("ReadUint%d(0x%x) got %v, want %v",
+					tt.size*8, tt.offset, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestGetMappedData(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/pspluginwkr.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	// The .data section is mapped at 0x24000 for 0x2510 bytes, only the
+	// first 0x400 bytes are backed by the file at 0x23200. The .rsrc section
+	// starts at 0x27000 and the last section ends at 0x29e00.
+	tests := []struct {
+		name   string
+		rva    uint32
+		length uint32
+		out    []byte
+		err    error
+	}{
+		{"headers", 0, 2, []byte("MZ"), nil},
+		{"raw data then virtual part", 0x243f8, 0x10,
+			append(append([]byte{}, data[0x235f8:0x23600]...), make([]byte, 8)...), nil},
+		{"virtual part", 0x24800, 0x10, make([]byte, 0x10), nil},
+		{"gap between sections", 0x26500, 0x20, make([]byte, 0x20), nil},
+		{"past the last section", 0x29df0, 0x20, nil, ErrOutsideBoundary},
+		{"wraps around", 0xfffffff0, 0x20, nil, ErrOutsideBoundary},
+	}
+
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := file.GetMappedData(tt.rva, tt.length)
+			if err != tt.err {
+				t.Fatalf("GetMappedData(0x%x, 0x%x) error assertion failed, got %v, want %v",
+					tt.rva, tt.length, err, tt.err)
+			}
+			if !bytes.Equal(got, tt.out) {
+				t.Errorf("GetMappedData(0x%x, 0x%x) got %x, want %x",
+					tt.rva, tt.length, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestVAToRVA(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		va  uint64
+		rva uint32
+		err error
+	}{
+		// PE32+ with an image base of 0x140000000.
+		{getAbsoluteFilePath("test/putty.exe"), 0x140001000, 0x1000, nil},
+		{getAbsoluteFilePath("test/putty.exe"), 0x140000000, 0x0, nil},
+		{getAbsoluteFilePath("test/putty.exe"), 0x13ffff000, 0x0, ErrVAOutsideImage},
+		{getAbsoluteFilePath("test/putty.exe"), 0x240000000, 0x0, ErrVAOutsideImage},
+		// PE32 with an image base of 0x400000.
+		{getAbsoluteFilePath("test/impbyord.exe"), 0x401000, 0x1000, nil},
+		{getAbsoluteFilePath("test/impbyord.exe"), 0x1000, 0x0, ErrVAOutsideImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{Fast: true})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			rva, err := file.VAToRVA(tt.va)
+			if err != tt.err {
+				t.Fatalf("VAToRVA(0x%x) error assertion failed, got %v, want %v",
+					tt.va, err, tt.err)
+			}
+			if rva != tt.rva {
+				t.Errorf("VAToRVA(0x%x) got 0x%x, want 0x%x", tt.va, rva, tt.rva)
+			}
+			if err != nil {
+				return
+			}
+			if va, err := file.RVAToVA(rva); err != nil || va != tt.va {
+				t.Errorf("RVAToVA(0x%x) got (0x%x, %v), want 0x%x", rva, va, err, tt.va)
+			}
+		})
+	}
+}
+
+func TestRVAToVA(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		is64      bool
+		imageBase uint64
+		rva       uint32
+		va        uint64
+		err       error
+	}{
+		{"PE32", false, 0x400000, 0x1000, 0x401000, nil},
+		{"PE32 overflow", false, 0x400000, 0xfffff000, 0, ErrRVAOutsideAddressSpace},
+		{"PE32 top", false, 0x80000000, 0x7fffffff, 0xffffffff, nil},
+		{"PE32+", true, 0x140000000, 0xffffffff, 0x23fffffff, nil},
+		{"PE32+ overflow", true, 0xffffffffffff0000, 0x10000, 0, ErrRVAOutsideAddressSpace},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &File{}
+			file.Is64, file.Is32 = tt.is64, !tt.is64
+			switch tt.is64 {
+			case true:
+				file.NtHeader.OptionalHeader = ImageOptionalHeader64{
+					ImageBase: tt.imageBase}
+			case false:
+				file.NtHeader.OptionalHeader = ImageOptionalHeader32{
+					ImageBase: uint32(tt.imageBase)}
+			}
+
+			va, err := file.RVAToVA(tt.rva)
+			if err != tt.err {
+				t.Fatalf("RVAToVA(0x%x) error assertion failed, got %v, want %v",
+					tt.rva, err, tt.err)
+			}
+			if va != tt.va {
+				t.Errorf("RVAToVA(0x%x) got 0x%x, want 0x%x", tt.rva, va, tt.va)
+			}
+		})
+	}
+}
+
+func TestIsPrintable(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out bool
+	}{
+		{"", true},
+		{"KERNEL32.dll", true},
+		{"a b\t~", true},
+		{"\xf3P\xcb1", false},
+		{"dll\x01", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := IsPrintable(tt.in); got != tt.out {
+				t.Errorf("IsPrintable(%q) got %v, want %v", tt.in, got, tt.out)
+			}
+		})
+	}
+}
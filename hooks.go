@@ -0,0 +1,123 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+// DirectoryHandler is a custom data directory parser. It receives the File
+// being parsed, the directory entry RVA and size as found in the optional
+// header, and the raw directory content. For the certificate directory, va
+// is a file offset.
+type DirectoryHandler func(pe *File, va, size uint32, data []byte) error
+
+// SectionHandler is a custom section parser. It receives the File being
+// parsed, the section and its raw content.
+type SectionHandler func(pe *File, section *Section, data []byte) error
+
+// hooks holds the custom handlers registered on a File.
+type hooks struct {
+	directoryHandlers map[ImageDirectoryEntry][]DirectoryHandler
+	sectionHandlers   map[string][]SectionHandler
+}
+
+// OnDirectory registers a handler invoked during Parse for the given data
+// directory entry, after the built-in parser of that directory, if any, ran.
+// Handlers are invoked even if the built-in parser is disabled with one of
+// the Omit*Directory options, which allows replacing it entirely, and for
+// directories the package does not parse such as the Architecture one.
+// Handlers are not invoked when the directory is empty, lies outside the
+// image or when the Fast option is set. Handlers must be registered before
+// calling Parse.
+func (pe *File) OnDirectory(entry ImageDirectoryEntry, handler DirectoryHandler) {
+	if pe.directoryHandlers == nil {
+		pe.directoryHandlers = make(map[ImageDirectoryEntry][]DirectoryHandler)
+	}
+	pe.directoryHandlers[entry] = append(pe.directoryHandlers[entry], handler)
+}
+
+// OnSection registers a handler invoked during Parse for every section
+// named name, right after the section headers are parsed. Handlers must be
+// registered before calling Parse.
+func (pe *File) OnSection(name string, handler SectionHandler) {
+	if pe.sectionHandlers == nil {
+		pe.sectionHandlers = make(map[string][]SectionHandler)
+	}
+	pe.sectionHandlers[name] = append(pe.sectionHandlers[name], handler)
+}
+
+// runDirectoryHandlers invokes the custom handlers of a data directory.
+func (pe *File) runDirectoryHandlers(entry ImageDirectoryEntry, va, size uint32) {
+	handlers := pe.directoryHandlers[entry]
+	if len(handlers) == 0 {
+		return
+	}
+
+	var data []byte
+	var err error
+	if entry == ImageDirectoryEntryCertificate {
+		data, err = pe.ReadBytesAtOffset(va, size)
+	} else {
+		data, err = pe.GetData(va, size)
+	}
+	if err != nil {
+		pe.warnf(entry.String(), 0, "failed to read data directory %s, reason: %v",
+			entry.String(), err)
+		return
+	}
+
+	for _, handler := range handlers {
+		func() {
+			// keep parsing even though a custom handler panics.
+			defer func() {
+				if e := recover(); e != nil {
+					pe.errorf(entry.String(), 0,
+						"unhandled exception in custom handler for data directory %s, reason: %v",
+						entry.String(), e)
+				}
+			}()
+
+			err := handler(pe, va, size, data)
+			if err != nil {
+				pe.warnf(entry.String(), 0,
+					"custom handler failed for data directory %s, reason: %v",
+					entry.String(), err)
+			}
+		}()
+	}
+}
+
+// runSectionHandlers invokes the custom handlers of every section.
+func (pe *File) runSectionHandlers() {
+	if len(pe.sectionHandlers) == 0 {
+		return
+	}
+
+	for i := range pe.Sections {
+		section := &pe.Sections[i]
+		handlers := pe.sectionHandlers[section.String()]
+		if len(handlers) == 0 {
+			continue
+		}
+
+		data := section.Data(0, 0, pe)
+		for _, handler := range handlers {
+			func() {
+				// keep parsing even though a custom handler panics.
+				defer func() {
+					if e := recover(); e != nil {
+						pe.errorf("SectionHeader", section.Header.PointerToRawData,
+							"unhandled exception in custom handler for section %s, reason: %v",
+							section.String(), e)
+					}
+				}()
+
+				err := handler(pe, section, data)
+				if err != nil {
+					pe.warnf("SectionHeader", section.Header.PointerToRawData,
+						"custom handler failed for section %s, reason: %v",
+						section.String(), err)
+				}
+			}()
+		}
+	}
+}
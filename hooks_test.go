@@ -0,0 +1,118 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"testing"
+)
+
+func TestOnDirectory(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		entry ImageDirectoryEntry
+		opts  Options
+		size  uint32
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), ImageDirectoryEntryImport,
+			Options{}, 0xb4},
+		{getAbsoluteFilePath("test/putty.exe"), ImageDirectoryEntryImport,
+			Options{OmitImportDirectory: true}, 0xb4},
+		{getAbsoluteFilePath("test/putty.exe"), ImageDirectoryEntryCertificate,
+			Options{}, 0x3d90},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in+tt.entry.String(), func(t *testing.T) {
+			file, err := New(tt.in, &tt.opts)
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+
+			called := 0
+			file.OnDirectory(tt.entry, func(pe *File, va, size uint32, data []byte) error {
+				called++
+				if size != tt.size || uint32(len(data)) != tt.size {
+					t.Errorf("directory handler size assertion failed, got %v (%d bytes), want %v",
+						size, len(data), tt.size)
+				}
+				return nil
+			})
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+			if called != 1 {
+				t.Errorf("directory handler called %d times, want 1", called)
+			}
+			if tt.opts.OmitImportDirectory && len(file.Imports) != 0 {
+				t.Errorf("import directory should not have been parsed")
+			}
+		})
+	}
+}
+
+func TestOnDirectoryPanic(t *testing.T) {
+	in := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(in, &Options{Strict: true})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", in, err)
+	}
+
+	called := 0
+	file.OnDirectory(ImageDirectoryEntryImport, func(pe *File, va, size uint32, data []byte) error {
+		panic("custom handler panic")
+	})
+	file.OnDirectory(ImageDirectoryEntryImport, func(pe *File, va, size uint32, data []byte) error {
+		called++
+		return nil
+	})
+
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", in, err)
+	}
+	if called != 1 {
+		t.Errorf("directory handler called %d times, want 1", called)
+	}
+	if len(file.Imports) == 0 {
+		t.Errorf("import directory should have been parsed")
+	}
+	status := file.Directories()[ImageDirectoryEntryImport].Status
+	if status != DataDirectoryParsed {
+		t.Errorf("import directory status assertion failed, got %v, want %v",
+			status, DataDirectoryParsed)
+	}
+}
+
+func TestOnSection(t *testing.T) {
+	in := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(in, &Options{Fast: true})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", in, err)
+	}
+
+	var got []string
+	file.OnSection(".pdata", func(pe *File, section *Section, data []byte) error {
+		got = append(got, section.String())
+		if uint32(len(data)) != section.Header.SizeOfRawData {
+			t.Errorf("section handler data size assertion failed, got %v, want %v",
+				len(data), section.Header.SizeOfRawData)
+		}
+		return nil
+	})
+	file.OnSection(".text", func(pe *File, section *Section, data []byte) error {
+		panic("custom handlers must not abort parsing")
+	})
+
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", in, err)
+	}
+	if len(got) != 1 || got[0] != ".pdata" {
+		t.Errorf("section handler assertion failed, got %v, want [.pdata]", got)
+	}
+}
@@ -4,12 +4,31 @@
 
 package pe
 
+// AnoIATOrphanEntry is reported when a non null IAT slot does not belong to
+// any import or delay import descriptor, which is a common indicator of
+// hooking or code injection.
+var AnoIATOrphanEntry = "IAT entry does not belong to any import descriptor"
+
 // IATEntry represents an entry inside the IAT.
 type IATEntry struct {
 	Index   uint32      `json:"index"`
 	Rva     uint32      `json:"rva"`
 	Value   interface{} `json:"value,omitempty"`
 	Meaning string      `json:"meaning"`
+
+	// Module is the name of the module the slot imports from.
+	Module string `json:"module,omitempty"`
+
+	// Function is the name of the imported function, or its ordinal in the
+	// form `#ordinal` for imports by ordinal.
+	Function string `json:"function,omitempty"`
+
+	// Delayed is true when the slot belongs to a delay import descriptor.
+	Delayed bool `json:"delayed,omitempty"`
+
+	// Orphan is true when the slot is not null and does not belong to any
+	// import or delay import descriptor.
+	Orphan bool `json:"orphan,omitempty"`
 }
 
 // The structure and content of the import address table are identical to those
@@ -27,7 +46,7 @@ type IATEntry struct {
 // PE to begin with.
 func (pe *File) parseIATDirectory(rva, size uint32) error {
 
-	var entries []IATEntry
+	entries := pe.IAT[:0]
 	var index uint32
 	var err error
 
@@ -53,10 +72,6 @@ func (pe *File) parseIATDirectory(rva, size uint32) error {
 			rva += 4
 		}
 		ie.Index = index
-		imp, i := pe.GetImportEntryInfoByRVA(rva)
-		if len(imp.Functions) != 0 {
-			ie.Meaning = imp.Name + "!" + imp.Functions[i].Name
-		}
 		entries = append(entries, ie)
 		index++
 	}
@@ -65,3 +80,54 @@ func (pe *File) parseIATDirectory(rva, size uint32) error {
 	pe.HasIAT = true
 	return nil
 }
+
+// resolveIATEntries maps the IAT slots to the functions of the import and
+// delay import descriptors. It runs once all data directories are parsed as
+// the delay import directory comes after the IAT one.
+func (pe *File) resolveIATEntries() {
+	type slot struct {
+		module, function string
+		delayed          bool
+	}
+
+	slots := make(map[uint32]slot)
+	for _, imp := range pe.Imports {
+		for _, fn := range imp.Functions {
+			slots[fn.ThunkRVA] = slot{imp.Name, fn.Name, false}
+		}
+	}
+	for _, imp := range pe.DelayImports {
+		for _, fn := range imp.Functions {
+			if _, ok := slots[fn.ThunkRVA]; !ok {
+				slots[fn.ThunkRVA] = slot{imp.Name, fn.Name, true}
+			}
+		}
+	}
+
+	for i := range pe.IAT {
+		ie := &pe.IAT[i]
+		if s, ok := slots[ie.Rva]; ok {
+			ie.Module = s.module
+			ie.Function = s.function
+			ie.Delayed = s.delayed
+			ie.Meaning = s.module + "!" + s.function
+			continue
+		}
+
+		// Null slots terminate the thunks of a descriptor. Slots can't be
+		// told apart when the import directory is not parsed or when its
+		// functions are read lazily.
+		if pe.opts.OmitImportDirectory || pe.opts.LazyImports {
+			continue
+		}
+		switch v := ie.Value.(type) {
+		case uint32:
+			ie.Orphan = v != 0
+		case uint64:
+			ie.Orphan = v != 0
+		}
+		if ie.Orphan {
+			pe.addAnomaly(AnoIATOrphanEntry)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestIATDirectory(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		index int
+		out   IATEntry
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), 0, IATEntry{
+			Index:    0,
+			Rva:      0xc5d00,
+			Value:    uint64(0xc6760),
+			Meaning:  "GDI32.dll!CreateBitmap",
+			Module:   "GDI32.dll",
+			Function: "CreateBitmap",
+		}},
+		{getAbsoluteFilePath("test/putty.exe"), 1, IATEntry{
+			Index:    1,
+			Rva:      0xc5d08,
+			Value:    uint64(0xc6770),
+			Meaning:  "GDI32.dll!CreateCompatibleBitmap",
+			Module:   "GDI32.dll",
+			Function: "CreateCompatibleBitmap",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			got := file.IAT[tt.index]
+			if got != tt.out {
+				t.Errorf("IAT entry assertion failed, got %+v, want %+v",
+					got, tt.out)
+			}
+			if stringInSlice(AnoIATOrphanEntry, file.Anomalies) {
+				t.Errorf("unexpected anomaly %q", AnoIATOrphanEntry)
+			}
+		})
+	}
+}
+
+func TestIATDirectoryLazyImports(t *testing.T) {
+	in := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(in, &Options{LazyImports: true})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", in, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", in, err)
+	}
+
+	if len(file.IAT) == 0 {
+		t.Fatalf("IAT directory assertion failed, got no entry")
+	}
+	for _, entry := range file.IAT {
+		if entry.Orphan {
+			t.Errorf("IAT entry %d assertion failed, got an orphan", entry.Index)
+		}
+	}
+	if stringInSlice(AnoIATOrphanEntry, file.Anomalies) {
+		t.Errorf("unexpected anomaly %q", AnoIATOrphanEntry)
+	}
+}
+
+func TestIATDirectoryOrphanEntry(t *testing.T) {
+	filename := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	// Overwrite the null slot terminating the thunks of the first module.
+	index := -1
+	for i, ie := range file.IAT {
+		if ie.Module == "" {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		t.Fatalf("no null IAT slot found")
+	}
+	offset := file.GetOffsetFromRva(file.IAT[index].Rva)
+	binary.LittleEndian.PutUint64(data[offset:], 0x140001000)
+
+	file, err = NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	if !file.IAT[index].Orphan {
+		t.Errorf("IAT entry %d should be an orphan, got %+v", index,
+			file.IAT[index])
+	}
+	if file.IAT[index-1].Orphan || file.IAT[index+1].Orphan {
+		t.Errorf("IAT entries surrounding %d should not be orphans", index)
+	}
+	if !stringInSlice(AnoIATOrphanEntry, file.Anomalies) {
+		t.Errorf("anomaly %q not found in %v", AnoIATOrphanEntry,
+			file.Anomalies)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2018 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// ErrNoIcon is returned when the file has no icon group resource.
+	ErrNoIcon = errors.New("no icon found in the resource directory")
+)
+
+// GrpIconDir represents the GRPICONDIR header of an icon group resource
+// (RT_GROUP_ICON), it is followed by Count GrpIconDirEntry structures.
+type GrpIconDir struct {
+	// Reserved, must be 0.
+	Reserved uint16 `json:"reserved"`
+
+	// Resource type, 1 for icons and 2 for cursors.
+	Type uint16 `json:"type"`
+
+	// Number of images in the group.
+	Count uint16 `json:"count"`
+}
+
+// GrpIconDirEntry represents a GRPICONDIRENTRY, which describes one of the
+// images of an icon group. The image itself is the RT_ICON resource
+// identified by ID.
+type GrpIconDirEntry struct {
+	// Width and height of the image in pixels, 0 means 256.
+	Width  uint8 `json:"width"`
+	Height uint8 `json:"height"`
+
+	// Number of colors of palette based images, 0 otherwise.
+	ColorCount uint8 `json:"color_count"`
+
+	// Reserved, must be 0.
+	Reserved uint8 `json:"reserved"`
+
+	// Color planes.
+	Planes uint16 `json:"planes"`
+
+	// Bits per pixel.
+	BitCount uint16 `json:"bit_count"`
+
+	// Size of the image in bytes.
+	BytesInRes uint32 `json:"bytes_in_res"`
+
+	// Identifier of the RT_ICON resource holding the image.
+	ID uint16 `json:"id"`
+}
+
+// Size returns the width and the height of the image in pixels.
+func (e GrpIconDirEntry) Size() (width, height int) {
+	width, height = int(e.Width), int(e.Height)
+	if width == 0 {
+		width = 256
+	}
+	if height == 0 {
+		height = 256
+	}
+	return width, height
+}
+
+// colors returns the number of bits per pixel of the image, derived from the
+// number of colors when BitCount is not set.
+func (e GrpIconDirEntry) colors() int {
+	if e.BitCount != 0 {
+		return int(e.BitCount) * int(Max(uint32(e.Planes), 1))
+	}
+	bits := 0
+	for n := int(e.ColorCount); n > 1; n >>= 1 {
+		bits++
+	}
+	return bits
+}
+
+// selectResourceLang returns the data entry of a resource directory whose
+// language ranks best against the preferred languages, see langRank.
+func selectResourceLang(dir ResourceDirectory,
+	langs []uint16) *ResourceDirectoryEntry {
+
+	var best *ResourceDirectoryEntry
+	for i := range dir.Entries {
+		entry := &dir.Entries[i]
+		if entry.IsResourceDir {
+			continue
+		}
+		if best == nil ||
+			langRank(uint16(entry.ID), langs) < langRank(uint16(best.ID), langs) {
+			best = entry
+		}
+	}
+	return best
+}
+
+// PrimaryIcon returns the icon displayed by Windows Explorer for the file,
+// that is the first icon group of the resource directory, in the language
+// ranking best against the given language identifiers, most preferred first.
+// Out of the images of the group, the largest one is picked, the one with
+// the most colors when several have the same size. The image is returned
+// as found in the RT_ICON resource, a PNG or a DIB without file header.
+func (pe *File) PrimaryIcon(langs ...uint16) (GrpIconDirEntry, []byte, error) {
+	var groups, icons *ResourceDirectory
+	for i := range pe.Resources.Entries {
+		entry := &pe.Resources.Entries[i]
+		switch entry.ID {
+		case RTGroupIcon:
+			groups = &entry.Directory
+		case RTIcon:
+			icons = &entry.Directory
+		}
+	}
+	if groups == nil || len(groups.Entries) == 0 || icons == nil {
+		return GrpIconDirEntry{}, nil, ErrNoIcon
+	}
+
+	group := selectResourceLang(groups.Entries[0].Directory, langs)
+	if group == nil {
+		return GrpIconDirEntry{}, nil, ErrNoIcon
+	}
+	data, err := pe.GetData(group.Data.Struct.OffsetToData,
+		group.Data.Struct.Size)
+	if err != nil {
+		return GrpIconDirEntry{}, nil, err
+	}
+
+	var dir GrpIconDir
+	r := bytes.NewReader(data)
+	if err := binary.Read(r, binary.LittleEndian, &dir); err != nil {
+		return GrpIconDirEntry{}, nil, err
+	}
+
+	// The count is bounded by the size of the resource.
+	var best GrpIconDirEntry
+	found := false
+	for i := uint16(0); i < dir.Count; i++ {
+		var entry GrpIconDirEntry
+		if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+			break
+		}
+		width, height := entry.Size()
+		bestWidth, bestHeight := best.Size()
+		area, bestArea := width*height, bestWidth*bestHeight
+		if !found || area > bestArea ||
+			area == bestArea && entry.colors() > best.colors() {
+			best = entry
+			found = true
+		}
+	}
+	if !found {
+		return GrpIconDirEntry{}, nil, ErrNoIcon
+	}
+
+	for _, entry := range icons.Entries {
+		if entry.Name != "" || entry.ID != uint32(best.ID) {
+			continue
+		}
+		icon := selectResourceLang(entry.Directory, langs)
+		if icon == nil {
+			break
+		}
+		image, err := pe.GetData(icon.Data.Struct.OffsetToData,
+			icon.Data.Struct.Size)
+		return best, image, err
+	}
+	return best, nil, ErrNoIcon
+}
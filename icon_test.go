@@ -0,0 +1,59 @@
+// Copyright 2018 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrimaryIcon(t *testing.T) {
+	tests := []struct {
+		in     string
+		entry  GrpIconDirEntry
+		header []byte
+		err    error
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), GrpIconDirEntry{Width: 48,
+			Height: 48, ColorCount: 16, Planes: 1, BitCount: 4,
+			BytesInRes: 1640, ID: 3}, []byte{0x28, 0, 0, 0, 0x30}, nil},
+		{getAbsoluteFilePath("test/brave.exe"), GrpIconDirEntry{Planes: 1,
+			BitCount: 32, BytesInRes: 17571, ID: 2}, []byte("\x89PNG"), nil},
+		{getAbsoluteFilePath("test/kernel32.dll"), GrpIconDirEntry{}, nil,
+			ErrNoIcon},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			entry, image, err := file.PrimaryIcon(0x409)
+			if err != tt.err {
+				t.Fatalf("PrimaryIcon(%s) failed, got %v, want %v", tt.in, err,
+					tt.err)
+			}
+			if entry != tt.entry {
+				t.Errorf("icon entry assertion failed, got %+v, want %+v",
+					entry, tt.entry)
+			}
+			if uint32(len(image)) != tt.entry.BytesInRes ||
+				!bytes.HasPrefix(image, tt.header) {
+				t.Errorf("icon image assertion failed, got %d bytes", len(image))
+			}
+			if width, _ := entry.Size(); tt.err == nil && width != 256 &&
+				width != int(tt.entry.Width) {
+				t.Errorf("icon width assertion failed, got %d", width)
+			}
+		})
+	}
+}
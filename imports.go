@@ -10,6 +10,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -44,6 +45,36 @@ var (
 	// ErrDamagedImportTable is reported when the IAT and ILT table length is 0.
 	ErrDamagedImportTable = errors.New(
 		"damaged Import Table information. ILT and/or IAT appear to be broken")
+
+	// AnoImportDescriptorBeyondDirectory is reported when import descriptors
+	// continue past the import directory size.
+	AnoImportDescriptorBeyondDirectory = "Import descriptors extend beyond the import directory size"
+
+	// AnoImportNameInHeaders is reported when a DLL or function name RVA
+	// points inside the PE headers.
+	AnoImportNameInHeaders = "Import name RVA points into the PE headers"
+
+	// AnoImportNameOutsideSections is reported when a DLL name RVA does not
+	// fall within any section.
+	AnoImportNameOutsideSections = "Import name RVA is not within any section"
+
+	// AnoImportBoundWithoutINT is reported when a bound import descriptor has
+	// no import name table (OriginalFirstThunk == 0), the loader can not
+	// re-resolve the IAT if the binding is stale.
+	AnoImportBoundWithoutINT = "Bound import descriptor has no OriginalFirstThunk"
+
+	// AnoImportMergedINTAndIAT is reported when the import name table and the
+	// import address table of a descriptor are the same array.
+	AnoImportMergedINTAndIAT = "Import descriptor OriginalFirstThunk and FirstThunk are the same"
+
+	// AnoImportINTTruncated is reported when the import name table of a
+	// descriptor is truncated or holds invalid entries, the names of the
+	// imported functions being read from the IAT instead.
+	AnoImportINTTruncated = "Import name table is truncated, names are read from the IAT"
+
+	// AnoImportThunkOutsideImage is reported when a hint/name thunk points
+	// beyond SizeOfImage.
+	AnoImportThunkOutsideImage = "Import thunk points outside the image"
 )
 
 // ImageImportDescriptor describes the remainder of the import information.
@@ -131,6 +162,20 @@ type ImportFunction struct {
 
 	// Name Thunk RVA.
 	OriginalThunkRVA uint32 `json:"original_thunk_rva"`
+
+	// The demangled form of a C++ decorated name, only populated with the
+	// DemangleNames option. It is empty when the name is not decorated. See
+	// Demangle.
+	Demangled string `json:"demangled,omitempty"`
+
+	// FromIAT is true when the import name table is missing, truncated or
+	// invalid and the function was read from the IAT instead. The name is
+	// only recovered when the IAT was not patched by the loader, or bound.
+	FromIAT bool `json:"from_iat,omitempty"`
+
+	// The name as found in the file when Options.NameSanitization changed
+	// it, empty otherwise.
+	RawName string `json:"raw_name,omitempty"`
 }
 
 // Import represents an empty entry in the import table.
@@ -139,73 +184,371 @@ type Import struct {
 	Name       string                `json:"name"`
 	Functions  []ImportFunction      `json:"functions"`
 	Descriptor ImageImportDescriptor `json:"descriptor"`
+
+	// The name as found in the file when Options.NameSanitization changed
+	// it, empty otherwise.
+	RawName string `json:"raw_name,omitempty"`
 }
 
 func (pe *File) parseImportDirectory(rva, size uint32) (err error) {
 
-	for {
-		importDesc := ImageImportDescriptor{}
-		fileOffset := pe.GetOffsetFromRva(rva)
-		importDescSize := uint32(binary.Size(importDesc))
-		err := pe.structUnpack(&importDesc, fileOffset, importDescSize)
+	it := pe.newImportIterator(rva, size)
+	it.anomalies = true
+	numImports := 0
+	for it.nextDescriptor() {
+		importDesc := it.imp.Descriptor
 
-		// If the RVA is invalid all would blow up. Some EXEs seem to be
-		// specially nasty and have an invalid RVA.
-		if err != nil {
-			return err
+		// With the LazyImports option, the imported functions are only
+		// checked for anomalies, they are read on demand by IterImports.
+		var importedFunctions []ImportFunction
+		pe.checkImportDescriptorTricks(&importDesc)
+		if !pe.opts.LazyImports {
+			importedFunctions, err = it.functions().all()
+			if err != nil {
+				return err
+			}
+			for _, function := range importedFunctions {
+				pe.checkImportFunctionTricks(&importDesc, function)
+			}
+		} else {
+			functions := it.functions()
+			for functions.Next() {
+				pe.checkImportFunctionTricks(&importDesc, functions.Function())
+			}
+			if functions.Err() != nil {
+				return functions.Err()
+			}
 		}
 
-		// If the structure is all zeros, we reached the end of the list.
-		if importDesc == (ImageImportDescriptor{}) {
-			break
+		dllName := pe.getStringAtRVA(importDesc.Name, maxDllLength)
+		if !IsValidDosFilename(dllName) {
+			continue
+		}
+
+		numImports++
+		if pe.opts.LazyImports {
+			continue
+		}
+		imp := Import{
+			Offset:     it.imp.Offset,
+			Functions:  importedFunctions,
+			Descriptor: importDesc,
+		}
+		imp.Name, imp.RawName = pe.sanitizeName(dllName)
+		pe.Imports = append(pe.Imports, imp)
+	}
+	if it.err != nil {
+		return it.err
+	}
+
+	if numImports > 0 {
+		pe.HasImport = true
+	}
+
+	return nil
+}
+
+// ImportIterator reads the import descriptors one at a time, the imported
+// functions of each descriptor are only read when iterating over them. It
+// follows the bufio.Scanner pattern:
+//
+//	it := file.IterImports()
+//	for it.Next() {
+//		imp := it.Import()
+//		functions := it.Functions()
+//		for functions.Next() {
+//			fmt.Println(imp.Name, functions.Function().Name)
+//		}
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type ImportIterator struct {
+	pe       *File
+	rva      uint32
+	dirStart uint32
+	size     uint32
+	maxLen   uint32
+	imp      Import
+	done     bool
+	err      error
+
+	// Anomalies are only recorded while parsing.
+	anomalies bool
+}
+
+// IterImports returns an iterator over the import directory. It does not
+// depend on the Imports slice, and works when the file was parsed with the
+// LazyImports option. Unlike Parse, the iteration does not record anomalies.
+func (pe *File) IterImports() *ImportIterator {
+	dirEntry := pe.dataDirectory(ImageDirectoryEntryImport)
+	return pe.newImportIterator(dirEntry.VirtualAddress, dirEntry.Size)
+}
+
+func (pe *File) newImportIterator(rva, size uint32) *ImportIterator {
+	return &ImportIterator{
+		pe:       pe,
+		rva:      rva,
+		dirStart: rva,
+		size:     size,
+		done:     rva == 0,
+	}
+}
+
+// Next advances to the next import descriptor, skipping the ones with an
+// invalid DLL name. It returns false at the end of the import directory or
+// when reading a descriptor fails.
+func (it *ImportIterator) Next() bool {
+	for it.nextDescriptor() {
+		dllName := it.pe.getStringAtRVA(it.imp.Descriptor.Name, maxDllLength)
+		if IsValidDosFilename(dllName) {
+			it.imp.Name, it.imp.RawName = it.pe.sanitizeName(dllName)
+			return true
 		}
+	}
+	return false
+}
+
+// Import returns the current import descriptor, its Functions are not read,
+// see Functions.
+func (it *ImportIterator) Import() Import {
+	return it.imp
+}
 
-		rva += importDescSize
+// Functions returns an iterator over the functions imported through the
+// current descriptor.
+func (it *ImportIterator) Functions() *ImportFunctionIterator {
+	return it.functions()
+}
 
-		// If the array of thunks is somewhere earlier than the import
-		// descriptor we can set a maximum length for the array. Otherwise
-		// just set a maximum length of the size of the file
-		maxLen := uint32(len(pe.data)) - fileOffset
-		if rva > importDesc.OriginalFirstThunk || rva > importDesc.FirstThunk {
-			if rva < importDesc.OriginalFirstThunk {
-				maxLen = rva - importDesc.FirstThunk
-			} else if rva < importDesc.FirstThunk {
-				maxLen = rva - importDesc.OriginalFirstThunk
-			} else {
-				maxLen = Max(rva-importDesc.OriginalFirstThunk,
-					rva-importDesc.FirstThunk)
-			}
+// Err returns the error which stopped the iteration, if any.
+func (it *ImportIterator) Err() error {
+	return it.err
+}
+
+// imports returns the import descriptors along with their functions. When
+// the file was parsed with the LazyImports option, they are read with
+// IterImports on each call.
+func (pe *File) imports() []Import {
+	if pe.opts == nil || !pe.opts.LazyImports {
+		return pe.Imports
+	}
+
+	var imports []Import
+	it := pe.IterImports()
+	for it.Next() {
+		imp := it.Import()
+		functions := it.Functions()
+		imp.Functions = []ImportFunction{}
+		for functions.Next() {
+			imp.Functions = append(imp.Functions, functions.Function())
 		}
+		imports = append(imports, imp)
+	}
+	return imports
+}
 
-		var importedFunctions []ImportFunction
-		if pe.Is64 {
-			importedFunctions, err = pe.parseImports64(&importDesc, maxLen)
+// nextDescriptor reads the next import descriptor, whatever its DLL name.
+func (it *ImportIterator) nextDescriptor() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+
+	pe := it.pe
+	importDesc := ImageImportDescriptor{}
+	fileOffset := pe.GetOffsetFromRva(it.rva)
+	importDescSize := uint32(binary.Size(importDesc))
+	err := pe.structUnpack(&importDesc, fileOffset, importDescSize)
+
+	// If the RVA is invalid all would blow up. Some EXEs seem to be
+	// specially nasty and have an invalid RVA.
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	// If the structure is all zeros, we reached the end of the list.
+	if importDesc == (ImageImportDescriptor{}) {
+		it.done = true
+		return false
+	}
+
+	if it.anomalies && it.size != 0 &&
+		uint64(it.rva)+uint64(importDescSize) > uint64(it.dirStart)+uint64(it.size) {
+		pe.addAnomaly(AnoImportDescriptorBeyondDirectory)
+	}
+
+	it.rva += importDescSize
+	rva := it.rva
+
+	// If the array of thunks is somewhere earlier than the import
+	// descriptor we can set a maximum length for the array. Otherwise
+	// just set a maximum length of the size of the file
+	maxLen := pe.remaining(fileOffset)
+	if rva > importDesc.OriginalFirstThunk || rva > importDesc.FirstThunk {
+		if rva < importDesc.OriginalFirstThunk {
+			maxLen = rva - importDesc.FirstThunk
+		} else if rva < importDesc.FirstThunk {
+			maxLen = rva - importDesc.OriginalFirstThunk
 		} else {
-			importedFunctions, err = pe.parseImports32(&importDesc, maxLen)
+			maxLen = Max(rva-importDesc.OriginalFirstThunk,
+				rva-importDesc.FirstThunk)
 		}
-		if err != nil {
-			return err
+	}
+
+	it.maxLen = maxLen
+	it.imp = Import{
+		Offset:     fileOffset,
+		Descriptor: importDesc,
+	}
+	return true
+}
+
+func (it *ImportIterator) functions() *ImportFunctionIterator {
+	functions, err := it.pe.importFunctions(&it.imp.Descriptor, it.maxLen)
+	if err != nil {
+		return &ImportFunctionIterator{err: err}
+	}
+	functions.anomalies = it.anomalies
+	return functions
+}
+
+// ImportFunctionIterator reads the functions imported through a descriptor
+// one at a time, see ImportIterator.Functions.
+type ImportFunctionIterator struct {
+	pe         *File
+	count      uint32
+	idx        uint32
+	numInvalid uint32
+	at         func(idx uint32) ImportFunction
+	function   ImportFunction
+	err        error
+	anomalies  bool
+}
+
+// Next advances to the next imported function, skipping the entries with an
+// invalid name. It returns false at the end of the thunk table or when the
+// table is found to be damaged.
+func (it *ImportFunctionIterator) Next() bool {
+	for it.err == nil && it.idx < it.count {
+		idx := it.idx
+		it.idx++
+		imp := it.at(idx)
+
+		// This file bfe97192e8107d52dd7b4010d12b2924 has an invalid table built
+		// in a way that it's parsable but contains invalid entries that lead
+		// pefile to take extremely long amounts of time to parse. It also leads
+		// to extreme memory consumption. To prevent similar cases, if invalid
+		// entries are found in the middle of a table the parsing will be aborted.
+		hasName := len(imp.Name) > 0
+		if it.anomalies && imp.Ordinal == 0 && !hasName {
+			if !stringInSlice(AnoImportNoNameNoOrdinal, it.pe.Anomalies) {
+				it.pe.appendAnomaly(AnoImportNoNameNoOrdinal)
+			}
 		}
 
-		dllName := pe.getStringAtRVA(importDesc.Name, maxDllLength)
-		if !IsValidDosFilename(dllName) {
-			dllName = "*invalid*"
+		// Some PEs appear to interleave valid and invalid imports. Instead of
+		// aborting the parsing altogether we will simply skip the invalid entries.
+		// Although if we see 1000 invalid entries and no legit ones, we abort.
+		if imp.Name == "*invalid*" {
+			if it.numInvalid > 1000 && it.numInvalid == idx {
+				it.err = errors.New(
+					`too many invalid names, aborting parsing`)
+				return false
+			}
+			it.numInvalid++
 			continue
 		}
 
-		pe.Imports = append(pe.Imports, Import{
-			Offset:     fileOffset,
-			Name:       string(dllName),
-			Functions:  importedFunctions,
-			Descriptor: importDesc,
-		})
+		imp.Name, imp.RawName = it.pe.sanitizeName(imp.Name)
+		it.function = imp
+		return true
 	}
+	return false
+}
 
-	if len(pe.Imports) > 0 {
-		pe.HasImport = true
+// Function returns the current imported function.
+func (it *ImportFunctionIterator) Function() ImportFunction {
+	return it.function
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (it *ImportFunctionIterator) Err() error {
+	return it.err
+}
+
+// all collects the remaining imported functions.
+func (it *ImportFunctionIterator) all() ([]ImportFunction, error) {
+	importedFunctions := []ImportFunction{}
+	for it.Next() {
+		importedFunctions = append(importedFunctions, it.function)
 	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	return importedFunctions, nil
+}
 
-	return nil
+// isValidImportName tells whether an imported function was resolved to a
+// name, or to an ordinal.
+func isValidImportName(name string) bool {
+	return name != "" && name != "*invalid*"
+}
+
+// checkImportDescriptorTricks reports import descriptors built to confuse
+// parsers or to hide imports: names located in the headers or outside of
+// any section, bound imports without an import name table and merged
+// INT/IAT.
+func (pe *File) checkImportDescriptorTricks(importDesc *ImageImportDescriptor) {
+
+	if importDesc.Name < pe.sizeOfHeaders() {
+		pe.addAnomaly(AnoImportNameInHeaders)
+	} else if len(pe.Sections) > 0 && pe.getSectionByRva(importDesc.Name) == nil {
+		pe.addAnomaly(AnoImportNameOutsideSections)
+	}
+
+	// A TimeDateStamp different from zero means the IAT has been bound.
+	if importDesc.OriginalFirstThunk == 0 && importDesc.TimeDateStamp != 0 {
+		pe.addAnomaly(AnoImportBoundWithoutINT)
+	}
+
+	if importDesc.OriginalFirstThunk != 0 &&
+		importDesc.OriginalFirstThunk == importDesc.FirstThunk {
+		pe.addAnomaly(AnoImportMergedINTAndIAT)
+	}
+}
+
+// checkImportFunctionTricks reports imported functions read from the IAT
+// because of a truncated INT, and name thunks pointing outside the image or
+// into the headers.
+func (pe *File) checkImportFunctionTricks(importDesc *ImageImportDescriptor,
+	function ImportFunction) {
+
+	if importDesc.OriginalFirstThunk != 0 && function.FromIAT {
+		pe.addAnomaly(AnoImportINTTruncated)
+	}
+	if function.ByOrdinal {
+		return
+	}
+
+	// When the IAT is bound and there is no INT, the thunk values are
+	// virtual addresses of the resolved functions.
+	var hintNameRVA uint64
+	if importDesc.OriginalFirstThunk != 0 {
+		hintNameRVA = function.OriginalThunkValue
+	} else if importDesc.TimeDateStamp == 0 {
+		hintNameRVA = function.ThunkValue
+	} else {
+		return
+	}
+
+	sizeOfImage, sizeOfHeaders := pe.sizeOfImage(), pe.sizeOfHeaders()
+	if sizeOfImage != 0 && hintNameRVA >= uint64(sizeOfImage) {
+		pe.addAnomaly(AnoImportThunkOutsideImage)
+	}
+	if hintNameRVA != 0 && hintNameRVA < uint64(sizeOfHeaders) {
+		pe.addAnomaly(AnoImportNameInHeaders)
+	}
 }
 
 func (pe *File) getImportTable32(rva uint32, maxLen uint32,
@@ -227,8 +570,9 @@ func (pe *File) getImportTable32(rva uint32, maxLen uint32,
 	}
 
 	for {
-		if rva >= startRVA+maxLen {
-			pe.logger.Warnf("Error parsing the import table. Entries go beyond bounds.")
+		if uint64(rva) >= uint64(startRVA)+uint64(maxLen) {
+			pe.warnf(ImageDirectoryEntryImport.String(), pe.GetOffsetFromRva(rva),
+				"Error parsing the import table. Entries go beyond bounds.")
 			break
 		}
 
@@ -236,7 +580,7 @@ func (pe *File) getImportTable32(rva uint32, maxLen uint32,
 		// a table containing bogus data (with malicious intent or otherwise)
 		if repeatedAddress >= maxRepeatedAddresses {
 			if !stringInSlice(AnoManyRepeatedEntries, pe.Anomalies) {
-				pe.Anomalies = append(pe.Anomalies, AnoManyRepeatedEntries)
+				pe.appendAnomaly(AnoManyRepeatedEntries)
 			}
 		}
 
@@ -245,7 +589,7 @@ func (pe *File) getImportTable32(rva uint32, maxLen uint32,
 		// a bogus table as the addresses should be contained within a module
 		if maxAddressOfData-minAddressOfData > maxAddressSpread {
 			if !stringInSlice(AnoInvalidThunkAddressOfData, pe.Anomalies) {
-				pe.Anomalies = append(pe.Anomalies, AnoInvalidThunkAddressOfData)
+				pe.appendAnomaly(AnoInvalidThunkAddressOfData)
 			}
 		}
 
@@ -258,8 +602,10 @@ func (pe *File) getImportTable32(rva uint32, maxLen uint32,
 		// correct thing and changed the fields containing addresses to RVAs.
 		offset := uint32(0)
 		if isOldDelayImport {
-			oh32 := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32)
-			newRVA := rva - oh32.ImageBase
+			newRVA, err := pe.rvaFromVA(uint64(rva), "delay import table")
+			if err != nil {
+				return nil, nil
+			}
 			offset = pe.GetOffsetFromRva(newRVA)
 			if offset == ^uint32(0) {
 				return nil, nil
@@ -290,9 +636,10 @@ func (pe *File) getImportTable32(rva uint32, maxLen uint32,
 		// Seen in PE with SHA256:
 		// 5945bb6f0ac879ddf61b1c284f3b8d20c06b228e75ae4f571fa87f5b9512902c
 		if thunk.AddressOfData >= startRVA && thunk.AddressOfData <= rva {
-			pe.logger.Warnf("Error parsing the import table. "+
-				"AddressOfData overlaps with THUNK_DATA for THUNK at: "+
-				"RVA 0x%x", rva)
+			pe.warnf(ImageDirectoryEntryImport.String(), pe.GetOffsetFromRva(rva),
+				"Error parsing the import table. "+
+					"AddressOfData overlaps with THUNK_DATA for THUNK at: "+
+					"RVA 0x%x", rva)
 			break
 		}
 
@@ -302,7 +649,7 @@ func (pe *File) getImportTable32(rva uint32, maxLen uint32,
 				// but its value is beyond 2^16, we will assume it's a
 				// corrupted and ignore it altogether
 				if !stringInSlice(AnoAddressOfDataBeyondLimits, pe.Anomalies) {
-					pe.Anomalies = append(pe.Anomalies, AnoAddressOfDataBeyondLimits)
+					pe.appendAnomaly(AnoAddressOfDataBeyondLimits)
 				}
 			}
 		} else {
@@ -352,8 +699,9 @@ func (pe *File) getImportTable64(rva uint32, maxLen uint32,
 	}
 
 	for {
-		if rva >= startRVA+maxLen {
-			pe.logger.Warnf("Error parsing the import table. Entries go beyond bounds.")
+		if uint64(rva) >= uint64(startRVA)+uint64(maxLen) {
+			pe.warnf(ImageDirectoryEntryImport.String(), pe.GetOffsetFromRva(rva),
+				"Error parsing the import table. Entries go beyond bounds.")
 			break
 		}
 
@@ -361,7 +709,7 @@ func (pe *File) getImportTable64(rva uint32, maxLen uint32,
 		// a table containing bogus data (with malicious intent or otherwise)
 		if repeatedAddress >= uint64(maxRepeatedAddresses) {
 			if !stringInSlice(AnoManyRepeatedEntries, pe.Anomalies) {
-				pe.Anomalies = append(pe.Anomalies, AnoManyRepeatedEntries)
+				pe.appendAnomaly(AnoManyRepeatedEntries)
 			}
 		}
 
@@ -370,7 +718,7 @@ func (pe *File) getImportTable64(rva uint32, maxLen uint32,
 		// table as the addresses should be contained within a module
 		if maxAddressOfData-minAddressOfData > uint64(maxAddressSpread) {
 			if !stringInSlice(AnoInvalidThunkAddressOfData, pe.Anomalies) {
-				pe.Anomalies = append(pe.Anomalies, AnoInvalidThunkAddressOfData)
+				pe.appendAnomaly(AnoInvalidThunkAddressOfData)
 			}
 		}
 
@@ -383,8 +731,10 @@ func (pe *File) getImportTable64(rva uint32, maxLen uint32,
 		// correct thing and changed the fields containing addresses to RVAs.
 		offset := uint32(0)
 		if isOldDelayImport {
-			oh64 := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64)
-			newRVA := rva - uint32(oh64.ImageBase)
+			newRVA, err := pe.rvaFromVA(uint64(rva), "delay import table")
+			if err != nil {
+				return nil, nil
+			}
 			offset = pe.GetOffsetFromRva(newRVA)
 			if offset == ^uint32(0) {
 				return nil, nil
@@ -416,9 +766,10 @@ func (pe *File) getImportTable64(rva uint32, maxLen uint32,
 		// 5945bb6f0ac879ddf61b1c284f3b8d20c06b228e75ae4f571fa87f5b9512902c
 		if thunk.AddressOfData >= uint64(startRVA) &&
 			thunk.AddressOfData <= uint64(rva) {
-			pe.logger.Warnf("Error parsing the import table. "+
-				"AddressOfData overlaps with THUNK_DATA for THUNK at: "+
-				"RVA 0x%x", rva)
+			pe.warnf(ImageDirectoryEntryImport.String(), pe.GetOffsetFromRva(rva),
+				"Error parsing the import table. "+
+					"AddressOfData overlaps with THUNK_DATA for THUNK at: "+
+					"RVA 0x%x", rva)
 			break
 		}
 
@@ -428,7 +779,7 @@ func (pe *File) getImportTable64(rva uint32, maxLen uint32,
 			// corrupted and ignore it altogether
 			if thunk.AddressOfData&0x7fffffff > 0xffff {
 				if !stringInSlice(AnoAddressOfDataBeyondLimits, pe.Anomalies) {
-					pe.Anomalies = append(pe.Anomalies, AnoAddressOfDataBeyondLimits)
+					pe.appendAnomaly(AnoAddressOfDataBeyondLimits)
 				}
 			}
 			// and if it looks like it should be an RVA
@@ -463,21 +814,59 @@ func (pe *File) getImportTable64(rva uint32, maxLen uint32,
 func (pe *File) parseImports32(importDesc interface{}, maxLen uint32) (
 	[]ImportFunction, error) {
 
-	var OriginalFirstThunk uint32
-	var FirstThunk uint32
-	var isOldDelayImport bool
+	it, err := pe.importFunctions32(importDesc, maxLen)
+	if err != nil {
+		return nil, err
+	}
+	it.anomalies = true
+	return it.all()
+}
+
+func (pe *File) parseImports64(importDesc interface{}, maxLen uint32) ([]ImportFunction, error) {
+
+	it, err := pe.importFunctions64(importDesc, maxLen)
+	if err != nil {
+		return nil, err
+	}
+	it.anomalies = true
+	return it.all()
+}
+
+// importFunctions reads the thunk tables of an import or a delay import
+// descriptor and returns an iterator building the imported functions.
+func (pe *File) importFunctions(importDesc interface{}, maxLen uint32) (
+	*ImportFunctionIterator, error) {
+	switch pe.Is64 {
+	case true:
+		return pe.importFunctions64(importDesc, maxLen)
+	default:
+		return pe.importFunctions32(importDesc, maxLen)
+	}
+}
+
+// importThunks returns the name and address thunk tables RVAs of an import
+// or a delay import descriptor.
+func importThunks(importDesc interface{}) (originalFirstThunk, firstThunk uint32,
+	isOldDelayImport bool) {
 
 	switch desc := importDesc.(type) {
 	case *ImageImportDescriptor:
-		OriginalFirstThunk = desc.OriginalFirstThunk
-		FirstThunk = desc.FirstThunk
+		originalFirstThunk = desc.OriginalFirstThunk
+		firstThunk = desc.FirstThunk
 	case *ImageDelayImportDescriptor:
-		OriginalFirstThunk = desc.ImportNameTableRVA
-		FirstThunk = desc.ImportAddressTableRVA
+		originalFirstThunk = desc.ImportNameTableRVA
+		firstThunk = desc.ImportAddressTableRVA
 		if desc.Attributes == 0 {
 			isOldDelayImport = true
 		}
 	}
+	return originalFirstThunk, firstThunk, isOldDelayImport
+}
+
+func (pe *File) importFunctions32(importDesc interface{}, maxLen uint32) (
+	*ImportFunctionIterator, error) {
+
+	OriginalFirstThunk, FirstThunk, isOldDelayImport := importThunks(importDesc)
 
 	// Import Lookup Table (OFT). Contains ordinals or pointers to strings.
 	ilt, err := pe.getImportTable32(OriginalFirstThunk, maxLen, isOldDelayImport)
@@ -498,24 +887,14 @@ func (pe *File) parseImports32(importDesc interface{}, maxLen uint32) (
 		return nil, ErrDamagedImportTable
 	}
 
-	var table []ThunkData32
-	if len(ilt) > 0 {
-		table = ilt
-	} else if len(iat) > 0 {
-		table = iat
-	} else {
-		return nil, err
-	}
-
-	importedFunctions := []ImportFunction{}
-	numInvalid := uint32(0)
-	for idx := uint32(0); idx < uint32(len(table)); idx++ {
+	from := func(table []ThunkData32, idx uint32) ImportFunction {
 		imp := ImportFunction{}
-		if table[idx].ImageThunkData.AddressOfData > 0 {
+		addressOfData := table[idx].ImageThunkData.AddressOfData
+		if addressOfData > 0 {
 			// If imported by ordinal, we will append the ordinal number
-			if table[idx].ImageThunkData.AddressOfData&imageOrdinalFlag32 > 0 {
+			if addressOfData&imageOrdinalFlag32 > 0 {
 				imp.ByOrdinal = true
-				imp.Ordinal = table[idx].ImageThunkData.AddressOfData & uint32(0xffff)
+				imp.Ordinal = addressOfData & uint32(0xffff)
 
 				// Original Thunk
 				if uint32(len(ilt)) > idx {
@@ -533,8 +912,12 @@ func (pe *File) parseImports32(importDesc interface{}, maxLen uint32) (
 			} else {
 				imp.ByOrdinal = false
 				if isOldDelayImport {
-					table[idx].ImageThunkData.AddressOfData -=
-						pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase
+					rva, err := pe.rvaFromVA(uint64(table[idx].ImageThunkData.AddressOfData),
+						"delay import table")
+					if err == nil {
+						table[idx].ImageThunkData.AddressOfData = rva
+					}
+					addressOfData = table[idx].ImageThunkData.AddressOfData
 				}
 
 				// Original Thunk
@@ -550,67 +933,58 @@ func (pe *File) parseImports32(importDesc interface{}, maxLen uint32) (
 				}
 
 				// Thunk
-				hintNameTableRva := table[idx].ImageThunkData.AddressOfData & addressMask32
+				hintNameTableRva := addressOfData & addressMask32
 				off := pe.GetOffsetFromRva(hintNameTableRva)
+				var err error
 				imp.Hint, err = pe.ReadUint16(off)
 				if err != nil {
 					imp.Hint = ^uint16(0)
 				}
-				imp.Name = pe.getStringAtRVA(table[idx].ImageThunkData.AddressOfData+2,
-					maxImportNameLength)
+				imp.Name = pe.getStringAtRVA(addressOfData+2, maxImportNameLength)
 				if !IsValidFunctionName(imp.Name) {
 					imp.Name = "*invalid*"
+				} else if pe.opts.DemangleNames {
+					imp.Demangled = Demangle(imp.Name)
 				}
 			}
 		}
+		return imp
+	}
 
-		// This file bfe97192e8107d52dd7b4010d12b2924 has an invalid table built
-		// in a way that it's parsable but contains invalid entries that lead
-		// pefile to take extremely long amounts of time to parse. It also leads
-		// to extreme memory consumption. To prevent similar cases, if invalid
-		// entries are found in the middle of a table the parsing will be aborted.
-		hasName := len(imp.Name) > 0
-		if imp.Ordinal == 0 && !hasName {
-			if !stringInSlice(AnoImportNoNameNoOrdinal, pe.Anomalies) {
-				pe.Anomalies = append(pe.Anomalies, AnoImportNoNameNoOrdinal)
+	// The import name table can be missing, zeroed or truncated, as done by
+	// some packers, the functions are then read from the IAT.
+	count := uint32(len(ilt))
+	if uint32(len(iat)) > count {
+		count = uint32(len(iat))
+	}
+	it := &ImportFunctionIterator{pe: pe, count: count}
+	it.at = func(idx uint32) ImportFunction {
+		if idx < uint32(len(ilt)) {
+			imp := from(ilt, idx)
+			if isValidImportName(imp.Name) || idx >= uint32(len(iat)) {
+				return imp
 			}
 		}
-
-		// Some PEs appear to interleave valid and invalid imports. Instead of
-		// aborting the parsing altogether we will simply skip the invalid entries.
-		// Although if we see 1000 invalid entries and no legit ones, we abort.
-		if imp.Name == "*invalid*" {
-			if numInvalid > 1000 && numInvalid == idx {
-				return nil, errors.New(
-					`too many invalid names, aborting parsing`)
-			}
-			numInvalid++
-			continue
+		imp := from(iat, idx)
+		imp.FromIAT = true
+
+		// Past a truncated import name table, the IAT can as well run into
+		// the slots of the next descriptor when its terminator was
+		// overwritten, stop at the first slot which is not a name.
+		if len(ilt) > 0 && idx >= uint32(len(ilt)) && !isValidImportName(imp.Name) {
+			it.count = idx
+			imp.Name = "*invalid*"
 		}
-
-		importedFunctions = append(importedFunctions, imp)
+		return imp
 	}
 
-	return importedFunctions, nil
+	return it, nil
 }
 
-func (pe *File) parseImports64(importDesc interface{}, maxLen uint32) ([]ImportFunction, error) {
+func (pe *File) importFunctions64(importDesc interface{}, maxLen uint32) (
+	*ImportFunctionIterator, error) {
 
-	var OriginalFirstThunk uint32
-	var FirstThunk uint32
-	var isOldDelayImport bool
-
-	switch desc := importDesc.(type) {
-	case *ImageImportDescriptor:
-		OriginalFirstThunk = desc.OriginalFirstThunk
-		FirstThunk = desc.FirstThunk
-	case *ImageDelayImportDescriptor:
-		OriginalFirstThunk = desc.ImportNameTableRVA
-		FirstThunk = desc.ImportAddressTableRVA
-		if desc.Attributes == 0 {
-			isOldDelayImport = true
-		}
-	}
+	OriginalFirstThunk, FirstThunk, isOldDelayImport := importThunks(importDesc)
 
 	// Import Lookup Table. Contains ordinals or pointers to strings.
 	ilt, err := pe.getImportTable64(OriginalFirstThunk, maxLen, isOldDelayImport)
@@ -631,25 +1005,15 @@ func (pe *File) parseImports64(importDesc interface{}, maxLen uint32) ([]ImportF
 		return nil, ErrDamagedImportTable
 	}
 
-	var table []ThunkData64
-	if len(ilt) > 0 {
-		table = ilt
-	} else if len(iat) > 0 {
-		table = iat
-	} else {
-		return nil, err
-	}
-
-	importedFunctions := []ImportFunction{}
-	numInvalid := uint32(0)
-	for idx := uint32(0); idx < uint32(len(table)); idx++ {
+	from := func(table []ThunkData64, idx uint32) ImportFunction {
 		imp := ImportFunction{}
-		if table[idx].ImageThunkData.AddressOfData > 0 {
+		addressOfData := table[idx].ImageThunkData.AddressOfData
+		if addressOfData > 0 {
 
 			// If imported by ordinal, we will append the ordinal number
-			if table[idx].ImageThunkData.AddressOfData&imageOrdinalFlag64 > 0 {
+			if addressOfData&imageOrdinalFlag64 > 0 {
 				imp.ByOrdinal = true
-				imp.Ordinal = uint32(table[idx].ImageThunkData.AddressOfData) & uint32(0xffff)
+				imp.Ordinal = uint32(addressOfData) & uint32(0xffff)
 
 				// Original Thunk
 				if uint32(len(ilt)) > idx {
@@ -671,8 +1035,12 @@ func (pe *File) parseImports64(importDesc interface{}, maxLen uint32) ([]ImportF
 				imp.ByOrdinal = false
 
 				if isOldDelayImport {
-					table[idx].ImageThunkData.AddressOfData -=
-						pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).ImageBase
+					rva, err := pe.rvaFromVA(table[idx].ImageThunkData.AddressOfData,
+						"delay import table")
+					if err == nil {
+						table[idx].ImageThunkData.AddressOfData = uint64(rva)
+					}
+					addressOfData = table[idx].ImageThunkData.AddressOfData
 				}
 
 				// Original Thunk
@@ -688,50 +1056,61 @@ func (pe *File) parseImports64(importDesc interface{}, maxLen uint32) ([]ImportF
 					imp.ThunkRVA = iat[idx].Offset
 				}
 
-				hintNameTableRva := table[idx].ImageThunkData.AddressOfData & addressMask64
+				hintNameTableRva := addressOfData & addressMask64
 				off := pe.GetOffsetFromRva(uint32(hintNameTableRva))
-				imp.Hint = binary.LittleEndian.Uint16(pe.data[off:])
-				imp.Name = pe.getStringAtRVA(uint32(table[idx].ImageThunkData.AddressOfData+2),
+				var err error
+				imp.Hint, err = pe.ReadUint16(off)
+				if err != nil {
+					imp.Hint = ^uint16(0)
+				} else {
+					pe.markCoverage(off, 2)
+				}
+				imp.Name = pe.getStringAtRVA(uint32(addressOfData+2),
 					maxImportNameLength)
 				if !IsValidFunctionName(imp.Name) {
 					imp.Name = "*invalid*"
+				} else if pe.opts.DemangleNames {
+					imp.Demangled = Demangle(imp.Name)
 				}
 			}
 		}
+		return imp
+	}
 
-		// This file bfe97192e8107d52dd7b4010d12b2924 has an invalid table built
-		// in a way that it's parsable but contains invalid entries that lead
-		// pefile to take extremely long amounts of time to parse. It also leads
-		// to extreme memory consumption. To prevent similar cases, if invalid
-		// entries are found in the middle of a table the parsing will be aborted.
-		hasName := len(imp.Name) > 0
-		if imp.Ordinal == 0 && !hasName {
-			if !stringInSlice(AnoImportNoNameNoOrdinal, pe.Anomalies) {
-				pe.Anomalies = append(pe.Anomalies, AnoImportNoNameNoOrdinal)
+	// The import name table can be missing, zeroed or truncated, as done by
+	// some packers, the functions are then read from the IAT.
+	count := uint32(len(ilt))
+	if uint32(len(iat)) > count {
+		count = uint32(len(iat))
+	}
+	it := &ImportFunctionIterator{pe: pe, count: count}
+	it.at = func(idx uint32) ImportFunction {
+		if idx < uint32(len(ilt)) {
+			imp := from(ilt, idx)
+			if isValidImportName(imp.Name) || idx >= uint32(len(iat)) {
+				return imp
 			}
 		}
-		// Some PEs appear to interleave valid and invalid imports. Instead of
-		// aborting the parsing altogether we will simply skip the invalid entries.
-		// Although if we see 1000 invalid entries and no legit ones, we abort.
-		if imp.Name == "*invalid*" {
-			if numInvalid > 1000 && numInvalid == idx {
-				return nil, errors.New(
-					`too many invalid names, aborting parsing`)
-			}
-			numInvalid++
-			continue
+		imp := from(iat, idx)
+		imp.FromIAT = true
+
+		// Past a truncated import name table, the IAT can as well run into
+		// the slots of the next descriptor when its terminator was
+		// overwritten, stop at the first slot which is not a name.
+		if len(ilt) > 0 && idx >= uint32(len(ilt)) && !isValidImportName(imp.Name) {
+			it.count = idx
+			imp.Name = "*invalid*"
 		}
-
-		importedFunctions = append(importedFunctions, imp)
+		return imp
 	}
 
-	return importedFunctions, nil
+	return it, nil
 }
 
 // GetImportEntryInfoByRVA return an import function + index of the entry given
 // an RVA.
 func (pe *File) GetImportEntryInfoByRVA(rva uint32) (Import, int) {
-	for _, imp := range pe.Imports {
+	for _, imp := range pe.imports() {
 		for i, entry := range imp.Functions {
 			if entry.ThunkRVA == rva {
 				return imp, i
@@ -749,6 +1128,29 @@ func md5hash(text string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// ImpHashOptions selects the normalization applied to the imports before
+// hashing them. The zero value gives the standard imphash.
+type ImpHashOptions struct {
+	// Keep the file extensions of the imported module names, by default
+	// the `.dll`, `.ocx` and `.sys` extensions are removed.
+	KeepExtensions bool
+
+	// Keep the case of the module and function names, by default both are
+	// converted to lowercase.
+	KeepCase bool
+
+	// Skip the functions imported by ordinal.
+	SkipOrdinals bool
+
+	// Do not resolve ordinals to function names, functions imported by
+	// ordinal are named `ord<N>` instead.
+	RawOrdinals bool
+
+	// Sort the imports before hashing them, which makes the hash resilient
+	// to the reordering of the import table.
+	Sorted bool
+}
+
 // ImpHash calculates the import hash.
 // Algorithm:
 // Resolving ordinals to function names when they appear
@@ -757,41 +1159,86 @@ func md5hash(text string) string {
 // Building and storing the lowercased string . in an ordered list
 // Generating the MD5 hash of the ordered list
 func (pe *File) ImpHash() (string, error) {
-	if len(pe.Imports) == 0 {
-		return "", errors.New("no imports found")
+	return pe.ImpHashWithOptions(ImpHashOptions{})
+}
+
+// ImpHashWithOptions calculates the import hash using the given options, as
+// the normalization differs between the vendors computing it.
+func (pe *File) ImpHashWithOptions(opts ImpHashOptions) (string, error) {
+	impStrs, err := pe.importStrings(opts)
+	if err != nil {
+		return "", err
+	}
+
+	hash := md5hash(strings.Join(impStrs, ","))
+	return hash, nil
+}
+
+// ImpFuzzy calculates the impfuzzy hash, that is the ssdeep fuzzy hash of the
+// string hashed by imphash. Unlike imphash, two impfuzzy hashes can be
+// compared to measure how similar the import tables are.
+func (pe *File) ImpFuzzy() (string, error) {
+	impStrs, err := pe.importStrings(ImpHashOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return ssdeepHash([]byte(strings.Join(impStrs, ","))), nil
+}
+
+// importStrings returns the `module.function` strings of the imports,
+// normalized according to opts.
+func (pe *File) importStrings(opts ImpHashOptions) ([]string, error) {
+	imports := pe.imports()
+	if len(imports) == 0 {
+		return nil, errors.New("no imports found")
 	}
 
 	extensions := []string{"ocx", "sys", "dll"}
 	var impStrs []string
 
-	for _, imp := range pe.Imports {
+	for _, imp := range imports {
+		// The hashes are computed over the names as found in the file.
+		dllName := originalName(imp.Name, imp.RawName)
 		var libName string
-		parts := strings.Split(imp.Name, ".")
-		if len(parts) == 2 && stringInSlice(strings.ToLower(parts[1]), extensions) {
+		parts := strings.Split(dllName, ".")
+		if len(parts) == 2 && stringInSlice(strings.ToLower(parts[1]), extensions) &&
+			!opts.KeepExtensions {
 			libName = parts[0]
 		} else {
-			libName = imp.Name
+			libName = dllName
 		}
 
-		libName = strings.ToLower(libName)
+		if !opts.KeepCase {
+			libName = strings.ToLower(libName)
+		}
 
 		for _, function := range imp.Functions {
 			var funcName string
-			if function.ByOrdinal {
-				funcName = OrdLookup(imp.Name, uint64(function.Ordinal), true)
-			} else {
-				funcName = function.Name
+			switch {
+			case function.ByOrdinal && opts.SkipOrdinals:
+				continue
+			case function.ByOrdinal && opts.RawOrdinals:
+				funcName = fmt.Sprintf("ord%d", function.Ordinal)
+			case function.ByOrdinal:
+				funcName = OrdLookup(dllName, uint64(function.Ordinal), true)
+			default:
+				funcName = originalName(function.Name, function.RawName)
 			}
 
 			if funcName == "" {
 				continue
 			}
 
-			impStr := fmt.Sprintf("%s.%s", libName, strings.ToLower(funcName))
-			impStrs = append(impStrs, impStr)
+			if !opts.KeepCase {
+				funcName = strings.ToLower(funcName)
+			}
+			impStrs = append(impStrs, fmt.Sprintf("%s.%s", libName, funcName))
 		}
 	}
 
-	hash := md5hash(strings.Join(impStrs, ","))
-	return hash, nil
+	if opts.Sorted {
+		sort.Strings(impStrs)
+	}
+	return impStrs, nil
 }
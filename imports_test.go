@@ -5,6 +5,8 @@
 package pe
 
 import (
+	"encoding/binary"
+	"io/ioutil"
 	"reflect"
 	"testing"
 )
@@ -155,3 +157,309 @@ func TestImpHash(t *testing.T) {
 		})
 	}
 }
+
+func TestImpHashWithOptions(t *testing.T) {
+
+	tests := []struct {
+		opts ImpHashOptions
+		out  string
+	}{
+		{ImpHashOptions{}, "msvcrt.printf,impbyord.exe.ord35"},
+		{ImpHashOptions{KeepExtensions: true}, "msvcrt.dll.printf,impbyord.exe.ord35"},
+		{ImpHashOptions{SkipOrdinals: true}, "msvcrt.printf"},
+		{ImpHashOptions{Sorted: true}, "impbyord.exe.ord35,msvcrt.printf"},
+	}
+
+	filename := getAbsoluteFilePath("test/impbyord.exe")
+	file, err := New(filename, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	if err := file.Parse(); err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			got, err := file.ImpHashWithOptions(tt.opts)
+			if err != nil {
+				t.Fatalf("ImpHashWithOptions(%+v) failed, reason: %v", tt.opts, err)
+			}
+			if want := md5hash(tt.out); got != want {
+				t.Errorf("ImpHashWithOptions(%+v) got %v, want %v", tt.opts, got, want)
+			}
+		})
+	}
+}
+
+func TestImpFuzzy(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		out string
+	}{
+		{getAbsoluteFilePath("test/impbyord.exe"), "3:rTGKAoD+RX:HlnD+l"},
+		{getAbsoluteFilePath("test/putty.exe"),
+			"96:oO0b11txH/63OxfUvDaS375tKN2Sm68BXTCdjAwhmypAhiONvR83un:oO411txH/63OxfUvDaS37vJ52djk"},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			if err := file.Parse(); err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+			got, err := file.ImpFuzzy()
+			if err != nil {
+				t.Fatalf("ImpFuzzy(%s) failed, reason: %v", tt.in, err)
+			}
+			if got != tt.out {
+				t.Errorf("ImpFuzzy(%s) got %v, want %v", tt.in, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestImportDirectoryTricks(t *testing.T) {
+
+	tests := []struct {
+		in        string
+		desc      ImageImportDescriptor
+		functions []ImportFunction
+		out       string
+	}{
+		{getAbsoluteFilePath("test/putty.exe"),
+			ImageImportDescriptor{OriginalFirstThunk: 0xcc000, Name: 0x40,
+				FirstThunk: 0xa0000},
+			nil,
+			AnoImportNameInHeaders},
+		{getAbsoluteFilePath("test/putty.exe"),
+			ImageImportDescriptor{OriginalFirstThunk: 0xcc000, Name: 0x7ffff000,
+				FirstThunk: 0xa0000},
+			nil,
+			AnoImportNameOutsideSections},
+		{getAbsoluteFilePath("test/putty.exe"),
+			ImageImportDescriptor{TimeDateStamp: 0xffffffff, Name: 0xccec6,
+				FirstThunk: 0xa0000},
+			nil,
+			AnoImportBoundWithoutINT},
+		{getAbsoluteFilePath("test/putty.exe"),
+			ImageImportDescriptor{OriginalFirstThunk: 0xa0000, Name: 0xccec6,
+				FirstThunk: 0xa0000},
+			nil,
+			AnoImportMergedINTAndIAT},
+		{getAbsoluteFilePath("test/putty.exe"),
+			ImageImportDescriptor{OriginalFirstThunk: 0xcc000, Name: 0xccec6,
+				FirstThunk: 0xa0000},
+			[]ImportFunction{{Name: "CreateFileW", OriginalThunkValue: 0x7ffff000}},
+			AnoImportThunkOutsideImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			file, err := New(tt.in, &Options{Fast: true})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			file.checkImportDescriptorTricks(&tt.desc)
+			for _, function := range tt.functions {
+				file.checkImportFunctionTricks(&tt.desc, function)
+			}
+			if !reflect.DeepEqual(file.Anomalies, []string{tt.out}) {
+				t.Errorf("import tricks anomalies assertion failed, got %v, want %v",
+					file.Anomalies, []string{tt.out})
+			}
+		})
+	}
+}
+
+func TestImportNamesFromIAT(t *testing.T) {
+
+	in := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", in, err)
+	}
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", in, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", in, err)
+	}
+	want := file.Imports[0]
+	intOffset := file.GetOffsetFromRva(want.Descriptor.OriginalFirstThunk)
+
+	tests := []struct {
+		name    string
+		patch   func(b []byte)
+		fromIAT []bool
+		anomaly bool
+	}{
+		{
+			"missing INT",
+			func(b []byte) {
+				binary.LittleEndian.PutUint32(b[want.Offset:], 0)
+			},
+			[]bool{true, true, true, true, true},
+			false,
+		},
+		{
+			"truncated INT",
+			func(b []byte) {
+				binary.LittleEndian.PutUint64(b[intOffset+3*8:], 0)
+			},
+			[]bool{false, false, false, true, true},
+			true,
+		},
+		{
+			"invalid INT entry",
+			func(b []byte) {
+				binary.LittleEndian.PutUint64(b[intOffset+8:], 0x7ffff000)
+			},
+			[]bool{false, true, false, false, false},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched := append([]byte{}, data...)
+			tt.patch(patched)
+			file, err := NewBytes(patched, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", in, err)
+			}
+
+			got := file.Imports[0]
+			if len(got.Functions) != len(want.Functions) {
+				t.Fatalf("functions count assertion failed, got %v, want %v",
+					len(got.Functions), len(want.Functions))
+			}
+			for i, fromIAT := range tt.fromIAT {
+				fn := got.Functions[i]
+				if fn.Name != want.Functions[i].Name || fn.FromIAT != fromIAT {
+					t.Errorf("function #%d assertion failed, got %s (from IAT: %v), want %s (from IAT: %v)",
+						i, fn.Name, fn.FromIAT, want.Functions[i].Name, fromIAT)
+				}
+			}
+			if stringInSlice(AnoImportINTTruncated, file.Anomalies) != tt.anomaly {
+				t.Errorf("anomaly %q assertion failed, got %v", AnoImportINTTruncated,
+					file.Anomalies)
+			}
+		})
+	}
+}
+
+func TestImportDescriptorBeyondDirectory(t *testing.T) {
+	in := getAbsoluteFilePath("test/liblzo2-2.dll")
+	file, err := New(in, &Options{Fast: true})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", in, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", in, err)
+	}
+
+	// The directory holds two descriptors, pretend it only holds one.
+	oh64 := file.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+	va := oh64.DataDirectory[ImageDirectoryEntryImport].VirtualAddress
+	err = file.parseImportDirectory(va, 20)
+	if err != nil {
+		t.Fatalf("parseImportDirectory(%s) failed, reason: %v", in, err)
+	}
+	if !stringInSlice(AnoImportDescriptorBeyondDirectory, file.Anomalies) {
+		t.Errorf("anomaly %q not found in %v", AnoImportDescriptorBeyondDirectory,
+			file.Anomalies)
+	}
+}
+
+func TestIterImports(t *testing.T) {
+
+	tests := []string{
+		getAbsoluteFilePath("test/kernel32.dll"),
+		getAbsoluteFilePath("test/mfc40u.dll"),
+		getAbsoluteFilePath("test/putty.exe"),
+	}
+
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			eager, err := New(tt, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt, err)
+			}
+			err = eager.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt, err)
+			}
+
+			file, err := New(tt, &Options{LazyImports: true})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt, err)
+			}
+			if file.Imports != nil || file.HasImport != eager.HasImport {
+				t.Fatalf("lazy imports assertion failed, got %d imports (%v), want none (%v)",
+					len(file.Imports), file.HasImport, eager.HasImport)
+			}
+
+			var imports []Import
+			it := file.IterImports()
+			for it.Next() {
+				imp := it.Import()
+				if imp.Functions != nil {
+					t.Errorf("import %s functions assertion failed, want none before iterating",
+						imp.Name)
+				}
+				functions := it.Functions()
+				imp.Functions = []ImportFunction{}
+				for functions.Next() {
+					imp.Functions = append(imp.Functions, functions.Function())
+				}
+				if functions.Err() != nil {
+					t.Fatalf("import %s functions iteration failed, reason: %v",
+						imp.Name, functions.Err())
+				}
+				imports = append(imports, imp)
+			}
+			if it.Err() != nil {
+				t.Fatalf("IterImports(%s) failed, reason: %v", tt, it.Err())
+			}
+
+			if !reflect.DeepEqual(imports, eager.Imports) {
+				t.Errorf("IterImports(%s) assertion failed, got %d imports, want %d",
+					tt, len(imports), len(eager.Imports))
+			}
+
+			// Methods relying on the imports read them on demand.
+			if !reflect.DeepEqual(file.imports(), eager.Imports) {
+				t.Errorf("imports(%s) assertion failed, got %d imports, want %d",
+					tt, len(file.imports()), len(eager.Imports))
+			}
+			lazyHash, _ := file.ImpHash()
+			eagerHash, _ := eager.ImpHash()
+			if lazyHash != eagerHash {
+				t.Errorf("ImpHash(%s) assertion failed, got %s, want %s",
+					tt, lazyHash, eagerHash)
+			}
+			if !reflect.DeepEqual(file.Anomalies, eager.Anomalies) {
+				t.Errorf("anomalies assertion failed, got %v, want %v",
+					file.Anomalies, eager.Anomalies)
+			}
+		})
+	}
+}
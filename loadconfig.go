@@ -75,6 +75,39 @@ const (
 	// ImageGuardCfLongJumpTablePresent indicates that the module contains
 	// long jmp target information.
 	ImageGuardCfLongJumpTablePresent = 0x00010000
+
+	// ImageGuardRfInstrumented indicates that the module contains return
+	// flow instrumentation and metadata.
+	ImageGuardRfInstrumented = 0x00020000
+
+	// ImageGuardRfEnable indicates that the module requests that the OS
+	// enable return flow protection.
+	ImageGuardRfEnable = 0x00040000
+
+	// ImageGuardRfStrict indicates that the module requests that the OS
+	// enable return flow protection in strict mode.
+	ImageGuardRfStrict = 0x00080000
+
+	// ImageGuardRetpolinePresent indicates that the module was built with
+	// retpoline support.
+	ImageGuardRetpolinePresent = 0x00100000
+
+	// ImageGuardEhContinuationTablePresent indicates that the module
+	// contains EH continuation target information, the targets exception
+	// handlers may resume execution at when the CET shadow stack is enforced.
+	ImageGuardEhContinuationTablePresent = 0x00400000
+
+	// ImageGuardXfgEnabled indicates that the module was built with
+	// eXtended Flow Guard, the type based control flow checks.
+	ImageGuardXfgEnabled = 0x00800000
+
+	// ImageGuardCastGuardPresent indicates that the module has CastGuard
+	// instrumentation present.
+	ImageGuardCastGuardPresent = 0x01000000
+
+	// ImageGuardMemcpyPresent indicates that the module has Guarded Memcpy
+	// instrumentation present.
+	ImageGuardMemcpyPresent = 0x02000000
 )
 
 const (
@@ -571,6 +604,24 @@ type VolatileMetadata struct {
 	AccessRVATable []uint32              `json:"access_rva_table"`
 	InfoRangeTable []RangeTableEntry     `json:"info_range_table"`
 }
+
+// GuardPointer is a pointer of the load configuration resolved within the
+// image.
+type GuardPointer struct {
+	// Virtual address found in the load configuration.
+	VA uint64 `json:"va"`
+
+	// RVA the virtual address resolves to.
+	RVA uint32 `json:"rva"`
+
+	// Name of the section the RVA lies in, empty when it is in no section.
+	Section string `json:"section"`
+
+	// Pointer-sized value stored at the RVA, zero when it is not backed by
+	// the file.
+	Value uint64 `json:"value"`
+}
+
 type LoadConfig struct {
 	Struct           interface{}       `json:"struct"`
 	SEH              []uint32          `json:"seh"`
@@ -581,6 +632,14 @@ type LoadConfig struct {
 	DVRT             *DVRT             `json:"dvrt"`
 	Enclave          *Enclave          `json:"enclave"`
 	VolatileMetadata *VolatileMetadata `json:"volatile_metadata"`
+
+	// CastGuard is the variable holding the CastGuard failure mode the OS
+	// determines, as pointed by CastGuardOSDeterminedFailureMode.
+	CastGuard *GuardPointer `json:"cast_guard"`
+
+	// GuardMemcpy is the function pointer of the guarded memcpy, as pointed
+	// by GuardMemcpyFunctionPointer.
+	GuardMemcpy *GuardPointer `json:"guard_memcpy"`
 }
 
 // ImageLoadConfigCodeIntegrity Code Integrity in load config (CI).
@@ -778,29 +837,23 @@ func (pe *File) parseLoadConfigDirectory(rva, size uint32) error {
 	// PrintLoadConfigStruct()
 	var loadCfg interface{}
 
-	// Boundary check
-	totalSize := fileOffset + size
-
-	// Integer overflow
-	if (totalSize > fileOffset) != (size > 0) {
-		return ErrOutsideBoundary
-	}
-
-	if fileOffset >= pe.size || totalSize > pe.size {
-		return ErrOutsideBoundary
+	if err := pe.checkRange(fileOffset, size); err != nil {
+		return err
 	}
 
 	if pe.Is32 {
 		loadCfg32 := ImageLoadConfigDirectory32{}
 		imgLoadConfigDirectory := make([]byte, binary.Size(loadCfg32))
-		copy(imgLoadConfigDirectory, pe.data[fileOffset:fileOffset+structSize])
+		copy(imgLoadConfigDirectory, pe.data[fileOffset:uint64(fileOffset)+uint64(structSize)])
+		pe.markCoverage(fileOffset, structSize)
 		buf := bytes.NewReader(imgLoadConfigDirectory)
 		err = binary.Read(buf, binary.LittleEndian, &loadCfg32)
 		loadCfg = loadCfg32
 	} else {
 		loadCfg64 := ImageLoadConfigDirectory64{}
 		imgLoadConfigDirectory := make([]byte, binary.Size(loadCfg64))
-		copy(imgLoadConfigDirectory, pe.data[fileOffset:fileOffset+structSize])
+		copy(imgLoadConfigDirectory, pe.data[fileOffset:uint64(fileOffset)+uint64(structSize)])
+		pe.markCoverage(fileOffset, structSize)
 		buf := bytes.NewReader(imgLoadConfigDirectory)
 		err = binary.Read(buf, binary.LittleEndian, &loadCfg64)
 		loadCfg = loadCfg64
@@ -841,28 +894,56 @@ func (pe *File) parseLoadConfigDirectory(rva, size uint32) error {
 	// Retrieve volatile metadata table if there are any.
 	pe.LoadConfig.VolatileMetadata = pe.getVolatileMetadata()
 
+	// Resolve the CastGuard and guarded memcpy pointers if there are any.
+	pe.LoadConfig.CastGuard = pe.getGuardPointer(
+		"CastGuardOSDeterminedFailureMode")
+	pe.LoadConfig.GuardMemcpy = pe.getGuardPointer(
+		"GuardMemcpyFunctionPointer")
+
 	return nil
 }
 
+// GuardFlagsType represents the GuardFlags field of the load configuration.
+type GuardFlagsType uint32
+
+// guardFlagMap names the flags of the GuardFlags field.
+var guardFlagMap = map[GuardFlagsType]string{
+	ImageGuardCfInstrumented:                 "Instrumented",
+	ImageGuardCfWInstrumented:                "WriteInstrumented",
+	ImageGuardCfFunctionTablePresent:         "TargetMetadata",
+	ImageGuardSecurityCookieUnused:           "SecurityCookieUnused",
+	ImageGuardProtectDelayLoadIAT:            "DelayLoadIAT",
+	ImageGuardDelayLoadIATInItsOwnSection:    "DelayLoadIATInItsOwnSection",
+	ImageGuardCfExportSuppressionInfoPresent: "ExportSuppressionInfoPresent",
+	ImageGuardCfEnableExportSuppression:      "EnableExportSuppression",
+	ImageGuardCfLongJumpTablePresent:         "LongJumpTablePresent",
+	ImageGuardRfInstrumented:                 "ReturnFlowInstrumented",
+	ImageGuardRfEnable:                       "ReturnFlowEnable",
+	ImageGuardRfStrict:                       "ReturnFlowStrict",
+	ImageGuardRetpolinePresent:               "RetpolinePresent",
+	ImageGuardEhContinuationTablePresent:     "EHContinuationTablePresent",
+	ImageGuardXfgEnabled:                     "XFGEnabled",
+	ImageGuardCastGuardPresent:               "CastGuardPresent",
+	ImageGuardMemcpyPresent:                  "MemcpyPresent",
+}
+
 // StringifyGuardFlags returns list of strings which describes the GuardFlags.
 func StringifyGuardFlags(flags uint32) []string {
-	var values []string
-	guardFlagMap := map[uint32]string{
-		ImageGuardCfInstrumented:                 "Instrumented",
-		ImageGuardCfWInstrumented:                "WriteInstrumented",
-		ImageGuardCfFunctionTablePresent:         "TargetMetadata",
-		ImageGuardSecurityCookieUnused:           "SecurityCookieUnused",
-		ImageGuardProtectDelayLoadIAT:            "DelayLoadIAT",
-		ImageGuardDelayLoadIATInItsOwnSection:    "DelayLoadIATInItsOwnSection",
-		ImageGuardCfExportSuppressionInfoPresent: "ExportSuppressionInfoPresent",
-		ImageGuardCfEnableExportSuppression:      "EnableExportSuppression",
-		ImageGuardCfLongJumpTablePresent:         "LongJumpTablePresent",
-	}
+	return GuardFlagsType(flags).Flags()
+}
+
+// Flags returns the names of the GuardFlags set, ordered by flag value.
+func (flags GuardFlagsType) Flags() []string {
+	return flagNames(guardFlagMap, func(k uint64) bool {
+		return GuardFlagsType(k)&flags != 0
+	})
+}
 
+// FlagsMap returns every GuardFlags flag mapped to whether it is set.
+func (flags GuardFlagsType) FlagsMap() map[string]bool {
+	values := make(map[string]bool, len(guardFlagMap))
 	for k, s := range guardFlagMap {
-		if k&flags != 0 {
-			values = append(values, s)
-		}
+		values[s] = k&flags != 0
 	}
 	return values
 }
@@ -875,9 +956,11 @@ func (pe *File) getSEHHandlers() []uint32 {
 	// SEHandlerCount is found in index 19 of the struct.
 	SEHandlerCount := uint32(v.Field(19).Uint())
 	if SEHandlerCount > 0 {
-		SEHandlerTable := uint32(v.Field(18).Uint())
-		imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase
-		rva := SEHandlerTable - imageBase
+		SEHandlerTable := v.Field(18).Uint()
+		rva, err := pe.rvaFromVA(SEHandlerTable, "SEHandlerTable")
+		if err != nil {
+			return handlers
+		}
 		for i := uint32(0); i < SEHandlerCount; i++ {
 			offset := pe.GetOffsetFromRva(rva + i*4)
 			handler, err := pe.ReadUint32(offset)
@@ -896,7 +979,6 @@ func (pe *File) getControlFlowGuardFunctions() []CFGFunction {
 
 	v := reflect.ValueOf(pe.LoadConfig.Struct)
 	var GFIDS []CFGFunction
-	var err error
 
 	// The GFIDS table is an array of 4 + n bytes, where n is given by :
 	// ((GuardFlags & IMAGE_GUARD_CF_FUNCTION_TABLE_SIZE_MASK) >>
@@ -912,9 +994,11 @@ func (pe *File) getControlFlowGuardFunctions() []CFGFunction {
 	GuardCFFunctionCount := v.Field(23).Uint()
 	if GuardCFFunctionCount > 0 {
 		if pe.Is32 {
-			GuardCFFunctionTable := uint32(v.Field(22).Uint())
-			imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase
-			rva := GuardCFFunctionTable - imageBase
+			GuardCFFunctionTable := v.Field(22).Uint()
+			rva, err := pe.rvaFromVA(GuardCFFunctionTable, "GuardCFFunctionTable")
+			if err != nil {
+				return GFIDS
+			}
 			offset := pe.GetOffsetFromRva(rva)
 			for i := uint32(1); i <= uint32(GuardCFFunctionCount); i++ {
 				cfgFunction := CFGFunction{}
@@ -941,8 +1025,10 @@ func (pe *File) getControlFlowGuardFunctions() []CFGFunction {
 			}
 		} else {
 			GuardCFFunctionTable := v.Field(22).Uint()
-			imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).ImageBase
-			rva := uint32(GuardCFFunctionTable - imageBase)
+			rva, err := pe.rvaFromVA(GuardCFFunctionTable, "GuardCFFunctionTable")
+			if err != nil {
+				return GFIDS
+			}
 			offset := pe.GetOffsetFromRva(rva)
 			for i := uint64(1); i <= GuardCFFunctionCount; i++ {
 				var cfgFlags uint8
@@ -973,7 +1059,6 @@ func (pe *File) getControlFlowGuardIAT() []CFGIATEntry {
 
 	v := reflect.ValueOf(pe.LoadConfig.Struct)
 	var GFGIAT []CFGIATEntry
-	var err error
 
 	// GuardAddressTakenIatEntryCount is found in index 27 of the struct.
 	// An image that supports CFG ES includes a GuardAddressTakenIatEntryTable
@@ -990,9 +1075,12 @@ func (pe *File) getControlFlowGuardIAT() []CFGIATEntry {
 	GuardAddressTakenIatEntryCount := v.Field(27).Uint()
 	if GuardAddressTakenIatEntryCount > 0 {
 		if pe.Is32 {
-			GuardAddressTakenIatEntryTable := uint32(v.Field(26).Uint())
-			imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase
-			rva := GuardAddressTakenIatEntryTable - imageBase
+			GuardAddressTakenIatEntryTable := v.Field(26).Uint()
+			rva, err := pe.rvaFromVA(GuardAddressTakenIatEntryTable,
+				"GuardAddressTakenIatEntryTable")
+			if err != nil {
+				return GFGIAT
+			}
 			offset := pe.GetOffsetFromRva(rva)
 			for i := uint32(1); i <= uint32(GuardAddressTakenIatEntryCount); i++ {
 				cfgIATEntry := CFGIATEntry{}
@@ -1011,8 +1099,11 @@ func (pe *File) getControlFlowGuardIAT() []CFGIATEntry {
 			}
 		} else {
 			GuardAddressTakenIatEntryTable := v.Field(26).Uint()
-			imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).ImageBase
-			rva := uint32(GuardAddressTakenIatEntryTable - imageBase)
+			rva, err := pe.rvaFromVA(GuardAddressTakenIatEntryTable,
+				"GuardAddressTakenIatEntryTable")
+			if err != nil {
+				return GFGIAT
+			}
 			offset := pe.GetOffsetFromRva(rva)
 			for i := uint64(1); i <= GuardAddressTakenIatEntryCount; i++ {
 				cfgIATEntry := CFGIATEntry{}
@@ -1053,9 +1144,11 @@ func (pe *File) getLongJumpTargetTable() []uint32 {
 	GuardLongJumpTargetCount := v.Field(29).Uint()
 	if GuardLongJumpTargetCount > 0 {
 		if pe.Is32 {
-			GuardLongJumpTargetTable := uint32(v.Field(28).Uint())
-			imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase
-			rva := GuardLongJumpTargetTable - imageBase
+			GuardLongJumpTargetTable := v.Field(28).Uint()
+			rva, err := pe.rvaFromVA(GuardLongJumpTargetTable, "GuardLongJumpTargetTable")
+			if err != nil {
+				return longJumpTargets
+			}
 			offset := pe.GetOffsetFromRva(rva)
 			for i := uint32(1); i <= uint32(GuardLongJumpTargetCount); i++ {
 				target, err := pe.ReadUint32(offset)
@@ -1067,8 +1160,10 @@ func (pe *File) getLongJumpTargetTable() []uint32 {
 			}
 		} else {
 			GuardLongJumpTargetTable := v.Field(28).Uint()
-			imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).ImageBase
-			rva := uint32(GuardLongJumpTargetTable - imageBase)
+			rva, err := pe.rvaFromVA(GuardLongJumpTargetTable, "GuardLongJumpTargetTable")
+			if err != nil {
+				return longJumpTargets
+			}
 			offset := pe.GetOffsetFromRva(rva)
 			for i := uint64(1); i <= GuardLongJumpTargetCount; i++ {
 				target, err := pe.ReadUint32(offset)
@@ -1092,13 +1187,9 @@ func (pe *File) getHybridPE() *HybridPE {
 	if CHPEMetadataPointer == 0 {
 		return nil
 	}
-	var rva uint32
-	if pe.Is32 {
-		imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase
-		rva = uint32(CHPEMetadataPointer) - imageBase
-	} else {
-		imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).ImageBase
-		rva = uint32(CHPEMetadataPointer - imageBase)
+	rva, err := pe.rvaFromVA(CHPEMetadataPointer, "CHPEMetadataPointer")
+	if err != nil {
+		return nil
 	}
 
 	// As the image CHPE metadata structure changes over time,
@@ -1125,22 +1216,14 @@ func (pe *File) getHybridPE() *HybridPE {
 		structSize = uint32(binary.Size(imgCHPEMetaX86))
 	}
 
-	// Boundary check
-	totalSize := fileOffset + structSize
-
-	// Integer overflow
-	if (totalSize > fileOffset) != (structSize > 0) {
-		pe.logger.Debug("encountered an outside read boundary when reading CHPE structure")
-		return nil
-	}
-
-	if fileOffset >= pe.size || totalSize > pe.size {
+	if err := pe.checkRange(fileOffset, structSize); err != nil {
 		pe.logger.Debug("encountered an outside read boundary when reading CHPE structure")
 		return nil
 	}
 
 	imgCHPEMeta := make([]byte, binary.Size(imgCHPEMetaX86))
-	copy(imgCHPEMeta, pe.data[fileOffset:fileOffset+structSize])
+	copy(imgCHPEMeta, pe.data[fileOffset:uint64(fileOffset)+uint64(structSize)])
+	pe.markCoverage(fileOffset, structSize)
 	buf := bytes.NewReader(imgCHPEMeta)
 	err = binary.Read(buf, binary.LittleEndian, &imgCHPEMetaX86)
 	if err != nil {
@@ -1394,11 +1477,14 @@ func (pe *File) getEnclaveConfiguration() *Enclave {
 		return nil
 	}
 
+	rva, err := pe.rvaFromVA(EnclaveConfigurationPointer, "EnclaveConfigurationPointer")
+	if err != nil {
+		return nil
+	}
+
 	if pe.Is32 {
 		imgEnclaveCfg := ImageEnclaveConfig32{}
 		imgEnclaveCfgSize := uint32(binary.Size(imgEnclaveCfg))
-		imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase
-		rva := uint32(EnclaveConfigurationPointer) - imageBase
 		offset := pe.GetOffsetFromRva(rva)
 		err := pe.structUnpack(&imgEnclaveCfg, offset, imgEnclaveCfgSize)
 		if err != nil {
@@ -1408,8 +1494,6 @@ func (pe *File) getEnclaveConfiguration() *Enclave {
 	} else {
 		imgEnclaveCfg := ImageEnclaveConfig64{}
 		imgEnclaveCfgSize := uint32(binary.Size(imgEnclaveCfg))
-		imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).ImageBase
-		rva := uint32(EnclaveConfigurationPointer - imageBase)
 		offset := pe.GetOffsetFromRva(rva)
 		err := pe.structUnpack(&imgEnclaveCfg, offset, imgEnclaveCfgSize)
 		if err != nil {
@@ -1440,11 +1524,45 @@ func (pe *File) getEnclaveConfiguration() *Enclave {
 	return &enclave
 }
 
+// getGuardPointer resolves the virtual address held by the given field of the
+// load configuration. It returns nil when the field is zero, or when the
+// address is outside the image, which is reported as an anomaly.
+func (pe *File) getGuardPointer(field string) *GuardPointer {
+
+	v := reflect.ValueOf(pe.LoadConfig.Struct).FieldByName(field)
+	if !v.IsValid() || v.Uint() == 0 {
+		return nil
+	}
+
+	va := v.Uint()
+	rva, err := pe.rvaFromVA(va, field)
+	if err != nil {
+		return nil
+	}
+
+	if rva >= pe.sizeOfImage() {
+		pe.addAnomaly(fmt.Sprintf(AnoVAOutsideImage, field))
+		return nil
+	}
+
+	ptr := GuardPointer{VA: va, RVA: rva, Section: pe.getSectionNameByRva(rva)}
+
+	// The virtual part of a section is zero-filled, so is the value.
+	offset := pe.GetOffsetFromRva(rva)
+	switch pe.Is64 {
+	case true:
+		ptr.Value, _ = pe.ReadUint64(offset)
+	case false:
+		value, _ := pe.ReadUint32(offset)
+		ptr.Value = uint64(value)
+	}
+	return &ptr
+}
+
 func (pe *File) getVolatileMetadata() *VolatileMetadata {
 
 	volatileMeta := VolatileMetadata{}
 	imgVolatileMeta := ImageVolatileMetadata{}
-	rva := uint32(0)
 
 	v := reflect.ValueOf(pe.LoadConfig.Struct)
 	if v.NumField() <= 41 {
@@ -1456,17 +1574,14 @@ func (pe *File) getVolatileMetadata() *VolatileMetadata {
 		return nil
 	}
 
-	if pe.Is32 {
-		imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase
-		rva = uint32(VolatileMetadataPointer) - imageBase
-	} else {
-		imageBase := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).ImageBase
-		rva = uint32(VolatileMetadataPointer - imageBase)
+	rva, err := pe.rvaFromVA(VolatileMetadataPointer, "VolatileMetadataPointer")
+	if err != nil {
+		return nil
 	}
 
 	offset := pe.GetOffsetFromRva(rva)
 	imgVolatileMetaSize := uint32(binary.Size(imgVolatileMeta))
-	err := pe.structUnpack(&imgVolatileMeta, offset, imgVolatileMetaSize)
+	err = pe.structUnpack(&imgVolatileMeta, offset, imgVolatileMetaSize)
 	if err != nil {
 		return nil
 	}
@@ -5,6 +5,9 @@
 package pe
 
 import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
 	"reflect"
 	"testing"
 )
@@ -1015,3 +1018,107 @@ func TestLoadConfigDirectoryVolatileMetadata(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadConfigDirectoryTableBelowImageBase(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/kernel32.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	if len(file.LoadConfig.GFIDS) == 0 {
+		t.Fatalf("GFIDS should not be empty")
+	}
+
+	// Locate the GuardCFFunctionTable field in the file.
+	r, err := file.DataDirectoryRange(ImageDirectoryEntryLoadConfig)
+	if err != nil {
+		t.Fatalf("DataDirectoryRange(LoadConfig) failed, reason: %v", err)
+	}
+	offset := r.Offset
+	typ := reflect.TypeOf(ImageLoadConfigDirectory64{})
+	for i := 0; typ.Field(i).Name != "GuardCFFunctionTable"; i++ {
+		offset += uint32(typ.Field(i).Type.Size())
+	}
+
+	// Point the table below the image base, a 32-bit subtraction would wrap
+	// around to a valid looking RVA.
+	imageBase := file.NtHeader.OptionalHeader.(ImageOptionalHeader64).ImageBase
+	binary.LittleEndian.PutUint64(data[offset:], imageBase-0x100000000+0x1000)
+
+	file, err = NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	if len(file.LoadConfig.GFIDS) != 0 {
+		t.Errorf("GFIDS count assertion failed, got %v, want 0",
+			len(file.LoadConfig.GFIDS))
+	}
+	anomaly := fmt.Sprintf(AnoVAOutsideImage, "GuardCFFunctionTable")
+	if !stringInSlice(anomaly, file.Anomalies) {
+		t.Errorf("anomaly %q not found in %v", anomaly, file.Anomalies)
+	}
+}
+
+func TestLoadConfigGuardPointers(t *testing.T) {
+
+	tests := []struct {
+		in          string
+		castGuard   *GuardPointer
+		guardMemcpy *GuardPointer
+	}{
+		{
+			getAbsoluteFilePath("test/pwsh.exe"),
+			&GuardPointer{VA: 0x14001a440, RVA: 0x1a440, Section: ".rdata"},
+			&GuardPointer{VA: 0x14001a448, RVA: 0x1a448, Section: ".rdata",
+				Value: 0x140015670},
+		},
+		{
+			getAbsoluteFilePath("test/YourPhone.Exp.WinRT.dll"),
+			&GuardPointer{VA: 0x180038570, RVA: 0x38570, Section: ".rdata"},
+			&GuardPointer{VA: 0x180038578, RVA: 0x38578, Section: ".rdata",
+				Value: 0x180032ef0},
+		},
+		{
+			getAbsoluteFilePath("test/kernel32.dll"),
+			nil,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			if !reflect.DeepEqual(file.LoadConfig.CastGuard, tt.castGuard) {
+				t.Errorf("CastGuard assertion failed, got %+v, want %+v",
+					file.LoadConfig.CastGuard, tt.castGuard)
+			}
+			if !reflect.DeepEqual(file.LoadConfig.GuardMemcpy, tt.guardMemcpy) {
+				t.Errorf("GuardMemcpy assertion failed, got %+v, want %+v",
+					file.LoadConfig.GuardMemcpy, tt.guardMemcpy)
+			}
+		})
+	}
+}
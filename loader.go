@@ -0,0 +1,130 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"crypto"
+	"hash"
+	"io"
+)
+
+// zeroPage is written to fill the gaps of the mapped image.
+var zeroPage [0x1000]byte
+
+// WriteMappedImage writes the image the way the loader maps it in memory:
+// the headers followed by every section copied at its virtual address, the
+// gaps and the virtual part of the sections being zero-filled, up to
+// SizeOfImage. No relocation is applied and imports are not resolved, the
+// image is the one found in a memory dump of a module loaded at its preferred
+// base address. The file must be parsed before calling WriteMappedImage.
+func (pe *File) WriteMappedImage(w io.Writer) error {
+
+	mw := mappedImageWriter{w: w, size: uint64(pe.sizeOfImage())}
+
+	// The headers are mapped at the image base, they end where the first
+	// section starts.
+	headersEnd := uint64(pe.sizeOfHeaders())
+	if headersEnd > pe.size {
+		headersEnd = pe.size
+	}
+	if len(pe.sectionMap) > 0 &&
+		uint64(pe.sectionMap[0].VirtualAddress) < headersEnd {
+		headersEnd = uint64(pe.sectionMap[0].VirtualAddress)
+	}
+	mw.write(pe.data[:headersEnd])
+
+	for _, section := range pe.sectionMap {
+		start := uint64(section.VirtualAddress)
+		mw.zeroTo(start)
+
+		// Only the bytes within the virtual size are mapped.
+		rawSize := section.SizeOfRawData
+		if rawSize > section.VirtualSize {
+			rawSize = section.VirtualSize
+		}
+		data := pe.data[section.PointerToRawData : section.PointerToRawData+rawSize]
+
+		// Sections overlapping what was already written are mapped from
+		// where the previous one ends.
+		if mw.offset > start {
+			skip := mw.offset - start
+			if skip >= uint64(len(data)) {
+				continue
+			}
+			data = data[skip:]
+		}
+		mw.write(data)
+		mw.zeroTo(start + uint64(section.VirtualSize))
+	}
+	mw.zeroTo(uint64(pe.sizeOfImage()))
+
+	return mw.err
+}
+
+// ImageHash generates the SHA256 hash of the image as mapped in memory by the
+// loader, see WriteMappedImage. Unlike the hash of the file, it is not
+// affected by the overlay nor by the file alignment padding, which makes it
+// suitable to match a file with a module found in a memory dump.
+func (pe *File) ImageHash() []byte {
+	results := pe.ImageHashExt(crypto.SHA256.New())
+	if len(results) > 0 {
+		return results[0]
+	}
+	return nil
+}
+
+// ImageHashExt generates the hashes of the image as mapped in memory by the
+// loader using the given hashers.
+func (pe *File) ImageHashExt(hashers ...hash.Hash) [][]byte {
+	writers := make([]io.Writer, len(hashers))
+	for i, h := range hashers {
+		writers[i] = h
+	}
+
+	err := pe.WriteMappedImage(io.MultiWriter(writers...))
+	if err != nil {
+		return nil
+	}
+
+	var hashes [][]byte
+	for _, h := range hashers {
+		hashes = append(hashes, h.Sum(nil))
+	}
+	return hashes
+}
+
+// mappedImageWriter writes the mapped image sequentially, up to its size.
+type mappedImageWriter struct {
+	w      io.Writer
+	offset uint64
+	size   uint64
+	err    error
+}
+
+// write writes b at the current offset, truncated to the size of the image.
+func (mw *mappedImageWriter) write(b []byte) {
+	if mw.err != nil || mw.offset >= mw.size {
+		return
+	}
+	if uint64(len(b)) > mw.size-mw.offset {
+		b = b[:mw.size-mw.offset]
+	}
+	_, mw.err = mw.w.Write(b)
+	mw.offset += uint64(len(b))
+}
+
+// zeroTo writes zeros from the current offset to the given one.
+func (mw *mappedImageWriter) zeroTo(offset uint64) {
+	if offset > mw.size {
+		offset = mw.size
+	}
+	for mw.err == nil && mw.offset < offset {
+		n := offset - mw.offset
+		if n > uint64(len(zeroPage)) {
+			n = uint64(len(zeroPage))
+		}
+		mw.write(zeroPage[:n])
+	}
+}
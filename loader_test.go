@@ -0,0 +1,115 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriteMappedImage(t *testing.T) {
+
+	tests := []struct {
+		in            string
+		sizeOfImage   int
+		sizeOfHeaders int
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), 0x128000, 0x400},
+		{getAbsoluteFilePath("test/mfc40u.dll"), 0xe9000, 0x400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			var buf bytes.Buffer
+			err = file.WriteMappedImage(&buf)
+			if err != nil {
+				t.Fatalf("WriteMappedImage(%s) failed, reason: %v", tt.in, err)
+			}
+			image := buf.Bytes()
+
+			if len(image) != tt.sizeOfImage {
+				t.Fatalf("mapped image size assertion failed, got 0x%x, want 0x%x",
+					len(image), tt.sizeOfImage)
+			}
+			if !bytes.Equal(image[:tt.sizeOfHeaders], file.data[:tt.sizeOfHeaders]) {
+				t.Errorf("mapped image headers assertion failed")
+			}
+
+			for _, section := range file.Sections {
+				hdr := section.Header
+				size := hdr.SizeOfRawData
+				if hdr.VirtualSize < size {
+					size = hdr.VirtualSize
+				}
+				got := image[hdr.VirtualAddress : hdr.VirtualAddress+size]
+				want := file.data[hdr.PointerToRawData : hdr.PointerToRawData+size]
+				if !bytes.Equal(got, want) {
+					t.Errorf("mapped section %s assertion failed", section.String())
+				}
+
+				// The virtual part of the section is zero-filled.
+				end := hdr.VirtualAddress + hdr.VirtualSize
+				for _, b := range image[hdr.VirtualAddress+size : end] {
+					if b != 0 {
+						t.Errorf("mapped section %s padding assertion failed",
+							section.String())
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestImageHash(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	imageHash := func(data []byte) []byte {
+		file, err := NewBytes(data, &Options{})
+		if err != nil {
+			t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+		}
+		err = file.Parse()
+		if err != nil {
+			t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+		}
+		return file.ImageHash()
+	}
+
+	want := imageHash(data)
+	if len(want) != 32 {
+		t.Fatalf("ImageHash(%s) assertion failed, got %x", filename, want)
+	}
+
+	// Appended data is not mapped.
+	overlay := append(append([]byte{}, data...), bytes.Repeat([]byte{0xcc}, 0x1000)...)
+	if got := imageHash(overlay); !bytes.Equal(got, want) {
+		t.Errorf("ImageHash(%s) with overlay assertion failed, got %x, want %x",
+			filename, got, want)
+	}
+
+	// The code is.
+	patched := append([]byte{}, data...)
+	patched[0x400] ^= 0xff
+	if got := imageHash(patched); bytes.Equal(got, want) {
+		t.Errorf("ImageHash(%s) with patched code assertion failed, got %x",
+			filename, got)
+	}
+}
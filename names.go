@@ -0,0 +1,90 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"strings"
+)
+
+// NonASCIIPolicy tells how the bytes of a name which are not printable ASCII,
+// embedded null bytes and other control characters included, are handled.
+type NonASCIIPolicy int
+
+const (
+	// NonASCIIKeep keeps the bytes as found in the file, the name may then
+	// not be valid UTF-8.
+	NonASCIIKeep NonASCIIPolicy = iota
+
+	// NonASCIIEscape replaces every offending byte with its \xNN escape.
+	NonASCIIEscape
+
+	// NonASCIIReplace replaces every offending byte with a '?'.
+	NonASCIIReplace
+
+	// NonASCIIDrop removes the offending bytes.
+	NonASCIIDrop
+)
+
+// NameSanitization is the policy applied to the names read from the file,
+// such as the names of the imported and exported functions and of the DLLs
+// they come from. Hostile files use very long names or names made of binary
+// data, which downstream JSON encoders and databases may not cope with. The
+// zero value keeps the names as found in the file.
+type NameSanitization struct {
+	// Names are truncated to MaxLength bytes once sanitized, zero means no
+	// limit.
+	MaxLength int
+
+	// How the bytes outside of printable ASCII are handled.
+	NonASCII NonASCIIPolicy
+}
+
+// Sanitize applies the policy to the given name.
+func (s NameSanitization) Sanitize(name string) string {
+	if s.NonASCII != NonASCIIKeep {
+		var b strings.Builder
+		for i := 0; i < len(name); i++ {
+			c := name[i]
+			if c >= 0x20 && c < 0x7f {
+				b.WriteByte(c)
+				continue
+			}
+			switch s.NonASCII {
+			case NonASCIIEscape:
+				b.WriteString(`\x`)
+				b.WriteByte("0123456789abcdef"[c>>4])
+				b.WriteByte("0123456789abcdef"[c&0xf])
+			case NonASCIIReplace:
+				b.WriteByte('?')
+			}
+		}
+		name = b.String()
+	}
+
+	if s.MaxLength > 0 && len(name) > s.MaxLength {
+		name = name[:s.MaxLength]
+	}
+	return name
+}
+
+// sanitizeName applies Options.NameSanitization to a name read from the
+// file. It returns the name to use along with the original one when the
+// sanitization changed it, empty otherwise.
+func (pe *File) sanitizeName(name string) (string, string) {
+	clean := pe.opts.NameSanitization.Sanitize(name)
+	if clean == name {
+		return name, ""
+	}
+	return clean, name
+}
+
+// originalName returns the name as found in the file given the sanitized one
+// and the raw one recorded by sanitizeName.
+func originalName(name, raw string) string {
+	if raw != "" {
+		return raw
+	}
+	return name
+}
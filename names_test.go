@@ -0,0 +1,89 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"testing"
+)
+
+func TestNameSanitization(t *testing.T) {
+
+	tests := []struct {
+		policy NameSanitization
+		in     string
+		out    string
+	}{
+		{NameSanitization{}, "Kernel\x00\xff32", "Kernel\x00\xff32"},
+		{NameSanitization{NonASCII: NonASCIIEscape}, "a\x00b\xffc", `a\x00b\xffc`},
+		{NameSanitization{NonASCII: NonASCIIReplace}, "a\x00b\xffc", "a?b?c"},
+		{NameSanitization{NonASCII: NonASCIIDrop}, "a\x00b\xffc\n", "abc"},
+		{NameSanitization{MaxLength: 4}, "CreateFileW", "Crea"},
+		{NameSanitization{MaxLength: 4, NonASCII: NonASCIIEscape}, "\x01bc", `\x01`},
+		{NameSanitization{MaxLength: 100}, "CreateFileW", "CreateFileW"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got := tt.policy.Sanitize(tt.in)
+			if got != tt.out {
+				t.Errorf("Sanitize(%q) got %q, want %q", tt.in, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestSanitizedImportNames(t *testing.T) {
+	filename := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(filename, &Options{
+		NameSanitization: NameSanitization{MaxLength: 5}})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	defer file.Close()
+	if err := file.Parse(); err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	ref, err := New(filename, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	defer ref.Close()
+	if err := ref.Parse(); err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	truncated := 0
+	for i, imp := range file.Imports {
+		want := ref.Imports[i].Name
+		if originalName(imp.Name, imp.RawName) != want || len(imp.Name) > 5 {
+			t.Errorf("import %d got name %q raw %q, want %q", i, imp.Name,
+				imp.RawName, want)
+		}
+		for j, function := range imp.Functions {
+			want := ref.Imports[i].Functions[j].Name
+			if len(function.Name) > 5 ||
+				originalName(function.Name, function.RawName) != want {
+				t.Errorf("function got name %q raw %q, want %q",
+					function.Name, function.RawName, want)
+			}
+			if function.RawName != "" {
+				truncated++
+			}
+		}
+	}
+	if truncated == 0 {
+		t.Errorf("no imported function name was truncated")
+	}
+
+	got, err := file.ImpHash()
+	if err != nil {
+		t.Fatalf("ImpHash() failed, reason: %v", err)
+	}
+	want, _ := ref.ImpHash()
+	if got != want {
+		t.Errorf("ImpHash() got %s, want %s", got, want)
+	}
+}
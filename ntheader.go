@@ -5,7 +5,9 @@
 package pe
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
 // ImageFileHeaderMachineType represents the type of the image file header `Machine“ field.
@@ -364,6 +366,11 @@ type DataDirectory struct {
 // beginning of the file.
 func (pe *File) ParseNTHeader() (err error) {
 	ntHeaderOffset := pe.DOSHeader.AddressOfNewEXEHeader
+	err = pe.checkTruncated("NT header signature", ntHeaderOffset, 4,
+		ErrInvalidNtHeaderOffset)
+	if err != nil {
+		return err
+	}
 	signature, err := pe.ReadUint32(ntHeaderOffset)
 	if err != nil {
 		return ErrInvalidNtHeaderOffset
@@ -394,6 +401,11 @@ func (pe *File) ParseNTHeader() (err error) {
 	// follows it.
 	fileHeaderSize := uint32(binary.Size(pe.NtHeader.FileHeader))
 	fileHeaderOffset := ntHeaderOffset + 4
+	err = pe.checkTruncated("file header", fileHeaderOffset, fileHeaderSize,
+		ErrOutsideBoundary)
+	if err != nil {
+		return err
+	}
 	err = pe.structUnpack(&pe.NtHeader.FileHeader, fileHeaderOffset, fileHeaderSize)
 	if err != nil {
 		return err
@@ -411,6 +423,11 @@ func (pe *File) ParseNTHeader() (err error) {
 	oh64 := ImageOptionalHeader64{}
 
 	optHeaderOffset := ntHeaderOffset + (fileHeaderSize + 4)
+	err = pe.checkTruncated("optional header magic", optHeaderOffset, 2,
+		ErrOutsideBoundary)
+	if err != nil {
+		return err
+	}
 	magic, err := pe.ReadUint16(optHeaderOffset)
 	if err != nil {
 		return err
@@ -426,18 +443,22 @@ func (pe *File) ParseNTHeader() (err error) {
 	switch magic {
 	case ImageNtOptionalHeader64Magic:
 		size := uint32(binary.Size(oh64))
-		err = pe.structUnpack(&oh64, optHeaderOffset, size)
+		err = pe.unpackOptionalHeader(&oh64, optHeaderOffset, size)
 		if err != nil {
 			return err
 		}
+		oh64.DataDirectory = pe.presentDataDirectories(oh64.NumberOfRvaAndSizes,
+			size-uint32(binary.Size(oh64.DataDirectory)), oh64.DataDirectory)
 		pe.Is64 = true
 		pe.NtHeader.OptionalHeader = oh64
 	case ImageNtOptionalHeader32Magic:
 		size := uint32(binary.Size(oh32))
-		err = pe.structUnpack(&oh32, optHeaderOffset, size)
+		err = pe.unpackOptionalHeader(&oh32, optHeaderOffset, size)
 		if err != nil {
 			return err
 		}
+		oh32.DataDirectory = pe.presentDataDirectories(oh32.NumberOfRvaAndSizes,
+			size-uint32(binary.Size(oh32.DataDirectory)), oh32.DataDirectory)
 		pe.Is32 = true
 		pe.NtHeader.OptionalHeader = oh32
 	}
@@ -451,102 +472,212 @@ func (pe *File) ParseNTHeader() (err error) {
 	// ImageBase + SizeOfImage < 80000000h for PE32.
 	// ImageBase + SizeOfImage < 0xffff080000000000 for PE32+.
 	if (pe.Is32 && oh32.ImageBase+oh32.SizeOfImage >= 0x80000000) || (pe.Is64 && oh64.ImageBase+uint64(oh64.SizeOfImage) >= 0xffff080000000000) {
-		pe.Anomalies = append(pe.Anomalies, AnoImageBaseOverflow)
+		pe.appendAnomaly(AnoImageBaseOverflow)
+	}
+
+	// Alignment anomalies are reported once here rather than each time an
+	// address is adjusted, so RVA translation stays free of side effects.
+	var fileAlignment, sectionAlignment uint32
+	switch pe.Is64 {
+	case true:
+		fileAlignment = oh64.FileAlignment
+		sectionAlignment = oh64.SectionAlignment
+	case false:
+		fileAlignment = oh32.FileAlignment
+		sectionAlignment = oh32.SectionAlignment
+	}
+	if fileAlignment > FileAlignmentHardcodedValue && fileAlignment%2 != 0 {
+		err := pe.violation("optional header", ErrInvalidFileAlignment, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if fileAlignment < FileAlignmentHardcodedValue &&
+		fileAlignment != sectionAlignment {
+		err := pe.violation("optional header", ErrInvalidSectionAlignment, nil)
+		if err != nil {
+			return err
+		}
 	}
 
 	pe.HasNTHdr = true
 	return nil
 }
 
+// unpackOptionalHeader reads the optional header. Truncated files whose
+// optional header runs past the end of the file are supported, the missing
+// bytes are read as zeros like the loader does when it maps the headers.
+func (pe *File) unpackOptionalHeader(iface interface{}, offset, size uint32) error {
+	if pe.checkRange(offset, size) == nil {
+		return pe.structUnpack(iface, offset, size)
+	}
+	if err := pe.checkRange(offset, 0); err != nil {
+		return err
+	}
+
+	err := pe.violation("optional header", AnoOptionalHeaderBeyondFile,
+		pe.checkTruncated("optional header", offset, size, ErrOutsideBoundary))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, size)
+	copy(buf, pe.data[offset:])
+	pe.markCoverage(offset, size)
+	return binary.Read(bytes.NewReader(buf), binary.LittleEndian, iface)
+}
+
+// presentDataDirectories returns the data directories which are actually
+// present according to NumberOfRvaAndSizes, the other entries are zeroed
+// so they are never parsed. fixedSize is the size of the optional header
+// without the data directories.
+func (pe *File) presentDataDirectories(numberOfRvaAndSizes, fixedSize uint32,
+	dirs [16]DataDirectory) [16]DataDirectory {
+
+	maxCount := uint32(len(dirs))
+	count := numberOfRvaAndSizes
+	if count != maxCount {
+		pe.addAnomaly(AnoNumberOfRvaAndSizes)
+	}
+	if count > maxCount {
+		count = maxCount
+	}
+	for i := count; i < maxCount; i++ {
+		dirs[i] = DataDirectory{}
+	}
+
+	// SizeOfOptionalHeader is not required to cover the data directories,
+	// the section table then overlaps them.
+	sizeOfOptionalHeader := uint32(pe.NtHeader.FileHeader.SizeOfOptionalHeader)
+	expected := fixedSize + count*uint32(binary.Size(DataDirectory{}))
+	if sizeOfOptionalHeader != 0 && sizeOfOptionalHeader < expected {
+		pe.addAnomaly(AnoSizeOfOptionalHeaderTruncated)
+	}
+
+	return dirs
+}
+
 // String returns the string representations of the `Machine` field of the IMAGE_FILE_HEADER.
 func (t ImageFileHeaderMachineType) String() string {
 	machineType := map[ImageFileHeaderMachineType]string{
-		ImageFileMachineUnknown:   "Unknown",
-		ImageFileMachineAM33:      "Matsushita AM33",
-		ImageFileMachineAMD64:     "x64",
-		ImageFileMachineARM:       "ARM little endian",
-		ImageFileMachineARM64:     "ARM64 little endian",
-		ImageFileMachineARMNT:     "ARM Thumb-2 little endian",
-		ImageFileMachineEBC:       "EFI byte code",
-		ImageFileMachineI386:      "Intel 386 or later / compatible processors",
-		ImageFileMachineIA64:      "Intel Itanium processor family",
-		ImageFileMachineM32R:      "Mitsubishi M32R little endian",
-		ImageFileMachineMIPS16:    "MIPS16",
-		ImageFileMachineMIPSFPU:   "MIPS with FPU",
-		ImageFileMachineMIPSFPU16: "MIPS16 with FPU",
-		ImageFileMachinePowerPC:   "Power PC little endian",
-		ImageFileMachinePowerPCFP: "Power PC with floating point support",
-		ImageFileMachineR4000:     "MIPS little endian",
-		ImageFileMachineRISCV32:   "RISC-V 32-bit address space",
-		ImageFileMachineRISCV64:   "RISC-V 64-bit address space",
-		ImageFileMachineRISCV128:  "RISC-V 128-bit address space",
-		ImageFileMachineSH3:       "Hitachi SH3",
-		ImageFileMachineSH3DSP:    "Hitachi SH3 DSP",
-		ImageFileMachineSH4:       "Hitachi SH4",
-		ImageFileMachineSH5:       "Hitachi SH5",
-		ImageFileMachineTHUMB:     "Thumb",
-		ImageFileMachineWCEMIPSv2: "MIPS little-endian WCE v2",
+		ImageFileMachineUnknown:     "Unknown",
+		ImageFileMachineAlpha:       "Alpha AXP",
+		ImageFileMachineAlpha64:     "Alpha 64",
+		ImageFileMachineAM33:        "Matsushita AM33",
+		ImageFileMachineAMD64:       "x64",
+		ImageFileMachineARM:         "ARM little endian",
+		ImageFileMachineARM64:       "ARM64 little endian",
+		ImageFileMachineARM64EC:     "ARM64EC",
+		ImageFileMachineARM64X:      "ARM64X",
+		ImageFileMachineARMNT:       "ARM Thumb-2 little endian",
+		ImageFileMachineEBC:         "EFI byte code",
+		ImageFileMachineI386:        "Intel 386 or later / compatible processors",
+		ImageFileMachineIA64:        "Intel Itanium processor family",
+		ImageFileMachineLoongArch32: "LoongArch 32-bit",
+		ImageFileMachineLoongArch64: "LoongArch 64-bit",
+		ImageFileMachineM32R:        "Mitsubishi M32R little endian",
+		ImageFileMachineMIPS16:      "MIPS16",
+		ImageFileMachineMIPSFPU:     "MIPS with FPU",
+		ImageFileMachineMIPSFPU16:   "MIPS16 with FPU",
+		ImageFileMachinePowerPC:     "Power PC little endian",
+		ImageFileMachinePowerPCFP:   "Power PC with floating point support",
+		ImageFileMachineR4000:       "MIPS little endian",
+		ImageFileMachineRISCV32:     "RISC-V 32-bit address space",
+		ImageFileMachineRISCV64:     "RISC-V 64-bit address space",
+		ImageFileMachineRISCV128:    "RISC-V 128-bit address space",
+		ImageFileMachineSH3:         "Hitachi SH3",
+		ImageFileMachineSH3DSP:      "Hitachi SH3 DSP",
+		ImageFileMachineSH4:         "Hitachi SH4",
+		ImageFileMachineSH5:         "Hitachi SH5",
+		ImageFileMachineTHUMB:       "Thumb",
+		ImageFileMachineWCEMIPSv2:   "MIPS little-endian WCE v2",
 	}
 
 	if val, ok := machineType[t]; ok {
 		return val
 	}
-	return "?"
+
+	// Render unknown machines as hex so they remain intelligible in logs.
+	return fmt.Sprintf("0x%x", uint16(t))
+}
+
+// fileHeaderCharacteristics names the flags of the `Characteristics` field
+// of the IMAGE_FILE_HEADER.
+var fileHeaderCharacteristics = map[ImageFileHeaderCharacteristicsType]string{
+	ImageFileRelocsStripped:       "RelocsStripped",
+	ImageFileExecutableImage:      "ExecutableImage",
+	ImageFileLineNumsStripped:     "LineNumsStripped",
+	ImageFileLocalSymsStripped:    "LocalSymsStripped",
+	ImageFileAggressiveWSTrim:     "AgressibeWsTrim",
+	ImageFileLargeAddressAware:    "LargeAddressAware",
+	ImageFileBytesReservedLow:     "BytesReservedLow",
+	ImageFile32BitMachine:         "32BitMachine",
+	ImageFileDebugStripped:        "DebugStripped",
+	ImageFileRemovableRunFromSwap: "RemovableRunFromSwap",
+	ImageFileSystem:               "FileSystem",
+	ImageFileDLL:                  "DLL",
+	ImageFileUpSystemOnly:         "UpSystemOnly",
+	ImageFileBytesReservedHigh:    "BytesReservedHigh",
 }
 
 // String returns the string representations of the `Characteristics` field of the IMAGE_FILE_HEADER.
 func (t ImageFileHeaderCharacteristicsType) String() []string {
-	var values []string
-	fileHeaderCharacteristics := map[ImageFileHeaderCharacteristicsType]string{
-		ImageFileRelocsStripped:       "RelocsStripped",
-		ImageFileExecutableImage:      "ExecutableImage",
-		ImageFileLineNumsStripped:     "LineNumsStripped",
-		ImageFileLocalSymsStripped:    "LocalSymsStripped",
-		ImageFileAggressiveWSTrim:     "AgressibeWsTrim",
-		ImageFileLargeAddressAware:    "LargeAddressAware",
-		ImageFileBytesReservedLow:     "BytesReservedLow",
-		ImageFile32BitMachine:         "32BitMachine",
-		ImageFileDebugStripped:        "DebugStripped",
-		ImageFileRemovableRunFromSwap: "RemovableRunFromSwap",
-		ImageFileSystem:               "FileSystem",
-		ImageFileDLL:                  "DLL",
-		ImageFileUpSystemOnly:         "UpSystemOnly",
-		ImageFileBytesReservedHigh:    "BytesReservedHigh",
-	}
+	return t.Flags()
+}
 
+// Flags returns the names of the flags set in the `Characteristics` field of
+// the IMAGE_FILE_HEADER, ordered by flag value.
+func (t ImageFileHeaderCharacteristicsType) Flags() []string {
+	return flagNames(fileHeaderCharacteristics, func(k uint64) bool {
+		return ImageFileHeaderCharacteristicsType(k)&t != 0
+	})
+}
+
+// FlagsMap returns every flag of the `Characteristics` field of the
+// IMAGE_FILE_HEADER mapped to whether it is set.
+func (t ImageFileHeaderCharacteristicsType) FlagsMap() map[string]bool {
+	values := make(map[string]bool, len(fileHeaderCharacteristics))
 	for k, s := range fileHeaderCharacteristics {
-		if k&t != 0 {
-			values = append(values, s)
-		}
+		values[s] = k&t != 0
 	}
-
 	return values
 }
 
+// imgDllCharacteristics names the flags of the `DllCharacteristics` field of
+// ImageOptionalHeader.
+var imgDllCharacteristics = map[ImageOptionalHeaderDllCharacteristicsType]string{
+	ImageDllCharacteristicsHighEntropyVA:        "HighEntropyVA",
+	ImageDllCharacteristicsDynamicBase:          "DynamicBase",
+	ImageDllCharacteristicsForceIntegrity:       "ForceIntegrity",
+	ImageDllCharacteristicsNXCompact:            "NXCompact",
+	ImageDllCharacteristicsNoIsolation:          "NoIsolation",
+	ImageDllCharacteristicsNoSEH:                "NoSEH",
+	ImageDllCharacteristicsNoBind:               "NoBind",
+	ImageDllCharacteristicsAppContainer:         "AppContainer",
+	ImageDllCharacteristicsWdmDriver:            "WdmDriver",
+	ImageDllCharacteristicsGuardCF:              "GuardCF",
+	ImageDllCharacteristicsTerminalServiceAware: "TerminalServiceAware",
+}
+
 // String returns the string representations of the `DllCharacteristics` field of ImageOptionalHeader.
 func (t ImageOptionalHeaderDllCharacteristicsType) String() []string {
-	var values []string
-
-	imgDllCharacteristics := map[ImageOptionalHeaderDllCharacteristicsType]string{
-		ImageDllCharacteristicsHighEntropyVA:        "HighEntropyVA",
-		ImageDllCharacteristicsDynamicBase:          "DynamicBase",
-		ImageDllCharacteristicsForceIntegrity:       "ForceIntegrity",
-		ImageDllCharacteristicsNXCompact:            "NXCompact",
-		ImageDllCharacteristicsNoIsolation:          "NoIsolation",
-		ImageDllCharacteristicsNoSEH:                "NoSEH",
-		ImageDllCharacteristicsNoBind:               "NoBind",
-		ImageDllCharacteristicsAppContainer:         "AppContainer",
-		ImageDllCharacteristicsWdmDriver:            "WdmDriver",
-		ImageDllCharacteristicsGuardCF:              "GuardCF",
-		ImageDllCharacteristicsTerminalServiceAware: "TerminalServiceAware",
-	}
+	return t.Flags()
+}
+
+// Flags returns the names of the flags set in the `DllCharacteristics` field
+// of ImageOptionalHeader, ordered by flag value.
+func (t ImageOptionalHeaderDllCharacteristicsType) Flags() []string {
+	return flagNames(imgDllCharacteristics, func(k uint64) bool {
+		return ImageOptionalHeaderDllCharacteristicsType(k)&t != 0
+	})
+}
 
+// FlagsMap returns every flag of the `DllCharacteristics` field of
+// ImageOptionalHeader mapped to whether it is set.
+func (t ImageOptionalHeaderDllCharacteristicsType) FlagsMap() map[string]bool {
+	values := make(map[string]bool, len(imgDllCharacteristics))
 	for k, s := range imgDllCharacteristics {
-		if k&t != 0 {
-			values = append(values, s)
-		}
+		values[s] = k&t != 0
 	}
-
 	return values
 }
 
@@ -584,11 +715,13 @@ func (pe *File) PrettyOptionalHeaderMagic() string {
 	var magic uint16
 
 	if pe.Is64 {
-		magic =
-			pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).Magic
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			magic = oh64.Magic
+		}
 	} else {
-		magic =
-			pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).Magic
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			magic = oh32.Magic
+		}
 	}
 
 	switch magic {
@@ -5,6 +5,8 @@
 package pe
 
 import (
+	"encoding/binary"
+	"io/ioutil"
 	"reflect"
 	"sort"
 	"strconv"
@@ -50,7 +52,16 @@ func TestNtHeaderMachineType(t *testing.T) {
 			ImageFileHeaderMachineType(0x8664), "x64",
 		},
 		{
-			ImageFileHeaderMachineType(0xffff), "?",
+			ImageFileHeaderMachineType(0xffff), "0xffff",
+		},
+		{
+			ImageFileHeaderMachineType(0x6264), "LoongArch 64-bit",
+		},
+		{
+			ImageFileHeaderMachineType(0xa641), "ARM64EC",
+		},
+		{
+			ImageFileHeaderMachineType(0x5064), "RISC-V 64-bit address space",
 		},
 	}
 
@@ -143,3 +154,126 @@ func TestOptionalHeaderDllCharacteristicsType(t *testing.T) {
 		})
 	}
 }
+
+func TestParseNtHeaderNumberOfRvaAndSizes(t *testing.T) {
+
+	// putty.exe is a PE32+ whose optional header starts at 0x90.
+	const numberOfRvaAndSizesOffset = 0x90 + 108
+
+	tests := []struct {
+		numberOfRvaAndSizes uint32
+		hasImport           bool
+		hasResource         bool
+		anomaly             bool
+	}{
+		{16, true, true, false},
+		{2, true, false, true},
+		{0, false, false, true},
+		{0xffffffff, true, true, true},
+	}
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(int(tt.numberOfRvaAndSizes)), func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+			binary.LittleEndian.PutUint32(data[numberOfRvaAndSizesOffset:],
+				tt.numberOfRvaAndSizes)
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			if file.HasImport != tt.hasImport {
+				t.Errorf("import directory presence assertion failed, got %v, want %v",
+					file.HasImport, tt.hasImport)
+			}
+			if file.HasResource != tt.hasResource {
+				t.Errorf("resource directory presence assertion failed, got %v, want %v",
+					file.HasResource, tt.hasResource)
+			}
+
+			oh64 := file.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+			for i := tt.numberOfRvaAndSizes; i < 16; i++ {
+				if oh64.DataDirectory[i] != (DataDirectory{}) {
+					t.Errorf("data directory %d should not be exposed, got %v",
+						i, oh64.DataDirectory[i])
+				}
+			}
+
+			got := stringInSlice(AnoNumberOfRvaAndSizes, file.Anomalies)
+			if got != tt.anomaly {
+				t.Errorf("NumberOfRvaAndSizes anomaly assertion failed, got %v, want %v",
+					got, tt.anomaly)
+			}
+		})
+	}
+}
+
+func TestParseNtHeaderTruncatedOptionalHeader(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	tests := []struct {
+		name      string
+		data      func() []byte
+		anomaly   string
+		sizeOfImg uint32
+	}{
+		{
+			// The file ends in the middle of the data directories.
+			"truncated file",
+			func() []byte { return append([]byte{}, data[:0x120]...) },
+			AnoOptionalHeaderBeyondFile,
+			0x128000,
+		},
+		{
+			// SizeOfOptionalHeader only covers the first two data directories.
+			"small SizeOfOptionalHeader",
+			func() []byte {
+				b := append([]byte{}, data...)
+				binary.LittleEndian.PutUint16(b[0x7c+16:], 0x70+2*8)
+				return b
+			},
+			AnoSizeOfOptionalHeaderTruncated,
+			0x128000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := NewBytes(tt.data(), &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.ParseDOSHeader()
+			if err != nil {
+				t.Fatalf("ParseDOSHeader(%s) failed, reason: %v", filename, err)
+			}
+			err = file.ParseNTHeader()
+			if err != nil {
+				t.Fatalf("ParseNTHeader(%s) failed, reason: %v", filename, err)
+			}
+
+			oh64 := file.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+			if oh64.SizeOfImage != tt.sizeOfImg {
+				t.Errorf("SizeOfImage assertion failed, got 0x%x, want 0x%x",
+					oh64.SizeOfImage, tt.sizeOfImg)
+			}
+			if !stringInSlice(tt.anomaly, file.Anomalies) {
+				t.Errorf("anomaly %q not found in %v", tt.anomaly, file.Anomalies)
+			}
+		})
+	}
+}
@@ -5,8 +5,11 @@
 package pe
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"io"
+	"strings"
 )
 
 // error
@@ -19,7 +22,8 @@ func (pe *File) NewOverlayReader() (*io.SectionReader, error) {
 	if pe.data == nil {
 		return nil, errors.New("pe: file reader is nil")
 	}
-	return io.NewSectionReader(pe.f, pe.OverlayOffset, 1<<63-1), nil
+	return io.NewSectionReader(bytes.NewReader(pe.data), pe.OverlayOffset,
+		1<<63-1), nil
 }
 
 // Overlay returns the overlay of the PE file.
@@ -32,7 +36,6 @@ func (pe *File) Overlay() ([]byte, error) {
 	overlay := make([]byte, int64(pe.size)-pe.OverlayOffset)
 	n, err := sr.ReadAt(overlay, 0)
 	if n == len(overlay) {
-		pe.HasOverlay = true
 		err = nil
 	}
 
@@ -42,3 +45,188 @@ func (pe *File) Overlay() ([]byte, error) {
 func (pe *File) OverlayLength() int64 {
 	return int64(pe.size) - pe.OverlayOffset
 }
+
+// OverlayFormat identifies the format of the payload appended to a PE,
+// typically by installers and self-extracting archives.
+type OverlayFormat int
+
+// Overlay payload formats.
+const (
+	OverlayFormatUnknown OverlayFormat = iota
+	OverlayFormatNSIS
+	OverlayFormatInnoSetup
+	OverlayFormat7zSFX
+	OverlayFormatCab
+	OverlayFormatMSI
+	OverlayFormatZip
+	OverlayFormatRar
+)
+
+const (
+	// nsisSignature follows the flags of the NSIS firstheader, which is
+	// aligned to 512 bytes in the file.
+	nsisSignature = "\xef\xbe\xad\xdeNullsoftInst"
+	nsisAlignment = 512
+
+	// innoOffsetTableID starts the Inno Setup loader offset table, which is
+	// stored in the RCDATA resource 11111 since Inno Setup 5.1.5, and
+	// referenced from the DOS header before.
+	innoOffsetTableID      = "rDlPtS\xcd\xe6\xd7\x7b\x0b\x2a"
+	innoOffsetTableResID   = 11111
+	innoExeHeaderID        = "Inno"
+	innoExeHeaderOffset    = 0x30
+	innoSetupDataID        = "Inno Setup Setup Data ("
+	sevenZipSignature      = "7z\xbc\xaf\x27\x1c"
+	sevenZipSFXConfigStart = ";!@Install@!UTF-8!"
+	sevenZipSFXConfigEnd   = ";!@InstallEnd@!"
+	cabSignature           = "MSCF\x00\x00\x00\x00"
+	oleSignature           = "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"
+	zipSignature           = "PK\x03\x04"
+	rarSignature           = "Rar!\x1a\x07"
+)
+
+// OverlayPayload describes the payload found in the overlay.
+type OverlayPayload struct {
+	// Format of the payload.
+	Format OverlayFormat `json:"format"`
+
+	// File offset where the payload starts, which may be past the start of
+	// the overlay.
+	Offset int64 `json:"offset"`
+
+	// Version of the installer, when available.
+	Version string `json:"version,omitempty"`
+}
+
+// String returns the name of the overlay format.
+func (f OverlayFormat) String() string {
+	overlayFormatMap := map[OverlayFormat]string{
+		OverlayFormatUnknown:   "Unknown",
+		OverlayFormatNSIS:      "NSIS",
+		OverlayFormatInnoSetup: "Inno Setup",
+		OverlayFormat7zSFX:     "7z SFX",
+		OverlayFormatCab:       "Cabinet",
+		OverlayFormatMSI:       "MSI",
+		OverlayFormatZip:       "Zip",
+		OverlayFormatRar:       "RAR",
+	}
+
+	if v, ok := overlayFormatMap[f]; ok {
+		return v
+	}
+	return "?"
+}
+
+// DetectOverlayFormat recognizes the installer payloads and archives
+// commonly appended to PE files: NSIS, Inno Setup, 7z self-extracting
+// archives, cabinets, MSI packages, Zip and RAR archives. The format is
+// OverlayFormatUnknown when the overlay does not match any of them.
+func (pe *File) DetectOverlayFormat() (OverlayPayload, error) {
+	payload := OverlayPayload{Offset: pe.OverlayOffset}
+	if !pe.HasOverlay || pe.OverlayOffset >= int64(pe.size) {
+		return payload, ErrNoOverlayFound
+	}
+	overlay := pe.data[pe.OverlayOffset:]
+
+	// Inno Setup finds its payload from an offset table, the overlay starts
+	// with the compressed setup program.
+	if offset, version, ok := pe.innoSetupPayload(); ok {
+		payload.Format = OverlayFormatInnoSetup
+		payload.Offset = offset
+		payload.Version = version
+		return payload, nil
+	}
+
+	for _, sig := range []struct {
+		signature string
+		format    OverlayFormat
+	}{
+		{sevenZipSignature, OverlayFormat7zSFX},
+		{cabSignature, OverlayFormatCab},
+		{oleSignature, OverlayFormatMSI},
+		{zipSignature, OverlayFormatZip},
+		{rarSignature, OverlayFormatRar},
+	} {
+		if bytes.HasPrefix(overlay, []byte(sig.signature)) {
+			payload.Format = sig.format
+			return payload, nil
+		}
+	}
+
+	// 7z self-extracting archives may start with their configuration.
+	if bytes.HasPrefix(overlay, []byte(sevenZipSFXConfigStart)) {
+		end := bytes.Index(overlay, []byte(sevenZipSFXConfigEnd))
+		if end >= 0 {
+			end += len(sevenZipSFXConfigEnd)
+			archive := bytes.Index(overlay[end:], []byte(sevenZipSignature))
+			if archive >= 0 {
+				payload.Format = OverlayFormat7zSFX
+				payload.Offset += int64(end + archive)
+				return payload, nil
+			}
+		}
+	}
+
+	// The NSIS firstheader is searched at every 512 bytes boundary of the
+	// file, as done by the NSIS stub.
+	start := (pe.OverlayOffset + nsisAlignment - 1) / nsisAlignment * nsisAlignment
+	for off := start; off+4+int64(len(nsisSignature)) <= int64(pe.size); off += nsisAlignment {
+		if bytes.HasPrefix(pe.data[off+4:], []byte(nsisSignature)) {
+			payload.Format = OverlayFormatNSIS
+			payload.Offset = off
+			return payload, nil
+		}
+	}
+
+	return payload, nil
+}
+
+// innoSetupPayload locates the Inno Setup loader offset table and returns
+// the offset of the compressed setup program along with the Inno Setup
+// version found in the setup data, if any.
+func (pe *File) innoSetupPayload() (int64, string, bool) {
+	var table []byte
+	var hasVersion bool
+	for _, e := range pe.rcDataEntries() {
+		if e.ID == innoOffsetTableResID {
+			table = pe.resourceData(e)
+			hasVersion = true
+			break
+		}
+	}
+	if table == nil && pe.size >= innoExeHeaderOffset+8 &&
+		string(pe.data[innoExeHeaderOffset:innoExeHeaderOffset+4]) == innoExeHeaderID {
+		offset := binary.LittleEndian.Uint32(pe.data[innoExeHeaderOffset+4:])
+		if uint64(offset) < pe.size {
+			table = pe.data[offset:]
+		}
+	}
+	if !bytes.HasPrefix(table, []byte(innoOffsetTableID)) {
+		return 0, "", false
+	}
+
+	// Since Inno Setup 5.1.5, a version field follows the table ID. Before,
+	// the offset of the compressed program was followed by its compressed
+	// size, uncompressed size, checksum and the offset of a message.
+	offsetEXEField, offset0Field := 16, 36
+	if hasVersion {
+		offsetEXEField, offset0Field = 20, 32
+	}
+	if len(table) < offset0Field+4 {
+		return 0, "", false
+	}
+	offsetEXE := binary.LittleEndian.Uint32(table[offsetEXEField:])
+	offset0 := binary.LittleEndian.Uint32(table[offset0Field:])
+
+	var version string
+	if uint64(offset0) < pe.size {
+		data := pe.data[offset0:]
+		if bytes.HasPrefix(data, []byte(innoSetupDataID)) {
+			data = data[len(innoSetupDataID):]
+			if end := bytes.IndexByte(data, ')'); end >= 0 && end < 64 {
+				version = strings.TrimSpace(string(data[:end]))
+			}
+		}
+	}
+	return int64(offsetEXE), version, true
+}
@@ -2,7 +2,9 @@ package pe
 
 import (
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
+	"io/ioutil"
 	"testing"
 )
 
@@ -54,3 +56,94 @@ func TestFile_NewOverlayReader(t *testing.T) {
 		})
 	}
 }
+
+func TestDetectOverlayFormat(t *testing.T) {
+
+	// The overlay of putty.exe starts at 0x11c000 with its certificate, it
+	// is replaced by the payloads below.
+	const overlayOffset = 0x11c000
+	innoTable := make([]byte, 0x100)
+	copy(innoTable, innoOffsetTableID)
+	binary.LittleEndian.PutUint32(innoTable[16:], overlayOffset)
+	binary.LittleEndian.PutUint32(innoTable[36:], overlayOffset+0x200)
+	innoSetup := append(append(innoTable, make([]byte, 0x100)...),
+		"Inno Setup Setup Data (5.0.8)"...)
+
+	tests := []struct {
+		name    string
+		payload string
+		out     OverlayPayload
+	}{
+		{"nsis", string(make([]byte, 0x200)) + "\x00\x00\x00\x00" + nsisSignature,
+			OverlayPayload{OverlayFormatNSIS, overlayOffset + 0x200, ""}},
+		{"inno", string(innoSetup),
+			OverlayPayload{OverlayFormatInnoSetup, overlayOffset, "5.0.8"}},
+		{"7z", sevenZipSignature,
+			OverlayPayload{OverlayFormat7zSFX, overlayOffset, ""}},
+		{"7z-config", sevenZipSFXConfigStart + "\r\nTitle=\"x\"\r\n" +
+			sevenZipSFXConfigEnd + sevenZipSignature,
+			OverlayPayload{OverlayFormat7zSFX, overlayOffset + 46, ""}},
+		{"cab", cabSignature,
+			OverlayPayload{OverlayFormatCab, overlayOffset, ""}},
+		{"msi", oleSignature,
+			OverlayPayload{OverlayFormatMSI, overlayOffset, ""}},
+		{"zip", zipSignature,
+			OverlayPayload{OverlayFormatZip, overlayOffset, ""}},
+		{"rar", rarSignature + "\x00",
+			OverlayPayload{OverlayFormatRar, overlayOffset, ""}},
+		{"unknown", "unknown",
+			OverlayPayload{OverlayFormatUnknown, overlayOffset, ""}},
+	}
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(append([]byte{}, src[:overlayOffset]...), tt.payload...)
+			if tt.out.Format == OverlayFormatInnoSetup {
+				// Inno Setup before 5.1.5 references its offset table
+				// from the DOS header.
+				copy(data[innoExeHeaderOffset:], innoExeHeaderID)
+				binary.LittleEndian.PutUint32(data[innoExeHeaderOffset+4:],
+					overlayOffset)
+			}
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			got, err := file.DetectOverlayFormat()
+			if err != nil {
+				t.Fatalf("DetectOverlayFormat(%s) failed, reason: %v",
+					filename, err)
+			}
+			if got != tt.out {
+				t.Errorf("overlay payload assertion failed, got %+v, want %+v",
+					got, tt.out)
+			}
+		})
+	}
+
+	file, err := NewBytes(src[:overlayOffset], &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	_, err = file.DetectOverlayFormat()
+	if err != ErrNoOverlayFound {
+		t.Errorf("DetectOverlayFormat(%s) error assertion failed, got %v, "+
+			"want %v", filename, err, ErrNoOverlayFound)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+// Parser parses many files one after the other, reusing the memory of the
+// previous file: the slices of sections, imports, exports, relocations,
+// exceptions, anomalies and so on keep their capacity, and the options and
+// the logger are set up once. This reduces the pressure on the garbage
+// collector in scanners processing a large number of files.
+//
+// The File returned by Reset is owned by the Parser: it and everything read
+// from it, including the slices it holds, are only valid until the next call
+// to Reset. Copy what needs to outlive it. A Parser is not safe for
+// concurrent use, use one Parser per goroutine.
+type Parser struct {
+	file File
+}
+
+// NewParser instantiates a parser with the given options, which apply to
+// every file it parses.
+func NewParser(opts *Options) *Parser {
+	file, _ := NewBytes(nil, opts)
+	return &Parser{file: *file}
+}
+
+// Reset prepares the parser for a new file given a memory buffer, and
+// returns the File to call Parse on. Handlers registered with OnDirectory and
+// OnSection on a previously returned File are kept.
+func (p *Parser) Reset(data []byte) *File {
+	prev := &p.file
+	p.file = File{
+		Sections:     prev.Sections[:0],
+		Imports:      prev.Imports[:0],
+		Export:       Export{Functions: prev.Export.Functions[:0]},
+		Debugs:       prev.Debugs[:0],
+		Relocations:  prev.Relocations[:0],
+		Exceptions:   prev.Exceptions[:0],
+		DelayImports: prev.DelayImports[:0],
+		BoundImports: prev.BoundImports[:0],
+		IAT:          prev.IAT[:0],
+		Anomalies:    prev.Anomalies[:0],
+		AnomalyIDs:   prev.AnomalyIDs[:0],
+		sectionMap:   prev.sectionMap[:0],
+		coverage:     prev.coverage[:0],
+		hooks:        prev.hooks,
+		opts:         prev.opts,
+		logger:       prev.logger,
+	}
+	p.file.data = data
+	p.file.size = uint64(len(data))
+	return &p.file
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// deepEqualSlices is like reflect.DeepEqual for slices, except that nil and
+// empty slices are equal, as the slices reused by the parser are never nil.
+func deepEqualSlices(a, b interface{}) bool {
+	if reflect.ValueOf(a).Len() == 0 && reflect.ValueOf(b).Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestParserReset(t *testing.T) {
+
+	filenames := []string{
+		getAbsoluteFilePath("test/kernel32.dll"),
+		getAbsoluteFilePath("test/putty.exe"),
+		getAbsoluteFilePath("test/impbyord.exe"),
+		getAbsoluteFilePath("test/kernel32.dll"),
+	}
+
+	p := NewParser(&Options{})
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+		}
+
+		want, err := NewBytes(data, &Options{})
+		if err != nil {
+			t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+		}
+		err = want.Parse()
+		if err != nil {
+			t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+		}
+
+		got := p.Reset(data)
+		err = got.Parse()
+		if err != nil {
+			t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+		}
+
+		if !deepEqualSlices(got.Sections, want.Sections) {
+			t.Errorf("sections of %s assertion failed", filename)
+		}
+		if !deepEqualSlices(got.Imports, want.Imports) {
+			t.Errorf("imports of %s assertion failed", filename)
+		}
+		if got.Export.Name != want.Export.Name ||
+			!deepEqualSlices(got.Export.Functions, want.Export.Functions) {
+			t.Errorf("exports of %s assertion failed", filename)
+		}
+		if !deepEqualSlices(got.Relocations, want.Relocations) {
+			t.Errorf("relocations of %s assertion failed", filename)
+		}
+		if !deepEqualSlices(got.Exceptions, want.Exceptions) {
+			t.Errorf("exceptions of %s assertion failed", filename)
+		}
+		if !deepEqualSlices(got.Anomalies, want.Anomalies) {
+			t.Errorf("anomalies of %s assertion failed, got %v, want %v",
+				filename, got.Anomalies, want.Anomalies)
+		}
+		if got.FileInfo != want.FileInfo {
+			t.Errorf("file info of %s assertion failed, got %+v, want %+v",
+				filename, got.FileInfo, want.FileInfo)
+		}
+	}
+}
+
+func BenchmarkParser(b *testing.B) {
+	filename := getAbsoluteFilePath("test/kernel32.dll")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		b.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	b.Run("NewBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				b.Fatalf("NewBytes() failed, reason: %v", err)
+			}
+			err = file.Parse()
+			if err != nil {
+				b.Fatalf("Parse() failed, reason: %v", err)
+			}
+		}
+	})
+
+	b.Run("Reset", func(b *testing.B) {
+		b.ReportAllocs()
+		p := NewParser(&Options{})
+		for i := 0; i < b.N; i++ {
+			err := p.Reset(data).Parse()
+			if err != nil {
+				b.Fatalf("Parse() failed, reason: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrDataDirectoryEmpty is returned when requesting the byte range of a data
+// directory entry which is not present.
+var ErrDataDirectoryEmpty = errors.New("data directory entry is empty")
+
+// ByteRange locates a structure in the raw file.
+type ByteRange struct {
+	// Offset is the file offset where the structure starts.
+	Offset uint32 `json:"offset"`
+
+	// Length is the number of bytes the structure occupies.
+	Length uint32 `json:"length"`
+}
+
+// End returns the file offset immediately following the range.
+func (r ByteRange) End() uint64 {
+	return uint64(r.Offset) + uint64(r.Length)
+}
+
+// Raw returns the bytes of the file covered by the given range.
+func (pe *File) Raw(r ByteRange) ([]byte, error) {
+	return pe.ReadBytesAtOffset(r.Offset, r.Length)
+}
+
+// DOSHeaderRange returns the byte range of the DOS header.
+func (pe *File) DOSHeaderRange() ByteRange {
+	return ByteRange{Offset: 0, Length: uint32(binary.Size(pe.DOSHeader))}
+}
+
+// DOSStubRange returns the byte range of the DOS stub program.
+func (pe *File) DOSStubRange() ByteRange {
+	return ByteRange{Offset: pe.DOSStub.Offset, Length: pe.DOSStub.Size}
+}
+
+// RichHeaderRange returns the byte range of the Rich header, from the `DanS`
+// signature to the XOR key which follows the `Rich` signature.
+func (pe *File) RichHeaderRange() ByteRange {
+	length := uint32(len(pe.RichHeader.Raw))
+
+	// The raw bytes might have been dropped after parsing, see RetainRaw.
+	if length == 0 && pe.HasRichHdr {
+		ntHeaderOffset := pe.DOSHeader.AddressOfNewEXEHeader
+		richSigOffset := bytes.Index(pe.data[:ntHeaderOffset], []byte(RichSignature))
+		if richSigOffset > pe.RichHeader.DansOffset {
+			length = uint32(richSigOffset + 8 - pe.RichHeader.DansOffset)
+		}
+	}
+
+	return ByteRange{
+		Offset: uint32(pe.RichHeader.DansOffset),
+		Length: length,
+	}
+}
+
+// NtHeaderRange returns the byte range of the NT headers: the PE signature,
+// the file header and the optional header as sized by SizeOfOptionalHeader.
+func (pe *File) NtHeaderRange() ByteRange {
+	return ByteRange{
+		Offset: pe.DOSHeader.AddressOfNewEXEHeader,
+		Length: 4 + uint32(binary.Size(pe.NtHeader.FileHeader)) +
+			uint32(pe.NtHeader.FileHeader.SizeOfOptionalHeader),
+	}
+}
+
+// FileHeaderRange returns the byte range of the COFF file header.
+func (pe *File) FileHeaderRange() ByteRange {
+	return ByteRange{
+		Offset: pe.DOSHeader.AddressOfNewEXEHeader + 4,
+		Length: uint32(binary.Size(pe.NtHeader.FileHeader)),
+	}
+}
+
+// OptionalHeaderRange returns the byte range of the optional header as sized
+// by the SizeOfOptionalHeader field of the file header.
+func (pe *File) OptionalHeaderRange() ByteRange {
+	fileHeader := pe.FileHeaderRange()
+	return ByteRange{
+		Offset: fileHeader.Offset + fileHeader.Length,
+		Length: uint32(pe.NtHeader.FileHeader.SizeOfOptionalHeader),
+	}
+}
+
+// SectionHeaderRange returns the byte range of the header of a section
+// found in File.Sections.
+func (pe *File) SectionHeaderRange(section *Section) ByteRange {
+	return ByteRange{
+		Offset: section.headerOffset,
+		Length: uint32(binary.Size(section.Header)),
+	}
+}
+
+// SectionHeaderRanges returns the byte ranges of the section headers, in the
+// same order as File.Sections.
+func (pe *File) SectionHeaderRanges() []ByteRange {
+	ranges := make([]ByteRange, 0, len(pe.Sections))
+	for i := range pe.Sections {
+		ranges = append(ranges, pe.SectionHeaderRange(&pe.Sections[i]))
+	}
+	return ranges
+}
+
+// DataDirectoryRange returns the byte range of the content of a data
+// directory entry. The range covers the size declared in the data directory
+// and might extend beyond the end of the file, Raw reports it.
+func (pe *File) DataDirectoryRange(entry ImageDirectoryEntry) (ByteRange, error) {
+	if entry < ImageDirectoryEntryExport || entry > ImageDirectoryEntryReserved {
+		return ByteRange{}, ErrOutsideBoundary
+	}
+
+	dataDir := pe.dataDirectory(entry)
+	if dataDir.VirtualAddress == 0 {
+		return ByteRange{}, ErrDataDirectoryEmpty
+	}
+
+	// The certificate table address is a file offset, not an RVA.
+	offset := dataDir.VirtualAddress
+	if entry != ImageDirectoryEntryCertificate {
+		offset = pe.GetOffsetFromRva(dataDir.VirtualAddress)
+	}
+	if err := pe.checkRange(offset, 0); err != nil {
+		return ByteRange{}, err
+	}
+
+	return ByteRange{Offset: offset, Length: dataDir.Size}, nil
+}
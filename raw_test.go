@@ -0,0 +1,87 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestByteRanges(t *testing.T) {
+	filename := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(filename, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	tests := []struct {
+		name string
+		got  ByteRange
+		want ByteRange
+	}{
+		{"dos header", file.DOSHeaderRange(), ByteRange{0, 0x40}},
+		{"nt header", file.NtHeaderRange(), ByteRange{0x78, 0x108}},
+		{"file header", file.FileHeaderRange(), ByteRange{0x7c, 0x14}},
+		{"optional header", file.OptionalHeaderRange(), ByteRange{0x90, 0xf0}},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s range assertion failed, got %v, want %v",
+				tt.name, tt.got, tt.want)
+		}
+	}
+
+	b, err := file.Raw(file.DOSHeaderRange())
+	if err != nil || !bytes.HasPrefix(b, []byte("MZ")) {
+		t.Errorf("Raw(DOS header) got %v, err: %v", b, err)
+	}
+	b, err = file.Raw(file.NtHeaderRange())
+	if err != nil || !bytes.HasPrefix(b, []byte("PE\x00\x00")) {
+		t.Errorf("Raw(NT header) got %v, err: %v", b, err)
+	}
+
+	ranges := file.SectionHeaderRanges()
+	if len(ranges) != len(file.Sections) {
+		t.Fatalf("section header ranges count assertion failed, got %v, want %v",
+			len(ranges), len(file.Sections))
+	}
+	for i, r := range ranges {
+		b, err = file.Raw(r)
+		if err != nil {
+			t.Fatalf("Raw(%v) failed, reason: %v", r, err)
+		}
+		name := file.Sections[i].Header.Name
+		if !bytes.Equal(b[:len(name)], name[:]) {
+			t.Errorf("section header range %v does not point to section %s",
+				r, file.Sections[i].String())
+		}
+	}
+
+	r, err := file.DataDirectoryRange(ImageDirectoryEntryImport)
+	if err != nil {
+		t.Fatalf("DataDirectoryRange(Import) failed, reason: %v", err)
+	}
+	if want := (ByteRange{0xc3fe8, 0xb4}); r != want {
+		t.Errorf("import directory range assertion failed, got %v, want %v", r, want)
+	}
+
+	r, err = file.DataDirectoryRange(ImageDirectoryEntryCertificate)
+	if err != nil {
+		t.Fatalf("DataDirectoryRange(Certificate) failed, reason: %v", err)
+	}
+	if r.End() != uint64(len(file.data)) {
+		t.Errorf("certificate range end assertion failed, got %v, want %v",
+			r.End(), len(file.data))
+	}
+
+	_, err = file.DataDirectoryRange(ImageDirectoryEntryExport)
+	if err != ErrDataDirectoryEmpty {
+		t.Errorf("DataDirectoryRange(Export) got %v, want %v", err, ErrDataDirectoryEmpty)
+	}
+}
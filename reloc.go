@@ -7,6 +7,7 @@ package pe
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 )
 
 var (
@@ -17,6 +18,42 @@ var (
 	// ErrInvalidBasicRelocSizeOfBloc is reposed when base reloc is too large.
 	ErrInvalidBasicRelocSizeOfBloc = errors.New("invalid relocation " +
 		"information. Base Relocation SizeOfBlock too large")
+
+	// ErrInvalidRelocType is reported when a base relocation type is not
+	// valid for the machine of the image.
+	ErrInvalidRelocType = errors.New("base relocation type is not valid " +
+		"for the machine")
+
+	// ErrUnsupportedRelocType is reported when applying a base relocation
+	// type whose semantics are not implemented.
+	ErrUnsupportedRelocType = errors.New("base relocation type is not " +
+		"supported")
+
+	// ErrRelocOutsideImage is reported when a base relocation targets bytes
+	// outside of the mapped image.
+	ErrRelocOutsideImage = errors.New("base relocation target is outside " +
+		"of the image")
+
+	// ErrRelocHighAdjTruncated is reported when a HighAdj base relocation is
+	// the last entry of its block and misses the slot holding its low 16 bits.
+	ErrRelocHighAdjTruncated = errors.New("base relocation HighAdj misses " +
+		"its second slot")
+)
+
+const (
+	// AnoRelocTypeInvalidForMachine is reported when a base relocation type
+	// does not make sense for the machine of the image, for instance an ARM
+	// MOV32 relocation in an x64 image.
+	AnoRelocTypeInvalidForMachine = "Relocation type is not valid for the machine"
+
+	// AnoRelocsStrippedASLR is reported when the relocations are stripped
+	// from an image which nonetheless opts in to ASLR. The loader cannot
+	// relocate it, it is loaded at its preferred base or fails to load.
+	AnoRelocsStrippedASLR = "Relocations are stripped but the image requests ASLR"
+
+	// AnoRelocPageOutsideImage is reported when a base relocation block
+	// patches a page at or past SizeOfImage.
+	AnoRelocPageOutsideImage = "Base relocation page is outside of the image"
 )
 
 // ImageBaseRelocationEntryType represents the type of an in image base relocation entry.
@@ -138,7 +175,7 @@ func (pe *File) parseRelocations(dataRVA, rva, size uint32) ([]ImageBaseRelocati
 
 	relocEntriesCount := size / 2
 	if relocEntriesCount > pe.opts.MaxRelocEntriesCount {
-		pe.Anomalies = append(pe.Anomalies, AnoAddressOfDataBeyondLimits)
+		pe.appendAnomaly(AnoAddressOfDataBeyondLimits)
 	}
 
 	offset := pe.GetOffsetFromRva(dataRVA)
@@ -158,14 +195,7 @@ func (pe *File) parseRelocations(dataRVA, rva, size uint32) ([]ImageBaseRelocati
 }
 
 func (pe *File) parseRelocDirectory(rva, size uint32) error {
-	var sizeOfImage uint32
-	switch pe.Is64 {
-	case true:
-		sizeOfImage = pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).SizeOfImage
-	case false:
-		sizeOfImage = pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).SizeOfImage
-	}
-
+	sizeOfImage := pe.sizeOfImage()
 	relocSize := uint32(binary.Size(ImageBaseRelocation{}))
 	end := rva + size
 	for rva < end {
@@ -178,6 +208,7 @@ func (pe *File) parseRelocDirectory(rva, size uint32) error {
 
 		// VirtualAddress must lie within the Image.
 		if baseReloc.VirtualAddress > sizeOfImage {
+			pe.addAnomaly(AnoRelocPageOutsideImage)
 			return ErrInvalidBaseRelocVA
 		}
 
@@ -193,6 +224,25 @@ func (pe *File) parseRelocDirectory(rva, size uint32) error {
 			return err
 		}
 
+		forEachReloc(relocEntries, func(entry ImageBaseRelocationEntry,
+			param uint16, ok bool) bool {
+			if pe.relocTypeValid(entry.Type) {
+				return true
+			}
+			if !stringInSlice(AnoRelocTypeInvalidForMachine, pe.Anomalies) {
+				pe.addAnomaly(AnoRelocTypeInvalidForMachine)
+				pe.warnf(ImageDirectoryEntryBaseReloc.String(), offset,
+					"relocation type %d is not valid for machine %s",
+					entry.Type, pe.NtHeader.FileHeader.Machine.String())
+			}
+			return false
+		})
+
+		if relocPageOutsideImage(baseReloc.VirtualAddress, relocEntries,
+			sizeOfImage) {
+			pe.addAnomaly(AnoRelocPageOutsideImage)
+		}
+
 		pe.Relocations = append(pe.Relocations, Relocation{
 			Data:    baseReloc,
 			Entries: relocEntries,
@@ -211,6 +261,100 @@ func (pe *File) parseRelocDirectory(rva, size uint32) error {
 	return nil
 }
 
+// relocPageOutsideImage tells whether a base relocation block patches bytes
+// at or past SizeOfImage. Absolute entries only pad the block.
+func relocPageOutsideImage(page uint32, entries []ImageBaseRelocationEntry,
+	sizeOfImage uint32) bool {
+	if page >= sizeOfImage {
+		return true
+	}
+	outside := false
+	forEachReloc(entries, func(entry ImageBaseRelocationEntry,
+		param uint16, ok bool) bool {
+		if entry.Type != ImageRelBasedAbsolute &&
+			uint64(page)+uint64(entry.Offset) >= uint64(sizeOfImage) {
+			outside = true
+		}
+		return !outside
+	})
+	return outside
+}
+
+// RelocationStats summarizes the base relocations of an image, which tell
+// whether the image can actually be relocated when it opts in to ASLR.
+type RelocationStats struct {
+	// The relocations are stripped from the image, the file header
+	// characteristics has the RelocsStripped flag.
+	Stripped bool `json:"stripped"`
+
+	// The image opts in to ASLR, the optional header DllCharacteristics has
+	// the DynamicBase flag.
+	DynamicBase bool `json:"dynamic_base"`
+
+	// Number of base relocation blocks, one per 4K page.
+	Blocks int `json:"blocks"`
+
+	// Number of base relocations, Absolute padding entries included. The slot
+	// following a HighAdj relocation is not a relocation and is not counted.
+	Entries int `json:"entries"`
+
+	// Number of base relocations per type.
+	ByType map[ImageBaseRelocationEntryType]int `json:"by_type"`
+
+	// Number of base relocations, Absolute padding entries excluded, per name
+	// of the section holding the patched bytes. Relocations outside of any
+	// section are counted under an empty name.
+	BySection map[string]int `json:"by_section"`
+
+	// Pages patched at or past SizeOfImage, see AnoRelocPageOutsideImage.
+	PagesOutsideImage []uint32 `json:"pages_outside_image"`
+}
+
+// RelocationStats returns the statistics of the base relocations, the file
+// must be parsed before calling RelocationStats.
+func (pe *File) RelocationStats() RelocationStats {
+	var dllCharacteristics ImageOptionalHeaderDllCharacteristicsType
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			dllCharacteristics = oh64.DllCharacteristics
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			dllCharacteristics = oh32.DllCharacteristics
+		}
+	}
+	sizeOfImage := pe.sizeOfImage()
+
+	stats := RelocationStats{
+		Stripped: pe.NtHeader.FileHeader.Characteristics&
+			ImageFileRelocsStripped != 0,
+		DynamicBase: dllCharacteristics&
+			ImageDllCharacteristicsDynamicBase != 0,
+		Blocks:    len(pe.Relocations),
+		ByType:    make(map[ImageBaseRelocationEntryType]int),
+		BySection: make(map[string]int),
+	}
+
+	for _, reloc := range pe.Relocations {
+		page := reloc.Data.VirtualAddress
+		forEachReloc(reloc.Entries, func(entry ImageBaseRelocationEntry,
+			param uint16, ok bool) bool {
+			stats.Entries++
+			stats.ByType[entry.Type]++
+			if entry.Type != ImageRelBasedAbsolute {
+				rva := page + uint32(entry.Offset)
+				stats.BySection[pe.getSectionNameByRva(rva)]++
+			}
+			return true
+		})
+		if relocPageOutsideImage(page, reloc.Entries, sizeOfImage) {
+			stats.PagesOutsideImage = append(stats.PagesOutsideImage, page)
+		}
+	}
+	return stats
+}
+
 // String returns the string representation of the `Type` field of a base reloc entry.
 func (t ImageBaseRelocationEntryType) String(pe *File) string {
 	relocTypesMap := map[ImageBaseRelocationEntryType]string{
@@ -230,7 +374,7 @@ func (t ImageBaseRelocationEntryType) String(pe *File) string {
 	}
 
 	switch pe.NtHeader.FileHeader.Machine {
-	case ImageFileMachineMIPS16, ImageFileMachineMIPSFPU, ImageFileMachineMIPSFPU16, ImageFileMachineWCEMIPSv2:
+	case ImageFileMachineMIPS16, ImageFileMachineMIPSFPU, ImageFileMachineMIPSFPU16, ImageFileMachineWCEMIPSv2, ImageFileMachineR4000:
 		if t == ImageRelBasedMIPSJmpAddr {
 			return "MIPS JMP Addr"
 		}
@@ -255,3 +399,337 @@ func (t ImageBaseRelocationEntryType) String(pe *File) string {
 
 	return "?"
 }
+
+// isMIPSMachine tells whether the machine belongs to the MIPS family.
+func isMIPSMachine(machine ImageFileHeaderMachineType) bool {
+	switch machine {
+	case ImageFileMachineMIPS16, ImageFileMachineMIPSFPU,
+		ImageFileMachineMIPSFPU16, ImageFileMachineWCEMIPSv2,
+		ImageFileMachineR4000:
+		return true
+	}
+	return false
+}
+
+// isRISCVMachine tells whether the machine belongs to the RISC-V family.
+func isRISCVMachine(machine ImageFileHeaderMachineType) bool {
+	switch machine {
+	case ImageFileMachineRISCV32, ImageFileMachineRISCV64,
+		ImageFileMachineRISCV128:
+		return true
+	}
+	return false
+}
+
+// relocTypeValid tells whether a base relocation type makes sense for the
+// machine of the image. The types 5 to 9 are interpreted according to the
+// machine, DIR64 only applies to PE32+ images.
+func (pe *File) relocTypeValid(t ImageBaseRelocationEntryType) bool {
+	machine := pe.NtHeader.FileHeader.Machine
+	switch t {
+	case ImageRelBasedAbsolute, ImageRelBasedHigh, ImageRelBasedLow,
+		ImageRelBasedHighLow, ImageRelBasedHighAdj:
+		return true
+	case ImageRelBasedMIPSJmpAddr:
+		return isMIPSMachine(machine) || isRISCVMachine(machine) ||
+			machine == ImageFileMachineARM ||
+			machine == ImageFileMachineARMNT ||
+			machine == ImageFileMachineTHUMB
+	case ImageRelBasedThumbMov32:
+		return isRISCVMachine(machine) ||
+			machine == ImageFileMachineARMNT ||
+			machine == ImageFileMachineTHUMB
+	case ImageRelBasedRISCVLow12s:
+		// LoongArch reuses the type for IMAGE_REL_BASED_LOONGARCH_MARK_LA.
+		return isRISCVMachine(machine) ||
+			machine == ImageFileMachineLoongArch32 ||
+			machine == ImageFileMachineLoongArch64
+	case ImageRelBasedMIPSJmpAddr16:
+		// Itanium reuses the type for IMAGE_REL_BASED_IA64_IMM64.
+		return isMIPSMachine(machine) || machine == ImageFileMachineIA64
+	case ImageRelBasedDir64:
+		return pe.Is64
+	}
+	return false
+}
+
+// forEachReloc calls fn for every base relocation of a block. The slot which
+// follows a HighAdj relocation holds the low 16 bits of the value to relocate
+// rather than a relocation, it is passed as param and skipped. ok is false
+// when the block ends before the slot. Iteration stops when fn returns false.
+func forEachReloc(entries []ImageBaseRelocationEntry,
+	fn func(entry ImageBaseRelocationEntry, param uint16, ok bool) bool) {
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		param, ok := uint16(0), true
+		if entry.Type == ImageRelBasedHighAdj {
+			i++
+			if i < len(entries) {
+				param = entries[i].Data
+			} else {
+				ok = false
+			}
+		}
+		if !fn(entry, param, ok) {
+			return
+		}
+	}
+}
+
+// Rebase applies the base relocations to an image mapped in memory, as
+// written by WriteMappedImage, so that it can run at newBase. The ImageBase
+// field of the optional header of the image tells the address the image is
+// currently relocated for and is updated as the loader does, which lets the
+// same image be rebased several times. The file must be parsed before calling
+// Rebase.
+func (pe *File) Rebase(image []byte, newBase uint64) error {
+
+	imageBase := pe.imageBase()
+	imageBaseOffset := pe.DOSHeader.AddressOfNewEXEHeader + 4 +
+		uint32(binary.Size(pe.NtHeader.FileHeader))
+	switch pe.Is64 {
+	case true:
+		imageBaseOffset += 24
+	case false:
+		imageBaseOffset += 28
+	}
+
+	// The image may have been rebased already, the loader keeps the current
+	// base address in the header of the image.
+	switch pe.Is64 {
+	case true:
+		if uint64(imageBaseOffset)+8 <= uint64(len(image)) {
+			imageBase = binary.LittleEndian.Uint64(image[imageBaseOffset:])
+		}
+	case false:
+		if uint64(imageBaseOffset)+4 <= uint64(len(image)) {
+			imageBase = uint64(binary.LittleEndian.Uint32(image[imageBaseOffset:]))
+		}
+	}
+
+	delta := newBase - imageBase
+	var err error
+	for _, reloc := range pe.Relocations {
+		forEachReloc(reloc.Entries, func(entry ImageBaseRelocationEntry,
+			param uint16, ok bool) bool {
+			rva := reloc.Data.VirtualAddress + uint32(entry.Offset)
+			if !ok {
+				err = fmt.Errorf("%w at RVA 0x%x", ErrRelocHighAdjTruncated, rva)
+				return false
+			}
+			err = pe.applyReloc(image, rva, entry.Type, param, delta)
+			if err != nil {
+				err = fmt.Errorf("%w, type %d at RVA 0x%x", err, entry.Type, rva)
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	switch pe.Is64 {
+	case true:
+		if uint64(imageBaseOffset)+8 <= uint64(len(image)) {
+			binary.LittleEndian.PutUint64(image[imageBaseOffset:], newBase)
+		}
+	case false:
+		if uint64(imageBaseOffset)+4 <= uint64(len(image)) {
+			binary.LittleEndian.PutUint32(image[imageBaseOffset:], uint32(newBase))
+		}
+	}
+	return nil
+}
+
+// applyReloc applies a base relocation at the given RVA of a mapped image,
+// delta being the difference between the new and the preferred base address.
+// param holds the low 16 bits of the value relocated by a HighAdj relocation.
+func (pe *File) applyReloc(image []byte, rva uint32,
+	t ImageBaseRelocationEntryType, param uint16, delta uint64) error {
+
+	if !pe.relocTypeValid(t) {
+		return ErrInvalidRelocType
+	}
+
+	// The number of bytes the relocation patches.
+	size := uint64(0)
+	switch t {
+	case ImageRelBasedHigh, ImageRelBasedLow, ImageRelBasedHighAdj:
+		size = 2
+	case ImageRelBasedHighLow, ImageRelBasedMIPSJmpAddr,
+		ImageRelBasedThumbMov32, ImageRelBasedRISCVLow12s,
+		ImageRelBasedMIPSJmpAddr16:
+		size = 4
+	case ImageRelBasedDir64:
+		size = 8
+	}
+	machine := pe.NtHeader.FileHeader.Machine
+	if t == ImageRelBasedMIPSJmpAddr && !isMIPSMachine(machine) &&
+		!isRISCVMachine(machine) {
+		// The MOVW/MOVT pair.
+		size = 8
+	}
+	if t == ImageRelBasedThumbMov32 && !isRISCVMachine(machine) {
+		size = 8
+	}
+	if uint64(rva)+size > uint64(len(image)) {
+		return ErrRelocOutsideImage
+	}
+	b := image[rva:]
+
+	switch t {
+	case ImageRelBasedAbsolute:
+
+	case ImageRelBasedHigh:
+		v := binary.LittleEndian.Uint16(b)
+		binary.LittleEndian.PutUint16(b, v+uint16(delta>>16))
+
+	case ImageRelBasedLow:
+		v := binary.LittleEndian.Uint16(b)
+		binary.LittleEndian.PutUint16(b, v+uint16(delta))
+
+	case ImageRelBasedHighLow:
+		v := binary.LittleEndian.Uint32(b)
+		binary.LittleEndian.PutUint32(b, v+uint32(delta))
+
+	case ImageRelBasedHighAdj:
+		// The low half is sign extended, the high half is rounded.
+		v := binary.LittleEndian.Uint16(b)
+		value := uint32(v)<<16 + uint32(int32(int16(param)))
+		value += uint32(delta) + 0x8000
+		binary.LittleEndian.PutUint16(b, uint16(value>>16))
+
+	case ImageRelBasedDir64:
+		v := binary.LittleEndian.Uint64(b)
+		binary.LittleEndian.PutUint64(b, v+delta)
+
+	case ImageRelBasedMIPSJmpAddr:
+		switch {
+		case isMIPSMachine(machine):
+			relocMIPSJmpAddr(b, delta)
+		case isRISCVMachine(machine):
+			relocRISCVHigh20(b, delta)
+		case machine == ImageFileMachineARM:
+			relocARMMov32(b, delta)
+		default:
+			relocThumbMov32(b, delta)
+		}
+
+	case ImageRelBasedThumbMov32:
+		switch {
+		case isRISCVMachine(machine):
+			relocRISCVLow12i(b, delta)
+		default:
+			relocThumbMov32(b, delta)
+		}
+
+	case ImageRelBasedRISCVLow12s:
+		if !isRISCVMachine(machine) {
+			return ErrUnsupportedRelocType
+		}
+		relocRISCVLow12s(b, delta)
+
+	case ImageRelBasedMIPSJmpAddr16:
+		if !isMIPSMachine(machine) {
+			return ErrUnsupportedRelocType
+		}
+		relocMIPSJmpAddr16(b, delta)
+	}
+
+	return nil
+}
+
+// relocARMMov32 relocates the 32-bit address loaded by an ARM MOVW/MOVT
+// instruction pair. Each instruction holds 16 bits of the address as imm4:imm12.
+func relocARMMov32(b []byte, delta uint64) {
+	movw := binary.LittleEndian.Uint32(b)
+	movt := binary.LittleEndian.Uint32(b[4:])
+	decode := func(inst uint32) uint32 {
+		return (inst>>16&0xf)<<12 | inst&0xfff
+	}
+	encode := func(inst, imm uint32) uint32 {
+		return inst&^0xf0fff | (imm>>12&0xf)<<16 | imm&0xfff
+	}
+
+	value := decode(movw) | decode(movt)<<16
+	value += uint32(delta)
+	binary.LittleEndian.PutUint32(b, encode(movw, value&0xffff))
+	binary.LittleEndian.PutUint32(b[4:], encode(movt, value>>16))
+}
+
+// relocThumbMov32 relocates the 32-bit address loaded by a Thumb-2 MOVW/MOVT
+// instruction pair. Each instruction is made of two halfwords and holds 16
+// bits of the address as imm4:i:imm3:imm8.
+func relocThumbMov32(b []byte, delta uint64) {
+	decode := func(b []byte) uint32 {
+		hw1 := uint32(binary.LittleEndian.Uint16(b))
+		hw2 := uint32(binary.LittleEndian.Uint16(b[2:]))
+		return (hw1&0xf)<<12 | (hw1>>10&1)<<11 | (hw2>>12&7)<<8 | hw2&0xff
+	}
+	encode := func(b []byte, imm uint32) {
+		hw1 := uint32(binary.LittleEndian.Uint16(b))
+		hw2 := uint32(binary.LittleEndian.Uint16(b[2:]))
+		hw1 = hw1&^0x040f | imm>>12&0xf | (imm>>11&1)<<10
+		hw2 = hw2&^0x70ff | (imm>>8&7)<<12 | imm&0xff
+		binary.LittleEndian.PutUint16(b, uint16(hw1))
+		binary.LittleEndian.PutUint16(b[2:], uint16(hw2))
+	}
+
+	value := decode(b) | decode(b[4:])<<16
+	value += uint32(delta)
+	encode(b, value&0xffff)
+	encode(b[4:], value>>16)
+}
+
+// relocMIPSJmpAddr relocates the target of a MIPS J or JAL instruction, which
+// holds the 26-bit word index of the target within its 256 MB region.
+func relocMIPSJmpAddr(b []byte, delta uint64) {
+	inst := binary.LittleEndian.Uint32(b)
+	target := (inst&0x3ffffff)<<2 + uint32(delta)
+	inst = inst&0xfc000000 | target>>2&0x3ffffff
+	binary.LittleEndian.PutUint32(b, inst)
+}
+
+// relocMIPSJmpAddr16 relocates the target of an extended MIPS16 JAL
+// instruction. The first halfword holds the bits 20:16 and 25:21 of the
+// 26-bit word index of the target, the second halfword its low 16 bits.
+func relocMIPSJmpAddr16(b []byte, delta uint64) {
+	hw1 := uint32(binary.LittleEndian.Uint16(b))
+	hw2 := uint32(binary.LittleEndian.Uint16(b[2:]))
+
+	index := (hw1&0x1f)<<21 | (hw1>>5&0x1f)<<16 | hw2
+	index = (index<<2 + uint32(delta)) >> 2 & 0x3ffffff
+
+	hw1 = hw1&^0x3ff | index>>21&0x1f | (index>>16&0x1f)<<5
+	binary.LittleEndian.PutUint16(b, uint16(hw1))
+	binary.LittleEndian.PutUint16(b[2:], uint16(index))
+}
+
+// relocRISCVHigh20 relocates the upper 20 bits immediate of a RISC-V LUI or
+// AUIPC instruction. The image base being aligned on 64 KB, the low 12 bits
+// of the difference are zero and never carry into the high part.
+func relocRISCVHigh20(b []byte, delta uint64) {
+	inst := binary.LittleEndian.Uint32(b)
+	inst = (inst&0xfffff000 + uint32(delta)&0xfffff000) | inst&0xfff
+	binary.LittleEndian.PutUint32(b, inst)
+}
+
+// relocRISCVLow12i relocates the 12 bits immediate of a RISC-V I-type
+// instruction, stored in the bits 31:20.
+func relocRISCVLow12i(b []byte, delta uint64) {
+	inst := binary.LittleEndian.Uint32(b)
+	imm := inst>>20 + uint32(delta)&0xfff
+	inst = inst&0xfffff | (imm&0xfff)<<20
+	binary.LittleEndian.PutUint32(b, inst)
+}
+
+// relocRISCVLow12s relocates the 12 bits immediate of a RISC-V S-type
+// instruction, split between the bits 31:25 and 11:7.
+func relocRISCVLow12s(b []byte, delta uint64) {
+	inst := binary.LittleEndian.Uint32(b)
+	imm := (inst>>25)<<5 | inst>>7&0x1f
+	imm += uint32(delta) & 0xfff
+	inst = inst&0x1fff07f | (imm>>5&0x7f)<<25 | (imm&0x1f)<<7
+	binary.LittleEndian.PutUint32(b, inst)
+}
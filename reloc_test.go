@@ -5,6 +5,11 @@
 package pe
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"reflect"
 	"testing"
 )
 
@@ -174,3 +179,285 @@ func TestParseRelocDirectoryEntry(t *testing.T) {
 		})
 	}
 }
+
+func TestRebase(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		delta uint64
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), 0x10000000},
+		{getAbsoluteFilePath("test/arp.dll"), 0x00230000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			var buf bytes.Buffer
+			err = file.WriteMappedImage(&buf)
+			if err != nil {
+				t.Fatalf("WriteMappedImage(%s) failed, reason: %v", tt.in, err)
+			}
+			original := buf.Bytes()
+			image := append([]byte{}, original...)
+
+			var imageBase uint64
+			switch file.Is64 {
+			case true:
+				imageBase = file.NtHeader.OptionalHeader.(ImageOptionalHeader64).ImageBase
+			case false:
+				imageBase = uint64(file.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase)
+			}
+
+			err = file.Rebase(image, imageBase+tt.delta)
+			if err != nil {
+				t.Fatalf("Rebase(%s) failed, reason: %v", tt.in, err)
+			}
+
+			// The slots following HighAdj relocations are not relocations.
+			for _, reloc := range file.Relocations {
+				forEachReloc(reloc.Entries, func(entry ImageBaseRelocationEntry,
+					param uint16, ok bool) bool {
+					rva := reloc.Data.VirtualAddress + uint32(entry.Offset)
+					switch entry.Type {
+					case ImageRelBasedDir64:
+						got := binary.LittleEndian.Uint64(image[rva:])
+						want := binary.LittleEndian.Uint64(original[rva:]) + tt.delta
+						if got != want {
+							t.Errorf("DIR64 relocation at 0x%x assertion failed, got 0x%x, want 0x%x",
+								rva, got, want)
+						}
+					case ImageRelBasedHighLow:
+						got := binary.LittleEndian.Uint32(image[rva:])
+						want := binary.LittleEndian.Uint32(original[rva:]) + uint32(tt.delta)
+						if got != want {
+							t.Errorf("HighLow relocation at 0x%x assertion failed, got 0x%x, want 0x%x",
+								rva, got, want)
+						}
+					}
+					return true
+				})
+			}
+
+			// Rebasing back to the preferred base address restores the image.
+			err = file.Rebase(image, imageBase)
+			if err != nil {
+				t.Fatalf("Rebase(%s) failed, reason: %v", tt.in, err)
+			}
+			if !bytes.Equal(image, original) {
+				t.Errorf("rebased image of %s does not round trip", tt.in)
+			}
+
+			err = file.applyReloc(image, uint32(len(image)-2), ImageRelBasedHighLow, 0, 1)
+			if !errors.Is(err, ErrRelocOutsideImage) {
+				t.Errorf("relocation outside of the image assertion failed, got %v, want %v",
+					err, ErrRelocOutsideImage)
+			}
+		})
+	}
+}
+
+func TestRelocTypeValid(t *testing.T) {
+
+	tests := []struct {
+		machine ImageFileHeaderMachineType
+		is64    bool
+		typ     ImageBaseRelocationEntryType
+		out     bool
+	}{
+		{ImageFileMachineAMD64, true, ImageRelBasedDir64, true},
+		{ImageFileMachineI386, false, ImageRelBasedDir64, false},
+		{ImageFileMachineAMD64, true, ImageRelBasedARMMov32, false},
+		{ImageFileMachineARM, false, ImageRelBasedARMMov32, true},
+		{ImageFileMachineARMNT, false, ImageRelBasedThumbMov32, true},
+		{ImageFileMachineARM, false, ImageRelBasedThumbMov32, false},
+		{ImageFileMachineR4000, false, ImageRelBasedMIPSJmpAddr16, true},
+		{ImageFileMachineRISCV64, true, ImageRelBasedRISCVLow12s, true},
+		{ImageFileMachineI386, false, ImageRelBasedRISCVLow12s, false},
+		{ImageFileMachineI386, false, ImageRelReserved, false},
+		{ImageFileMachineI386, false, 11, false},
+	}
+
+	for _, tt := range tests {
+		file := File{}
+		file.Is64 = tt.is64
+		file.NtHeader.FileHeader.Machine = tt.machine
+		got := file.relocTypeValid(tt.typ)
+		if got != tt.out {
+			t.Errorf("relocTypeValid(%s, %d) assertion failed, got %v, want %v",
+				tt.machine.String(), tt.typ, got, tt.out)
+		}
+	}
+}
+
+func TestRelocInstructions(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		fn    func(b []byte, delta uint64)
+		in    []uint32
+		delta uint64
+		out   []uint32
+	}{
+		// movw r0, #0x5678; movt r0, #0x1234
+		{"ARM MOV32", relocARMMov32, []uint32{0xe3050678, 0xe3410234}, 0x1a988,
+			[]uint32{0xe3000000, 0xe3410236}},
+		// movw r0, #0x5678; movt r0, #0x1234
+		{"Thumb MOV32", relocThumbMov32, []uint32{0x6078f245, 0x2034f2c1}, 0x1b188,
+			[]uint32{0x0000f640, 0x2036f2c1}},
+		// jal 0x401000
+		{"MIPS JMPADDR", relocMIPSJmpAddr, []uint32{0x0c100400}, 0x10000,
+			[]uint32{0x0c104400}},
+		// jal 0x401000 (MIPS16)
+		{"MIPS JMPADDR16", relocMIPSJmpAddr16, []uint32{0x04001a00}, 0x1000000,
+			[]uint32{0x04001a02}},
+		// lui a0, 0x12345
+		{"RISC-V High20", relocRISCVHigh20, []uint32{0x12345537}, 0x10000,
+			[]uint32{0x12355537}},
+		// addi a0, a0, 0x678
+		{"RISC-V Low12i", relocRISCVLow12i, []uint32{0x67850513}, 0x10,
+			[]uint32{0x68850513}},
+		// sw a1, 0x678(a0)
+		{"RISC-V Low12s", relocRISCVLow12s, []uint32{0x66b52c23}, 0x10,
+			[]uint32{0x68b52423}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, 4*len(tt.in))
+			for i, inst := range tt.in {
+				binary.LittleEndian.PutUint32(b[4*i:], inst)
+			}
+
+			tt.fn(b, tt.delta)
+
+			for i, want := range tt.out {
+				got := binary.LittleEndian.Uint32(b[4*i:])
+				if got != want {
+					t.Errorf("instruction %d assertion failed, got 0x%x, want 0x%x",
+						i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRelocationStats(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	oh64 := file.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+	relocDir := oh64.DataDirectory[ImageDirectoryEntryBaseReloc]
+	lastBlockRVA := relocDir.VirtualAddress
+	for _, reloc := range file.Relocations[:len(file.Relocations)-1] {
+		lastBlockRVA += reloc.Data.SizeOfBlock
+	}
+	lastBlockOffset := file.GetOffsetFromRva(lastBlockRVA)
+	characteristicsOffset := file.DOSHeader.AddressOfNewEXEHeader + 4 + 18
+
+	tests := []struct {
+		name              string
+		patch             func(b []byte)
+		stripped          bool
+		pagesOutsideImage []uint32
+		anomalies         []string
+	}{
+		{
+			name:  "original",
+			patch: func(b []byte) {},
+		},
+		{
+			name: "relocs stripped",
+			patch: func(b []byte) {
+				characteristics := binary.LittleEndian.Uint16(b[characteristicsOffset:])
+				binary.LittleEndian.PutUint16(b[characteristicsOffset:],
+					characteristics|ImageFileRelocsStripped)
+			},
+			stripped:  true,
+			anomalies: []string{AnoRelocsStrippedASLR},
+		},
+		{
+			name: "page outside image",
+			patch: func(b []byte) {
+				binary.LittleEndian.PutUint32(b[lastBlockOffset:], oh64.SizeOfImage)
+			},
+			pagesOutsideImage: []uint32{oh64.SizeOfImage},
+			anomalies:         []string{AnoRelocPageOutsideImage},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := append([]byte{}, data...)
+			tt.patch(b)
+			file, err := NewBytes(b, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+			err = file.GetAnomalies()
+			if err != nil {
+				t.Fatalf("GetAnomalies(%s) failed, reason: %v", filename, err)
+			}
+
+			stats := file.RelocationStats()
+			if stats.Stripped != tt.stripped || !stats.DynamicBase {
+				t.Errorf("flags assertion failed, got stripped=%v dynamic base=%v, want %v true",
+					stats.Stripped, stats.DynamicBase, tt.stripped)
+			}
+			if stats.Blocks != 18 || stats.Entries != 2288 {
+				t.Errorf("counts assertion failed, got %d blocks and %d entries, want 18 and 2288",
+					stats.Blocks, stats.Entries)
+			}
+			wantByType := map[ImageBaseRelocationEntryType]int{
+				ImageRelBasedAbsolute: 6,
+				ImageRelBasedDir64:    2282,
+			}
+			if !reflect.DeepEqual(stats.ByType, wantByType) {
+				t.Errorf("per type count assertion failed, got %v, want %v",
+					stats.ByType, wantByType)
+			}
+			if tt.pagesOutsideImage == nil {
+				wantBySection := map[string]int{".00cfg": 2, ".data": 49, ".rdata": 2231}
+				if !reflect.DeepEqual(stats.BySection, wantBySection) {
+					t.Errorf("per section count assertion failed, got %v, want %v",
+						stats.BySection, wantBySection)
+				}
+			}
+			if !reflect.DeepEqual(stats.PagesOutsideImage, tt.pagesOutsideImage) {
+				t.Errorf("pages outside image assertion failed, got %v, want %v",
+					stats.PagesOutsideImage, tt.pagesOutsideImage)
+			}
+
+			for _, ano := range []string{AnoRelocsStrippedASLR, AnoRelocPageOutsideImage} {
+				if stringInSlice(ano, file.Anomalies) != stringInSlice(ano, tt.anomalies) {
+					t.Errorf("anomaly %q assertion failed, got %v", ano, file.Anomalies)
+				}
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ package pe
 
 import (
 	"encoding/binary"
+	"sort"
+	"strconv"
 )
 
 // ResourceType represents a resource type.
@@ -883,6 +885,26 @@ const (
 
 const (
 	maxAllowedEntries = 0x1000
+
+	// MaxDefaultResourceDepth represents the default maximum depth of the
+	// resource directory tree. Resources are organized in three levels: type,
+	// name and language.
+	MaxDefaultResourceDepth = 16
+)
+
+const (
+	// AnoResourceDirectoryLoop is reported when a resource directory entry
+	// points to a directory which was already parsed.
+	AnoResourceDirectoryLoop = "Resource directory entry points to an already parsed directory"
+
+	// AnoResourceDirectoryTooDeep is reported when the resource directory
+	// tree is deeper than the MaxResourceDepth option.
+	AnoResourceDirectoryTooDeep = "Resource directory tree is too deep"
+
+	// AnoResourceDataOutsideSection is reported when a resource data entry
+	// points outside of the section holding the resource directory, which
+	// is used to smuggle a payload as a resource.
+	AnoResourceDataOutsideSection = "Resource data entry points outside of the resource section"
 )
 
 // Predefined Resource Types.
@@ -1022,8 +1044,13 @@ func (pe *File) parseResourceDataEntry(rva uint32) ImageResourceDataEntry {
 	offset := pe.GetOffsetFromRva(rva)
 	err := pe.structUnpack(&dataEntry, offset, dataEntrySize)
 	if err != nil {
-		pe.logger.Warnf("Error parsing a resource directory data entry, the RVA is invalid")
+		pe.warnf(ImageDirectoryEntryResource.String(), offset,
+			"Error parsing a resource directory data entry, the RVA is invalid")
+		return dataEntry
 	}
+
+	// The resource content is not read, but belongs to the directory.
+	pe.markCoverage(pe.GetOffsetFromRva(dataEntry.OffsetToData), dataEntry.Size)
 	return dataEntry
 }
 
@@ -1056,7 +1083,7 @@ func (pe *File) parseResourceDirectoryEntry(rva uint32) *ImageResourceDirectoryE
 // The subdirectories have subdirectories of their own that may point to the
 // raw resource data for things like dialog templates.
 func (pe *File) doParseResourceDirectory(rva, size, baseRVA, level uint32,
-	dirs []uint32) (ResourceDirectory, error) {
+	visited map[uint32]bool) (ResourceDirectory, error) {
 
 	resourceDir := ImageResourceDirectory{}
 	resourceDirSize := uint32(binary.Size(resourceDir))
@@ -1070,9 +1097,7 @@ func (pe *File) doParseResourceDirectory(rva, size, baseRVA, level uint32,
 		baseRVA = rva
 	}
 
-	if len(dirs) == 0 {
-		dirs = append(dirs, rva)
-	}
+	visited[rva] = true
 
 	// Advance the RVA to the position immediately following the directory
 	// table header and pointing to the first entry in the table.
@@ -1084,15 +1109,17 @@ func (pe *File) doParseResourceDirectory(rva, size, baseRVA, level uint32,
 
 	// Set a hard limit on the maximum reasonable number of entries.
 	if numberOfEntries > maxAllowedEntries {
-		pe.logger.Warnf(`Error parsing the resources directory.
-		 The directory contains %d entries`, numberOfEntries)
+		pe.warnf(ImageDirectoryEntryResource.String(), offset,
+			"Error parsing the resources directory. "+
+				"The directory contains %d entries", numberOfEntries)
 		return ResourceDirectory{}, nil
 	}
 
 	for i := 0; i < numberOfEntries; i++ {
 		res := pe.parseResourceDirectoryEntry(rva)
 		if res == nil {
-			pe.logger.Warn("Error parsing a resource directory entry, the RVA is invalid")
+			pe.warnf(ImageDirectoryEntryResource.String(), 0,
+				"Error parsing a resource directory entry, the RVA is invalid")
 			break
 		}
 
@@ -1128,22 +1155,28 @@ func (pe *File) doParseResourceDirectory(rva, size, baseRVA, level uint32,
 			// trying to parse everything correctly.
 			// If the original RVA given to this function is equal to
 			// the next one to parse, we assume that it's a trick.
-			// Instead of raising a PEFormatError this would skip some
-			// reasonable data so we just break.
+			// Instead of raising a PEFormatError, the entry is kept without
+			// its directory.
 			// 9ee4d0a0caf095314fd7041a3e4404dc is the offending sample.
-			if intInSlice(baseRVA+OffsetToDirectory, dirs) {
-				break
+			// Every directory is parsed once, which also prevents shared
+			// subdirectories from blowing up the size of the tree, and legit
+			// trees are three levels deep, crafted ones are not descended
+			// past MaxResourceDepth.
+			var directoryEntry ResourceDirectory
+			switch {
+			case visited[baseRVA+OffsetToDirectory]:
+				pe.addAnomaly(AnoResourceDirectoryLoop)
+			case level+1 > pe.opts.MaxResourceDepth:
+				pe.addAnomaly(AnoResourceDirectoryTooDeep)
+			default:
+				directoryEntry, _ = pe.doParseResourceDirectory(
+					baseRVA+OffsetToDirectory,
+					size-(rva-baseRVA),
+					baseRVA,
+					level+1,
+					visited)
 			}
 
-			level++
-			dirs = append(dirs, baseRVA+OffsetToDirectory)
-			directoryEntry, _ := pe.doParseResourceDirectory(
-				baseRVA+OffsetToDirectory,
-				size-(rva-baseRVA),
-				baseRVA,
-				level,
-				dirs)
-
 			dirEntries = append(dirEntries, ResourceDirectoryEntry{
 				Struct:        *res,
 				Name:          entryName,
@@ -1154,6 +1187,9 @@ func (pe *File) doParseResourceDirectory(rva, size, baseRVA, level uint32,
 			// data is entry
 			dataEntryStruct := pe.parseResourceDataEntry(baseRVA +
 				OffsetToDirectory)
+			if pe.resourceDataOutsideSection(baseRVA, dataEntryStruct) {
+				pe.addAnomaly(AnoResourceDataOutsideSection)
+			}
 			entryData := ResourceDataEntry{
 				Struct:  dataEntryStruct,
 				Lang:    ResourceLang(res.Name & 0x3ff),
@@ -1180,8 +1216,8 @@ func (pe *File) doParseResourceDirectory(rva, size, baseRVA, level uint32,
 // The resource directory contains resources like dialog templates, icons,
 // and bitmaps. The resources are found in a section called .rsrc section.
 func (pe *File) parseResourceDirectory(rva, size uint32) error {
-	var dirs []uint32
-	Resources, err := pe.doParseResourceDirectory(rva, size, 0, 0, dirs)
+	visited := make(map[uint32]bool)
+	Resources, err := pe.doParseResourceDirectory(rva, size, 0, 0, visited)
 	if err != nil {
 		return err
 	}
@@ -1191,6 +1227,159 @@ func (pe *File) parseResourceDirectory(rva, size uint32) error {
 	return err
 }
 
+// ResourceDataInfo locates the data of a resource data entry.
+type ResourceDataInfo struct {
+	// Path is made of the name, or the ID when not named, of the directory
+	// entries leading to the data, such as `3/1/1033` for an icon.
+	Path string `json:"path"`
+
+	// RVA and Size of the data, as found in the data entry.
+	RVA  uint32 `json:"rva"`
+	Size uint32 `json:"size"`
+
+	// OutsideSection is true when the data is not within the section holding
+	// the resource directory.
+	OutsideSection bool `json:"outside_section"`
+}
+
+// ResourceOverlap represents two resource data entries sharing bytes.
+type ResourceOverlap struct {
+	First  ResourceDataInfo `json:"first"`
+	Second ResourceDataInfo `json:"second"`
+
+	// Duplicated is true when both entries point to the same data.
+	Duplicated bool `json:"duplicated"`
+}
+
+// ResourceAccounting sums up the data pointed to by the resource data
+// entries. The data of legit resources lies within the resource section and
+// is not shared between entries, unlike the payloads which are embedded in
+// crafted resource trees.
+type ResourceAccounting struct {
+	// Entries is the number of resource data entries.
+	Entries int `json:"entries"`
+
+	// TotalSize is the sum of the sizes of the data entries.
+	TotalSize uint64 `json:"total_size"`
+
+	// UniqueSize is the number of bytes pointed to by the data entries, the
+	// bytes shared by several entries being counted once.
+	UniqueSize uint64 `json:"unique_size"`
+
+	// SectionSize is the virtual size of the section holding the resource
+	// directory, zero when the directory is not within a section.
+	SectionSize uint32 `json:"section_size"`
+
+	// OutsideSection lists the entries whose data is not within the section
+	// holding the resource directory.
+	OutsideSection []ResourceDataInfo `json:"outside_section,omitempty"`
+
+	// Overlaps lists the entries sharing bytes with a previous entry, by
+	// increasing RVA. Each entry is paired with the previous entry ending
+	// last.
+	Overlaps []ResourceOverlap `json:"overlaps,omitempty"`
+}
+
+// resourceDataOutsideSection tells whether the data of a resource data entry
+// is not within the section holding the resource directory at dirRVA.
+func (pe *File) resourceDataOutsideSection(dirRVA uint32,
+	dataEntry ImageResourceDataEntry) bool {
+
+	section := pe.getSectionByRva(dirRVA)
+	if section == nil {
+		return false
+	}
+	dataSection := pe.getSectionByRva(dataEntry.OffsetToData)
+	if dataSection == nil ||
+		dataSection.Header.VirtualAddress != section.Header.VirtualAddress {
+		return true
+	}
+
+	end := section.VirtualEnd()
+	if rawEnd := uint64(section.Header.VirtualAddress) +
+		uint64(section.Header.SizeOfRawData); rawEnd > end {
+		end = rawEnd
+	}
+	return uint64(dataEntry.OffsetToData)+uint64(dataEntry.Size) > end
+}
+
+// ResourceAccounting walks the resource tree and sums up the data of its
+// entries, reporting the data located outside of the resource section and
+// the data shared between entries.
+func (pe *File) ResourceAccounting() ResourceAccounting {
+	var accounting ResourceAccounting
+	dirRVA := pe.dataDirectory(ImageDirectoryEntryResource).VirtualAddress
+	if section := pe.getSectionByRva(dirRVA); section != nil {
+		accounting.SectionSize = section.Header.VirtualSize
+	}
+
+	var infos []ResourceDataInfo
+	var walk func(dir ResourceDirectory, path string)
+	walk = func(dir ResourceDirectory, path string) {
+		for _, entry := range dir.Entries {
+			name := entry.Name
+			if name == "" {
+				name = strconv.FormatUint(uint64(entry.ID), 10)
+			}
+			if path != "" {
+				name = path + "/" + name
+			}
+			if entry.IsResourceDir {
+				walk(entry.Directory, name)
+				continue
+			}
+			data := entry.Data.Struct
+			info := ResourceDataInfo{
+				Path:           name,
+				RVA:            data.OffsetToData,
+				Size:           data.Size,
+				OutsideSection: pe.resourceDataOutsideSection(dirRVA, data),
+			}
+			accounting.Entries++
+			accounting.TotalSize += uint64(data.Size)
+			if info.OutsideSection {
+				accounting.OutsideSection = append(accounting.OutsideSection,
+					info)
+			}
+			infos = append(infos, info)
+		}
+	}
+	walk(pe.Resources, "")
+
+	// Sweep the entries by increasing RVA, keeping the one ending last.
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].RVA < infos[j].RVA
+	})
+	var last *ResourceDataInfo
+	var lastEnd uint64
+	for i := range infos {
+		info := &infos[i]
+		if info.Size == 0 {
+			continue
+		}
+		start, end := uint64(info.RVA), uint64(info.RVA)+uint64(info.Size)
+		switch {
+		case last == nil || start >= lastEnd:
+			accounting.UniqueSize += end - start
+		default:
+			accounting.Overlaps = append(accounting.Overlaps, ResourceOverlap{
+				First:  *last,
+				Second: *info,
+				Duplicated: last.RVA == info.RVA &&
+					last.Size == info.Size,
+			})
+			if end > lastEnd {
+				accounting.UniqueSize += end - lastEnd
+			}
+		}
+		if last == nil || end > lastEnd {
+			last, lastEnd = info, end
+		}
+	}
+
+	return accounting
+}
+
 // String stringify the resource type.
 func (rt ResourceType) String() string {
 
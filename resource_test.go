@@ -5,6 +5,8 @@
 package pe
 
 import (
+	"encoding/binary"
+	"io/ioutil"
 	"reflect"
 	"testing"
 )
@@ -261,3 +263,147 @@ func TestPrettyResourceLang(t *testing.T) {
 		})
 	}
 }
+
+func TestResourceDirectoryLoop(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	// The first entry of the root resource directory of putty.exe is made
+	// to point back to the root directory.
+	rsrcOffset := uint32(0xcfa00)
+	binary.LittleEndian.PutUint32(data[rsrcOffset+16+4:], 0x80000000)
+
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	if !stringInSlice(AnoResourceDirectoryLoop, file.Anomalies) {
+		t.Errorf("anomaly %s not found in %v", AnoResourceDirectoryLoop,
+			file.Anomalies)
+	}
+	if len(file.Resources.Entries) == 0 {
+		t.Fatalf("resource directory assertion failed, got no entries")
+	}
+	if got := file.Resources.Entries[0].Directory.Entries; got != nil {
+		t.Errorf("looping resource entry assertion failed, got %d entries, want none",
+			len(got))
+	}
+}
+
+func TestResourceDirectoryMaxDepth(t *testing.T) {
+
+	tests := []struct {
+		depth   uint32
+		anomaly bool
+	}{
+		{0, false},
+		{1, true},
+		{2, false},
+		{3, false},
+	}
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	for _, tt := range tests {
+		file, err := New(filename, &Options{MaxResourceDepth: tt.depth})
+		if err != nil {
+			t.Fatalf("New(%s) failed, reason: %v", filename, err)
+		}
+		err = file.Parse()
+		if err != nil {
+			t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+		}
+
+		got := stringInSlice(AnoResourceDirectoryTooDeep, file.Anomalies)
+		if got != tt.anomaly {
+			t.Errorf("MaxResourceDepth %d anomaly assertion failed, got %v, want %v",
+				tt.depth, got, tt.anomaly)
+		}
+
+		if tt.depth != 1 {
+			continue
+		}
+		for _, entry := range file.Resources.Entries {
+			for _, subEntry := range entry.Directory.Entries {
+				if subEntry.Directory.Entries != nil {
+					t.Errorf("MaxResourceDepth 1 assertion failed, got %d entries at level 2",
+						len(subEntry.Directory.Entries))
+				}
+			}
+		}
+	}
+}
+
+func TestResourceAccounting(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	got := file.ResourceAccounting()
+	want := ResourceAccounting{Entries: 21, TotalSize: 306034,
+		UniqueSize: 306034, SectionSize: 307248}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resource accounting assertion failed, got %+v, want %+v",
+			got, want)
+	}
+
+	// Collect the file offsets of the data entries of the first three types.
+	rsrcRVA := file.dataDirectory(ImageDirectoryEntryResource).VirtualAddress
+	var dataEntries []uint32
+	for _, typeEntry := range file.Resources.Entries[:3] {
+		nameEntry := typeEntry.Directory.Entries[0]
+		langEntry := nameEntry.Directory.Entries[0]
+		dataEntries = append(dataEntries, file.GetOffsetFromRva(
+			rsrcRVA+langEntry.Struct.OffsetToData))
+	}
+
+	// The second entry points to the data of the first one, the third one
+	// is moved to the entry point.
+	copy(data[dataEntries[1]:dataEntries[1]+8], data[dataEntries[0]:])
+	binary.LittleEndian.PutUint32(data[dataEntries[2]:],
+		file.NtHeader.OptionalHeader.(ImageOptionalHeader64).AddressOfEntryPoint)
+
+	file, err = NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	if !stringInSlice(AnoResourceDataOutsideSection, file.Anomalies) {
+		t.Errorf("anomaly %s not found in %v", AnoResourceDataOutsideSection,
+			file.Anomalies)
+	}
+	got = file.ResourceAccounting()
+	if got.Entries != 21 || len(got.OutsideSection) != 1 ||
+		got.OutsideSection[0].Path != "14/200/1033" {
+		t.Errorf("resource outside section assertion failed, got %+v",
+			got.OutsideSection)
+	}
+	if len(got.Overlaps) != 1 || !got.Overlaps[0].Duplicated ||
+		got.UniqueSize >= got.TotalSize {
+		t.Errorf("resource overlaps assertion failed, got %+v, unique size %d, total size %d",
+			got.Overlaps, got.UniqueSize, got.TotalSize)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2018 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+var (
+	// ErrInvalidStringTable is returned when a string table resource block
+	// is shorter than the lengths of its strings.
+	ErrInvalidStringTable = errors.New("invalid string table resource")
+)
+
+// CodePageDecoder decodes the strings formatted according to a Windows code
+// page, such as Windows-1252 or Shift JIS, found in the resources. It is set
+// with Options.CodePageDecoder to support other code pages or to avoid the
+// golang.org/x/text tables.
+type CodePageDecoder interface {
+	// Decode returns the UTF-8 form of b, ok is false when the code page is
+	// not supported.
+	Decode(b []byte, codePage uint32) (s string, ok bool)
+}
+
+// textCodePageDecoder is the default CodePageDecoder, it supports the code
+// pages of golang.org/x/text.
+type textCodePageDecoder struct{}
+
+func (textCodePageDecoder) Decode(b []byte, codePage uint32) (string, bool) {
+	if codePage > 0xffff {
+		return "", false
+	}
+	enc := codePageEncoding(uint16(codePage))
+	if enc == nil {
+		return "", false
+	}
+	s, err := enc.NewDecoder().Bytes(b)
+	if err != nil {
+		return "", false
+	}
+	return string(s), true
+}
+
+// decodeCodePage decodes b with Options.CodePageDecoder, or the default
+// decoder when it is not set.
+func (pe *File) decodeCodePage(b []byte, codePage uint32) (string, bool) {
+	if pe.opts.CodePageDecoder != nil {
+		return pe.opts.CodePageDecoder.Decode(b, codePage)
+	}
+	return textCodePageDecoder{}.Decode(b, codePage)
+}
+
+// decodeCodePageString decodes a null terminated string formatted according
+// to the given code page with Options.CodePageDecoder, see
+// decodeCodePageString.
+func (pe *File) decodeCodePageString(b []byte, codePage uint16) (string, error) {
+	if pe.opts.CodePageDecoder == nil {
+		return decodeCodePageString(b, codePage)
+	}
+	if n := bytes.IndexByte(b, 0); n >= 0 {
+		b = b[:n]
+	}
+	if s, ok := pe.opts.CodePageDecoder.Decode(b, uint32(codePage)); ok {
+		return s, nil
+	}
+	s, err := charmap.Windows1252.NewDecoder().Bytes(b)
+	return string(s), err
+}
+
+// looksLikeUTF16 tells whether b is likely made of little endian UTF-16 code
+// units of mostly ASCII text, that is with null high bytes.
+func looksLikeUTF16(b []byte) bool {
+	if len(b) < 2 || len(b)%2 != 0 {
+		return false
+	}
+	zeros := 0
+	for i := 1; i < len(b); i += 2 {
+		if b[i] == 0 {
+			zeros++
+		}
+	}
+	return zeros*4 >= len(b)
+}
+
+// decodeUTF16 decodes UTF-16 code units of the given byte order, up to the
+// first null code unit.
+func decodeUTF16(b []byte, order binary.ByteOrder) string {
+	chars := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := order.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
+	}
+	return string(utf16.Decode(chars))
+}
+
+// decodeResourceText decodes the content of a text resource, such as a
+// manifest or an HTML page. A byte order mark wins over the code page of the
+// data entry, which resource compilers often leave to the one of the
+// resource script. The text is then decoded as UTF-16 when it looks like
+// so, as UTF-8 when valid and according to the code page otherwise.
+func (pe *File) decodeResourceText(b []byte, codePage uint32) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xef, 0xbb, 0xbf}):
+		return string(bytes.TrimRight(b[3:], "\x00"))
+	case bytes.HasPrefix(b, []byte{0xff, 0xfe}):
+		return decodeUTF16(b[2:], binary.LittleEndian)
+	case bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+		return decodeUTF16(b[2:], binary.BigEndian)
+	case codePage == CodePageUnicode || looksLikeUTF16(b):
+		return decodeUTF16(b, binary.LittleEndian)
+	case codePage == 1201:
+		return decodeUTF16(b, binary.BigEndian)
+	}
+
+	b = bytes.TrimRight(b, "\x00")
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	if s, ok := pe.decodeCodePage(b, codePage); ok {
+		return s
+	}
+	s, _ := charmap.Windows1252.NewDecoder().Bytes(b)
+	return string(s)
+}
+
+// ResourceText returns the content of a text resource, such as RT_MANIFEST
+// or RT_HTML, decoded according to its byte order mark or to the code page
+// of its data entry.
+func (pe *File) ResourceText(entry ResourceDataEntry) (string, error) {
+	b, err := pe.GetData(entry.Struct.OffsetToData, entry.Struct.Size)
+	if err != nil {
+		return "", err
+	}
+	return pe.decodeResourceText(b, entry.Struct.CodePage), nil
+}
+
+// ResourceString represents a string of a string table resource
+// (RT_STRING), as loaded by LoadString.
+type ResourceString struct {
+	// The string identifier, strings are grouped by blocks of 16.
+	ID uint32 `json:"id"`
+
+	// The language of the block holding the string.
+	Lang    ResourceLang    `json:"lang"`
+	SubLang ResourceSubLang `json:"sub_lang"`
+
+	Value string `json:"value"`
+}
+
+// ResourceStrings returns the non empty strings of the string table
+// resources, in the order of the resource directory. A block is made of 16
+// strings prefixed by their length in UTF-16 code units. Blocks of a legacy
+// code page are decoded according to the code page of their data entry,
+// recognized when the strings do not fit as UTF-16.
+func (pe *File) ResourceStrings() ([]ResourceString, error) {
+	var strs []ResourceString
+	for _, typeEntry := range pe.Resources.Entries {
+		if typeEntry.ID != RTString {
+			continue
+		}
+		for _, nameEntry := range typeEntry.Directory.Entries {
+			if nameEntry.ID == 0 || nameEntry.Name != "" {
+				continue
+			}
+			for _, langEntry := range nameEntry.Directory.Entries {
+				data := langEntry.Data
+				b, err := pe.GetData(data.Struct.OffsetToData, data.Struct.Size)
+				if err != nil {
+					return strs, err
+				}
+				unicode := true
+				values, ok := splitStringBlock(b, 2)
+				if !ok && data.Struct.CodePage != CodePageUnicode {
+					unicode = false
+					values, ok = splitStringBlock(b, 1)
+				}
+				if !ok {
+					return strs, ErrInvalidStringTable
+				}
+
+				for i, value := range values {
+					if len(value) == 0 {
+						continue
+					}
+					var s string
+					if unicode {
+						s = decodeUTF16(value, binary.LittleEndian)
+					} else {
+						s = pe.decodeResourceText(value, data.Struct.CodePage)
+					}
+					strs = append(strs, ResourceString{
+						ID:      (nameEntry.ID-1)*16 + uint32(i),
+						Lang:    data.Lang,
+						SubLang: data.SubLang,
+						Value:   s,
+					})
+				}
+			}
+		}
+	}
+	return strs, nil
+}
+
+// splitStringBlock splits a string table block into its 16 strings, whose
+// length is counted in units of unitSize bytes. It returns false when the
+// block is too short for the lengths it holds.
+func splitStringBlock(b []byte, unitSize int) ([][]byte, bool) {
+	values := make([][]byte, 0, 16)
+	for i := 0; i < 16; i++ {
+		var length int
+		switch unitSize {
+		case 2:
+			if len(b) < 2 {
+				return nil, false
+			}
+			length = int(binary.LittleEndian.Uint16(b)) * 2
+			b = b[2:]
+		default:
+			if len(b) < 1 {
+				return nil, false
+			}
+			length = int(b[0])
+			b = b[1:]
+		}
+		if length > len(b) {
+			return nil, false
+		}
+		values = append(values, b[:length])
+		b = b[length:]
+	}
+	return values, true
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"strings"
+	"testing"
+)
+
+// upperDecoder is a CodePageDecoder supporting a single made up code page.
+type upperDecoder struct{}
+
+func (upperDecoder) Decode(b []byte, codePage uint32) (string, bool) {
+	if codePage != 42 {
+		return "", false
+	}
+	return strings.ToUpper(string(b)), true
+}
+
+func TestDecodeResourceText(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       []byte
+		codePage uint32
+		decoder  CodePageDecoder
+		out      string
+	}{
+		{"utf8 bom", []byte{0xef, 0xbb, 0xbf, 'h', 0xc3, 0xa9, 0}, 1252, nil, "hé"},
+		{"utf16le bom", []byte{0xff, 0xfe, 'h', 0, 0xe9, 0, 0, 0}, 1252, nil, "hé"},
+		{"utf16be bom", []byte{0xfe, 0xff, 0, 'h', 0, 0xe9}, 1252, nil, "hé"},
+		{"utf16le", []byte{'h', 0, 'i', 0, 0x3d, 0xd8, 0x00, 0xde}, 0, nil, "hi😀"},
+		{"utf8", []byte{'h', 0xc3, 0xa9, 0, 0}, 1251, nil, "hé"},
+		{"code page", []byte{0xc4, 0xf0, 0xe0, 0xe9}, 1251, nil, "Драй"},
+		{"fallback", []byte{'h', 0xe9}, 0, nil, "hé"},
+		{"decoder", []byte{'h', 0xe9}, 42, upperDecoder{}, "H�"},
+		{"decoder fallback", []byte{'h', 0xe9}, 1251, upperDecoder{}, "hé"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pe := File{opts: &Options{CodePageDecoder: tt.decoder}}
+			got := pe.decodeResourceText(tt.in, tt.codePage)
+			if got != tt.out {
+				t.Errorf("decodeResourceText(%v, %d) got %q, want %q",
+					tt.in, tt.codePage, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestResourceStrings(t *testing.T) {
+	tests := []struct {
+		in    string
+		count int
+		first ResourceString
+		last  ResourceString
+	}{
+		{
+			in:    getAbsoluteFilePath("test/mfc40u.dll"),
+			count: 338,
+			first: ResourceString{ID: 57347, Lang: LangEnglish,
+				SubLang: 0x1, Value: "%1 in %2"},
+			last: ResourceString{ID: 65223, Lang: LangEnglish,
+				SubLang: 0x1, Value: "Replacement text too long"},
+		},
+		{
+			in: getAbsoluteFilePath("test/putty.exe"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			if err = file.Parse(); err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			got, err := file.ResourceStrings()
+			if err != nil {
+				t.Fatalf("ResourceStrings(%s) failed, reason: %v", tt.in, err)
+			}
+			if len(got) != tt.count {
+				t.Fatalf("ResourceStrings(%s) got %d strings, want %d",
+					tt.in, len(got), tt.count)
+			}
+			if tt.count == 0 {
+				return
+			}
+			if got[0] != tt.first {
+				t.Errorf("first string got %+v, want %+v", got[0], tt.first)
+			}
+			if got[len(got)-1] != tt.last {
+				t.Errorf("last string got %+v, want %+v", got[len(got)-1], tt.last)
+			}
+		})
+	}
+}
+
+func TestSplitStringBlock(t *testing.T) {
+	block := make([]byte, 0, 40)
+	block = append(block, 2, 0, 'h', 0, 'i', 0)
+	for i := 1; i < 16; i++ {
+		block = append(block, 0, 0)
+	}
+
+	values, ok := splitStringBlock(block, 2)
+	if !ok || len(values) != 16 || string(values[0]) != "h\x00i\x00" {
+		t.Errorf("splitStringBlock(%v) got %q, %v", block, values, ok)
+	}
+
+	if _, ok := splitStringBlock(block[:10], 2); ok {
+		t.Errorf("splitStringBlock of a truncated block succeeded")
+	}
+}
+
+func TestResourceText(t *testing.T) {
+	file, err := New(getAbsoluteFilePath("test/putty.exe"), &Options{})
+	if err != nil {
+		t.Fatalf("New failed, reason: %v", err)
+	}
+	if err = file.Parse(); err != nil {
+		t.Fatalf("Parse failed, reason: %v", err)
+	}
+
+	for _, typeEntry := range file.Resources.Entries {
+		if typeEntry.ID != RTManifest {
+			continue
+		}
+		entry := typeEntry.Directory.Entries[0].Directory.Entries[0]
+		text, err := file.ResourceText(entry.Data)
+		if err != nil {
+			t.Fatalf("ResourceText failed, reason: %v", err)
+		}
+		want := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`
+		if !strings.HasPrefix(text, want) {
+			t.Errorf("ResourceText got %q, want prefix %q", text[:len(want)], want)
+		}
+		return
+	}
+	t.Errorf("no manifest found")
+}
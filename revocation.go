@@ -0,0 +1,388 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// RevocationStatus represents the revocation status of a certificate chain.
+type RevocationStatus int
+
+const (
+	// RevocationNotChecked means the revocation status was not checked.
+	RevocationNotChecked RevocationStatus = iota
+
+	// RevocationGood means none of the certificates of the chain are revoked.
+	RevocationGood
+
+	// RevocationRevoked means one of the certificates of the chain is revoked.
+	RevocationRevoked
+
+	// RevocationUnknown means the revocation status of at least one
+	// certificate of the chain could not be determined, for instance because
+	// the OCSP responder and the CRL distribution points were unreachable.
+	RevocationUnknown
+)
+
+// maxRevocationResponseSize bounds the size of the OCSP responses and CRLs
+// downloaded during revocation checks.
+const maxRevocationResponseSize = 16 << 20
+
+var (
+	oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+
+	// errRevocationUnknown is returned when a revocation source can't tell
+	// whether a certificate is revoked.
+	errRevocationUnknown = errors.New("revocation status unknown")
+)
+
+// ocspSignatureAlgorithms maps the signature algorithms OCSP responders
+// commonly use to their x509 equivalent.
+var ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+	"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+	"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+	"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+	"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+	"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+}
+
+// The OCSP request and response structures, as per RFC 6960.
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	RequestList []ocspSingleRequest
+}
+
+type ocspSingleRequest struct {
+	Cert ocspCertID
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+	Extensions     []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// String returns the string representation of a revocation status.
+func (s RevocationStatus) String() string {
+	revocationStatusMap := map[RevocationStatus]string{
+		RevocationNotChecked: "Not Checked",
+		RevocationGood:       "Good",
+		RevocationRevoked:    "Revoked",
+		RevocationUnknown:    "Unknown",
+	}
+
+	if value, ok := revocationStatusMap[s]; ok {
+		return value
+	}
+	return "?"
+}
+
+// checkRevocation checks whether any certificate of the chain, which starts
+// with the end-entity certificate and ends with the root, was revoked before
+// the given time. Each certificate is looked up via OCSP first, then via the
+// CRL distribution points when no OCSP responder gives an answer. Roots are
+// not checked as they can only be distrusted.
+func (pe *File) checkRevocation(chain []*x509.Certificate, at time.Time) RevocationStatus {
+	client := pe.opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	status := RevocationGood
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+
+		revokedAt, err := checkOCSP(client, cert, issuer)
+		if err != nil {
+			pe.logger.Debugf("OCSP check of %s failed: %v", cert.Subject, err)
+			revokedAt, err = checkCRL(client, cert, issuer)
+		}
+		if err != nil {
+			pe.logger.Debugf("CRL check of %s failed: %v", cert.Subject, err)
+			status = RevocationUnknown
+			continue
+		}
+		if !revokedAt.IsZero() && revokedAt.Before(at) {
+			return RevocationRevoked
+		}
+	}
+	return status
+}
+
+// checkOCSP queries the OCSP responders of a certificate and returns its
+// revocation time, which is zero when the certificate is not revoked.
+func checkOCSP(client *http.Client, cert, issuer *x509.Certificate) (time.Time, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return time.Time{}, err
+	}
+	nameHash := crypto.SHA1.New()
+	nameHash.Write(issuer.RawSubject)
+	keyHash := crypto.SHA1.New()
+	keyHash.Write(spki.PublicKey.RightAlign())
+	certID := ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidSHA1,
+			Parameters: asn1.NullRawValue,
+		},
+		NameHash:      nameHash.Sum(nil),
+		IssuerKeyHash: keyHash.Sum(nil),
+		SerialNumber:  cert.SerialNumber,
+	}
+
+	req, err := asn1.Marshal(ocspRequest{
+		TBSRequest: ocspTBSRequest{
+			RequestList: []ocspSingleRequest{{Cert: certID}},
+		},
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	err = errRevocationUnknown
+	for _, server := range cert.OCSPServer {
+		var data []byte
+		data, err = httpDownload(client, http.MethodPost, server, req)
+		if err != nil {
+			continue
+		}
+		var revokedAt time.Time
+		revokedAt, err = parseOCSPResponse(data, certID, issuer)
+		if err == nil {
+			return revokedAt, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// parseOCSPResponse verifies the signature of an OCSP response, which is
+// made either by the issuer or by a responder it delegated to, and returns
+// the revocation time of the certificate.
+func parseOCSPResponse(data []byte, certID ocspCertID, issuer *x509.Certificate) (time.Time, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(data, &resp); err != nil {
+		return time.Time{}, err
+	}
+	if resp.Status != 0 || !resp.Response.ResponseType.Equal(oidOCSPBasicResponse) {
+		return time.Time{}, errRevocationUnknown
+	}
+
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return time.Time{}, err
+	}
+	var tbs ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &tbs); err != nil {
+		return time.Time{}, err
+	}
+
+	algo, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return time.Time{}, x509.ErrUnsupportedAlgorithm
+	}
+	signed := basic.TBSResponseData.FullBytes
+	signature := basic.Signature.RightAlign()
+	err := issuer.CheckSignature(algo, signed, signature)
+	for _, raw := range basic.Certificates {
+		if err == nil {
+			break
+		}
+		responder, parseErr := x509.ParseCertificate(raw.FullBytes)
+		if parseErr != nil || responder.CheckSignatureFrom(issuer) != nil ||
+			!hasExtKeyUsage(responder, x509.ExtKeyUsageOCSPSigning) {
+			continue
+		}
+		err = responder.CheckSignature(algo, signed, signature)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, r := range tbs.Responses {
+		if r.CertID.SerialNumber == nil ||
+			r.CertID.SerialNumber.Cmp(certID.SerialNumber) != 0 ||
+			!bytes.Equal(r.CertID.NameHash, certID.NameHash) ||
+			!bytes.Equal(r.CertID.IssuerKeyHash, certID.IssuerKeyHash) {
+			continue
+		}
+		switch {
+		case bool(r.Good):
+			return time.Time{}, nil
+		case !r.Revoked.RevocationTime.IsZero():
+			return r.Revoked.RevocationTime, nil
+		}
+	}
+	return time.Time{}, errRevocationUnknown
+}
+
+// checkCRL downloads the CRLs of a certificate and returns its revocation
+// time, which is zero when the certificate is not revoked.
+func checkCRL(client *http.Client, cert, issuer *x509.Certificate) (time.Time, error) {
+	err := errRevocationUnknown
+	for _, url := range cert.CRLDistributionPoints {
+		var data []byte
+		data, err = httpDownload(client, http.MethodGet, url, nil)
+		if err != nil {
+			continue
+		}
+		crl, parseErr := x509.ParseCRL(data)
+		if parseErr != nil {
+			err = parseErr
+			continue
+		}
+		if err = issuer.CheckCRLSignature(crl); err != nil {
+			continue
+		}
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return revoked.RevocationTime, nil
+			}
+		}
+		return time.Time{}, nil
+	}
+	return time.Time{}, err
+}
+
+// httpDownload sends an HTTP request and returns the body of the response.
+func httpDownload(client *http.Client, method, url string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/ocsp-request")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxRevocationResponseSize))
+}
+
+// hasExtKeyUsage returns true when the certificate allows the given extended
+// key usage.
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+// buildCertChain returns the chain of trust of the signer certificate. When
+// the trust store is nil, the chain is built from the certificates embedded
+// in the signature without checking it ends with a trusted root.
+func buildCertChain(signer *x509.Certificate, certs []*x509.Certificate,
+	roots *x509.CertPool, at time.Time) ([]*x509.Certificate, error) {
+
+	if roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs {
+			intermediates.AddCert(cert)
+		}
+		chains, err := signer.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			CurrentTime:   at,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return chains[0], nil
+	}
+
+	chain := []*x509.Certificate{signer}
+	for len(chain) <= len(certs) {
+		last := chain[len(chain)-1]
+		var issuer *x509.Certificate
+		for _, cert := range certs {
+			if !cert.Equal(last) && last.CheckSignatureFrom(cert) == nil {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+	}
+	return chain, nil
+}
@@ -9,6 +9,7 @@ import (
 	"crypto/md5"
 	"encoding/binary"
 	"fmt"
+	"math/bits"
 )
 
 const (
@@ -26,6 +27,10 @@ const (
 	// padding DWORDs are not equal to 0.
 	AnoPaddingDwordNotZero = "Rich header found: 3 leading padding DWORDs " +
 		"not found after DanS signature"
+
+	// AnoInvalidRichHeaderChecksum is reported when the rich header XOR key
+	// does not match its checksum.
+	AnoInvalidRichHeaderChecksum = "Invalid rich header checksum"
 )
 
 // CompID represents the `@comp.id` structure.
@@ -70,6 +75,12 @@ func (pe *File) ParseRichHeader() error {
 		return nil
 	}
 
+	// The NT headers may overlap the DOS stub, make sure the XOR key
+	// following the signature is within the file.
+	if pe.checkRange(uint32(richSigOffset), 8) != nil {
+		return nil
+	}
+
 	// The DWORD following the "Rich" sequence is the XOR key stored by and
 	// calculated by the linker. It is actually a checksum of the DOS header with
 	// the e_lfanew zeroed out, and additionally includes the values of the
@@ -100,17 +111,18 @@ func (pe *File) ParseRichHeader() error {
 
 	// Probe we successfuly found the `DanS` magic.
 	if dansSigOffset == -1 {
-		pe.Anomalies = append(pe.Anomalies, AnoDansSigNotFound)
+		pe.appendAnomaly(AnoDansSigNotFound)
 		return nil
 	}
 
 	// Anomaly check: dansSigOffset is usually found in offset 0x80.
 	if dansSigOffset != 0x80 {
-		pe.Anomalies = append(pe.Anomalies, AnoDanSMagicOffset)
+		pe.appendAnomaly(AnoDanSMagicOffset)
 	}
 
 	rh.DansOffset = dansSigOffset
 	rh.Raw = pe.data[dansSigOffset : richSigOffset+8]
+	pe.markCoverage(uint32(dansSigOffset), uint32(len(rh.Raw)))
 
 	// Reverse the decrypted rich header
 	for i, j := 0, len(decRichHeader)-1; i < j; i, j = i+1, j-1 {
@@ -122,7 +134,7 @@ func (pe *File) ParseRichHeader() error {
 	// (paragraph) boundary, so the 3 leading padding DWORDs can be safely
 	// skipped as not belonging to the data.
 	if decRichHeader[0] != 0 || decRichHeader[1] != 0 || decRichHeader[2] != 0 {
-		pe.Anomalies = append(pe.Anomalies, AnoPaddingDwordNotZero)
+		pe.appendAnomaly(AnoPaddingDwordNotZero)
 	}
 
 	// The array stores entries that are 8-bytes each, broken into 3 members.
@@ -156,7 +168,7 @@ func (pe *File) ParseRichHeader() error {
 
 	checksum := pe.RichHeaderChecksum()
 	if checksum != rh.XORKey {
-		pe.Anomalies = append(pe.Anomalies, "Invalid rich header checksum")
+		pe.appendAnomaly(AnoInvalidRichHeaderChecksum)
 	}
 
 	return nil
@@ -164,25 +176,35 @@ func (pe *File) ParseRichHeader() error {
 
 // RichHeaderChecksum calculate the Rich Header checksum.
 func (pe *File) RichHeaderChecksum() uint32 {
+	return ComputeRichHeaderChecksum(pe.data, pe.RichHeader.DansOffset,
+		pe.RichHeader.CompIDs)
+}
 
-	checksum := uint32(pe.RichHeader.DansOffset)
+// ComputeRichHeaderChecksum calculates the checksum the linker uses as the XOR
+// key of a rich header starting at dansOffset in data, which holds at least
+// the DOS header and stub. It lets tools which rewrite the @comp.id entries
+// produce a rich header with a valid key.
+func ComputeRichHeaderChecksum(data []byte, dansOffset int,
+	compIDs []CompID) uint32 {
+
+	checksum := uint32(dansOffset)
 
 	// First, calculate the sum of the DOS header bytes each rotated left the
 	// number of times their position relative to the start of the DOS header e.g.
 	// second byte is rotated left 2x using rol operation.
-	for i := 0; i < pe.RichHeader.DansOffset; i++ {
+	for i := 0; i < dansOffset && i < len(data); i++ {
 		// skip over dos e_lfanew field at offset 0x3C
 		if i >= 0x3C && i < 0x40 {
 			continue
 		}
-		b := uint32(pe.data[i])
+		b := uint32(data[i])
 		checksum += ((b << (i % 32)) | (b>>(32-(i%32)))&0xff)
 		checksum &= 0xFFFFFFFF
 	}
 
 	// Next, take summation of each Rich header entry by combining its ProductId
 	// and BuildNumber into a single 32 bit number and rotating by its count.
-	for _, compid := range pe.RichHeader.CompIDs {
+	for _, compid := range compIDs {
 		checksum += (compid.Unmasked<<(compid.Count%32) |
 			compid.Unmasked>>(32-(compid.Count%32)))
 		checksum &= 0xFFFFFFFF
@@ -191,6 +213,68 @@ func (pe *File) RichHeaderChecksum() uint32 {
 	return checksum
 }
 
+// DecodeRichHeader decodes a raw rich header, from the masked `DanS`
+// signature up to the XOR key which follows the `Rich` signature, such as
+// the Raw field of a parsed RichHeader. Unlike ParseRichHeader, it does not
+// need the rest of the file, DansOffset is left to zero.
+func DecodeRichHeader(raw []byte) (RichHeader, error) {
+
+	rh := RichHeader{}
+	richSigOffset := bytes.Index(raw, []byte(RichSignature))
+	if richSigOffset < 0 || richSigOffset%4 != 0 ||
+		richSigOffset+8 > len(raw) {
+		return rh, ErrRichSignatureNotFound
+	}
+	rh.XORKey = binary.LittleEndian.Uint32(raw[richSigOffset+4:])
+
+	// The DanS signature is followed by 3 padding DWORDs.
+	if richSigOffset < 16 ||
+		binary.LittleEndian.Uint32(raw)^rh.XORKey != DansSignature {
+		return rh, ErrDansSignatureNotFound
+	}
+
+	// A trailing DWORD which does not make a full @comp.id is ignored, as
+	// ParseRichHeader does.
+	for i := 16; i+8 <= richSigOffset; i += 8 {
+		unmasked := binary.LittleEndian.Uint32(raw[i:]) ^ rh.XORKey
+		rh.CompIDs = append(rh.CompIDs, CompID{
+			MinorCV:  uint16(unmasked),
+			ProdID:   uint16(unmasked >> 16),
+			Count:    binary.LittleEndian.Uint32(raw[i+4:]) ^ rh.XORKey,
+			Unmasked: unmasked,
+		})
+	}
+
+	rh.Raw = raw[:richSigOffset+8]
+	return rh, nil
+}
+
+// EncodeRichHeader masks @comp.id entries with the given XOR key and returns
+// the raw rich header the linker would write: the `DanS` signature, the 3
+// padding DWORDs, the entries, the `Rich` signature and the key. The entries
+// are encoded from their ProdID and MinorCV fields, their Unmasked field is
+// ignored.
+func EncodeRichHeader(compIDs []CompID, key uint32) []byte {
+	raw := make([]byte, 16+8*len(compIDs)+8)
+
+	binary.LittleEndian.PutUint32(raw, DansSignature^key)
+	for i := 4; i < 16; i += 4 {
+		binary.LittleEndian.PutUint32(raw[i:], key)
+	}
+
+	offset := 16
+	for _, compid := range compIDs {
+		unmasked := uint32(compid.ProdID)<<16 | uint32(compid.MinorCV)
+		binary.LittleEndian.PutUint32(raw[offset:], unmasked^key)
+		binary.LittleEndian.PutUint32(raw[offset+4:], compid.Count^key)
+		offset += 8
+	}
+
+	copy(raw[offset:], RichSignature)
+	binary.LittleEndian.PutUint32(raw[offset+4:], key)
+	return raw
+}
+
 // RichHeaderHash calculate the Rich Header hash.
 func (pe *File) RichHeaderHash() string {
 	if !pe.HasRichHdr {
@@ -213,6 +297,59 @@ func (pe *File) RichHeaderHash() string {
 	return fmt.Sprintf("%x", md5.Sum(clearData))
 }
 
+// RichPEHash calculates the RichPE hash, which combines the Rich header
+// @comp.id entries with their counts rounded up, and a few fields of the PE
+// headers set by the build environment. Files built from slightly different
+// code by the same toolchain and project settings share the same hash.
+func (pe *File) RichPEHash() string {
+	if !pe.HasRichHdr || !pe.HasNTHdr {
+		return ""
+	}
+
+	var linker [2]uint8
+	var versions [7]uint16
+	switch pe.Is64 {
+	case true:
+		oh64 := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+		linker = [2]uint8{oh64.MajorLinkerVersion, oh64.MinorLinkerVersion}
+		versions = [7]uint16{uint16(oh64.Subsystem),
+			oh64.MajorOperatingSystemVersion, oh64.MinorOperatingSystemVersion,
+			oh64.MajorImageVersion, oh64.MinorImageVersion,
+			oh64.MajorSubsystemVersion, oh64.MinorSubsystemVersion}
+	case false:
+		oh32 := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32)
+		linker = [2]uint8{oh32.MajorLinkerVersion, oh32.MinorLinkerVersion}
+		versions = [7]uint16{uint16(oh32.Subsystem),
+			oh32.MajorOperatingSystemVersion, oh32.MinorOperatingSystemVersion,
+			oh32.MajorImageVersion, oh32.MinorImageVersion,
+			oh32.MajorSubsystemVersion, oh32.MinorSubsystemVersion}
+	}
+
+	h := md5.New()
+	buf := make([]byte, 4)
+	write32 := func(v uint32) {
+		binary.LittleEndian.PutUint32(buf, v)
+		h.Write(buf)
+	}
+
+	// The counts are masked so that small variations do not change the hash.
+	for _, compid := range pe.RichHeader.CompIDs {
+		count := compid.Count
+		count |= 1<<(uint(bits.Len32(count))/2+1) - 1
+		write32(compid.Unmasked)
+		write32(count)
+	}
+
+	write32(uint32(pe.NtHeader.FileHeader.Machine))
+	write32(uint32(pe.NtHeader.FileHeader.Characteristics))
+	write32(uint32(versions[0]))
+	h.Write(linker[:])
+	for _, v := range versions[1:] {
+		write32(uint32(v))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 // ProdIDtoStr maps product ids to MS internal names.
 // list from: https://github.com/kirschju/richheader
 func ProdIDtoStr(prodID uint16) string {
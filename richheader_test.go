@@ -5,6 +5,8 @@
 package pe
 
 import (
+	"bytes"
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -173,3 +175,105 @@ func TestRichHeaderHash(t *testing.T) {
 		})
 	}
 }
+
+func TestRichPEHash(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{getAbsoluteFilePath("test/kernel32.dll"),
+			"e7b0b13bc71e0997a872453068c45199"},
+		{getAbsoluteFilePath("test/WdBoot.sys"),
+			"523662d688c6a6b634eb7d33db1022b0"},
+		{getAbsoluteFilePath("test/putty.exe"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			got := file.RichPEHash()
+			if got != tt.out {
+				t.Errorf("RichPEHash(%s) got %v, want %v", tt.in, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestDecodeEncodeRichHeader(t *testing.T) {
+
+	tests := []string{
+		getAbsoluteFilePath("test/kernel32.dll"),
+		getAbsoluteFilePath("test/WdBoot.sys"),
+	}
+
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			file, err := New(tt, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt, err)
+			}
+
+			rh, err := DecodeRichHeader(file.RichHeader.Raw)
+			if err != nil {
+				t.Fatalf("DecodeRichHeader(%s) failed, reason: %v", tt, err)
+			}
+			want := file.RichHeader
+			want.DansOffset = 0
+			if !reflect.DeepEqual(rh, want) {
+				t.Errorf("decoded rich header assertion failed, got %v, want %v",
+					rh, want)
+			}
+
+			raw := EncodeRichHeader(rh.CompIDs, rh.XORKey)
+			if !bytes.Equal(raw, file.RichHeader.Raw) {
+				t.Errorf("encoded rich header assertion failed, got %x, want %x",
+					raw, file.RichHeader.Raw)
+			}
+
+			// Re-encrypting with another key round trips.
+			raw = EncodeRichHeader(rh.CompIDs, 0xdeadbeef)
+			rh2, err := DecodeRichHeader(raw)
+			if err != nil {
+				t.Fatalf("DecodeRichHeader(%s) failed, reason: %v", tt, err)
+			}
+			if rh2.XORKey != 0xdeadbeef || !reflect.DeepEqual(rh2.CompIDs, rh.CompIDs) {
+				t.Errorf("re-encrypted rich header assertion failed, got %v, want %v",
+					rh2.CompIDs, rh.CompIDs)
+			}
+
+			checksum := ComputeRichHeaderChecksum(file.data,
+				file.RichHeader.DansOffset, rh.CompIDs)
+			if checksum != rh.XORKey {
+				t.Errorf("rich header checksum assertion failed, got 0x%x, want 0x%x",
+					checksum, rh.XORKey)
+			}
+		})
+	}
+
+	_, err := DecodeRichHeader([]byte("DanS"))
+	if !errors.Is(err, ErrRichSignatureNotFound) {
+		t.Errorf("DecodeRichHeader() error assertion failed, got %v, want %v",
+			err, ErrRichSignatureNotFound)
+	}
+
+	raw := EncodeRichHeader(nil, 0x1234)
+	raw[0] ^= 0xff
+	_, err = DecodeRichHeader(raw)
+	if !errors.Is(err, ErrDansSignatureNotFound) {
+		t.Errorf("DecodeRichHeader() error assertion failed, got %v, want %v",
+			err, ErrDansSignatureNotFound)
+	}
+}
@@ -6,6 +6,7 @@ package pe
 
 import (
 	"encoding/binary"
+	"fmt"
 	"math"
 	"sort"
 	"strings"
@@ -236,6 +237,40 @@ type Section struct {
 	// the case when the section entropy is equal to zero and the case when the
 	// entropy is equal to nil - meaning that it was never calculated.
 	Entropy *float64 `json:"entropy,omitempty"`
+
+	// FuzzyHashes of the section content, only populated with the
+	// SectionFuzzyHashes option.
+	FuzzyHashes *FuzzyHashes `json:"fuzzy_hashes,omitempty"`
+
+	// Padding describes the bytes which follow the section content in the
+	// file, nil when the section has no padding.
+	Padding *SectionPadding `json:"padding,omitempty"`
+
+	// Name is the section name, with long names of the form `/N` resolved
+	// from the COFF string table. The raw name remains in Header.Name.
+	Name string `json:"name"`
+
+	// headerOffset is the file offset of the section header.
+	headerOffset uint32
+}
+
+// SectionPadding represents the file region which follows the content of a
+// section: it starts after the first VirtualSize bytes of raw data and runs
+// up to the raw data of the next section. Compilers and linkers fill this space
+// with zeros, non-zero padding is a good indicator of an infected or patched
+// binary.
+type SectionPadding struct {
+	// Offset is the file offset where the padding starts.
+	Offset uint32 `json:"offset"`
+
+	// Size is the number of bytes in the padding.
+	Size uint32 `json:"size"`
+
+	// NonZeroCount is the number of padding bytes different from zero.
+	NonZeroCount uint32 `json:"non_zero_count"`
+
+	// Entropy represents the padding entropy.
+	Entropy float64 `json:"entropy"`
 }
 
 // ParseSectionHeader parses the PE section headers. Each row of the section
@@ -264,7 +299,23 @@ func (pe *File) ParseSectionHeader() (err error) {
 	for i := uint16(0); i < numberOfSections; i++ {
 		err := pe.structUnpack(&secHeader, offset, secHeaderSize)
 		if err != nil {
-			return err
+			// Keep the sections parsed so far, tiny images are still
+			// loaded with their section table cut by the end of the file.
+			err = pe.violation("section table", AnoSectionTableBeyondFile,
+				pe.checkTruncated("section table", offset, secHeaderSize, err))
+			if err != nil {
+				return err
+			}
+			break
+		}
+
+		// In an image dumped from memory, the data of the sections lies at
+		// their virtual address, up to their virtual size.
+		if pe.opts.MappedImage {
+			secHeader.PointerToRawData = secHeader.VirtualAddress
+			if secHeader.VirtualSize != 0 {
+				secHeader.SizeOfRawData = secHeader.VirtualSize
+			}
 		}
 
 		if secEnd := int64(secHeader.PointerToRawData) + int64(secHeader.SizeOfRawData); secEnd > pe.OverlayOffset {
@@ -272,48 +323,50 @@ func (pe *File) ParseSectionHeader() (err error) {
 		}
 
 		countErr := 0
-		sec := Section{Header: secHeader}
+		sec := Section{Header: secHeader, headerOffset: offset}
+		sec.Name = pe.sectionName(secHeader.Name)
 		secName := sec.String()
 
 		if (ImageSectionHeader{}) == secHeader {
-			pe.Anomalies = append(pe.Anomalies, "Section `"+secName+"` Contents are null-bytes")
+			pe.appendAnomaly(fmt.Sprintf(AnoSectionNullBytes, secName))
 			countErr++
 		}
 
-		if secHeader.SizeOfRawData+secHeader.PointerToRawData > pe.size {
-			pe.Anomalies = append(pe.Anomalies, "Section `"+secName+
-				"` SizeOfRawData is larger than file")
+		if uint64(secHeader.SizeOfRawData)+uint64(secHeader.PointerToRawData) > pe.size {
+			pe.appendAnomaly(fmt.Sprintf(AnoSectionRawDataBeyondFile, secName))
 			countErr++
 		}
 
-		if pe.adjustFileAlignment(secHeader.PointerToRawData) > pe.size {
-			pe.Anomalies = append(pe.Anomalies, "Section `"+secName+
-				"` PointerToRawData points beyond the end of the file")
+		if uint64(pe.adjustFileAlignment(secHeader.PointerToRawData)) > pe.size {
+			pe.appendAnomaly(fmt.Sprintf(AnoSectionPointerToRawDataBeyondFile,
+				secName))
 			countErr++
 		}
 
 		if secHeader.VirtualSize > 0x10000000 {
-			pe.Anomalies = append(pe.Anomalies, "Section `"+secName+
-				"` VirtualSize is extremely large > 256MiB")
+			pe.appendAnomaly(fmt.Sprintf(AnoSectionVirtualSizeTooLarge, secName))
 			countErr++
 		}
 
 		if pe.adjustSectionAlignment(secHeader.VirtualAddress) > 0x10000000 {
-			pe.Anomalies = append(pe.Anomalies, "Section `"+secName+
-				"` VirtualAddress is beyond 0x10000000")
+			pe.appendAnomaly(fmt.Sprintf(AnoSectionVirtualAddressTooLarge, secName))
 			countErr++
 		}
 
 		var fileAlignment uint32
 		switch pe.Is64 {
 		case true:
-			fileAlignment = pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).FileAlignment
+			if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+				fileAlignment = oh64.FileAlignment
+			}
 		case false:
-			fileAlignment = pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).FileAlignment
+			if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+				fileAlignment = oh32.FileAlignment
+			}
 		}
 		if fileAlignment != 0 && secHeader.PointerToRawData%fileAlignment != 0 {
-			pe.Anomalies = append(pe.Anomalies, "Section `"+secName+
-				"` PointerToRawData is not multiple of FileAlignment")
+			pe.appendAnomaly(fmt.Sprintf(AnoSectionPointerToRawDataUnaligned,
+				secName))
 			countErr++
 		}
 
@@ -326,17 +379,48 @@ func (pe *File) ParseSectionHeader() (err error) {
 			entropy := sec.CalculateEntropy(pe)
 			sec.Entropy = &entropy
 		}
+		if pe.opts.SectionFuzzyHashes {
+			hashes := sec.CalculateFuzzyHashes(pe)
+			sec.FuzzyHashes = &hashes
+		}
 		pe.Sections = append(pe.Sections, sec)
 
 		offset += secHeaderSize
 	}
 
+	// Report sections sharing the same name, the indexes are the ones of
+	// the section table.
+	sectionIndexes := make(map[string]int)
+	for i, sec := range pe.Sections {
+		name := sec.String()
+		if j, ok := sectionIndexes[name]; ok {
+			pe.appendAnomaly(fmt.Sprintf(AnoSectionNameDuplicated, name, i, j))
+			continue
+		}
+		sectionIndexes[name] = i
+	}
+
+	// The section table is expected to be sorted by VirtualAddress.
+	if !sort.IsSorted(byVirtualAddress(pe.Sections)) {
+		pe.appendAnomaly(AnoSectionsNotSortedByVA)
+	}
+
 	// Sort the sections by their VirtualAddress. This will allow to check
 	// for potentially overlapping sections in badly constructed PEs.
-	sort.Sort(byVirtualAddress(pe.Sections))
+	sort.Stable(byVirtualAddress(pe.Sections))
+
+	// Compute the normalized layout used to translate RVAs to file offsets.
+	pe.buildSectionMap()
+
+	// Collect statistics about the bytes found between sections.
+	pe.parseSectionsPadding()
 
+	// The headers end with the section table, the offset was only advanced
+	// for the sections kept in the loop above.
 	if pe.NtHeader.FileHeader.NumberOfSections > 0 && len(pe.Sections) > 0 {
-		offset += secHeaderSize * uint32(pe.NtHeader.FileHeader.NumberOfSections)
+		offset = optionalHeaderOffset +
+			uint32(pe.NtHeader.FileHeader.SizeOfOptionalHeader) +
+			secHeaderSize*uint32(pe.NtHeader.FileHeader.NumberOfSections)
 	}
 
 	// There could be a problem if there are no raw data sections
@@ -359,25 +443,244 @@ func (pe *File) ParseSectionHeader() (err error) {
 		lowestSectionOffset = 0
 	}
 
-	if lowestSectionOffset == 0 || lowestSectionOffset < offset {
-		if offset <= pe.size {
+	// When the NT headers are relocated past the first section raw data,
+	// only the bytes preceding the sections are mapped as headers.
+	relocatedHeaders := pe.DOSHeader.AddressOfNewEXEHeader >= lowestSectionOffset
+	if lowestSectionOffset == 0 ||
+		(lowestSectionOffset < offset && !relocatedHeaders) {
+		if uint64(offset) <= pe.size {
 			pe.Header = pe.data[:offset]
 		}
 	} else {
-		if lowestSectionOffset <= pe.size {
+		if uint64(lowestSectionOffset) <= pe.size {
 			pe.Header = pe.data[:lowestSectionOffset]
 		}
 	}
 
+	// Tiny images may have no section at all and rely on the loader mapping
+	// the headers, or the whole file in low alignment mode.
+	if pe.isLowAlignment() {
+		pe.addAnomaly(AnoLowAlignment)
+	}
+	if len(pe.Sections) == 0 {
+		pe.addAnomaly(AnoHeaderOnlyImage)
+
+		headerSize := Max(offset, pe.sizeOfHeaders())
+		if pe.isLowAlignment() {
+			headerSize = Max(headerSize, pe.sizeOfImage())
+		}
+		pe.Header = pe.data[:min(headerSize, pe.remaining(0))]
+	}
+
+	// The NT headers can be found anywhere e_lfanew points to.
+	pe.checkPEHeaderLocation(offset)
+
+	// The overlay is everything which lies past the end of the last section
+	// raw data, it is computed here so that getters do not have to.
+	if pe.OverlayOffset > 0 && pe.OverlayOffset < int64(pe.size) {
+		pe.HasOverlay = true
+	}
+
 	pe.HasSections = true
 	return nil
 }
 
-// String stringifies the section name.
+// checkPEHeaderLocation records where the NT headers and the section table
+// ending at headersEnd are located. The loader reads them from the file
+// offset e_lfanew points to, which is not necessarily within SizeOfHeaders:
+// they can share the raw data of a section, or be appended after the last
+// section, in which case they are not considered part of the overlay.
+func (pe *File) checkPEHeaderLocation(headersEnd uint32) {
+	start := pe.DOSHeader.AddressOfNewEXEHeader
+
+	if start >= pe.sizeOfHeaders() {
+		pe.addAnomaly(AnoPEHeaderBeyondSizeOfHeaders)
+	}
+
+	for _, sec := range pe.Sections {
+		secStart := pe.adjustFileAlignment(sec.Header.PointerToRawData)
+		secEnd := uint64(secStart) + uint64(sec.Header.SizeOfRawData)
+		if sec.Header.SizeOfRawData > 0 && uint64(start) < secEnd &&
+			headersEnd > secStart {
+			pe.addAnomaly(AnoPEHeaderOverlapSection)
+			break
+		}
+	}
+
+	if len(pe.Sections) > 0 && int64(start) >= pe.OverlayOffset {
+		pe.addAnomaly(AnoPEHeaderInOverlay)
+		if int64(headersEnd) > pe.OverlayOffset {
+			pe.OverlayOffset = int64(headersEnd)
+		}
+	}
+}
+
+// SectionMapping describes where a section lives in memory and in the file
+// once its header values have been normalized the way the Windows loader does:
+// addresses are aligned, a null VirtualSize falls back to SizeOfRawData, raw
+// data running past the end of the file is truncated and a section whose
+// virtual range overlaps the next one is cut where the next one starts.
+type SectionMapping struct {
+	// Index of the section in File.Sections.
+	Index int `json:"index"`
+
+	// VirtualAddress is the section RVA aligned to SectionAlignment.
+	VirtualAddress uint32 `json:"virtual_address"`
+
+	// VirtualSize is the size of the address range the section covers.
+	VirtualSize uint32 `json:"virtual_size"`
+
+	// PointerToRawData is the section file offset aligned to FileAlignment.
+	PointerToRawData uint32 `json:"pointer_to_raw_data"`
+
+	// SizeOfRawData is the number of bytes effectively backed by the file.
+	SizeOfRawData uint32 `json:"size_of_raw_data"`
+}
+
+// buildSectionMap computes the normalized section layout. It expects the
+// sections to be sorted by VirtualAddress.
+func (pe *File) buildSectionMap() {
+	sectionMap := pe.sectionMap[:0]
+
+	for i := range pe.Sections {
+		header := pe.Sections[i].Header
+		rawStart := pe.adjustFileAlignment(header.PointerToRawData)
+		vaStart := pe.adjustSectionAlignment(header.VirtualAddress)
+
+		// In low alignment mode, the file is mapped as is: the section
+		// data is found at its address, whatever PointerToRawData says.
+		rawSize := header.SizeOfRawData
+		if pe.isLowAlignment() {
+			rawStart = vaStart
+			rawSize = Max(header.SizeOfRawData, header.VirtualSize)
+		}
+
+		// Check if the SizeOfRawData is realistic. If it's bigger than the
+		// size of the whole PE file minus the start address of the section
+		// it could be either truncated or the SizeOfRawData contains a
+		// misleading value. In either of those cases we take the VirtualSize.
+		var virtualSize uint32
+		if uint64(rawStart) > pe.size || pe.remaining(rawStart) < rawSize {
+			virtualSize = header.VirtualSize
+		} else {
+			virtualSize = Max(rawSize, header.VirtualSize)
+		}
+
+		// Cut the range where the next section starts.
+		if i+1 < len(pe.Sections) {
+			next := pe.Sections[i+1].Header.VirtualAddress
+			if next > header.VirtualAddress && vaStart+virtualSize > next {
+				virtualSize = next - vaStart
+			}
+		}
+
+		// Raw data past the end of the file is not backed by anything.
+		if uint64(rawStart) >= pe.size {
+			rawSize = 0
+		} else if pe.remaining(rawStart) < rawSize {
+			rawSize = pe.remaining(rawStart)
+		}
+
+		sectionMap = append(sectionMap, SectionMapping{
+			Index:            i,
+			VirtualAddress:   vaStart,
+			VirtualSize:      virtualSize,
+			PointerToRawData: rawStart,
+			SizeOfRawData:    rawSize,
+		})
+	}
+
+	// Report sections sharing the same raw data. This is legit with some
+	// packers but is also used to confuse parsers.
+	for i := range sectionMap {
+		for j := i + 1; j < len(sectionMap); j++ {
+			a, b := sectionMap[i], sectionMap[j]
+			if a.SizeOfRawData == 0 || b.SizeOfRawData == 0 {
+				continue
+			}
+			if a.PointerToRawData < b.PointerToRawData+b.SizeOfRawData &&
+				b.PointerToRawData < a.PointerToRawData+a.SizeOfRawData {
+				pe.appendAnomaly(fmt.Sprintf(AnoSectionRawDataOverlap,
+					pe.Sections[a.Index].String(), pe.Sections[b.Index].String()))
+			}
+		}
+	}
+
+	pe.sectionMap = sectionMap
+}
+
+// SectionMap returns the normalized section layout used for RVA to file
+// offset translation, sorted by VirtualAddress.
+func (pe *File) SectionMap() []SectionMapping {
+	return pe.sectionMap
+}
+
+// String stringifies the section name, long names are resolved from the
+// COFF string table.
 func (section *Section) String() string {
+	if section.Name != "" {
+		return section.Name
+	}
+	return section.RawName()
+}
+
+// RawName returns the name as found in the section header, which is of the
+// form `/N` for names longer than 8 bytes.
+func (section *Section) RawName() string {
 	return strings.Replace(string(section.Header.Name[:]), "\x00", "", -1)
 }
 
+// sectionName resolves the name of a section header. Names longer than 8
+// bytes, emitted by MinGW or the Go linker, are stored in the COFF string
+// table and the header holds a slash followed by their offset into the
+// table, in decimal or, for LLVM large offsets, `//` followed by base64.
+func (pe *File) sectionName(name [8]uint8) string {
+	raw := strings.Replace(string(name[:]), "\x00", "", -1)
+	if len(raw) < 2 || raw[0] != '/' {
+		return raw
+	}
+
+	var strOff uint64
+	if raw[1] == '/' {
+		const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+		if len(raw) == 2 {
+			return raw
+		}
+		for _, c := range raw[2:] {
+			i := strings.IndexRune(alphabet, c)
+			if i < 0 {
+				return raw
+			}
+			strOff = strOff*64 + uint64(i)
+		}
+	} else {
+		for _, c := range raw[1:] {
+			if c < '0' || c > '9' {
+				return raw
+			}
+			strOff = strOff*10 + uint64(c-'0')
+		}
+	}
+
+	// The string table immediately follows the symbol table, the offset
+	// includes the 4 bytes of the table size.
+	fileHdr := pe.NtHeader.FileHeader
+	if fileHdr.PointerToSymbolTable == 0 || strOff < 4 {
+		return raw
+	}
+	strTableOffset := uint64(fileHdr.PointerToSymbolTable) +
+		uint64(fileHdr.NumberOfSymbols)*uint64(binary.Size(COFFSymbol{}))
+	offset := strTableOffset + strOff
+	if offset >= pe.size {
+		return raw
+	}
+	n, str := pe.readASCIIStringAtOffset(uint32(offset), MaxCOFFSymStrLength)
+	if n == 0 {
+		return raw
+	}
+	return str
+}
+
 // NextHeaderAddr returns the VirtualAddress of the next section.
 func (section *Section) NextHeaderAddr(pe *File) uint32 {
 	for i, currentSection := range pe.Sections {
@@ -403,7 +706,7 @@ func (section *Section) Contains(rva uint32, pe *File) bool {
 
 	var size uint32
 	adjustedPointer := pe.adjustFileAlignment(section.Header.PointerToRawData)
-	if uint32(len(pe.data))-adjustedPointer < section.Header.SizeOfRawData {
+	if pe.remaining(adjustedPointer) < section.Header.SizeOfRawData {
 		size = section.Header.VirtualSize
 	} else {
 		size = Max(section.Header.SizeOfRawData, section.Header.VirtualSize)
@@ -430,6 +733,15 @@ func (section *Section) Data(start, length uint32, pe *File) []byte {
 	virtualAddressAdj := pe.adjustSectionAlignment(
 		section.Header.VirtualAddress)
 
+	// In low alignment mode, the section data is read at its address.
+	pointerToRawData := section.Header.PointerToRawData
+	sizeOfRawData := section.Header.SizeOfRawData
+	if pe.isLowAlignment() {
+		pointerToRawDataAdj = virtualAddressAdj
+		pointerToRawData = virtualAddressAdj
+		sizeOfRawData = Max(sizeOfRawData, section.Header.VirtualSize)
+	}
+
 	var offset uint32
 	if start == 0 {
 		offset = pointerToRawDataAdj
@@ -437,23 +749,23 @@ func (section *Section) Data(start, length uint32, pe *File) []byte {
 		offset = (start - virtualAddressAdj) + pointerToRawDataAdj
 	}
 
-	if offset > pe.size {
+	if uint64(offset) > pe.size {
 		return nil
 	}
 
-	var end uint32
+	var end uint64
 	if length != 0 {
-		end = offset + length
+		end = uint64(offset) + uint64(length)
 	} else {
-		end = offset + section.Header.SizeOfRawData
+		end = uint64(offset) + uint64(sizeOfRawData)
 	}
 
 	// PointerToRawData is not adjusted here as we might want to read any possible
 	// extra bytes that might get cut off by aligning the start (and hence cutting
 	// something off the end)
-	if end > section.Header.PointerToRawData+section.Header.SizeOfRawData &&
-		section.Header.PointerToRawData+section.Header.SizeOfRawData > offset {
-		end = section.Header.PointerToRawData + section.Header.SizeOfRawData
+	rawEnd := uint64(pointerToRawData) + uint64(sizeOfRawData)
+	if end > rawEnd && rawEnd > uint64(offset) {
+		end = rawEnd
 	}
 
 	if end > pe.size {
@@ -463,34 +775,283 @@ func (section *Section) Data(start, length uint32, pe *File) []byte {
 	return pe.data[offset:end]
 }
 
+// VirtualEnd returns the RVA immediately following the section once mapped,
+// that is VirtualAddress plus VirtualSize. As the loader does, SizeOfRawData
+// is used when VirtualSize is zero. The end is not rounded to the section
+// alignment.
+func (section *Section) VirtualEnd() uint64 {
+	size := section.Header.VirtualSize
+	if size == 0 {
+		size = section.Header.SizeOfRawData
+	}
+	return uint64(section.Header.VirtualAddress) + uint64(size)
+}
+
+// RawEnd returns the file offset immediately following the raw data of the
+// section, that is PointerToRawData plus SizeOfRawData.
+func (section *Section) RawEnd() uint64 {
+	return uint64(section.Header.PointerToRawData) +
+		uint64(section.Header.SizeOfRawData)
+}
+
+// SectionPermissions is the memory protection of a section once mapped, as
+// derived from its characteristics.
+type SectionPermissions struct {
+	Read    bool `json:"read"`
+	Write   bool `json:"write"`
+	Execute bool `json:"execute"`
+}
+
+// String returns the permissions in the `rwx` notation, such as `r-x`.
+func (p SectionPermissions) String() string {
+	perms := []byte("---")
+	if p.Read {
+		perms[0] = 'r'
+	}
+	if p.Write {
+		perms[1] = 'w'
+	}
+	if p.Execute {
+		perms[2] = 'x'
+	}
+	return string(perms)
+}
+
+// Permissions returns the memory protection of the section once mapped.
+func (section *Section) Permissions() SectionPermissions {
+	characteristics := section.Header.Characteristics
+	return SectionPermissions{
+		Read:    characteristics&ImageSectionMemRead != 0,
+		Write:   characteristics&ImageSectionMemWrite != 0,
+		Execute: characteristics&ImageSectionMemExecute != 0,
+	}
+}
+
+// MemoryRegion is a section mapped with noteworthy memory attributes.
+type MemoryRegion struct {
+	Name           string             `json:"name"`
+	VirtualAddress uint32             `json:"virtual_address"`
+	VirtualSize    uint32             `json:"virtual_size"`
+	Permissions    SectionPermissions `json:"permissions"`
+}
+
+// MemoryPermissions summarizes the memory attributes of the sections of the
+// image which deserve attention.
+type MemoryPermissions struct {
+	// The sections both writable and executable, which allow code to be
+	// modified or injected at runtime.
+	WX []MemoryRegion `json:"wx,omitempty"`
+
+	// The sections shared between all the processes loading the image.
+	Shared []MemoryRegion `json:"shared,omitempty"`
+
+	// The sections which are never paged out, mostly found in drivers.
+	NotPaged []MemoryRegion `json:"not_paged,omitempty"`
+}
+
+// MemoryPermissions returns the writable and executable, shared and not
+// paged sections of the image.
+func (pe *File) MemoryPermissions() MemoryPermissions {
+	var perms MemoryPermissions
+	for i := range pe.Sections {
+		section := &pe.Sections[i]
+		region := MemoryRegion{
+			Name:           section.String(),
+			VirtualAddress: section.Header.VirtualAddress,
+			VirtualSize:    uint32(section.VirtualEnd()) - section.Header.VirtualAddress,
+			Permissions:    section.Permissions(),
+		}
+
+		characteristics := section.Header.Characteristics
+		if region.Permissions.Write && region.Permissions.Execute {
+			perms.WX = append(perms.WX, region)
+		}
+		if characteristics&ImageSectionMemShared != 0 {
+			perms.Shared = append(perms.Shared, region)
+		}
+		if characteristics&ImageSectionMemNotPaged != 0 {
+			perms.NotPaged = append(perms.NotPaged, region)
+		}
+	}
+	return perms
+}
+
+// SlackRange returns the byte range of the slack space of the section: the
+// file region between the end of its raw data and the raw data of the next
+// section in the file. The slack space is not mapped by the loader, which
+// makes it a hiding spot for payloads. The range is empty for the last
+// section, the bytes which follow it belong to the overlay.
+func (section *Section) SlackRange(pe *File) ByteRange {
+	if section.Header.SizeOfRawData == 0 {
+		return ByteRange{}
+	}
+
+	start := uint64(pe.adjustFileAlignment(section.Header.PointerToRawData)) +
+		uint64(section.Header.SizeOfRawData)
+	end := uint64(0)
+	for _, next := range pe.Sections {
+		if next.Header.SizeOfRawData == 0 {
+			continue
+		}
+		nextStart := uint64(pe.adjustFileAlignment(next.Header.PointerToRawData))
+		if nextStart >= start && (end == 0 || nextStart < end) {
+			end = nextStart
+		}
+	}
+	if end > pe.size {
+		end = pe.size
+	}
+	if end <= start {
+		return ByteRange{}
+	}
+
+	return ByteRange{Offset: uint32(start), Length: uint32(end - start)}
+}
+
+// Slack returns the bytes of the slack space of the section, see SlackRange.
+func (section *Section) Slack(pe *File) []byte {
+	slack := section.SlackRange(pe)
+	if slack.Length == 0 {
+		return nil
+	}
+	return pe.data[slack.Offset:slack.End()]
+}
+
 // CalculateEntropy calculates section entropy.
 func (section *Section) CalculateEntropy(pe *File) float64 {
-	sectionData := section.Data(0, 0, pe)
-	if sectionData == nil {
-		return 0.0
-	}
+	return entropy(section.Data(0, 0, pe))
+}
+
+// CalculateFuzzyHashes calculates the fuzzy hashes of the section content.
+func (section *Section) CalculateFuzzyHashes(pe *File) FuzzyHashes {
+	return fuzzyHashes(section.Data(0, 0, pe))
+}
 
-	sectionSize := float64(len(sectionData))
-	if sectionSize == 0.0 {
+// entropy calculates the Shannon entropy of a byte slice.
+func entropy(data []byte) float64 {
+	size := float64(len(data))
+	if size == 0.0 {
 		return 0.0
 	}
 
 	var frequencies [256]uint64
-	for _, v := range sectionData {
+	for _, v := range data {
 		frequencies[v]++
 	}
 
 	var entropy float64
 	for _, p := range frequencies {
 		if p > 0 {
-			freq := float64(p) / sectionSize
+			freq := float64(p) / size
 			entropy += freq * math.Log2(freq)
 		}
 	}
 
+	if entropy == 0 {
+		return 0
+	}
 	return -entropy
 }
 
+// parseSectionsPadding computes the padding statistics of every section. The
+// padding of a section starts right after its content (VirtualSize bytes)
+// and ends where the raw data of the next section in the file begins. For
+// the last section, it stops at the end of its raw data to not account for
+// the overlay.
+func (pe *File) parseSectionsPadding() {
+
+	// Sections are sorted by VirtualAddress, the padding analysis needs them
+	// ordered by their location in the file.
+	sections := make([]*Section, 0, len(pe.Sections))
+	for i := range pe.Sections {
+		if pe.Sections[i].Header.PointerToRawData == 0 ||
+			pe.Sections[i].Header.SizeOfRawData == 0 {
+			continue
+		}
+		sections = append(sections, &pe.Sections[i])
+	}
+	sort.SliceStable(sections, func(i, j int) bool {
+		return sections[i].Header.PointerToRawData < sections[j].Header.PointerToRawData
+	})
+
+	for i, section := range sections {
+		rawStart := pe.adjustFileAlignment(section.Header.PointerToRawData)
+		rawEnd := rawStart + section.Header.SizeOfRawData
+		if rawEnd < rawStart || uint64(rawStart) >= pe.size {
+			continue
+		}
+
+		// The content of the section is VirtualSize bytes long, the raw data
+		// beyond it is padding. A zero VirtualSize means the whole raw data
+		// is content.
+		contentSize := section.Header.SizeOfRawData
+		if section.Header.VirtualSize != 0 &&
+			section.Header.VirtualSize < contentSize {
+			contentSize = section.Header.VirtualSize
+		}
+		start := rawStart + contentSize
+
+		end := rawEnd
+		if i+1 < len(sections) {
+			next := pe.adjustFileAlignment(sections[i+1].Header.PointerToRawData)
+			if next > rawStart {
+				end = next
+			}
+		}
+		if uint64(end) > pe.size {
+			end = uint32(pe.size)
+		}
+		if start >= end {
+			continue
+		}
+
+		data := pe.data[start:end]
+		padding := SectionPadding{
+			Offset:  start,
+			Size:    end - start,
+			Entropy: entropy(data),
+		}
+		for _, b := range data {
+			if b != 0 {
+				padding.NonZeroCount++
+			}
+		}
+		if padding.NonZeroCount > 0 && !isLinkerPadding(data) {
+			pe.appendAnomaly(fmt.Sprintf(AnoSectionPaddingNotZero,
+				section.String()))
+		}
+
+		section.Padding = &padding
+	}
+}
+
+// isLinkerPadding returns true when the data matches one of the fill patterns
+// emitted by linkers: null bytes, INT3 opcodes between code sections, or the
+// `PADDINGXX` string at the end of resource sections.
+func isLinkerPadding(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+
+	filler := data[0]
+	if filler == 0x00 || filler == 0xCC {
+		for _, b := range data {
+			if b != filler {
+				return false
+			}
+		}
+		return true
+	}
+
+	pattern := []byte("PADDINGXXPADDING")
+	for i, b := range data {
+		if b != pattern[i%len(pattern)] && b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // byVirtualAddress sorts all sections by Virtual Address.
 type byVirtualAddress []Section
 
@@ -509,60 +1070,75 @@ func (s byPointerToRawData) Less(i, j int) bool {
 	return s[i].Header.PointerToRawData < s[j].Header.PointerToRawData
 }
 
+// SectionCharacteristicsType represents the `Characteristics` field of the
+// section header.
+type SectionCharacteristicsType uint32
+
+// sectionFlags names the flags of the `Characteristics` field of the section
+// header.
+var sectionFlags = map[SectionCharacteristicsType]string{
+	//ImageSectionReserved1:            "Reserved1",
+	ImageSectionReserved2:            "Reserved2",
+	ImageSectionReserved3:            "Reserved3",
+	ImageSectionReserved4:            "Reserved4",
+	ImageSectionTypeNoPad:            "No Padd",
+	ImageSectionReserved5:            "Reserved5",
+	ImageSectionCntCode:              "Contains Code",
+	ImageSectionCntInitializedData:   "Initialized Data",
+	ImageSectionCntUninitializedData: "Uninitialized Data",
+	ImageSectionLnkOther:             "Lnk Other",
+	ImageSectionLnkInfo:              "Lnk Info",
+	ImageSectionReserved6:            "Reserved6",
+	ImageSectionLnkRemove:            "LnkRemove",
+	ImageSectionLnkCOMDAT:            "LnkCOMDAT",
+	ImageSectionGpRel:                "GpReferenced",
+	ImageSectionMemPurgeable:         "Purgeable",
+	ImageSectionMemLocked:            "Locked",
+	ImageSectionMemPreload:           "Preload",
+	ImageSectionAlign1Bytes:          "Align1Bytes",
+	ImageSectionAlign2Bytes:          "Align2Bytes",
+	ImageSectionAlign4Bytes:          "Align4Bytes",
+	ImageSectionAlign8Bytes:          "Align8Bytes",
+	ImageSectionAlign16Bytes:         "Align16Bytes",
+	ImageSectionAlign32Bytes:         "Align32Bytes",
+	ImageSectionAlign64Bytes:         "Align64Bytes",
+	ImageSectionAlign128Bytes:        "Align128Bytes",
+	ImageSectionAlign256Bytes:        "Align256Bytes",
+	ImageSectionAlign512Bytes:        "Align512Bytes",
+	ImageSectionAlign1024Bytes:       "Align1024Bytes",
+	ImageSectionAlign2048Bytes:       "Align2048Bytes",
+	ImageSectionAlign4096Bytes:       "Align4096Bytes",
+	ImageSectionAlign8192Bytes:       "Align8192Bytes",
+	ImageSectionLnkMRelocOvfl:        "ExtendedReloc",
+	ImageSectionMemDiscardable:       "Discardable",
+	ImageSectionMemNotCached:         "NotCached",
+	ImageSectionMemNotPaged:          "NotPaged",
+	ImageSectionMemShared:            "Shared",
+	ImageSectionMemExecute:           "Executable",
+	ImageSectionMemRead:              "Readable",
+	ImageSectionMemWrite:             "Writable",
+}
+
 // PrettySectionFlags returns the string representations of the `Flags` field
 // of section header.
 func (section *Section) PrettySectionFlags() []string {
-	var values []string
-
-	sectionFlags := map[uint32]string{
-		//ImageSectionReserved1:            "Reserved1",
-		ImageSectionReserved2:            "Reserved2",
-		ImageSectionReserved3:            "Reserved3",
-		ImageSectionReserved4:            "Reserved4",
-		ImageSectionTypeNoPad:            "No Padd",
-		ImageSectionReserved5:            "Reserved5",
-		ImageSectionCntCode:              "Contains Code",
-		ImageSectionCntInitializedData:   "Initialized Data",
-		ImageSectionCntUninitializedData: "Uninitialized Data",
-		ImageSectionLnkOther:             "Lnk Other",
-		ImageSectionLnkInfo:              "Lnk Info",
-		ImageSectionReserved6:            "Reserved6",
-		ImageSectionLnkRemove:            "LnkRemove",
-		ImageSectionLnkCOMDAT:            "LnkCOMDAT",
-		ImageSectionGpRel:                "GpReferenced",
-		ImageSectionMemPurgeable:         "Purgeable",
-		ImageSectionMemLocked:            "Locked",
-		ImageSectionMemPreload:           "Preload",
-		ImageSectionAlign1Bytes:          "Align1Bytes",
-		ImageSectionAlign2Bytes:          "Align2Bytes",
-		ImageSectionAlign4Bytes:          "Align4Bytes",
-		ImageSectionAlign8Bytes:          "Align8Bytes",
-		ImageSectionAlign16Bytes:         "Align16Bytes",
-		ImageSectionAlign32Bytes:         "Align32Bytes",
-		ImageSectionAlign64Bytes:         "Align64Bytes",
-		ImageSectionAlign128Bytes:        "Align128Bytes",
-		ImageSectionAlign256Bytes:        "Align256Bytes",
-		ImageSectionAlign512Bytes:        "Align512Bytes",
-		ImageSectionAlign1024Bytes:       "Align1024Bytes",
-		ImageSectionAlign2048Bytes:       "Align2048Bytes",
-		ImageSectionAlign4096Bytes:       "Align4096Bytes",
-		ImageSectionAlign8192Bytes:       "Align8192Bytes",
-		ImageSectionLnkMRelocOvfl:        "ExtendedReloc",
-		ImageSectionMemDiscardable:       "Discardable",
-		ImageSectionMemNotCached:         "NotCached",
-		ImageSectionMemNotPaged:          "NotPaged",
-		ImageSectionMemShared:            "Shared",
-		ImageSectionMemExecute:           "Executable",
-		ImageSectionMemRead:              "Readable",
-		ImageSectionMemWrite:             "Writable",
-	}
-
-	flags := section.Header.Characteristics
+	return SectionCharacteristicsType(section.Header.Characteristics).Flags()
+}
+
+// Flags returns the names of the flags set in the `Characteristics` field of
+// the section header, ordered by flag value.
+func (flags SectionCharacteristicsType) Flags() []string {
+	return flagNames(sectionFlags, func(k uint64) bool {
+		return SectionCharacteristicsType(k)&flags == SectionCharacteristicsType(k)
+	})
+}
+
+// FlagsMap returns every flag of the `Characteristics` field of the section
+// header mapped to whether it is set.
+func (flags SectionCharacteristicsType) FlagsMap() map[string]bool {
+	values := make(map[string]bool, len(sectionFlags))
 	for k, v := range sectionFlags {
-		if (k & flags) == k {
-			values = append(values, v)
-		}
+		values[v] = (k & flags) == k
 	}
-
 	return values
 }
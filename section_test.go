@@ -5,6 +5,10 @@
 package pe
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
 	"reflect"
 	"sort"
 	"testing"
@@ -87,3 +91,743 @@ func TestParseSectionHeaders(t *testing.T) {
 		})
 	}
 }
+
+func TestSectionPadding(t *testing.T) {
+
+	tests := []struct {
+		in          string
+		sectionName string
+		out         SectionPadding
+		wantAnomaly bool
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), ".text",
+			SectionPadding{Offset: 0x9cc26, Size: 474, NonZeroCount: 474}, false},
+		{getAbsoluteFilePath("test/putty.exe"), ".rdata",
+			SectionPadding{Offset: 0xc8e14, Size: 492}, false},
+		{getAbsoluteFilePath("test/mfc40u.dll"), ".rsrc",
+			SectionPadding{Offset: 0xd2e9c, Size: 356, NonZeroCount: 356,
+				Entropy: 2.747099941585763}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in+tt.sectionName, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			var padding *SectionPadding
+			for _, section := range file.Sections {
+				if section.String() == tt.sectionName {
+					padding = section.Padding
+				}
+			}
+			if padding == nil {
+				t.Fatalf("section %s padding not found", tt.sectionName)
+			}
+			if *padding != tt.out {
+				t.Errorf("section padding assertion failed, got %v, want %v",
+					*padding, tt.out)
+			}
+
+			anomaly := "Section `" + tt.sectionName + "` padding contains non-zero bytes"
+			if stringInSlice(anomaly, file.Anomalies) != tt.wantAnomaly {
+				t.Errorf("section padding anomaly assertion failed, got %v, want %v",
+					!tt.wantAnomaly, tt.wantAnomaly)
+			}
+		})
+	}
+}
+
+func TestSectionPaddingVirtualSize(t *testing.T) {
+
+	tests := []struct {
+		virtualSize uint32
+		out         *SectionPadding
+	}{
+		// The raw data beyond VirtualSize is padding.
+		{0x120, &SectionPadding{Offset: 0x320, Size: 0xe0, NonZeroCount: 0xe0}},
+		// VirtualSize covers the whole raw data, there is no padding.
+		{0x200, nil},
+		{0x1000, nil},
+	}
+
+	filename := getAbsoluteFilePath("test/impbyord.exe")
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("VirtualSize=0x%x", tt.virtualSize), func(t *testing.T) {
+			data := make([]byte, len(src))
+			copy(data, src)
+			binary.LittleEndian.PutUint32(data[0x138+8:], tt.virtualSize)
+			copy(data[0x320:], bytes.Repeat([]byte{'A'}, 0xe0))
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			padding := file.Sections[0].Padding
+			if tt.out == nil {
+				if padding != nil {
+					t.Errorf("section padding assertion failed, got %v, want nil",
+						*padding)
+				}
+				return
+			}
+			if padding == nil {
+				t.Fatalf("section padding not found")
+			}
+			padding.Entropy = 0
+			if *padding != *tt.out {
+				t.Errorf("section padding assertion failed, got %v, want %v",
+					*padding, *tt.out)
+			}
+			anomaly := fmt.Sprintf(AnoSectionPaddingNotZero, file.Sections[0].String())
+			if !stringInSlice(anomaly, file.Anomalies) {
+				t.Errorf("anomaly %q not found in %v", anomaly, file.Anomalies)
+			}
+		})
+	}
+}
+
+func TestSectionBoundaries(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	tests := []struct {
+		sectionName   string
+		sizeOfRawData uint32
+		virtualEnd    uint64
+		rawEnd        uint64
+		slack         ByteRange
+	}{
+		{".text", 0x9ca00, 0x9d826, 0x9ce00, ByteRange{}},
+		{".data", 0xc00, 0xd1198, 0xc9c00, ByteRange{}},
+		{".data", 0x800, 0xd1198, 0xc9800, ByteRange{Offset: 0xc9800, Length: 0x400}},
+		{".reloc", 0x1400, 0x127270, 0x11c000, ByteRange{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sectionName, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			// Shrink the raw data of the section to leave slack space before
+			// the next section.
+			for _, section := range file.Sections {
+				if section.String() == tt.sectionName {
+					binary.LittleEndian.PutUint32(data[section.headerOffset+16:],
+						tt.sizeOfRawData)
+				}
+			}
+			file, err = NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			var section *Section
+			for i := range file.Sections {
+				if file.Sections[i].String() == tt.sectionName {
+					section = &file.Sections[i]
+				}
+			}
+			if section == nil {
+				t.Fatalf("section %s not found", tt.sectionName)
+			}
+
+			if section.VirtualEnd() != tt.virtualEnd {
+				t.Errorf("section virtual end assertion failed, got 0x%x, want 0x%x",
+					section.VirtualEnd(), tt.virtualEnd)
+			}
+			if section.RawEnd() != tt.rawEnd {
+				t.Errorf("section raw end assertion failed, got 0x%x, want 0x%x",
+					section.RawEnd(), tt.rawEnd)
+			}
+			if !section.Contains(uint32(tt.virtualEnd)-1, file) {
+				t.Errorf("section does not contain its last byte 0x%x", tt.virtualEnd-1)
+			}
+			if section.SlackRange(file) != tt.slack {
+				t.Errorf("section slack assertion failed, got %v, want %v",
+					section.SlackRange(file), tt.slack)
+			}
+			slack := section.Slack(file)
+			if !bytes.Equal(slack, data[tt.slack.Offset:tt.slack.End()]) {
+				t.Errorf("section slack bytes assertion failed, got %d bytes, want %d",
+					len(slack), tt.slack.Length)
+			}
+		})
+	}
+}
+
+func TestSectionMapNonMonotonicTable(t *testing.T) {
+
+	tests := []string{
+		getAbsoluteFilePath("test/putty.exe"),
+	}
+
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			data, err := ioutil.ReadFile(tt)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", tt, err)
+			}
+
+			file, err := NewBytes(data, &Options{Fast: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", tt, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt, err)
+			}
+			want := file.SectionMap()
+			if len(want) != len(file.Sections) {
+				t.Fatalf("section map count assertion failed, got %v, want %v",
+					len(want), len(file.Sections))
+			}
+
+			// Swap the first two section headers.
+			ntHeaderOffset := binary.LittleEndian.Uint32(data[0x3c:])
+			sizeOfOptionalHeader := binary.LittleEndian.Uint16(data[ntHeaderOffset+20:])
+			secTableOffset := ntHeaderOffset + 4 + 20 + uint32(sizeOfOptionalHeader)
+			swapped := make([]byte, len(data))
+			copy(swapped, data)
+			copy(swapped[secTableOffset:], data[secTableOffset+40:secTableOffset+80])
+			copy(swapped[secTableOffset+40:], data[secTableOffset:secTableOffset+40])
+
+			file, err = NewBytes(swapped, &Options{Fast: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", tt, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt, err)
+			}
+
+			if !stringInSlice(AnoSectionsNotSortedByVA, file.Anomalies) {
+				t.Errorf("anomaly %q not found in %v", AnoSectionsNotSortedByVA,
+					file.Anomalies)
+			}
+			if !reflect.DeepEqual(file.SectionMap(), want) {
+				t.Errorf("section map assertion failed, got %v, want %v",
+					file.SectionMap(), want)
+			}
+		})
+	}
+}
+
+// headerOnlyImage builds an image without sections out of impbyord.exe: the
+// content of its only section is moved to the same file offset as its RVA and
+// the headers are extended to cover the whole image.
+func headerOnlyImage(t *testing.T, sectionAlignment uint32) []byte {
+	filename := getAbsoluteFilePath("test/impbyord.exe")
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	data := make([]byte, 0x2000)
+	copy(data, src[:0x200])
+	copy(data[0x1000:], src[0x200:0x400])
+
+	optionalHeaderOffset := uint32(0x40 + 4 + 20)
+	binary.LittleEndian.PutUint16(data[0x40+6:], 0)
+	binary.LittleEndian.PutUint32(data[optionalHeaderOffset+32:], sectionAlignment)
+	binary.LittleEndian.PutUint32(data[optionalHeaderOffset+36:], 0x200)
+	binary.LittleEndian.PutUint32(data[optionalHeaderOffset+60:], 0x2000)
+	return data
+}
+
+func TestParseSectionHeaderHeaderOnly(t *testing.T) {
+
+	tests := []struct {
+		sectionAlignment uint32
+		anomalies        []string
+	}{
+		{0x200, []string{AnoHeaderOnlyImage, AnoLowAlignment}},
+		{0x1000, []string{AnoHeaderOnlyImage}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("SectionAlignment=0x%x", tt.sectionAlignment), func(t *testing.T) {
+			file, err := NewBytes(headerOnlyImage(t, tt.sectionAlignment), &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes() failed, reason: %v", err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse() failed, reason: %v", err)
+			}
+
+			if len(file.Sections) != 0 {
+				t.Errorf("sections count assertion failed, got %v, want 0",
+					len(file.Sections))
+			}
+			if len(file.Header) != 0x2000 {
+				t.Errorf("header size assertion failed, got 0x%x, want 0x2000",
+					len(file.Header))
+			}
+			for _, ano := range tt.anomalies {
+				if !stringInSlice(ano, file.Anomalies) {
+					t.Errorf("anomaly %q not found in %v", ano, file.Anomalies)
+				}
+			}
+			if tt.sectionAlignment >= 0x1000 &&
+				stringInSlice(AnoLowAlignment, file.Anomalies) {
+				t.Errorf("unexpected anomaly %q", AnoLowAlignment)
+			}
+
+			// Data directories are resolved from the headers.
+			if len(file.Imports) != 2 || file.Imports[0].Name != "msvcrt.dll" {
+				t.Errorf("imports assertion failed, got %v", file.Imports)
+			}
+			if len(file.Export.Functions) != 1 ||
+				file.Export.Functions[0].Ordinal != 35 {
+				t.Errorf("exports assertion failed, got %v", file.Export)
+			}
+
+			// A null length reads up to the end of the image.
+			data, err := file.GetData(0x1000, 0)
+			if err != nil || len(data) != 0x1000 {
+				t.Errorf("GetData(0x1000, 0) got %d bytes and %v, want 0x1000 bytes",
+					len(data), err)
+			}
+		})
+	}
+}
+
+func TestParseSectionHeaderBeyondFile(t *testing.T) {
+	filename := getAbsoluteFilePath("test/impbyord.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	// Cut the file in the middle of the section table.
+	data = data[:0x138+20]
+
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	for _, ano := range []string{AnoSectionTableBeyondFile, AnoHeaderOnlyImage} {
+		if !stringInSlice(ano, file.Anomalies) {
+			t.Errorf("anomaly %q not found in %v", ano, file.Anomalies)
+		}
+	}
+	if len(file.Sections) != 0 {
+		t.Errorf("sections count assertion failed, got %v, want 0",
+			len(file.Sections))
+	}
+}
+
+func TestRelocatedPEHeader(t *testing.T) {
+
+	tests := []struct {
+		elfanew     uint32
+		anomalies   []string
+		noAnomalies []string
+	}{
+		{0x40, nil, []string{AnoPEHeaderOverlapDOSHeader,
+			AnoPEHeaderBeyondSizeOfHeaders, AnoPEHeaderOverlapSection,
+			AnoPEHeaderInOverlay}},
+		// The e_lfanew field overlaps BaseOfCode.
+		{0x10, []string{AnoPEHeaderOverlapDOSHeader},
+			[]string{AnoPEHeaderBeyondSizeOfHeaders}},
+		{0x200, []string{AnoPEHeaderBeyondSizeOfHeaders,
+			AnoPEHeaderOverlapSection},
+			[]string{AnoPEHeaderInOverlay}},
+		{0x400, []string{AnoPEHeaderBeyondSizeOfHeaders,
+			AnoPEHeaderInOverlay},
+			[]string{AnoPEHeaderOverlapSection}},
+	}
+
+	filename := getAbsoluteFilePath("test/impbyord.exe")
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("e_lfanew=0x%x", tt.elfanew), func(t *testing.T) {
+			// Move the NT headers and the section table of impbyord.exe,
+			// found at 0x40 up to 0x160, to e_lfanew.
+			data := make([]byte, len(src))
+			copy(data, src[:0x40])
+			copy(data[0x200:], src[0x200:])
+			if int(tt.elfanew)+0x120 > len(data) {
+				data = append(data, make([]byte, int(tt.elfanew)+0x120-len(data))...)
+			}
+			copy(data[tt.elfanew:], src[0x40:0x160])
+			binary.LittleEndian.PutUint32(data[0x3c:], tt.elfanew)
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			if len(file.Sections) != 1 {
+				t.Errorf("sections count assertion failed, got %v, want 1",
+					len(file.Sections))
+			}
+			// Only the bytes preceding the section are mapped as headers.
+			if len(file.Header) != 0x200 {
+				t.Errorf("header size assertion failed, got 0x%x, want 0x200",
+					len(file.Header))
+			}
+			// Headers appended after the last section are not an overlay.
+			if file.HasOverlay {
+				t.Errorf("overlay assertion failed, got %v, want false",
+					file.HasOverlay)
+			}
+			for _, ano := range tt.anomalies {
+				if !stringInSlice(ano, file.Anomalies) {
+					t.Errorf("anomaly %q not found in %v", ano, file.Anomalies)
+				}
+			}
+			for _, ano := range tt.noAnomalies {
+				if stringInSlice(ano, file.Anomalies) {
+					t.Errorf("unexpected anomaly %q", ano)
+				}
+			}
+		})
+	}
+}
+
+func TestSectionLongName(t *testing.T) {
+
+	tests := []struct {
+		rawName string
+		name    string
+	}{
+		{"/4", ".debug_info"},
+		{"//AAAAAE", ".debug_info"},
+		{"/16", ".debug_abbrev"},
+		{"/999", "/999"},
+		{"/4x", "/4x"},
+		{".text", ".text"},
+	}
+
+	filename := getAbsoluteFilePath("test/impbyord.exe")
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rawName, func(t *testing.T) {
+			// Append a symbol table with a single null symbol followed by
+			// the string table, and rename the only section.
+			strTable := []byte("\x00\x00\x00\x00.debug_info\x00.debug_abbrev\x00")
+			binary.LittleEndian.PutUint32(strTable, uint32(len(strTable)))
+			data := append(append([]byte{}, src...), make([]byte, 18)...)
+			data = append(data, strTable...)
+			binary.LittleEndian.PutUint32(data[0x40+12:], uint32(len(src)))
+			binary.LittleEndian.PutUint32(data[0x40+16:], 1)
+			var name [8]byte
+			copy(name[:], tt.rawName)
+			copy(data[0x138:], name[:])
+
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			section := file.Sections[0]
+			if section.String() != tt.name || section.Name != tt.name {
+				t.Errorf("section name assertion failed, got %v, want %v",
+					section.String(), tt.name)
+			}
+			if section.RawName() != tt.rawName {
+				t.Errorf("section raw name assertion failed, got %v, want %v",
+					section.RawName(), tt.rawName)
+			}
+		})
+	}
+}
+
+func TestSectionPermissions(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		perms []string
+		out   MemoryPermissions
+	}{
+		{
+			in:    getAbsoluteFilePath("test/impbyord.exe"),
+			perms: []string{"-wx"},
+			out: MemoryPermissions{
+				WX: []MemoryRegion{
+					{
+						VirtualAddress: 0x1000,
+						VirtualSize:    0x1000,
+						Permissions:    SectionPermissions{Write: true, Execute: true},
+					},
+				},
+			},
+		},
+		{
+			in: getAbsoluteFilePath("test/amdxata.sys"),
+			perms: []string{"r-x", "r--", "rw-", "r--", "r--", "r-x", "r-x", "r--",
+				"r--", "r--"},
+			out: MemoryPermissions{
+				NotPaged: []MemoryRegion{
+					{".text", 0x1000, 0xd81, SectionPermissions{true, false, true}},
+					{".rdata", 0x2000, 0x3cc, SectionPermissions{true, false, false}},
+					{".data", 0x3000, 0x150, SectionPermissions{true, true, false}},
+					{".pdata", 0x4000, 0x18c, SectionPermissions{true, false, false}},
+					{".idata", 0x5000, 0x6da, SectionPermissions{true, false, false}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			ops := Options{Fast: true}
+			file, err := New(tt.in, &ops)
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			defer file.Close()
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			var perms []string
+			for _, section := range file.Sections {
+				perms = append(perms, section.Permissions().String())
+			}
+			if !reflect.DeepEqual(perms, tt.perms) {
+				t.Fatalf("section permissions assertion failed, got %v, want %v",
+					perms, tt.perms)
+			}
+
+			got := file.MemoryPermissions()
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Fatalf("memory permissions assertion failed, got %v, want %v",
+					got, tt.out)
+			}
+		})
+	}
+}
+
+func TestGetSectionByRVAAndOffset(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	file, err := New(filename, &Options{})
+	if err != nil {
+		t.Fatalf("New(%s) failed, reason: %v", filename, err)
+	}
+	defer file.Close()
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	rvaTests := []struct {
+		rva     uint32
+		section string
+		offset  uint32
+		err     error
+	}{
+		{0x1000, ".text", 0x400, nil},
+		{0x9e010, ".rdata", 0x9ce10, nil},
+		// .data raw data is 0xc00 bytes long, its virtual size 0x6198.
+		{0xcbc00, ".data", 0, ErrRVANotInFile},
+		// The gap between the end of .text and .rdata.
+		{0x9da00, "", 0, ErrRVANotInSection},
+		// The headers.
+		{0x200, "", 0, ErrRVANotInSection},
+		// Beyond the last section.
+		{0x127400, "", 0, ErrRVANotInSection},
+	}
+	for _, tt := range rvaTests {
+		section, offset, err := file.GetSectionByRVA(tt.rva)
+		if err != tt.err {
+			t.Errorf("GetSectionByRVA(0x%x) error assertion failed, got %v, want %v",
+				tt.rva, err, tt.err)
+		}
+		name := ""
+		if section != nil {
+			name = section.String()
+		}
+		if name != tt.section || offset != tt.offset {
+			t.Errorf("GetSectionByRVA(0x%x) got (%q, 0x%x), want (%q, 0x%x)",
+				tt.rva, name, offset, tt.section, tt.offset)
+		}
+	}
+
+	offsetTests := []struct {
+		offset  uint32
+		section string
+		rva     uint32
+		err     error
+	}{
+		{0x400, ".text", 0x1000, nil},
+		{0xc9010, ".data", 0xcb010, nil},
+		{0x11c000 - 1, ".reloc", 0x126000 + 0x13ff, nil},
+		// The headers.
+		{0x200, "", 0, ErrOffsetNotInSection},
+		// The certificate table, in the overlay.
+		{0x11c000, "", 0, ErrOffsetNotInSection},
+	}
+	for _, tt := range offsetTests {
+		section, rva, err := file.GetSectionByOffset(tt.offset)
+		if err != tt.err {
+			t.Errorf("GetSectionByOffset(0x%x) error assertion failed, got %v, want %v",
+				tt.offset, err, tt.err)
+		}
+		name := ""
+		if section != nil {
+			name = section.String()
+		}
+		if name != tt.section || rva != tt.rva {
+			t.Errorf("GetSectionByOffset(0x%x) got (%q, 0x%x), want (%q, 0x%x)",
+				tt.offset, name, rva, tt.section, tt.rva)
+		}
+	}
+}
+
+// lowAlignmentImage builds an image in low alignment mode out of
+// impbyord.exe: its only section is moved at RVA and offset 0x1200 and its
+// data directories are cleared.
+func lowAlignmentImage(t *testing.T, sectionAlignment, fileAlignment uint32) []byte {
+	filename := getAbsoluteFilePath("test/impbyord.exe")
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	data := make([]byte, 0x1400)
+	copy(data, src[:0x200])
+	copy(data[0x1200:], "MARK")
+	copy(data[0x13fc:], "END!")
+
+	optionalHeaderOffset := uint32(0x40 + 4 + 20)
+	binary.LittleEndian.PutUint32(data[optionalHeaderOffset+32:], sectionAlignment)
+	binary.LittleEndian.PutUint32(data[optionalHeaderOffset+36:], fileAlignment)
+	binary.LittleEndian.PutUint32(data[optionalHeaderOffset+56:], 0x1400)
+	for i := optionalHeaderOffset + 96; i < 0x138; i++ {
+		data[i] = 0
+	}
+
+	sectionHeaderOffset := uint32(0x138)
+	binary.LittleEndian.PutUint32(data[sectionHeaderOffset+8:], 0x200)
+	binary.LittleEndian.PutUint32(data[sectionHeaderOffset+12:], 0x1200)
+	binary.LittleEndian.PutUint32(data[sectionHeaderOffset+16:], 0x200)
+	binary.LittleEndian.PutUint32(data[sectionHeaderOffset+20:], 0x1200)
+	return data
+}
+
+func TestLowAlignment(t *testing.T) {
+
+	tests := []struct {
+		sectionAlignment uint32
+		fileAlignment    uint32
+	}{
+		{0x200, 0x200},
+		// SectionAlignment lower than FileAlignment, the section address
+		// must not be aligned to FileAlignment.
+		{0x200, 0x1000},
+		{0x20, 0x200},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("SectionAlignment=0x%x,FileAlignment=0x%x",
+			tt.sectionAlignment, tt.fileAlignment), func(t *testing.T) {
+			data := lowAlignmentImage(t, tt.sectionAlignment, tt.fileAlignment)
+			file, err := NewBytes(data, &Options{})
+			if err != nil {
+				t.Fatalf("NewBytes() failed, reason: %v", err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse() failed, reason: %v", err)
+			}
+
+			if !stringInSlice(AnoLowAlignment, file.Anomalies) {
+				t.Errorf("anomaly %q not found in %v", AnoLowAlignment,
+					file.Anomalies)
+			}
+
+			for _, rva := range []uint32{0x100, 0x1000, 0x1200, 0x13fc} {
+				if offset := file.GetOffsetFromRva(rva); offset != rva {
+					t.Errorf("GetOffsetFromRva(0x%x) got 0x%x, want 0x%x",
+						rva, offset, rva)
+				}
+				if got := file.GetRVAFromOffset(rva); got != rva {
+					t.Errorf("GetRVAFromOffset(0x%x) got 0x%x, want 0x%x",
+						rva, got, rva)
+				}
+			}
+			if offset := file.GetOffsetFromRva(0x1400); offset != ^uint32(0) {
+				t.Errorf("GetOffsetFromRva(0x1400) got 0x%x, want 0x%x",
+					offset, ^uint32(0))
+			}
+
+			section, offset, err := file.GetSectionByRVA(0x1210)
+			if err != nil || section == nil || offset != 0x1210 {
+				t.Errorf("GetSectionByRVA(0x1210) got (%v, 0x%x, %v), want offset 0x1210",
+					section, offset, err)
+			}
+			_, rva, err := file.GetSectionByOffset(0x1210)
+			if err != nil || rva != 0x1210 {
+				t.Errorf("GetSectionByOffset(0x1210) got (0x%x, %v), want 0x1210",
+					rva, err)
+			}
+
+			for rva, want := range map[uint32]string{
+				0x1000: "\x00\x00\x00\x00",
+				0x1200: "MARK",
+				0x13fc: "END!",
+			} {
+				got, err := file.GetData(rva, 4)
+				if err != nil || string(got) != want {
+					t.Errorf("GetData(0x%x, 4) got (%q, %v), want %q",
+						rva, got, err, want)
+				}
+			}
+		})
+	}
+}
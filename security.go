@@ -45,17 +45,24 @@ const (
 // (but are not limited to) the items in the following table. Note that some
 // values are not currently supported.
 const (
-	// Certificate contains an X.509 Certificate (Not Supported)
-	WinCertTypeX509 = 0x0001
+	// Certificate contains an X.509 Certificate, the certificate is parsed
+	// but there is no signature to verify.
+	WinCertTypeX509 WinCertType = 0x0001
 
 	// Certificate contains a PKCS#7 SignedData structure.
-	WinCertTypePKCSSignedData = 0x0002
+	WinCertTypePKCSSignedData WinCertType = 0x0002
 
 	// Reserved.
-	WinCertTypeReserved1 = 0x0003
+	WinCertTypeReserved1 WinCertType = 0x0003
 
 	// Terminal Server Protocol Stack Certificate signing (Not Supported).
-	WinCertTypeTSStackSigned = 0x0004
+	WinCertTypeTSStackSigned WinCertType = 0x0004
+)
+
+const (
+	// AnoUnsupportedCertificateType is reported when the certificate type is
+	// not one the parser decodes, its content is only exposed raw.
+	AnoUnsupportedCertificateType = "Unsupported certificate type in security directory"
 )
 
 var (
@@ -63,6 +70,36 @@ var (
 	// header in the security directory is invalid.
 	ErrSecurityDataDirInvalid = errors.New(
 		`invalid certificate header in security directory`)
+
+	// ErrSecurityDataDirTooLarge is reported when the certificate in the
+	// security directory is larger than Options.MaxCertificateSize.
+	ErrSecurityDataDirTooLarge = errors.New(
+		`certificate in security directory exceeds the maximum size`)
+
+	// ErrASN1Limits is reported when the ASN.1 encoding of a certificate is
+	// malformed or too complex to be handed to the ASN.1 decoders, such as
+	// a too deep nesting or a huge object identifier.
+	ErrASN1Limits = errors.New(`ASN.1 encoding exceeds the parser limits`)
+)
+
+const (
+	// MaxDefaultCertificateSize represents the default maximum size of the
+	// certificate in the security directory.
+	MaxDefaultCertificateSize = 0x1000000
+
+	// MaxDefaultASN1Depth represents the default maximum nesting depth of
+	// the ASN.1 encoding of a certificate. Nested signatures stack up
+	// about a dozen levels each.
+	MaxDefaultASN1Depth = 64
+
+	// maxASN1OIDLength is the maximum encoded length of an object
+	// identifier, the longest ones found in certificates are a few dozens
+	// of bytes.
+	maxASN1OIDLength = 128
+
+	// maxNestedSignatures is the maximum number of nested signatures which
+	// are verified.
+	maxNestedSignatures = 32
 )
 
 type CertificateSection struct {
@@ -79,6 +116,7 @@ type Certificate struct {
 	SignatureValid   bool                `json:"signature_valid"`
 	Info             CertInfo            `json:"info"`
 	Verified         bool                `json:"verified"`
+	Revocation       RevocationStatus    `json:"revocation"`
 }
 
 // WinCertificate encapsulates a signature used in verifying executable files.
@@ -90,7 +128,25 @@ type WinCertificate struct {
 	Revision uint16 `json:"revision"`
 
 	// Specifies the type of certificate.
-	CertificateType uint16 `json:"certificate_type"`
+	CertificateType WinCertType `json:"certificate_type"`
+}
+
+// WinCertType represents the type of a WIN_CERTIFICATE.
+type WinCertType uint16
+
+// String stringify the certificate type.
+func (t WinCertType) String() string {
+	certTypeMap := map[WinCertType]string{
+		WinCertTypeX509:           "X.509",
+		WinCertTypePKCSSignedData: "PKCS#7 SignedData",
+		WinCertTypeReserved1:      "Reserved",
+		WinCertTypeTSStackSigned:  "Terminal Server Protocol Stack Signed",
+	}
+
+	if name, ok := certTypeMap[t]; ok {
+		return name
+	}
+	return "?"
 }
 
 // CertInfo wraps the important fields of the pkcs7 structure.
@@ -141,8 +197,8 @@ func (s byStart) Less(i, j int) bool {
 }
 
 type Range struct {
-	Start uint32
-	End   uint32
+	Start uint64
+	End   uint64
 }
 
 func (pe *File) parseLocations() (map[string]*RelRange, error) {
@@ -158,16 +214,20 @@ func (pe *File) parseLocations() (map[string]*RelRange, error) {
 		optionalHeaderSize uint32
 	)
 
+	var ok bool
 	switch pe.Is64 {
 	case true:
-		oh64 = pe.NtHeader.OptionalHeader.(ImageOptionalHeader64)
+		oh64, ok = pe.NtHeader.OptionalHeader.(ImageOptionalHeader64)
 		optionalHeaderSize = oh64.SizeOfHeaders
 	case false:
-		oh32 = pe.NtHeader.OptionalHeader.(ImageOptionalHeader32)
+		oh32, ok = pe.NtHeader.OptionalHeader.(ImageOptionalHeader32)
 		optionalHeaderSize = oh32.SizeOfHeaders
 	}
+	if !ok {
+		return nil, ErrImageNtSignatureNotFound
+	}
 
-	if optionalHeaderSize > pe.size-optionalHeaderOffset {
+	if optionalHeaderSize > pe.remaining(optionalHeaderOffset) {
 		msgF := "the optional header exceeds the file length (%d + %d > %d)"
 		return nil, fmt.Errorf(msgF, optionalHeaderSize, optionalHeaderOffset, pe.size)
 	}
@@ -276,10 +336,10 @@ func (pe *File) AuthentihashExt(hashers ...hash.Hash) [][]byte {
 	sort.Sort(byStart(locationSlice))
 
 	ranges := make([]*Range, 0, len(locationSlice))
-	start := uint32(0)
+	start := uint64(0)
 	for _, r := range locationSlice {
-		ranges = append(ranges, &Range{Start: start, End: r.Start})
-		start = r.Start + r.Length
+		ranges = append(ranges, &Range{Start: start, End: uint64(r.Start)})
+		start = uint64(r.Start) + uint64(r.Length)
 	}
 	ranges = append(ranges, &Range{Start: start, End: pe.size})
 
@@ -331,77 +391,67 @@ func (pe *File) parseSecurityDirectory(rva, size uint32) error {
 		return ErrOutsideBoundary
 	}
 
-	if fileOffset+certHeader.Length > pe.size {
-		return ErrOutsideBoundary
+	if err := pe.checkRange(fileOffset, certHeader.Length); err != nil {
+		return err
 	}
 
-	if certHeader.Length == 0 {
+	// The length includes the header.
+	if certHeader.Length < certSize {
 		return ErrSecurityDataDirInvalid
 	}
 
+	if certHeader.Length > pe.opts.MaxCertificateSize {
+		return ErrSecurityDataDirTooLarge
+	}
+
 	pe.HasCertificate = true
 	pe.Certificates.Header = certHeader
-	pe.Certificates.Raw = pe.data[fileOffset+certSize : fileOffset+certHeader.Length]
+	certStart := uint64(fileOffset) + uint64(certSize)
+	certEnd := uint64(fileOffset) + uint64(certHeader.Length)
+	pe.Certificates.Raw = pe.data[certStart:certEnd]
+	pe.markCoverage(fileOffset+certSize, certHeader.Length-certSize)
 
+	// The ASN.1 decoders are only handed an encoding which is within the
+	// limits, the nested signatures are checked along.
 	certContent := pe.Certificates.Raw
-	for {
+	if err := checkASN1(certContent, pe.opts.MaxASN1Depth); err != nil {
+		return err
+	}
+
+	switch certHeader.CertificateType {
+	case WinCertTypePKCSSignedData:
+	case WinCertTypeX509:
+		return pe.parseX509Certificate(certContent)
+	default:
+		// The content of the other types is not documented.
+		pe.addAnomaly(AnoUnsupportedCertificateType)
+		return nil
+	}
+
+	for nested := 0; nested < maxNestedSignatures; nested++ {
 		pkcs, err := pkcs7.Parse(certContent)
 		if err != nil {
 			return err
 		}
-		// The pkcs7.PKCS7 structure contains many fields that we are not
-		// interested to, so create another structure, similar to _CERT_INFO
-		// structure which contains only the important information.
-		var signerCertificate = pkcs.GetOnlySigner()
-		if signerCertificate == nil {
-			return errors.New("could not find signer certificate")
+		certInfo, certValid, revocation, err := pe.verifySigner(pkcs)
+		if err != nil {
+			return err
 		}
 
-		var certInfo CertInfo
-
-		certInfo.SerialNumber = hex.EncodeToString(signerCertificate.SerialNumber.Bytes())
-		certInfo.PublicKeyAlgorithm = signerCertificate.PublicKeyAlgorithm
-
-		certInfo.NotAfter = signerCertificate.NotAfter
-		certInfo.NotBefore = signerCertificate.NotBefore
-
-		// Issuer infos
-		certInfo.Issuer = formatPkixName(signerCertificate.Issuer)
-
-		// Subject infos
-		certInfo.Subject = formatPkixName(signerCertificate.Subject)
-
-		// Let's mark the file as signed, then we verify if the signature is valid.
+		// Let's mark the file as signed, the signature content is verified
+		// below.
 		pe.IsSigned = true
 
-		var certValid bool
-		// Let's load the system root certs.
-		if !pe.opts.DisableCertValidation {
-			var certPool *x509.CertPool
-			if runtime.GOOS == "windows" {
-				certPool, err = loadSystemRoots()
-			} else {
-				certPool, err = x509.SystemCertPool()
-			}
-
-			// Verify the signature. This will also verify the chain of trust of the
-			// the end-entity signer cert to one of the root in the trust store.
-			if err != nil {
-				pe.logger.Errorf("failed to loadSystemRoots: %v", err)
-			} else {
-				err = pkcs.VerifyWithChain(certPool)
-				if err == nil {
-					certValid = true
-				} else {
-					certValid = false
-				}
-			}
-		}
-
+		// The signed content is itself an ASN.1 encoding wrapped in an
+		// octet string, which the check above does not descend into.
 		var signatureValid bool
-		signatureContent, err = parseAuthenticodeContent(pkcs.Content)
+		err = checkASN1(pkcs.Content, pe.opts.MaxASN1Depth)
+		if err == nil {
+			signatureContent, err = parseAuthenticodeContent(pkcs.Content)
+		}
 		if err != nil {
-			pe.logger.Errorf("could not parse authenticode content: %v", err)
+			pe.errorf(ImageDirectoryEntryCertificate.String(), 0,
+				"could not parse authenticode content: %v", err)
 			signatureValid = false
 		} else if !pe.opts.DisableSignatureValidation {
 			authentihash := pe.AuthentihashExt(signatureContent.HashFunction.New())[0]
@@ -416,6 +466,7 @@ func (pe *File) parseSecurityDirectory(rva, size uint32) error {
 			SignatureValid:   signatureValid,
 			Info:             certInfo,
 			Verified:         certValid,
+			Revocation:       revocation,
 		})
 
 		// Subsequent certificates are an (unsigned) attribute of the PKCS#7
@@ -435,6 +486,125 @@ func (pe *File) parseSecurityDirectory(rva, size uint32) error {
 	return nil
 }
 
+// verifySigner builds the CertInfo of the signer of a PKCS#7 signature and,
+// unless disabled by the options, verifies the signature along with the
+// chain of trust and the revocation status of the signer.
+func (pe *File) verifySigner(pkcs *pkcs7.PKCS7) (CertInfo, bool,
+	RevocationStatus, error) {
+
+	// The pkcs7.PKCS7 structure contains many fields that we are not
+	// interested to, so create another structure, similar to _CERT_INFO
+	// structure which contains only the important information.
+	var signerCertificate = pkcs.GetOnlySigner()
+	if signerCertificate == nil {
+		return CertInfo{}, false, RevocationNotChecked,
+			errors.New("could not find signer certificate")
+	}
+
+	var certInfo CertInfo
+
+	certInfo.SerialNumber = hex.EncodeToString(signerCertificate.SerialNumber.Bytes())
+	certInfo.PublicKeyAlgorithm = signerCertificate.PublicKeyAlgorithm
+
+	certInfo.NotAfter = signerCertificate.NotAfter
+	certInfo.NotBefore = signerCertificate.NotBefore
+
+	// Issuer infos
+	certInfo.Issuer = formatPkixName(signerCertificate.Issuer)
+
+	// Subject infos
+	certInfo.Subject = formatPkixName(signerCertificate.Subject)
+
+	var err error
+	var certValid bool
+	revocation := RevocationNotChecked
+	if !pe.opts.DisableCertValidation {
+		var certPool *x509.CertPool
+		if !pe.opts.DisableCertChainValidation {
+			// Let's load the system root certs.
+			if runtime.GOOS == "windows" {
+				certPool, err = loadSystemRoots()
+			} else {
+				certPool, err = x509.SystemCertPool()
+			}
+		}
+
+		// Verify the signature. This will also verify the chain of trust of the
+		// the end-entity signer cert to one of the root in the trust store,
+		// unless chain validation is disabled.
+		validationTime := pe.opts.CertValidationTime
+		if err != nil {
+			pe.errorf(ImageDirectoryEntryCertificate.String(), 0,
+				"failed to loadSystemRoots: %v", err)
+		} else {
+			if validationTime.IsZero() {
+				err = pkcs.VerifyWithChain(certPool)
+			} else {
+				err = pkcs.VerifyWithChainAtTime(certPool, validationTime)
+			}
+			certValid = err == nil
+		}
+
+		if certValid && pe.opts.CertRevocationCheck {
+			// The chain is evaluated at the signing time like
+			// VerifyWithChain does, when no time is given.
+			chainTime := validationTime
+			if chainTime.IsZero() {
+				chainTime = signingTime(pkcs)
+			}
+			chain, err := buildCertChain(signerCertificate,
+				pkcs.Certificates, certPool, chainTime)
+			if err != nil {
+				revocation = RevocationUnknown
+			} else {
+				revocation = pe.checkRevocation(chain, chainTime)
+			}
+			if revocation == RevocationRevoked {
+				certValid = false
+			}
+		}
+	}
+
+	return certInfo, certValid, revocation, nil
+}
+
+// parseX509Certificate parses the content of a WIN_CERT_TYPE_X509 entry,
+// a bare certificate which is exposed as the only certificate of an empty
+// PKCS#7 structure. It signs nothing, thus the file is not marked as signed.
+func (pe *File) parseX509Certificate(content []byte) error {
+	cert, err := x509.ParseCertificate(content)
+	if err != nil {
+		return err
+	}
+
+	certInfo := CertInfo{
+		Issuer:             formatPkixName(cert.Issuer),
+		Subject:            formatPkixName(cert.Subject),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		SerialNumber:       hex.EncodeToString(cert.SerialNumber.Bytes()),
+		SignatureAlgorithm: cert.SignatureAlgorithm,
+		PublicKeyAlgorithm: cert.PublicKeyAlgorithm,
+	}
+
+	pe.Certificates.Certificates = append(pe.Certificates.Certificates, Certificate{
+		Content:    pkcs7.PKCS7{Certificates: []*x509.Certificate{cert}},
+		Info:       certInfo,
+		Revocation: RevocationNotChecked,
+	})
+	return nil
+}
+
+// signingTime returns the signing time authenticated attribute of the
+// signature, or the current time when it is missing.
+func signingTime(pkcs *pkcs7.PKCS7) time.Time {
+	var t time.Time
+	if err := pkcs.UnmarshalSignedAttribute(pkcs7.OIDAttributeSigningTime, &t); err != nil {
+		return time.Now().UTC()
+	}
+	return t
+}
+
 // loadSystemsRoots manually downloads all the trusted root certificates
 // in Windows by spawning certutil then adding root certs individually
 // to the cert pool. Initially, when running in windows, go SystemCertPool()
@@ -576,6 +746,106 @@ func parseAuthenticodeContent(content []byte) (AuthenticodeContent, error) {
 	}, nil
 }
 
+// checkASN1 walks the BER encoded elements of data without decoding them and
+// reports an ErrASN1Limits when an element does not fit in its parent, is
+// nested deeper than maxDepth or is an object identifier longer than
+// maxASN1OIDLength. Trailing data after the first element is ignored like
+// the decoders do.
+func checkASN1(data []byte, maxDepth uint32) error {
+	_, err := checkASN1Element(data, 0, maxDepth)
+	return err
+}
+
+// checkASN1Element checks the element at the start of data and returns its
+// encoded length.
+func checkASN1Element(data []byte, depth, maxDepth uint32) (int, error) {
+	if depth >= maxDepth {
+		return 0, fmt.Errorf("%w: nesting deeper than %d", ErrASN1Limits,
+			maxDepth)
+	}
+	if len(data) < 2 {
+		return 0, fmt.Errorf("%w: truncated element", ErrASN1Limits)
+	}
+
+	// Identifier octets, high tag numbers span several bytes.
+	offset := 1
+	constructed := data[0]&0x20 != 0
+	tag := int(data[0] & 0x1f)
+	if tag == 0x1f {
+		tag = 0
+		for {
+			if offset >= len(data) || offset > 4 {
+				return 0, fmt.Errorf("%w: invalid tag", ErrASN1Limits)
+			}
+			b := data[offset]
+			offset++
+			tag = tag<<7 | int(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	universal := data[0]&0xc0 == 0
+
+	// Length octets, the indefinite form is only valid for constructed
+	// elements and ends with two null bytes.
+	if offset >= len(data) {
+		return 0, fmt.Errorf("%w: truncated element", ErrASN1Limits)
+	}
+	b := data[offset]
+	offset++
+	if b == 0x80 {
+		if !constructed {
+			return 0, fmt.Errorf("%w: indefinite length of a primitive element",
+				ErrASN1Limits)
+		}
+		for {
+			if len(data)-offset >= 2 && data[offset] == 0 && data[offset+1] == 0 {
+				return offset + 2, nil
+			}
+			n, err := checkASN1Element(data[offset:], depth+1, maxDepth)
+			if err != nil {
+				return 0, err
+			}
+			offset += n
+		}
+	}
+
+	length := int(b)
+	if b&0x80 != 0 {
+		numBytes := int(b & 0x7f)
+		if numBytes > 4 || len(data)-offset < numBytes {
+			return 0, fmt.Errorf("%w: invalid length", ErrASN1Limits)
+		}
+		length = 0
+		for _, b := range data[offset : offset+numBytes] {
+			length = length<<8 | int(b)
+		}
+		offset += numBytes
+	}
+	if length < 0 || length > len(data)-offset {
+		return 0, fmt.Errorf("%w: element larger than its parent", ErrASN1Limits)
+	}
+
+	// Object identifiers and relative object identifiers.
+	if universal && (tag == 6 || tag == 13) && length > maxASN1OIDLength {
+		return 0, fmt.Errorf("%w: object identifier of %d bytes",
+			ErrASN1Limits, length)
+	}
+
+	if constructed {
+		content := data[offset : offset+length]
+		for len(content) > 0 {
+			n, err := checkASN1Element(content, depth+1, maxDepth)
+			if err != nil {
+				return 0, err
+			}
+			content = content[n:]
+		}
+	}
+	return offset + length, nil
+}
+
 func formatPkixName(name pkix.Name) string {
 	var formattedName string
 	if len(name.Country) > 0 {
@@ -0,0 +1,57 @@
+//go:build go1.18
+// +build go1.18
+
+// Copyright 2018 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzSecurityDirectory mutates the WIN_CERTIFICATE entry of a signed file,
+// run it with: go test -fuzz=FuzzSecurityDirectory
+func FuzzSecurityDirectory(f *testing.F) {
+	filename := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		f.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+	file, err := NewBytes(data, &Options{Fast: true})
+	if err != nil {
+		f.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	if err := file.Parse(); err != nil {
+		f.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	dirEntry := file.dataDirectory(ImageDirectoryEntryCertificate)
+	offset, size := dirEntry.VirtualAddress, dirEntry.Size
+
+	f.Add(data[offset : offset+size])
+	f.Add([]byte{0x10, 0, 0, 0, 0, 2, 2, 0, 0x30, 0x80, 0x30, 0x80, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, cert []byte) {
+		if len(cert) > int(size) {
+			cert = cert[:size]
+		}
+		mutated := make([]byte, len(data))
+		copy(mutated, data)
+		copy(mutated[offset:], cert)
+		binary.LittleEndian.PutUint32(mutated[offset:], uint32(len(cert)))
+
+		// The headers are needed to compute the authentihash.
+		file, err := NewBytes(mutated, &Options{Fast: true,
+			DisableCertValidation: true})
+		if err != nil {
+			t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+		}
+		if err := file.Parse(); err != nil {
+			t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+		}
+		file.parseSecurityDirectory(offset, size)
+	})
+}
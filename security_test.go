@@ -5,8 +5,21 @@
 package pe
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -249,3 +262,354 @@ func TestAuthentihash(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckRevocation(t *testing.T) {
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate,
+		&caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	type revocationServer struct {
+		ocspStatus int // 0: good, 1: revoked, other: responder failure
+		crlRevoked bool
+		revokedAt  time.Time
+	}
+	var srv revocationServer
+	var leaf *x509.Certificate
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ocsp":
+			if srv.ocspStatus > 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			var req ocspRequest
+			if _, err := asn1.Unmarshal(body, &req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			single := ocspSingleResponse{
+				CertID:     req.TBSRequest.RequestList[0].Cert,
+				ThisUpdate: time.Now().UTC().Truncate(time.Second),
+			}
+			if srv.ocspStatus == 1 {
+				single.Revoked.RevocationTime = srv.revokedAt
+			} else {
+				single.Good = true
+			}
+			keyHash, _ := asn1.Marshal([]byte{0x01})
+			tbs, _ := asn1.Marshal(ocspResponseData{
+				RawResponderID: asn1.RawValue{Class: 2, Tag: 2, IsCompound: true, Bytes: keyHash},
+				ProducedAt:     time.Now().UTC().Truncate(time.Second),
+				Responses:      []ocspSingleResponse{single},
+			})
+			digest := sha256.Sum256(tbs)
+			signature, _ := ecdsa.SignASN1(rand.Reader, caKey, digest[:])
+			basic, _ := asn1.Marshal(ocspBasicResponse{
+				TBSResponseData: asn1.RawValue{FullBytes: tbs},
+				SignatureAlgorithm: pkix.AlgorithmIdentifier{
+					Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2},
+				},
+				Signature: asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+			})
+			resp, _ := asn1.Marshal(ocspResponse{
+				Response: ocspResponseBytes{
+					ResponseType: oidOCSPBasicResponse,
+					Response:     basic,
+				},
+			})
+			w.Write(resp)
+		case "/crl":
+			var revoked []pkix.RevokedCertificate
+			if srv.crlRevoked {
+				revoked = append(revoked, pkix.RevokedCertificate{
+					SerialNumber:   leaf.SerialNumber,
+					RevocationTime: srv.revokedAt,
+				})
+			}
+			crl, _ := ca.CreateCRL(rand.Reader, caKey, revoked, time.Now(),
+				time.Now().Add(time.Hour))
+			w.Write(crl)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(0x1234),
+		Subject:               pkix.Name{CommonName: "Test Code Signing"},
+		NotBefore:             time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		OCSPServer:            []string{server.URL + "/ocsp"},
+		CRLDistributionPoints: []string{server.URL + "/crl"},
+	}
+	leafDER, _ := x509.CreateCertificate(rand.Reader, leafTemplate, ca,
+		&leafKey.PublicKey, caKey)
+	leaf, _ = x509.ParseCertificate(leafDER)
+
+	revokedAt := time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		server revocationServer
+		at     time.Time
+		out    RevocationStatus
+	}{
+		{"OCSPGood", revocationServer{ocspStatus: 0}, time.Time{}, RevocationGood},
+		{"OCSPRevoked", revocationServer{ocspStatus: 1, revokedAt: revokedAt},
+			time.Time{}, RevocationRevoked},
+		{"OCSPRevokedAfterValidationTime", revocationServer{ocspStatus: 1, revokedAt: revokedAt},
+			time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC), RevocationGood},
+		{"CRLGood", revocationServer{ocspStatus: 2}, time.Time{}, RevocationGood},
+		{"CRLRevoked", revocationServer{ocspStatus: 2, crlRevoked: true, revokedAt: revokedAt},
+			time.Time{}, RevocationRevoked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv = tt.server
+			file, err := NewBytes([]byte{0x4d, 0x5a}, &Options{HTTPClient: server.Client()})
+			if err != nil {
+				t.Fatalf("NewBytes() failed, reason: %v", err)
+			}
+
+			got := file.checkRevocation([]*x509.Certificate{leaf, ca}, tt.at)
+			if got != tt.out {
+				t.Errorf("revocation status assertion failed, got %v, want %v",
+					got, tt.out)
+			}
+		})
+	}
+
+	t.Run("Unreachable", func(t *testing.T) {
+		unreachable := *leaf
+		unreachable.OCSPServer = []string{server.URL + "/missing"}
+		unreachable.CRLDistributionPoints = nil
+		file, err := NewBytes([]byte{0x4d, 0x5a}, &Options{HTTPClient: server.Client()})
+		if err != nil {
+			t.Fatalf("NewBytes() failed, reason: %v", err)
+		}
+
+		got := file.checkRevocation([]*x509.Certificate{&unreachable, ca}, time.Time{})
+		if got != RevocationUnknown {
+			t.Errorf("revocation status assertion failed, got %v, want %v",
+				got, RevocationUnknown)
+		}
+	})
+}
+
+func TestCertValidationOptions(t *testing.T) {
+
+	tests := []struct {
+		name string
+		opts Options
+		out  bool
+	}{
+		{"ChainSkipped", Options{DisableCertChainValidation: true}, true},
+		{"ChainSkippedExpired", Options{DisableCertChainValidation: true,
+			CertValidationTime: time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)}, true},
+		{"ExpiredAtValidationTime", Options{
+			CertValidationTime: time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)}, false},
+	}
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := New(filename, &tt.opts)
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			for i, cert := range file.Certificates.Certificates {
+				if cert.Verified != tt.out {
+					t.Errorf("certificate verification %d failed, cert %v, want %v",
+						i, cert.Verified, tt.out)
+				}
+				if cert.Revocation != RevocationNotChecked {
+					t.Errorf("certificate revocation %d failed, got %v, want %v",
+						i, cert.Revocation, RevocationNotChecked)
+				}
+			}
+		})
+	}
+}
+
+func TestCertificateTypes(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	tests := []struct {
+		certType WinCertType
+		name     string
+		signed   bool
+		subject  string
+		anomaly  bool
+	}{
+		{WinCertTypePKCSSignedData, "PKCS#7 SignedData", true,
+			"GB, Cambridgeshire, Cambridge, Simon Tatham, Simon Tatham", false},
+		{WinCertTypeX509, "X.509", false,
+			"GB, Cambridgeshire, Cambridge, Simon Tatham, Simon Tatham", false},
+		{WinCertTypeReserved1, "Reserved", false, "", true},
+		{WinCertTypeTSStackSigned, "Terminal Server Protocol Stack Signed", false, "", true},
+		{0x42, "?", false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.certType.String() != tt.name {
+				t.Errorf("certificate type name assertion failed, got %s, want %s",
+					tt.certType.String(), tt.name)
+			}
+
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+			file, err := NewBytes(data, &Options{DisableCertValidation: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			// Rewrite the WIN_CERTIFICATE header, a X.509 entry holds the
+			// DER encoding of the signer certificate.
+			headerOffset := file.dataDirectory(ImageDirectoryEntryCertificate).VirtualAddress
+			if tt.certType == WinCertTypeX509 {
+				signer := file.Certificates.Certificates[0].Content.GetOnlySigner()
+				copy(data[headerOffset+8:], signer.Raw)
+				binary.LittleEndian.PutUint32(data[headerOffset:], uint32(8+len(signer.Raw)))
+			}
+			binary.LittleEndian.PutUint16(data[headerOffset+6:], uint16(tt.certType))
+
+			file, err = NewBytes(data, &Options{DisableCertValidation: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			if file.Certificates.Header.CertificateType != tt.certType ||
+				!file.HasCertificate || len(file.Certificates.Raw) == 0 {
+				t.Fatalf("certificate header assertion failed, got %v, want type %v",
+					file.Certificates.Header, tt.certType)
+			}
+			if file.IsSigned != tt.signed {
+				t.Errorf("signed assertion failed, got %v, want %v", file.IsSigned, tt.signed)
+			}
+			var subject string
+			if len(file.Certificates.Certificates) > 0 {
+				subject = file.Certificates.Certificates[0].Info.Subject
+			}
+			if subject != tt.subject {
+				t.Errorf("certificate subject assertion failed, got %q, want %q",
+					subject, tt.subject)
+			}
+			got := stringInSlice(AnoUnsupportedCertificateType, file.Anomalies)
+			if got != tt.anomaly {
+				t.Errorf("anomaly %s assertion failed, got %v, want %v",
+					AnoUnsupportedCertificateType, got, tt.anomaly)
+			}
+		})
+	}
+}
+
+func TestCheckASN1(t *testing.T) {
+
+	nested := func(depth int) []byte {
+		data := []byte{0x05, 0x00}
+		for i := 0; i < depth; i++ {
+			data = append([]byte{0x30, byte(len(data))}, data...)
+		}
+		return data
+	}
+	oid := append([]byte{0x06, 0x81, 0x90}, bytes.Repeat([]byte{0x2a}, 0x90)...)
+
+	tests := []struct {
+		name string
+		in   []byte
+		ok   bool
+	}{
+		{"Sequence", []byte{0x30, 0x03, 0x02, 0x01, 0x01}, true},
+		{"Indefinite", []byte{0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00}, true},
+		{"HighTag", []byte{0x9f, 0x81, 0x01, 0x01, 0x00}, true},
+		{"MaxDepth", nested(10), true},
+		{"TooDeep", nested(11), false},
+		{"OIDTooLong", oid, false},
+		{"Truncated", []byte{0x30, 0x05, 0x02, 0x01}, false},
+		{"ChildOverflow", []byte{0x30, 0x03, 0x02, 0x05, 0x01}, false},
+		{"LengthTooLong", []byte{0x30, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00}, false},
+		{"IndefinitePrimitive", []byte{0x04, 0x80, 0x00, 0x00}, false},
+		{"IndefiniteNoEnd", []byte{0x30, 0x80, 0x02, 0x01, 0x01}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkASN1(tt.in, 11)
+			if (err == nil) != tt.ok {
+				t.Errorf("checkASN1(%x) got %v, want ok %v", tt.in, err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, ErrASN1Limits) {
+				t.Errorf("checkASN1(%x) got %v, want %v", tt.in, err, ErrASN1Limits)
+			}
+		})
+	}
+}
+
+func TestCertificateLimits(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		opts   Options
+		signed bool
+	}{
+		{"Default", Options{}, true},
+		{"TooDeep", Options{MaxASN1Depth: 4}, false},
+		{"TooLarge", Options{MaxCertificateSize: 0x100}, false},
+	}
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.DisableCertValidation = true
+			file, err := New(filename, &tt.opts)
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			if file.IsSigned != tt.signed {
+				t.Errorf("signed assertion failed, got %v, want %v",
+					file.IsSigned, tt.signed)
+			}
+			if !tt.signed && len(file.Certificates.Certificates) != 0 {
+				t.Errorf("certificates count assertion failed, got %d, want 0",
+					len(file.Certificates.Certificates))
+			}
+		})
+	}
+}
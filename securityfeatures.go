@@ -0,0 +1,150 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+// SecurityFeatures summarizes the exploit mitigations an image opts in to.
+// The flags spread over the optional header DllCharacteristics, the load
+// configuration GuardFlags and the Ex.DLL Characteristics debug entry are
+// joined, as a mitigation is often only effective when all of them agree.
+type SecurityFeatures struct {
+	// The image can be relocated at load time (ASLR).
+	DynamicBase bool `json:"dynamic_base"`
+
+	// The image can handle a high entropy 64-bit virtual address space.
+	HighEntropyVA bool `json:"high_entropy_va"`
+
+	// The image is compatible with data execution prevention (DEP).
+	NXCompat bool `json:"nx_compat"`
+
+	// Code integrity checks are enforced.
+	ForceIntegrity bool `json:"force_integrity"`
+
+	// The image must execute in an AppContainer.
+	AppContainer bool `json:"app_container"`
+
+	// The image does not use structured exception handling.
+	NoSEH bool `json:"no_seh"`
+
+	// The image registers its safe exception handlers in the load
+	// configuration (/SAFESEH), only meaningful for 32-bit images.
+	SafeSEH bool `json:"safe_seh"`
+
+	// The image supports Control Flow Guard: the DllCharacteristics flag is
+	// set and the code is instrumented according to the GuardFlags.
+	CFG bool `json:"cfg"`
+
+	// The image is built with eXtended Flow Guard.
+	XFG bool `json:"xfg"`
+
+	// The image is instrumented for return flow guard.
+	ReturnFlowGuard bool `json:"return_flow_guard"`
+
+	// The image lists the targets exception handlers may resume execution
+	// at, which the OS requires to unwind when the CET shadow stack is
+	// enforced.
+	EHContinuation bool `json:"eh_continuation"`
+
+	// The image is compatible with the CET shadow stack (/CETCOMPAT).
+	CETCompat bool `json:"cet_compat"`
+
+	// The image is CET compatible only in strict mode.
+	CETStrictMode bool `json:"cet_strict_mode"`
+
+	// The instruction pointer validation is relaxed for the contexts set by
+	// SetThreadContext and RtlRestoreContext.
+	CETSetContextIPValidationRelaxed bool `json:"cet_set_context_ip_validation_relaxed"`
+
+	// The CET dynamic APIs are restricted to out of process callers.
+	CETDynamicAPIsAllowInProc bool `json:"cet_dynamic_apis_allow_in_proc"`
+
+	// The image is built with CastGuard, which checks the casts between
+	// related C++ types.
+	CastGuard bool `json:"cast_guard"`
+
+	// The image calls memcpy through the guarded memcpy function pointer.
+	GuardMemcpy bool `json:"guard_memcpy"`
+
+	// The raw GuardFlags field of the load configuration.
+	GuardFlags uint32 `json:"guard_flags"`
+
+	// The raw flags of the Ex.DLL Characteristics debug entry.
+	DllCharacteristicsEx DllCharacteristicsExType `json:"dll_characteristics_ex"`
+}
+
+// DllCharacteristicsEx returns the flags of the Ex.DLL Characteristics debug
+// entry, and false when the image has none.
+func (pe *File) DllCharacteristicsEx() (DllCharacteristicsExType, bool) {
+	for _, debug := range pe.Debugs {
+		if debug.Struct.Type != ImageDebugTypeExDllCharacteristics {
+			continue
+		}
+		if flags, ok := debug.Info.(DllCharacteristicsExType); ok {
+			return flags, true
+		}
+	}
+	return 0, false
+}
+
+// SecurityFeatures returns the summary of the exploit mitigations of the
+// image. The file must be parsed before calling SecurityFeatures.
+func (pe *File) SecurityFeatures() SecurityFeatures {
+	var sf SecurityFeatures
+
+	var dllCharacteristics ImageOptionalHeaderDllCharacteristicsType
+	switch pe.Is64 {
+	case true:
+		if oh64, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader64); ok {
+			dllCharacteristics = oh64.DllCharacteristics
+		}
+	case false:
+		if oh32, ok := pe.NtHeader.OptionalHeader.(ImageOptionalHeader32); ok {
+			dllCharacteristics = oh32.DllCharacteristics
+		}
+	}
+
+	// Only the fields within the size the load configuration declares are
+	// read, the others are zero.
+	var ehContinuationCount uint64
+	switch loadCfg := pe.LoadConfig.Struct.(type) {
+	case ImageLoadConfigDirectory32:
+		sf.GuardFlags = loadCfg.GuardFlags
+		sf.SafeSEH = loadCfg.SEHandlerCount > 0
+		ehContinuationCount = uint64(loadCfg.GuardEHContinuationCount)
+	case ImageLoadConfigDirectory64:
+		sf.GuardFlags = loadCfg.GuardFlags
+		ehContinuationCount = loadCfg.GuardEHContinuationCount
+	}
+
+	sf.DynamicBase = dllCharacteristics&ImageDllCharacteristicsDynamicBase != 0
+	sf.HighEntropyVA = pe.Is64 &&
+		dllCharacteristics&ImageDllCharacteristicsHighEntropyVA != 0
+	sf.NXCompat = dllCharacteristics&ImageDllCharacteristicsNXCompact != 0
+	sf.ForceIntegrity = dllCharacteristics&ImageDllCharacteristicsForceIntegrity != 0
+	sf.AppContainer = dllCharacteristics&ImageDllCharacteristicsAppContainer != 0
+	sf.NoSEH = dllCharacteristics&ImageDllCharacteristicsNoSEH != 0
+	sf.CFG = dllCharacteristics&ImageDllCharacteristicsGuardCF != 0 &&
+		sf.GuardFlags&ImageGuardCfInstrumented != 0
+	sf.XFG = sf.CFG && sf.GuardFlags&ImageGuardXfgEnabled != 0
+	sf.ReturnFlowGuard = sf.GuardFlags&ImageGuardRfInstrumented != 0
+	sf.EHContinuation = sf.GuardFlags&ImageGuardEhContinuationTablePresent != 0 &&
+		ehContinuationCount > 0
+
+	// Compilers which emit the pointers do not always set the flags.
+	sf.CastGuard = pe.LoadConfig.CastGuard != nil ||
+		sf.GuardFlags&ImageGuardCastGuardPresent != 0
+	sf.GuardMemcpy = pe.LoadConfig.GuardMemcpy != nil ||
+		sf.GuardFlags&ImageGuardMemcpyPresent != 0
+
+	sf.DllCharacteristicsEx, _ = pe.DllCharacteristicsEx()
+	exFlags := sf.DllCharacteristicsEx
+	sf.CETCompat = exFlags&ImageDllCharacteristicsExCETCompat != 0
+	sf.CETStrictMode = exFlags&ImageDllCharacteristicsExCETCompatStrictMode != 0
+	sf.CETSetContextIPValidationRelaxed =
+		exFlags&ImageDllCharacteristicsExCETSetContextIPValidationRelaxedMode != 0
+	sf.CETDynamicAPIsAllowInProc =
+		exFlags&ImageDllCharacteristicsExCETDynamicAPIsAllowInProc != 0
+
+	return sf
+}
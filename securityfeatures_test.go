@@ -0,0 +1,106 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSecurityFeatures(t *testing.T) {
+
+	tests := []struct {
+		in  string
+		out SecurityFeatures
+	}{
+		{
+			getAbsoluteFilePath("test/kernel32.dll"),
+			SecurityFeatures{
+				DynamicBase:          true,
+				HighEntropyVA:        true,
+				NXCompat:             true,
+				CFG:                  true,
+				EHContinuation:       true,
+				CETCompat:            true,
+				GuardFlags:           0x10417500,
+				DllCharacteristicsEx: ImageDllCharacteristicsExCETCompat,
+			},
+		},
+		{
+			getAbsoluteFilePath("test/WdBoot.sys"),
+			SecurityFeatures{
+				DynamicBase:   true,
+				HighEntropyVA: true,
+				NXCompat:      true,
+				CFG:           true,
+				GuardFlags:    0x11c500,
+			},
+		},
+		{
+			getAbsoluteFilePath("test/pwsh.exe"),
+			SecurityFeatures{
+				DynamicBase:    true,
+				HighEntropyVA:  true,
+				NXCompat:       true,
+				CFG:            true,
+				EHContinuation: true,
+				CastGuard:      true,
+				GuardMemcpy:    true,
+				GuardFlags:     0x10417500,
+			},
+		},
+		{
+			getAbsoluteFilePath("test/putty.exe"),
+			SecurityFeatures{
+				DynamicBase:   true,
+				HighEntropyVA: true,
+				NXCompat:      true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			got := file.SecurityFeatures()
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("security features assertion failed, got %+v, want %+v",
+					got, tt.out)
+			}
+		})
+	}
+}
+
+func TestDllCharacteristicsExString(t *testing.T) {
+
+	tests := []struct {
+		in  DllCharacteristicsExType
+		out string
+	}{
+		{0, "?"},
+		{ImageDllCharacteristicsExCETCompat, "CET Compatible"},
+		{ImageDllCharacteristicsExCETCompat |
+			ImageDllCharacteristicsExCETDynamicAPIsAllowInProc,
+			"CET Compatible, CET Dynamic APIs Allow In Proc"},
+		{ImageDllCharacteristicsExForwardCFICompat, "Forward CFI Compatible"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			if got := tt.in.String(); got != tt.out {
+				t.Errorf("DllCharacteristicsEx string assertion failed, got %v, "+
+					"want %v", got, tt.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+// FuzzyScore scores the similarity of two chunks of data from their fuzzy
+// hashes.
+type FuzzyScore struct {
+	// Ssdeep is the ssdeep match score, from 0 for unrelated data to 100.
+	Ssdeep int `json:"ssdeep"`
+
+	// TLSH is the TLSH distance, 0 for identical data, or -1 when the TLSH
+	// hash of one of the chunks could not be computed.
+	TLSH int `json:"tlsh"`
+}
+
+// SectionSimilarity scores the similarity of the sections of two files
+// sharing the same name.
+type SectionSimilarity struct {
+	Name string `json:"name"`
+	FuzzyScore
+}
+
+// Similarity scores the similarity of two files, as a whole and section by
+// section.
+type Similarity struct {
+	File FuzzyScore `json:"file"`
+
+	// Sections are paired by name, in the order of the section table of the
+	// first file, the sections without a counterpart are left out.
+	Sections []SectionSimilarity `json:"sections"`
+}
+
+// scoreFuzzyHashes compares the fuzzy hashes of two chunks of data.
+func scoreFuzzyHashes(a, b FuzzyHashes) FuzzyScore {
+	score := FuzzyScore{TLSH: -1}
+	score.Ssdeep, _ = SsdeepCompare(a.Ssdeep, b.Ssdeep)
+	if a.TLSH != "" && b.TLSH != "" {
+		if diff, err := TLSHDiff(a.TLSH, b.TLSH); err == nil {
+			score.TLSH = diff
+		}
+	}
+	return score
+}
+
+// sectionFuzzyHashes returns the fuzzy hashes of a section, computed at
+// parse time with the SectionFuzzyHashes option or now otherwise.
+func (pe *File) sectionFuzzyHashes(section *Section) FuzzyHashes {
+	if section.FuzzyHashes != nil {
+		return *section.FuzzyHashes
+	}
+	return section.CalculateFuzzyHashes(pe)
+}
+
+// FuzzySimilarity scores the similarity of two parsed files with the ssdeep
+// and TLSH fuzzy hashes of the whole files and of their sections, to
+// cluster variants of a same program.
+func FuzzySimilarity(a, b *File) *Similarity {
+	similarity := &Similarity{
+		File: scoreFuzzyHashes(a.FuzzyHashes(), b.FuzzyHashes()),
+	}
+
+	// Sections sharing a name are paired in order.
+	used := make([]bool, len(b.Sections))
+	for i := range a.Sections {
+		name := a.Sections[i].String()
+		for j := range b.Sections {
+			if used[j] || b.Sections[j].String() != name {
+				continue
+			}
+			used[j] = true
+			similarity.Sections = append(similarity.Sections,
+				SectionSimilarity{
+					Name: name,
+					FuzzyScore: scoreFuzzyHashes(
+						a.sectionFuzzyHashes(&a.Sections[i]),
+						b.sectionFuzzyHashes(&b.Sections[j])),
+				})
+			break
+		}
+	}
+	return similarity
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"testing"
+)
+
+func TestFuzzySimilarity(t *testing.T) {
+
+	parse := func(name string, opts *Options) *File {
+		filename := getAbsoluteFilePath(name)
+		file, err := New(filename, opts)
+		if err != nil {
+			t.Fatalf("New(%s) failed, reason: %v", filename, err)
+		}
+		err = file.Parse()
+		if err != nil {
+			t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+		}
+		return file
+	}
+
+	putty := parse("test/putty.exe", &Options{SectionFuzzyHashes: true})
+	modified := parse("test/putty_modified.exe", &Options{})
+	kernel32 := parse("test/kernel32.dll", &Options{})
+
+	if putty.Sections[0].FuzzyHashes == nil ||
+		modified.Sections[0].FuzzyHashes != nil {
+		t.Fatalf("section fuzzy hashes assertion failed")
+	}
+
+	similarity := FuzzySimilarity(putty, modified)
+	if similarity.File.Ssdeep < 90 || similarity.File.TLSH > 10 {
+		t.Errorf("similar files score assertion failed, got %+v",
+			similarity.File)
+	}
+	if len(similarity.Sections) != len(putty.Sections) {
+		t.Fatalf("sections count assertion failed, got %d, want %d",
+			len(similarity.Sections), len(putty.Sections))
+	}
+	for _, section := range similarity.Sections {
+		if section.Ssdeep != 100 || section.TLSH > 0 {
+			t.Errorf("section %s score assertion failed, got %+v",
+				section.Name, section.FuzzyScore)
+		}
+	}
+
+	similarity = FuzzySimilarity(putty, kernel32)
+	if similarity.File.Ssdeep != 0 || similarity.File.TLSH < 100 {
+		t.Errorf("unrelated files score assertion failed, got %+v",
+			similarity.File)
+	}
+	if similarity.Sections[0].Name != ".text" {
+		t.Errorf("section pairing assertion failed, got %s, want .text",
+			similarity.Sections[0].Name)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"time"
+)
+
+// ParseStats holds the time spent and the bytes read by a parser.
+type ParseStats struct {
+	// Parser is the name of the parser, for instance `NTHeader`,
+	// `SectionHeader` or the name of a data directory.
+	Parser string `json:"parser"`
+
+	// Duration is the time spent in the parser, including the custom
+	// handlers registered for the data directory.
+	Duration time.Duration `json:"duration"`
+
+	// Bytes is the number of bytes read by the parser. Bytes read several
+	// times are counted every time.
+	Bytes uint64 `json:"bytes"`
+}
+
+// startStats attributes the time elapsed since the previous parser started
+// to it, and starts timing the given parser. Nothing is recorded unless the
+// CollectStats option is set.
+func (pe *File) startStats(parser string) {
+	if !pe.opts.CollectStats {
+		return
+	}
+
+	now := time.Now()
+	if pe.coverageParser != "" {
+		pe.parserStats(pe.coverageParser).Duration += now.Sub(pe.statsStart)
+	}
+	pe.statsStart = now
+}
+
+// addStatsBytes records that the current parser read size bytes.
+func (pe *File) addStatsBytes(size uint32) {
+	if !pe.opts.CollectStats || pe.coverageParser == "" {
+		return
+	}
+	pe.parserStats(pe.coverageParser).Bytes += uint64(size)
+}
+
+// parserStats returns the statistics of a parser, in the order the parsers
+// were run.
+func (pe *File) parserStats(parser string) *ParseStats {
+	for i := range pe.Stats {
+		if pe.Stats[i].Parser == parser {
+			return &pe.Stats[i]
+		}
+	}
+	pe.Stats = append(pe.Stats, ParseStats{Parser: parser})
+	return &pe.Stats[len(pe.Stats)-1]
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"testing"
+)
+
+func TestCollectStats(t *testing.T) {
+
+	tests := []struct {
+		in      string
+		parsers []string
+	}{
+		{getAbsoluteFilePath("test/putty.exe"),
+			[]string{"DOSHeader", "NTHeader", "SectionHeader", "Import", "Resource"}},
+		{getAbsoluteFilePath("test/kernel32.dll"),
+			[]string{"DOSHeader", "RichHeader", "NTHeader", "Export", "Import"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{CollectStats: true})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			stats := make(map[string]ParseStats)
+			for _, s := range file.Stats {
+				if _, ok := stats[s.Parser]; ok {
+					t.Errorf("parser %s has several stats entries", s.Parser)
+				}
+				stats[s.Parser] = s
+			}
+			for _, parser := range tt.parsers {
+				s, ok := stats[parser]
+				if !ok {
+					t.Errorf("stats of parser %s not found", parser)
+					continue
+				}
+				if s.Bytes == 0 || s.Duration < 0 {
+					t.Errorf("stats of parser %s assertion failed, got %v", parser, s)
+				}
+			}
+
+			// Stats do not enable the coverage.
+			if len(file.Coverage()) != 0 {
+				t.Errorf("coverage recorded without the Coverage option")
+			}
+		})
+	}
+
+	file, err := New(getAbsoluteFilePath("test/putty.exe"), &Options{})
+	if err != nil {
+		t.Fatalf("New() failed, reason: %v", err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse() failed, reason: %v", err)
+	}
+	if file.Stats != nil {
+		t.Errorf("stats collected without the CollectStats option, got %v",
+			file.Stats)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"sort"
+	"unicode/utf16"
+)
+
+// StringKind tells how a string found in the file is encoded. Kinds are
+// flags which can be combined to select the strings to extract.
+type StringKind uint8
+
+const (
+	// StringASCII is a string of printable ASCII characters.
+	StringASCII StringKind = 1 << iota
+
+	// StringUTF16 is a little endian UTF-16 string of printable ASCII
+	// characters, as Windows stores wide strings.
+	StringUTF16
+)
+
+// DefaultStringMinLength is the minimum number of characters of the strings
+// extracted when none is given.
+const DefaultStringMinLength = 4
+
+// String stringifies the string kind.
+func (k StringKind) String() string {
+	stringKindMap := map[StringKind]string{
+		StringASCII: "ASCII",
+		StringUTF16: "UTF-16",
+	}
+
+	if v, ok := stringKindMap[k]; ok {
+		return v
+	}
+	return "?"
+}
+
+// FileString is a string found in the file along with where it lies.
+type FileString struct {
+	// The string, without its terminator.
+	Value string `json:"value"`
+
+	// How the string is encoded.
+	Kind StringKind `json:"kind"`
+
+	// File offset of the string.
+	Offset uint32 `json:"offset"`
+
+	// RVA of the string once mapped, zero when it is not mapped, such as in
+	// the overlay.
+	RVA uint32 `json:"rva"`
+
+	// Name of the section the string lies in, empty when it is in the
+	// headers or not mapped.
+	Section string `json:"section"`
+}
+
+// Strings extracts the strings of at least minLen characters from the whole
+// file, sorted by offset, and tags them with the section and the RVA they
+// are mapped at. kinds selects the encodings to look for, all of them when
+// zero. When minLen is zero, DefaultStringMinLength is used.
+func (pe *File) Strings(minLen int, kinds StringKind) []FileString {
+
+	if minLen <= 0 {
+		minLen = DefaultStringMinLength
+	}
+	if kinds == 0 {
+		kinds = StringASCII | StringUTF16
+	}
+
+	var strs []FileString
+	data := pe.data[:pe.size]
+	if kinds&StringASCII != 0 {
+		strs = append(strs, pe.asciiStrings(data, minLen)...)
+	}
+	if kinds&StringUTF16 != 0 {
+		strs = append(strs, pe.utf16Strings(data, minLen)...)
+	}
+
+	sort.SliceStable(strs, func(i, j int) bool {
+		return strs[i].Offset < strs[j].Offset
+	})
+	return strs
+}
+
+// isPrintable tells whether a character is printable ASCII, tabs included.
+func isPrintable(c byte) bool {
+	return (c >= 0x20 && c < 0x7f) || c == '\t'
+}
+
+// asciiStrings returns the runs of printable ASCII characters.
+func (pe *File) asciiStrings(data []byte, minLen int) []FileString {
+	var strs []FileString
+	start := -1
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && isPrintable(data[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= minLen {
+			strs = append(strs, pe.fileString(string(data[start:i]),
+				StringASCII, uint32(start)))
+		}
+		start = -1
+	}
+	return strs
+}
+
+// utf16Strings returns the runs of printable ASCII characters encoded as
+// little endian UTF-16, at even and odd offsets.
+func (pe *File) utf16Strings(data []byte, minLen int) []FileString {
+	var strs []FileString
+	for i := 0; i+1 < len(data); {
+		if !isPrintable(data[i]) || data[i+1] != 0 {
+			i++
+			continue
+		}
+
+		start := i
+		var chars []uint16
+		for i+1 < len(data) && isPrintable(data[i]) && data[i+1] == 0 {
+			chars = append(chars, uint16(data[i]))
+			i += 2
+		}
+		if len(chars) >= minLen {
+			strs = append(strs, pe.fileString(string(utf16.Decode(chars)),
+				StringUTF16, uint32(start)))
+		}
+	}
+	return strs
+}
+
+// fileString tags a string with the section and the RVA of its offset.
+func (pe *File) fileString(value string, kind StringKind,
+	offset uint32) FileString {
+
+	str := FileString{Value: value, Kind: kind, Offset: offset}
+	if section := pe.getSectionByOffset(offset); section != nil {
+		str.Section = section.String()
+		str.RVA = offset -
+			pe.adjustFileAlignment(section.Header.PointerToRawData) +
+			pe.adjustSectionAlignment(section.Header.VirtualAddress)
+		return str
+	}
+
+	if offset < pe.sizeOfHeaders() {
+		str.RVA = offset
+	}
+	return str
+}
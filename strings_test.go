@@ -0,0 +1,102 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"testing"
+)
+
+func TestStrings(t *testing.T) {
+
+	tests := []struct {
+		in     string
+		minLen int
+		kinds  StringKind
+		out    FileString
+	}{
+		{
+			in:     getAbsoluteFilePath("test/putty.exe"),
+			minLen: 4,
+			kinds:  StringASCII,
+			out: FileString{
+				Value:  "!This program cannot be run in DOS mode.$",
+				Kind:   StringASCII,
+				Offset: 0x4d,
+				RVA:    0x4d,
+			},
+		},
+		{
+			in:     getAbsoluteFilePath("test/putty.exe"),
+			minLen: 0,
+			kinds:  0,
+			out: FileString{
+				Value:   "PuTTY downstream no longer available",
+				Kind:    StringASCII,
+				Offset:  0xa47e0,
+				RVA:     0xa59e0,
+				Section: ".rdata",
+			},
+		},
+		{
+			in:     getAbsoluteFilePath("test/putty.exe"),
+			minLen: 8,
+			kinds:  StringUTF16,
+			out: FileString{
+				Value:   ".0123456789",
+				Kind:    StringUTF16,
+				Offset:  0xa70fe,
+				RVA:     0xa82fe,
+				Section: ".rdata",
+			},
+		},
+		{
+			in:     getAbsoluteFilePath("test/putty.exe"),
+			minLen: 12,
+			kinds:  StringASCII,
+			out: FileString{
+				Value:  "'Symantec Time Stamping Services CA - G2",
+				Kind:   StringASCII,
+				Offset: 0x11fbd3,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out.Value, func(t *testing.T) {
+			ops := Options{Fast: true}
+			file, err := New(tt.in, &ops)
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			defer file.Close()
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			strs := file.Strings(tt.minLen, tt.kinds)
+			found := false
+			for i, s := range strs {
+				if i > 0 && strs[i-1].Offset > s.Offset {
+					t.Fatalf("strings not sorted by offset at 0x%x", s.Offset)
+				}
+				if tt.kinds != 0 && s.Kind&tt.kinds == 0 {
+					t.Fatalf("unexpected %s string %q", s.Kind, s.Value)
+				}
+				if s.Offset == tt.out.Offset && s.Kind == tt.out.Kind {
+					found = true
+					if s != tt.out {
+						t.Fatalf("string at 0x%x assertion failed, got %v, want %v",
+							s.Offset, s, tt.out)
+					}
+				}
+			}
+			if !found {
+				t.Fatalf("string %q not found", tt.out.Value)
+			}
+		})
+	}
+}
@@ -5,9 +5,20 @@
 package pe
 
 import (
+	"bytes"
 	"encoding/binary"
 )
 
+const (
+	// AnoTLSCallbacksNull is reported when the AddressOfCallBacks of the TLS
+	// directory is zero rather than pointing to a null-terminated list.
+	AnoTLSCallbacksNull = "TLS AddressOfCallBacks is zero"
+
+	// AnoTLSDirectoryStraddlesSections is reported when the TLS directory
+	// starts in a section and ends in another one, or in the headers.
+	AnoTLSDirectoryStraddlesSections = "TLS directory straddles sections"
+)
+
 // TLSDirectoryCharacteristicsType represents the type of a TLS directory
 // Characteristics.
 type TLSDirectoryCharacteristicsType uint32
@@ -18,8 +29,11 @@ type TLSDirectory struct {
 	// of type *IMAGE_TLS_DIRECTORY32 or *IMAGE_TLS_DIRECTORY64 structure.
 	Struct interface{} `json:"struct"`
 
-	// of type []uint32 or []uint64.
+	// of type []uint32 or []uint64, empty when the image has no callback.
 	Callbacks interface{} `json:"callbacks"`
+
+	// HasCallbacks is set when the list of callbacks is not empty.
+	HasCallbacks bool `json:"has_callbacks"`
 }
 
 // ImageTLSDirectory32 represents the IMAGE_TLS_DIRECTORY32 structure.
@@ -103,55 +117,31 @@ func (pe *File) parseTLSDirectory(rva, size uint32) error {
 	if pe.Is64 {
 		tlsDir := ImageTLSDirectory64{}
 		tlsSize := uint32(binary.Size(tlsDir))
-		fileOffset := pe.GetOffsetFromRva(rva)
-		err := pe.structUnpack(&tlsDir, fileOffset, tlsSize)
+		err := pe.tlsUnpack(&tlsDir, rva, tlsSize)
 		if err != nil {
 			return err
 		}
 		tls.Struct = tlsDir
 
-		if tlsDir.AddressOfCallBacks != 0 {
-			callbacks := make([]uint64, 0)
-			rvaAddressOfCallBacks := uint32(tlsDir.AddressOfCallBacks -
-				pe.NtHeader.OptionalHeader.(ImageOptionalHeader64).ImageBase)
-			offset := pe.GetOffsetFromRva(rvaAddressOfCallBacks)
-			for {
-				c, err := pe.ReadUint64(offset)
-				if err != nil || c == 0 {
-					break
-				}
-				callbacks = append(callbacks, c)
-				offset += 8
-			}
-			tls.Callbacks = callbacks
-		}
+		callbacks := pe.parseTLSCallbacks(tlsDir.AddressOfCallBacks, 8)
+		tls.Callbacks = callbacks
+		tls.HasCallbacks = len(callbacks) > 0
 	} else {
 		tlsDir := ImageTLSDirectory32{}
 		tlsSize := uint32(binary.Size(tlsDir))
-		fileOffset := pe.GetOffsetFromRva(rva)
-		err := pe.structUnpack(&tlsDir, fileOffset, tlsSize)
+		err := pe.tlsUnpack(&tlsDir, rva, tlsSize)
 		if err != nil {
 			return err
 		}
 		tls.Struct = tlsDir
 
-		// 94a9dc17d47b03f6fb01cb639e25503b37761b452e7c07ec6b6c2280635f1df9
-		// Callbacks may be empty.
-		if tlsDir.AddressOfCallBacks != 0 {
-			callbacks := make([]uint32, 0)
-			rvaAddressOfCallBacks := tlsDir.AddressOfCallBacks -
-				pe.NtHeader.OptionalHeader.(ImageOptionalHeader32).ImageBase
-			offset := pe.GetOffsetFromRva(rvaAddressOfCallBacks)
-			for {
-				c, err := pe.ReadUint32(offset)
-				if err != nil || c == 0 {
-					break
-				}
-				callbacks = append(callbacks, c)
-				offset += 4
-			}
-			tls.Callbacks = callbacks
+		callbacks := make([]uint32, 0)
+		for _, c := range pe.parseTLSCallbacks(
+			uint64(tlsDir.AddressOfCallBacks), 4) {
+			callbacks = append(callbacks, uint32(c))
 		}
+		tls.Callbacks = callbacks
+		tls.HasCallbacks = len(callbacks) > 0
 	}
 
 	pe.TLS = tls
@@ -159,6 +149,60 @@ func (pe *File) parseTLSDirectory(rva, size uint32) error {
 	return nil
 }
 
+// tlsUnpack decodes the TLS directory as the loader maps it. Packers are
+// known to lay it across two sections, whose raw data is not necessarily
+// contiguous in the file, or at the end of a section, where the loader zero
+// fills it.
+func (pe *File) tlsUnpack(tlsDir interface{}, rva, size uint32) error {
+	if pe.getSectionByRva(rva) != pe.getSectionByRva(rva+size-1) {
+		pe.addAnomaly(AnoTLSDirectoryStraddlesSections)
+	}
+
+	data, err := pe.GetMappedData(rva, size)
+	if err != nil {
+		return err
+	}
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, tlsDir)
+}
+
+// parseTLSCallbacks reads the null-terminated list of callbacks pointed to by
+// the AddressOfCallBacks VA. The list is empty when the address is zero or
+// outside the image.
+func (pe *File) parseTLSCallbacks(addressOfCallBacks uint64,
+	ptrSize uint32) []uint64 {
+
+	callbacks := make([]uint64, 0)
+	if addressOfCallBacks == 0 {
+		pe.addAnomaly(AnoTLSCallbacksNull)
+		return callbacks
+	}
+
+	rva, err := pe.rvaFromVA(addressOfCallBacks, "TLS AddressOfCallBacks")
+	if err != nil {
+		return callbacks
+	}
+
+	for {
+		data, err := pe.GetMappedData(rva, ptrSize)
+		if err != nil {
+			break
+		}
+
+		var c uint64
+		if ptrSize == 8 {
+			c = binary.LittleEndian.Uint64(data)
+		} else {
+			c = uint64(binary.LittleEndian.Uint32(data))
+		}
+		if c == 0 {
+			break
+		}
+		callbacks = append(callbacks, c)
+		rva += ptrSize
+	}
+	return callbacks
+}
+
 // String returns the string representations of the `Characteristics` field of
 // TLS directory.
 func (characteristics TLSDirectoryCharacteristicsType) String() string {
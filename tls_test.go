@@ -5,6 +5,9 @@
 package pe
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
 	"reflect"
 	"testing"
 )
@@ -24,7 +27,8 @@ func TestParseTLSDirectory(t *testing.T) {
 					AddressOfIndex:        0x6CBB75AC,
 					AddressOfCallBacks:    0x6CBBA030,
 				},
-				Callbacks: []uint64{0x6cbae7e0, 0x6cbae7b0},
+				Callbacks:    []uint64{0x6cbae7e0, 0x6cbae7b0},
+				HasCallbacks: true,
 			},
 		},
 		{
@@ -37,7 +41,8 @@ func TestParseTLSDirectory(t *testing.T) {
 					AddressOfCallBacks:    0x0040E3AC,
 					Characteristics:       0x00100000,
 				},
-				Callbacks: []uint32{0x40A5A0},
+				Callbacks:    []uint32{0x40A5A0},
+				HasCallbacks: true,
 			},
 		},
 	}
@@ -110,3 +115,101 @@ func TestTLSDirectoryCharacteristics(t *testing.T) {
 		})
 	}
 }
+
+func TestTLSDirectoryEdgeCases(t *testing.T) {
+
+	// liblzo2-2.dll has its TLS directory at 0x3b020 in the .tls section, the
+	// AddressOfCallBacks field being at +24. The section header of .CRT, which
+	// precedes .tls, is the 9th one.
+	const (
+		tlsRVA    = 0x3b020
+		imageBase = 0x6cb80000
+	)
+
+	tests := []struct {
+		name         string
+		callbacks    uint64
+		tlsRVA       uint32
+		crtVSize     uint32
+		anomaly      string
+		hasCallbacks bool
+	}{
+		{"no callbacks list", 0, tlsRVA, 0, AnoTLSCallbacksNull, false},
+		// The last 8 bytes of the directory are zero.
+		{"empty callbacks list", imageBase + tlsRVA + 0x20, tlsRVA, 0, "", false},
+		// Extend .CRT up to .tls and move the directory across both.
+		{"straddling directory", 0, 0x3aff0, 0x1000,
+			AnoTLSDirectoryStraddlesSections, false},
+	}
+
+	filename := getAbsoluteFilePath("test/liblzo2-2.dll")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+			}
+
+			file, err := NewBytes(data, &Options{Fast: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+
+			if tt.crtVSize != 0 {
+				crt := file.Sections[8]
+				binary.LittleEndian.PutUint32(data[crt.headerOffset+8:], tt.crtVSize)
+			} else {
+				offset := file.GetOffsetFromRva(tlsRVA)
+				binary.LittleEndian.PutUint64(data[offset+24:], tt.callbacks)
+			}
+
+			file, err = NewBytes(data, &Options{Fast: true})
+			if err != nil {
+				t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+			}
+			err = file.parseTLSDirectory(tt.tlsRVA, 0x28)
+			if err != nil {
+				t.Fatalf("parseTLSDirectory(%s) failed, reason: %v", filename, err)
+			}
+
+			callbacks, ok := file.TLS.Callbacks.([]uint64)
+			if !ok {
+				t.Fatalf("TLS callbacks type assertion failed, got %T",
+					file.TLS.Callbacks)
+			}
+			if file.TLS.HasCallbacks != tt.hasCallbacks ||
+				(len(callbacks) > 0) != tt.hasCallbacks {
+				t.Errorf("TLS callbacks assertion failed, got %v, want callbacks %v",
+					callbacks, tt.hasCallbacks)
+			}
+			if tt.anomaly != "" && !stringInSlice(tt.anomaly, file.Anomalies) {
+				t.Errorf("anomaly %s not found in %v", tt.anomaly, file.Anomalies)
+			}
+
+			// The directory is the one found in the mapped image.
+			var image bytes.Buffer
+			err = file.WriteMappedImage(&image)
+			if err != nil {
+				t.Fatalf("WriteMappedImage(%s) failed, reason: %v", filename, err)
+			}
+			var want ImageTLSDirectory64
+			err = binary.Read(bytes.NewReader(image.Bytes()[tt.tlsRVA:]),
+				binary.LittleEndian, &want)
+			if err != nil {
+				t.Fatalf("binary.Read() failed, reason: %v", err)
+			}
+			if !reflect.DeepEqual(file.TLS.Struct, want) {
+				t.Errorf("TLS directory assertion failed, got %v, want %v",
+					file.TLS.Struct, want)
+			}
+		})
+	}
+}
@@ -7,7 +7,17 @@ package pe
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"strconv"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
 )
 
 const (
@@ -36,6 +46,9 @@ const (
 	// LangIDLength specifies the length of the language identifier string.
 	// It is represented as 8-digit hexadecimal number stored as a Unicode string.
 	LangIDLength uint32 = 8*2 + 1
+
+	// CodePageUnicode is the code page identifier of UTF-16LE.
+	CodePageUnicode = 1200
 )
 
 // VsVersionInfo represents the organization of data in
@@ -206,15 +219,15 @@ func (s *StringTable) GetOffset(rva uint32, e ResourceDirectoryEntry, pe *File)
 	return alignDword(offset, e.Data.Struct.OffsetToData)
 }
 
-func (pe *File) parseStringTable(rva uint32, e ResourceDirectoryEntry) (*StringTable, error) {
+func (pe *File) parseStringTable(rva uint32, e ResourceDirectoryEntry) (*StringTable, uint16, uint16, error) {
 	var s StringTable
 	offset := s.GetOffset(rva, e, pe)
 	b, err := pe.ReadBytesAtOffset(offset, StringTableLength)
 	if err != nil {
-		return nil, err
+		return nil, 0, 0, err
 	}
 	if err := binary.Read(bytes.NewBuffer(b), binary.LittleEndian, &s); err != nil {
-		return nil, err
+		return nil, 0, 0, err
 	}
 	// Read the 8-digit hexadecimal number stored as a Unicode string.
 	// The four most significant digits represent the language identifier.
@@ -222,18 +235,22 @@ func (pe *File) parseStringTable(rva uint32, e ResourceDirectoryEntry) (*StringT
 	// the data is formatted.
 	b, err = pe.ReadBytesAtOffset(offset+StringTableLength, (8*2)+1)
 	if err != nil {
-		return nil, err
+		return nil, 0, 0, err
 	}
 	langID, err := DecodeUTF16String(b)
 	if err != nil {
-		return nil, err
+		return nil, 0, 0, err
 	}
 	if len(langID) != int(LangIDLength/2) {
-		return nil, fmt.Errorf("invalid language identifier length. Expected: %d, Got: %d",
+		return nil, 0, 0, fmt.Errorf("invalid language identifier length. Expected: %d, Got: %d",
 			LangIDLength/2,
 			len(langID))
 	}
-	return &s, nil
+	translation, err := strconv.ParseUint(langID, 16, 32)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("invalid language identifier %s", langID)
+	}
+	return &s, uint16(translation >> 16), uint16(translation), nil
 }
 
 // String Represents the organization of data in a
@@ -260,7 +277,7 @@ func (s *String) getOffsetAndPadding(rva uint32, e ResourceDirectoryEntry, pe *F
 	return alignedOffset, uint16(alignedOffset - unalignedOffset)
 }
 
-func (pe *File) parseString(rva uint32, e ResourceDirectoryEntry) (string, string, uint16, error) {
+func (pe *File) parseString(rva uint32, e ResourceDirectoryEntry, codePage uint16) (string, string, uint16, error) {
 	var s String
 	offset, padding := s.getOffsetAndPadding(rva, e, pe)
 	b, err := pe.ReadBytesAtOffset(offset, StringLength)
@@ -280,11 +297,25 @@ func (pe *File) parseString(rva uint32, e ResourceDirectoryEntry) (string, strin
 		return "", "", 0, err
 	}
 	valueOffset := alignDword(uint32(2*(len(key)+1))+offset+StringLength, e.Data.Struct.OffsetToData)
-	b, err = pe.ReadBytesAtOffset(valueOffset, uint32(2*(s.ValueLength+1)))
-	if err != nil {
-		return "", "", 0, err
+	var value string
+	switch {
+	case s.ValueLength == 0:
+		// Empty value, nothing to decode.
+	case s.Type == 0 && codePage != CodePageUnicode:
+		// Binary values are made of ValueLength bytes formatted according
+		// to the code page of the string table.
+		b, err = pe.ReadBytesAtOffset(valueOffset, uint32(s.ValueLength))
+		if err != nil {
+			return "", "", 0, err
+		}
+		value, err = pe.decodeCodePageString(b, codePage)
+	default:
+		b, err = pe.ReadBytesAtOffset(valueOffset, uint32(2*(s.ValueLength+1)))
+		if err != nil {
+			return "", "", 0, err
+		}
+		value, err = DecodeUTF16String(b)
 	}
-	value, err := DecodeUTF16String(b)
 	if err != nil {
 		return "", "", 0, err
 	}
@@ -297,41 +328,160 @@ func (pe *File) parseString(rva uint32, e ResourceDirectoryEntry) (string, strin
 	return key, value, totalLength, nil
 }
 
+// codePageEncoding returns the text encoding of a Windows code page
+// identifier, or nil when the code page is not supported.
+func codePageEncoding(codePage uint16) encoding.Encoding {
+	switch codePage {
+	case 437:
+		return charmap.CodePage437
+	case 850:
+		return charmap.CodePage850
+	case 852:
+		return charmap.CodePage852
+	case 855:
+		return charmap.CodePage855
+	case 866:
+		return charmap.CodePage866
+	case 874:
+		return charmap.Windows874
+	case 932:
+		return japanese.ShiftJIS
+	case 936:
+		return simplifiedchinese.GBK
+	case 949:
+		return korean.EUCKR
+	case 950:
+		return traditionalchinese.Big5
+	case 1200:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case 1201:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	case 1250:
+		return charmap.Windows1250
+	case 1251:
+		return charmap.Windows1251
+	case 1252:
+		return charmap.Windows1252
+	case 1253:
+		return charmap.Windows1253
+	case 1254:
+		return charmap.Windows1254
+	case 1255:
+		return charmap.Windows1255
+	case 1256:
+		return charmap.Windows1256
+	case 1257:
+		return charmap.Windows1257
+	case 1258:
+		return charmap.Windows1258
+	case 10000:
+		return charmap.Macintosh
+	case 20866:
+		return charmap.KOI8R
+	case 21866:
+		return charmap.KOI8U
+	case 28591:
+		return charmap.ISO8859_1
+	case 28592:
+		return charmap.ISO8859_2
+	case 28595:
+		return charmap.ISO8859_5
+	case 28597:
+		return charmap.ISO8859_7
+	case 28605:
+		return charmap.ISO8859_15
+	case 65001:
+		return unicode.UTF8
+	}
+	return nil
+}
+
+// decodeCodePageString decodes a null terminated string formatted according
+// to the given code page. Unknown code pages are decoded as Windows-1252,
+// which is what the resource compiler defaults to.
+func decodeCodePageString(b []byte, codePage uint16) (string, error) {
+	if n := bytes.IndexByte(b, 0); n >= 0 {
+		b = b[:n]
+	}
+	enc := codePageEncoding(codePage)
+	if enc == nil {
+		enc = charmap.Windows1252
+	}
+	s, err := enc.NewDecoder().Bytes(b)
+	if err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// VersionTranslation holds the version strings of a single StringTable,
+// that is, the strings formatted for one language and code page pair.
+type VersionTranslation struct {
+	// LangID is the language identifier of the string table.
+	LangID uint16 `json:"lang_id"`
+
+	// CodePage is the code page for which the strings are formatted.
+	CodePage uint16 `json:"code_page"`
+
+	// Strings maps the version string names to their values.
+	Strings map[string]string `json:"strings"`
+}
+
 // ParseVersionResources parses file version strings from the version resource
 // directory. This directory contains several structures starting with VS_VERSION_INFO
 // with references to children StringFileInfo structures. In addition, StringFileInfo
 // contains the StringTable structure with String entries describing the name and value
 // of each file version strings.
+//
+// The strings are the ones of a single translation, picked the way
+// PrimaryVersionTranslation does without any preferred language. Use
+// ParseVersionTranslations to get the strings of every translation.
 func (pe *File) ParseVersionResources() (map[string]string, error) {
-	vers := make(map[string]string)
+	translations, err := pe.ParseVersionTranslations()
+	if len(translations) == 0 {
+		return make(map[string]string), err
+	}
+	return translations[primaryTranslation(translations, nil)].Strings, err
+}
+
+// ParseVersionTranslations parses the version resource directory and returns
+// the strings of every StringTable, one entry per language and code page
+// pair, in the order they appear in the resource directory.
+func (pe *File) ParseVersionTranslations() ([]VersionTranslation, error) {
+	var translations []VersionTranslation
 	if pe.opts.OmitResourceDirectory {
-		return vers, nil
+		return translations, nil
 	}
 	for _, e := range pe.Resources.Entries {
 		if e.ID != VersionResourceType {
 			continue
 		}
 
+		if len(e.Directory.Entries) == 0 {
+			continue
+		}
 		directory := e.Directory.Entries[0].Directory
 
 		for _, e := range directory.Entries {
-			m, err := pe.parseVersionEntry(e, vers)
+			t, err := pe.parseVersionEntry(e)
+			translations = append(translations, t...)
 			if err != nil {
-				return m, err
+				return translations, err
 			}
 		}
 	}
-	return vers, nil
+	return translations, nil
 }
 
-func (pe *File) parseVersionEntry(e ResourceDirectoryEntry, vers map[string]string) (map[string]string, error) {
+func (pe *File) parseVersionEntry(e ResourceDirectoryEntry) ([]VersionTranslation, error) {
+	var translations []VersionTranslation
 	ver, err := pe.parseVersionInfo(e)
 	if err != nil {
-		return vers, err
+		return translations, err
 	}
 	ff, err := pe.parseFixedFileInfo(e)
 	if err != nil {
-		return vers, err
+		return translations, err
 	}
 
 	offset := ff.GetStringFileInfoOffset(e)
@@ -346,28 +496,34 @@ func (pe *File) parseVersionEntry(e ResourceDirectoryEntry, vers map[string]stri
 		case StringFileInfoString:
 			tableOffset := f.GetStringTableOffset(offset)
 			for {
-				table, err := pe.parseStringTable(tableOffset, e)
-				if err != nil {
+				table, langID, codePage, err := pe.parseStringTable(tableOffset, e)
+				if err != nil || table.Length == 0 {
 					break
 				}
+				tableOffset = alignDword(tableOffset, e.Data.Struct.OffsetToData)
+				translation := VersionTranslation{
+					LangID:   langID,
+					CodePage: codePage,
+					Strings:  make(map[string]string),
+				}
 				stringOffset := table.GetStringOffset(tableOffset, e)
 				for stringOffset < tableOffset+uint32(table.Length) {
-					k, v, l, err := pe.parseString(stringOffset, e)
+					k, v, l, err := pe.parseString(stringOffset, e, codePage)
 					if err != nil {
 						break
 					}
-					vers[k] = v
+					translation.Strings[k] = v
 					if l == 0 {
 						stringOffset = tableOffset + uint32(table.Length)
 					} else {
 						stringOffset = stringOffset + uint32(l)
 					}
 				}
-				// handle potential infinite loops
-				if uint32(table.Length)+tableOffset > tableOffset {
-					break
-				}
-				if tableOffset > uint32(f.Length) {
+				translations = append(translations, translation)
+
+				// Move to the next string table, if any.
+				tableOffset += uint32(table.Length)
+				if tableOffset >= offset+uint32(f.Length) {
 					break
 				}
 			}
@@ -382,7 +538,70 @@ func (pe *File) parseVersionEntry(e ResourceDirectoryEntry, vers map[string]stri
 			break
 		}
 	}
-	return nil, nil
+	return translations, nil
+}
+
+// langEnglishUS is the language identifier of English (United States), which
+// Windows falls back to when no resource matches the preferred languages.
+const langEnglishUS = 0x409
+
+// langRank ranks the language identifier of a resource against the preferred
+// languages, lower is better. As the Windows resource loader does, an exact
+// match comes before a match of the primary language only, then come the
+// neutral language, English (United States) and the other languages.
+func langRank(langID uint16, preferred []uint16) int {
+	for i, lang := range preferred {
+		if langID == lang {
+			return i * 2
+		}
+		if langID&0x3ff == lang&0x3ff {
+			return i*2 + 1
+		}
+	}
+	n := len(preferred) * 2
+	switch {
+	case langID&0x3ff == 0:
+		return n
+	case langID == langEnglishUS:
+		return n + 1
+	}
+	return n + 2
+}
+
+// PrimaryVersionTranslation returns the translation displayed in the file
+// properties dialog of Windows Explorer for a user whose preferred UI
+// languages are the given language identifiers, most preferred first.
+// Without a match, the neutral language is picked, then English (United
+// States) and otherwise the first translation. Among the translations of a
+// language, the Unicode one is preferred.
+func (pe *File) PrimaryVersionTranslation(langs ...uint16) (
+	VersionTranslation, error) {
+
+	translations, err := pe.ParseVersionTranslations()
+	if len(translations) == 0 {
+		if err == nil {
+			err = errors.New("no version translation found")
+		}
+		return VersionTranslation{}, err
+	}
+	return translations[primaryTranslation(translations, langs)], nil
+}
+
+// primaryTranslation returns the index of the translation picked by
+// PrimaryVersionTranslation among a non empty list of translations.
+func primaryTranslation(translations []VersionTranslation, langs []uint16) int {
+	best := 0
+	for i, translation := range translations[1:] {
+		rank := langRank(translation.LangID, langs)
+		bestRank := langRank(translations[best].LangID, langs)
+		if rank < bestRank || rank == bestRank &&
+			translation.LangID == translations[best].LangID &&
+			translation.CodePage == CodePageUnicode &&
+			translations[best].CodePage != CodePageUnicode {
+			best = i + 1
+		}
+	}
+	return best
 }
 
 // ParseVersionResourcesForEntries parses file version strings from the version resource
@@ -400,12 +619,18 @@ func (pe *File) ParseVersionResourcesForEntries() ([]map[string]string, error) {
 			continue
 		}
 
+		if len(e.Directory.Entries) == 0 {
+			continue
+		}
 		directory := e.Directory.Entries[0].Directory
 
 		for _, e := range directory.Entries {
 			vers := make(map[string]string)
+			translations, err := pe.parseVersionEntry(e)
+			if len(translations) > 0 {
+				vers = translations[primaryTranslation(translations, nil)].Strings
+			}
 			allVersions = append(allVersions, vers)
-			_, err := pe.parseVersionEntry(e, vers)
 			if err != nil {
 				return allVersions, err
 			}
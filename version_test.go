@@ -48,6 +48,11 @@ var peVersionResourceTests = []struct {
 		nil,
 		map[string]string{"CompanyName": "Microsoft Corporation", "FileDescription": "", "FileVersion": "1.24052.124.0", "OriginalFilename": "YourPhone.Exp.WinRT.dll", "LegalCopyright": "Â© Microsoft Corporation.  All rights reserved.", "InternalName": "YourPhone.Exp.WinRT", "ProductName": "Microsoft Phone Link", "ProductVersion": "1.24052.124.0"},
 	},
+	{
+		getAbsoluteFilePath("test/PSCRIPT5.DLL"),
+		nil,
+		map[string]string{"CompanyName": "Microsoft Corporation", "FileDescription": "PostScript Printer Driver", "LegalCopyright": "© Microsoft Corporation. All rights reserved.", "ProductName": "Microsoft® Windows® Operating System"},
+	},
 }
 
 func TestParseVersionResources(t *testing.T) {
@@ -78,3 +83,144 @@ func TestParseVersionResources(t *testing.T) {
 		})
 	}
 }
+
+func TestParseVersionTranslations(t *testing.T) {
+	tests := []struct {
+		in                string
+		translationsCount int
+		langID            uint16
+		codePage          uint16
+		fileDescription   string
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), 1, 0x809, 1200, "SSH, Telnet and Rlogin client"},
+		{getAbsoluteFilePath("test/mfc40u.dll"), 1, 0x409, 1252, "MFCDLL Shared Library - Retail Version"},
+		{getAbsoluteFilePath("test/PSCRIPT5.DLL"), 22, 0x411, 1200, "PostScript プリンター ドライバー"},
+		{getAbsoluteFilePath("test/PSCRIPT5.DLL"), 22, 0x419, 1200, "Драйвер принтера PostScript"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			translations, err := file.ParseVersionTranslations()
+			if err != nil {
+				t.Fatalf("ParseVersionTranslations(%s) failed, reason: %v", tt.in, err)
+			}
+			if len(translations) != tt.translationsCount {
+				t.Fatalf("translations count assertion failed, got %v, want %v",
+					len(translations), tt.translationsCount)
+			}
+
+			found := false
+			for _, translation := range translations {
+				if translation.LangID != tt.langID {
+					continue
+				}
+				found = true
+				if translation.CodePage != tt.codePage {
+					t.Errorf("code page assertion failed, got %v, want %v",
+						translation.CodePage, tt.codePage)
+				}
+				got := translation.Strings["FileDescription"]
+				if got != tt.fileDescription {
+					t.Errorf("FileDescription assertion failed, got %q, want %q",
+						got, tt.fileDescription)
+				}
+			}
+			if !found {
+				t.Errorf("translation for language 0x%x not found", tt.langID)
+			}
+		})
+	}
+}
+
+func TestParseVersionTranslationsEmptyDirectory(t *testing.T) {
+	file := File{opts: &Options{}}
+	file.Resources.Entries = []ResourceDirectoryEntry{{ID: VersionResourceType}}
+
+	translations, err := file.ParseVersionTranslations()
+	if len(translations) != 0 || err != nil {
+		t.Errorf("ParseVersionTranslations() got (%v, %v), want no translation",
+			translations, err)
+	}
+	vers, err := file.ParseVersionResourcesForEntries()
+	if len(vers) != 0 || err != nil {
+		t.Errorf("ParseVersionResourcesForEntries() got (%v, %v), want no version",
+			vers, err)
+	}
+}
+
+func TestDecodeCodePageString(t *testing.T) {
+	tests := []struct {
+		in       []byte
+		codePage uint16
+		out      string
+	}{
+		{[]byte{0xa9, 0x20, 0x41, 0x00, 0x42}, 1252, "© A"},
+		{[]byte{0xc4, 0xf0, 0xe0, 0xe9, 0xe2, 0xe5, 0xf0}, 1251, "Драйвер"},
+		{[]byte{0xc2, 0xa9, 0x00}, 65001, "©"},
+		{[]byte{0x83, 0x76, 0x83, 0x8a}, 932, "プリ"},
+		{[]byte{0xa9}, 0xffff, "©"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			got, err := decodeCodePageString(tt.in, tt.codePage)
+			if err != nil {
+				t.Fatalf("decodeCodePageString(%v) failed, reason: %v", tt.in, err)
+			}
+			if got != tt.out {
+				t.Errorf("decodeCodePageString(%v, %d) got %q, want %q",
+					tt.in, tt.codePage, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestPrimaryVersionTranslation(t *testing.T) {
+	tests := []struct {
+		in       string
+		langs    []uint16
+		langID   uint16
+		codePage uint16
+	}{
+		{getAbsoluteFilePath("test/putty.exe"), nil, 0x809, 1200},
+		{getAbsoluteFilePath("test/PSCRIPT5.DLL"), nil, 0x409, 1200},
+		{getAbsoluteFilePath("test/PSCRIPT5.DLL"), []uint16{0x411}, 0x411, 1200},
+		{getAbsoluteFilePath("test/PSCRIPT5.DLL"), []uint16{0x80c}, 0x40c, 1200},
+		{getAbsoluteFilePath("test/PSCRIPT5.DLL"), []uint16{0x42, 0x419}, 0x419, 1200},
+		{getAbsoluteFilePath("test/mscorlib.dll"), []uint16{0x40c}, 0, 1200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			file, err := New(tt.in, &Options{})
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			translation, err := file.PrimaryVersionTranslation(tt.langs...)
+			if err != nil {
+				t.Fatalf("PrimaryVersionTranslation(%s) failed, reason: %v",
+					tt.in, err)
+			}
+			if translation.LangID != tt.langID || translation.CodePage != tt.codePage {
+				t.Errorf("primary translation assertion failed, got %x/%d, want %x/%d",
+					translation.LangID, translation.CodePage, tt.langID, tt.codePage)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WalkFunc is called by Walk for every parsed structure and field. The path
+// names the value the way it is found in the JSON encoding of the File, for
+// instance `nt_header.file_header.machine` or `sections[0].header.name`.
+// Returning false skips the fields or elements of v.
+type WalkFunc func(path string, v interface{}) bool
+
+// Walk traverses the parsed structures of the file, depth first, in the order
+// of the JSON encoding: fields are named after their JSON name, fields not
+// encoded in JSON are skipped and embedded structures are flattened. Slices
+// and arrays elements are indexed, map entries are visited in the order of
+// their keys. Byte slices and arrays are visited as a whole. The file must be
+// parsed before calling Walk.
+func (pe *File) Walk(visitor WalkFunc) {
+	w := walker{visitor: visitor, visiting: make(map[uintptr]bool)}
+	w.walkFields("", reflect.ValueOf(pe).Elem())
+}
+
+// walker holds the state of a traversal. The pointers being visited are
+// tracked to not loop forever on cyclic structures.
+type walker struct {
+	visitor  WalkFunc
+	visiting map[uintptr]bool
+}
+
+var byteType = reflect.TypeOf(byte(0))
+
+func (w *walker) walk(path string, v reflect.Value) {
+	// Values held by interfaces and pointers are visited under the same path.
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			w.visitor(path, nil)
+			return
+		}
+		if v.Kind() == reflect.Ptr {
+			if w.visiting[v.Pointer()] {
+				return
+			}
+			w.visiting[v.Pointer()] = true
+			defer delete(w.visiting, v.Pointer())
+		}
+		v = v.Elem()
+	}
+
+	if !w.visitor(path, v.Interface()) {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		w.walkFields(path, v)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem() == byteType {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			w.walk(path+"["+strconv.Itoa(i)+"]", v.Index(i))
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			if key.Kind() == reflect.String {
+				names[i] = strconv.Quote(key.String())
+			} else {
+				names[i] = fmt.Sprint(key.Interface())
+			}
+		}
+		sort.Sort(mapKeys{keys, names})
+		for i, key := range keys {
+			w.walk(path+"["+names[i]+"]", v.MapIndex(key))
+		}
+	}
+}
+
+// walkFields visits the exported fields of a structure.
+func (w *walker) walkFields(path string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+
+		// Embedded structures without a JSON name are flattened.
+		fv := v.Field(i)
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			if fv.Kind() == reflect.Struct {
+				w.walkFields(path, fv)
+			}
+			continue
+		}
+		if field.PkgPath != "" || !fv.CanInterface() {
+			continue
+		}
+
+		if path != "" {
+			name = path + "." + name
+		}
+		w.walk(name, fv)
+	}
+}
+
+// mapKeys sorts the keys of a map by their names.
+type mapKeys struct {
+	keys  []reflect.Value
+	names []string
+}
+
+func (m mapKeys) Len() int           { return len(m.keys) }
+func (m mapKeys) Less(i, j int) bool { return m.names[i] < m.names[j] }
+func (m mapKeys) Swap(i, j int) {
+	m.keys[i], m.keys[j] = m.keys[j], m.keys[i]
+	m.names[i], m.names[j] = m.names[j], m.names[i]
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		out  map[string]interface{}
+		skip string
+	}{
+		{
+			in: getAbsoluteFilePath("test/kernel32.dll"),
+			out: map[string]interface{}{
+				"dos_header.magic":                uint16(ImageDOSSignature),
+				"nt_header.file_header.machine":   ImageFileHeaderMachineType(ImageFileMachineAMD64),
+				"nt_header.optional_header.magic": uint16(0x20b),
+				"Is64":                            true,
+			},
+			skip: "sections",
+		},
+		{
+			in: getAbsoluteFilePath("test/putty.exe"),
+			out: map[string]interface{}{
+				"dos_header.magic":              uint16(ImageDOSSignature),
+				"nt_header.file_header.machine": ImageFileHeaderMachineType(ImageFileMachineAMD64),
+				"sections[0].header.name":       [8]uint8{'.', 't', 'e', 'x', 't'},
+				"load_config.chpe":              nil,
+				"Is32":                          false,
+			},
+			skip: "imports",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			ops := Options{Fast: false}
+			file, err := New(tt.in, &ops)
+			if err != nil {
+				t.Fatalf("New(%s) failed, reason: %v", tt.in, err)
+			}
+			err = file.Parse()
+			if err != nil {
+				t.Fatalf("Parse(%s) failed, reason: %v", tt.in, err)
+			}
+
+			got := make(map[string]interface{})
+			file.Walk(func(path string, v interface{}) bool {
+				if _, ok := got[path]; ok {
+					t.Errorf("Walk(%s) visited %s twice", tt.in, path)
+				}
+				got[path] = v
+				return path != tt.skip
+			})
+
+			for path, want := range tt.out {
+				v, ok := got[path]
+				if !ok {
+					t.Errorf("Walk(%s) did not visit %s", tt.in, path)
+					continue
+				}
+				if !reflect.DeepEqual(v, want) {
+					t.Errorf("Walk(%s) visited %s with %v, want %v",
+						tt.in, path, v, want)
+				}
+			}
+
+			if _, ok := got[tt.skip]; !ok {
+				t.Errorf("Walk(%s) did not visit %s", tt.in, tt.skip)
+			}
+			for path := range got {
+				if strings.HasPrefix(path, tt.skip+"[") ||
+					strings.HasPrefix(path, tt.skip+".") {
+					t.Errorf("Walk(%s) visited %s, want %s children skipped",
+						tt.in, path, tt.skip)
+				}
+				if strings.Contains(path, "dos_stub") &&
+					strings.HasSuffix(path, "]") {
+					t.Errorf("Walk(%s) visited byte %s", tt.in, path)
+				}
+			}
+		})
+	}
+}
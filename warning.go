@@ -0,0 +1,72 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"fmt"
+
+	"github.com/saferwall/pe/log"
+)
+
+// Warning is a diagnostic emitted while parsing a structure which is
+// malformed but does not prevent the rest of the file from being parsed.
+// Warnings are also written to the logger, they let consumers which do not
+// hook a logger get the diagnostics in a machine-readable form.
+type Warning struct {
+	// Name of the structure or data directory being parsed, for instance
+	// `RichHeader` or `Resource`.
+	Directory string `json:"directory"`
+
+	// Human readable description of the problem.
+	Message string `json:"message"`
+
+	// File offset the warning relates to, zero when unknown.
+	Offset uint32 `json:"offset"`
+}
+
+func (w Warning) String() string {
+	if w.Offset == 0 {
+		return fmt.Sprintf("%s: %s", w.Directory, w.Message)
+	}
+	return fmt.Sprintf("%s: %s at offset 0x%x", w.Directory, w.Message, w.Offset)
+}
+
+// warnf logs a warning and records it in the Warnings of the file.
+func (pe *File) warnf(directory string, offset uint32, format string,
+	args ...interface{}) {
+	pe.addWarning(log.LevelWarn, directory, offset, fmt.Sprintf(format, args...))
+}
+
+// errorf logs an error and records it in the Warnings of the file.
+func (pe *File) errorf(directory string, offset uint32, format string,
+	args ...interface{}) {
+	pe.addWarning(log.LevelError, directory, offset, fmt.Sprintf(format, args...))
+}
+
+// addWarning logs the message at the given level. Warnings are only recorded
+// while parsing, as methods such as IterImports which go through the same
+// code paths later on must not mutate the File.
+func (pe *File) addWarning(level log.Level, directory string, offset uint32,
+	msg string) {
+
+	switch level {
+	case log.LevelError:
+		pe.logger.Error(msg)
+	default:
+		pe.logger.Warn(msg)
+	}
+
+	if !pe.parsing {
+		return
+	}
+	if offset == ^uint32(0) {
+		offset = 0
+	}
+	pe.Warnings = append(pe.Warnings, Warning{
+		Directory: directory,
+		Message:   msg,
+		Offset:    offset,
+	})
+}
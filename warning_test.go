@@ -0,0 +1,68 @@
+// Copyright 2022 Saferwall. All rights reserved.
+// Use of this source code is governed by Apache v2 license
+// license that can be found in the LICENSE file.
+
+package pe
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+
+	filename := getAbsoluteFilePath("test/putty.exe")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed, reason: %v", filename, err)
+	}
+
+	file, err := NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+	if len(file.Warnings) != 0 {
+		t.Errorf("Warnings(%s) assertion failed, got %v, want none",
+			filename, file.Warnings)
+	}
+
+	// Raise the number of entries of the root resource directory of
+	// putty.exe past the allowed maximum.
+	rsrcOffset := uint32(0xcfa00)
+	binary.LittleEndian.PutUint16(data[rsrcOffset+14:], maxAllowedEntries+1)
+
+	file, err = NewBytes(data, &Options{})
+	if err != nil {
+		t.Fatalf("NewBytes(%s) failed, reason: %v", filename, err)
+	}
+	err = file.Parse()
+	if err != nil {
+		t.Fatalf("Parse(%s) failed, reason: %v", filename, err)
+	}
+
+	want := Warning{Directory: "Resource", Offset: rsrcOffset}
+	found := false
+	for _, w := range file.Warnings {
+		if w.Directory == want.Directory && w.Offset == want.Offset &&
+			w.Message != "" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Warnings(%s) assertion failed, got %v, want %v",
+			filename, file.Warnings, want)
+	}
+
+	// Warnings are not recorded once parsing is over.
+	count := len(file.Warnings)
+	file.warnf("Resource", rsrcOffset, "not recorded")
+	if len(file.Warnings) != count {
+		t.Errorf("Warnings(%s) recorded after parsing, got %v",
+			filename, file.Warnings)
+	}
+}